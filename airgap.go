@@ -0,0 +1,112 @@
+package cfx
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+)
+
+// KeyAirGapped is the ENV_VAR that turns on air-gapped mode when set to a true value.
+const KeyAirGapped EnvVar = EnvVar("AIR_GAPPED")
+
+func init() {
+	registerEnvVar(envVarInfo{Var: KeyAirGapped, Origin: OriginCFX, Default: "false", Description: "Turns on air-gapped mode, in which cfx makes no network calls."})
+}
+
+var (
+	_airGappedMu sync.RWMutex
+	_airGapped   bool
+
+	// localSecretSchemes are the secret schemes that are resolved without network access.
+	localSecretSchemes = map[string]bool{
+		"env":      true,
+		"file":     true,
+		"cred":     true,
+		"keychain": true,
+	}
+)
+
+// AirGappedError is returned when an operation that needs network access is attempted in
+// air-gapped mode.
+type AirGappedError struct {
+	// Resource describes what would have made the network call.
+	Resource string
+}
+
+// Error implements the error interface.
+func (e AirGappedError) Error() string {
+	return fmt.Sprintf("%s requires network access, which is disabled in air-gapped mode", e.Resource)
+}
+
+// AirGapped turns on air-gapped mode. In air-gapped mode no resolver makes network calls: secret
+// references using schemes that were not registered with RegisterLocalSecretResolver fail with
+// an AirGappedError, as do service registrations. Air-gapped mode is process wide and cannot
+// be turned off once enabled.
+// It can also be turned on by setting CFX_AIR_GAPPED (with the application's prefix) to true.
+func AirGapped() Option {
+	return func(o *options) {
+		EnableAirGapped()
+	}
+}
+
+// EnableAirGapped turns on air-gapped mode for the process. See AirGapped.
+func EnableAirGapped() {
+	_airGappedMu.Lock()
+	defer _airGappedMu.Unlock()
+	_airGapped = true
+}
+
+// IsAirGapped reports whether air-gapped mode is on.
+func IsAirGapped() bool {
+	_airGappedMu.RLock()
+	defer _airGappedMu.RUnlock()
+	return _airGapped
+}
+
+// RegisterLocalSecretResolver is like RegisterSecretResolver, for resolvers that never make
+// network calls. Only local resolvers can be used in air-gapped mode.
+func RegisterLocalSecretResolver(scheme string, r SecretResolver) {
+	RegisterSecretResolver(scheme, r)
+
+	secretResolversMu.Lock()
+	defer secretResolversMu.Unlock()
+	localSecretSchemes[scheme] = true
+}
+
+// checkNetworkAllowed returns an AirGappedError for resource in air-gapped mode.
+func checkNetworkAllowed(resource string) error {
+	if IsAirGapped() {
+		return AirGappedError{Resource: resource}
+	}
+	return nil
+}
+
+// checkSecretScheme fails closed for secret schemes that are not known to be local.
+func checkSecretScheme(scheme string) error {
+	if !IsAirGapped() {
+		return nil
+	}
+	secretResolversMu.RLock()
+	local := localSecretSchemes[scheme]
+	secretResolversMu.RUnlock()
+	if local {
+		return nil
+	}
+	return AirGappedError{Resource: fmt.Sprintf("secret resolver %q", scheme)}
+}
+
+// airGappedFromEnv turns on air-gapped mode when the KeyAirGapped variable is set to true.
+func airGappedFromEnv(prefix EnvKeyPrefix) error {
+	val, ok := KeyAirGapped.Lookup(prefix)
+	if !ok || val == "" {
+		return nil
+	}
+	on, err := strconv.ParseBool(val)
+	if err != nil {
+		return fmt.Errorf("env var %s is not a valid boolean: %v", KeyAirGapped.Key(prefix), err)
+	}
+	if on {
+		EnableAirGapped()
+	}
+	return nil
+}
@@ -0,0 +1,210 @@
+package cfx
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ReloadTrigger describes what caused a Container to reload its configuration.
+type ReloadTrigger string
+
+const (
+	// TriggerManual is used when a reload was requested directly through the Container API.
+	TriggerManual ReloadTrigger = "manual"
+
+	// TriggerSignal is used when a reload was requested by an operating system signal.
+	TriggerSignal ReloadTrigger = "signal"
+
+	// TriggerWatch is used when a reload was caused by a change to a watched configuration source.
+	TriggerWatch ReloadTrigger = "watch"
+
+	// TriggerStartup is used for events emitted while the Container is first loaded.
+	TriggerStartup ReloadTrigger = "startup"
+)
+
+const _redactedValue = "[REDACTED]"
+
+// Redactor is used to scrub sensitive values before they leave the process.
+// It receives the dotted key path and the value, and returns the value that should be emitted.
+// Elements of lists are passed one by one, with their index in the key, as in
+// "kafka.brokers[0].sasl_password".
+type Redactor func(key string, value interface{}) interface{}
+
+var _sensitiveKeyParts = []string{
+	"password",
+	"passwd",
+	"secret",
+	"token",
+	"apikey",
+	"api_key",
+	"private_key",
+	"credential",
+}
+
+// DefaultRedactor redacts any value whose key path contains a commonly sensitive word
+// such as "password", "secret" or "token".
+func DefaultRedactor(key string, value interface{}) interface{} {
+	if value == nil {
+		return nil
+	}
+	if isSensitiveKey(key) {
+		return _redactedValue
+	}
+	return value
+}
+
+func isSensitiveKey(key string) bool {
+	lk := strings.ToLower(key)
+	for _, part := range _sensitiveKeyParts {
+		if strings.Contains(lk, part) {
+			return true
+		}
+	}
+	return false
+}
+
+// AuditRecord is the structured record emitted every time a Container reloads.
+type AuditRecord struct {
+	// Timestamp is when the reload completed.
+	Timestamp time.Time `json:"timestamp" yaml:"timestamp"`
+
+	// Trigger is what caused the reload.
+	Trigger ReloadTrigger `json:"trigger" yaml:"trigger"`
+
+	// Environment is the environment the Container was loaded for.
+	Environment EnvID `json:"environment,omitempty" yaml:"environment,omitempty"`
+
+	// OldFingerprint is the fingerprint of the configuration before the reload.
+	OldFingerprint string `json:"old_fingerprint" yaml:"old_fingerprint"`
+
+	// NewFingerprint is the fingerprint of the configuration after the reload.
+	NewFingerprint string `json:"new_fingerprint" yaml:"new_fingerprint"`
+
+	// Changes holds every leaf key that changed, with values passed through the Redactor.
+	Changes []Change `json:"changes" yaml:"changes"`
+}
+
+// AuditSink receives audit records when configuration changes at runtime.
+type AuditSink interface {
+	WriteAudit(rec AuditRecord) error
+}
+
+// AuditSinkFunc is an adapter to allow the use of ordinary functions as an AuditSink.
+type AuditSinkFunc func(rec AuditRecord) error
+
+// WriteAudit implements the AuditSink interface.
+func (f AuditSinkFunc) WriteAudit(rec AuditRecord) error {
+	return f(rec)
+}
+
+func newAuditRecord(env EnvContext, trigger ReloadTrigger, oldSnap, newSnap *snapshot, redact Redactor) AuditRecord {
+	// values are redacted as trees, since a changed list can hold secrets
+	changes := diffTrees(oldSnap.tree, newSnap.tree)
+	for i := range changes {
+		changes[i].Old = redactTree(changes[i].Key, changes[i].Old, redact)
+		changes[i].New = redactTree(changes[i].Key, changes[i].New, redact)
+	}
+
+	return AuditRecord{
+		Timestamp:      newSnap.loadedAt,
+		Trigger:        trigger,
+		Environment:    env.Environment,
+		OldFingerprint: oldSnap.fingerprint,
+		NewFingerprint: newSnap.fingerprint,
+		Changes:        changes,
+	}
+}
+
+type fileAuditSink struct {
+	sync.Mutex
+
+	path string
+}
+
+// NewFileAuditSink creates an AuditSink that appends each record as a line of JSON
+// to the file at path. The file is created if it does not exist.
+func NewFileAuditSink(path string) (AuditSink, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("could not open audit log %s: %v", path, err)
+	}
+	if err := f.Close(); err != nil {
+		return nil, fmt.Errorf("could not close audit log %s: %v", path, err)
+	}
+
+	return &fileAuditSink{path: path}, nil
+}
+
+// WriteAudit implements the AuditSink interface.
+func (s *fileAuditSink) WriteAudit(rec AuditRecord) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return fmt.Errorf("could not encode audit record: %v", err)
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	f, err := os.OpenFile(s.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("could not open audit log %s: %v", s.path, err)
+	}
+	defer f.Close()
+
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("could not write audit log %s: %v", s.path, err)
+	}
+
+	return f.Sync()
+}
+
+// NewLoggerAuditSink creates an AuditSink that writes each record as JSON to the provided logger.
+// If l is nil, the standard library's default logger is used.
+func NewLoggerAuditSink(l *log.Logger) AuditSink {
+	return AuditSinkFunc(func(rec AuditRecord) error {
+		data, err := json.Marshal(rec)
+		if err != nil {
+			return fmt.Errorf("could not encode audit record: %v", err)
+		}
+		if l == nil {
+			log.Printf("cfx audit: %s", data)
+			return nil
+		}
+		l.Printf("cfx audit: %s", data)
+		return nil
+	})
+}
+
+// NewHTTPAuditSink creates an AuditSink that POSTs each record as JSON to the provided URL.
+// If client is nil, a client with a 10 second timeout is used.
+func NewHTTPAuditSink(url string, client *http.Client) AuditSink {
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	return AuditSinkFunc(func(rec AuditRecord) error {
+		data, err := json.Marshal(rec)
+		if err != nil {
+			return fmt.Errorf("could not encode audit record: %v", err)
+		}
+
+		resp, err := client.Post(url, "application/json", bytes.NewReader(data))
+		if err != nil {
+			return fmt.Errorf("could not deliver audit record to %s: %v", url, err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			return fmt.Errorf("audit endpoint %s returned status %d", url, resp.StatusCode)
+		}
+
+		return nil
+	})
+}
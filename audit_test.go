@@ -0,0 +1,97 @@
+package cfx
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestRedactTreeWalksLists(t *testing.T) {
+	tree := map[string]interface{}{
+		"kafka": map[string]interface{}{
+			"password": "hunter1",
+			"brokers": []interface{}{
+				map[string]interface{}{"host": "k1", "sasl_password": "hunter2"},
+				map[string]interface{}{"host": "k2", "tokens": []interface{}{"hunter3"}},
+			},
+			"topics": []interface{}{},
+		},
+	}
+	var keys []string
+	got := redactTree("", tree, func(key string, value interface{}) interface{} {
+		keys = append(keys, key)
+		return DefaultRedactor(key, value)
+	})
+
+	data, err := json.Marshal(got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{"hunter1", "hunter2", "hunter3"} {
+		if strings.Contains(string(data), secret) {
+			t.Errorf("redacted tree %s leaks %s", data, secret)
+		}
+	}
+	if !strings.Contains(string(data), `"host":"k1"`) {
+		t.Errorf("redacted tree %s lost a value that is not sensitive", data)
+	}
+	want := "kafka.brokers[0].sasl_password"
+	found := false
+	for _, k := range keys {
+		found = found || k == want
+	}
+	if !found {
+		t.Errorf("redactor saw keys %v, want %s among them", keys, want)
+	}
+}
+
+func TestAuditRecordRedactsLists(t *testing.T) {
+	dir := testDir(t)
+	writeTestConfig(t, dir, "production.yaml", "kafka:\n  password: pw-old\n  brokers:\n    - host: k1\n      sasl_password: hunter2\n")
+
+	var recs []AuditRecord
+	c, err := NewConfigWithOptions(EnvContext{ConfigPath: dir, Environment: "production"}, WithAuditSink(AuditSinkFunc(func(rec AuditRecord) error {
+		recs = append(recs, rec)
+		return nil
+	})))
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestConfig(t, dir, "production.yaml", "kafka:\n  password: pw-new\n  brokers:\n    - host: k2\n      sasl_password: hunter3\n")
+	if err := c.Reload(TriggerManual); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(recs) != 1 {
+		t.Fatalf("got %d audit records, want 1", len(recs))
+	}
+	data, err := json.Marshal(recs[0].Changes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{"hunter2", "hunter3", "pw-old", "pw-new"} {
+		if strings.Contains(string(data), secret) {
+			t.Errorf("audit changes %s leak %s", data, secret)
+		}
+	}
+	if !strings.Contains(string(data), "k2") {
+		t.Errorf("audit changes %s lost the broker host", data)
+	}
+}
+
+func TestConfigServerRedactsSecretsInLists(t *testing.T) {
+	dir := testDir(t)
+	writeTestConfig(t, dir, "production.yaml", "kafka:\n  password: hunter1\n  brokers:\n    - host: k1\n      sasl_password: hunter2\n")
+
+	s := NewConfigServer(EnvContext{ConfigPath: dir})
+	defer s.Close()
+	resp, err := s.Fetch(context.Background(), &ConfigRequest{Environment: "production"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	broker := resp.Config["kafka"].(map[string]interface{})["brokers"].([]interface{})[0].(map[string]interface{})
+	if broker["sasl_password"] != _redactedValue || broker["host"] != "k1" {
+		t.Errorf("served broker = %v, want the password redacted", broker)
+	}
+}
@@ -0,0 +1,84 @@
+package cfx
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+	"text/tabwriter"
+)
+
+// Banner writes a human readable summary of the environment and the loaded configuration
+// to w. It is intended to be printed once at boot so every service reports the same facts
+// in the same layout. Values are passed through the Container's Redactor, and credentials
+// embedded in source locations are removed.
+func Banner(w io.Writer, env EnvContext, c Container) error {
+	redact := Redactor(DefaultRedactor)
+	var sources []string
+	if y, ok := c.(*yamlContainer); ok {
+		redact = y.opts.redactor
+		if snap := y.currentSnapshot(); snap != nil {
+			sources = snap.sources
+		}
+	}
+
+	rows := [][2]string{
+		{"environment", env.Environment.String()},
+		{"app id", env.Deployment.AppID},
+		{"service id", env.Deployment.ServiceID},
+		{"instance", env.Deployment.InstanceID},
+		{"region", env.Deployment.Region},
+		{"zone", env.Deployment.AvailabilityZone},
+		{"host", env.Host.Hostname},
+		{"config path", env.ConfigPath},
+	}
+
+	for i, src := range sources {
+		label := ""
+		if i == 0 {
+			label = "config sources"
+		}
+		rows = append(rows, [2]string{label, redactLocation(src)})
+	}
+
+	if c != nil {
+		rows = append(rows, [2]string{"fingerprint", c.Fingerprint()})
+	}
+	rows = append(rows,
+		[2]string{"go", fmt.Sprintf("%s %s/%s", env.Go.Version, env.Go.OS, env.Go.Arch)},
+		[2]string{"cfx", Version},
+	)
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "---- configuration ----")
+	for _, row := range rows {
+		val := row[1]
+		if v, ok := redact(strings.Replace(row[0], " ", "_", -1), val).(string); ok {
+			val = v
+		}
+		if val == "" {
+			val = "-"
+		}
+		label := row[0]
+		if label != "" {
+			label += ":"
+		}
+		fmt.Fprintf(tw, "%s\t%s\n", label, val)
+	}
+	fmt.Fprintln(tw, "-----------------------")
+
+	return tw.Flush()
+}
+
+// redactLocation strips user credentials from URL shaped source locations.
+func redactLocation(loc string) string {
+	if !strings.Contains(loc, "://") {
+		return loc
+	}
+	u, err := url.Parse(loc)
+	if err != nil || u.User == nil {
+		return loc
+	}
+	u.User = url.User("REDACTED")
+	return u.String()
+}
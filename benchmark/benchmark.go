@@ -0,0 +1,248 @@
+// Package benchmark holds benchmarks of cfx that applications can run against their own
+// build, and that cfx uses to catch performance regressions. Call the benchmarks from a test
+// file:
+//
+//	func BenchmarkLoadLazy(b *testing.B) { benchmark.LoadLazy(b, benchmark.Large) }
+//
+// or run the whole suite, compared against a recorded baseline, with cfxctl bench.
+package benchmark
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gen0cide/cfx"
+)
+
+// Size is the size of a generated configuration tree.
+type Size struct {
+	// Name identifies the size in results.
+	Name string
+
+	// Sections is the number of top level sections.
+	Sections int
+
+	// Keys is the number of keys in every section.
+	Keys int
+
+	// Rules is the number of entries in the large "rules" section.
+	Rules int
+}
+
+var (
+	// Small is a typical service configuration.
+	Small = Size{Name: "small", Sections: 10, Keys: 10, Rules: 10}
+
+	// Medium is a large service configuration.
+	Medium = Size{Name: "medium", Sections: 50, Keys: 20, Rules: 1000}
+
+	// Large is a multi-megabyte configuration dominated by a rules section, as used by rules
+	// engines.
+	Large = Size{Name: "large", Sections: 100, Keys: 50, Rules: 20000}
+)
+
+// Environment is the environment fixtures are generated for.
+const Environment cfx.EnvID = "production"
+
+// Fixture generates a base and an environment configuration file of the given size.
+func Fixture(size Size) (base, env []byte) {
+	var b bytes.Buffer
+	for s := 0; s < size.Sections; s++ {
+		fmt.Fprintf(&b, "section%d:\n", s)
+		for k := 0; k < size.Keys; k++ {
+			fmt.Fprintf(&b, "  key%d: value-%d-%d\n", k, s, k)
+		}
+	}
+	b.WriteString("rules:\n")
+	for r := 0; r < size.Rules; r++ {
+		fmt.Fprintf(&b, "  - name: rule-%d\n    match: \"path == '/api/%d'\"\n    action: allow\n    weight: %d\n", r, r, r%100)
+	}
+
+	var e bytes.Buffer
+	for s := 0; s < size.Sections; s += 10 {
+		fmt.Fprintf(&e, "section%d:\n  key0: override-%d\n", s, s)
+	}
+	return b.Bytes(), e.Bytes()
+}
+
+// WriteFixture writes the fixture of the given size to dir, gzip compressing it if compress is
+// set, and returns the EnvContext to load it with.
+func WriteFixture(dir string, size Size, compress bool) (cfx.EnvContext, error) {
+	base, env := Fixture(size)
+	files := map[string][]byte{
+		"base.yaml":                    base,
+		Environment.String() + ".yaml": env,
+	}
+
+	for name, data := range files {
+		if compress {
+			var buf bytes.Buffer
+			w := gzip.NewWriter(&buf)
+			if _, err := w.Write(data); err != nil {
+				return cfx.EnvContext{}, err
+			}
+			if err := w.Close(); err != nil {
+				return cfx.EnvContext{}, err
+			}
+			name, data = name+".gz", buf.Bytes()
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, name), data, 0644); err != nil {
+			return cfx.EnvContext{}, err
+		}
+	}
+
+	return cfx.EnvContext{Environment: Environment, ConfigPath: dir}, nil
+}
+
+// withFixture runs fn with the fixture of the given size written to a temporary directory.
+func withFixture(b *testing.B, size Size, compress bool, fn func(env cfx.EnvContext)) {
+	dir, err := ioutil.TempDir("", "cfx-benchmark")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	env, err := WriteFixture(dir, size, compress)
+	if err != nil {
+		b.Fatal(err)
+	}
+	fn(env)
+}
+
+// load benchmarks creating a Container and reading one small section from it.
+func load(b *testing.B, size Size, compress bool, opts ...cfx.Option) {
+	withFixture(b, size, compress, func(env cfx.EnvContext) {
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			c, err := cfx.NewConfigWithOptions(env, opts...)
+			if err != nil {
+				b.Fatal(err)
+			}
+			var v map[string]string
+			if err := c.Populate("section0", &v); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+// LoadEager benchmarks loading a configuration and reading one section.
+func LoadEager(b *testing.B, size Size) {
+	load(b, size, false)
+}
+
+// LoadLazy benchmarks loading a configuration with its rules section parsed lazily, and
+// reading a different section, so the rules are never parsed.
+func LoadLazy(b *testing.B, size Size) {
+	load(b, size, false, cfx.WithLazySections("rules"))
+}
+
+// LoadGzip benchmarks loading a gzip compressed configuration and reading one section.
+func LoadGzip(b *testing.B, size Size) {
+	load(b, size, true)
+}
+
+// NewConfig benchmarks creating a Container: locating, reading, expanding and merging the
+// configuration files.
+func NewConfig(b *testing.B, size Size) {
+	withFixture(b, size, false, func(env cfx.EnvContext) {
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if _, err := cfx.NewConfigWithOptions(env); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+// Merge benchmarks reloading a Container, which re-reads and merges every layer and diffs the
+// result against the previous configuration.
+func Merge(b *testing.B, size Size) {
+	withFixture(b, size, false, func(env cfx.EnvContext) {
+		c, err := cfx.NewConfigWithOptions(env)
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if err := c.Reload(cfx.TriggerManual); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+// Section is the struct sections of generated fixtures are populated into.
+type Section struct {
+	Key0 string `yaml:"key0"`
+	Key1 string `yaml:"key1"`
+	Key2 string `yaml:"key2"`
+	Key3 string `yaml:"key3"`
+	Key4 string `yaml:"key4"`
+	Key5 string `yaml:"key5"`
+	Key6 string `yaml:"key6"`
+	Key7 string `yaml:"key7"`
+	Key8 string `yaml:"key8"`
+	Key9 string `yaml:"key9"`
+}
+
+// Populate benchmarks populating a section into a struct, the typical read on a hot path.
+func Populate(b *testing.B, size Size) {
+	withFixture(b, size, false, func(env cfx.EnvContext) {
+		c, err := cfx.NewConfigWithOptions(env)
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			var s Section
+			if err := c.Populate("section0", &s); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+// PopulateKey benchmarks populating a single scalar.
+func PopulateKey(b *testing.B, size Size) {
+	withFixture(b, size, false, func(env cfx.EnvContext) {
+		c, err := cfx.NewConfigWithOptions(env)
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			var s string
+			if err := c.Populate("section0.key1", &s); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+// ReadFast benchmarks reading a single scalar with StringFast, which should not allocate.
+func ReadFast(b *testing.B, size Size) {
+	withFixture(b, size, false, func(env cfx.EnvContext) {
+		c, err := cfx.NewConfigWithOptions(env)
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if _, ok := c.StringFast("section0.key1"); !ok {
+				b.Fatal("section0.key1 is not set")
+			}
+		}
+	})
+}
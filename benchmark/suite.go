@@ -0,0 +1,172 @@
+package benchmark
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"testing"
+)
+
+// Case is a benchmark run at one size.
+type Case struct {
+	Name string
+	Size Size
+	Fn   func(b *testing.B, size Size)
+}
+
+// Suite returns every benchmark at each of the given sizes, or at Small, Medium and Large if
+// none are given.
+func Suite(sizes ...Size) []Case {
+	if len(sizes) == 0 {
+		sizes = []Size{Small, Medium, Large}
+	}
+
+	benchmarks := []struct {
+		name string
+		fn   func(*testing.B, Size)
+	}{
+		{"NewConfig", NewConfig},
+		{"Merge", Merge},
+		{"Populate", Populate},
+		{"PopulateKey", PopulateKey},
+		{"ReadFast", ReadFast},
+		{"LoadEager", LoadEager},
+		{"LoadLazy", LoadLazy},
+		{"LoadGzip", LoadGzip},
+	}
+
+	var ret []Case
+	for _, bm := range benchmarks {
+		for _, size := range sizes {
+			ret = append(ret, Case{Name: bm.name + "/" + size.Name, Size: size, Fn: bm.fn})
+		}
+	}
+	return ret
+}
+
+// Result is the outcome of running a Case.
+type Result struct {
+	Name        string `json:"name"`
+	N           int    `json:"n"`
+	NsPerOp     int64  `json:"ns_per_op"`
+	AllocsPerOp int64  `json:"allocs_per_op"`
+	BytesPerOp  int64  `json:"bytes_per_op"`
+}
+
+// String formats the result like go test -bench -benchmem.
+func (r Result) String() string {
+	return fmt.Sprintf("%-24s %10d %12d ns/op %12d B/op %10d allocs/op", r.Name, r.N, r.NsPerOp, r.BytesPerOp, r.AllocsPerOp)
+}
+
+// Run runs the cases in order.
+func Run(cases []Case) []Result {
+	ret := make([]Result, 0, len(cases))
+	for _, c := range cases {
+		c := c
+		r := testing.Benchmark(func(b *testing.B) { c.Fn(b, c.Size) })
+		ret = append(ret, Result{
+			Name:        c.Name,
+			N:           r.N,
+			NsPerOp:     r.NsPerOp(),
+			AllocsPerOp: r.AllocsPerOp(),
+			BytesPerOp:  r.AllocedBytesPerOp(),
+		})
+	}
+	return ret
+}
+
+// Thresholds are the increases over a baseline that count as regressions, as fractions: 0.1
+// allows results to be up to 10% worse. A negative threshold disables the check.
+type Thresholds struct {
+	Time   float64 `json:"time"`
+	Allocs float64 `json:"allocs"`
+	Bytes  float64 `json:"bytes"`
+}
+
+// DefaultThresholds tolerate the noise of shared CI machines on time, while holding
+// allocations, which are deterministic, to a tight budget.
+var DefaultThresholds = Thresholds{Time: 0.25, Allocs: 0.05, Bytes: 0.15}
+
+// Regression is a result that exceeded its threshold.
+type Regression struct {
+	Name     string
+	Metric   string
+	Baseline int64
+	Current  int64
+}
+
+// String describes the regression.
+func (r Regression) String() string {
+	pct := 100.0
+	if r.Baseline > 0 {
+		pct = float64(r.Current-r.Baseline) / float64(r.Baseline) * 100
+	}
+	return fmt.Sprintf("%s: %s went from %d to %d (+%.1f%%)", r.Name, r.Metric, r.Baseline, r.Current, pct)
+}
+
+// Compare returns the results in current that regressed from baseline by more than the
+// thresholds. Results missing from the baseline are not compared.
+func Compare(baseline, current []Result, t Thresholds) []Regression {
+	base := make(map[string]Result, len(baseline))
+	for _, r := range baseline {
+		base[r.Name] = r
+	}
+
+	var ret []Regression
+	for _, cur := range current {
+		b, ok := base[cur.Name]
+		if !ok {
+			continue
+		}
+		for _, m := range []struct {
+			name      string
+			threshold float64
+			base, cur int64
+		}{
+			{"ns/op", t.Time, b.NsPerOp, cur.NsPerOp},
+			{"allocs/op", t.Allocs, b.AllocsPerOp, cur.AllocsPerOp},
+			{"B/op", t.Bytes, b.BytesPerOp, cur.BytesPerOp},
+		} {
+			if m.threshold < 0 {
+				continue
+			}
+			if float64(m.cur) > float64(m.base)*(1+m.threshold) {
+				ret = append(ret, Regression{Name: cur.Name, Metric: m.name, Baseline: m.base, Current: m.cur})
+			}
+		}
+	}
+
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].Name != ret[j].Name {
+			return ret[i].Name < ret[j].Name
+		}
+		return ret[i].Metric < ret[j].Metric
+	})
+	return ret
+}
+
+// ReadResults reads results written by WriteResults.
+func ReadResults(path string) ([]Result, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read benchmark results %s: %v", path, err)
+	}
+	var ret []Result
+	if err := json.Unmarshal(data, &ret); err != nil {
+		return nil, fmt.Errorf("could not decode benchmark results %s: %v", path, err)
+	}
+	return ret, nil
+}
+
+// WriteResults writes results to path as JSON, to be used as a baseline.
+func WriteResults(path string, results []Result) error {
+	data, err := json.MarshalIndent(results, "", "  ")
+	if err != nil {
+		return fmt.Errorf("could not encode benchmark results: %v", err)
+	}
+	if err := ioutil.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("could not write benchmark results %s: %v", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,523 @@
+package cfx
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// _maxBundleEntrySize bounds the size of a single file extracted from a bundle.
+const _maxBundleEntrySize = 64 << 20
+
+// BundleManifestName is the name of the manifest file written into bundles by WriteBundle.
+const BundleManifestName = "cfx-bundle.json"
+
+// Bundle is the in-memory contents of a config bundle archive.
+type Bundle struct {
+	path     string
+	digest   string
+	files    map[string][]byte
+	manifest *BundleManifest
+}
+
+// BundleManifest describes the contents of a bundle written by WriteBundle.
+type BundleManifest struct {
+	// Version is a free form version string for the bundle, e.g. a git SHA or release number.
+	Version string `json:"version,omitempty"`
+
+	// Environments lists the environments packaged in the bundle.
+	Environments []EnvID `json:"environments,omitempty"`
+
+	// Files maps every YAML file in the bundle to its sha256 hex digest.
+	Files map[string]string `json:"files"`
+}
+
+// LoadBundle reads an unsigned bundle archive from disk. If the bundle contains a manifest,
+// the digest of every file is checked against it.
+func LoadBundle(path string) (*Bundle, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read config bundle %s: %v", path, err)
+	}
+	return readBundleArchive(path, data)
+}
+
+// Path returns the location the bundle was read from.
+func (b *Bundle) Path() string {
+	return b.path
+}
+
+// Digest returns the sha256 hex digest of the bundle archive.
+func (b *Bundle) Digest() string {
+	return b.digest
+}
+
+// Manifest returns the bundle's manifest, or nil if it does not have one.
+func (b *Bundle) Manifest() *BundleManifest {
+	return b.manifest
+}
+
+// File returns the contents of the YAML file at name, a slash separated path within the
+// bundle.
+func (b *Bundle) File(name string) ([]byte, bool) {
+	data, ok := b.files[name]
+	return data, ok
+}
+
+// readBundleArchive reads a .tar, .tar.gz/.tgz or .zip archive of YAML files.
+// Only files with a YAML extension, possibly compressed, are kept, under their path within
+// the archive.
+func readBundleArchive(name string, data []byte) (*Bundle, error) {
+	sum := sha256.Sum256(data)
+	b := &Bundle{
+		path:   name,
+		digest: hex.EncodeToString(sum[:]),
+		files:  map[string][]byte{},
+	}
+
+	var err error
+	switch {
+	case bytes.HasPrefix(data, []byte("PK\x03\x04")):
+		err = b.readZip(data)
+	case bytes.HasPrefix(data, []byte{0x1f, 0x8b}):
+		var gz *gzip.Reader
+		gz, err = gzip.NewReader(bytes.NewReader(data))
+		if err == nil {
+			err = b.readTar(gz)
+			gz.Close()
+		}
+	default:
+		err = b.readTar(bytes.NewReader(data))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not read config bundle %s: %v", name, err)
+	}
+
+	if len(b.files) == 0 {
+		return nil, fmt.Errorf("config bundle %s does not contain any yaml files", name)
+	}
+
+	if err := b.verifyManifest(); err != nil {
+		return nil, fmt.Errorf("config bundle %s is inconsistent: %v", name, err)
+	}
+
+	return b, nil
+}
+
+func (b *Bundle) add(name string, r io.Reader) error {
+	name = path.Clean(strings.TrimPrefix(filepath.ToSlash(name), "./"))
+	if path.Base(name) == BundleManifestName {
+		return b.readManifest(r)
+	}
+	if !isConfigFile(name) {
+		return nil
+	}
+	if path.IsAbs(name) || name == ".." || strings.HasPrefix(name, "../") {
+		return fmt.Errorf("file %s is outside the bundle", name)
+	}
+	if _, exists := b.files[name]; exists {
+		return fmt.Errorf("duplicate file %s", name)
+	}
+
+	data, err := ioutil.ReadAll(io.LimitReader(r, _maxBundleEntrySize+1))
+	if err != nil {
+		return err
+	}
+	if len(data) > _maxBundleEntrySize {
+		return fmt.Errorf("file %s is too large", name)
+	}
+
+	b.files[name] = data
+	return nil
+}
+
+// isConfigFile reports whether name is a YAML file, possibly compressed.
+func isConfigFile(name string) bool {
+	base, _ := compressionExt(name)
+	return yamlExts[path.Ext(base)]
+}
+
+func (b *Bundle) readTar(r io.Reader) error {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		if err := b.add(hdr.Name, tr); err != nil {
+			return err
+		}
+	}
+}
+
+func (b *Bundle) readZip(data []byte) error {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return err
+	}
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return err
+		}
+		err = b.add(f.Name, rc)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *Bundle) readManifest(r io.Reader) error {
+	if b.manifest != nil {
+		return errors.New("duplicate bundle manifest")
+	}
+	m := &BundleManifest{}
+	if err := json.NewDecoder(io.LimitReader(r, _maxBundleEntrySize)).Decode(m); err != nil {
+		return fmt.Errorf("could not decode bundle manifest: %v", err)
+	}
+	b.manifest = m
+	return nil
+}
+
+func (b *Bundle) verifyManifest() error {
+	if b.manifest == nil {
+		return nil
+	}
+	for name, data := range b.files {
+		want, ok := b.manifest.Files[name]
+		if !ok {
+			return fmt.Errorf("file %s is not listed in the manifest", name)
+		}
+		sum := sha256.Sum256(data)
+		if hex.EncodeToString(sum[:]) != want {
+			return fmt.Errorf("file %s does not match its manifest digest", name)
+		}
+	}
+	for name := range b.manifest.Files {
+		if _, ok := b.files[name]; !ok {
+			return fmt.Errorf("file %s is listed in the manifest but missing", name)
+		}
+	}
+	return nil
+}
+
+// Files returns the sorted paths of the YAML files within the bundle.
+func (b *Bundle) Files() []string {
+	return b.names()
+}
+
+// names returns the sorted file names within the bundle.
+func (b *Bundle) names() []string {
+	ret := make([]string, 0, len(b.files))
+	for n := range b.files {
+		ret = append(ret, n)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// sources returns the configuration files of the bundle for env in merge order. Files are
+// selected exactly as in a config directory, with the naming strategy of opts, refinements for
+// the region and service of the deployment and compressed files, by extracting the bundle to
+// a temporary directory.
+func (b *Bundle) sources(env EnvContext, opts *options) ([]configSource, error) {
+	dir, err := ioutil.TempDir("", "cfx-bundle")
+	if err != nil {
+		return nil, fmt.Errorf("could not extract config bundle %s: %v", b.path, err)
+	}
+	defer os.RemoveAll(dir)
+
+	for name, data := range b.files {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0700); err != nil {
+			return nil, fmt.Errorf("could not extract config bundle %s: %v", b.path, err)
+		}
+		if err := ioutil.WriteFile(p, data, 0600); err != nil {
+			return nil, fmt.Errorf("could not extract config bundle %s: %v", b.path, err)
+		}
+	}
+
+	env.ConfigPath, env.ConfigPaths = dir, nil
+	paths, err := discoverConfigFiles(env, opts.naming)
+	if err == ErrConfigNotFound {
+		return nil, fmt.Errorf("config bundle %s does not contain a config for environment %s: %v", b.path, env.Environment, err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("config bundle %s: %v", b.path, strings.Replace(err.Error(), dir, b.path, -1))
+	}
+
+	ret := make([]configSource, 0, len(paths))
+	for _, p := range paths {
+		src, err := readConfigSource(p, opts.limits)
+		if err != nil {
+			return nil, err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return nil, err
+		}
+		src.name = b.path + "!" + filepath.ToSlash(rel)
+		ret = append(ret, src)
+	}
+	return ret, nil
+}
+
+// loadSignedBundle reads the bundle at bundlePath and verifies its detached signature.
+// The signature is read from sigPath, or from <bundle>.sig / <bundle>.minisig when empty.
+func loadSignedBundle(bundlePath, sigPath string, keys []BundleVerifier) (*Bundle, error) {
+	data, err := ioutil.ReadFile(bundlePath)
+	if err != nil {
+		return nil, fmt.Errorf("could not read config bundle %s: %v", bundlePath, err)
+	}
+
+	if sigPath == "" {
+		sigPath = bundlePath + ".sig"
+		if _, err := os.Stat(sigPath); err != nil {
+			sigPath = bundlePath + ".minisig"
+		}
+	}
+
+	sig, err := ioutil.ReadFile(sigPath)
+	if err != nil {
+		return nil, fmt.Errorf("could not read signature for config bundle %s: %v", bundlePath, err)
+	}
+
+	if err := verifyAny(keys, data, sig); err != nil {
+		return nil, fmt.Errorf("config bundle %s failed verification: %v", bundlePath, err)
+	}
+
+	return readBundleArchive(bundlePath, data)
+}
+
+// WithBundle loads configuration from an unsigned bundle archive instead of ConfigPath.
+// Use WithSignedBundle when the bundle comes from an untrusted location.
+func WithBundle(path string) Option {
+	return func(o *options) {
+		o.bundlePath = path
+		o.bundleSig = ""
+		o.bundleKeys = nil
+		o.bundleUnsigned = true
+	}
+}
+
+// WithSignedBundle loads configuration from a signed bundle archive instead of ConfigPath.
+// The archive's detached signature must verify against one of the trusted keys before any of
+// its contents are parsed. The signature is read from sigPath, or from "<path>.sig" or
+// "<path>.minisig" when sigPath is empty.
+func WithSignedBundle(path, sigPath string, keys ...BundleVerifier) Option {
+	return func(o *options) {
+		o.bundlePath = path
+		o.bundleSig = sigPath
+		o.bundleKeys = keys
+		o.bundleUnsigned = false
+	}
+}
+
+// WriteBundle packages the base config and the given environments from configDir into a
+// deterministic gzipped tar archive with a manifest. Every environment brings the files it
+// inherits from and its refinements for any region or service. If no environments are given,
+// every YAML file under configDir is included.
+func WriteBundle(w io.Writer, configDir string, version string, envs ...EnvID) (*BundleManifest, error) {
+	return WriteBundleWithNaming(w, configDir, DefaultNaming, version, envs...)
+}
+
+// WriteBundleWithNaming is like WriteBundle, for config directories laid out with a naming
+// strategy other than DefaultNaming. The bundle must be loaded with the same strategy.
+func WriteBundleWithNaming(w io.Writer, configDir string, naming NamingStrategy, version string, envs ...EnvID) (*BundleManifest, error) {
+	selected, err := configFilesUnder(configDir)
+	if err != nil {
+		return nil, err
+	}
+	if len(envs) > 0 {
+		selected, err = selectBundleFiles(configDir, naming, envs)
+		if err != nil {
+			return nil, err
+		}
+	}
+	sort.Strings(selected)
+
+	manifest := &BundleManifest{
+		Version:      version,
+		Environments: envs,
+		Files:        map[string]string{},
+	}
+	contents := map[string][]byte{}
+	for _, name := range selected {
+		data, err := ioutil.ReadFile(filepath.Join(configDir, filepath.FromSlash(name)))
+		if err != nil {
+			return nil, fmt.Errorf("could not read config file %s: %v", name, err)
+		}
+		sum := sha256.Sum256(data)
+		manifest.Files[name] = hex.EncodeToString(sum[:])
+		contents[name] = data
+	}
+
+	mdata, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("could not encode bundle manifest: %v", err)
+	}
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	write := func(name string, data []byte) error {
+		hdr := &tar.Header{
+			Name:     name,
+			Mode:     0644,
+			Size:     int64(len(data)),
+			Typeflag: tar.TypeReg,
+			Format:   tar.FormatPAX,
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		_, err := tw.Write(data)
+		return err
+	}
+
+	if err := write(BundleManifestName, mdata); err != nil {
+		return nil, fmt.Errorf("could not write bundle: %v", err)
+	}
+	for _, name := range selected {
+		if err := write(name, contents[name]); err != nil {
+			return nil, fmt.Errorf("could not write bundle: %v", err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, fmt.Errorf("could not write bundle: %v", err)
+	}
+	if err := gz.Close(); err != nil {
+		return nil, fmt.Errorf("could not write bundle: %v", err)
+	}
+
+	return manifest, nil
+}
+
+// configFilesUnder returns the slash separated paths of every YAML file under configDir,
+// skipping hidden directories.
+func configFilesUnder(configDir string) ([]string, error) {
+	var ret []string
+	err := filepath.Walk(configDir, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fi.IsDir() {
+			if p != configDir && strings.HasPrefix(fi.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !fi.Mode().IsRegular() || !isConfigFile(fi.Name()) {
+			return nil
+		}
+		rel, err := filepath.Rel(configDir, p)
+		if err != nil {
+			return err
+		}
+		ret = append(ret, filepath.ToSlash(rel))
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not list config directory: %v", err)
+	}
+	return ret, nil
+}
+
+// selectBundleFiles returns the slash separated paths of the files in configDir that envs
+// need: the environments manifest, base, and for every environment the files of its chain with
+// all of their region and service refinements.
+func selectBundleFiles(configDir string, naming NamingStrategy, envs []EnvID) ([]string, error) {
+	envManifest, err := readEnvironmentsManifest(configDir)
+	if err != nil {
+		return nil, err
+	}
+	names, err := naming.Names(configDir)
+	if err != nil {
+		return nil, err
+	}
+
+	picked := map[string]bool{}
+	pick := func(paths []string) error {
+		for _, p := range paths {
+			rel, err := filepath.Rel(configDir, p)
+			if err != nil {
+				return err
+			}
+			picked[filepath.ToSlash(rel)] = true
+		}
+		return nil
+	}
+
+	if p, err := resolveConfig(configDir, EnvironmentsManifestName); err == nil {
+		picked[filepath.Base(p)] = true
+	}
+	base, err := naming.Resolve(configDir, _defaultConfigName)
+	if err != nil && err != ErrConfigNotFound {
+		return nil, err
+	}
+	if err := pick(base); err != nil {
+		return nil, err
+	}
+
+	for _, env := range envs {
+		chain, err := envManifest.Chain(env.String())
+		if err != nil {
+			return nil, err
+		}
+		for _, cfg := range chain {
+			paths, err := naming.Resolve(configDir, cfg)
+			if err == ErrConfigNotFound {
+				return nil, fmt.Errorf("config directory %s does not contain a config for %s, needed by environment %s", configDir, cfg, env)
+			}
+			if err != nil {
+				return nil, err
+			}
+			if err := pick(paths); err != nil {
+				return nil, err
+			}
+
+			// refinements are named <cfg>.<region>, <cfg>.<service> or <cfg>.<region>.<service>
+			for _, n := range names {
+				if len(n) <= len(cfg)+1 || !strings.EqualFold(n[:len(cfg)+1], cfg+".") {
+					continue
+				}
+				paths, err := naming.Resolve(configDir, n)
+				if err != nil && err != ErrConfigNotFound {
+					return nil, err
+				}
+				if err := pick(paths); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+
+	ret := make([]string, 0, len(picked))
+	for name := range picked {
+		ret = append(ret, name)
+	}
+	return ret, nil
+}
@@ -0,0 +1,116 @@
+package cfx
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func gzipped(t *testing.T, data string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	if _, err := gz.Write([]byte(data)); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// writeTestBundle packages dir for envs and loads the result back.
+func writeTestBundle(t *testing.T, dir string, naming NamingStrategy, envs ...EnvID) *Bundle {
+	t.Helper()
+	var buf bytes.Buffer
+	if _, err := WriteBundleWithNaming(&buf, dir, naming, "v1", envs...); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(testDir(t), "config.tgz")
+	if err := ioutil.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	b, err := LoadBundle(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func sourceNames(b *Bundle, sources []configSource) []string {
+	ret := make([]string, len(sources))
+	for i, s := range sources {
+		ret[i] = strings.TrimPrefix(s.name, b.Path()+"!")
+	}
+	return ret
+}
+
+func TestBundleSelectsFilesLikeConfigDir(t *testing.T) {
+	dir := testDir(t)
+	writeTestConfig(t, dir, "base.yaml", "a: base\n")
+	if err := ioutil.WriteFile(filepath.Join(dir, "production.yaml.gz"), gzipped(t, "a: production\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	writeTestConfig(t, dir, "production.us-east-1.yaml", "a: region\n")
+	writeTestConfig(t, dir, "production.us-east-1.payments.yaml", "a: service\n")
+	writeTestConfig(t, dir, "staging.yaml", "a: staging\n")
+
+	b := writeTestBundle(t, dir, DefaultNaming, EnvID("production"))
+	want := []string{"base.yaml", "production.us-east-1.payments.yaml", "production.us-east-1.yaml", "production.yaml.gz"}
+	if got := b.Files(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("Files() = %v, want %v", got, want)
+	}
+
+	env := EnvContext{Environment: EnvID("production")}
+	env.Deployment.Region = "us-east-1"
+	env.Deployment.ServiceID = "payments"
+	sources, err := b.sources(env, newOptions(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = []string{"base.yaml", "production.yaml.gz", "production.us-east-1.yaml", "production.us-east-1.payments.yaml"}
+	if got := sourceNames(b, sources); !reflect.DeepEqual(got, want) {
+		t.Fatalf("sources = %v, want %v", got, want)
+	}
+	if got := string(sources[1].data); got != "a: production\n" {
+		t.Errorf("compressed source was not decompressed: %q", got)
+	}
+}
+
+func TestBundleNamingStrategy(t *testing.T) {
+	dir := testDir(t)
+	naming := PatternNaming("<env>/config.yaml")
+	for _, name := range []string{"base", "production"} {
+		if err := os.Mkdir(filepath.Join(dir, name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		writeTestConfig(t, dir, name+"/config.yaml", "a: "+name+"\n")
+	}
+
+	b := writeTestBundle(t, dir, naming, EnvID("production"))
+	sources, err := b.sources(EnvContext{Environment: EnvID("production")}, newOptions([]Option{WithNamingStrategy(naming)}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"base/config.yaml", "production/config.yaml"}
+	if got := sourceNames(b, sources); !reflect.DeepEqual(got, want) {
+		t.Fatalf("sources = %v, want %v", got, want)
+	}
+}
+
+func TestBundleMissingEnvironment(t *testing.T) {
+	dir := testDir(t)
+	writeTestConfig(t, dir, "base.yaml", "a: base\n")
+	writeTestConfig(t, dir, "staging.yaml", "a: staging\n")
+
+	b := writeTestBundle(t, dir, DefaultNaming)
+	_, err := b.sources(EnvContext{Environment: EnvID("production")}, newOptions(nil))
+	if err == nil || !strings.Contains(err.Error(), b.Path()) {
+		t.Fatalf("got %v, want an error naming the bundle", err)
+	}
+}
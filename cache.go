@@ -0,0 +1,398 @@
+package cfx
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Cache is a key-value cache used by resolvers to avoid fetching the same value from a backend
+// repeatedly, for example when a whole fleet restarts during a deploy. Implementations must be
+// safe for concurrent use.
+type Cache interface {
+	// Get returns the value stored under key, and false if it is missing or expired.
+	Get(ctx context.Context, key string) ([]byte, bool, error)
+
+	// Set stores value under key for ttl. A ttl of zero or less stores it without expiry.
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+
+	// Delete removes key from the cache.
+	Delete(ctx context.Context, key string) error
+}
+
+// CacheStats are the counters kept by the caches in this package.
+type CacheStats struct {
+	Hits        uint64 `json:"hits" yaml:"hits"`
+	Misses      uint64 `json:"misses" yaml:"misses"`
+	Sets        uint64 `json:"sets" yaml:"sets"`
+	Evictions   uint64 `json:"evictions" yaml:"evictions"`
+	Expirations uint64 `json:"expirations" yaml:"expirations"`
+	Entries     int    `json:"entries" yaml:"entries"`
+}
+
+// cacheCounters are the atomic counters behind CacheStats.
+type cacheCounters struct {
+	hits, misses, sets, evictions, expirations uint64
+}
+
+func (c *cacheCounters) stats(entries int) CacheStats {
+	return CacheStats{
+		Hits:        atomic.LoadUint64(&c.hits),
+		Misses:      atomic.LoadUint64(&c.misses),
+		Sets:        atomic.LoadUint64(&c.sets),
+		Evictions:   atomic.LoadUint64(&c.evictions),
+		Expirations: atomic.LoadUint64(&c.expirations),
+		Entries:     entries,
+	}
+}
+
+// MemoryCache is an in-memory Cache that evicts the least recently used entry once it holds
+// its maximum number of entries.
+type MemoryCache struct {
+	mu         sync.Mutex
+	maxEntries int
+	ll         *list.List
+	items      map[string]*list.Element
+	now        func() time.Time
+	counters   cacheCounters
+}
+
+type memoryCacheEntry struct {
+	key     string
+	value   []byte
+	expires time.Time
+}
+
+// NewMemoryCache creates a MemoryCache holding at most maxEntries entries. Zero means unbounded.
+func NewMemoryCache(maxEntries int) *MemoryCache {
+	return &MemoryCache{
+		maxEntries: maxEntries,
+		ll:         list.New(),
+		items:      map[string]*list.Element{},
+		now:        time.Now,
+	}
+}
+
+// Get implements the Cache interface.
+func (m *MemoryCache) Get(_ context.Context, key string) ([]byte, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	el, ok := m.items[key]
+	if !ok {
+		atomic.AddUint64(&m.counters.misses, 1)
+		return nil, false, nil
+	}
+
+	ent := el.Value.(*memoryCacheEntry)
+	if !ent.expires.IsZero() && !m.now().Before(ent.expires) {
+		m.removeElement(el)
+		atomic.AddUint64(&m.counters.expirations, 1)
+		atomic.AddUint64(&m.counters.misses, 1)
+		return nil, false, nil
+	}
+
+	m.ll.MoveToFront(el)
+	atomic.AddUint64(&m.counters.hits, 1)
+	return append([]byte{}, ent.value...), true, nil
+}
+
+// Set implements the Cache interface.
+func (m *MemoryCache) Set(_ context.Context, key string, value []byte, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	atomic.AddUint64(&m.counters.sets, 1)
+
+	var expires time.Time
+	if ttl > 0 {
+		expires = m.now().Add(ttl)
+	}
+	value = append([]byte{}, value...)
+
+	if el, ok := m.items[key]; ok {
+		ent := el.Value.(*memoryCacheEntry)
+		ent.value, ent.expires = value, expires
+		m.ll.MoveToFront(el)
+		return nil
+	}
+
+	m.items[key] = m.ll.PushFront(&memoryCacheEntry{key: key, value: value, expires: expires})
+	for m.maxEntries > 0 && m.ll.Len() > m.maxEntries {
+		m.removeElement(m.ll.Back())
+		atomic.AddUint64(&m.counters.evictions, 1)
+	}
+
+	return nil
+}
+
+// Delete implements the Cache interface.
+func (m *MemoryCache) Delete(_ context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if el, ok := m.items[key]; ok {
+		m.removeElement(el)
+	}
+	return nil
+}
+
+// Stats returns the cache's counters.
+func (m *MemoryCache) Stats() CacheStats {
+	m.mu.Lock()
+	n := m.ll.Len()
+	m.mu.Unlock()
+	return m.counters.stats(n)
+}
+
+func (m *MemoryCache) removeElement(el *list.Element) {
+	m.ll.Remove(el)
+	delete(m.items, el.Value.(*memoryCacheEntry).key)
+}
+
+// DiskCacheConfig configures a DiskCache.
+type DiskCacheConfig struct {
+	// Dir is the directory holding the entries. It is created if needed.
+	Dir string `json:"dir" yaml:"dir" mapstructure:"dir"`
+
+	// MaxEntries bounds the number of entries. Zero means unbounded.
+	MaxEntries int `json:"max_entries,omitempty" yaml:"max_entries,omitempty" mapstructure:"max_entries,omitempty"`
+
+	// MaxBytes bounds the total size of the entries. Zero means unbounded.
+	MaxBytes int64 `json:"max_bytes,omitempty" yaml:"max_bytes,omitempty" mapstructure:"max_bytes,omitempty"`
+
+	// FileLock configures the lock taken on the directory while entries are written, deleted
+	// or evicted, so that several processes can share it.
+	FileLock FileLockConfig `json:"file_lock,omitempty" yaml:"file_lock,omitempty" mapstructure:"file_lock,omitempty"`
+}
+
+// DiskCache is a Cache that stores every entry in its own file within a directory, so cached
+// values survive restarts. Files are written with 0600 permissions, but values are stored in
+// the clear: only use it for secrets on hosts where that is acceptable. Once the cache is over
+// its bounds, expired entries are removed first and then the least recently used ones.
+type DiskCache struct {
+	cfg      DiskCacheConfig
+	now      func() time.Time
+	counters cacheCounters
+}
+
+// NewDiskCache creates an unbounded DiskCache in dir, creating the directory if needed.
+func NewDiskCache(dir string) (*DiskCache, error) {
+	return NewDiskCacheWithConfig(DiskCacheConfig{Dir: dir})
+}
+
+// NewDiskCacheWithConfig creates a DiskCache from cfg, creating the directory if needed.
+func NewDiskCacheWithConfig(cfg DiskCacheConfig) (*DiskCache, error) {
+	if cfg.Dir == "" {
+		return nil, errors.New("disk cache needs a directory")
+	}
+	if cfg.MaxEntries < 0 || cfg.MaxBytes < 0 {
+		return nil, errors.New("disk cache bounds cannot be negative")
+	}
+	if err := os.MkdirAll(cfg.Dir, 0700); err != nil {
+		return nil, fmt.Errorf("could not create cache directory %s: %v", cfg.Dir, err)
+	}
+	return &DiskCache{cfg: cfg, now: time.Now}, nil
+}
+
+func (d *DiskCache) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(d.cfg.Dir, hex.EncodeToString(sum[:]))
+}
+
+// lock takes the lock on the cache directory.
+func (d *DiskCache) lock(ctx context.Context) (*FileLock, error) {
+	return LockFile(ctx, filepath.Join(d.cfg.Dir, ".lock"), d.cfg.FileLock)
+}
+
+// Get implements the Cache interface.
+func (d *DiskCache) Get(_ context.Context, key string) ([]byte, bool, error) {
+	p := d.path(key)
+	data, err := ioutil.ReadFile(p)
+	if os.IsNotExist(err) {
+		atomic.AddUint64(&d.counters.misses, 1)
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("could not read cache entry: %v", err)
+	}
+	if len(data) < 8 {
+		atomic.AddUint64(&d.counters.misses, 1)
+		return nil, false, nil
+	}
+
+	now := d.now()
+	if diskEntryExpired(data, now) {
+		os.Remove(p)
+		atomic.AddUint64(&d.counters.expirations, 1)
+		atomic.AddUint64(&d.counters.misses, 1)
+		return nil, false, nil
+	}
+
+	// the modification time orders entries for eviction
+	if d.bounded() {
+		os.Chtimes(p, now, now)
+	}
+	atomic.AddUint64(&d.counters.hits, 1)
+	return data[8:], true, nil
+}
+
+// diskEntryExpired reports whether an entry has expired. Entries are prefixed with their expiry
+// in unix nanoseconds, zero for none.
+func diskEntryExpired(data []byte, now time.Time) bool {
+	exp := int64(binary.BigEndian.Uint64(data[:8]))
+	return exp != 0 && now.UnixNano() >= exp
+}
+
+// Set implements the Cache interface.
+func (d *DiskCache) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	atomic.AddUint64(&d.counters.sets, 1)
+
+	data := make([]byte, 8+len(value))
+	if ttl > 0 {
+		binary.BigEndian.PutUint64(data[:8], uint64(d.now().Add(ttl).UnixNano()))
+	}
+	copy(data[8:], value)
+
+	lock, err := d.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
+	if err := writeFileAtomic(d.path(key), data, 0600); err != nil {
+		return err
+	}
+	if d.bounded() {
+		return d.evict()
+	}
+	return nil
+}
+
+// Delete implements the Cache interface.
+func (d *DiskCache) Delete(ctx context.Context, key string) error {
+	lock, err := d.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
+	if err := os.Remove(d.path(key)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("could not delete cache entry: %v", err)
+	}
+	return nil
+}
+
+// Stats returns the cache's counters.
+func (d *DiskCache) Stats() CacheStats {
+	entries, _ := d.entries()
+	return d.counters.stats(len(entries))
+}
+
+func (d *DiskCache) bounded() bool {
+	return d.cfg.MaxEntries > 0 || d.cfg.MaxBytes > 0
+}
+
+// entries lists the entry files of the cache, least recently used first.
+func (d *DiskCache) entries() ([]os.FileInfo, error) {
+	files, err := ioutil.ReadDir(d.cfg.Dir)
+	if err != nil {
+		return nil, fmt.Errorf("could not list cache directory %s: %v", d.cfg.Dir, err)
+	}
+	ret := files[:0]
+	for _, f := range files {
+		if f.Mode().IsRegular() && len(f.Name()) == sha256.Size*2 {
+			ret = append(ret, f)
+		}
+	}
+	sort.SliceStable(ret, func(i, j int) bool {
+		return ret[i].ModTime().Before(ret[j].ModTime())
+	})
+	return ret, nil
+}
+
+// evict removes entries until the cache is within its bounds: expired entries first, then
+// the least recently used ones. The directory lock must be held.
+func (d *DiskCache) evict() error {
+	files, err := d.entries()
+	if err != nil {
+		return err
+	}
+	var size int64
+	for _, f := range files {
+		size += f.Size()
+	}
+	over := func(n int) bool {
+		return (d.cfg.MaxEntries > 0 && n > d.cfg.MaxEntries) || (d.cfg.MaxBytes > 0 && size > d.cfg.MaxBytes)
+	}
+	if !over(len(files)) {
+		return nil
+	}
+
+	now := d.now()
+	live := files[:0]
+	for _, f := range files {
+		if d.expiredFile(f.Name(), now) {
+			if err := os.Remove(filepath.Join(d.cfg.Dir, f.Name())); err == nil {
+				size -= f.Size()
+				atomic.AddUint64(&d.counters.expirations, 1)
+				continue
+			}
+		}
+		live = append(live, f)
+	}
+
+	n := len(live)
+	for _, f := range live {
+		if !over(n) {
+			break
+		}
+		if err := os.Remove(filepath.Join(d.cfg.Dir, f.Name())); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("could not evict cache entry: %v", err)
+		}
+		n--
+		size -= f.Size()
+		atomic.AddUint64(&d.counters.evictions, 1)
+	}
+	return nil
+}
+
+// expiredFile reads the expiry of the entry in name.
+func (d *DiskCache) expiredFile(name string, now time.Time) bool {
+	f, err := os.Open(filepath.Join(d.cfg.Dir, name))
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	var hdr [8]byte
+	if _, err := io.ReadFull(f, hdr[:]); err != nil {
+		return false
+	}
+	return diskEntryExpired(hdr[:], now)
+}
+
+// WithRemoteCache caches what the Container fetches from remote configuration sources in c for
+// ttl: the configuration returned by a config server, and the content of failover layers that
+// have no Cache of their own. A shared cache, such as a RedisCache, keeps a whole fleet
+// restarting at once from hammering those stores. Cache errors are logged and the source is
+// fetched as if the cache were missing. A ttl of zero or less uses one minute.
+func WithRemoteCache(c Cache, ttl time.Duration) Option {
+	return func(o *options) {
+		o.remoteCache = c
+		o.remoteCacheTTL = ttl
+	}
+}
+
+// _defaultRemoteCacheTTL is how long remote configuration is cached when no TTL is given.
+const _defaultRemoteCacheTTL = time.Minute
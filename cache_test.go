@@ -0,0 +1,440 @@
+package cfx
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDiskCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	ctx := context.Background()
+	d, err := NewDiskCacheWithConfig(DiskCacheConfig{Dir: testDir(t), MaxEntries: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, k := range []string{"a", "b"} {
+		if err := d.Set(ctx, k, []byte(k), 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	now := time.Now()
+	os.Chtimes(d.path("a"), now.Add(-2*time.Hour), now.Add(-2*time.Hour))
+	os.Chtimes(d.path("b"), now.Add(-time.Hour), now.Add(-time.Hour))
+
+	// reading a makes b the least recently used entry
+	if _, ok, err := d.Get(ctx, "a"); !ok || err != nil {
+		t.Fatalf("get a = %v, %v", ok, err)
+	}
+	if err := d.Set(ctx, "c", []byte("c"), 0); err != nil {
+		t.Fatal(err)
+	}
+
+	for k, want := range map[string]bool{"a": true, "b": false, "c": true} {
+		if _, ok, _ := d.Get(ctx, k); ok != want {
+			t.Errorf("entry %s present = %v, want %v", k, ok, want)
+		}
+	}
+	if s := d.Stats(); s.Entries != 2 || s.Evictions != 1 {
+		t.Errorf("stats = %+v, want 2 entries and 1 eviction", s)
+	}
+}
+
+func TestDiskCacheMaxBytes(t *testing.T) {
+	ctx := context.Background()
+	// every entry takes 8 bytes of expiry and 10 bytes of value
+	d, err := NewDiskCacheWithConfig(DiskCacheConfig{Dir: testDir(t), MaxBytes: 30})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Set(ctx, "a", []byte("0123456789"), 0); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-time.Hour)
+	os.Chtimes(d.path("a"), old, old)
+	if err := d.Set(ctx, "b", []byte("0123456789"), 0); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok, _ := d.Get(ctx, "a"); ok {
+		t.Error("oldest entry was kept over the size bound")
+	}
+	if _, ok, _ := d.Get(ctx, "b"); !ok {
+		t.Error("newest entry was evicted")
+	}
+}
+
+func TestDiskCacheEvictsExpiredEntriesFirst(t *testing.T) {
+	ctx := context.Background()
+	d, err := NewDiskCacheWithConfig(DiskCacheConfig{Dir: testDir(t), MaxEntries: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	d.now = func() time.Time { return now }
+
+	if err := d.Set(ctx, "a", []byte("a"), time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Set(ctx, "b", []byte("b"), time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	old := now.Add(-time.Hour)
+	os.Chtimes(d.path("a"), old, old)
+
+	now = now.Add(2 * time.Minute)
+	if err := d.Set(ctx, "c", []byte("c"), 0); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok, _ := d.Get(ctx, "a"); !ok {
+		t.Error("live entry was evicted while an expired one was available")
+	}
+	if s := d.Stats(); s.Entries != 2 || s.Evictions != 0 || s.Expirations != 1 {
+		t.Errorf("stats = %+v, want 2 entries, no evictions and 1 expiration", s)
+	}
+}
+
+// fakeRedis is a minimal RESP server supporting AUTH, SELECT, GET, SET with PX and DEL.
+type fakeRedis struct {
+	ln       net.Listener
+	password string
+
+	mu   sync.Mutex
+	data map[string]string
+	cmds []string
+}
+
+func newFakeRedis(t *testing.T, password string) *fakeRedis {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeRedis{ln: ln, password: password, data: map[string]string{}}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn)
+		}
+	}()
+	return f
+}
+
+func (f *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	authed := f.password == ""
+	for {
+		args, err := readFakeRedisCommand(r)
+		if err != nil {
+			return
+		}
+
+		f.mu.Lock()
+		f.cmds = append(f.cmds, strings.Join(args, " "))
+		var reply string
+		switch strings.ToUpper(args[0]) {
+		case "AUTH":
+			if args[len(args)-1] == f.password {
+				authed = true
+				reply = "+OK\r\n"
+			} else {
+				reply = "-WRONGPASS invalid password\r\n"
+			}
+		case "SELECT":
+			reply = "+OK\r\n"
+		case "GET":
+			if v, ok := f.data[args[1]]; ok {
+				reply = "$" + strconv.Itoa(len(v)) + "\r\n" + v + "\r\n"
+			} else {
+				reply = "$-1\r\n"
+			}
+		case "SET":
+			f.data[args[1]] = args[2]
+			reply = "+OK\r\n"
+		case "DEL":
+			delete(f.data, args[1])
+			reply = ":1\r\n"
+		default:
+			reply = "-ERR unknown command\r\n"
+		}
+		if !authed && strings.ToUpper(args[0]) != "AUTH" {
+			reply = "-NOAUTH Authentication required.\r\n"
+		}
+		f.mu.Unlock()
+
+		if _, err := io.WriteString(conn, reply); err != nil {
+			return
+		}
+	}
+}
+
+func readFakeRedisCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+	if err != nil {
+		return nil, err
+	}
+	args := make([]string, n)
+	for i := range args {
+		if line, err = r.ReadString('\n'); err != nil {
+			return nil, err
+		}
+		size, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+		if err != nil {
+			return nil, err
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		args[i] = string(buf[:size])
+	}
+	return args, nil
+}
+
+func (f *fakeRedis) commands() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string{}, f.cmds...)
+}
+
+func TestRedisCache(t *testing.T) {
+	ctx := context.Background()
+	srv := newFakeRedis(t, "s3cret")
+	setTestEnv(t, "CFX_TEST_REDIS_PASSWORD", "s3cret")
+
+	c := NewRedisCache(RedisCacheConfig{
+		Addr:     srv.ln.Addr().String(),
+		Username: "app",
+		Password: "env:CFX_TEST_REDIS_PASSWORD",
+		DB:       3,
+		Prefix:   "svc:",
+	})
+	defer c.Close()
+
+	if _, ok, err := c.Get(ctx, "k"); ok || err != nil {
+		t.Fatalf("get of a missing key = %v, %v", ok, err)
+	}
+	if err := c.Set(ctx, "k", []byte("line\r\nbreak"), 1500*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	got, ok, err := c.Get(ctx, "k")
+	if err != nil || !ok || string(got) != "line\r\nbreak" {
+		t.Fatalf("get = %q, %v, %v", got, ok, err)
+	}
+	if err := c.Delete(ctx, "k"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, _ := c.Get(ctx, "k"); ok {
+		t.Error("deleted key is still cached")
+	}
+
+	want := []string{
+		"AUTH app s3cret",
+		"SELECT 3",
+		"GET svc:k",
+		"SET svc:k line\r\nbreak PX 1500",
+		"GET svc:k",
+		"DEL svc:k",
+		"GET svc:k",
+	}
+	cmds := srv.commands()
+	if strings.Join(cmds, "|") != strings.Join(want, "|") {
+		t.Errorf("commands = %q, want %q on a single pooled connection", cmds, want)
+	}
+	if s := c.Stats(); s.Hits != 1 || s.Misses != 2 || s.Sets != 1 {
+		t.Errorf("stats = %+v", s)
+	}
+}
+
+func TestRedisCacheErrorReply(t *testing.T) {
+	srv := newFakeRedis(t, "s3cret")
+	c := NewRedisCache(RedisCacheConfig{Addr: srv.ln.Addr().String()})
+	defer c.Close()
+
+	_, _, err := c.Get(context.Background(), "k")
+	if err == nil || !strings.Contains(err.Error(), "NOAUTH") {
+		t.Fatalf("get without a password = %v, want the server's NOAUTH error", err)
+	}
+}
+
+// countingLayer is a LayerSource counting its fetches.
+type countingLayer struct {
+	mu    sync.Mutex
+	calls int
+}
+
+func (c *countingLayer) Name() string { return "counting" }
+
+func (c *countingLayer) FetchLayer(context.Context) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.calls++
+	return []byte("a: 1\n"), nil
+}
+
+func TestFailoverLayerUsesRemoteCache(t *testing.T) {
+	src := &countingLayer{}
+	layer := &FailoverLayer{Name: "shared", Sources: []LayerSource{src}}
+	opts := newOptions([]Option{WithRemoteCache(NewMemoryCache(0), time.Minute)})
+
+	for i, want := range []string{"counting", "cache"} {
+		data, from, err := layer.fetch(context.Background(), opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if from != want || string(data) != "a: 1\n" {
+			t.Errorf("fetch %d came from %s with %q, want %s", i, from, data, want)
+		}
+	}
+	if src.calls != 1 {
+		t.Errorf("source fetched %d times, want once", src.calls)
+	}
+}
+
+// countingClient is a ConfigClient counting its fetches.
+type countingClient struct {
+	server *ConfigServer
+	calls  int
+}
+
+func (c *countingClient) Fetch(ctx context.Context, req *ConfigRequest) (*ConfigResponse, error) {
+	c.calls++
+	return c.server.Fetch(ctx, req)
+}
+
+func (c *countingClient) Watch(context.Context, *ConfigRequest) (ConfigUpdates, error) {
+	return nil, errors.New("not supported")
+}
+
+func TestConfigServerResponseCached(t *testing.T) {
+	dir := testDir(t)
+	writeTestConfig(t, dir, "production.yaml", "db:\n  host: db.internal\n")
+	s := NewConfigServer(EnvContext{ConfigPath: dir})
+	defer s.Close()
+
+	client := &countingClient{server: s}
+	opts := newOptions([]Option{WithConfigServer(client), WithRemoteCache(NewMemoryCache(0), time.Minute)})
+
+	for _, svc := range []string{"payments", "payments", "billing"} {
+		req := &ConfigRequest{Environment: "production", ServiceID: svc}
+		resp, err := fetchFromServer(context.Background(), opts, req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if db := resp.Config["db"].(map[string]interface{}); db["host"] != "db.internal" {
+			t.Errorf("host = %v, want db.internal", db["host"])
+		}
+	}
+	if client.calls != 2 {
+		t.Errorf("server fetched %d times, want once per distinct request", client.calls)
+	}
+}
+
+// streamingClient is a ConfigClient without delta support, streaming the responses sent on
+// updates.
+type streamingClient struct {
+	first   *ConfigResponse
+	updates chan *ConfigResponse
+
+	mu    sync.Mutex
+	calls int
+}
+
+func (c *streamingClient) Fetch(context.Context, *ConfigRequest) (*ConfigResponse, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.calls++
+	return c.first, nil
+}
+
+func (c *streamingClient) Watch(ctx context.Context, _ *ConfigRequest) (ConfigUpdates, error) {
+	return chanUpdates{ctx: ctx, ch: c.updates}, nil
+}
+
+type chanUpdates struct {
+	ctx context.Context
+	ch  chan *ConfigResponse
+}
+
+func (u chanUpdates) Recv() (*ConfigResponse, error) {
+	select {
+	case resp := <-u.ch:
+		return resp, nil
+	case <-u.ctx.Done():
+		return nil, u.ctx.Err()
+	}
+}
+
+func serverResponse(fp, host string) *ConfigResponse {
+	return &ConfigResponse{
+		Version:     DistributionProtocolVersion,
+		Environment: EnvContext{Environment: "production"},
+		Fingerprint: fp,
+		Config:      map[string]interface{}{"db": map[string]interface{}{"host": host}},
+	}
+}
+
+func TestWatchServerBypassesRemoteCache(t *testing.T) {
+	client := &streamingClient{first: serverResponse("v1", "old.internal"), updates: make(chan *ConfigResponse)}
+	reloaded := make(chan struct{}, 4)
+	observe := WithReloadObserver(func(ev ReloadEvent) {
+		if ev.Trigger == TriggerWatch {
+			reloaded <- struct{}{}
+		}
+	})
+	c, err := NewConfigWithOptions(EnvContext{Environment: "production"}, WithConfigServer(client), WithRemoteCache(NewMemoryCache(0), time.Hour), observe)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go WatchServer(ctx, c)
+
+	client.updates <- serverResponse("v1", "old.internal")
+	<-reloaded
+	client.updates <- serverResponse("v2", "new.internal")
+	<-reloaded
+
+	var db struct {
+		Host string `yaml:"host"`
+	}
+	if err := c.Populate("db", &db); err != nil {
+		t.Fatal(err)
+	}
+	if db.Host != "new.internal" {
+		t.Errorf("host = %q after a streamed update, want new.internal", db.Host)
+	}
+
+	// the streamed update also replaces the cached response
+	req := configRequestFor(EnvContext{Environment: "production"})
+	resp, err := fetchFromServer(context.Background(), c.(*yamlContainer).opts, &req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Fingerprint != "v2" {
+		t.Errorf("cached response has fingerprint %s, want v2", resp.Fingerprint)
+	}
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	if client.calls != 1 {
+		t.Errorf("server fetched %d times, want once", client.calls)
+	}
+}
@@ -0,0 +1,439 @@
+package cfx
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// SnapshotEncoding is the serialization used for snapshots served on a socket or published to
+// a shared snapshot file.
+type SnapshotEncoding string
+
+const (
+	// EncodingJSON is the default encoding, readable by anything.
+	EncodingJSON SnapshotEncoding = "json"
+
+	// EncodingCBOR is a compact binary encoding (RFC 8949) that is several times faster to
+	// decode than JSON for large configurations.
+	EncodingCBOR SnapshotEncoding = "cbor"
+)
+
+// WithSnapshotEncoding sets the encoding of snapshots served with WithSocketServer and
+// published with WithSharedSnapshot. Readers in cfx detect the encoding, so it can be changed
+// without updating them together.
+func WithSnapshotEncoding(enc SnapshotEncoding) Option {
+	return func(o *options) {
+		o.snapshotEncoding = enc
+	}
+}
+
+// CBOR major types.
+const (
+	cborUint   = 0
+	cborNegint = 1
+	cborBytes  = 2
+	cborText   = 3
+	cborArray  = 4
+	cborMap    = 5
+	cborTag    = 6
+	cborSimple = 7
+)
+
+// _cborSelfDescribe is the self-described CBOR tag (55799) every encoded value starts with, so
+// that readers can tell CBOR from JSON by its first byte.
+var _cborSelfDescribe = []byte{0xd9, 0xd9, 0xf7}
+
+// _cborContentType is the media type of CBOR responses from a ConfigServer.
+const _cborContentType = "application/cbor"
+
+// _maxInt is the largest value of int on the platform.
+const _maxInt = uint64(^uint(0) >> 1)
+
+// _cborMaxDepth bounds the nesting of decoded values.
+const _cborMaxDepth = 1000
+
+// EncodeCBOR encodes a configuration tree, made of maps with string keys, slices, strings,
+// numbers, booleans and nils, as CBOR. Map keys are sorted, so equal trees encode equally.
+func EncodeCBOR(v interface{}) ([]byte, error) {
+	buf := append([]byte{}, _cborSelfDescribe...)
+	return appendCBOR(buf, v)
+}
+
+// DecodeCBOR decodes a value encoded with EncodeCBOR. Integers are decoded as int when they
+// fit, matching the YAML decoder, and as int64 or uint64 otherwise.
+func DecodeCBOR(data []byte) (interface{}, error) {
+	d := &cborDecoder{data: data}
+	if len(data) >= len(_cborSelfDescribe) && string(data[:len(_cborSelfDescribe)]) == string(_cborSelfDescribe) {
+		d.off = len(_cborSelfDescribe)
+	}
+	v, err := d.value(0)
+	if err != nil {
+		return nil, fmt.Errorf("could not decode cbor: %v", err)
+	}
+	if d.off != len(data) {
+		return nil, fmt.Errorf("could not decode cbor: %d trailing bytes", len(data)-d.off)
+	}
+	return v, nil
+}
+
+// isCBOR reports whether data was encoded with EncodeCBOR.
+func isCBOR(data []byte) bool {
+	return len(data) > 0 && data[0] == _cborSelfDescribe[0]
+}
+
+func appendCBORHead(buf []byte, major byte, n uint64) []byte {
+	m := major << 5
+	switch {
+	case n < 24:
+		return append(buf, m|byte(n))
+	case n <= math.MaxUint8:
+		return append(buf, m|24, byte(n))
+	case n <= math.MaxUint16:
+		return append(buf, m|25, byte(n>>8), byte(n))
+	case n <= math.MaxUint32:
+		return append(buf, m|26, byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+	default:
+		var b [8]byte
+		binary.BigEndian.PutUint64(b[:], n)
+		return append(append(buf, m|27), b[:]...)
+	}
+}
+
+func appendCBORInt(buf []byte, i int64) []byte {
+	if i < 0 {
+		return appendCBORHead(buf, cborNegint, uint64(-(i + 1)))
+	}
+	return appendCBORHead(buf, cborUint, uint64(i))
+}
+
+func appendCBOR(buf []byte, v interface{}) ([]byte, error) {
+	switch t := v.(type) {
+	case nil:
+		return append(buf, 0xf6), nil
+	case bool:
+		if t {
+			return append(buf, 0xf5), nil
+		}
+		return append(buf, 0xf4), nil
+	case string:
+		buf = appendCBORHead(buf, cborText, uint64(len(t)))
+		return append(buf, t...), nil
+	case []byte:
+		buf = appendCBORHead(buf, cborBytes, uint64(len(t)))
+		return append(buf, t...), nil
+	case int:
+		return appendCBORInt(buf, int64(t)), nil
+	case int64:
+		return appendCBORInt(buf, t), nil
+	case int32:
+		return appendCBORInt(buf, int64(t)), nil
+	case uint64:
+		return appendCBORHead(buf, cborUint, t), nil
+	case uint32:
+		return appendCBORHead(buf, cborUint, uint64(t)), nil
+	case float64:
+		var b [8]byte
+		binary.BigEndian.PutUint64(b[:], math.Float64bits(t))
+		return append(append(buf, 0xfb), b[:]...), nil
+	case float32:
+		return appendCBOR(buf, float64(t))
+	case []interface{}:
+		buf = appendCBORHead(buf, cborArray, uint64(len(t)))
+		for i, item := range t {
+			var err error
+			if buf, err = appendCBOR(buf, item); err != nil {
+				return nil, fmt.Errorf("[%d]: %v", i, err)
+			}
+		}
+		return buf, nil
+	case []string:
+		buf = appendCBORHead(buf, cborArray, uint64(len(t)))
+		for _, item := range t {
+			buf = appendCBORHead(buf, cborText, uint64(len(item)))
+			buf = append(buf, item...)
+		}
+		return buf, nil
+	case map[string]interface{}:
+		keys := make([]string, 0, len(t))
+		for k := range t {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		buf = appendCBORHead(buf, cborMap, uint64(len(t)))
+		for _, k := range keys {
+			buf = appendCBORHead(buf, cborText, uint64(len(k)))
+			buf = append(buf, k...)
+			var err error
+			if buf, err = appendCBOR(buf, t[k]); err != nil {
+				return nil, fmt.Errorf("%s: %v", k, err)
+			}
+		}
+		return buf, nil
+	case map[interface{}]interface{}:
+		return appendCBOR(buf, normalizeValue(t))
+	default:
+		return nil, fmt.Errorf("cannot encode %T as cbor", v)
+	}
+}
+
+var errCBORTruncated = errors.New("truncated input")
+
+type cborDecoder struct {
+	data []byte
+	off  int
+}
+
+// head reads the initial byte and argument of the next item.
+func (d *cborDecoder) head() (byte, byte, uint64, error) {
+	if d.off >= len(d.data) {
+		return 0, 0, 0, errCBORTruncated
+	}
+	ib := d.data[d.off]
+	d.off++
+	major, info := ib>>5, ib&0x1f
+
+	var size int
+	switch {
+	case info < 24:
+		return major, info, uint64(info), nil
+	case info == 24:
+		size = 1
+	case info == 25:
+		size = 2
+	case info == 26:
+		size = 4
+	case info == 27:
+		size = 8
+	default:
+		return 0, 0, 0, fmt.Errorf("unsupported additional information %d", info)
+	}
+	if len(d.data)-d.off < size {
+		return 0, 0, 0, errCBORTruncated
+	}
+	var n uint64
+	for _, b := range d.data[d.off : d.off+size] {
+		n = n<<8 | uint64(b)
+	}
+	d.off += size
+	return major, info, n, nil
+}
+
+// take returns the next n bytes.
+func (d *cborDecoder) take(n uint64) ([]byte, error) {
+	if uint64(len(d.data)-d.off) < n {
+		return nil, errCBORTruncated
+	}
+	ret := d.data[d.off : d.off+int(n)]
+	d.off += int(n)
+	return ret, nil
+}
+
+func (d *cborDecoder) value(depth int) (interface{}, error) {
+	if depth > _cborMaxDepth {
+		return nil, errors.New("nested too deeply")
+	}
+
+	major, info, n, err := d.head()
+	if err != nil {
+		return nil, err
+	}
+
+	switch major {
+	case cborUint:
+		switch {
+		case n <= _maxInt:
+			return int(n), nil
+		case n <= math.MaxInt64:
+			return int64(n), nil
+		}
+		return n, nil
+	case cborNegint:
+		switch {
+		case n < _maxInt:
+			return int(-int64(n) - 1), nil
+		case n <= math.MaxInt64:
+			return -int64(n) - 1, nil
+		}
+		return nil, errors.New("negative integer overflows int64")
+	case cborBytes:
+		b, err := d.take(n)
+		if err != nil {
+			return nil, err
+		}
+		return append([]byte{}, b...), nil
+	case cborText:
+		b, err := d.take(n)
+		if err != nil {
+			return nil, err
+		}
+		return string(b), nil
+	case cborArray:
+		// every item takes at least a byte, which bounds the allocation
+		if n > uint64(len(d.data)-d.off) {
+			return nil, errCBORTruncated
+		}
+		ret := make([]interface{}, n)
+		for i := range ret {
+			if ret[i], err = d.value(depth + 1); err != nil {
+				return nil, err
+			}
+		}
+		return ret, nil
+	case cborMap:
+		if n > uint64(len(d.data)-d.off)/2 {
+			return nil, errCBORTruncated
+		}
+		ret := make(map[string]interface{}, n)
+		for i := uint64(0); i < n; i++ {
+			k, err := d.value(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			key, ok := k.(string)
+			if !ok {
+				return nil, fmt.Errorf("map key of type %T is not supported", k)
+			}
+			if ret[key], err = d.value(depth + 1); err != nil {
+				return nil, err
+			}
+		}
+		return ret, nil
+	case cborTag:
+		if n != 55799 {
+			return nil, fmt.Errorf("unsupported tag %d", n)
+		}
+		return d.value(depth + 1)
+	default:
+		switch info {
+		case 20:
+			return false, nil
+		case 21:
+			return true, nil
+		case 22, 23:
+			return nil, nil
+		case 25:
+			return halfToFloat(uint16(n)), nil
+		case 26:
+			return float64(math.Float32frombits(uint32(n))), nil
+		case 27:
+			return math.Float64frombits(n), nil
+		}
+		return nil, fmt.Errorf("unsupported simple value %d", info)
+	}
+}
+
+// halfToFloat converts an IEEE 754 half precision float.
+func halfToFloat(h uint16) float64 {
+	exp := int(h>>10) & 0x1f
+	mant := float64(h & 0x3ff)
+	var v float64
+	switch exp {
+	case 0:
+		v = math.Ldexp(mant, -24)
+	case 0x1f:
+		if mant == 0 {
+			v = math.Inf(1)
+		} else {
+			v = math.NaN()
+		}
+	default:
+		v = math.Ldexp(mant+1024, exp-25)
+	}
+	if h&0x8000 != 0 {
+		return -v
+	}
+	return v
+}
+
+// encodeSnapshotCBOR encodes the fields shared by SocketSnapshot and ConfigResponse. The
+// environment is small and is carried in its JSON form.
+func encodeSnapshotCBOR(version int, env EnvContext, fingerprint string, sources []ReportSource, config map[string]interface{}) ([]byte, error) {
+	envTree, err := jsonTree(env)
+	if err != nil {
+		return nil, err
+	}
+	srcs := make([]interface{}, len(sources))
+	for i, s := range sources {
+		srcs[i] = map[string]interface{}{"name": s.Name, "size": s.Size, "digest": s.Digest}
+	}
+	if config == nil {
+		config = map[string]interface{}{}
+	}
+
+	return EncodeCBOR(map[string]interface{}{
+		"version":     version,
+		"environment": envTree,
+		"fingerprint": fingerprint,
+		"sources":     srcs,
+		"config":      config,
+	})
+}
+
+// decodeSnapshotCBOR decodes the fields written by encodeSnapshotCBOR.
+func decodeSnapshotCBOR(data []byte) (int, EnvContext, string, []ReportSource, map[string]interface{}, error) {
+	v, err := DecodeCBOR(data)
+	if err != nil {
+		return 0, EnvContext{}, "", nil, nil, err
+	}
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return 0, EnvContext{}, "", nil, nil, fmt.Errorf("snapshot is a %T, not a map", v)
+	}
+
+	version, _ := m["version"].(int)
+	fingerprint, _ := m["fingerprint"].(string)
+	config, _ := m["config"].(map[string]interface{})
+
+	var env EnvContext
+	if data, err := json.Marshal(m["environment"]); err == nil {
+		if err := json.Unmarshal(data, &env); err != nil {
+			return 0, EnvContext{}, "", nil, nil, fmt.Errorf("could not decode environment: %v", err)
+		}
+	}
+
+	var sources []ReportSource
+	list, _ := m["sources"].([]interface{})
+	for _, item := range list {
+		s, _ := item.(map[string]interface{})
+		src := ReportSource{}
+		src.Name, _ = s["name"].(string)
+		src.Size, _ = s["size"].(int)
+		src.Digest, _ = s["digest"].(string)
+		sources = append(sources, src)
+	}
+
+	return version, env, fingerprint, sources, config, nil
+}
+
+// jsonTree converts v to a generic tree through its JSON form.
+func jsonTree(v interface{}) (interface{}, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var ret interface{}
+	if err := json.Unmarshal(data, &ret); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// MarshalCBOR encodes the response as CBOR.
+func (r *ConfigResponse) MarshalCBOR() ([]byte, error) {
+	return encodeSnapshotCBOR(r.Version, r.Environment, r.Fingerprint, r.Sources, r.Config)
+}
+
+// UnmarshalCBOR decodes a response encoded with MarshalCBOR.
+func (r *ConfigResponse) UnmarshalCBOR(data []byte) error {
+	version, env, fp, sources, config, err := decodeSnapshotCBOR(data)
+	if err != nil {
+		return err
+	}
+	*r = ConfigResponse{Version: version, Environment: env, Fingerprint: fp, Sources: sources, Config: config}
+	return nil
+}
@@ -7,6 +7,8 @@ import (
 // Module is the Fx provider that gives access to cfgfx.EnvContext and cfgfx.Container types.
 // Note: You should use cfx.NewFXEnvContext("PREFIX") to populate a constructor for EnvContext types.
 // If you wish to leave "PREFIX" empty, you can - the default prefix is "CFX".
-var Module = fx.Provide(
-	NewConfig,
+// Every section registered with cfx.ProvideSection is validated when the application starts.
+var Module = fx.Options(
+	fx.Provide(NewConfig),
+	validateSections,
 )
@@ -0,0 +1,156 @@
+package cfxgrpc
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gen0cide/cfx"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func TestCodecRoundTrip(t *testing.T) {
+	resp := &cfx.ConfigResponse{
+		Version:     cfx.DistributionProtocolVersion,
+		Environment: cfx.EnvContext{Environment: "production"},
+		Fingerprint: "abc",
+		Sources:     []cfx.ReportSource{{Name: "production.yaml", Size: 12, Digest: "d"}},
+		Config:      map[string]interface{}{"port": 8080, "name": "svc"},
+	}
+	data, err := (&configResponse{resp}).marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := &configResponse{&cfx.ConfigResponse{}}
+	if err := got.unmarshal(data); err != nil {
+		t.Fatal(err)
+	}
+	if got.Fingerprint != "abc" || got.Environment.Environment != "production" || len(got.Sources) != 1 {
+		t.Errorf("decoded %+v", got.ConfigResponse)
+	}
+	if got.Config["port"] != 8080 {
+		t.Errorf("port = %#v, want 8080 as an int", got.Config["port"])
+	}
+
+	delta := &cfx.DeltaConfigRequest{
+		ConfigRequest: cfx.ConfigRequest{Environment: "production", ServiceID: "payments"},
+		Versions:      map[string]string{"db": "v1"},
+	}
+	data, _ = (&deltaRequest{delta}).marshal()
+	gotDelta := &deltaRequest{&cfx.DeltaConfigRequest{}}
+	if err := gotDelta.unmarshal(data); err != nil {
+		t.Fatal(err)
+	}
+	if gotDelta.ServiceID != "payments" || gotDelta.Versions["db"] != "v1" {
+		t.Errorf("decoded %+v", gotDelta.DeltaConfigRequest)
+	}
+}
+
+// serve starts cs on an in-memory listener and returns a client for it.
+func serve(t *testing.T, cs *cfx.ConfigServer, cfg Config, opts ...grpc.DialOption) cfx.ConfigClient {
+	t.Helper()
+	srv, err := NewServer(cs, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lis := bufconn.Listen(1 << 20)
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	opts = append(opts,
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	cc, err := grpc.Dial("passthrough:///bufnet", opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { cc.Close() })
+	return NewClient(cc)
+}
+
+func configServer(t *testing.T, opts ...cfx.Option) *cfx.ConfigServer {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "cfxgrpc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	if err := ioutil.WriteFile(filepath.Join(dir, "production.yaml"), []byte("port: 8080\npassword: hunter2\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cs := cfx.NewConfigServer(cfx.EnvContext{ConfigPath: dir}, opts...)
+	t.Cleanup(func() { cs.Close() })
+	return cs
+}
+
+func TestFetchAndWatch(t *testing.T) {
+	client := serve(t, configServer(t), Config{Insecure: true})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	req := &cfx.ConfigRequest{Environment: "production"}
+	resp, err := client.Fetch(ctx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Config["port"] != 8080 {
+		t.Errorf("port = %#v, want 8080", resp.Config["port"])
+	}
+	if resp.Config["password"] == "hunter2" {
+		t.Error("password was served unredacted")
+	}
+
+	updates, err := client.Watch(ctx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, err := updates.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Fingerprint != resp.Fingerprint {
+		t.Errorf("watch fingerprint %s, fetch fingerprint %s", first.Fingerprint, resp.Fingerprint)
+	}
+
+	deltas, err := client.(cfx.DeltaConfigClient).WatchDelta(ctx, &cfx.DeltaConfigRequest{ConfigRequest: *req})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := deltas.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := d.Updated["port"]; !ok || d.Fingerprint != resp.Fingerprint {
+		t.Errorf("first delta %+v", d)
+	}
+}
+
+func TestErrorCodes(t *testing.T) {
+	cs := configServer(t, cfx.WithAllowedConfigRequests(cfx.ConfigRequest{Environment: "production"}))
+	client := serve(t, cs, Config{Insecure: true})
+
+	_, err := client.Fetch(context.Background(), &cfx.ConfigRequest{Environment: "staging"})
+	if status.Code(err) != codes.PermissionDenied {
+		t.Errorf("got %v, want PermissionDenied", err)
+	}
+}
+
+func TestConfigRequiresSecurity(t *testing.T) {
+	if err := (Config{}).Validate(); err == nil {
+		t.Error("a config without tls or insecure validated")
+	}
+	if err := (Config{TLS: cfx.TLSConfig{Enabled: true, CertFile: "c", KeyFile: "k"}}).Validate(); err == nil {
+		t.Error("a config without client authentication validated")
+	}
+	if err := (Config{TLS: cfx.TLSConfig{Enabled: true, CertFile: "c", KeyFile: "k"}, Tokens: []string{"t"}}).Validate(); err != nil {
+		t.Errorf("tls with tokens did not validate: %v", err)
+	}
+}
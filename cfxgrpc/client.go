@@ -0,0 +1,107 @@
+package cfxgrpc
+
+import (
+	"context"
+
+	"github.com/gen0cide/cfx"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+// client is a cfx.ConfigClient and cfx.DeltaConfigClient calling the ConfigService.
+type client struct {
+	cc grpc.ClientConnInterface
+}
+
+// NewClient returns a cfx.ConfigClient calling the ConfigService over cc. It implements
+// cfx.DeltaConfigClient, so cfx.WatchServer follows the server with incremental updates.
+func NewClient(cc grpc.ClientConnInterface) cfx.ConfigClient {
+	return &client{cc: cc}
+}
+
+// TokenCredentials returns credentials sending token as a bearer token with every call, for
+// servers configured with Config.Tokens. They are only sent over TLS.
+func TokenCredentials(token string) credentials.PerRPCCredentials {
+	return tokenCredentials(token)
+}
+
+type tokenCredentials string
+
+func (t tokenCredentials) GetRequestMetadata(context.Context, ...string) (map[string]string, error) {
+	return map[string]string{"authorization": "Bearer " + string(t)}, nil
+}
+
+func (t tokenCredentials) RequireTransportSecurity() bool {
+	return true
+}
+
+func (c *client) Fetch(ctx context.Context, req *cfx.ConfigRequest) (*cfx.ConfigResponse, error) {
+	if cfx.IsAirGapped() {
+		return nil, cfx.AirGappedError{Resource: "config service"}
+	}
+	out := &configResponse{&cfx.ConfigResponse{}}
+	if err := c.cc.Invoke(ctx, _fetchMethod, &configRequest{*req}, out, grpc.ForceCodec(codec{})); err != nil {
+		return nil, err
+	}
+	return out.ConfigResponse, nil
+}
+
+// openStream starts a server streaming call of method, sending req.
+func (c *client) openStream(ctx context.Context, desc *grpc.StreamDesc, method string, req wireMessage) (grpc.ClientStream, error) {
+	if cfx.IsAirGapped() {
+		return nil, cfx.AirGappedError{Resource: "config service"}
+	}
+	stream, err := c.cc.NewStream(ctx, desc, method, grpc.ForceCodec(codec{}))
+	if err != nil {
+		return nil, err
+	}
+	if err := stream.SendMsg(req); err != nil {
+		return nil, err
+	}
+	if err := stream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return stream, nil
+}
+
+func (c *client) Watch(ctx context.Context, req *cfx.ConfigRequest) (cfx.ConfigUpdates, error) {
+	stream, err := c.openStream(ctx, &_serviceDesc.Streams[0], _watchMethod, &configRequest{*req})
+	if err != nil {
+		return nil, err
+	}
+	return watchUpdates{stream}, nil
+}
+
+func (c *client) WatchDelta(ctx context.Context, req *cfx.DeltaConfigRequest) (cfx.DeltaConfigUpdates, error) {
+	stream, err := c.openStream(ctx, &_serviceDesc.Streams[1], _watchDeltaMethod, &deltaRequest{req})
+	if err != nil {
+		return nil, err
+	}
+	return watchDeltaUpdates{stream}, nil
+}
+
+// watchUpdates adapts a grpc.ClientStream to cfx.ConfigUpdates.
+type watchUpdates struct {
+	stream grpc.ClientStream
+}
+
+func (w watchUpdates) Recv() (*cfx.ConfigResponse, error) {
+	out := &configResponse{&cfx.ConfigResponse{}}
+	if err := w.stream.RecvMsg(out); err != nil {
+		return nil, err
+	}
+	return out.ConfigResponse, nil
+}
+
+// watchDeltaUpdates adapts a grpc.ClientStream to cfx.DeltaConfigUpdates.
+type watchDeltaUpdates struct {
+	stream grpc.ClientStream
+}
+
+func (w watchDeltaUpdates) Recv() (*cfx.DeltaConfigResponse, error) {
+	out := &deltaResponse{&cfx.DeltaConfigResponse{}}
+	if err := w.stream.RecvMsg(out); err != nil {
+		return nil, err
+	}
+	return out.DeltaConfigResponse, nil
+}
@@ -0,0 +1,132 @@
+// Command cfxserver serves a config directory to cfx clients over gRPC, as the ConfigService
+// defined in proto/config.proto.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/gen0cide/cfx"
+	"github.com/gen0cide/cfx/cfxgrpc"
+)
+
+func main() {
+	if err := run(os.Args[1:]); err != nil {
+		fmt.Fprintf(os.Stderr, "cfxserver: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string) error {
+	fs := flag.NewFlagSet("cfxserver", flag.ContinueOnError)
+	addr := fs.String("listen", "127.0.0.1:8481", "address to serve configuration on")
+	interval := fs.Duration("interval", 5*time.Second, "how often to check the config directory for changes")
+	hierarchy := fs.Bool("key-hierarchy", false, "apply app and service specific overrides")
+	certFile := fs.String("tls-cert", "", "certificate to serve TLS with")
+	keyFile := fs.String("tls-key", "", "key of the -tls-cert certificate")
+	clientCA := fs.String("client-ca", "", "require client certificates signed by this CA bundle")
+	tokenFile := fs.String("token-file", "", "require a bearer token listed in this file, one per line")
+	maxEntries := fs.Int("max-entries", cfx.DefaultServerEntryLimit, "how many distinct config requests to serve at once")
+
+	// allow the config directory to come before the flags
+	dir := ""
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		dir, args = args[0], args[1:]
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if dir == "" && fs.NArg() > 0 {
+		dir = fs.Arg(0)
+	}
+	if dir == "" {
+		return fmt.Errorf("usage: cfxserver <config-dir> [-listen <addr>] [-tls-cert <file> -tls-key <file>] [-client-ca <file>] [-token-file <file>]")
+	}
+
+	cfg := cfxgrpc.Config{Insecure: isLoopback(*addr)}
+	if *certFile != "" || *keyFile != "" {
+		cfg.TLS = cfx.TLSConfig{Enabled: true, CertFile: *certFile, KeyFile: *keyFile, MinVersion: "1.2"}
+		if *clientCA != "" {
+			cfg.TLS.CAFile = *clientCA
+			cfg.TLS.ClientAuth = "require_and_verify"
+		}
+	}
+	if *tokenFile != "" {
+		tokens, err := readTokens(*tokenFile)
+		if err != nil {
+			return err
+		}
+		cfg.Tokens = tokens
+	}
+	if err := cfg.Validate(); err != nil {
+		if !cfg.Insecure {
+			return fmt.Errorf("%s is not a loopback address: %v", *addr, err)
+		}
+		return err
+	}
+
+	opts := []cfx.Option{cfx.WithHotReload(*interval), cfx.WithServerEntryLimit(*maxEntries)}
+	if *hierarchy {
+		opts = append(opts, cfx.WithKeyHierarchy())
+	}
+	cs := cfx.NewConfigServer(cfx.EnvContext{ConfigPath: dir}, opts...)
+	defer cs.Close()
+
+	srv, err := cfxgrpc.NewServer(cs, cfg)
+	if err != nil {
+		return err
+	}
+	lis, err := net.Listen("tcp", *addr)
+	if err != nil {
+		return err
+	}
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sigs
+		srv.GracefulStop()
+	}()
+
+	fmt.Fprintf(os.Stderr, "serving configuration from %s on %s\n", dir, lis.Addr())
+	return srv.Serve(lis)
+}
+
+// isLoopback reports whether addr only listens on the loopback interface.
+func isLoopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// readTokens reads the bearer tokens listed in path, skipping blank lines and comments.
+func readTokens(path string) ([]string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read tokens: %v", err)
+	}
+	var ret []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "#") {
+			ret = append(ret, line)
+		}
+	}
+	if len(ret) == 0 {
+		return nil, fmt.Errorf("%s does not list any tokens", path)
+	}
+	return ret, nil
+}
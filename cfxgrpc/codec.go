@@ -0,0 +1,364 @@
+package cfxgrpc
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/gen0cide/cfx"
+	"google.golang.org/grpc/encoding"
+	_ "google.golang.org/grpc/encoding/proto" // registers the codec other messages fall back to
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// wireMessage is implemented by the messages of the ConfigService.
+type wireMessage interface {
+	marshal() ([]byte, error)
+	unmarshal(data []byte) error
+}
+
+// codec encodes the ConfigService messages, and hands every other message to the protobuf
+// codec, so a server created with ServerOption can serve generated services as well.
+type codec struct{}
+
+// Name implements the encoding.Codec interface. It is the name of the protobuf codec, as the
+// messages are encoded as protobuf.
+func (codec) Name() string {
+	return "proto"
+}
+
+// Marshal implements the encoding.Codec interface.
+func (codec) Marshal(v interface{}) ([]byte, error) {
+	if m, ok := v.(wireMessage); ok {
+		return m.marshal()
+	}
+	return encoding.GetCodec("proto").Marshal(v)
+}
+
+// Unmarshal implements the encoding.Codec interface.
+func (codec) Unmarshal(data []byte, v interface{}) error {
+	if m, ok := v.(wireMessage); ok {
+		return m.unmarshal(data)
+	}
+	return encoding.GetCodec("proto").Unmarshal(data, v)
+}
+
+// appendString appends a string field, omitting it when empty as proto3 does.
+func appendString(b []byte, num protowire.Number, v string) []byte {
+	if v == "" {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, v)
+}
+
+// appendBytes appends a bytes field, omitting it when empty.
+func appendBytes(b []byte, num protowire.Number, v []byte) []byte {
+	if len(v) == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, v)
+}
+
+// appendInt appends an int32 or int64 field, omitting it when zero.
+func appendInt(b []byte, num protowire.Number, v int64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, uint64(v))
+}
+
+// appendMapEntry appends an entry of a map field, which is encoded as a repeated message with
+// the key in field 1 and the value in field 2.
+func appendMapEntry(b []byte, num protowire.Number, key string, value []byte) []byte {
+	var entry []byte
+	entry = protowire.AppendTag(entry, 1, protowire.BytesType)
+	entry = protowire.AppendString(entry, key)
+	entry = protowire.AppendTag(entry, 2, protowire.BytesType)
+	entry = protowire.AppendBytes(entry, value)
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, entry)
+}
+
+// field is a decoded field: data holds the payload of length delimited fields and n the value
+// of varint fields.
+type field struct {
+	num  protowire.Number
+	data []byte
+	n    uint64
+}
+
+// consumeFields calls fn for every varint and length delimited field of data, skipping others.
+func consumeFields(data []byte, fn func(field) error) error {
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+
+		f := field{num: num}
+		switch typ {
+		case protowire.VarintType:
+			f.n, n = protowire.ConsumeVarint(data)
+		case protowire.BytesType:
+			f.data, n = protowire.ConsumeBytes(data)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, data)
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+
+		if typ == protowire.VarintType || typ == protowire.BytesType {
+			if err := fn(f); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// consumeMapEntry decodes an entry of a map field.
+func consumeMapEntry(data []byte) (string, []byte, error) {
+	var key string
+	var value []byte
+	err := consumeFields(data, func(f field) error {
+		switch f.num {
+		case 1:
+			key = string(f.data)
+		case 2:
+			value = f.data
+		}
+		return nil
+	})
+	return key, value, err
+}
+
+// configRequest is a cfx.v1.ConfigRequest.
+type configRequest struct {
+	cfx.ConfigRequest
+}
+
+func (m *configRequest) marshal() ([]byte, error) {
+	var b []byte
+	b = appendString(b, 1, m.Environment.String())
+	b = appendString(b, 2, m.AppID)
+	b = appendString(b, 3, m.ServiceID)
+	b = appendString(b, 4, m.Region)
+	b = appendString(b, 5, m.AvailabilityZone)
+	return b, nil
+}
+
+func (m *configRequest) unmarshal(data []byte) error {
+	m.ConfigRequest = cfx.ConfigRequest{}
+	return consumeFields(data, func(f field) error {
+		switch f.num {
+		case 1:
+			m.Environment = cfx.EnvID(f.data)
+		case 2:
+			m.AppID = string(f.data)
+		case 3:
+			m.ServiceID = string(f.data)
+		case 4:
+			m.Region = string(f.data)
+		case 5:
+			m.AvailabilityZone = string(f.data)
+		}
+		return nil
+	})
+}
+
+// configResponse is a cfx.v1.ConfigResponse. The configuration is sent as CBOR, which keeps
+// integers intact and is cheaper to decode than JSON; JSON is accepted from other servers.
+type configResponse struct {
+	*cfx.ConfigResponse
+}
+
+func (m *configResponse) marshal() ([]byte, error) {
+	env, err := json.Marshal(m.Environment)
+	if err != nil {
+		return nil, fmt.Errorf("could not encode environment: %v", err)
+	}
+	config, err := cfx.EncodeCBOR(m.Config)
+	if err != nil {
+		return nil, fmt.Errorf("could not encode configuration: %v", err)
+	}
+
+	var b []byte
+	b = appendInt(b, 1, int64(m.Version))
+	b = appendBytes(b, 2, env)
+	b = appendString(b, 3, m.Fingerprint)
+	b = appendBytes(b, 5, config)
+	for _, s := range m.Sources {
+		var src []byte
+		src = appendString(src, 1, s.Name)
+		src = appendInt(src, 2, int64(s.Size))
+		src = appendString(src, 3, s.Digest)
+		b = protowire.AppendTag(b, 6, protowire.BytesType)
+		b = protowire.AppendBytes(b, src)
+	}
+	return b, nil
+}
+
+func (m *configResponse) unmarshal(data []byte) error {
+	*m.ConfigResponse = cfx.ConfigResponse{}
+	var configJSON, configCBOR []byte
+	err := consumeFields(data, func(f field) error {
+		switch f.num {
+		case 1:
+			m.Version = int(int32(f.n))
+		case 2:
+			if err := json.Unmarshal(f.data, &m.Environment); err != nil {
+				return fmt.Errorf("could not decode environment: %v", err)
+			}
+		case 3:
+			m.Fingerprint = string(f.data)
+		case 4:
+			configJSON = f.data
+		case 5:
+			configCBOR = f.data
+		case 6:
+			s := cfx.ReportSource{}
+			if err := consumeFields(f.data, func(f field) error {
+				switch f.num {
+				case 1:
+					s.Name = string(f.data)
+				case 2:
+					s.Size = int(f.n)
+				case 3:
+					s.Digest = string(f.data)
+				}
+				return nil
+			}); err != nil {
+				return err
+			}
+			m.Sources = append(m.Sources, s)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	m.Config = map[string]interface{}{}
+	switch {
+	case len(configCBOR) > 0:
+		v, err := cfx.DecodeCBOR(configCBOR)
+		if err != nil {
+			return err
+		}
+		config, ok := v.(map[string]interface{})
+		if !ok && v != nil {
+			return fmt.Errorf("configuration is a %T, not a map", v)
+		}
+		if config != nil {
+			m.Config = config
+		}
+	case len(configJSON) > 0:
+		if err := json.Unmarshal(configJSON, &m.Config); err != nil {
+			return fmt.Errorf("could not decode configuration: %v", err)
+		}
+	}
+	return nil
+}
+
+// deltaRequest is a cfx.v1.DeltaConfigRequest.
+type deltaRequest struct {
+	*cfx.DeltaConfigRequest
+}
+
+func (m *deltaRequest) marshal() ([]byte, error) {
+	req, _ := (&configRequest{m.ConfigRequest}).marshal()
+
+	var b []byte
+	b = protowire.AppendTag(b, 1, protowire.BytesType)
+	b = protowire.AppendBytes(b, req)
+	for k, v := range m.Versions {
+		b = appendMapEntry(b, 2, k, []byte(v))
+	}
+	return b, nil
+}
+
+func (m *deltaRequest) unmarshal(data []byte) error {
+	*m.DeltaConfigRequest = cfx.DeltaConfigRequest{Versions: map[string]string{}}
+	return consumeFields(data, func(f field) error {
+		switch f.num {
+		case 1:
+			req := &configRequest{}
+			if err := req.unmarshal(f.data); err != nil {
+				return err
+			}
+			m.ConfigRequest = req.ConfigRequest
+		case 2:
+			k, v, err := consumeMapEntry(f.data)
+			if err != nil {
+				return err
+			}
+			m.Versions[k] = string(v)
+		}
+		return nil
+	})
+}
+
+// deltaResponse is a cfx.v1.DeltaConfigResponse.
+type deltaResponse struct {
+	*cfx.DeltaConfigResponse
+}
+
+func (m *deltaResponse) marshal() ([]byte, error) {
+	var b []byte
+	b = appendInt(b, 1, int64(m.Version))
+	b = appendString(b, 2, m.Fingerprint)
+	for k, v := range m.Updated {
+		data, err := json.Marshal(v)
+		if err != nil {
+			return nil, fmt.Errorf("could not encode section %s: %v", k, err)
+		}
+		b = appendMapEntry(b, 3, k, data)
+	}
+	for k, v := range m.Versions {
+		b = appendMapEntry(b, 4, k, []byte(v))
+	}
+	for _, k := range m.Removed {
+		b = protowire.AppendTag(b, 5, protowire.BytesType)
+		b = protowire.AppendString(b, k)
+	}
+	return b, nil
+}
+
+func (m *deltaResponse) unmarshal(data []byte) error {
+	*m.DeltaConfigResponse = cfx.DeltaConfigResponse{
+		Updated:  map[string]interface{}{},
+		Versions: map[string]string{},
+	}
+	return consumeFields(data, func(f field) error {
+		switch f.num {
+		case 1:
+			m.Version = int(int32(f.n))
+		case 2:
+			m.Fingerprint = string(f.data)
+		case 3:
+			k, v, err := consumeMapEntry(f.data)
+			if err != nil {
+				return err
+			}
+			var section interface{}
+			if err := json.Unmarshal(v, &section); err != nil {
+				return fmt.Errorf("could not decode section %s: %v", k, err)
+			}
+			m.Updated[k] = section
+		case 4:
+			k, v, err := consumeMapEntry(f.data)
+			if err != nil {
+				return err
+			}
+			m.Versions[k] = string(v)
+		case 5:
+			m.Removed = append(m.Removed, string(f.data))
+		}
+		return nil
+	})
+}
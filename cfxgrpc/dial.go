@@ -0,0 +1,106 @@
+package cfxgrpc
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/gen0cide/cfx"
+	"go.uber.org/fx"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/backoff"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/keepalive"
+)
+
+// DialOptions returns the dial options set by cfg: transport security, the authority, message
+// size limits, keepalive pings and the default service config holding the retry policy.
+// DialTimeout bounds every connection attempt, and CallTimeout is applied to unary calls
+// whose context has no deadline. Connections are insecure unless TLS is enabled.
+func DialOptions(cfg *cfx.GRPCClientConfig) ([]grpc.DialOption, error) {
+	var opts []grpc.DialOption
+
+	if cfg.TLS.Enabled {
+		tc, err := cfg.TLS.ClientConfig()
+		if err != nil {
+			return nil, fmt.Errorf("could not configure grpc client tls: %v", err)
+		}
+		opts = append(opts, grpc.WithTransportCredentials(credentials.NewTLS(tc)))
+	} else {
+		opts = append(opts, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	}
+
+	if cfg.Authority != "" {
+		opts = append(opts, grpc.WithAuthority(cfg.Authority))
+	}
+
+	var callOpts []grpc.CallOption
+	if cfg.MaxRecvMsgSize > 0 {
+		callOpts = append(callOpts, grpc.MaxCallRecvMsgSize(cfg.MaxRecvMsgSize))
+	}
+	if cfg.MaxSendMsgSize > 0 {
+		callOpts = append(callOpts, grpc.MaxCallSendMsgSize(cfg.MaxSendMsgSize))
+	}
+	if len(callOpts) > 0 {
+		opts = append(opts, grpc.WithDefaultCallOptions(callOpts...))
+	}
+
+	if cfg.Keepalive.Time > 0 {
+		opts = append(opts, grpc.WithKeepaliveParams(keepalive.ClientParameters{
+			Time:                cfg.Keepalive.Time,
+			Timeout:             cfg.Keepalive.Timeout,
+			PermitWithoutStream: cfg.Keepalive.PermitWithoutStream,
+		}))
+	}
+
+	if cfg.DialTimeout > 0 {
+		opts = append(opts, grpc.WithConnectParams(grpc.ConnectParams{
+			Backoff:           backoff.DefaultConfig,
+			MinConnectTimeout: cfg.DialTimeout,
+		}))
+	}
+
+	if cfg.CallTimeout > 0 {
+		opts = append(opts, grpc.WithChainUnaryInterceptor(callTimeout(cfg)))
+	}
+
+	sc, err := cfg.ServiceConfigJSON()
+	if err != nil {
+		return nil, err
+	}
+	if sc != "" {
+		opts = append(opts, grpc.WithDefaultServiceConfig(sc))
+	}
+
+	return opts, nil
+}
+
+// callTimeout applies the CallTimeout of cfg to unary calls without a deadline. Streams are
+// left alone, since they are often meant to stay open.
+func callTimeout(cfg *cfx.GRPCClientConfig) grpc.UnaryClientInterceptor {
+	timeout := cfg.CallTimeout
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		if _, ok := ctx.Deadline(); !ok {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+// ProvideDialOptions returns an Fx option that provides the []grpc.DialOption built from the
+// gRPC client config section at key, named after it. Consumers can depend on it with an fx.In
+// field tagged `name:"<key>"` and pass it to grpc.NewClient along with the section's Target.
+func ProvideDialOptions(key string) fx.Option {
+	return fx.Provide(fx.Annotated{
+		Name: key,
+		Target: func(c cfx.Container) ([]grpc.DialOption, error) {
+			cfg, err := cfx.LoadGRPCClientConfig(c, key)
+			if err != nil {
+				return nil, err
+			}
+			return DialOptions(cfg)
+		},
+	})
+}
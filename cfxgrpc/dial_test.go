@@ -0,0 +1,43 @@
+package cfxgrpc
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gen0cide/cfx"
+	"google.golang.org/grpc"
+)
+
+func TestDialOptions(t *testing.T) {
+	cfg := cfx.DefaultGRPCClientConfig()
+	cfg.Target = "passthrough:///bufnet"
+	cfg.Authority = "config.internal"
+	cfg.MaxRecvMsgSize = 1 << 20
+	cfg.Retries.MaxAttempts = 3
+	cfg.Retries.InitialBackoff = 50 * time.Microsecond
+
+	opts, err := DialOptions(&cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// grpc rejects malformed default service configs when the client is created
+	cc, err := grpc.NewClient(cfg.Target, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cc.Close()
+
+	client := serve(t, configServer(t), Config{Insecure: true}, opts...)
+	if _, err := client.Fetch(context.Background(), &cfx.ConfigRequest{Environment: "production"}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDialOptionsTLSErrors(t *testing.T) {
+	cfg := cfx.DefaultGRPCClientConfig()
+	cfg.TLS = cfx.TLSConfig{Enabled: true, CAFile: "/nonexistent/ca.pem"}
+	if _, err := DialOptions(&cfg); err == nil {
+		t.Fatal("DialOptions accepted a missing CA file")
+	}
+}
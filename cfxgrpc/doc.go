@@ -0,0 +1,8 @@
+// Package cfxgrpc connects cfx to gRPC. It serves a cfx.ConfigServer as the ConfigService in
+// proto/config.proto, provides a cfx.ConfigClient calling that service, and builds dial options
+// from a cfx.GRPCClientConfig. It is a separate module so that cfx itself does not depend on
+// gRPC.
+//
+// The service's messages are encoded by hand as the messages in proto/config.proto, so the
+// service interoperates with clients and servers generated from that file in any language.
+package cfxgrpc
@@ -0,0 +1,23 @@
+module github.com/gen0cide/cfx/cfxgrpc
+
+go 1.19
+
+require (
+	github.com/gen0cide/cfx v0.1.0
+	go.uber.org/fx v1.10.0
+	google.golang.org/grpc v1.64.0
+	google.golang.org/protobuf v1.33.0
+)
+
+require (
+	github.com/denisbrodbeck/machineid v1.0.1 // indirect
+	go.uber.org/atomic v1.5.0 // indirect
+	go.uber.org/config v1.4.0 // indirect
+	go.uber.org/dig v1.8.0 // indirect
+	go.uber.org/multierr v1.4.0 // indirect
+	golang.org/x/net v0.22.0 // indirect
+	golang.org/x/sys v0.18.0 // indirect
+	golang.org/x/text v0.14.0 // indirect
+	google.golang.org/genproto/googleapis/rpc v0.0.0-20240318140521-94a12d6c2237 // indirect
+	gopkg.in/yaml.v2 v2.2.5 // indirect
+)
@@ -0,0 +1,209 @@
+package cfxgrpc
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"strings"
+
+	"github.com/gen0cide/cfx"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// ServiceName is the full name of the gRPC service defined in proto/config.proto.
+const ServiceName = "cfx.v1.ConfigService"
+
+const (
+	_fetchMethod      = "/" + ServiceName + "/Fetch"
+	_watchMethod      = "/" + ServiceName + "/Watch"
+	_watchDeltaMethod = "/" + ServiceName + "/WatchDelta"
+)
+
+// configService is implemented by *cfx.ConfigServer.
+type configService interface {
+	Fetch(ctx context.Context, req *cfx.ConfigRequest) (*cfx.ConfigResponse, error)
+	Watch(req *cfx.ConfigRequest, stream cfx.ConfigStream) error
+	WatchDelta(req *cfx.DeltaConfigRequest, stream cfx.DeltaConfigStream) error
+}
+
+var _serviceDesc = grpc.ServiceDesc{
+	ServiceName: ServiceName,
+	HandlerType: (*configService)(nil),
+	Methods: []grpc.MethodDesc{
+		{MethodName: "Fetch", Handler: fetchHandler},
+	},
+	Streams: []grpc.StreamDesc{
+		{StreamName: "Watch", Handler: watchHandler, ServerStreams: true},
+		{StreamName: "WatchDelta", Handler: watchDeltaHandler, ServerStreams: true},
+	},
+	Metadata: "proto/config.proto",
+}
+
+// ServerOption makes a grpc.Server encode the ConfigService messages. Servers passed to
+// Register must be created with it; other services on the server are unaffected.
+func ServerOption() grpc.ServerOption {
+	return grpc.ForceServerCodec(codec{})
+}
+
+// Register registers cs as the ConfigService on s, which must be created with ServerOption.
+func Register(s *grpc.Server, cs *cfx.ConfigServer) {
+	s.RegisterService(&_serviceDesc, cs)
+}
+
+// Config configures the server created by NewServer.
+type Config struct {
+	// TLS is the server's TLS configuration. Setting ClientAuth to "require_and_verify" along
+	// with a CA authenticates clients by their certificate.
+	TLS cfx.TLSConfig `json:"tls,omitempty" yaml:"tls,omitempty" mapstructure:"tls,omitempty"`
+
+	// Tokens are bearer tokens clients can authenticate with, see TokenCredentials.
+	Tokens []string `json:"tokens,omitempty" yaml:"tokens,omitempty" mapstructure:"tokens,omitempty"`
+
+	// Insecure allows serving without TLS and client authentication. Only use it for servers
+	// listening on the loopback interface.
+	Insecure bool `json:"insecure,omitempty" yaml:"insecure,omitempty" mapstructure:"insecure,omitempty"`
+}
+
+// Validate implements the cfx.Validator interface.
+func (c Config) Validate() error {
+	if err := c.TLS.Validate(); err != nil {
+		return err
+	}
+	if c.Insecure {
+		return nil
+	}
+	if !c.TLS.Enabled {
+		return errors.New("config service requires tls unless insecure is set")
+	}
+	if len(c.Tokens) == 0 && c.TLS.ClientAuth != "require_and_verify" {
+		return errors.New("config service requires tokens or client certificates unless insecure is set")
+	}
+	return nil
+}
+
+// NewServer creates a grpc.Server serving cs as the ConfigService, secured as cfg describes.
+func NewServer(cs *cfx.ConfigServer, cfg Config, opts ...grpc.ServerOption) (*grpc.Server, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	opts = append([]grpc.ServerOption{ServerOption()}, opts...)
+	tc, err := cfg.TLS.ServerConfig()
+	if err != nil {
+		return nil, err
+	}
+	if tc != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tc)))
+	}
+	if len(cfg.Tokens) > 0 {
+		auth := tokenAuth(cfg.Tokens)
+		opts = append(opts,
+			grpc.ChainUnaryInterceptor(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+				if err := auth(ctx); err != nil {
+					return nil, err
+				}
+				return handler(ctx, req)
+			}),
+			grpc.ChainStreamInterceptor(func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+				if err := auth(ss.Context()); err != nil {
+					return err
+				}
+				return handler(srv, ss)
+			}),
+		)
+	}
+
+	s := grpc.NewServer(opts...)
+	Register(s, cs)
+	return s, nil
+}
+
+// tokenAuth returns a check that the call carries one of tokens as a bearer token.
+func tokenAuth(tokens []string) func(context.Context) error {
+	return func(ctx context.Context) error {
+		md, _ := metadata.FromIncomingContext(ctx)
+		for _, v := range md.Get("authorization") {
+			got := strings.TrimPrefix(v, "Bearer ")
+			for _, t := range tokens {
+				if t != "" && subtle.ConstantTimeCompare([]byte(got), []byte(t)) == 1 {
+					return nil
+				}
+			}
+		}
+		return status.Error(codes.Unauthenticated, "missing or invalid bearer token")
+	}
+}
+
+// statusError converts an error returned by a cfx.ConfigServer to a gRPC status.
+func statusError(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, cfx.ErrConfigRequestNotAllowed):
+		return status.Error(codes.PermissionDenied, err.Error())
+	case errors.Is(err, cfx.ErrTooManyConfigRequests):
+		return status.Error(codes.ResourceExhausted, err.Error())
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return status.FromContextError(err).Err()
+	}
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	return status.Error(codes.FailedPrecondition, err.Error())
+}
+
+func fetchHandler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := &configRequest{}
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	call := func(ctx context.Context, req interface{}) (interface{}, error) {
+		resp, err := srv.(configService).Fetch(ctx, &req.(*configRequest).ConfigRequest)
+		if err != nil {
+			return nil, statusError(err)
+		}
+		return &configResponse{resp}, nil
+	}
+	if interceptor == nil {
+		return call(ctx, in)
+	}
+	return interceptor(ctx, in, &grpc.UnaryServerInfo{Server: srv, FullMethod: _fetchMethod}, call)
+}
+
+func watchHandler(srv interface{}, stream grpc.ServerStream) error {
+	in := &configRequest{}
+	if err := stream.RecvMsg(in); err != nil {
+		return err
+	}
+	return statusError(srv.(configService).Watch(&in.ConfigRequest, watchServerStream{stream}))
+}
+
+func watchDeltaHandler(srv interface{}, stream grpc.ServerStream) error {
+	in := &deltaRequest{&cfx.DeltaConfigRequest{}}
+	if err := stream.RecvMsg(in); err != nil {
+		return err
+	}
+	return statusError(srv.(configService).WatchDelta(in.DeltaConfigRequest, watchDeltaServerStream{stream}))
+}
+
+// watchServerStream adapts a grpc.ServerStream to cfx.ConfigStream.
+type watchServerStream struct {
+	grpc.ServerStream
+}
+
+func (s watchServerStream) Send(resp *cfx.ConfigResponse) error {
+	return s.SendMsg(&configResponse{resp})
+}
+
+// watchDeltaServerStream adapts a grpc.ServerStream to cfx.DeltaConfigStream.
+type watchDeltaServerStream struct {
+	grpc.ServerStream
+}
+
+func (s watchDeltaServerStream) Send(resp *cfx.DeltaConfigResponse) error {
+	return s.SendMsg(&deltaResponse{resp})
+}
@@ -0,0 +1,407 @@
+package cfx
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ChaosKey is the config section the chaos harness writes and reads.
+const ChaosKey = "chaos"
+
+// ChaosMutation is a way the chaos harness damages the configuration file under test.
+type ChaosMutation string
+
+// Chaos mutations.
+const (
+	// ChaosValidWrite atomically replaces the file with a new valid generation.
+	ChaosValidWrite ChaosMutation = "valid_write"
+
+	// ChaosPartialWrite writes a new valid generation in place in two halves, pausing between
+	// them, so the watcher can observe the first half alone.
+	ChaosPartialWrite ChaosMutation = "partial_write"
+
+	// ChaosTruncate truncates the file to a random length, possibly zero.
+	ChaosTruncate ChaosMutation = "truncate"
+
+	// ChaosInvalidYAML replaces the file with content that is not valid YAML.
+	ChaosInvalidYAML ChaosMutation = "invalid_yaml"
+
+	// ChaosPermissionFlip makes the file unreadable for a moment. It has no effect when the
+	// process runs as root.
+	ChaosPermissionFlip ChaosMutation = "permission_flip"
+)
+
+// _chaosMutations are the mutations applied when ChaosConfig lists none.
+var _chaosMutations = []ChaosMutation{
+	ChaosValidWrite,
+	ChaosPartialWrite,
+	ChaosTruncate,
+	ChaosInvalidYAML,
+	ChaosPermissionFlip,
+}
+
+// ChaosConfig configures a run of Chaos.
+type ChaosConfig struct {
+	// Dir is the config directory the harness writes to. It is created if needed, and its
+	// base.yaml and chaos.yaml files are overwritten. When empty, a temporary directory is used
+	// and removed afterwards.
+	Dir string
+
+	// Duration is how long mutations are applied for. Defaults to ten seconds.
+	Duration time.Duration
+
+	// Interval is the hot reload interval of the container under test. Defaults to 10ms.
+	Interval time.Duration
+
+	// MutationInterval is the pause between mutations. Defaults to Interval.
+	MutationInterval time.Duration
+
+	// Mutations are picked from at random. Defaults to every mutation.
+	Mutations []ChaosMutation
+
+	// Seed seeds the choice of mutations, so that a failing run can be repeated. Defaults to
+	// the current time; the seed used is recorded in the report.
+	Seed int64
+
+	// Options are applied to the container under test, for example WithLastKnownGood.
+	Options []Option
+}
+
+// ChaosReport describes a run of Chaos.
+type ChaosReport struct {
+	Seed int64 `json:"seed" yaml:"seed"`
+
+	// Mutations counts the mutations applied, by kind.
+	Mutations map[ChaosMutation]int `json:"mutations" yaml:"mutations"`
+
+	// Generations is the number of valid configurations written.
+	Generations int `json:"generations" yaml:"generations"`
+
+	// Reads is the number of times the configuration was read while mutations were applied.
+	Reads int `json:"reads" yaml:"reads"`
+
+	// Reloads and RejectedReloads count the reload attempts that succeeded and failed.
+	Reloads         int `json:"reloads" yaml:"reloads"`
+	RejectedReloads int `json:"rejected_reloads" yaml:"rejected_reloads"`
+
+	// Violations describes every read that returned a configuration that was never written
+	// whole, or one older than a configuration read before it, and a container that did not
+	// converge on the last valid generation once mutations stopped.
+	Violations []string `json:"violations,omitempty" yaml:"violations,omitempty"`
+}
+
+// OK reports whether the run found no violations.
+func (r ChaosReport) OK() bool {
+	return len(r.Violations) == 0
+}
+
+// String implements the fmt.Stringer interface.
+func (r ChaosReport) String() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "seed %d: %d generations, %d reads, %d reloads, %d rejected\n", r.Seed, r.Generations, r.Reads, r.Reloads, r.RejectedReloads)
+	for _, m := range _chaosMutations {
+		if n := r.Mutations[m]; n > 0 {
+			fmt.Fprintf(&sb, "  %-16s %d\n", m, n)
+		}
+	}
+	for _, v := range r.Violations {
+		fmt.Fprintf(&sb, "violation: %s\n", v)
+	}
+	return sb.String()
+}
+
+// chaosDoc is the content of the "chaos" section. Its checksum covers the generation and
+// payload, so that a configuration assembled from parts of several writes is detected.
+type chaosDoc struct {
+	Generation int    `json:"generation" yaml:"generation"`
+	Payload    string `json:"payload" yaml:"payload"`
+	Checksum   string `json:"checksum" yaml:"checksum"`
+}
+
+// Validate implements the cfx.Validator interface, rejecting damaged configurations the way an
+// application's own sections reject invalid ones.
+func (d chaosDoc) Validate() error {
+	if d.Generation <= 0 {
+		return errors.New("chaos generation is missing")
+	}
+	if d.Checksum != chaosChecksum(d.Generation, d.Payload) {
+		return fmt.Errorf("chaos generation %d does not match its checksum", d.Generation)
+	}
+	return nil
+}
+
+func chaosChecksum(generation int, payload string) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%d:%s", generation, payload)))
+	return hex.EncodeToString(sum[:])
+}
+
+// Chaos soaks the hot reload path. It runs a Container with hot reload over a config directory
+// and, for the configured duration, rapidly damages its configuration file with partial writes,
+// truncation, invalid YAML and permission flips, interleaved with valid writes, while reading
+// the configuration continuously. The container validates a checksummed "chaos" section, as
+// an application validates its own sections, so every damaged file must be rejected: any read
+// that returns a configuration that was never written whole, or an older one than was already
+// served, is a violation. After the last mutation the container must converge on the last
+// valid configuration. Run it from a test:
+//
+//	report, err := cfx.Chaos(ctx, cfx.ChaosConfig{Duration: 5 * time.Second})
+//	if err != nil || !report.OK() {
+//		t.Fatal(err, report)
+//	}
+//
+// The returned error reports problems running the harness; violations are in the report.
+func Chaos(ctx context.Context, cfg ChaosConfig) (*ChaosReport, error) {
+	if cfg.Duration <= 0 {
+		cfg.Duration = 10 * time.Second
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = 10 * time.Millisecond
+	}
+	if cfg.MutationInterval <= 0 {
+		cfg.MutationInterval = cfg.Interval
+	}
+	if len(cfg.Mutations) == 0 {
+		cfg.Mutations = _chaosMutations
+	}
+	if cfg.Seed == 0 {
+		cfg.Seed = time.Now().UnixNano()
+	}
+
+	if cfg.Dir == "" {
+		dir, err := ioutil.TempDir("", "cfx-chaos-")
+		if err != nil {
+			return nil, err
+		}
+		defer os.RemoveAll(dir)
+		cfg.Dir = dir
+	} else if err := os.MkdirAll(cfg.Dir, 0755); err != nil {
+		return nil, err
+	}
+
+	h := &chaosHarness{
+		rng:    rand.New(rand.NewSource(cfg.Seed)),
+		path:   filepath.Join(cfg.Dir, "chaos.yaml"),
+		report: &ChaosReport{Seed: cfg.Seed, Mutations: map[ChaosMutation]int{}},
+	}
+	if err := ioutil.WriteFile(filepath.Join(cfg.Dir, "base.yaml"), []byte("chaos_base: true\n"), 0644); err != nil {
+		return nil, err
+	}
+	if err := h.writeValid(false); err != nil {
+		return nil, err
+	}
+
+	opts := append(append([]Option{}, cfg.Options...),
+		WithHotReload(cfg.Interval),
+		WithSections(Section(ChaosKey, &chaosDoc{})),
+		WithReloadObserver(h.observe),
+	)
+	c, err := NewConfigWithOptions(EnvContext{Environment: "chaos", ConfigPath: cfg.Dir}, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("could not create the container under test: %v", err)
+	}
+	if y, ok := c.(*yamlContainer); ok {
+		defer closePlugins(y.opts.plugins)
+	}
+
+	wctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		Watch(wctx, c)
+	}()
+	go func() {
+		defer wg.Done()
+		h.read(wctx, c)
+	}()
+
+	deadline := time.Now().Add(cfg.Duration)
+	var runErr error
+	for time.Now().Before(deadline) && ctx.Err() == nil {
+		m := cfg.Mutations[h.rng.Intn(len(cfg.Mutations))]
+		if runErr = h.mutate(m, cfg.MutationInterval); runErr != nil {
+			break
+		}
+		h.count(m)
+		time.Sleep(cfg.MutationInterval)
+	}
+
+	// finish with a valid write the container must converge on
+	if runErr == nil {
+		runErr = h.writeValid(false)
+	}
+	if runErr == nil {
+		h.converge(ctx, c, 50*cfg.Interval+time.Second)
+	}
+	cancel()
+	wg.Wait()
+	return h.snapshot(), runErr
+}
+
+// chaosHarness holds the state of a Chaos run.
+type chaosHarness struct {
+	rng  *rand.Rand
+	path string
+
+	mu         sync.Mutex
+	report     *ChaosReport
+	generation int
+	served     int
+}
+
+func (h *chaosHarness) observe(ev ReloadEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if ev.Err != nil {
+		h.report.RejectedReloads++
+	} else {
+		h.report.Reloads++
+	}
+}
+
+func (h *chaosHarness) count(m ChaosMutation) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.report.Mutations[m]++
+}
+
+func (h *chaosHarness) violation(format string, args ...interface{}) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.report.Violations = append(h.report.Violations, fmt.Sprintf(format, args...))
+}
+
+func (h *chaosHarness) snapshot() *ChaosReport {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	r := *h.report
+	return &r
+}
+
+// nextDoc encodes the next valid generation.
+func (h *chaosHarness) nextDoc() []byte {
+	h.mu.Lock()
+	h.generation++
+	gen := h.generation
+	h.report.Generations++
+	h.mu.Unlock()
+
+	b := make([]byte, 256+h.rng.Intn(4096))
+	h.rng.Read(b)
+	payload := hex.EncodeToString(b)
+	return []byte(fmt.Sprintf("%s:\n  generation: %d\n  payload: %s\n  checksum: %s\n", ChaosKey, gen, payload, chaosChecksum(gen, payload)))
+}
+
+// writeValid writes the next generation, atomically or in place.
+func (h *chaosHarness) writeValid(inPlace bool) error {
+	data := h.nextDoc()
+	if inPlace {
+		return ioutil.WriteFile(h.path, data, 0644)
+	}
+	tmp := h.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, h.path)
+}
+
+func (h *chaosHarness) mutate(m ChaosMutation, pause time.Duration) error {
+	switch m {
+	case ChaosValidWrite:
+		return h.writeValid(false)
+	case ChaosPartialWrite:
+		data := h.nextDoc()
+		half := len(data) / 2
+		if err := ioutil.WriteFile(h.path, data[:half], 0644); err != nil {
+			return err
+		}
+		time.Sleep(pause)
+		f, err := os.OpenFile(h.path, os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return err
+		}
+		_, err = f.Write(data[half:])
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		return err
+	case ChaosTruncate:
+		fi, err := os.Stat(h.path)
+		if err != nil {
+			return err
+		}
+		size := int64(0)
+		if fi.Size() > 0 {
+			size = h.rng.Int63n(fi.Size())
+		}
+		return os.Truncate(h.path, size)
+	case ChaosInvalidYAML:
+		return ioutil.WriteFile(h.path, []byte(ChaosKey+":\n  generation: [1\n\tpayload: {\n"), 0644)
+	case ChaosPermissionFlip:
+		if err := os.Chmod(h.path, 0); err != nil {
+			return err
+		}
+		time.Sleep(pause)
+		return os.Chmod(h.path, 0644)
+	}
+	return fmt.Errorf("unknown chaos mutation %q", m)
+}
+
+// read reads the chaos section until ctx is done, checking every read.
+func (h *chaosHarness) read(ctx context.Context, c Container) {
+	for ctx.Err() == nil {
+		h.check(c)
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// check reads the chaos section once, returning the generation served.
+func (h *chaosHarness) check(c Container) int {
+	var doc chaosDoc
+	err := c.Populate(ChaosKey, &doc)
+
+	h.mu.Lock()
+	h.report.Reads++
+	last := h.served
+	if err == nil && doc.Generation > h.served {
+		h.served = doc.Generation
+	}
+	h.mu.Unlock()
+
+	switch {
+	case err != nil:
+		h.violation("reading the configuration failed: %v", err)
+	case doc.Validate() != nil:
+		h.violation("served a damaged configuration: %v", doc.Validate())
+	case doc.Generation < last:
+		h.violation("served generation %d after generation %d", doc.Generation, last)
+	}
+	return doc.Generation
+}
+
+// converge waits for the container to serve the last generation written.
+func (h *chaosHarness) converge(ctx context.Context, c Container, timeout time.Duration) {
+	h.mu.Lock()
+	want := h.generation
+	h.mu.Unlock()
+
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) && ctx.Err() == nil {
+		if h.check(c) == want {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	h.violation("did not converge on generation %d within %s", want, timeout)
+}
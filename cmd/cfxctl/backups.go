@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gen0cide/cfx"
+)
+
+func runBackups(args []string) error {
+	fs := flag.NewFlagSet("backups", flag.ContinueOnError)
+	restore := fs.Int("restore", 0, "restore the n-th newest backup, starting at 1")
+	keep := fs.Int("keep", 5, "backups to keep when restoring")
+	format := fs.String("format", "text", "output format: text or json")
+
+	// allow the file to come before the flags
+	path := ""
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		path, args = args[0], args[1:]
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if path == "" && fs.NArg() > 0 {
+		path = fs.Arg(0)
+	}
+	if path == "" {
+		return errors.New("usage: cfxctl backups <config-file> [-restore <n>]")
+	}
+
+	backups, err := cfx.ListBackups(path)
+	if err != nil {
+		return err
+	}
+
+	if *restore > 0 {
+		if *restore > len(backups) {
+			return fmt.Errorf("%s has %d backups", path, len(backups))
+		}
+		b := backups[*restore-1]
+		if err := cfx.RestoreBackup(b, *keep); err != nil {
+			return err
+		}
+		fmt.Printf("restored %s from %s\n", path, b.Path)
+		return nil
+	}
+
+	switch *format {
+	case "text":
+		for i, b := range backups {
+			fmt.Printf("%3d  %s  %8d  %s\n", i+1, b.Time.Local().Format(time.RFC3339), b.Size, b.Path)
+		}
+	case "json":
+		if backups == nil {
+			backups = []cfx.ConfigBackup{}
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(backups)
+	default:
+		return fmt.Errorf("unknown format %q", *format)
+	}
+	return nil
+}
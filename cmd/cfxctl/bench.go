@@ -0,0 +1,72 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/gen0cide/cfx/benchmark"
+)
+
+func runBench(args []string) error {
+	fs := flag.NewFlagSet("bench", flag.ContinueOnError)
+	sizes := fs.String("sizes", "small,medium,large", "comma separated fixture sizes to run")
+	baseline := fs.String("baseline", "", "results file to compare against")
+	write := fs.String("write", "", "write the results to this file")
+	timeT := fs.Float64("time-threshold", benchmark.DefaultThresholds.Time, "allowed ns/op increase over the baseline, as a fraction")
+	allocsT := fs.Float64("allocs-threshold", benchmark.DefaultThresholds.Allocs, "allowed allocs/op increase over the baseline, as a fraction")
+	bytesT := fs.Float64("bytes-threshold", benchmark.DefaultThresholds.Bytes, "allowed B/op increase over the baseline, as a fraction")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	var selected []benchmark.Size
+	for _, name := range strings.Split(*sizes, ",") {
+		switch strings.TrimSpace(name) {
+		case benchmark.Small.Name:
+			selected = append(selected, benchmark.Small)
+		case benchmark.Medium.Name:
+			selected = append(selected, benchmark.Medium)
+		case benchmark.Large.Name:
+			selected = append(selected, benchmark.Large)
+		default:
+			return fmt.Errorf("unknown size %q", name)
+		}
+	}
+
+	var base []benchmark.Result
+	if *baseline != "" {
+		var err error
+		if base, err = benchmark.ReadResults(*baseline); err != nil {
+			return err
+		}
+	}
+
+	// testing.Benchmark reads the test flags, which are only registered by testing.Init
+	testing.Init()
+
+	results := benchmark.Run(benchmark.Suite(selected...))
+	for _, r := range results {
+		fmt.Println(r)
+	}
+
+	if *write != "" {
+		if err := benchmark.WriteResults(*write, results); err != nil {
+			return err
+		}
+	}
+
+	if base == nil {
+		return nil
+	}
+	regressions := benchmark.Compare(base, results, benchmark.Thresholds{Time: *timeT, Allocs: *allocsT, Bytes: *bytesT})
+	for _, r := range regressions {
+		fmt.Fprintln(os.Stderr, r)
+	}
+	if len(regressions) > 0 {
+		return fmt.Errorf("%d benchmark regressions against %s", len(regressions), *baseline)
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gen0cide/cfx"
+)
+
+func runBundle(args []string) error {
+	fs := flag.NewFlagSet("bundle", flag.ContinueOnError)
+	var envs stringList
+	fs.Var(&envs, "env", "environment to package (repeatable, default all files)")
+	out := fs.String("o", "", "path of the bundle to write (required)")
+	version := fs.String("version", "", "version recorded in the bundle manifest")
+	naming := fs.String("naming", "", "naming pattern of the config files, such as app.<env>.yaml")
+
+	// allow the config directory to come before the flags
+	dir := ""
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		dir, args = args[0], args[1:]
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if dir == "" && fs.NArg() > 0 {
+		dir = fs.Arg(0)
+	}
+	if dir == "" {
+		return errors.New("usage: cfxctl bundle <config-dir> [-env <env>]... -o <bundle.tgz>")
+	}
+	if *out == "" {
+		return errors.New("-o is required")
+	}
+
+	ids := make([]cfx.EnvID, 0, len(envs))
+	for _, e := range envs {
+		id, err := cfx.ParseEnv(e)
+		if err != nil {
+			return err
+		}
+		ids = append(ids, id)
+	}
+
+	strategy := cfx.DefaultNaming
+	if *naming != "" {
+		if strings.Count(*naming, cfx.EnvPlaceholder) != 1 {
+			return fmt.Errorf("-naming must contain %s exactly once", cfx.EnvPlaceholder)
+		}
+		strategy = cfx.PatternNaming(*naming)
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(*out), ".cfx-bundle-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	manifest, err := cfx.WriteBundleWithNaming(tmp, dir, strategy, *version, ids...)
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), *out); err != nil {
+		return err
+	}
+
+	b, err := cfx.LoadBundle(*out)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("wrote %s (%d files, sha256 %s)\n", *out, len(manifest.Files), b.Digest())
+	return nil
+}
+
+// stringList is a repeatable string flag.
+type stringList []string
+
+func (s *stringList) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringList) Set(v string) error {
+	*s = append(*s, v)
+	return nil
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/gen0cide/cfx"
+)
+
+func runCompat(args []string) error {
+	fs := flag.NewFlagSet("compat", flag.ContinueOnError)
+	envName := fs.String("env", "", "environment to load (default development)")
+	format := fs.String("format", "text", "output format: text or json")
+
+	// allow the config directory to come before the flags
+	dir := ""
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		dir, args = args[0], args[1:]
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if dir == "" && fs.NArg() > 0 {
+		dir = fs.Arg(0)
+	}
+	if dir == "" {
+		return errors.New("usage: cfxctl compat <config-dir> [-env <environment>]")
+	}
+
+	env, err := cfx.ParseEnv(*envName)
+	if err != nil {
+		return err
+	}
+	report, err := cfx.CompareWithUberConfig(cfx.EnvContext{Environment: env, ConfigPath: dir})
+	if err != nil {
+		return err
+	}
+
+	switch *format {
+	case "text":
+		fmt.Print(report)
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(report); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown format %q", *format)
+	}
+
+	if !report.Equivalent() {
+		return errors.New("cfx and go.uber.org/config disagree")
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/gen0cide/cfx"
+)
+
+func runDocs(args []string) error {
+	fs := flag.NewFlagSet("docs", flag.ContinueOnError)
+	dir := fs.String("dir", ".", "directory of the Go package that registers the config sections")
+	catalog := fs.String("catalog", "", "render an existing JSON catalog instead of extracting one")
+	format := fs.String("format", "markdown", "output format: markdown or json")
+	out := fs.String("out", "", "file to write to instead of stdout")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	var cat *cfx.ConfigCatalog
+	var err error
+	if *catalog != "" {
+		f, ferr := os.Open(*catalog)
+		if ferr != nil {
+			return ferr
+		}
+		cat, err = cfx.ReadConfigCatalog(f)
+		f.Close()
+	} else {
+		cat, err = cfx.ExtractConfigCatalog(*dir)
+	}
+	if err != nil {
+		return err
+	}
+
+	var w io.Writer = os.Stdout
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	switch *format {
+	case "markdown":
+		return cfx.WriteConfigCatalogMarkdown(w, cat)
+	case "json":
+		return cfx.WriteConfigCatalogJSON(w, cat)
+	default:
+		return fmt.Errorf("unknown format %q", *format)
+	}
+}
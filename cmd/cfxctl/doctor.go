@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/gen0cide/cfx"
+)
+
+func runDoctor(args []string) error {
+	fs := flag.NewFlagSet("doctor", flag.ContinueOnError)
+	ntp := fs.String("ntp", "", "NTP server to check clock skew against, e.g. pool.ntp.org")
+	skew := fs.Duration("max-skew", 0, "largest clock offset that passes (default 1s)")
+	timeout := fs.Duration("timeout", 0, "timeout of each check (default 5s)")
+	format := fs.String("format", "text", "output format: text or json")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	report := cfx.Doctor(context.Background(), cfx.DoctorConfig{
+		NTPServer:    *ntp,
+		MaxClockSkew: *skew,
+		Timeout:      *timeout,
+	})
+
+	switch *format {
+	case "text":
+		fmt.Print(report)
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(report); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown format %q", *format)
+	}
+
+	if !report.Healthy() {
+		return errors.New("environment checks failed")
+	}
+	return nil
+}
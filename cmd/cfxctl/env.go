@@ -0,0 +1,58 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/gen0cide/cfx"
+)
+
+func runEnv(args []string) error {
+	fs := flag.NewFlagSet("env", flag.ContinueOnError)
+	prefix := fs.String("prefix", "", "env var prefix of the application (default CFX)")
+	configDir := fs.String("config-dir", "", "config directory to scan for ${VAR} references")
+	format := fs.String("format", "markdown", "output format: markdown or json")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	p, err := cfx.ParseEnvKeyPrefix(*prefix)
+	if err != nil {
+		return err
+	}
+
+	docs := cfx.EnvManifest(p)
+	if *configDir != "" {
+		refs, err := cfx.ScanEnvReferences(*configDir)
+		if err != nil {
+			return err
+		}
+		docs = append(docs, refs...)
+	}
+	docs = dedupeEnvDocs(docs)
+
+	switch *format {
+	case "markdown", "md":
+		return cfx.WriteEnvManifestMarkdown(os.Stdout, docs)
+	case "json":
+		return cfx.WriteEnvManifestJSON(os.Stdout, docs)
+	default:
+		return fmt.Errorf("unknown format %q", *format)
+	}
+}
+
+// dedupeEnvDocs drops variables listed more than once, such as a cfx variable that is also
+// referenced from the config files, keeping the first and most descriptive entry.
+func dedupeEnvDocs(docs []cfx.EnvVarDoc) []cfx.EnvVarDoc {
+	seen := map[string]bool{}
+	ret := docs[:0]
+	for _, d := range docs {
+		if seen[d.Name] {
+			continue
+		}
+		seen[d.Name] = true
+		ret = append(ret, d)
+	}
+	return ret
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/gen0cide/cfx"
+)
+
+func runKeychain(args []string) error {
+	fs := flag.NewFlagSet("keychain", flag.ContinueOnError)
+	fromStdin := fs.Bool("stdin", false, "with set, read the secret from standard input instead of prompting")
+
+	// allow the action and path to come before the flags
+	var pos []string
+	for len(args) > 0 && !strings.HasPrefix(args[0], "-") && len(pos) < 2 {
+		pos, args = append(pos, args[0]), args[1:]
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	pos = append(pos, fs.Args()...)
+	if len(pos) != 2 {
+		return errors.New("usage: cfxctl keychain get|set|delete <service>/<account> [-stdin]")
+	}
+
+	service, account, err := cfx.SplitKeychainPath(pos[1])
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+
+	switch pos[0] {
+	case "get":
+		val, err := cfx.ReadKeychain(ctx, service, account)
+		if err != nil {
+			return err
+		}
+		fmt.Println(val)
+	case "set":
+		secret, err := readSecret(pos[1], *fromStdin)
+		if err != nil {
+			return err
+		}
+		if err := cfx.WriteKeychain(ctx, service, account, secret); err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "stored %s, reference it as ${keychain:%s}\n", pos[1], pos[1])
+	case "delete":
+		return cfx.DeleteKeychain(ctx, service, account)
+	default:
+		return fmt.Errorf("unknown action %q, expected get, set or delete", pos[0])
+	}
+	return nil
+}
+
+// readSecret reads the secret to store, prompting without echo unless fromStdin is set.
+func readSecret(path string, fromStdin bool) (string, error) {
+	if fromStdin {
+		data, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimRight(string(data), "\r\n"), nil
+	}
+
+	fmt.Fprintf(os.Stderr, "secret for %s: ", path)
+	secret, err := cfx.ReadPassword(os.Stdin)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", fmt.Errorf("could not read the secret, use -stdin when not at a terminal: %v", err)
+	}
+	if secret == "" {
+		return "", errors.New("no secret given")
+	}
+	return secret, nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/gen0cide/cfx"
+)
+
+func runLint(args []string) error {
+	fs := flag.NewFlagSet("lint", flag.ContinueOnError)
+	envName := fs.String("env", "", "environment to load (default development)")
+	maxDepth := fs.Int("max-depth", cfx.DefaultLintConfig().MaxDepth, "deepest a key may be nested, 0 for unlimited")
+	snakeCase := fs.Bool("snake-case", true, "require snake_case keys")
+	duplicates := fs.Bool("duplicates", true, "reject sibling keys that only differ by a unit suffix")
+	ignore := fs.String("ignore", "", "comma separated key patterns to skip, e.g. feature_flags.*")
+	format := fs.String("format", "text", "output format: text or json")
+
+	// allow the config directory to come before the flags
+	dir := ""
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		dir, args = args[0], args[1:]
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if dir == "" && fs.NArg() > 0 {
+		dir = fs.Arg(0)
+	}
+	if dir == "" {
+		return errors.New("usage: cfxctl lint <config-dir> [-env <environment>]")
+	}
+
+	cfg := cfx.LintConfig{SnakeCase: *snakeCase, MaxDepth: *maxDepth, SemanticDuplicates: *duplicates}
+	if *ignore != "" {
+		cfg.Ignore = strings.Split(*ignore, ",")
+	}
+
+	env, err := cfx.ParseEnv(*envName)
+	if err != nil {
+		return err
+	}
+	c, err := cfx.NewConfigWithOptions(cfx.EnvContext{Environment: env, ConfigPath: dir})
+	if err != nil {
+		return err
+	}
+	var findings cfx.LintFindings
+	if err := cfx.CheckNaming(c, cfg); err != nil {
+		var ok bool
+		if findings, ok = err.(cfx.LintFindings); !ok {
+			return err
+		}
+	}
+
+	switch *format {
+	case "text":
+		for _, f := range findings {
+			fmt.Printf("%-18s %s: %s\n", f.Rule, f.Key, f.Message)
+		}
+	case "json":
+		if findings == nil {
+			findings = cfx.LintFindings{}
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(findings); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown format %q", *format)
+	}
+
+	if len(findings) > 0 {
+		return fmt.Errorf("%d keys break naming rules", len(findings))
+	}
+	return nil
+}
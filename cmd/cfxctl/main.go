@@ -0,0 +1,57 @@
+// Command cfxctl is a companion tool for applications configured with cfx.
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+type command struct {
+	name  string
+	usage string
+	run   func(args []string) error
+}
+
+var commands = []command{
+	{name: "env", usage: "document the environment variables an application reads", run: runEnv},
+	{name: "bundle", usage: "package a config directory into a bundle archive", run: runBundle},
+	{name: "serve", usage: "serve a config directory to cfx clients over HTTP", run: runServe},
+	{name: "bench", usage: "benchmark cfx and compare against a baseline", run: runBench},
+	{name: "doctor", usage: "check the environment for common problems", run: runDoctor},
+	{name: "docs", usage: "generate a config reference from struct comments", run: runDocs},
+	{name: "compat", usage: "compare a config directory under cfx and go.uber.org/config", run: runCompat},
+	{name: "lint", usage: "check config keys against naming rules", run: runLint},
+	{name: "backups", usage: "list or restore backups of a config file", run: runBackups},
+	{name: "keychain", usage: "store secrets in the OS keychain", run: runKeychain},
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: cfxctl <command> [flags]")
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "commands:")
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", c.name, c.usage)
+	}
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	for _, c := range commands {
+		if c.name != os.Args[1] {
+			continue
+		}
+		if err := c.run(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "cfxctl %s: %v\n", c.name, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	fmt.Fprintf(os.Stderr, "cfxctl: unknown command %q\n\n", os.Args[1])
+	usage()
+	os.Exit(2)
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gen0cide/cfx"
+)
+
+func runServe(args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+	addr := fs.String("listen", "127.0.0.1:8480", "address to serve configuration on")
+	interval := fs.Duration("interval", 5*time.Second, "how often to check the config directory for changes")
+	hierarchy := fs.Bool("key-hierarchy", false, "apply app and service specific overrides")
+	certFile := fs.String("tls-cert", "", "certificate to serve TLS with")
+	keyFile := fs.String("tls-key", "", "key of the -tls-cert certificate")
+	clientCA := fs.String("client-ca", "", "require client certificates signed by this CA bundle")
+	tokenFile := fs.String("token-file", "", "require a bearer token listed in this file, one per line")
+	maxEntries := fs.Int("max-entries", cfx.DefaultServerEntryLimit, "how many distinct config requests to serve at once")
+
+	// allow the config directory to come before the flags
+	dir := ""
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		dir, args = args[0], args[1:]
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if dir == "" && fs.NArg() > 0 {
+		dir = fs.Arg(0)
+	}
+	if dir == "" {
+		return errors.New("usage: cfxctl serve <config-dir> [-listen <addr>] [-tls-cert <file> -tls-key <file>] [-client-ca <file>] [-token-file <file>]")
+	}
+
+	secure := *certFile != "" && *keyFile != ""
+	authenticated := *tokenFile != "" || *clientCA != ""
+	if !isLoopback(*addr) && (!secure || !authenticated) {
+		return fmt.Errorf("%s is not a loopback address: serving configuration on it requires -tls-cert, -tls-key and either -client-ca or -token-file", *addr)
+	}
+	if *clientCA != "" && !secure {
+		return errors.New("-client-ca requires -tls-cert and -tls-key")
+	}
+
+	opts := []cfx.Option{cfx.WithHotReload(*interval), cfx.WithServerEntryLimit(*maxEntries)}
+	if *hierarchy {
+		opts = append(opts, cfx.WithKeyHierarchy())
+	}
+
+	srv := cfx.NewConfigServer(cfx.EnvContext{ConfigPath: dir}, opts...)
+	defer srv.Close()
+
+	var handler http.Handler = srv
+	if *tokenFile != "" {
+		tokens, err := readTokens(*tokenFile)
+		if err != nil {
+			return err
+		}
+		handler = cfx.RequireBearerToken(handler, tokens...)
+	}
+
+	hs := &http.Server{Addr: *addr, Handler: handler, ReadHeaderTimeout: 10 * time.Second}
+	fmt.Fprintf(os.Stderr, "serving configuration from %s on %s\n", dir, *addr)
+	if !secure {
+		return hs.ListenAndServe()
+	}
+
+	tc := cfx.TLSConfig{Enabled: true, CertFile: *certFile, KeyFile: *keyFile, MinVersion: "1.2"}
+	if *clientCA != "" {
+		tc.CAFile = *clientCA
+		tc.ClientAuth = "require_and_verify"
+	}
+	cfg, err := tc.ServerConfig()
+	if err != nil {
+		return err
+	}
+	hs.TLSConfig = cfg
+	return hs.ListenAndServeTLS("", "")
+}
+
+// isLoopback reports whether addr only listens on the loopback interface.
+func isLoopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// readTokens reads the bearer tokens listed in path, skipping blank lines and comments.
+func readTokens(path string) ([]string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read tokens: %v", err)
+	}
+	var ret []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "#") {
+			ret = append(ret, line)
+		}
+	}
+	if len(ret) == 0 {
+		return nil, fmt.Errorf("%s does not list any tokens", path)
+	}
+	return ret, nil
+}
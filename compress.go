@@ -0,0 +1,81 @@
+package cfx
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Decompressor opens a reader over the decompressed content of r.
+type Decompressor func(r io.Reader) (io.ReadCloser, error)
+
+var (
+	decompressorsMu sync.RWMutex
+
+	// decompressors are keyed by file extension, including the dot.
+	decompressors = map[string]Decompressor{
+		".gz": func(r io.Reader) (io.ReadCloser, error) { return gzip.NewReader(r) },
+	}
+)
+
+// RegisterDecompressor makes configuration files ending in ext, such as "base.yaml.zst" for
+// ".zst", readable by decompressing them with fn. Gzip (".gz") is supported out of the box;
+// cfx does not depend on a zstd implementation, so applications register one:
+//
+//	cfx.RegisterDecompressor(".zst", func(r io.Reader) (io.ReadCloser, error) {
+//		d, err := zstd.NewReader(r)
+//		if err != nil {
+//			return nil, err
+//		}
+//		return d.IOReadCloser(), nil
+//	})
+func RegisterDecompressor(ext string, fn Decompressor) {
+	if !strings.HasPrefix(ext, ".") {
+		ext = "." + ext
+	}
+
+	decompressorsMu.Lock()
+	defer decompressorsMu.Unlock()
+	decompressors[strings.ToLower(ext)] = fn
+}
+
+// compressionExt splits a registered compression extension off name, returning the remaining
+// name and the decompressor to use.
+func compressionExt(name string) (string, Decompressor) {
+	ext := strings.ToLower(filepath.Ext(name))
+	if ext == "" {
+		return name, nil
+	}
+
+	decompressorsMu.RLock()
+	defer decompressorsMu.RUnlock()
+	fn, ok := decompressors[ext]
+	if !ok {
+		return name, nil
+	}
+	return name[:len(name)-len(ext)], fn
+}
+
+// decompressSource decompresses the content of a compressed configuration file. The size
+// limit applies to the decompressed content, so a small file cannot expand without bound.
+func decompressSource(path string, r io.Reader, fn Decompressor, limits Limits) ([]byte, error) {
+	dr, err := fn(r)
+	if err != nil {
+		return nil, fmt.Errorf("could not decompress config file %s: %v", path, err)
+	}
+	defer dr.Close()
+
+	var lr io.Reader = dr
+	if limits.MaxFileSize > 0 {
+		lr = io.LimitReader(dr, limits.MaxFileSize+1)
+	}
+	data, err := ioutil.ReadAll(lr)
+	if err != nil {
+		return nil, fmt.Errorf("could not decompress config file %s: %v", path, err)
+	}
+	return data, nil
+}
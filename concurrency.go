@@ -0,0 +1,90 @@
+package cfx
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"go.uber.org/fx"
+)
+
+// ConcurrencyKey is the config section read by ConcurrencyModule.
+const ConcurrencyKey = "concurrency"
+
+// ConcurrencyModule provides a Concurrency computed from the "concurrency" config section and
+// the CPU limits of the environment.
+var ConcurrencyModule = fx.Options(
+	ProvideSection(ConcurrencyKey, DefaultConcurrencyConfig()),
+	fx.Provide(NewConcurrency),
+)
+
+// ConcurrencyConfig is the configuration section for sizing worker pools.
+type ConcurrencyConfig struct {
+	// PerCore is the number of workers per usable core. Defaults to 1.
+	PerCore float64 `json:"per_core,omitempty" yaml:"per_core,omitempty" mapstructure:"per_core,omitempty"`
+
+	// Min is the lowest number of workers recommended. Defaults to 1.
+	Min int `json:"min,omitempty" yaml:"min,omitempty" mapstructure:"min,omitempty"`
+
+	// Max is the highest number of workers recommended. Zero means no upper bound.
+	Max int `json:"max,omitempty" yaml:"max,omitempty" mapstructure:"max,omitempty"`
+}
+
+// DefaultConcurrencyConfig returns a ConcurrencyConfig recommending one worker per core.
+func DefaultConcurrencyConfig() *ConcurrencyConfig {
+	return &ConcurrencyConfig{PerCore: 1, Min: 1}
+}
+
+// Validate implements the cfx.Validator interface.
+func (c ConcurrencyConfig) Validate() error {
+	if c.PerCore <= 0 {
+		return errors.New("concurrency per_core must be positive")
+	}
+	if c.Min < 1 {
+		return errors.New("concurrency min must be at least 1")
+	}
+	if c.Max != 0 && c.Max < c.Min {
+		return errors.New("concurrency max must not be lower than min")
+	}
+	return nil
+}
+
+// Concurrency is the recommended parallelism for the process.
+type Concurrency struct {
+	// CPUs is the number of usable cores the recommendation was based on.
+	CPUs float64
+
+	// Workers is the recommended number of workers.
+	Workers int
+}
+
+// Recommend computes the recommended parallelism for the given resources.
+func (c ConcurrencyConfig) Recommend(res ResourceContext) Concurrency {
+	cpus := res.CPUs()
+	workers := int(math.Ceil(cpus * c.PerCore))
+	if workers < c.Min {
+		workers = c.Min
+	}
+	if c.Max > 0 && workers > c.Max {
+		workers = c.Max
+	}
+	return Concurrency{CPUs: cpus, Workers: workers}
+}
+
+// NewConcurrency reads the "concurrency" section and computes the recommended parallelism
+// from the environment's ResourceContext.
+func NewConcurrency(env EnvContext, c Container) (Concurrency, error) {
+	cfg := DefaultConcurrencyConfig()
+	if err := c.Populate(ConcurrencyKey, cfg); err != nil {
+		return Concurrency{}, fmt.Errorf("could not populate concurrency config: %v", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		return Concurrency{}, SectionError{Key: ConcurrencyKey, Err: err}
+	}
+
+	res := env.Resources
+	if res.NumCPU == 0 {
+		res = DetectResources()
+	}
+	return cfg.Recommend(res), nil
+}
@@ -1,15 +1,20 @@
 package cfx
 
 import (
+	"bytes"
+	"context"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strings"
 	"sync"
+	"time"
 
 	"go.uber.org/config"
+	"gopkg.in/yaml.v2"
 )
 
 const (
@@ -34,51 +39,372 @@ var (
 type Container interface {
 	// Populate is used to load a block of YAML configuration into
 	// a target struct. Target should be a pointer to the config struct value.
+	// Constraints declared with the cfx struct tag are checked afterwards.
 	Populate(key string, target interface{}) error
+
+	// PopulateContext is like Populate, but afterwards resolves any ContextResolver values
+	// within target, such as secrets held by remote resolvers, honouring ctx's deadline
+	// and cancellation.
+	PopulateContext(ctx context.Context, key string, target interface{}) error
+
+	// Reload re-reads the configuration files from disk and atomically swaps them
+	// in. The trigger is recorded in the audit log of any registered AuditSink.
+	Reload(trigger ReloadTrigger) error
+
+	// Fingerprint returns a stable digest of the currently loaded, merged configuration.
+	Fingerprint() string
+
+	// Value returns a goroutine safe handle to the value under key that reports when
+	// reloads change it. Calling Value again with the same key returns the same handle.
+	Value(key string) *Watchable
+
+	// Report returns a machine readable summary of how the current configuration was loaded.
+	Report() LoadReport
+
+	// Freeze permanently disables reloads. Once frozen, Reload returns ErrFrozen and the
+	// configuration being served can no longer change for the lifetime of the process.
+	Freeze()
+
+	// Frozen reports whether Freeze has been called.
+	Frozen() bool
+
+	// WipeSecrets replaces every sensitive value in the loaded configuration with its redacted
+	// form, as decided by the Container's Redactor, and drops cached Secret values. Sections
+	// populated afterwards receive the redacted values, so it should be called once every
+	// component has read its configuration.
+	// The original values are released to the garbage collector; Go offers no way to zero
+	// immutable strings in place.
+	WipeSecrets() error
+
+	// StringFast, Int64Fast, Float64Fast, BoolFast and DurationFast read a scalar from a flat
+	// map built when the configuration is loaded, without reflection or allocation, for
+	// lookups on request paths. They report false if the key is not set, is not a scalar, or
+	// cannot be read as the requested type. Integers can be read as floats and durations (in
+	// nanoseconds), and strings as durations if they parse with time.ParseDuration. Keys in
+	// lazy sections or under read-time stanzas are not available and must be populated.
+	StringFast(key string) (string, bool)
+	Int64Fast(key string) (int64, bool)
+	Float64Fast(key string) (float64, bool)
+	BoolFast(key string) (bool, bool)
+	DurationFast(key string) (time.Duration, bool)
 }
 
 // NewConfig is used to create a container that can be used to extract configuration
 // elements from a YAML file.
 func NewConfig(env EnvContext) (Container, error) {
-	ret := &yamlContainer{}
+	return NewConfigWithOptions(env)
+}
+
+// NewConfigWithOptions is used to create a container with the provided options applied.
+func NewConfigWithOptions(env EnvContext, opts ...Option) (Container, error) {
+	ret, err := newYAMLContainer(env, opts)
+	if err != nil {
+		// don't leave plugin processes behind
+		closePlugins(ret.opts.plugins)
+	}
+	return ret, err
+}
+
+func newYAMLContainer(env EnvContext, opts []Option) (*yamlContainer, error) {
+	ret := &yamlContainer{
+		env:  env,
+		opts: newOptions(opts),
+	}
+	ret.env = applyConfigPaths(env, ret.opts)
+	env = ret.env
+
+	if env.ConfigPath != "" {
+		if err := createConfigDir(ret.opts, env.ConfigPath); err != nil {
+			return ret, err
+		}
+	}
+
+	if ret.opts.pluginDir != "" {
+		plugins, err := startPlugins(env, ret.opts.pluginDir)
+		if err != nil {
+			return ret, err
+		}
+		ret.opts.plugins = plugins
+	}
+
+	if err := loadWASMTransformers(env, ret.opts); err != nil {
+		return ret, err
+	}
+
+	snap, err := loadSnapshot(env, ret.opts)
+	if err == nil {
+		start := ret.opts.clock()
+		err = validatePlugins(env, ret.opts, snap)
+		snap.stages.record(ret.opts, StageValidate, start)
+	}
+	if err == nil {
+		// a writable configuration is a deployment error, not a bad configuration
+		if err := verifyReadOnly(env, ret.opts, snap); err != nil {
+			return ret, err
+		}
+	}
+	if err != nil {
+		// come up with the last known good configuration if there is one
+		lkg, lkgErr := loadLastKnownGood(env, ret.opts)
+		if lkgErr != nil {
+			return ret, err
+		}
+		lkg.fallbackReason = err
+		ret.notify(ReloadEvent{Trigger: TriggerStartup, Err: err, Fingerprint: lkg.fingerprint, FellBack: true})
+		snap = lkg
+	} else if err := persistLastKnownGood(ret.opts, snap); err != nil {
+		return ret, err
+	}
+
+	if err := recordReplay(env, ret.opts, snap); err != nil {
+		return ret, err
+	}
+
+	if err := publishSharedSnapshot(ret.opts, snap); err != nil {
+		return ret, err
+	}
+
+	ret.Lock()
+	ret.snap = snap
+	ret.Unlock()
+
+	return ret, nil
+}
+
+// loadSnapshot locates, parses and merges the configuration files for the environment.
+func loadSnapshot(env EnvContext, opts *options) (*snapshot, error) {
+	start := opts.clock()
+	snap, err := readSnapshot(env, opts)
+	if err != nil {
+		return nil, err
+	}
+	snap.loadDuration = opts.clock().Sub(start)
+	return snap, nil
+}
+
+func readSnapshot(env EnvContext, opts *options) (*snapshot, error) {
+	if opts.replayPath != "" {
+		return loadReplay(env, opts)
+	}
+	if opts.socketPath != "" {
+		return loadSocket(env, opts)
+	}
+	if opts.configClient != nil {
+		return loadFromServer(env, opts)
+	}
+	if opts.gitSource != nil {
+		return loadGit(env, opts)
+	}
+
+	if opts.bundlePath != "" {
+		var b *Bundle
+		var err error
+		if opts.bundleUnsigned {
+			b, err = LoadBundle(opts.bundlePath)
+		} else {
+			b, err = loadSignedBundle(opts.bundlePath, opts.bundleSig, opts.bundleKeys)
+		}
+		if err != nil {
+			return nil, err
+		}
+		sources, err := b.sources(env, opts)
+		if err != nil {
+			return nil, err
+		}
+		extra, err := pluginSources(env, opts)
+		if err != nil {
+			return nil, err
+		}
+		return buildSnapshot(env, opts, append(sources, extra...))
+	}
+
+	pre := &stageTimings{}
+	start := opts.clock()
+	paths, err := discoverConfigFiles(env, opts.naming)
+	if err != nil {
+		return nil, err
+	}
+	pre.record(opts, StageDiscovery, start)
+
+	start = opts.clock()
+	sources := make([]configSource, 0, len(paths))
+	for _, path := range paths {
+		src, err := readConfigSource(path, opts.limits)
+		if err != nil {
+			return nil, err
+		}
+		sources = append(sources, src)
+	}
+
+	extra, err := pluginSources(env, opts)
+	if err != nil {
+		return nil, err
+	}
+	pre.record(opts, StageRead, start)
 
-	// set the default YAML options
-	cfgopts := []config.YAMLOption{
-		config.Expand(os.LookupEnv),
+	snap, err := buildSnapshot(env, opts, append(sources, extra...))
+	if err != nil {
+		return nil, err
 	}
+	snap.stages.prepend(pre.list())
+	snap.unusedFiles = unusedConfigFiles(env, opts)
+	return snap, nil
+}
+
+// discoverConfigFiles returns the configuration files for the environment in merge order. With
+// several config directories, each name is looked up in all of them, so that production.yaml
+// in any directory overlays base.yaml in every directory. Each environment file is followed by
+// its refinements for the region and service of the deployment, such as
+// production.us-east-1.payments.yaml.
+func discoverConfigFiles(env EnvContext, naming NamingStrategy) ([]string, error) {
+	paths := []string{}
+	roots := env.configRoots()
 
 	// try and locate a base.yaml
-	basecfg, err := resolveConfig(env.ConfigPath, _defaultConfigName)
+	basecfg, err := resolveAcross(roots, naming, _defaultConfigName)
 	if err != nil && err != ErrConfigNotFound {
-		return ret, err
+		return nil, err
 	}
-	if basecfg != "" {
-		// we did locate a base.yaml file
-		cfgopts = append(cfgopts, config.File(basecfg))
+	// we may have located a base.yaml file
+	paths = append(paths, basecfg...)
+
+	// resolve the ${environment}.yaml, after any environments it inherits from
+	manifest, err := readSearchPathManifest(roots)
+	if err != nil {
+		return nil, err
+	}
+	chain, err := manifest.Chain(env.Environment.String())
+	if err != nil {
+		return nil, err
 	}
+	for _, name := range chain {
+		cfg, err := resolveSpecific(env, roots, naming, name)
+		if err != nil {
+			if err == ErrConfigNotFound && name != env.Environment.String() {
+				return nil, fmt.Errorf("could not find config for %s, inherited by environment %s: %v", name, env.Environment, err)
+			}
+			return nil, err
+		}
+		paths = append(paths, cfg...)
+	}
+
+	return paths, nil
+}
 
-	// resolve the ${environment}.yaml
-	envcfg, err := resolveConfig(env.ConfigPath, env.Environment.String())
+// buildExpandedSnapshot builds a snapshot from a configuration tree whose values were
+// already expanded, such as one recorded in a replay file or received from another process.
+func buildExpandedSnapshot(env EnvContext, opts *options, name string, tree map[string]interface{}) (*snapshot, error) {
+	data, err := yaml.Marshal(tree)
 	if err != nil {
-		return ret, err
+		return nil, fmt.Errorf("could not encode configuration from %s: %v", name, err)
+	}
+
+	data = append([]byte("# "+NoExpandDirective+"\n"), data...)
+	return buildSnapshot(env, opts, []configSource{{name: name, data: data}})
+}
+
+// configSource is a single layer of YAML configuration, merged in order.
+type configSource struct {
+	// name identifies the source, usually the path of the file it was read from.
+	name string
+
+	data []byte
+}
+
+// readConfigSource reads a configuration file, enforcing the file size limit.
+func readConfigSource(path string, limits Limits) (configSource, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return configSource{}, fmt.Errorf("could not open config file %s: %v", path, err)
+	}
+	defer f.Close()
+
+	if _, fn := compressionExt(path); fn != nil {
+		data, err := decompressSource(path, f, fn, limits)
+		if err != nil {
+			return configSource{}, err
+		}
+		return configSource{name: path, data: data}, nil
+	}
+
+	var r io.Reader = f
+	if limits.MaxFileSize > 0 {
+		r = io.LimitReader(f, limits.MaxFileSize+1)
+	}
+
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return configSource{}, fmt.Errorf("could not read config file %s: %v", path, err)
+	}
+
+	return configSource{name: path, data: data}, nil
+}
+
+// buildSnapshot merges the sources in order into a new snapshot.
+func buildSnapshot(env EnvContext, opts *options, sources []configSource) (*snapshot, error) {
+	names := make([]string, 0, len(sources))
+	reports := make([]ReportSource, 0, len(sources))
+
+	stages := &stageTimings{}
+	start := opts.clock()
+
+	prepared := make([][]byte, 0, len(sources))
+	exp := newExpander(opts, os.LookupEnv)
+
+	for _, src := range sources {
+		if err := opts.limits.check(src); err != nil {
+			return nil, err
+		}
+		data, err := exp.expandSource(src)
+		if err != nil {
+			return nil, fmt.Errorf("could not expand environment variables: %v", err)
+		}
+		guarded, err := applyGuards(env, configSource{name: src.name, data: rewriteExprTags(data)})
+		if err != nil {
+			return nil, err
+		}
+		prepared = append(prepared, guarded.data)
+		names = append(names, src.name)
+		reports = append(reports, newReportSource(src))
+	}
+
+	// set aside the sections that are parsed on first use
+	prepared, lazy := splitLazySources(opts.lazySections, prepared)
+	stages.record(opts, StageExpand, start)
+
+	start = opts.clock()
+
+	cfgopts := make([]config.YAMLOption, 0, len(prepared))
+	for _, data := range prepared {
+		cfgopts = append(cfgopts, config.Source(bytes.NewReader(data)))
 	}
-	cfgopts = append(cfgopts, config.File(envcfg))
 
 	// create the provider
 	provider, err := config.NewYAML(cfgopts...)
 	if err != nil {
-		return ret, fmt.Errorf("error constructing yaml configuration: %v", err)
+		return nil, fmt.Errorf("error constructing yaml configuration: %v", err)
 	}
 
 	if provider == nil {
-		return ret, errors.New("yaml config constructor returned nil provider")
+		return nil, errors.New("yaml config constructor returned nil provider")
 	}
+	stages.record(opts, StageParse, start)
 
-	ret.Lock()
-	ret.cfg = provider
-	ret.Unlock()
+	start = opts.clock()
+	snap, err := newSnapshot(env, opts, provider, names)
+	if err != nil {
+		return nil, err
+	}
+	stages.record(opts, StageResolve, start)
+	snap.stages = stages
+	snap.unresolved = exp.unresolved
+	snap.sourceReports = reports
+	if lazy != nil {
+		snap.lazy = lazy
+		snap.fingerprint = lazyFingerprint(snap.fingerprint, lazy)
+	}
 
-	return ret, nil
+	return snap, nil
 }
 
 // try to find a yaml/yml config by a given name in the provided config dir.
@@ -110,14 +436,17 @@ func resolveConfig(configDir string, name string) (string, error) {
 			continue // don't want a directory
 		}
 
-		fileext := filepath.Ext(x.Name())
+		// look through compression extensions, such as base.yaml.gz
+		filename, _ := compressionExt(x.Name())
+
+		fileext := filepath.Ext(filename)
 		// skip if it doesn't have .yaml or a .yml extension.
 		if _, exists := yamlExts[fileext]; !exists {
 			continue
 		}
 
 		// get the base filename without extension
-		basename := strings.Replace(filepath.Base(x.Name()), fileext, ``, -1)
+		basename := strings.Replace(filepath.Base(filename), fileext, ``, -1)
 
 		// compare it against the provided name
 		if strings.EqualFold(basename, name) {
@@ -132,16 +461,71 @@ func resolveConfig(configDir string, name string) (string, error) {
 type yamlContainer struct {
 	sync.RWMutex
 
-	cfg *config.YAML
+	env  EnvContext
+	opts *options
+	snap *snapshot
+
+	// sections are validated against every reloaded configuration before it is swapped in.
+	sections []SectionSpec
+
+	// handlers are called with the changes under their section after a reload.
+	handlers []sectionHandler
+
+	// watchables are the handles returned by Value, by key.
+	watchables map[string]*Watchable
+
+	// frozen disables reloads once set.
+	frozen bool
+
+	// reloadMu serializes reloads.
+	reloadMu sync.Mutex
 }
 
 // Populate implements the cfgfx.Container interface.
 func (y *yamlContainer) Populate(key string, target interface{}) error {
 	y.Lock()
 	defer y.Unlock()
-	if y.cfg == nil {
+	if y.snap == nil {
 		return ErrNoConfigsLoaded
 	}
 
-	return y.cfg.Get(key).Populate(target)
+	var err error
+	switch l := y.snap.lazySectionFor(key); {
+	case l != nil:
+		var cfg *config.YAML
+		if cfg, _, err = l.load(); err == nil {
+			err = cfg.Get(key).Populate(target)
+		}
+	case y.snap.dynamic:
+		err = populateDynamic(y.newReadContext(), y.snap.tree, key, target)
+	case key == "" && y.snap.lazy != nil:
+		var cfg *config.YAML
+		if cfg, err = y.snap.rootProvider(); err == nil {
+			err = cfg.Get(key).Populate(target)
+		}
+	default:
+		err = y.snap.cfg.Get(key).Populate(target)
+	}
+	if err != nil {
+		return err
+	}
+
+	return CheckConstraints(key, target)
+}
+
+func (y *yamlContainer) currentSnapshot() *snapshot {
+	y.RLock()
+	defer y.RUnlock()
+	return y.snap
+}
+
+// Fingerprint implements the cfgfx.Container interface.
+func (y *yamlContainer) Fingerprint() string {
+	y.RLock()
+	defer y.RUnlock()
+	if y.snap == nil {
+		return ""
+	}
+
+	return y.snap.fingerprint
 }
@@ -0,0 +1,92 @@
+package cfx
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type mergeOrderConfig struct {
+	Base     string `yaml:"base"`
+	Parent   string `yaml:"parent"`
+	Env      string `yaml:"env"`
+	Region   string `yaml:"region"`
+	Service  string `yaml:"service"`
+	Override string `yaml:"override"`
+	Layer    string `yaml:"layer"`
+}
+
+func TestMergeOrder(t *testing.T) {
+	dir := testDir(t)
+	writeTestConfig(t, dir, "environments.yaml", "environments:\n  production-like: {}\n  production:\n    parent: production-like\n")
+	writeTestConfig(t, dir, "base.yaml", "app:\n  base: base\n  parent: base\n  env: base\n  region: base\n  service: base\n  override: base\n  layer: base\n")
+	writeTestConfig(t, dir, "production-like.yaml", "app:\n  parent: parent\n  env: parent\n  region: parent\n  service: parent\n  override: parent\n")
+	writeTestConfig(t, dir, "production.yaml", "app:\n  env: env\n  region: env\n  service: env\n  override: env\n")
+	writeTestConfig(t, dir, "production.us-east-1.yaml", "app:\n  region: region\n  service: region\n  override: region\n")
+	writeTestConfig(t, dir, "production.us-east-1.payments.yaml", "app:\n  service: service\n  override: service\n")
+	layerFile := filepath.Join(testDir(t), "layer.yaml")
+	if err := ioutil.WriteFile(layerFile, []byte("app:\n  override: layer\n  layer: layer\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	env := EnvContext{ConfigPath: dir, Environment: "production"}
+	env.Deployment.Region = "us-east-1"
+	env.Deployment.ServiceID = "payments"
+	layer := &FailoverLayer{Name: "shared", Sources: []LayerSource{FileLayer(layerFile)}}
+	c, err := NewConfigWithOptions(env, WithFailoverLayer(layer))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got mergeOrderConfig
+	if err := c.Populate("app", &got); err != nil {
+		t.Fatal(err)
+	}
+	want := mergeOrderConfig{
+		Base:     "base",
+		Parent:   "parent",
+		Env:      "env",
+		Region:   "region",
+		Service:  "service",
+		Override: "layer",
+		Layer:    "layer",
+	}
+	if got != want {
+		t.Errorf("merged config = %+v, want %+v", got, want)
+	}
+
+	var names []string
+	for _, s := range c.Report().Sources {
+		names = append(names, strings.TrimPrefix(s.Name, dir+string(filepath.Separator)))
+	}
+	wantNames := []string{"base.yaml", "production-like.yaml", "production.yaml", "production.us-east-1.yaml", "production.us-east-1.payments.yaml", "layer:shared@file:" + layerFile}
+	if !reflect.DeepEqual(names, wantNames) {
+		t.Errorf("sources = %v, want %v", names, wantNames)
+	}
+}
+
+func TestMergeOrderAcrossConfigPaths(t *testing.T) {
+	primary, fallback := testDir(t), testDir(t)
+	writeTestConfig(t, fallback, "base.yaml", "app:\n  base: fallback\n  env: fallback\n  override: fallback\n")
+	writeTestConfig(t, primary, "base.yaml", "app:\n  override: primary-base\n")
+	writeTestConfig(t, fallback, "production.yaml", "app:\n  env: fallback-env\n  override: fallback-env\n")
+	writeTestConfig(t, primary, "production.yaml", "app:\n  override: primary-env\n")
+
+	c, err := NewConfigWithOptions(EnvContext{ConfigPath: primary, Environment: "production"}, WithConfigPaths(primary, fallback))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got mergeOrderConfig
+	if err := c.Populate("app", &got); err != nil {
+		t.Fatal(err)
+	}
+
+	// every environment file overlays base.yaml in every directory, and within a name the
+	// directory with the highest precedence wins
+	want := mergeOrderConfig{Base: "fallback", Env: "fallback-env", Override: "primary-env"}
+	if got != want {
+		t.Errorf("merged config = %+v, want %+v", got, want)
+	}
+}
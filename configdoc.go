@@ -0,0 +1,396 @@
+package cfx
+
+import (
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ConfigCatalog documents the config sections of a Go package, extracted from the doc comments
+// of their structs by ExtractConfigCatalog.
+type ConfigCatalog struct {
+	// Package is the name of the package the catalog was extracted from.
+	Package string `json:"package" yaml:"package"`
+
+	// Sections are the documented sections, sorted by key.
+	Sections []CatalogSection `json:"sections" yaml:"sections"`
+}
+
+// CatalogSection documents a single config section.
+type CatalogSection struct {
+	Key  string `json:"key" yaml:"key"`
+	Type string `json:"type" yaml:"type"`
+	Doc  string `json:"doc,omitempty" yaml:"doc,omitempty"`
+
+	// Fields lists every key of the section, nested keys included, in declaration order.
+	Fields []CatalogField `json:"fields,omitempty" yaml:"fields,omitempty"`
+}
+
+// CatalogField documents a single key of a config section. Keys are relative to the section;
+// "<name>" stands for any map key and "[]" for a list element.
+type CatalogField struct {
+	Key  string `json:"key" yaml:"key"`
+	Type string `json:"type" yaml:"type"`
+	Doc  string `json:"doc,omitempty" yaml:"doc,omitempty"`
+}
+
+// _maxCatalogDepth bounds how deep ExtractConfigCatalog follows nested structs.
+const _maxCatalogDepth = 8
+
+// ExtractConfigCatalog parses the Go package in dir and documents every config section it
+// registers with ProvideSection or Section, using the doc comments of the section structs and
+// their fields. Keys come from the yaml struct tags. Section keys and targets must be written
+// as literals, constants, composite literals or calls to functions declared in the package;
+// sections registered some other way can be passed in explicitly. It is meant to run at build
+// time, for example from a go:generate directive calling `cfxctl docs`.
+func ExtractConfigCatalog(dir string, sections ...SectionSpec) (*ConfigCatalog, error) {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseDir(fset, dir, func(fi os.FileInfo) bool {
+		return !strings.HasSuffix(fi.Name(), "_test.go")
+	}, parser.ParseComments)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse %s: %v", dir, err)
+	}
+	if len(pkgs) != 1 {
+		return nil, fmt.Errorf("%s must contain exactly one package, found %d", dir, len(pkgs))
+	}
+
+	var x *catalogExtractor
+	for name, pkg := range pkgs {
+		x = newCatalogExtractor(name, pkg)
+	}
+
+	found := x.registrations()
+	for _, s := range sections {
+		t := reflect.TypeOf(s.Target)
+		for t != nil && t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		if t == nil || t.Name() == "" {
+			return nil, fmt.Errorf("section %s must target a named type, got %T", s.Key, s.Target)
+		}
+		found[s.Key] = t.Name()
+	}
+
+	cat := &ConfigCatalog{Package: x.pkg}
+	for key, typ := range found {
+		sec := CatalogSection{Key: key, Type: typ}
+		if spec, ok := x.types[typ]; ok {
+			sec.Doc = x.typeDocs[typ]
+			sec.Fields = x.nested(spec.Type, "", 0, map[string]bool{typ: true})
+		}
+		cat.Sections = append(cat.Sections, sec)
+	}
+	sort.Slice(cat.Sections, func(i, j int) bool { return cat.Sections[i].Key < cat.Sections[j].Key })
+	return cat, nil
+}
+
+// ReadConfigCatalog reads a catalog written as JSON.
+func ReadConfigCatalog(r io.Reader) (*ConfigCatalog, error) {
+	cat := &ConfigCatalog{}
+	if err := json.NewDecoder(r).Decode(cat); err != nil {
+		return nil, fmt.Errorf("could not decode config catalog: %v", err)
+	}
+	return cat, nil
+}
+
+// WriteConfigCatalogJSON writes the catalog as indented JSON.
+func WriteConfigCatalogJSON(w io.Writer, cat *ConfigCatalog) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(cat)
+}
+
+// WriteConfigCatalogMarkdown renders the catalog as a configuration reference page.
+func WriteConfigCatalogMarkdown(w io.Writer, cat *ConfigCatalog) error {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "# Configuration reference for %s\n", cat.Package)
+	for _, s := range cat.Sections {
+		fmt.Fprintf(&sb, "\n## %s\n\n", s.Key)
+		if s.Doc != "" {
+			sb.WriteString(s.Doc)
+			sb.WriteString("\n\n")
+		}
+		if len(s.Fields) == 0 {
+			fmt.Fprintf(&sb, "Type `%s`.\n", s.Type)
+			continue
+		}
+		sb.WriteString("| Key | Type | Description |\n|---|---|---|\n")
+		for _, f := range s.Fields {
+			doc := strings.Replace(f.Doc, "\n", " ", -1)
+			fmt.Fprintf(&sb, "| `%s.%s` | `%s` | %s |\n", s.Key, f.Key, f.Type, strings.Replace(doc, "|", `\|`, -1))
+		}
+	}
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+// catalogExtractor indexes the declarations of a package.
+type catalogExtractor struct {
+	pkg      string
+	files    []*ast.File
+	types    map[string]*ast.TypeSpec
+	typeDocs map[string]string
+	consts   map[string]string
+	funcs    map[string]*ast.FuncDecl
+}
+
+func newCatalogExtractor(name string, pkg *ast.Package) *catalogExtractor {
+	x := &catalogExtractor{
+		pkg:      name,
+		types:    map[string]*ast.TypeSpec{},
+		typeDocs: map[string]string{},
+		consts:   map[string]string{},
+		funcs:    map[string]*ast.FuncDecl{},
+	}
+
+	names := make([]string, 0, len(pkg.Files))
+	for fn := range pkg.Files {
+		names = append(names, fn)
+	}
+	sort.Strings(names)
+
+	for _, fn := range names {
+		f := pkg.Files[fn]
+		x.files = append(x.files, f)
+		for _, decl := range f.Decls {
+			switch d := decl.(type) {
+			case *ast.FuncDecl:
+				if d.Recv == nil {
+					x.funcs[d.Name.Name] = d
+				}
+			case *ast.GenDecl:
+				for _, spec := range d.Specs {
+					switch s := spec.(type) {
+					case *ast.TypeSpec:
+						x.types[s.Name.Name] = s
+						doc := s.Doc
+						if doc == nil && len(d.Specs) == 1 {
+							doc = d.Doc
+						}
+						x.typeDocs[s.Name.Name] = strings.TrimSpace(doc.Text())
+					case *ast.ValueSpec:
+						if d.Tok != token.CONST {
+							continue
+						}
+						for i, n := range s.Names {
+							if i < len(s.Values) {
+								if v, ok := stringLit(s.Values[i]); ok {
+									x.consts[n.Name] = v
+								}
+							}
+						}
+					}
+				}
+			}
+		}
+	}
+	return x
+}
+
+// registrations finds the ProvideSection and Section calls of the package, mapping section
+// keys to the names of their types.
+func (x *catalogExtractor) registrations() map[string]string {
+	ret := map[string]string{}
+	for _, f := range x.files {
+		ast.Inspect(f, func(n ast.Node) bool {
+			call, ok := n.(*ast.CallExpr)
+			if !ok || len(call.Args) != 2 {
+				return true
+			}
+			var name string
+			switch fn := call.Fun.(type) {
+			case *ast.Ident:
+				name = fn.Name
+			case *ast.SelectorExpr:
+				name = fn.Sel.Name
+			}
+			if name != "ProvideSection" && name != "Section" {
+				return true
+			}
+
+			key, ok := x.stringValue(call.Args[0])
+			if !ok {
+				return true
+			}
+			if typ := x.targetType(call.Args[1]); typ != "" {
+				ret[key] = typ
+			}
+			return true
+		})
+	}
+	return ret
+}
+
+func (x *catalogExtractor) stringValue(e ast.Expr) (string, bool) {
+	if v, ok := stringLit(e); ok {
+		return v, true
+	}
+	if id, ok := e.(*ast.Ident); ok {
+		v, ok := x.consts[id.Name]
+		return v, ok
+	}
+	return "", false
+}
+
+// targetType returns the name of the type of a section target expression.
+func (x *catalogExtractor) targetType(e ast.Expr) string {
+	switch t := e.(type) {
+	case *ast.UnaryExpr:
+		return x.targetType(t.X)
+	case *ast.CompositeLit:
+		return typeName(t.Type)
+	case *ast.CallExpr:
+		if id, ok := t.Fun.(*ast.Ident); ok {
+			if id.Name == "new" && len(t.Args) == 1 {
+				return typeName(t.Args[0])
+			}
+			if fn, ok := x.funcs[id.Name]; ok && fn.Type.Results != nil && len(fn.Type.Results.List) > 0 {
+				return typeName(fn.Type.Results.List[0].Type)
+			}
+		}
+	}
+	return ""
+}
+
+// fields documents the fields of a struct type, following nested structs declared in the
+// package.
+func (x *catalogExtractor) fields(e ast.Expr, prefix string, depth int, seen map[string]bool) []CatalogField {
+	st, ok := e.(*ast.StructType)
+	if !ok || depth > _maxCatalogDepth {
+		return nil
+	}
+
+	var ret []CatalogField
+	for _, f := range st.Fields.List {
+		names := make([]string, 0, len(f.Names))
+		for _, n := range f.Names {
+			if n.IsExported() {
+				names = append(names, n.Name)
+			}
+		}
+		if len(f.Names) == 0 {
+			names = append(names, typeName(f.Type))
+		}
+
+		tag := ""
+		if f.Tag != nil {
+			if v, err := strconv.Unquote(f.Tag.Value); err == nil {
+				tag = reflect.StructTag(v).Get("yaml")
+			}
+		}
+		parts := strings.Split(tag, ",")
+		if parts[0] == "-" {
+			continue
+		}
+		inline := false
+		for _, p := range parts[1:] {
+			inline = inline || p == "inline"
+		}
+
+		doc := f.Doc.Text()
+		if doc == "" {
+			doc = f.Comment.Text()
+		}
+		doc = strings.TrimSpace(doc)
+
+		for _, name := range names {
+			if name == "" {
+				continue
+			}
+			key := parts[0]
+			if key == "" {
+				key = strings.ToLower(name)
+			}
+			if !inline {
+				key = joinKey(prefix, key)
+			} else {
+				key = prefix
+			}
+
+			if !inline {
+				ret = append(ret, CatalogField{Key: key, Type: exprString(f.Type), Doc: doc})
+			}
+			ret = append(ret, x.nested(f.Type, key, depth, seen)...)
+		}
+	}
+	return ret
+}
+
+// nested documents the keys beneath a field whose type is, or contains, a struct declared in
+// the package.
+func (x *catalogExtractor) nested(e ast.Expr, key string, depth int, seen map[string]bool) []CatalogField {
+	switch t := e.(type) {
+	case *ast.StarExpr:
+		return x.nested(t.X, key, depth, seen)
+	case *ast.ArrayType:
+		return x.nested(t.Elt, key+"[]", depth, seen)
+	case *ast.MapType:
+		return x.nested(t.Value, joinKey(key, "<name>"), depth, seen)
+	case *ast.StructType:
+		return x.fields(t, key, depth+1, seen)
+	case *ast.Ident:
+		spec, ok := x.types[t.Name]
+		if !ok || seen[t.Name] {
+			return nil
+		}
+		seen[t.Name] = true
+		defer delete(seen, t.Name)
+		return x.nested(spec.Type, key, depth+1, seen)
+	}
+	return nil
+}
+
+func stringLit(e ast.Expr) (string, bool) {
+	lit, ok := e.(*ast.BasicLit)
+	if !ok || lit.Kind != token.STRING {
+		return "", false
+	}
+	v, err := strconv.Unquote(lit.Value)
+	return v, err == nil
+}
+
+// typeName returns the name of a named type, dereferencing pointers.
+func typeName(e ast.Expr) string {
+	switch t := e.(type) {
+	case *ast.StarExpr:
+		return typeName(t.X)
+	case *ast.Ident:
+		return t.Name
+	case *ast.SelectorExpr:
+		return t.Sel.Name
+	}
+	return ""
+}
+
+// exprString formats a type expression as it is written in the source.
+func exprString(e ast.Expr) string {
+	switch t := e.(type) {
+	case *ast.Ident:
+		return t.Name
+	case *ast.StarExpr:
+		return "*" + exprString(t.X)
+	case *ast.SelectorExpr:
+		return exprString(t.X) + "." + t.Sel.Name
+	case *ast.ArrayType:
+		if t.Len == nil {
+			return "[]" + exprString(t.Elt)
+		}
+		return "[...]" + exprString(t.Elt)
+	case *ast.MapType:
+		return "map[" + exprString(t.Key) + "]" + exprString(t.Value)
+	case *ast.InterfaceType:
+		return "interface{}"
+	case *ast.StructType:
+		return "struct"
+	}
+	return "?"
+}
@@ -0,0 +1,221 @@
+package cfx
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// ConstraintTag is the struct tag holding declarative constraints on slice, array and map
+// fields, checked whenever a Container populates a value. Constraints are separated by commas:
+//
+//	Brokers []string `yaml:"brokers" cfx:"minItems=1,maxItems=32,uniqueItems"`
+//
+// minItems and maxItems bound the number of elements, and uniqueItems rejects duplicate
+// elements in slices and arrays.
+const ConstraintTag = "cfx"
+
+// ConstraintError describes a single field that violates its constraints.
+type ConstraintError struct {
+	// Path is the config key of the offending field, e.g. "kafka.brokers".
+	Path string
+
+	// Constraint is the violated constraint, e.g. "minItems=1".
+	Constraint string
+
+	// Msg explains the violation.
+	Msg string
+}
+
+// Error implements the error interface.
+func (e ConstraintError) Error() string {
+	return fmt.Sprintf("%s violates %s: %s", e.Path, e.Constraint, e.Msg)
+}
+
+// ConstraintErrors aggregates every constraint violation found in a value.
+type ConstraintErrors []ConstraintError
+
+// Error implements the error interface.
+func (c ConstraintErrors) Error() string {
+	msgs := make([]string, len(c))
+	for i, e := range c {
+		msgs[i] = e.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+type constraints struct {
+	minItems, maxItems int
+	hasMin, hasMax     bool
+	uniqueItems        bool
+}
+
+func parseConstraints(tag string) (constraints, error) {
+	var ret constraints
+	for _, part := range strings.Split(tag, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, arg := part, ""
+		if i := strings.Index(part, "="); i >= 0 {
+			name, arg = part[:i], part[i+1:]
+		}
+
+		switch name {
+		case "minItems", "maxItems":
+			n, err := strconv.Atoi(arg)
+			if err != nil || n < 0 {
+				return ret, fmt.Errorf("%s requires a non-negative integer, got %q", name, arg)
+			}
+			if name == "minItems" {
+				ret.minItems, ret.hasMin = n, true
+			} else {
+				ret.maxItems, ret.hasMax = n, true
+			}
+		case "uniqueItems":
+			if arg != "" {
+				return ret, fmt.Errorf("uniqueItems takes no argument")
+			}
+			ret.uniqueItems = true
+		default:
+			return ret, fmt.Errorf("unknown constraint %q", name)
+		}
+	}
+	if ret.hasMin && ret.hasMax && ret.minItems > ret.maxItems {
+		return ret, fmt.Errorf("minItems=%d is greater than maxItems=%d", ret.minItems, ret.maxItems)
+	}
+	return ret, nil
+}
+
+// CheckConstraints checks the cfx struct tags within target, which was populated from key,
+// and returns ConstraintErrors listing every violation.
+func CheckConstraints(key string, target interface{}) error {
+	var errs ConstraintErrors
+	if err := checkConstraints(key, reflect.ValueOf(target), &errs); err != nil {
+		return err
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func checkConstraints(path string, v reflect.Value, errs *ConstraintErrors) error {
+	if !v.IsValid() {
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		return checkConstraints(path, v.Elem(), errs)
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < v.NumField(); i++ {
+			f := t.Field(i)
+			if f.PkgPath != "" {
+				continue // unexported
+			}
+			fieldPath := joinKey(path, constraintFieldName(f))
+			if f.Anonymous {
+				fieldPath = path
+			}
+			if tag, ok := f.Tag.Lookup(ConstraintTag); ok {
+				c, err := parseConstraints(tag)
+				if err != nil {
+					return fmt.Errorf("invalid %s tag on %s.%s: %v", ConstraintTag, t.Name(), f.Name, err)
+				}
+				if err := c.check(fieldPath, v.Field(i), errs); err != nil {
+					return fmt.Errorf("invalid %s tag on %s.%s: %v", ConstraintTag, t.Name(), f.Name, err)
+				}
+			}
+			if err := checkConstraints(fieldPath, v.Field(i), errs); err != nil {
+				return err
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if err := checkConstraints(fmt.Sprintf("%s[%d]", path, i), v.Index(i), errs); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		iter := v.MapRange()
+		for iter.Next() {
+			if err := checkConstraints(joinKey(path, fmt.Sprint(iter.Key().Interface())), iter.Value(), errs); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (c constraints) check(path string, v reflect.Value, errs *ConstraintErrors) error {
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			break
+		}
+		v = v.Elem()
+	}
+
+	n := 0
+	switch v.Kind() {
+	case reflect.Slice, reflect.Map:
+		if !v.IsNil() {
+			n = v.Len()
+		}
+	case reflect.Array:
+		n = v.Len()
+	case reflect.Ptr:
+		// nil pointer to a collection, treated as empty
+	default:
+		return fmt.Errorf("constraints only apply to slices, arrays and maps, not %s", v.Kind())
+	}
+
+	if c.hasMin && n < c.minItems {
+		*errs = append(*errs, ConstraintError{
+			Path:       path,
+			Constraint: fmt.Sprintf("minItems=%d", c.minItems),
+			Msg:        fmt.Sprintf("has %d items", n),
+		})
+	}
+	if c.hasMax && n > c.maxItems {
+		*errs = append(*errs, ConstraintError{
+			Path:       path,
+			Constraint: fmt.Sprintf("maxItems=%d", c.maxItems),
+			Msg:        fmt.Sprintf("has %d items", n),
+		})
+	}
+	if c.uniqueItems {
+		if v.Kind() == reflect.Map {
+			return fmt.Errorf("uniqueItems does not apply to maps")
+		}
+		for i := 1; i < n; i++ {
+			for j := 0; j < i; j++ {
+				if reflect.DeepEqual(v.Index(i).Interface(), v.Index(j).Interface()) {
+					*errs = append(*errs, ConstraintError{
+						Path:       path,
+						Constraint: "uniqueItems",
+						Msg:        fmt.Sprintf("items %d and %d are equal", j, i),
+					})
+					break
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// constraintFieldName returns the config key of a struct field, as used by the YAML decoder.
+func constraintFieldName(f reflect.StructField) string {
+	if name := strings.Split(f.Tag.Get("yaml"), ",")[0]; name != "" && name != "-" {
+		return name
+	}
+	return strings.ToLower(f.Name)
+}
@@ -0,0 +1,87 @@
+package cfx
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// WithCreateDirs creates APP_DIR and CONFIG_DIR with perm when they do not exist, instead of
+// failing, for desktop and command line tools that set themselves up on first run. It takes
+// effect in NewEnvContextWithOptions, and in NewConfigWithOptions for the config directory.
+func WithCreateDirs(perm os.FileMode) Option {
+	return func(o *options) {
+		o.createDirs = true
+		o.dirPerm = perm
+	}
+}
+
+// WithSeedFiles sets files written into the config directory when WithCreateDirs creates it,
+// keyed by their path relative to the directory, typically defaults compiled into the binary:
+//
+//	cfx.WithCreateDirs(0700),
+//	cfx.WithSeedFiles(map[string][]byte{"base.yaml": defaultConfig}),
+//
+// Files are written with perm of WithCreateDirs, without execute bits. A config directory that
+// already exists is left untouched.
+func WithSeedFiles(files map[string][]byte) Option {
+	return func(o *options) {
+		o.seedFiles = files
+	}
+}
+
+// NewEnvContextWithOptions is like NewEnvContext, with opts applied. Only the options that
+// affect the environment, such as WithCreateDirs, have an effect.
+func NewEnvContextWithOptions(prefix string, opts ...Option) (EnvContext, error) {
+	return newEnvContext(prefix, newOptions(opts))
+}
+
+// createDir creates dir and its parents when WithCreateDirs is set and it does not exist,
+// reporting whether it did.
+func createDir(opts *options, dir string) (bool, error) {
+	if !opts.createDirs {
+		return false, nil
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		return false, nil
+	}
+	if err := checkWriteAllowed(opts, "create directory", dir); err != nil {
+		return false, err
+	}
+	if err := os.MkdirAll(dir, opts.dirPerm); err != nil {
+		return false, fmt.Errorf("could not create directory %s: %v", dir, err)
+	}
+	return true, nil
+}
+
+// createConfigDir creates the config directory and writes the seed files into it.
+func createConfigDir(opts *options, dir string) error {
+	names := make([]string, 0, len(opts.seedFiles))
+	for name := range opts.seedFiles {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if rel, err := filepath.Rel(dir, path); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return fmt.Errorf("seed file %s is outside of the config directory", name)
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	created, err := createDir(opts, dir)
+	if err != nil || !created {
+		return err
+	}
+
+	perm := opts.dirPerm &^ 0111
+	for _, name := range names {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), opts.dirPerm); err != nil {
+			return fmt.Errorf("could not create directory for seed file %s: %v", name, err)
+		}
+		if err := writeFileAtomic(path, opts.seedFiles[name], perm); err != nil {
+			return fmt.Errorf("could not write seed file %s: %v", name, err)
+		}
+	}
+	return nil
+}
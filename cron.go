@@ -0,0 +1,184 @@
+package cfx
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CronSchedule is a parsed cron expression.
+type CronSchedule struct {
+	minute, hour, dom, month, dow uint64
+
+	// every is set for "@every <duration>" schedules.
+	every time.Duration
+
+	// domStar and dowStar record unrestricted day fields, which changes how they combine.
+	domStar, dowStar bool
+}
+
+var _cronDescriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+var (
+	_cronMonths = map[string]int{"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6, "jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12}
+	_cronDays   = map[string]int{"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6}
+)
+
+// ParseCron parses a standard five field cron expression ("minute hour day-of-month month
+// day-of-week") supporting lists, ranges, steps and month and weekday names, as well as the
+// @hourly, @daily, @weekly, @monthly, @yearly and "@every <duration>" descriptors.
+func ParseCron(expr string) (*CronSchedule, error) {
+	expr = strings.TrimSpace(expr)
+	if strings.HasPrefix(expr, "@every ") {
+		d, err := time.ParseDuration(strings.TrimSpace(strings.TrimPrefix(expr, "@every ")))
+		if err != nil || d < time.Second {
+			return nil, fmt.Errorf("invalid cron expression %q: @every needs a duration of at least 1s", expr)
+		}
+		return &CronSchedule{every: d}, nil
+	}
+	if d, ok := _cronDescriptors[expr]; ok {
+		expr = d
+	}
+
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("invalid cron expression %q: expected 5 fields, got %d", expr, len(fields))
+	}
+
+	s := &CronSchedule{}
+	var err error
+	for i, f := range []struct {
+		dst      *uint64
+		min, max int
+		names    map[string]int
+	}{
+		{&s.minute, 0, 59, nil},
+		{&s.hour, 0, 23, nil},
+		{&s.dom, 1, 31, nil},
+		{&s.month, 1, 12, _cronMonths},
+		{&s.dow, 0, 7, _cronDays},
+	} {
+		if *f.dst, err = parseCronField(fields[i], f.min, f.max, f.names); err != nil {
+			return nil, fmt.Errorf("invalid cron expression %q: %v", expr, err)
+		}
+	}
+
+	// 7 is an alias for Sunday
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+	s.domStar = fields[2] == "*" || fields[2] == "?"
+	s.dowStar = fields[4] == "*" || fields[4] == "?"
+
+	return s, nil
+}
+
+func parseCronField(field string, min, max int, names map[string]int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if idx := strings.Index(part, "/"); idx >= 0 {
+			n, err := strconv.Atoi(part[idx+1:])
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("invalid step in %q", part)
+			}
+			step, part = n, part[:idx]
+		}
+
+		lo, hi := min, max
+		switch {
+		case part == "*" || part == "?":
+		case strings.Contains(part, "-"):
+			bounds := strings.SplitN(part, "-", 2)
+			var err error
+			if lo, err = parseCronValue(bounds[0], names); err != nil {
+				return 0, err
+			}
+			if hi, err = parseCronValue(bounds[1], names); err != nil {
+				return 0, err
+			}
+		default:
+			v, err := parseCronValue(part, names)
+			if err != nil {
+				return 0, err
+			}
+			lo = v
+			if step == 1 {
+				hi = v
+			}
+		}
+
+		if lo < min || hi > max || lo > hi {
+			return 0, fmt.Errorf("%q is out of range %d-%d", part, min, max)
+		}
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+func parseCronValue(v string, names map[string]int) (int, error) {
+	if n, ok := names[strings.ToLower(v)]; ok {
+		return n, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %q", v)
+	}
+	return n, nil
+}
+
+// Next returns the first activation of the schedule after t, in t's location. It returns the
+// zero time if the schedule never activates within the next five years.
+func (s *CronSchedule) Next(t time.Time) time.Time {
+	if s.every > 0 {
+		return t.Add(s.every)
+	}
+
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	loc := t.Location()
+
+	for t.Before(limit) {
+		if s.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+			continue
+		}
+		if !s.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+			continue
+		}
+		if s.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc)
+			continue
+		}
+		if s.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+
+	return time.Time{}
+}
+
+// dayMatches applies the cron rule that a restricted day of month and day of week match
+// when either matches.
+func (s *CronSchedule) dayMatches(t time.Time) bool {
+	dom := s.dom&(1<<uint(t.Day())) != 0
+	dow := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.domStar || s.dowStar {
+		return dom && dow
+	}
+	return dom || dow
+}
@@ -0,0 +1,87 @@
+package cfx
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"runtime"
+	"strings"
+)
+
+var (
+	_fipsEnabledPath    = "/proc/sys/crypto/fips_enabled"
+	_cryptoPolicyPath   = "/etc/crypto-policies/state/current"
+	_cryptoPolicyFIPS   = "FIPS"
+	_boringCryptoMarker = "X:boringcrypto"
+)
+
+// CryptoContext describes the cryptography the application was built with and the crypto policy
+// of the operating system.
+type CryptoContext struct {
+	// BoringCrypto is set when the binary was built with GOEXPERIMENT=boringcrypto.
+	BoringCrypto bool `json:"boring_crypto,omitempty" yaml:"boring_crypto,omitempty" mapstructure:"boring_crypto,omitempty"`
+
+	// FIPS140 is set when the Go FIPS 140-3 module is enabled, with GOFIPS140 at build time or
+	// GODEBUG=fips140=on at run time. Requires Go 1.24 or newer.
+	FIPS140 bool `json:"fips140,omitempty" yaml:"fips140,omitempty" mapstructure:"fips140,omitempty"`
+
+	// OSFIPS is set when the kernel reports FIPS mode (/proc/sys/crypto/fips_enabled).
+	OSFIPS bool `json:"os_fips,omitempty" yaml:"os_fips,omitempty" mapstructure:"os_fips,omitempty"`
+
+	// OSPolicy is the system wide crypto policy, e.g. "DEFAULT" or "FIPS", on systems that
+	// use crypto-policies.
+	OSPolicy string `json:"os_policy,omitempty" yaml:"os_policy,omitempty" mapstructure:"os_policy,omitempty"`
+}
+
+// DetectCrypto inspects the binary and the operating system for FIPS mode and crypto policy.
+func DetectCrypto() CryptoContext {
+	ret := CryptoContext{
+		BoringCrypto: strings.Contains(runtime.Version(), _boringCryptoMarker),
+		FIPS140:      fips140Enabled(),
+	}
+
+	if data, err := ioutil.ReadFile(_fipsEnabledPath); err == nil {
+		ret.OSFIPS = strings.TrimSpace(string(data)) == "1"
+	}
+	if data, err := ioutil.ReadFile(_cryptoPolicyPath); err == nil {
+		ret.OSPolicy = strings.TrimSpace(string(data))
+	}
+
+	return ret
+}
+
+// FIPS reports whether the binary uses a FIPS validated cryptographic module.
+func (c CryptoContext) FIPS() bool {
+	return c.BoringCrypto || c.FIPS140
+}
+
+// RequireFIPS returns an error describing why the process is not running in FIPS mode, so
+// compliance sensitive modules can refuse to start. Both the binary and, on Linux, the
+// operating system must be in FIPS mode.
+func (c CryptoContext) RequireFIPS() error {
+	var problems []string
+	if !c.FIPS() {
+		problems = append(problems, "the binary was not built with a FIPS validated crypto module (boringcrypto or GOFIPS140)")
+	}
+	if runtime.GOOS == "linux" && !c.OSFIPS && !strings.HasPrefix(strings.ToUpper(c.OSPolicy), _cryptoPolicyFIPS) {
+		problems = append(problems, "the operating system is not in FIPS mode")
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("FIPS mode is required: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// RequireFIPSIn returns the result of RequireFIPS when env is one of the listed environments,
+// and nil otherwise.
+func RequireFIPSIn(env EnvContext, envs ...EnvID) error {
+	if len(envs) == 0 {
+		return errors.New("no environments were given to require FIPS mode in")
+	}
+	for _, e := range envs {
+		if env.Environment == e {
+			return env.Go.Crypto.RequireFIPS()
+		}
+	}
+	return nil
+}
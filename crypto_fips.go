@@ -0,0 +1,12 @@
+//go:build go1.24
+// +build go1.24
+
+package cfx
+
+import (
+	"crypto/fips140"
+)
+
+func fips140Enabled() bool {
+	return fips140.Enabled()
+}
@@ -0,0 +1,175 @@
+package cfx
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// DataRefPrefix is the name of ${data:key} references, which expand to values from the data
+// files registered with WithDataFile.
+const DataRefPrefix = "data"
+
+// WithDataFile registers a read-only data layer, such as the output of `terraform output
+// -json` or an Ansible inventory, whose values configuration files reference with
+// ${data:key} instead of copying them:
+//
+//	network:
+//	  vpc_id: ${data:vpc_id}
+//	  subnets: ${data:private_subnets}
+//
+// JSON and YAML files are supported. Terraform outputs are unwrapped to their values, and
+// nested keys are addressed with dots, as in ${data:all.vars.dns_zone}. Lists and maps expand
+// to their JSON form, which is valid YAML. ${data:key:-default} provides a default; any other
+// reference to a missing key is an error. Data files are read again on every reload and are
+// watched for changes with hot reload. When several files set a key, the last one wins.
+//
+// Data values are not part of the configuration tree unless a configuration file references
+// them, and configuration files cannot override them.
+func WithDataFile(path string) Option {
+	return func(o *options) {
+		o.dataFiles = append(o.dataFiles, path)
+	}
+}
+
+// loadDataFiles reads the data files into a single flat map of dotted keys.
+func loadDataFiles(paths []string, limits Limits) (map[string]interface{}, error) {
+	if len(paths) == 0 {
+		return nil, nil
+	}
+
+	ret := map[string]interface{}{}
+	for _, path := range paths {
+		src, err := readConfigSource(path, limits)
+		if err != nil {
+			return nil, fmt.Errorf("could not read data file: %v", err)
+		}
+		if err := limits.check(src); err != nil {
+			return nil, err
+		}
+
+		var raw interface{}
+		name, _ := compressionExt(path)
+		if strings.EqualFold(filepath.Ext(name), ".json") {
+			err = decodeJSONNumbers(src.data, &raw)
+		} else {
+			err = yaml.Unmarshal(src.data, &raw)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("could not parse data file %s: %v", path, err)
+		}
+
+		m, ok := normalizeValue(raw).(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("data file %s must hold a map, not %T", path, raw)
+		}
+		flattenData(ret, "", unwrapTerraformOutputs(m))
+	}
+	return ret, nil
+}
+
+// decodeJSONNumbers decodes data like json.Unmarshal, keeping numbers as json.Number so that
+// large integers such as account IDs expand exactly instead of as floats.
+func decodeJSONNumbers(data []byte, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(v); err != nil {
+		return err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return errors.New("invalid data after top-level value")
+	}
+	return nil
+}
+
+// unwrapTerraformOutputs replaces the {"value": ..., "type": ...} objects written by
+// `terraform output -json` with their values. Other maps are returned unchanged.
+func unwrapTerraformOutputs(m map[string]interface{}) map[string]interface{} {
+	if len(m) == 0 {
+		return m
+	}
+	for _, v := range m {
+		out, ok := v.(map[string]interface{})
+		if !ok {
+			return m
+		}
+		if _, ok := out["value"]; !ok {
+			return m
+		}
+		if _, ok := out["type"]; !ok {
+			return m
+		}
+	}
+
+	ret := make(map[string]interface{}, len(m))
+	for k, v := range m {
+		ret[k] = v.(map[string]interface{})["value"]
+	}
+	return ret
+}
+
+// flattenData records every value of v under its dotted key, including maps, so that both
+// ${data:network} and ${data:network.vpc_id} can be referenced.
+func flattenData(dst map[string]interface{}, prefix string, v interface{}) {
+	if prefix != "" {
+		dst[prefix] = v
+	}
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return
+	}
+	for k, val := range m {
+		flattenData(dst, joinKey(prefix, k), val)
+	}
+}
+
+// isDataRef reports whether a reference is a ${data:key} reference. Without data files, such a
+// reference keeps its meaning of the variable "data" with a default.
+func (e *expander) isDataRef(exp expansion) bool {
+	return len(e.dataFiles) > 0 && exp.Name == DataRefPrefix && exp.Op == ":"
+}
+
+// resolveData returns the text a ${data:key} reference expands to. The data files are read on
+// the first reference, so sources that reference none, such as last known good files, do not
+// depend on them.
+func (e *expander) resolveData(exp expansion) (string, error) {
+	if !e.dataLoaded {
+		e.data, e.dataErr = loadDataFiles(e.dataFiles, e.limits)
+		e.dataLoaded = true
+	}
+	if e.dataErr != nil {
+		return "", e.dataErr
+	}
+
+	key, def, hasDefault := exp.Arg, "", false
+	if i := strings.Index(key, ":-"); i >= 0 {
+		key, def, hasDefault = key[:i], key[i+2:], true
+	}
+
+	v, ok := e.data[key]
+	if !ok || v == nil {
+		if hasDefault {
+			return def, nil
+		}
+		return "", fmt.Errorf("data key %s is not set in any data file", key)
+	}
+
+	switch t := v.(type) {
+	case string:
+		return t, nil
+	case map[string]interface{}, []interface{}:
+		b, err := json.Marshal(t)
+		if err != nil {
+			return "", fmt.Errorf("could not encode data key %s: %v", key, err)
+		}
+		return string(b), nil
+	default:
+		return fmt.Sprint(t), nil
+	}
+}
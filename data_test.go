@@ -0,0 +1,32 @@
+package cfx
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestDataFileKeepsJSONNumbers(t *testing.T) {
+	dir := testDir(t)
+	writeTestConfig(t, dir, "outputs.json", `{
+  "account_id": {"value": 123456789012345678, "type": "number"},
+  "ratio": {"value": 0.25, "type": "number"},
+  "ports": {"value": [8080, 9090], "type": ["list", "number"]}
+}`)
+
+	e := newExpander(newOptions([]Option{WithDataFile(filepath.Join(dir, "outputs.json"))}), func(string) (string, bool) { return "", false })
+	out, err := e.expandSource(configSource{name: "test", data: []byte("a: ${data:account_id}\nb: ${data:ratio}\nc: ${data:ports}\n")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "a: 123456789012345678\nb: 0.25\nc: [8080,9090]\n"; string(out) != want {
+		t.Errorf("expanded to %q, want %q", out, want)
+	}
+}
+
+func TestDataFileRejectsTrailingJSON(t *testing.T) {
+	dir := testDir(t)
+	writeTestConfig(t, dir, "data.json", `{"a": 1} {"b": 2}`)
+	if _, err := loadDataFiles([]string{filepath.Join(dir, "data.json")}, Limits{}); err == nil {
+		t.Fatal("trailing JSON was accepted")
+	}
+}
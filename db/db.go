@@ -0,0 +1,415 @@
+// Package db provides a canonical database configuration section and an Fx provider
+// that builds a lifecycle-managed *sql.DB from it.
+//
+// Database drivers are not imported by this package; applications must import the
+// driver they use (for example _ "github.com/lib/pq") so that it is registered with database/sql.
+// MySQL connections verifying the server certificate also need MySQLTLSRegistrar set.
+package db
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gen0cide/cfx"
+	"go.uber.org/fx"
+)
+
+// DefaultKey is the config section read by Module.
+const DefaultKey = "database"
+
+// MySQLTLSRegistrar registers a named *tls.Config with the MySQL driver, so DSNs can refer to
+// it. The MySQL driver only takes certificate authorities and client certificates that way, so
+// the "verify-ca" mode, and "verify-full" with any TLS files, require applications to set it to
+// the driver's function:
+//
+//	db.MySQLTLSRegistrar = mysql.RegisterTLSConfig
+var MySQLTLSRegistrar func(name string, config *tls.Config) error
+
+// Module provides a *sql.DB configured from the "database" config section.
+var Module = Provide(DefaultKey)
+
+// DatabaseConfig is the canonical configuration section for a SQL database.
+type DatabaseConfig struct {
+	// Driver is the database/sql driver name ("postgres", "pgx", "mysql", "sqlite3").
+	Driver string `json:"driver,omitempty" yaml:"driver,omitempty" mapstructure:"driver,omitempty"`
+
+	// DSN is used verbatim when set, instead of being built from the other fields.
+	DSN cfx.SecretRef `json:"dsn,omitempty" yaml:"dsn,omitempty" mapstructure:"dsn,omitempty"`
+
+	// Host is the database host name or address.
+	Host string `json:"host,omitempty" yaml:"host,omitempty" mapstructure:"host,omitempty"`
+
+	// Port is the database port. If zero, the driver's default port is used.
+	Port int `json:"port,omitempty" yaml:"port,omitempty" mapstructure:"port,omitempty"`
+
+	// Name is the database (or sqlite file path) to connect to.
+	Name string `json:"name,omitempty" yaml:"name,omitempty" mapstructure:"name,omitempty"`
+
+	// User is the user to authenticate as.
+	User string `json:"user,omitempty" yaml:"user,omitempty" mapstructure:"user,omitempty"`
+
+	// Password is a reference to the user's password, e.g. "env:DB_PASSWORD".
+	Password cfx.SecretRef `json:"password,omitempty" yaml:"password,omitempty" mapstructure:"password,omitempty"`
+
+	// Params holds extra driver specific connection parameters.
+	Params map[string]string `json:"params,omitempty" yaml:"params,omitempty" mapstructure:"params,omitempty"`
+
+	// Pool configures the connection pool.
+	Pool PoolConfig `json:"pool,omitempty" yaml:"pool,omitempty" mapstructure:"pool,omitempty"`
+
+	// TLS configures transport security for the connection.
+	TLS TLSConfig `json:"tls,omitempty" yaml:"tls,omitempty" mapstructure:"tls,omitempty"`
+
+	// ConnectTimeout limits how long the startup ping may take.
+	ConnectTimeout time.Duration `json:"connect_timeout,omitempty" yaml:"connect_timeout,omitempty" mapstructure:"connect_timeout,omitempty"`
+}
+
+// PoolConfig holds the database/sql connection pool settings.
+type PoolConfig struct {
+	// MaxOpenConns is the maximum number of open connections. Zero means unlimited.
+	MaxOpenConns int `json:"max_open_conns,omitempty" yaml:"max_open_conns,omitempty" mapstructure:"max_open_conns,omitempty"`
+
+	// MaxIdleConns is the maximum number of idle connections kept in the pool.
+	MaxIdleConns int `json:"max_idle_conns,omitempty" yaml:"max_idle_conns,omitempty" mapstructure:"max_idle_conns,omitempty"`
+
+	// ConnMaxLifetime is the maximum amount of time a connection may be reused.
+	ConnMaxLifetime time.Duration `json:"conn_max_lifetime,omitempty" yaml:"conn_max_lifetime,omitempty" mapstructure:"conn_max_lifetime,omitempty"`
+}
+
+// TLSConfig holds the database TLS settings. Databases configure TLS through their DSN,
+// so only file based material is supported.
+type TLSConfig struct {
+	// Mode is the TLS mode: "disable", "require", "verify-ca" or "verify-full".
+	Mode string `json:"mode,omitempty" yaml:"mode,omitempty" mapstructure:"mode,omitempty"`
+
+	// CAFile is a path to the PEM encoded certificate authority bundle.
+	CAFile string `json:"ca_file,omitempty" yaml:"ca_file,omitempty" mapstructure:"ca_file,omitempty"`
+
+	// CertFile is a path to a PEM encoded client certificate.
+	CertFile string `json:"cert_file,omitempty" yaml:"cert_file,omitempty" mapstructure:"cert_file,omitempty"`
+
+	// KeyFile is a path to the PEM encoded private key for CertFile.
+	KeyFile string `json:"key_file,omitempty" yaml:"key_file,omitempty" mapstructure:"key_file,omitempty"`
+}
+
+var _tlsModes = map[string]bool{
+	"":            true,
+	"disable":     true,
+	"require":     true,
+	"verify-ca":   true,
+	"verify-full": true,
+}
+
+// DefaultConfig returns the defaults that are applied before the section is populated.
+func DefaultConfig() DatabaseConfig {
+	return DatabaseConfig{
+		Pool: PoolConfig{
+			MaxOpenConns:    10,
+			MaxIdleConns:    5,
+			ConnMaxLifetime: 30 * time.Minute,
+		},
+		ConnectTimeout: 10 * time.Second,
+	}
+}
+
+// Validate checks the database configuration for missing or invalid values.
+func (d DatabaseConfig) Validate() error {
+	if d.Driver == "" {
+		return errors.New("database driver must be set")
+	}
+	if d.DSN.IsZero() {
+		if d.Name == "" {
+			return errors.New("database name must be set")
+		}
+		if !isSQLite(d.Driver) && d.Host == "" {
+			return errors.New("database host must be set")
+		}
+	}
+	if d.Port < 0 || d.Port > 65535 {
+		return fmt.Errorf("database port %d is out of range", d.Port)
+	}
+	if d.Pool.MaxOpenConns < 0 || d.Pool.MaxIdleConns < 0 || d.Pool.ConnMaxLifetime < 0 {
+		return errors.New("database pool settings must not be negative")
+	}
+	if !_tlsModes[d.TLS.Mode] {
+		return fmt.Errorf("database tls mode %s is not supported", d.TLS.Mode)
+	}
+	return nil
+}
+
+// BuildDSN resolves any secret references and renders the driver specific data source name.
+func (d DatabaseConfig) BuildDSN(ctx context.Context) (string, error) {
+	if err := d.Validate(); err != nil {
+		return "", err
+	}
+
+	if !d.DSN.IsZero() {
+		return d.DSN.Resolve(ctx)
+	}
+
+	var password string
+	if !d.Password.IsZero() {
+		pw, err := d.Password.Resolve(ctx)
+		if err != nil {
+			return "", err
+		}
+		password = pw
+	}
+
+	switch {
+	case isSQLite(d.Driver):
+		return d.sqliteDSN(), nil
+	case d.Driver == "mysql":
+		return d.mysqlDSN(password)
+	default:
+		return d.postgresDSN(password), nil
+	}
+}
+
+func isSQLite(driver string) bool {
+	return strings.HasPrefix(driver, "sqlite")
+}
+
+func (d DatabaseConfig) hostPort(defaultPort int) string {
+	port := d.Port
+	if port == 0 {
+		port = defaultPort
+	}
+	return net.JoinHostPort(d.Host, strconv.Itoa(port))
+}
+
+func (d DatabaseConfig) postgresDSN(password string) string {
+	u := url.URL{
+		Scheme: "postgres",
+		Host:   d.hostPort(5432),
+		Path:   "/" + d.Name,
+	}
+	if d.User != "" {
+		u.User = url.UserPassword(d.User, password)
+		if password == "" {
+			u.User = url.User(d.User)
+		}
+	}
+
+	q := url.Values{}
+	if d.TLS.Mode != "" {
+		q.Set("sslmode", d.TLS.Mode)
+	}
+	if d.TLS.CAFile != "" {
+		q.Set("sslrootcert", d.TLS.CAFile)
+	}
+	if d.TLS.CertFile != "" {
+		q.Set("sslcert", d.TLS.CertFile)
+	}
+	if d.TLS.KeyFile != "" {
+		q.Set("sslkey", d.TLS.KeyFile)
+	}
+	if d.ConnectTimeout > 0 {
+		q.Set("connect_timeout", strconv.Itoa(int(d.ConnectTimeout.Seconds())))
+	}
+	for k, v := range d.Params {
+		q.Set(k, v)
+	}
+	u.RawQuery = q.Encode()
+
+	return u.String()
+}
+
+func (d DatabaseConfig) mysqlDSN(password string) (string, error) {
+	var sb strings.Builder
+	if d.User != "" {
+		sb.WriteString(d.User)
+		if password != "" {
+			sb.WriteString(":")
+			sb.WriteString(password)
+		}
+		sb.WriteString("@")
+	}
+	sb.WriteString("tcp(")
+	sb.WriteString(d.hostPort(3306))
+	sb.WriteString(")/")
+	sb.WriteString(d.Name)
+
+	q := url.Values{}
+	switch {
+	case d.TLS.Mode == "disable":
+		q.Set("tls", "false")
+	case d.TLS.Mode == "require":
+		q.Set("tls", "skip-verify")
+	case d.TLS.Mode == "verify-ca" || d.TLS.Mode == "verify-full" && d.TLS.hasFiles():
+		name, err := d.registerMySQLTLS()
+		if err != nil {
+			return "", err
+		}
+		q.Set("tls", name)
+	case d.TLS.Mode == "verify-full":
+		q.Set("tls", "true")
+	}
+	if d.ConnectTimeout > 0 {
+		q.Set("timeout", d.ConnectTimeout.String())
+	}
+	for k, v := range d.Params {
+		q.Set(k, v)
+	}
+	if len(q) > 0 {
+		sb.WriteString("?")
+		sb.WriteString(q.Encode())
+	}
+
+	return sb.String(), nil
+}
+
+func (t TLSConfig) hasFiles() bool {
+	return t.CAFile != "" || t.CertFile != "" || t.KeyFile != ""
+}
+
+// registerMySQLTLS registers the TLS configuration with the MySQL driver, returning the name
+// the DSN refers to it by. The name is derived from the settings, so configurations that do not
+// change keep their name across reloads.
+func (d DatabaseConfig) registerMySQLTLS() (string, error) {
+	if MySQLTLSRegistrar == nil {
+		return "", fmt.Errorf("database tls mode %s with mysql requires db.MySQLTLSRegistrar to be set to mysql.RegisterTLSConfig", d.TLS.Mode)
+	}
+
+	cfg, err := d.TLS.clientConfig(d.Host, d.TLS.Mode == "verify-full")
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256([]byte(strings.Join([]string{d.TLS.Mode, d.Host, d.TLS.CAFile, d.TLS.CertFile, d.TLS.KeyFile}, "\x00")))
+	name := "cfx-" + hex.EncodeToString(sum[:8])
+	if err := MySQLTLSRegistrar(name, cfg); err != nil {
+		return "", fmt.Errorf("could not register database tls config: %v", err)
+	}
+	return name, nil
+}
+
+// clientConfig builds the *tls.Config for connecting to host. The server certificate is always
+// checked against the CA bundle, or the system roots without one; its host name is only
+// checked when verifyHost is set.
+func (t TLSConfig) clientConfig(host string, verifyHost bool) (*tls.Config, error) {
+	var roots *x509.CertPool
+	if t.CAFile != "" {
+		pem, err := ioutil.ReadFile(t.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not read database ca file: %v", err)
+		}
+		roots = x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("database ca file %s does not contain any certificates", t.CAFile)
+		}
+	}
+
+	cfg := &tls.Config{RootCAs: roots, ServerName: host, MinVersion: tls.VersionTLS12}
+	if t.CertFile != "" || t.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not load database client certificate: %v", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	if verifyHost {
+		return cfg, nil
+	}
+
+	// verify the chain without the host name, which crypto/tls only allows through a callback
+	cfg.InsecureSkipVerify = true
+	cfg.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		if len(rawCerts) == 0 {
+			return errors.New("database server did not present a certificate")
+		}
+		certs := make([]*x509.Certificate, len(rawCerts))
+		for i, raw := range rawCerts {
+			c, err := x509.ParseCertificate(raw)
+			if err != nil {
+				return fmt.Errorf("could not parse database server certificate: %v", err)
+			}
+			certs[i] = c
+		}
+		opts := x509.VerifyOptions{Roots: roots, Intermediates: x509.NewCertPool()}
+		for _, c := range certs[1:] {
+			opts.Intermediates.AddCert(c)
+		}
+		_, err := certs[0].Verify(opts)
+		return err
+	}
+	return cfg, nil
+}
+
+func (d DatabaseConfig) sqliteDSN() string {
+	if len(d.Params) == 0 {
+		return d.Name
+	}
+	q := url.Values{}
+	for k, v := range d.Params {
+		q.Set(k, v)
+	}
+	return "file:" + d.Name + "?" + q.Encode()
+}
+
+// Params are the dependencies of the database provider.
+type Params struct {
+	fx.In
+
+	Lifecycle fx.Lifecycle
+	Config    cfx.Container
+}
+
+// Provide returns an Fx option that provides a *sql.DB configured from the section at key.
+// The connection is verified with a ping when the application starts and closed when it stops.
+func Provide(key string) fx.Option {
+	return fx.Provide(func(p Params) (*sql.DB, error) {
+		return New(key, p)
+	})
+}
+
+// New builds a *sql.DB from the section at key and binds it to the Fx lifecycle.
+func New(key string, p Params) (*sql.DB, error) {
+	cfg := DefaultConfig()
+	if err := p.Config.Populate(key, &cfg); err != nil {
+		return nil, fmt.Errorf("could not populate database config %s: %v", key, err)
+	}
+
+	dsn, err := cfg.BuildDSN(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("invalid database config %s: %v", key, err)
+	}
+
+	conn, err := sql.Open(cfg.Driver, dsn)
+	if err != nil {
+		return nil, fmt.Errorf("could not open database %s: %v", key, err)
+	}
+	conn.SetMaxOpenConns(cfg.Pool.MaxOpenConns)
+	conn.SetMaxIdleConns(cfg.Pool.MaxIdleConns)
+	conn.SetConnMaxLifetime(cfg.Pool.ConnMaxLifetime)
+
+	p.Lifecycle.Append(fx.Hook{
+		OnStart: func(ctx context.Context) error {
+			if cfg.ConnectTimeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, cfg.ConnectTimeout)
+				defer cancel()
+			}
+			if err := conn.PingContext(ctx); err != nil {
+				return fmt.Errorf("could not connect to database %s: %v", key, err)
+			}
+			return nil
+		},
+		OnStop: func(ctx context.Context) error {
+			return conn.Close()
+		},
+	})
+
+	return conn, nil
+}
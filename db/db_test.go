@@ -0,0 +1,105 @@
+package db
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeCA writes a self-signed CA certificate to a temporary file.
+func writeCA(t *testing.T) string {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "cfx-db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	path := filepath.Join(dir, "ca.pem")
+	if err := ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestMySQLDSNTLSModes(t *testing.T) {
+	registered := map[string]*tls.Config{}
+	MySQLTLSRegistrar = func(name string, cfg *tls.Config) error {
+		registered[name] = cfg
+		return nil
+	}
+	defer func() { MySQLTLSRegistrar = nil }()
+
+	ca := writeCA(t)
+	base := DatabaseConfig{Driver: "mysql", Host: "db.internal", Name: "app", User: "app"}
+
+	tests := []struct {
+		mode   string
+		caFile string
+		want   string
+	}{
+		{mode: "require", want: "tls=skip-verify"},
+		{mode: "verify-full", want: "tls=true"},
+		{mode: "verify-ca", caFile: ca, want: "tls=cfx-"},
+		{mode: "verify-full", caFile: ca, want: "tls=cfx-"},
+	}
+	for _, tt := range tests {
+		cfg := base
+		cfg.TLS = TLSConfig{Mode: tt.mode, CAFile: tt.caFile}
+		dsn, err := cfg.BuildDSN(context.Background())
+		if err != nil {
+			t.Fatalf("%s: %v", tt.mode, err)
+		}
+		if !strings.Contains(dsn, tt.want) {
+			t.Errorf("%s: dsn %q does not contain %q", tt.mode, dsn, tt.want)
+		}
+	}
+
+	if len(registered) != 2 {
+		t.Fatalf("%d tls configs registered, want 2", len(registered))
+	}
+	for name, cfg := range registered {
+		if cfg.RootCAs == nil {
+			t.Errorf("%s: the CA file was not used", name)
+		}
+		// verify-ca checks the chain itself, verify-full leaves it to crypto/tls
+		if cfg.InsecureSkipVerify && cfg.VerifyPeerCertificate == nil {
+			t.Errorf("%s: skips verification without verifying the chain", name)
+		}
+	}
+}
+
+func TestMySQLDSNVerifyCARequiresRegistrar(t *testing.T) {
+	cfg := DatabaseConfig{Driver: "mysql", Host: "db.internal", Name: "app", TLS: TLSConfig{Mode: "verify-ca", CAFile: writeCA(t)}}
+	if _, err := cfg.BuildDSN(context.Background()); err == nil {
+		t.Fatal("verify-ca without a registrar built a DSN")
+	}
+}
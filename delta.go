@@ -0,0 +1,290 @@
+package cfx
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+)
+
+// DeltaConfigRequest starts an incremental watch. Versions holds the version of every top
+// level section the client already has, so the server only sends the sections that differ.
+type DeltaConfigRequest struct {
+	ConfigRequest
+
+	Versions map[string]string `json:"versions,omitempty"`
+}
+
+// DeltaConfigResponse carries the top level sections that changed since the previous response,
+// in the manner of the xDS incremental protocol.
+type DeltaConfigResponse struct {
+	// Version is the protocol version.
+	Version int `json:"version"`
+
+	// Fingerprint is the fingerprint of the complete configuration after the change, which the
+	// client uses to check that it is in sync.
+	Fingerprint string `json:"fingerprint"`
+
+	// Updated holds the new value of every section that was added or changed.
+	Updated map[string]interface{} `json:"updated,omitempty"`
+
+	// Versions holds the new version of every section in Updated.
+	Versions map[string]string `json:"versions,omitempty"`
+
+	// Removed lists the sections that no longer exist.
+	Removed []string `json:"removed,omitempty"`
+}
+
+// DeltaConfigStream is the server side of a WatchDelta call.
+type DeltaConfigStream interface {
+	Context() context.Context
+	Send(*DeltaConfigResponse) error
+}
+
+// DeltaConfigUpdates is the client side of a WatchDelta call.
+type DeltaConfigUpdates interface {
+	Recv() (*DeltaConfigResponse, error)
+}
+
+// DeltaConfigClient is implemented by ConfigClients that support incremental updates. The
+// client returned by NewHTTPConfigClient does.
+type DeltaConfigClient interface {
+	WatchDelta(ctx context.Context, req *DeltaConfigRequest) (DeltaConfigUpdates, error)
+}
+
+// sectionVersions returns the version of every top level section of the entry's served
+// configuration, computing them once per snapshot.
+func (e *serverEntry) sectionVersions() (string, map[string]interface{}, map[string]string, error) {
+	fp, tree, _, err := e.served()
+	if err != nil {
+		return "", nil, nil, err
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.versionsOf == fp {
+		return fp, tree, e.versions, nil
+	}
+
+	versions := make(map[string]string, len(tree))
+	for k, v := range tree {
+		sfp, err := fingerprintTree(v)
+		if err != nil {
+			return "", nil, nil, err
+		}
+		versions[k] = sfp
+	}
+	e.versions = versions
+	e.versionsOf = fp
+	return fp, tree, versions, nil
+}
+
+// delta returns the response moving a client holding known to the current configuration.
+func (e *serverEntry) delta(known map[string]string) (*DeltaConfigResponse, map[string]string, error) {
+	fp, tree, versions, err := e.sectionVersions()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	resp := &DeltaConfigResponse{
+		Version:     DistributionProtocolVersion,
+		Fingerprint: fp,
+		Updated:     map[string]interface{}{},
+		Versions:    map[string]string{},
+	}
+	for k, v := range versions {
+		if known[k] != v {
+			resp.Updated[k] = tree[k]
+			resp.Versions[k] = v
+		}
+	}
+	for k := range known {
+		if _, ok := versions[k]; !ok {
+			resp.Removed = append(resp.Removed, k)
+		}
+	}
+	sort.Strings(resp.Removed)
+	return resp, versions, nil
+}
+
+// WatchDelta sends the sections of the configuration for req that differ from the versions
+// the client already has, then the sections that change every time the configuration changes,
+// until the stream's context is done or the server is closed.
+func (s *ConfigServer) WatchDelta(req *DeltaConfigRequest, stream DeltaConfigStream) error {
+	e, err := s.entry(req.ConfigRequest, true)
+	if err != nil {
+		return err
+	}
+	defer s.release(e)
+
+	known := req.Versions
+	first := true
+	for {
+		changed := e.changes()
+		resp, versions, err := e.delta(known)
+		if err != nil {
+			return err
+		}
+		// the first response is always sent so the client learns the fingerprint
+		if first || len(resp.Updated) > 0 || len(resp.Removed) > 0 {
+			if err := stream.Send(resp); err != nil {
+				return err
+			}
+			known = versions
+			first = false
+		}
+
+		select {
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		case <-s.ctx.Done():
+			return nil
+		case <-changed:
+		}
+	}
+}
+
+func (s *ConfigServer) serveDelta(w http.ResponseWriter, r *http.Request) {
+	req := &DeltaConfigRequest{}
+	if err := json.NewDecoder(io.LimitReader(r.Body, 16<<20)).Decode(req); err != nil {
+		http.Error(w, fmt.Sprintf("could not decode delta request: %v", err), http.StatusBadRequest)
+		return
+	}
+	if _, err := s.entry(req.ConfigRequest, false); err != nil {
+		http.Error(w, err.Error(), serverErrorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	s.WatchDelta(req, httpDeltaStream{&httpConfigStream{ctx: r.Context(), w: w, enc: json.NewEncoder(w)}})
+}
+
+// httpDeltaStream writes DeltaConfigResponses to an HTTP response as newline delimited JSON.
+type httpDeltaStream struct {
+	*httpConfigStream
+}
+
+func (h httpDeltaStream) Send(resp *DeltaConfigResponse) error {
+	return h.send(resp)
+}
+
+func (h *httpConfigClient) WatchDelta(ctx context.Context, req *DeltaConfigRequest) (DeltaConfigUpdates, error) {
+	if err := checkNetworkAllowed("config server " + h.url); err != nil {
+		return nil, err
+	}
+
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("could not encode delta request: %v", err)
+	}
+	hreq, err := http.NewRequest(http.MethodPost, h.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("could not create request for config server %s: %v", h.url, err)
+	}
+	hreq.Header.Set("Content-Type", "application/json")
+
+	resp, err := h.roundTrip(ctx, hreq)
+	if err != nil {
+		return nil, err
+	}
+	return &httpDeltaUpdates{body: resp.Body, dec: json.NewDecoder(resp.Body)}, nil
+}
+
+// httpDeltaUpdates reads newline delimited DeltaConfigResponses from a delta watch response.
+type httpDeltaUpdates struct {
+	body io.ReadCloser
+	dec  *json.Decoder
+}
+
+func (h *httpDeltaUpdates) Recv() (*DeltaConfigResponse, error) {
+	ret := &DeltaConfigResponse{}
+	if err := h.dec.Decode(ret); err != nil {
+		h.body.Close()
+		return nil, err
+	}
+	return ret, nil
+}
+
+// deltaState is the configuration a client has assembled from delta responses. It outlives
+// individual streams, so reconnecting only transfers what changed in the meantime.
+type deltaState struct {
+	tree        map[string]interface{}
+	versions    map[string]string
+	fingerprint string
+}
+
+func newDeltaState() *deltaState {
+	return &deltaState{tree: map[string]interface{}{}, versions: map[string]string{}}
+}
+
+// apply merges resp into the state. It returns whether the configuration changed, and an error
+// if the result does not match the server's fingerprint, in which case the state is reset so
+// the next stream transfers the complete configuration.
+func (d *deltaState) apply(resp *DeltaConfigResponse) (bool, error) {
+	for _, k := range resp.Removed {
+		delete(d.tree, k)
+		delete(d.versions, k)
+	}
+	for k, v := range resp.Updated {
+		d.tree[k] = v
+		d.versions[k] = resp.Versions[k]
+	}
+
+	fp, err := fingerprintTree(d.tree)
+	if err != nil {
+		return false, err
+	}
+	if fp != resp.Fingerprint {
+		*d = *newDeltaState()
+		return false, fmt.Errorf("incremental configuration is out of sync with the config server, requesting a full update")
+	}
+
+	changed := fp != d.fingerprint
+	d.fingerprint = fp
+	return changed, nil
+}
+
+func (y *yamlContainer) watchServerDelta(ctx context.Context, client DeltaConfigClient, state *deltaState, b *Backoff) error {
+	req := &DeltaConfigRequest{ConfigRequest: configRequestFor(y.env), Versions: map[string]string{}}
+	for k, v := range state.versions {
+		req.Versions[k] = v
+	}
+	updates, err := client.WatchDelta(ctx, req)
+	if err != nil {
+		return err
+	}
+
+	name := "server:" + req.Environment.String()
+	for {
+		resp, err := updates.Recv()
+		if err != nil {
+			return err
+		}
+		if resp.Version != DistributionProtocolVersion {
+			return fmt.Errorf("config server speaks protocol version %d, expected %d", resp.Version, DistributionProtocolVersion)
+		}
+		b.Reset()
+
+		changed, err := state.apply(resp)
+		if err != nil {
+			return err
+		}
+		if !changed {
+			continue
+		}
+
+		cacheServerResponse(ctx, y.opts, &req.ConfigRequest, &ConfigResponse{
+			Version:     DistributionProtocolVersion,
+			Fingerprint: state.fingerprint,
+			Config:      state.tree,
+		})
+
+		// failures are reported to observers, and the previous config keeps being served
+		_ = y.reload(TriggerWatch, func() (*snapshot, error) {
+			return buildExpandedSnapshot(y.env, y.opts, name, state.tree)
+		})
+	}
+}
@@ -0,0 +1,265 @@
+package cfx
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// deltaPipe is a DeltaConfigStream whose responses are read back through DeltaConfigUpdates.
+type deltaPipe struct {
+	ctx context.Context
+	ch  chan *DeltaConfigResponse
+}
+
+func newDeltaPipe(ctx context.Context) *deltaPipe {
+	return &deltaPipe{ctx: ctx, ch: make(chan *DeltaConfigResponse)}
+}
+
+func (p *deltaPipe) Context() context.Context { return p.ctx }
+
+func (p *deltaPipe) Send(resp *DeltaConfigResponse) error {
+	select {
+	case p.ch <- resp:
+		return nil
+	case <-p.ctx.Done():
+		return p.ctx.Err()
+	}
+}
+
+func (p *deltaPipe) Recv() (*DeltaConfigResponse, error) {
+	select {
+	case resp := <-p.ch:
+		return resp, nil
+	case <-p.ctx.Done():
+		return nil, p.ctx.Err()
+	}
+}
+
+func recvDelta(t *testing.T, p *deltaPipe) *DeltaConfigResponse {
+	t.Helper()
+	select {
+	case resp := <-p.ch:
+		return resp
+	case <-time.After(5 * time.Second):
+		t.Fatal("no delta response from the config server")
+		return nil
+	}
+}
+
+// serverDeltaClient is a DeltaConfigClient calling a ConfigServer in process. It records every
+// delta request, and replaces the fingerprint of the response numbered corrupt.
+type serverDeltaClient struct {
+	s       *ConfigServer
+	corrupt int
+
+	mu   sync.Mutex
+	reqs []DeltaConfigRequest
+	sent int
+}
+
+func (c *serverDeltaClient) Fetch(ctx context.Context, req *ConfigRequest) (*ConfigResponse, error) {
+	return c.s.Fetch(ctx, req)
+}
+
+func (c *serverDeltaClient) Watch(context.Context, *ConfigRequest) (ConfigUpdates, error) {
+	return nil, errors.New("only delta watches are supported")
+}
+
+func (c *serverDeltaClient) WatchDelta(ctx context.Context, req *DeltaConfigRequest) (DeltaConfigUpdates, error) {
+	c.mu.Lock()
+	r := *req
+	r.Versions = map[string]string{}
+	for k, v := range req.Versions {
+		r.Versions[k] = v
+	}
+	c.reqs = append(c.reqs, r)
+	c.mu.Unlock()
+
+	p := newDeltaPipe(ctx)
+	go c.s.WatchDelta(req, p)
+	return &corruptingUpdates{c: c, p: p}, nil
+}
+
+func (c *serverDeltaClient) requests() []DeltaConfigRequest {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]DeltaConfigRequest{}, c.reqs...)
+}
+
+type corruptingUpdates struct {
+	c *serverDeltaClient
+	p *deltaPipe
+}
+
+func (u *corruptingUpdates) Recv() (*DeltaConfigResponse, error) {
+	resp, err := u.p.Recv()
+	if err != nil {
+		return nil, err
+	}
+	u.c.mu.Lock()
+	defer u.c.mu.Unlock()
+	u.c.sent++
+	if u.c.sent == u.c.corrupt {
+		resp.Fingerprint = "corrupt"
+	}
+	return resp, nil
+}
+
+// reloadServerEntry rewrites production.yaml and reloads the entry serving req.
+func reloadServerEntry(t *testing.T, s *ConfigServer, dir string, req ConfigRequest, data string) {
+	t.Helper()
+	writeTestConfig(t, dir, "production.yaml", data)
+	e, err := s.entry(req, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.c.Reload(TriggerManual); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWatchDeltaSendsChangedAndRemovedSections(t *testing.T) {
+	dir := testDir(t)
+	writeTestConfig(t, dir, "production.yaml", "a:\n  v: 1\nb:\n  v: 2\n")
+	s := NewConfigServer(EnvContext{ConfigPath: dir})
+	defer s.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req := ConfigRequest{Environment: "production"}
+	p := newDeltaPipe(ctx)
+	go s.WatchDelta(&DeltaConfigRequest{ConfigRequest: req}, p)
+
+	state := newDeltaState()
+	first := recvDelta(t, p)
+	if len(first.Updated) != 2 || len(first.Removed) != 0 {
+		t.Fatalf("first response updates %v and removes %v, want both sections and no removals", first.Updated, first.Removed)
+	}
+	if _, err := state.apply(first); err != nil {
+		t.Fatal(err)
+	}
+
+	reloadServerEntry(t, s, dir, req, "a:\n  v: 1\nc:\n  v: 3\n")
+	next := recvDelta(t, p)
+	if _, ok := next.Updated["c"]; !ok || len(next.Updated) != 1 {
+		t.Errorf("updated sections = %v, want only c", next.Updated)
+	}
+	if !reflect.DeepEqual(next.Removed, []string{"b"}) {
+		t.Errorf("removed sections = %v, want [b]", next.Removed)
+	}
+	if next.Versions["c"] == "" {
+		t.Error("no version sent for the updated section")
+	}
+
+	changed, err := state.apply(next)
+	if err != nil || !changed {
+		t.Fatalf("apply = %v, %v, want a change in sync with the server", changed, err)
+	}
+	if _, ok := state.tree["b"]; ok {
+		t.Error("removed section b is still in the client state")
+	}
+	if _, ok := state.versions["b"]; ok {
+		t.Error("removed section b still has a version")
+	}
+}
+
+func TestWatchDeltaReconnectWithKnownVersions(t *testing.T) {
+	dir := testDir(t)
+	writeTestConfig(t, dir, "production.yaml", "a:\n  v: 1\nb:\n  v: 2\n")
+	s := NewConfigServer(EnvContext{ConfigPath: dir})
+	defer s.Close()
+
+	req := ConfigRequest{Environment: "production"}
+	watch := func(known map[string]string) *DeltaConfigResponse {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		p := newDeltaPipe(ctx)
+		go s.WatchDelta(&DeltaConfigRequest{ConfigRequest: req, Versions: known}, p)
+		return recvDelta(t, p)
+	}
+
+	full := watch(nil)
+	if len(full.Versions) != 2 {
+		t.Fatalf("versions = %v, want one per section", full.Versions)
+	}
+
+	resumed := watch(full.Versions)
+	if len(resumed.Updated) != 0 || len(resumed.Removed) != 0 {
+		t.Errorf("reconnecting with current versions resent %v and removed %v, want nothing", resumed.Updated, resumed.Removed)
+	}
+	if resumed.Fingerprint != full.Fingerprint {
+		t.Errorf("fingerprint = %s, want %s", resumed.Fingerprint, full.Fingerprint)
+	}
+
+	stale := watch(map[string]string{"a": full.Versions["a"], "b": "stale", "gone": "x"})
+	if _, ok := stale.Updated["b"]; !ok || len(stale.Updated) != 1 {
+		t.Errorf("updated sections = %v, want only the stale section b", stale.Updated)
+	}
+	if !reflect.DeepEqual(stale.Removed, []string{"gone"}) {
+		t.Errorf("removed sections = %v, want [gone]", stale.Removed)
+	}
+}
+
+func TestWatchServerDeltaResyncsOnFingerprintMismatch(t *testing.T) {
+	dir := testDir(t)
+	writeTestConfig(t, dir, "production.yaml", "db:\n  host: old.internal\ncache:\n  size: 1\n")
+	s := NewConfigServer(EnvContext{ConfigPath: dir})
+	defer s.Close()
+
+	reloaded := make(chan struct{}, 4)
+	observe := WithReloadObserver(func(ev ReloadEvent) {
+		if ev.Trigger == TriggerWatch && ev.Err == nil {
+			reloaded <- struct{}{}
+		}
+	})
+	client := &serverDeltaClient{s: s, corrupt: 2}
+	c, err := NewConfigWithOptions(EnvContext{Environment: "production"}, WithConfigServer(client), observe)
+	if err != nil {
+		t.Fatal(err)
+	}
+	y := c.(*yamlContainer)
+	req := configRequestFor(y.env)
+	b := RetryPolicy{InitialInterval: time.Millisecond}.Backoff()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	state := newDeltaState()
+	done := make(chan error, 1)
+	go func() { done <- y.watchServerDelta(ctx, client, state, b) }()
+	<-reloaded
+
+	// the second response is corrupted, so the client cannot trust its state
+	reloadServerEntry(t, s, dir, req, "db:\n  host: new.internal\ncache:\n  size: 1\n")
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Fatal("watch ended without an error on a fingerprint mismatch")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("watch did not end on a fingerprint mismatch")
+	}
+	if len(state.versions) != 0 || len(state.tree) != 0 {
+		t.Errorf("state after a mismatch = %v, want it reset", state.versions)
+	}
+
+	go func() { done <- y.watchServerDelta(ctx, client, state, b) }()
+	<-reloaded
+
+	reqs := client.requests()
+	if len(reqs) != 2 || len(reqs[1].Versions) != 0 {
+		t.Fatalf("requests = %+v, want the resync to ask for the full configuration", reqs)
+	}
+	var db struct {
+		Host string `yaml:"host"`
+	}
+	if err := c.Populate("db", &db); err != nil {
+		t.Fatal(err)
+	}
+	if db.Host != "new.internal" {
+		t.Errorf("host = %q after the resync, want new.internal", db.Host)
+	}
+}
@@ -0,0 +1,464 @@
+package cfx
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+const (
+	// ExprTag marks a scalar as an expression computed when the configuration is loaded:
+	//
+	//   http:
+	//     max_conns: !expr "max(cpus * 4, 16)"
+	//     idle_conns: !expr "cfg.http.max_conns / 2"
+	//
+	// Expressions are numeric. They support + - * / %, parentheses, the functions min, max,
+	// ceil, floor, round and abs, and the identifiers cpus (usable cores, honouring cgroup
+	// quotas), num_cpu (logical CPUs), memory_limit (cgroup memory limit in bytes, or 0) and
+	// cfg.<key>, the numeric value of another config key. Expressions referring to each other
+	// are evaluated in dependency order, and cycles are reported as errors.
+	ExprTag = "!expr"
+
+	// _exprStanzaKey is the key of the stanza an !expr scalar is rewritten into, so that it
+	// survives merging. YAML decoders drop unknown tags.
+	_exprStanzaKey = "_cfx_expr"
+
+	_maxExprLength = 1024
+	_maxExprDepth  = 64
+)
+
+// rewriteExprTags replaces every `!expr <scalar>` value in the YAML source with an expression
+// stanza.
+func rewriteExprTags(data []byte) []byte {
+	if !bytes.Contains(data, []byte(ExprTag)) {
+		return data
+	}
+
+	var out bytes.Buffer
+	out.Grow(len(data))
+
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	sc.Buffer(make([]byte, 0, 64*1024), len(data)+1)
+	for sc.Scan() {
+		out.WriteString(rewriteExprLine(sc.Text()))
+		out.WriteByte('\n')
+	}
+	return out.Bytes()
+}
+
+func rewriteExprLine(line string) string {
+	idx := strings.Index(line, ExprTag+" ")
+	if idx < 0 {
+		return line
+	}
+
+	// the tag must start the value of a mapping key
+	before := strings.TrimRight(line[:idx], " ")
+	if !strings.HasSuffix(before, ":") {
+		return line
+	}
+	if strings.Contains(before, "#") {
+		return line
+	}
+
+	rest := strings.TrimSpace(line[idx+len(ExprTag):])
+	var expr string
+	switch {
+	case strings.HasPrefix(rest, `"`):
+		end := 1
+		for end < len(rest) && rest[end] != '"' {
+			if rest[end] == '\\' {
+				end++
+			}
+			end++
+		}
+		if end >= len(rest) {
+			return line
+		}
+		v, err := strconv.Unquote(rest[:end+1])
+		if err != nil {
+			return line
+		}
+		expr = v
+	case strings.HasPrefix(rest, `'`):
+		end := strings.Index(rest[1:], `'`)
+		if end < 0 {
+			return line
+		}
+		expr = rest[1 : end+1]
+	default:
+		expr = rest
+		if i := strings.Index(expr, " #"); i >= 0 {
+			expr = expr[:i]
+		}
+		expr = strings.TrimSpace(expr)
+	}
+
+	return line[:idx] + "{" + _exprStanzaKey + ": " + strconv.Quote(expr) + "}"
+}
+
+// resolveExprs replaces every expression stanza in the tree with its value. It returns true
+// if any stanza was found.
+func resolveExprs(env EnvContext, tree map[string]interface{}) (bool, error) {
+	r := &exprResolver{env: env, tree: tree, visiting: map[string]bool{}}
+
+	var keys []string
+	collectExprKeys("", tree, &keys)
+	if len(keys) == 0 {
+		return false, nil
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if _, err := r.resolveKey(key); err != nil {
+			return true, err
+		}
+	}
+	return true, nil
+}
+
+func collectExprKeys(prefix string, m map[string]interface{}, keys *[]string) {
+	for k, v := range m {
+		child, ok := v.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		key := joinKey(prefix, k)
+		if _, ok := exprStanza(child); ok {
+			*keys = append(*keys, key)
+			continue
+		}
+		collectExprKeys(key, child, keys)
+	}
+}
+
+func exprStanza(m map[string]interface{}) (string, bool) {
+	if len(m) != 1 {
+		return "", false
+	}
+	expr, ok := m[_exprStanzaKey].(string)
+	return expr, ok
+}
+
+type exprResolver struct {
+	env      EnvContext
+	tree     map[string]interface{}
+	visiting map[string]bool
+	stack    []string
+}
+
+// resolveKey evaluates the expression at key, if it still holds one, and stores the result.
+func (r *exprResolver) resolveKey(key string) (interface{}, error) {
+	val, ok := lookupTree(r.tree, key)
+	if !ok {
+		return nil, fmt.Errorf("config key %s is not set", key)
+	}
+	m, ok := val.(map[string]interface{})
+	if !ok {
+		return val, nil
+	}
+	expr, ok := exprStanza(m)
+	if !ok {
+		return val, nil
+	}
+
+	if r.visiting[key] {
+		return nil, fmt.Errorf("expression cycle: %s -> %s", strings.Join(r.stack, " -> "), key)
+	}
+	r.visiting[key] = true
+	r.stack = append(r.stack, key)
+	defer func() {
+		delete(r.visiting, key)
+		r.stack = r.stack[:len(r.stack)-1]
+	}()
+
+	n, err := evalExpr(expr, r.lookup)
+	if err != nil {
+		return nil, fmt.Errorf("could not evaluate expression %q at %s: %v", expr, key, err)
+	}
+
+	var ret interface{} = n
+	if n == math.Trunc(n) && math.Abs(n) < 1<<53 {
+		ret = int(n)
+	}
+	setTree(r.tree, key, ret)
+	return ret, nil
+}
+
+func (r *exprResolver) lookup(name string) (float64, error) {
+	switch name {
+	case "cpus":
+		return r.env.Resources.CPUs(), nil
+	case "num_cpu":
+		return float64(r.env.Resources.NumCPU), nil
+	case "memory_limit":
+		return float64(r.env.Resources.MemoryLimit), nil
+	}
+
+	if !strings.HasPrefix(name, "cfg.") {
+		return 0, fmt.Errorf("unknown identifier %q", name)
+	}
+	key := strings.TrimPrefix(name, "cfg.")
+	val, err := r.resolveKey(key)
+	if err != nil {
+		return 0, err
+	}
+	switch t := val.(type) {
+	case int:
+		return float64(t), nil
+	case int64:
+		return float64(t), nil
+	case uint64:
+		return float64(t), nil
+	case float64:
+		return t, nil
+	case string:
+		if f, err := strconv.ParseFloat(t, 64); err == nil {
+			return f, nil
+		}
+	}
+	return 0, fmt.Errorf("config key %s is not a number", key)
+}
+
+// setTree sets the value at the dotted key path, which must already exist.
+func setTree(tree map[string]interface{}, key string, val interface{}) {
+	parts := strings.Split(key, ".")
+	m := tree
+	for _, part := range parts[:len(parts)-1] {
+		next, ok := m[part].(map[string]interface{})
+		if !ok {
+			return
+		}
+		m = next
+	}
+	m[parts[len(parts)-1]] = val
+}
+
+// evalExpr evaluates a numeric expression, resolving identifiers with lookup.
+func evalExpr(expr string, lookup func(string) (float64, error)) (float64, error) {
+	if len(expr) > _maxExprLength {
+		return 0, fmt.Errorf("expression is longer than %d characters", _maxExprLength)
+	}
+	p := &exprParser{src: expr, lookup: lookup}
+	v, err := p.parseSum()
+	if err != nil {
+		return 0, err
+	}
+	p.skipSpace()
+	if p.pos < len(p.src) {
+		return 0, fmt.Errorf("unexpected %q", p.src[p.pos:])
+	}
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return 0, fmt.Errorf("result is not a finite number")
+	}
+	return v, nil
+}
+
+type exprParser struct {
+	src    string
+	pos    int
+	depth  int
+	lookup func(string) (float64, error)
+}
+
+var _exprFuncs = map[string]func([]float64) (float64, error){
+	"min": func(args []float64) (float64, error) {
+		if len(args) == 0 {
+			return 0, fmt.Errorf("min needs at least one argument")
+		}
+		ret := args[0]
+		for _, a := range args[1:] {
+			ret = math.Min(ret, a)
+		}
+		return ret, nil
+	},
+	"max": func(args []float64) (float64, error) {
+		if len(args) == 0 {
+			return 0, fmt.Errorf("max needs at least one argument")
+		}
+		ret := args[0]
+		for _, a := range args[1:] {
+			ret = math.Max(ret, a)
+		}
+		return ret, nil
+	},
+	"ceil":  unaryExprFunc("ceil", math.Ceil),
+	"floor": unaryExprFunc("floor", math.Floor),
+	"round": unaryExprFunc("round", math.Round),
+	"abs":   unaryExprFunc("abs", math.Abs),
+}
+
+func unaryExprFunc(name string, fn func(float64) float64) func([]float64) (float64, error) {
+	return func(args []float64) (float64, error) {
+		if len(args) != 1 {
+			return 0, fmt.Errorf("%s takes exactly one argument", name)
+		}
+		return fn(args[0]), nil
+	}
+}
+
+func (p *exprParser) skipSpace() {
+	for p.pos < len(p.src) && (p.src[p.pos] == ' ' || p.src[p.pos] == '\t') {
+		p.pos++
+	}
+}
+
+func (p *exprParser) accept(c byte) bool {
+	p.skipSpace()
+	if p.pos < len(p.src) && p.src[p.pos] == c {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *exprParser) parseSum() (float64, error) {
+	p.depth++
+	defer func() { p.depth-- }()
+	if p.depth > _maxExprDepth {
+		return 0, fmt.Errorf("expression is nested too deeply")
+	}
+
+	left, err := p.parseProduct()
+	if err != nil {
+		return 0, err
+	}
+	for {
+		switch {
+		case p.accept('+'):
+			right, err := p.parseProduct()
+			if err != nil {
+				return 0, err
+			}
+			left += right
+		case p.accept('-'):
+			right, err := p.parseProduct()
+			if err != nil {
+				return 0, err
+			}
+			left -= right
+		default:
+			return left, nil
+		}
+	}
+}
+
+func (p *exprParser) parseProduct() (float64, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return 0, err
+	}
+	for {
+		var op byte
+		switch {
+		case p.accept('*'):
+			op = '*'
+		case p.accept('/'):
+			op = '/'
+		case p.accept('%'):
+			op = '%'
+		default:
+			return left, nil
+		}
+
+		right, err := p.parseUnary()
+		if err != nil {
+			return 0, err
+		}
+		switch op {
+		case '*':
+			left *= right
+		case '/':
+			if right == 0 {
+				return 0, fmt.Errorf("division by zero")
+			}
+			left /= right
+		case '%':
+			if right == 0 {
+				return 0, fmt.Errorf("division by zero")
+			}
+			left = math.Mod(left, right)
+		}
+	}
+}
+
+func (p *exprParser) parseUnary() (float64, error) {
+	if p.accept('-') {
+		v, err := p.parseUnary()
+		return -v, err
+	}
+	if p.accept('(') {
+		v, err := p.parseSum()
+		if err != nil {
+			return 0, err
+		}
+		if !p.accept(')') {
+			return 0, fmt.Errorf("missing closing parenthesis")
+		}
+		return v, nil
+	}
+	return p.parseAtom()
+}
+
+func (p *exprParser) parseAtom() (float64, error) {
+	p.skipSpace()
+	start := p.pos
+	if p.pos >= len(p.src) {
+		return 0, fmt.Errorf("unexpected end of expression")
+	}
+
+	c := rune(p.src[p.pos])
+	if unicode.IsDigit(c) || c == '.' {
+		for p.pos < len(p.src) && (unicode.IsDigit(rune(p.src[p.pos])) || p.src[p.pos] == '.') {
+			p.pos++
+		}
+		return strconv.ParseFloat(p.src[start:p.pos], 64)
+	}
+
+	if !unicode.IsLetter(c) && c != '_' {
+		return 0, fmt.Errorf("unexpected %q", p.src[p.pos:])
+	}
+	for p.pos < len(p.src) {
+		c := rune(p.src[p.pos])
+		if !unicode.IsLetter(c) && !unicode.IsDigit(c) && c != '_' && c != '.' {
+			break
+		}
+		p.pos++
+	}
+	name := p.src[start:p.pos]
+
+	fn, isFunc := _exprFuncs[name]
+	if !p.accept('(') {
+		if isFunc {
+			return 0, fmt.Errorf("function %s must be called", name)
+		}
+		return p.lookup(name)
+	}
+	if !isFunc {
+		return 0, fmt.Errorf("unknown function %q", name)
+	}
+
+	var args []float64
+	if !p.accept(')') {
+		for {
+			v, err := p.parseSum()
+			if err != nil {
+				return 0, err
+			}
+			args = append(args, v)
+			if p.accept(')') {
+				break
+			}
+			if !p.accept(',') {
+				return 0, fmt.Errorf("expected , or ) in call to %s", name)
+			}
+		}
+	}
+	return fn(args)
+}
@@ -0,0 +1,156 @@
+package cfx
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+
+	"go.uber.org/config"
+)
+
+// DifferentialReport describes how the configuration built by cfx differs from the one
+// go.uber.org/config builds from the same inputs.
+type DifferentialReport struct {
+	// Sources are the inputs both sides were given, in merge order.
+	Sources []string `json:"sources" yaml:"sources"`
+
+	// Divergences are the leaf keys that differ, with the go.uber.org/config value as Old and the
+	// cfx value as New: a key only cfx produced is "added", one only go.uber.org/config produced
+	// is "removed".
+	Divergences []Change `json:"divergences,omitempty" yaml:"divergences,omitempty"`
+
+	// UberError is set when go.uber.org/config failed to load the inputs.
+	UberError string `json:"uber_error,omitempty" yaml:"uber_error,omitempty"`
+
+	// CFXError is set when cfx failed to load the inputs.
+	CFXError string `json:"cfx_error,omitempty" yaml:"cfx_error,omitempty"`
+}
+
+// Equivalent reports whether both sides loaded the inputs into the same configuration, or
+// both failed to load them.
+func (r DifferentialReport) Equivalent() bool {
+	return len(r.Divergences) == 0 && (r.UberError == "") == (r.CFXError == "")
+}
+
+// String implements the fmt.Stringer interface, listing one divergence per line.
+func (r DifferentialReport) String() string {
+	var sb strings.Builder
+	if r.UberError != "" {
+		fmt.Fprintf(&sb, "go.uber.org/config failed: %s\n", r.UberError)
+	}
+	if r.CFXError != "" {
+		fmt.Fprintf(&sb, "cfx failed: %s\n", r.CFXError)
+	}
+	for _, c := range r.Divergences {
+		switch c.Kind {
+		case ChangeAdded:
+			fmt.Fprintf(&sb, "%s: only in cfx (%v)\n", c.Key, c.New)
+		case ChangeRemoved:
+			fmt.Fprintf(&sb, "%s: only in go.uber.org/config (%v)\n", c.Key, c.Old)
+		default:
+			fmt.Fprintf(&sb, "%s: go.uber.org/config %v, cfx %v\n", c.Key, c.Old, c.New)
+		}
+	}
+	if sb.Len() == 0 {
+		sb.WriteString("no divergences\n")
+	}
+	return sb.String()
+}
+
+// CompareWithUberConfig loads the configuration files of env twice: through the cfx layer
+// stack with opts applied, and by passing the same files, in the same order, straight to
+// go.uber.org/config with environment variable expansion. It reports every key where the two
+// differ, so that applications migrating to cfx can check that merging and expansion behave
+// as before. Failures to load on either side are recorded in the report; an error is only
+// returned when the files cannot be located.
+func CompareWithUberConfig(env EnvContext, opts ...Option) (*DifferentialReport, error) {
+	paths, err := discoverConfigFiles(env, newOptions(opts).naming)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &DifferentialReport{Sources: paths}
+	uberOpts := make([]config.YAMLOption, 0, len(paths)+1)
+	for _, p := range paths {
+		uberOpts = append(uberOpts, config.File(p))
+	}
+	uberTree, uberErr := uberConfigTree(uberOpts)
+
+	var cfxTree map[string]interface{}
+	c, cfxErr := NewConfigWithOptions(env, opts...)
+	if cfxErr == nil {
+		if y, ok := c.(*yamlContainer); ok {
+			// the container only lives for the comparison
+			defer closePlugins(y.opts.plugins)
+		}
+		var raw interface{}
+		if cfxErr = c.Populate(config.Root, &raw); cfxErr == nil {
+			cfxTree, _ = normalizeValue(raw).(map[string]interface{})
+		}
+	}
+
+	report.compare(uberTree, uberErr, cfxTree, cfxErr)
+	return report, nil
+}
+
+// CompareSourcesWithUberConfig is like CompareWithUberConfig for YAML documents held in
+// memory, merged in the order given. It is the building block for compatibility tests:
+//
+//	report := cfx.CompareSourcesWithUberConfig(cfx.EnvContext{}, [][]byte{base, override})
+//	if !report.Equivalent() {
+//		t.Error(report)
+//	}
+func CompareSourcesWithUberConfig(env EnvContext, docs [][]byte, opts ...Option) *DifferentialReport {
+	report := &DifferentialReport{}
+	sources := make([]configSource, len(docs))
+	uberOpts := make([]config.YAMLOption, 0, len(docs)+1)
+	for i, doc := range docs {
+		sources[i] = configSource{name: fmt.Sprintf("input[%d]", i), data: doc}
+		report.Sources = append(report.Sources, sources[i].name)
+		uberOpts = append(uberOpts, config.Source(bytes.NewReader(doc)))
+	}
+	uberTree, uberErr := uberConfigTree(uberOpts)
+
+	var cfxTree map[string]interface{}
+	snap, cfxErr := buildSnapshot(env, newOptions(opts), sources)
+	if cfxErr == nil {
+		cfxTree = snap.tree
+	}
+
+	report.compare(uberTree, uberErr, cfxTree, cfxErr)
+	return report
+}
+
+// uberConfigTree loads the sources with go.uber.org/config alone, expanding environment
+// variables the way it does.
+func uberConfigTree(opts []config.YAMLOption) (map[string]interface{}, error) {
+	provider, err := config.NewYAML(append(opts, config.Expand(os.LookupEnv))...)
+	if err != nil {
+		return nil, err
+	}
+	var raw interface{}
+	if err := provider.Get(config.Root).Populate(&raw); err != nil {
+		return nil, err
+	}
+	tree, _ := normalizeValue(raw).(map[string]interface{})
+	return tree, nil
+}
+
+func (r *DifferentialReport) compare(uberTree map[string]interface{}, uberErr error, cfxTree map[string]interface{}, cfxErr error) {
+	if uberErr != nil {
+		r.UberError = uberErr.Error()
+	}
+	if cfxErr != nil {
+		r.CFXError = cfxErr.Error()
+	}
+	if uberErr == nil && cfxErr == nil {
+		if uberTree == nil {
+			uberTree = map[string]interface{}{}
+		}
+		if cfxTree == nil {
+			cfxTree = map[string]interface{}{}
+		}
+		r.Divergences = diffTrees(uberTree, cfxTree)
+	}
+}
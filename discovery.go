@@ -0,0 +1,249 @@
+package cfx
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ConsulRegistrar registers instances with the local Consul agent over its HTTP API.
+type ConsulRegistrar struct {
+	// Address is the base URL of the Consul agent, e.g. http://127.0.0.1:8500.
+	Address string
+
+	// Token is sent as the ACL token, if set.
+	Token SecretRef
+
+	// CheckHTTP, when set, registers an HTTP health check against this URL.
+	CheckHTTP string
+
+	// CheckInterval is how often the health check runs. Defaults to 10s.
+	CheckInterval time.Duration
+
+	// Client is used for requests. Defaults to http.DefaultClient.
+	Client *http.Client
+}
+
+// NewConsulRegistrar creates a ConsulRegistrar for the agent at address.
+func NewConsulRegistrar(address string) *ConsulRegistrar {
+	return &ConsulRegistrar{Address: address}
+}
+
+// Register implements the Registrar interface.
+func (c *ConsulRegistrar) Register(ctx context.Context, id ServiceIdentity) error {
+	body := map[string]interface{}{
+		"ID":      id.ID,
+		"Name":    id.Name,
+		"Tags":    id.Tags,
+		"Meta":    id.Meta,
+		"Address": id.Address,
+		"Port":    id.Port,
+	}
+	if c.CheckHTTP != "" {
+		interval := c.CheckInterval
+		if interval <= 0 {
+			interval = 10 * time.Second
+		}
+		body["Check"] = map[string]interface{}{
+			"HTTP":     c.CheckHTTP,
+			"Interval": interval.String(),
+		}
+	}
+	return c.do(ctx, "/v1/agent/service/register", body)
+}
+
+// Deregister implements the Registrar interface.
+func (c *ConsulRegistrar) Deregister(ctx context.Context, id ServiceIdentity) error {
+	return c.do(ctx, "/v1/agent/service/deregister/"+url.PathEscape(id.ID), nil)
+}
+
+func (c *ConsulRegistrar) do(ctx context.Context, p string, body interface{}) error {
+	var r io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		r = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(http.MethodPut, strings.TrimSuffix(c.Address, "/")+p, r)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	if !c.Token.IsZero() {
+		token, err := c.Token.Resolve(ctx)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("X-Consul-Token", token)
+	}
+
+	_, err = doDiscoveryRequest(c.Client, req)
+	return err
+}
+
+// EtcdRegistrar registers instances in etcd under Prefix/<name>/<id>, attached to a lease that
+// is kept alive while the instance is registered. It uses etcd's v3 JSON gateway.
+type EtcdRegistrar struct {
+	// Endpoint is the base URL of an etcd member, e.g. http://127.0.0.1:2379.
+	Endpoint string
+
+	// Prefix is the key prefix registrations are written under. Defaults to /services.
+	Prefix string
+
+	// TTL is the lease TTL. Defaults to 30s; the lease is renewed every third of it.
+	TTL time.Duration
+
+	// Client is used for requests. Defaults to http.DefaultClient.
+	Client *http.Client
+
+	mu    sync.Mutex
+	lease string
+	stop  chan struct{}
+}
+
+// NewEtcdRegistrar creates an EtcdRegistrar for the member at endpoint.
+func NewEtcdRegistrar(endpoint string) *EtcdRegistrar {
+	return &EtcdRegistrar{Endpoint: endpoint}
+}
+
+func (e *EtcdRegistrar) key(id ServiceIdentity) string {
+	prefix := e.Prefix
+	if prefix == "" {
+		prefix = "/services"
+	}
+	return path.Join(prefix, id.Name, id.ID)
+}
+
+// Register implements the Registrar interface.
+func (e *EtcdRegistrar) Register(ctx context.Context, id ServiceIdentity) error {
+	ttl := e.TTL
+	if ttl <= 0 {
+		ttl = 30 * time.Second
+	}
+
+	var grant struct {
+		ID string `json:"ID"`
+	}
+	if err := e.call(ctx, "/v3/lease/grant", map[string]interface{}{"TTL": int64(ttl / time.Second)}, &grant); err != nil {
+		return fmt.Errorf("could not grant lease: %v", err)
+	}
+
+	value, err := json.Marshal(id)
+	if err != nil {
+		return err
+	}
+	put := map[string]interface{}{
+		"key":   base64.StdEncoding.EncodeToString([]byte(e.key(id))),
+		"value": base64.StdEncoding.EncodeToString(value),
+		"lease": grant.ID,
+	}
+	if err := e.call(ctx, "/v3/kv/put", put, nil); err != nil {
+		return err
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.lease = grant.ID
+	e.stop = make(chan struct{})
+	go e.keepAlive(grant.ID, ttl/3, e.stop)
+
+	return nil
+}
+
+// Deregister implements the Registrar interface.
+func (e *EtcdRegistrar) Deregister(ctx context.Context, id ServiceIdentity) error {
+	e.mu.Lock()
+	lease, stop := e.lease, e.stop
+	e.lease, e.stop = "", nil
+	e.mu.Unlock()
+
+	if stop != nil {
+		close(stop)
+	}
+
+	del := map[string]interface{}{
+		"key": base64.StdEncoding.EncodeToString([]byte(e.key(id))),
+	}
+	if err := e.call(ctx, "/v3/kv/deleterange", del, nil); err != nil {
+		return err
+	}
+	if lease != "" {
+		return e.call(ctx, "/v3/lease/revoke", map[string]interface{}{"ID": lease}, nil)
+	}
+	return nil
+}
+
+func (e *EtcdRegistrar) keepAlive(lease string, every time.Duration, stop chan struct{}) {
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), every)
+			// a failed renewal is retried on the next tick, before the lease runs out
+			_ = e.call(ctx, "/v3/lease/keepalive", map[string]interface{}{"ID": lease}, nil)
+			cancel()
+		}
+	}
+}
+
+func (e *EtcdRegistrar) call(ctx context.Context, p string, body interface{}, out interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, strings.TrimSuffix(e.Endpoint, "/")+p, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := doDiscoveryRequest(e.Client, req)
+	if err != nil || out == nil {
+		return err
+	}
+	return json.Unmarshal(resp, out)
+}
+
+// doDiscoveryRequest performs req and returns the body of a successful response.
+func doDiscoveryRequest(client *http.Client, req *http.Request) ([]byte, error) {
+	if err := checkNetworkAllowed("service registration with " + req.URL.Host); err != nil {
+		return nil, err
+	}
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("%s %s returned %d: %s", req.Method, req.URL.Path, resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return body, nil
+}
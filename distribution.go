@@ -0,0 +1,958 @@
+package cfx
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/fx"
+)
+
+// DistributionProtocolVersion is the version of the ConfigResponse served by a ConfigServer.
+const DistributionProtocolVersion = 1
+
+// DefaultServerEntryLimit is how many distinct ConfigRequests a ConfigServer serves at once,
+// unless WithServerEntryLimit is given. Every one holds a Container and a watch goroutine
+// until it is evicted.
+const DefaultServerEntryLimit = 64
+
+var (
+	// ErrConfigRequestNotAllowed is returned by a ConfigServer for requests that do not match
+	// any of the requests given with WithAllowedConfigRequests.
+	ErrConfigRequestNotAllowed = errors.New("config request is not allowed by the config server")
+
+	// ErrTooManyConfigRequests is returned by a ConfigServer already serving as many distinct
+	// requests as its entry limit allows, all of them to active watchers.
+	ErrTooManyConfigRequests = errors.New("config server is serving too many distinct config requests")
+)
+
+// ConfigRequest identifies the configuration a client asks a ConfigServer for. The server
+// loads it for an EnvContext with these fields set, so app and service specific overrides
+// (see WithKeyHierarchy) and guards apply as they would on the client.
+type ConfigRequest struct {
+	Environment      EnvID  `json:"environment"`
+	AppID            string `json:"app_id,omitempty"`
+	ServiceID        string `json:"service_id,omitempty"`
+	Region           string `json:"region,omitempty"`
+	AvailabilityZone string `json:"availability_zone,omitempty"`
+}
+
+// ConfigResponse is the merged configuration served by a ConfigServer.
+type ConfigResponse struct {
+	// Version is the protocol version.
+	Version int `json:"version"`
+
+	// Environment is the environment the server loaded the configuration in. Only the fields of
+	// the ConfigRequest are set; nothing about the server's host or process is sent.
+	Environment EnvContext `json:"environment"`
+
+	// Fingerprint is the fingerprint of the configuration.
+	Fingerprint string `json:"fingerprint"`
+
+	// Sources lists the sources the server merged the configuration from.
+	Sources []ReportSource `json:"sources,omitempty"`
+
+	// Config is the merged and expanded configuration, with sensitive values redacted.
+	Config map[string]interface{} `json:"config"`
+}
+
+// ConfigStream is the server side of a Watch call. Its shape matches a gRPC server stream, so
+// generated gRPC handlers can pass their stream to ConfigServer.Watch after converting messages.
+type ConfigStream interface {
+	Context() context.Context
+	Send(*ConfigResponse) error
+}
+
+// ConfigUpdates is the client side of a Watch call. Its shape matches a gRPC client stream.
+type ConfigUpdates interface {
+	Recv() (*ConfigResponse, error)
+}
+
+// ConfigClient fetches configuration from a ConfigServer. NewHTTPConfigClient returns one
+// speaking HTTP; applications using gRPC adapt their generated client to this interface.
+type ConfigClient interface {
+	Fetch(ctx context.Context, req *ConfigRequest) (*ConfigResponse, error)
+	Watch(ctx context.Context, req *ConfigRequest) (ConfigUpdates, error)
+}
+
+// ConfigServer serves merged, per-service configuration from a config directory to a fleet of
+// clients, streaming updates as the files change. It is transport agnostic: ServeHTTP speaks
+// JSON over HTTP, and the cfxserver module serves the gRPC service in proto/config.proto.
+// Authentication and TLS are left to the transport.
+//
+// Requests that differ only by app share a Container loaded without an app, so guards on
+// app_id do not match, unless the server is created with WithKeyHierarchy, whose app overrides
+// need the app.
+//
+// Values the Container's Redactor considers sensitive are redacted from responses, unless they
+// are secret references for a registered resolver, which clients resolve themselves. The
+// server's own EnvContext is never sent.
+type ConfigServer struct {
+	env  EnvContext
+	opts []Option
+
+	limit      int
+	allowed    []ConfigRequest
+	appsDiffer bool
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu      sync.Mutex
+	entries map[ConfigRequest]*serverEntry
+}
+
+// serverEntry is the Container serving one ConfigRequest.
+type serverEntry struct {
+	c      Container
+	env    EnvContext
+	cancel context.CancelFunc
+
+	// watchers and lastUsed are guarded by the server's lock.
+	watchers int
+	lastUsed time.Time
+
+	// ready is closed once c is loaded, or err is set.
+	ready chan struct{}
+	err   error
+
+	mu      sync.Mutex
+	changed chan struct{}
+
+	// tree and fingerprint cache the served configuration of the snapshot with fingerprint
+	// servedOf.
+	tree        map[string]interface{}
+	fingerprint string
+	servedOf    string
+
+	// versions caches the section versions of the served configuration with fingerprint
+	// versionsOf.
+	versions   map[string]string
+	versionsOf string
+}
+
+func (e *serverEntry) signal() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	close(e.changed)
+	e.changed = make(chan struct{})
+}
+
+func (e *serverEntry) changes() <-chan struct{} {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.changed
+}
+
+// WithServerEntryLimit sets how many distinct ConfigRequests a ConfigServer serves at once.
+// Once the limit is reached, the least recently used entry without active watchers is evicted
+// to make room; when every entry is being watched, new requests fail with
+// ErrTooManyConfigRequests. Defaults to DefaultServerEntryLimit.
+func WithServerEntryLimit(n int) Option {
+	return func(o *options) {
+		o.serverEntryLimit = n
+	}
+}
+
+// WithAllowedConfigRequests restricts a ConfigServer to the given requests. A field set to "*"
+// matches any value. Other requests fail with ErrConfigRequestNotAllowed.
+func WithAllowedConfigRequests(reqs ...ConfigRequest) Option {
+	return func(o *options) {
+		o.serverAllowed = append(o.serverAllowed, reqs...)
+	}
+}
+
+// NewConfigServer creates a ConfigServer loading configuration from env.ConfigPath. The
+// options apply to every Container the server creates; the configuration files are polled
+// at the interval set with WithHotReload, or every five seconds.
+func NewConfigServer(env EnvContext, opts ...Option) *ConfigServer {
+	o := newOptions(opts)
+	limit := o.serverEntryLimit
+	if limit <= 0 {
+		limit = DefaultServerEntryLimit
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	return &ConfigServer{
+		env:        env,
+		opts:       opts,
+		limit:      limit,
+		allowed:    o.serverAllowed,
+		appsDiffer: o.keyHierarchy,
+		ctx:        ctx,
+		cancel:     cancel,
+		entries:    map[ConfigRequest]*serverEntry{},
+	}
+}
+
+// Close stops watching the configuration files and ends every Watch call.
+func (s *ConfigServer) Close() error {
+	s.cancel()
+	return nil
+}
+
+// allows reports whether req matches the allowlist, if there is one.
+func (s *ConfigServer) allows(req ConfigRequest) bool {
+	if len(s.allowed) == 0 {
+		return true
+	}
+	match := func(pattern, v string) bool {
+		return pattern == "*" || pattern == v
+	}
+	for _, a := range s.allowed {
+		if match(a.Environment.String(), req.Environment.String()) && match(a.AppID, req.AppID) &&
+			match(a.ServiceID, req.ServiceID) && match(a.Region, req.Region) &&
+			match(a.AvailabilityZone, req.AvailabilityZone) {
+			return true
+		}
+	}
+	return false
+}
+
+// validate checks the fields of a request before they are used to locate configuration
+// files, so that a client cannot make the server read files outside its config directory.
+func (r ConfigRequest) validate() error {
+	if r.Environment == "" {
+		return errors.New("config request must name an environment")
+	}
+	if _, err := ParseEnv(r.Environment.String()); err != nil {
+		return fmt.Errorf("invalid environment in config request: %v", err)
+	}
+	fields := []struct{ name, value string }{
+		{"app_id", r.AppID},
+		{"service_id", r.ServiceID},
+		{"region", r.Region},
+		{"availability_zone", r.AvailabilityZone},
+	}
+	for _, f := range fields {
+		if err := validRequestField(f.value); err != nil {
+			return fmt.Errorf("invalid %s in config request: %v", f.name, err)
+		}
+	}
+	return nil
+}
+
+// validRequestField checks a deployment field of a ConfigRequest. Fields are joined into file
+// names with dots, so they cannot hold path separators, start with a dot or have empty
+// dot separated segments.
+func validRequestField(v string) error {
+	if v == "" {
+		return nil
+	}
+	if len(v) > 128 {
+		return errors.New("longer than 128 characters")
+	}
+	for _, c := range v {
+		if c == '/' || c == '\\' || c < 0x20 || c == 0x7f {
+			return errors.New("contains a path separator or control character")
+		}
+	}
+	for _, seg := range strings.Split(v, ".") {
+		if seg == "" {
+			return errors.New("starts with a dot or has an empty segment")
+		}
+	}
+	return nil
+}
+
+// entryKey returns the request an entry is kept under. The app only selects configuration
+// through key hierarchy overlays, so without them every app shares an entry.
+func (s *ConfigServer) entryKey(req ConfigRequest) ConfigRequest {
+	if !s.appsDiffer {
+		req.AppID = ""
+	}
+	return req
+}
+
+// entry returns the entry serving req, loading it if needed. With watch set, the entry is held
+// for a watcher and cannot be evicted until it is released.
+func (s *ConfigServer) entry(req ConfigRequest, watch bool) (*serverEntry, error) {
+	if err := req.validate(); err != nil {
+		return nil, err
+	}
+	if !s.allows(req) {
+		return nil, ErrConfigRequestNotAllowed
+	}
+	req = s.entryKey(req)
+
+	s.mu.Lock()
+	if e, ok := s.entries[req]; ok {
+		e.lastUsed = time.Now()
+		if watch {
+			e.watchers++
+		}
+		s.mu.Unlock()
+		<-e.ready
+		if e.err != nil {
+			if watch {
+				s.release(e)
+			}
+			return nil, e.err
+		}
+		return e, nil
+	}
+	if len(s.entries) >= s.limit && !s.evictLocked() {
+		s.mu.Unlock()
+		return nil, ErrTooManyConfigRequests
+	}
+	e := &serverEntry{changed: make(chan struct{}), ready: make(chan struct{}), lastUsed: time.Now()}
+	if watch {
+		e.watchers++
+	}
+	s.entries[req] = e
+	s.mu.Unlock()
+
+	// load outside the lock, so a slow load does not hold up requests for other entries
+	e.err = s.load(req, e)
+	close(e.ready)
+	if e.err != nil {
+		s.mu.Lock()
+		if s.entries[req] == e {
+			delete(s.entries, req)
+		}
+		s.mu.Unlock()
+		return nil, e.err
+	}
+	return e, nil
+}
+
+// release ends a watch of e taken with entry.
+func (s *ConfigServer) release(e *serverEntry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e.watchers--
+}
+
+// evictLocked removes the least recently used loaded entry that nobody watches, and reports
+// whether there was one. s.mu must be held.
+func (s *ConfigServer) evictLocked() bool {
+	var (
+		oldest    ConfigRequest
+		oldestEnt *serverEntry
+	)
+	for req, e := range s.entries {
+		if e.watchers > 0 {
+			continue
+		}
+		select {
+		case <-e.ready:
+		default:
+			continue
+		}
+		if oldestEnt == nil || e.lastUsed.Before(oldestEnt.lastUsed) {
+			oldest, oldestEnt = req, e
+		}
+	}
+	if oldestEnt == nil {
+		return false
+	}
+	delete(s.entries, oldest)
+	if oldestEnt.cancel != nil {
+		oldestEnt.cancel()
+	}
+	return true
+}
+
+// load creates the Container serving req into e.
+func (s *ConfigServer) load(req ConfigRequest, e *serverEntry) error {
+	env := s.env
+	env.Environment = req.Environment
+	env.Deployment.AppID = req.AppID
+	env.Deployment.ServiceID = req.ServiceID
+	env.Deployment.Region = req.Region
+	env.Deployment.AvailabilityZone = req.AvailabilityZone
+
+	opts := append(append([]Option{}, s.opts...), WithReloadObserver(func(ev ReloadEvent) {
+		if ev.Err == nil {
+			e.signal()
+		}
+	}))
+	c, err := NewConfigWithOptions(env, opts...)
+	if err != nil {
+		return fmt.Errorf("could not load configuration for %s: %v", req.Environment, err)
+	}
+	ctx, cancel := context.WithCancel(s.ctx)
+	e.c, e.cancel = c, cancel
+	e.env = EnvContext{
+		Environment: req.Environment,
+		Deployment: DeploymentContext{
+			AppID:            req.AppID,
+			ServiceID:        req.ServiceID,
+			Region:           req.Region,
+			AvailabilityZone: req.AvailabilityZone,
+		},
+	}
+	go Watch(ctx, c)
+	return nil
+}
+
+// served returns the configuration of the entry's current snapshot as it is sent to clients,
+// with sensitive values redacted, along with its fingerprint.
+func (e *serverEntry) served() (string, map[string]interface{}, []ReportSource, error) {
+	y := e.c.(*yamlContainer)
+	snap := y.currentSnapshot()
+	if snap == nil {
+		return "", nil, nil, ErrNoConfigsLoaded
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.servedOf == snap.fingerprint {
+		return e.fingerprint, e.tree, snap.sourceReports, nil
+	}
+
+	full, err := snap.materialized()
+	if err != nil {
+		return "", nil, nil, err
+	}
+	tree, _ := redactTree("", full, servedRedactor(y.opts.redactor)).(map[string]interface{})
+	if tree == nil {
+		tree = map[string]interface{}{}
+	}
+	fp, err := fingerprintTree(tree)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	e.tree, e.fingerprint, e.servedOf = tree, fp, snap.fingerprint
+	return fp, tree, snap.sourceReports, nil
+}
+
+// servedRedactor wraps redact so secret references for a registered resolver are kept, as they
+// are not secret themselves and clients resolve them.
+func servedRedactor(redact Redactor) Redactor {
+	return func(key string, value interface{}) interface{} {
+		if s, ok := value.(string); ok {
+			if scheme, _, err := SecretRef(s).Split(); err == nil {
+				if _, ok := lookupSecretResolver(scheme); ok {
+					return value
+				}
+			}
+		}
+		return redact(key, value)
+	}
+}
+
+func (e *serverEntry) response() (*ConfigResponse, error) {
+	fp, tree, sources, err := e.served()
+	if err != nil {
+		return nil, err
+	}
+
+	return &ConfigResponse{
+		Version:     DistributionProtocolVersion,
+		Environment: e.env,
+		Fingerprint: fp,
+		Sources:     sources,
+		Config:      tree,
+	}, nil
+}
+
+// Fetch returns the configuration for req.
+func (s *ConfigServer) Fetch(ctx context.Context, req *ConfigRequest) (*ConfigResponse, error) {
+	e, err := s.entry(*req, false)
+	if err != nil {
+		return nil, err
+	}
+	return e.response()
+}
+
+// Watch sends the configuration for req to stream, then again every time it changes, until the
+// stream's context is done or the server is closed.
+func (s *ConfigServer) Watch(req *ConfigRequest, stream ConfigStream) error {
+	e, err := s.entry(*req, true)
+	if err != nil {
+		return err
+	}
+	defer s.release(e)
+
+	last := ""
+	for {
+		changed := e.changes()
+		resp, err := e.response()
+		if err != nil {
+			return err
+		}
+		if resp.Fingerprint != last {
+			if err := stream.Send(resp); err != nil {
+				return err
+			}
+			last = resp.Fingerprint
+		}
+
+		select {
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		case <-s.ctx.Done():
+			return nil
+		case <-changed:
+		}
+	}
+}
+
+// ServeHTTP serves the configuration named by the environment, app_id, service_id, region and
+// availability_zone query parameters as JSON. With watch=true the response is a stream of
+// newline delimited ConfigResponses, one per change. A POST of a DeltaConfigRequest starts a
+// WatchDelta stream.
+func (s *ConfigServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		s.serveDelta(w, r)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	q := r.URL.Query()
+	req := &ConfigRequest{
+		Environment:      EnvID(q.Get("environment")),
+		AppID:            q.Get("app_id"),
+		ServiceID:        q.Get("service_id"),
+		Region:           q.Get("region"),
+		AvailabilityZone: q.Get("availability_zone"),
+	}
+
+	if q.Get("watch") != "true" {
+		resp, err := s.Fetch(r.Context(), req)
+		if err != nil {
+			http.Error(w, err.Error(), serverErrorStatus(err))
+			return
+		}
+		if strings.Contains(r.Header.Get("Accept"), _cborContentType) {
+			data, err := resp.MarshalCBOR()
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", _cborContentType)
+			w.Write(data)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	if _, err := s.entry(*req, false); err != nil {
+		http.Error(w, err.Error(), serverErrorStatus(err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	s.Watch(req, &httpConfigStream{ctx: r.Context(), w: w, enc: json.NewEncoder(w)})
+}
+
+// serverErrorStatus returns the HTTP status for an error returned by a ConfigServer.
+func serverErrorStatus(err error) int {
+	switch err {
+	case ErrConfigRequestNotAllowed:
+		return http.StatusForbidden
+	case ErrTooManyConfigRequests:
+		return http.StatusTooManyRequests
+	}
+	return http.StatusBadRequest
+}
+
+// BearerTokenTransport is an http.RoundTripper that adds a bearer token to every request, for
+// config servers that require one, such as cfxctl serve with -token-file.
+type BearerTokenTransport struct {
+	// Token is sent in the Authorization header.
+	Token string
+
+	// Base sends the requests. If nil, http.DefaultTransport is used.
+	Base http.RoundTripper
+}
+
+// RoundTrip implements the http.RoundTripper interface.
+func (t *BearerTokenTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	r = r.Clone(r.Context())
+	r.Header.Set("Authorization", "Bearer "+t.Token)
+	return base.RoundTrip(r)
+}
+
+// RequireBearerToken wraps h so requests are rejected with 401 Unauthorized unless they carry
+// one of tokens in their Authorization header, as sent by BearerTokenTransport.
+func RequireBearerToken(h http.Handler, tokens ...string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		for _, t := range tokens {
+			if t != "" && subtle.ConstantTimeCompare([]byte(got), []byte(t)) == 1 {
+				h.ServeHTTP(w, r)
+				return
+			}
+		}
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+	})
+}
+
+// httpConfigStream writes ConfigResponses to an HTTP response as newline delimited JSON.
+type httpConfigStream struct {
+	ctx context.Context
+	w   http.ResponseWriter
+	enc *json.Encoder
+}
+
+func (h *httpConfigStream) Context() context.Context { return h.ctx }
+
+func (h *httpConfigStream) Send(resp *ConfigResponse) error {
+	return h.send(resp)
+}
+
+func (h *httpConfigStream) send(v interface{}) error {
+	if err := h.enc.Encode(v); err != nil {
+		return err
+	}
+	if f, ok := h.w.(http.Flusher); ok {
+		f.Flush()
+	}
+	return nil
+}
+
+// httpConfigClient is a ConfigClient for a ConfigServer served over HTTP.
+type httpConfigClient struct {
+	url    string
+	client *http.Client
+}
+
+// NewHTTPConfigClient returns a ConfigClient for the ConfigServer at url. If client is nil,
+// http.DefaultClient is used; it must not set a Timeout, as Watch responses are long lived.
+func NewHTTPConfigClient(url string, client *http.Client) ConfigClient {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &httpConfigClient{url: url, client: client}
+}
+
+func (h *httpConfigClient) get(ctx context.Context, req *ConfigRequest, watch bool) (*http.Response, error) {
+	if err := checkNetworkAllowed("config server " + h.url); err != nil {
+		return nil, err
+	}
+
+	q := url.Values{}
+	q.Set("environment", req.Environment.String())
+	for k, v := range map[string]string{
+		"app_id":            req.AppID,
+		"service_id":        req.ServiceID,
+		"region":            req.Region,
+		"availability_zone": req.AvailabilityZone,
+	} {
+		if v != "" {
+			q.Set(k, v)
+		}
+	}
+	if watch {
+		q.Set("watch", "true")
+	}
+
+	sep := "?"
+	if strings.Contains(h.url, "?") {
+		sep = "&"
+	}
+	hreq, err := http.NewRequest(http.MethodGet, h.url+sep+q.Encode(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not create request for config server %s: %v", h.url, err)
+	}
+	if !watch {
+		hreq.Header.Set("Accept", _cborContentType+", application/json;q=0.9")
+	}
+	return h.roundTrip(ctx, hreq)
+}
+
+// roundTrip sends hreq, failing unless the server responds with 200 OK.
+func (h *httpConfigClient) roundTrip(ctx context.Context, hreq *http.Request) (*http.Response, error) {
+	resp, err := h.client.Do(hreq.WithContext(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("could not reach config server %s: %v", h.url, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+		resp.Body.Close()
+		return nil, fmt.Errorf("config server %s returned %s: %s", h.url, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return resp, nil
+}
+
+func (h *httpConfigClient) Fetch(ctx context.Context, req *ConfigRequest) (*ConfigResponse, error) {
+	resp, err := h.get(ctx, req, false)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	ret := &ConfigResponse{}
+	if strings.HasPrefix(resp.Header.Get("Content-Type"), _cborContentType) {
+		data, err := ioutil.ReadAll(resp.Body)
+		if err == nil {
+			err = ret.UnmarshalCBOR(data)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("could not decode response from config server %s: %v", h.url, err)
+		}
+		return ret, nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(ret); err != nil {
+		return nil, fmt.Errorf("could not decode response from config server %s: %v", h.url, err)
+	}
+	return ret, nil
+}
+
+func (h *httpConfigClient) Watch(ctx context.Context, req *ConfigRequest) (ConfigUpdates, error) {
+	resp, err := h.get(ctx, req, true)
+	if err != nil {
+		return nil, err
+	}
+	return &httpConfigUpdates{body: resp.Body, dec: json.NewDecoder(resp.Body)}, nil
+}
+
+// httpConfigUpdates reads newline delimited ConfigResponses from a watch response.
+type httpConfigUpdates struct {
+	body io.ReadCloser
+	dec  *json.Decoder
+}
+
+func (h *httpConfigUpdates) Recv() (*ConfigResponse, error) {
+	ret := &ConfigResponse{}
+	if err := h.dec.Decode(ret); err != nil {
+		h.body.Close()
+		return nil, err
+	}
+	return ret, nil
+}
+
+// WithConfigServer loads the configuration from a ConfigServer through client instead of the
+// config directory. The request is built from the EnvContext the Container is created with.
+// When the Container is created with NewFXConfig it follows the updates the server streams for
+// the lifetime of the Fx application; otherwise use cfx.WatchServer.
+func WithConfigServer(client ConfigClient) Option {
+	return func(o *options) {
+		o.configClient = client
+	}
+}
+
+// NewContainerFromServer creates a Container serving the configuration a ConfigServer returns
+// for req, along with the environment the server loaded it in, which only has the fields of req
+// set. Reloading the Container fetches
+// the configuration again; use WatchServer to follow the updates the server streams.
+func NewContainerFromServer(client ConfigClient, req ConfigRequest, opts ...Option) (Container, EnvContext, error) {
+	resp, err := client.Fetch(context.Background(), &req)
+	if err != nil {
+		return nil, EnvContext{}, err
+	}
+	if resp.Version != DistributionProtocolVersion {
+		return nil, EnvContext{}, fmt.Errorf("config server speaks protocol version %d, expected %d", resp.Version, DistributionProtocolVersion)
+	}
+
+	opts = append(opts, WithConfigServer(client))
+	c, err := NewConfigWithOptions(resp.Environment, opts...)
+	if err != nil {
+		return nil, EnvContext{}, err
+	}
+	return c, resp.Environment, nil
+}
+
+// configRequestFor builds the ConfigRequest for env.
+func configRequestFor(env EnvContext) ConfigRequest {
+	return ConfigRequest{
+		Environment:      env.Environment,
+		AppID:            env.Deployment.AppID,
+		ServiceID:        env.Deployment.ServiceID,
+		Region:           env.Deployment.Region,
+		AvailabilityZone: env.Deployment.AvailabilityZone,
+	}
+}
+
+// loadFromServer builds a snapshot from the configuration returned by the ConfigServer.
+func loadFromServer(env EnvContext, opts *options) (*snapshot, error) {
+	req := configRequestFor(env)
+	resp, err := fetchFromServer(context.Background(), opts, &req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Version != DistributionProtocolVersion {
+		return nil, fmt.Errorf("config server speaks protocol version %d, expected %d", resp.Version, DistributionProtocolVersion)
+	}
+	return buildExpandedSnapshot(env, opts, "server:"+req.Environment.String(), resp.Config)
+}
+
+// fetchFromServer fetches the configuration for req, through the cache given to
+// WithRemoteCache if any. Responses are cached as JSON, keyed by the request.
+func fetchFromServer(ctx context.Context, opts *options, req *ConfigRequest) (*ConfigResponse, error) {
+	if opts.remoteCache == nil {
+		return opts.configClient.Fetch(ctx, req)
+	}
+
+	key, err := serverCacheKey(req)
+	if err != nil {
+		return nil, err
+	}
+	data, ok, err := opts.remoteCache.Get(ctx, key)
+	if err != nil {
+		log.Printf("cfx: could not read config server response from the cache: %v", err)
+	}
+	if ok {
+		ret := &ConfigResponse{}
+		if err := json.Unmarshal(data, ret); err == nil {
+			return ret, nil
+		}
+		log.Printf("cfx: ignoring cached config server response that cannot be decoded")
+	}
+
+	resp, err := opts.configClient.Fetch(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	cacheServerResponse(ctx, opts, req, resp)
+	return resp, nil
+}
+
+// cacheServerResponse stores resp in the cache given to WithRemoteCache, if any, so that
+// updates streamed by the server replace what was fetched before. Failures are logged.
+func cacheServerResponse(ctx context.Context, opts *options, req *ConfigRequest, resp *ConfigResponse) {
+	if opts.remoteCache == nil {
+		return
+	}
+	ttl := opts.remoteCacheTTL
+	if ttl <= 0 {
+		ttl = _defaultRemoteCacheTTL
+	}
+
+	key, err := serverCacheKey(req)
+	if err != nil {
+		log.Printf("cfx: could not cache config server response: %v", err)
+		return
+	}
+	if data, err := json.Marshal(resp); err != nil {
+		log.Printf("cfx: could not cache config server response: %v", err)
+	} else if err := opts.remoteCache.Set(ctx, key, data, ttl); err != nil {
+		log.Printf("cfx: could not cache config server response: %v", err)
+	}
+}
+
+// serverCacheKey returns the cache key of the response to req.
+func serverCacheKey(req *ConfigRequest) (string, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return "", fmt.Errorf("could not encode config request: %v", err)
+	}
+	sum := sha256.Sum256(body)
+	return "cfx:server:" + hex.EncodeToString(sum[:]), nil
+}
+
+// WatchServer reloads c every time the ConfigServer it was created from streams a new
+// configuration, reconnecting with backoff when the stream breaks, until ctx is cancelled.
+// Clients that implement DeltaConfigClient receive only the sections that changed. Reload
+// failures are reported through ReloadObservers.
+func WatchServer(ctx context.Context, c Container) error {
+	y, ok := c.(*yamlContainer)
+	if !ok || y.opts.configClient == nil {
+		return errors.New("container does not load its configuration from a config server")
+	}
+
+	delta, isDelta := y.opts.configClient.(DeltaConfigClient)
+	state := newDeltaState()
+
+	policy := RetryPolicy{InitialInterval: time.Second, MaxInterval: time.Minute, Multiplier: 2, Jitter: 0.2}
+	b := policy.Backoff()
+	last := ""
+	for {
+		var err error
+		if isDelta {
+			err = y.watchServerDelta(ctx, delta, state, b)
+		} else {
+			err = y.watchServerOnce(ctx, &last, b)
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if y.Frozen() {
+			return ErrFrozen
+		}
+
+		d := b.NextBackOff()
+		log.Printf("cfx: config server stream ended, reconnecting in %s: %v", d, err)
+		t := time.NewTimer(d)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+}
+
+func (y *yamlContainer) watchServerOnce(ctx context.Context, last *string, b *Backoff) error {
+	req := configRequestFor(y.env)
+	updates, err := y.opts.configClient.Watch(ctx, &req)
+	if err != nil {
+		return err
+	}
+
+	name := "server:" + req.Environment.String()
+	for {
+		resp, err := updates.Recv()
+		if err != nil {
+			return err
+		}
+		if resp.Version != DistributionProtocolVersion {
+			return fmt.Errorf("config server speaks protocol version %d, expected %d", resp.Version, DistributionProtocolVersion)
+		}
+		b.Reset()
+
+		if resp.Fingerprint == *last {
+			continue
+		}
+		*last = resp.Fingerprint
+		cacheServerResponse(ctx, y.opts, &req, resp)
+
+		// the snapshot is built from the streamed configuration: fetching it again could be
+		// answered by a stale cache entry. Failures are reported to observers, and the
+		// previous config keeps being served.
+		_ = y.reload(TriggerWatch, func() (*snapshot, error) {
+			return buildExpandedSnapshot(y.env, y.opts, name, resp.Config)
+		})
+	}
+}
+
+// bindServerWatch follows the ConfigServer with the Fx application when the Container loads
+// its configuration from one.
+func bindServerWatch(lc fx.Lifecycle, c Container, opts *options) {
+	if opts.configClient == nil {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	lc.Append(fx.Hook{
+		OnStart: func(context.Context) error {
+			go func() {
+				defer close(done)
+				WatchServer(ctx, c)
+			}()
+			return nil
+		},
+		OnStop: func(stopCtx context.Context) error {
+			cancel()
+			select {
+			case <-done:
+			case <-stopCtx.Done():
+			}
+			return nil
+		},
+	})
+}
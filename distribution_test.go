@@ -0,0 +1,219 @@
+package cfx
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestConfigServerRedactsSecrets(t *testing.T) {
+	dir := testDir(t)
+	writeTestConfig(t, dir, "production.yaml", "db:\n  host: db.internal\n  password: hunter2\n  token_ref: env:DB_TOKEN\n")
+
+	s := NewConfigServer(EnvContext{ConfigPath: dir, Host: HostContext{Hostname: "server-host"}})
+	defer s.Close()
+
+	resp, err := s.Fetch(context.Background(), &ConfigRequest{Environment: "production", ServiceID: "payments"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := resp.Config["db"].(map[string]interface{})
+	if db["host"] != "db.internal" {
+		t.Errorf("host = %v, want db.internal", db["host"])
+	}
+	if db["password"] != _redactedValue {
+		t.Errorf("password = %v, want it redacted", db["password"])
+	}
+	if db["token_ref"] != "env:DB_TOKEN" {
+		t.Errorf("token_ref = %v, want the secret reference kept", db["token_ref"])
+	}
+	if resp.Environment.Host.Hostname != "" || resp.Environment.ConfigPath != "" {
+		t.Errorf("response leaks the server environment: %+v", resp.Environment)
+	}
+	if resp.Environment.Deployment.ServiceID != "payments" {
+		t.Errorf("service id = %q, want payments", resp.Environment.Deployment.ServiceID)
+	}
+
+	want, err := fingerprintTree(resp.Config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Fingerprint != want {
+		t.Errorf("fingerprint %s does not match the served configuration %s", resp.Fingerprint, want)
+	}
+}
+
+func TestConfigServerEntryLimit(t *testing.T) {
+	dir := testDir(t)
+	writeTestConfig(t, dir, "production.yaml", "a: 1\n")
+
+	s := NewConfigServer(EnvContext{ConfigPath: dir}, WithServerEntryLimit(2))
+	defer s.Close()
+
+	ctx := context.Background()
+	for _, svc := range []string{"a", "b", "a", "c"} {
+		if _, err := s.Fetch(ctx, &ConfigRequest{Environment: "production", ServiceID: svc}); err != nil {
+			t.Fatalf("fetch %s: %v", svc, err)
+		}
+	}
+	// b was the least recently used entry
+	for _, svc := range []string{"a", "c"} {
+		if _, ok := s.entries[ConfigRequest{Environment: "production", ServiceID: svc}]; !ok {
+			t.Errorf("entry for %s was evicted, want b evicted", svc)
+		}
+	}
+
+	// entries with watchers are never evicted
+	var held []*serverEntry
+	for _, svc := range []string{"a", "c"} {
+		e, err := s.entry(ConfigRequest{Environment: "production", ServiceID: svc}, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		held = append(held, e)
+	}
+	if _, err := s.Fetch(ctx, &ConfigRequest{Environment: "production", ServiceID: "d"}); err != ErrTooManyConfigRequests {
+		t.Fatalf("fetch with every entry watched returned %v, want ErrTooManyConfigRequests", err)
+	}
+	s.release(held[0])
+	if _, err := s.Fetch(ctx, &ConfigRequest{Environment: "production", ServiceID: "d"}); err != nil {
+		t.Fatalf("fetch after a watcher left: %v", err)
+	}
+	if _, ok := s.entries[ConfigRequest{Environment: "production", ServiceID: "c"}]; !ok {
+		t.Error("watched entry was evicted")
+	}
+}
+
+func TestConfigServerIgnoresAppID(t *testing.T) {
+	dir := testDir(t)
+	writeTestConfig(t, dir, "production.yaml", "a: 1\n")
+
+	s := NewConfigServer(EnvContext{ConfigPath: dir}, WithServerEntryLimit(1))
+	defer s.Close()
+
+	for i := 0; i < 4; i++ {
+		req := &ConfigRequest{Environment: "production", AppID: fmt.Sprintf("app%d", i)}
+		if _, err := s.Fetch(context.Background(), req); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := len(s.entries); n != 1 {
+		t.Errorf("%d entries for requests differing by app, want 1", n)
+	}
+
+	s = NewConfigServer(EnvContext{ConfigPath: dir}, WithKeyHierarchy())
+	defer s.Close()
+	for _, app := range []string{"a", "b"} {
+		if _, err := s.Fetch(context.Background(), &ConfigRequest{Environment: "production", AppID: app}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := len(s.entries); n != 2 {
+		t.Errorf("%d entries with key hierarchy overlays, want one per app", n)
+	}
+}
+
+func TestConfigServerFailedLoadFreesEntry(t *testing.T) {
+	dir := testDir(t)
+
+	s := NewConfigServer(EnvContext{ConfigPath: dir}, WithServerEntryLimit(1))
+	defer s.Close()
+
+	ctx := context.Background()
+	if _, err := s.Fetch(ctx, &ConfigRequest{Environment: "missing"}); err == nil {
+		t.Fatal("expected an error for an environment without configuration")
+	}
+	writeTestConfig(t, dir, "production.yaml", "a: 1\n")
+	if _, err := s.Fetch(ctx, &ConfigRequest{Environment: "production"}); err != nil {
+		t.Fatalf("failed load kept its entry: %v", err)
+	}
+}
+
+func TestConfigServerConcurrentFetch(t *testing.T) {
+	dir := testDir(t)
+	writeTestConfig(t, dir, "production.yaml", "a: 1\n")
+
+	s := NewConfigServer(EnvContext{ConfigPath: dir})
+	defer s.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := s.Fetch(context.Background(), &ConfigRequest{Environment: "production"}); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := len(s.entries); n != 1 {
+		t.Errorf("%d entries for one request, want 1", n)
+	}
+}
+
+func TestConfigServerAllowlist(t *testing.T) {
+	dir := testDir(t)
+	writeTestConfig(t, dir, "production.yaml", "a: 1\n")
+
+	s := NewConfigServer(EnvContext{ConfigPath: dir}, WithAllowedConfigRequests(ConfigRequest{
+		Environment: "production", ServiceID: "payments", AppID: "*", Region: "*", AvailabilityZone: "*",
+	}))
+	defer s.Close()
+
+	ctx := context.Background()
+	if _, err := s.Fetch(ctx, &ConfigRequest{Environment: "production", ServiceID: "payments", Region: "us-east-1"}); err != nil {
+		t.Fatalf("allowed request failed: %v", err)
+	}
+	if _, err := s.Fetch(ctx, &ConfigRequest{Environment: "production", ServiceID: "billing"}); err != ErrConfigRequestNotAllowed {
+		t.Fatalf("request outside the allowlist returned %v, want ErrConfigRequestNotAllowed", err)
+	}
+}
+
+func TestConfigServerRejectsPathTraversal(t *testing.T) {
+	root := testDir(t)
+	dir := filepath.Join(root, "cfg")
+	if err := os.MkdirAll(filepath.Join(root, "secret"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	writeTestConfig(t, filepath.Join(root, "secret"), "evil.yaml", "leaked: true\n")
+	writeTestConfig(t, dir, "production.yaml", "a: 1\n")
+
+	s := NewConfigServer(EnvContext{ConfigPath: dir})
+	defer s.Close()
+
+	reqs := []ConfigRequest{
+		{Environment: "../secret/evil"},
+		{Environment: "production/../../secret/evil"},
+		{Environment: "production", ServiceID: "/../../secret/evil"},
+		{Environment: "production", ServiceID: "../secret/evil"},
+		{Environment: "production", ServiceID: `..\secret\evil`},
+		{Environment: "production", Region: ".."},
+		{Environment: "production", Region: ".hidden"},
+		{Environment: "production", Region: "us-east-1..payments"},
+		{Environment: "production", Region: "us-east-1."},
+		{Environment: "production", AppID: "a/b"},
+		{Environment: "production", AvailabilityZone: "../../secret/evil"},
+		{Environment: "production", ServiceID: "pay\nments"},
+	}
+	for _, req := range reqs {
+		resp, err := s.Fetch(context.Background(), &req)
+		if err == nil {
+			t.Errorf("request %+v was served: %v", req, resp.Config)
+		}
+	}
+	if n := len(s.entries); n != 0 {
+		t.Errorf("rejected requests left %d entries", n)
+	}
+
+	if _, err := s.Fetch(context.Background(), &ConfigRequest{Environment: "production", Region: "us-east-1", ServiceID: "payments"}); err != nil {
+		t.Errorf("valid request failed: %v", err)
+	}
+}
@@ -0,0 +1,284 @@
+package cfx
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DoctorStatus is the outcome of a single Doctor check.
+type DoctorStatus string
+
+// Doctor check outcomes.
+const (
+	DoctorOK   DoctorStatus = "ok"
+	DoctorWarn DoctorStatus = "warn"
+	DoctorFail DoctorStatus = "fail"
+	DoctorSkip DoctorStatus = "skip"
+)
+
+// _entropyAvailPath reports the entropy available to the kernel's random number generator.
+var _entropyAvailPath = "/proc/sys/kernel/random/entropy_avail"
+
+// DoctorConfig configures the checks run by Doctor.
+type DoctorConfig struct {
+	// NTPServer is the host or host:port of an NTP server to measure clock skew against. The
+	// clock check is skipped when it is empty, and in air-gapped mode.
+	NTPServer string `json:"ntp_server,omitempty" yaml:"ntp_server,omitempty" mapstructure:"ntp_server,omitempty"`
+
+	// MaxClockSkew is the largest clock offset that passes, one second if zero.
+	MaxClockSkew time.Duration `json:"max_clock_skew,omitempty" yaml:"max_clock_skew,omitempty" mapstructure:"max_clock_skew,omitempty"`
+
+	// Timeout bounds each check, five seconds if zero.
+	Timeout time.Duration `json:"timeout,omitempty" yaml:"timeout,omitempty" mapstructure:"timeout,omitempty"`
+}
+
+// DoctorResult is the outcome of a single Doctor check.
+type DoctorResult struct {
+	Name     string        `json:"name" yaml:"name"`
+	Status   DoctorStatus  `json:"status" yaml:"status"`
+	Message  string        `json:"message,omitempty" yaml:"message,omitempty"`
+	Duration time.Duration `json:"duration" yaml:"duration"`
+}
+
+// DoctorReport holds the results of a Doctor run.
+type DoctorReport struct {
+	Time    time.Time      `json:"time" yaml:"time"`
+	Results []DoctorResult `json:"results" yaml:"results"`
+}
+
+// Healthy reports whether no check failed. Warnings do not make a report unhealthy.
+func (r DoctorReport) Healthy() bool {
+	for _, res := range r.Results {
+		if res.Status == DoctorFail {
+			return false
+		}
+	}
+	return true
+}
+
+// String implements the fmt.Stringer interface, listing one check per line.
+func (r DoctorReport) String() string {
+	var sb strings.Builder
+	for _, res := range r.Results {
+		fmt.Fprintf(&sb, "%-4s  %-12s %s\n", res.Status, res.Name, res.Message)
+	}
+	return sb.String()
+}
+
+// Doctor runs sanity checks against the environment the process runs in, catching problems
+// that otherwise surface as confusing errors much later:
+//
+//   - ca_bundle: the system certificate pool can be loaded and is not empty
+//   - tzdata: time zone data is available to time.LoadLocation
+//   - temp_dir: the temporary directory is writable
+//   - entropy: the random number generator returns data without blocking
+//   - locale: a system locale is configured
+//   - clock: the clock is within MaxClockSkew of NTPServer, when one is configured
+func Doctor(ctx context.Context, cfg DoctorConfig) DoctorReport {
+	timeout := cfg.Timeout
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+
+	checks := []struct {
+		name string
+		fn   func(context.Context, DoctorConfig) (DoctorStatus, string)
+	}{
+		{"ca_bundle", doctorCABundle},
+		{"tzdata", doctorTZData},
+		{"temp_dir", doctorTempDir},
+		{"entropy", doctorEntropy},
+		{"locale", doctorLocale},
+		{"clock", doctorClock},
+	}
+
+	r := DoctorReport{Time: time.Now()}
+	for _, c := range checks {
+		cctx, cancel := context.WithTimeout(ctx, timeout)
+		start := time.Now()
+		status, msg := c.fn(cctx, cfg)
+		cancel()
+		r.Results = append(r.Results, DoctorResult{Name: c.name, Status: status, Message: msg, Duration: time.Since(start)})
+	}
+	return r
+}
+
+// DoctorHandler runs Doctor on every request and serves the report as JSON, so it can be
+// mounted on an admin mux. The status is 503 when a check failed.
+func DoctorHandler(cfg DoctorConfig) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		report := Doctor(r.Context(), cfg)
+		status := http.StatusOK
+		if !report.Healthy() {
+			status = http.StatusServiceUnavailable
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(report)
+	})
+}
+
+func doctorCABundle(context.Context, DoctorConfig) (DoctorStatus, string) {
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		if runtime.GOOS == "windows" {
+			// Go verifies against the Windows store directly and cannot list it
+			return DoctorSkip, "the Windows certificate store cannot be listed"
+		}
+		return DoctorFail, fmt.Sprintf("could not load the system certificate pool: %v", err)
+	}
+	n := len(pool.Subjects())
+	if n == 0 {
+		if runtime.GOOS == "darwin" || runtime.GOOS == "windows" {
+			return DoctorSkip, "the system certificate store cannot be listed"
+		}
+		return DoctorFail, "the system certificate pool is empty, install ca-certificates or set SSL_CERT_FILE"
+	}
+	return DoctorOK, fmt.Sprintf("%d certificates", n)
+}
+
+func doctorTZData(context.Context, DoctorConfig) (DoctorStatus, string) {
+	if _, err := time.LoadLocation("America/New_York"); err != nil {
+		return DoctorFail, fmt.Sprintf("time zone data is not available, install tzdata or import time/tzdata: %v", err)
+	}
+	return DoctorOK, "local time zone " + time.Local.String()
+}
+
+func doctorTempDir(context.Context, DoctorConfig) (DoctorStatus, string) {
+	dir := os.TempDir()
+	f, err := ioutil.TempFile(dir, "cfx-doctor-")
+	if err != nil {
+		return DoctorFail, fmt.Sprintf("%s is not writable: %v", dir, err)
+	}
+	defer os.Remove(f.Name())
+	_, err = f.Write([]byte("cfx"))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return DoctorFail, fmt.Sprintf("could not write to %s: %v", dir, err)
+	}
+	return DoctorOK, dir
+}
+
+func doctorEntropy(ctx context.Context, _ DoctorConfig) (DoctorStatus, string) {
+	done := make(chan error, 1)
+	go func() {
+		var b [32]byte
+		_, err := rand.Read(b[:])
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			return DoctorFail, fmt.Sprintf("could not read random data: %v", err)
+		}
+	case <-ctx.Done():
+		return DoctorFail, "reading random data blocked, the system may lack entropy"
+	}
+
+	if data, err := ioutil.ReadFile(_entropyAvailPath); err == nil {
+		avail, err := strconv.Atoi(strings.TrimSpace(string(data)))
+		if err == nil && avail < 128 {
+			return DoctorWarn, fmt.Sprintf("only %d bits of entropy are available", avail)
+		}
+		if err == nil {
+			return DoctorOK, fmt.Sprintf("%d bits of entropy available", avail)
+		}
+	}
+	return DoctorOK, ""
+}
+
+func doctorLocale(context.Context, DoctorConfig) (DoctorStatus, string) {
+	if loc := DetectSystemLocale(); loc != "" {
+		return DoctorOK, loc
+	}
+	return DoctorWarn, "no system locale is configured, set LANG or LC_ALL"
+}
+
+func doctorClock(ctx context.Context, cfg DoctorConfig) (DoctorStatus, string) {
+	if cfg.NTPServer == "" {
+		return DoctorSkip, "no NTP server configured"
+	}
+	if err := checkNetworkAllowed("NTP server " + cfg.NTPServer); err != nil {
+		return DoctorSkip, err.Error()
+	}
+
+	offset, err := ntpOffset(ctx, cfg.NTPServer)
+	if err != nil {
+		return DoctorWarn, fmt.Sprintf("could not query %s: %v", cfg.NTPServer, err)
+	}
+	max := cfg.MaxClockSkew
+	if max <= 0 {
+		max = time.Second
+	}
+	abs := offset
+	if abs < 0 {
+		abs = -abs
+	}
+	if abs > max {
+		return DoctorFail, fmt.Sprintf("clock is off by %s from %s", offset, cfg.NTPServer)
+	}
+	return DoctorOK, fmt.Sprintf("offset %s from %s", offset, cfg.NTPServer)
+}
+
+// _ntpEpochOffset is the number of seconds between the NTP epoch (1900) and the Unix epoch.
+const _ntpEpochOffset = 2208988800
+
+// ntpOffset measures the offset of the local clock from an NTP server with a single SNTP
+// (RFC 4330) exchange. A positive offset means the local clock is behind.
+func ntpOffset(ctx context.Context, server string) (time.Duration, error) {
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, "123")
+	}
+	conn, err := (&net.Dialer{}).DialContext(ctx, "udp", server)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	if dl, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(dl)
+	}
+
+	req := make([]byte, 48)
+	req[0] = 0x1b // leap indicator 0, version 3, client mode
+	t0 := time.Now()
+	if _, err := conn.Write(req); err != nil {
+		return 0, err
+	}
+	resp := make([]byte, 48)
+	n, err := conn.Read(resp)
+	t3 := time.Now()
+	if err != nil {
+		return 0, err
+	}
+	if n < 48 {
+		return 0, fmt.Errorf("short NTP response of %d bytes", n)
+	}
+	if resp[0]&0x07 != 4 || resp[1] == 0 {
+		return 0, fmt.Errorf("invalid NTP response")
+	}
+
+	t1 := ntpTime(resp[32:40])
+	t2 := ntpTime(resp[40:48])
+	return (t1.Sub(t0) + t2.Sub(t3)) / 2, nil
+}
+
+func ntpTime(b []byte) time.Time {
+	sec := int64(binary.BigEndian.Uint32(b[0:4])) - _ntpEpochOffset
+	frac := int64(binary.BigEndian.Uint32(b[4:8]))
+	return time.Unix(sec, frac*1e9>>32)
+}
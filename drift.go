@@ -0,0 +1,146 @@
+package cfx
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"go.uber.org/fx"
+)
+
+// DriftEvent is the outcome of comparing the running configuration with the configuration
+// currently on disk.
+type DriftEvent struct {
+	// Time is when the check ran.
+	Time time.Time
+
+	// Running is the fingerprint of the configuration being served.
+	Running string
+
+	// Current is the fingerprint of the configuration the sources would produce now. It is
+	// empty if they could not be loaded.
+	Current string
+
+	// Drifted is set when Running and Current differ.
+	Drifted bool
+
+	// Err is set when the sources could not be loaded.
+	Err error
+}
+
+// DriftObserver is notified after every drift check. It is the hook for emitting metrics.
+type DriftObserver func(DriftEvent)
+
+// WithDriftDetection checks at the given interval whether the configuration sources have
+// drifted from the configuration being served, whether or not hot reload is enabled. Drift is
+// logged when it appears and when it clears, and reported to DriftObservers. The checker runs
+// for the lifetime of the Fx application when the Container is created with NewFXConfig;
+// otherwise use cfx.WatchDrift.
+func WithDriftDetection(interval time.Duration) Option {
+	return func(o *options) {
+		o.driftInterval = interval
+	}
+}
+
+// WithDriftObserver registers a function that is called after every drift check.
+func WithDriftObserver(fn DriftObserver) Option {
+	return func(o *options) {
+		if fn != nil {
+			o.driftObservers = append(o.driftObservers, fn)
+		}
+	}
+}
+
+// CheckDrift loads the configuration sources and compares their fingerprint with the
+// configuration being served by c, without changing it.
+func CheckDrift(c Container) (DriftEvent, error) {
+	y, ok := c.(*yamlContainer)
+	if !ok {
+		return DriftEvent{}, fmt.Errorf("container of type %T does not support drift detection", c)
+	}
+	return y.checkDrift(), nil
+}
+
+func (y *yamlContainer) checkDrift() DriftEvent {
+	ev := DriftEvent{Time: y.opts.clock(), Running: y.Fingerprint()}
+
+	snap, err := loadSnapshot(y.env, y.opts)
+	if err != nil {
+		ev.Err = err
+		return ev
+	}
+	ev.Current = snap.fingerprint
+	ev.Drifted = ev.Current != ev.Running
+	return ev
+}
+
+// WatchDrift checks c for drift at the interval set with WithDriftDetection, or every minute
+// if none was set, until ctx is cancelled.
+func WatchDrift(ctx context.Context, c Container) error {
+	y, ok := c.(*yamlContainer)
+	if !ok {
+		return fmt.Errorf("container of type %T does not support drift detection", c)
+	}
+
+	interval := y.opts.driftInterval
+	if interval <= 0 {
+		interval = time.Minute
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	drifted := false
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+
+		ev := y.checkDrift()
+		switch {
+		case ev.Err != nil:
+			log.Printf("cfx: drift check could not load the configuration sources: %v", ev.Err)
+		case ev.Drifted && !drifted:
+			log.Printf("cfx: configuration drift detected: running %s, sources are now %s", ev.Running, ev.Current)
+		case !ev.Drifted && drifted:
+			log.Printf("cfx: configuration drift cleared, running %s", ev.Running)
+		}
+		if ev.Err == nil {
+			drifted = ev.Drifted
+		}
+
+		for _, fn := range y.opts.driftObservers {
+			fn(ev)
+		}
+	}
+}
+
+// bindDriftDetection starts the drift checker with the Fx application when it is enabled.
+func bindDriftDetection(lc fx.Lifecycle, c Container, opts *options) {
+	if opts.driftInterval <= 0 {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	lc.Append(fx.Hook{
+		OnStart: func(context.Context) error {
+			go func() {
+				defer close(done)
+				WatchDrift(ctx, c)
+			}()
+			return nil
+		},
+		OnStop: func(stopCtx context.Context) error {
+			cancel()
+			select {
+			case <-done:
+			case <-stopCtx.Done():
+			}
+			return nil
+		},
+	})
+}
@@ -82,6 +82,11 @@ func (e EnvVar) Get(p EnvKeyPrefix) string {
 	return os.Getenv(e.Key(p))
 }
 
+// Lookup returns the environment variable's value with the included EnvKeyPrefix, and whether it was set.
+func (e EnvVar) Lookup(p EnvKeyPrefix) (string, bool) {
+	return os.LookupEnv(e.Key(p))
+}
+
 // EnvID represents a specific environment identifier within the application.
 type EnvID string
 
@@ -179,6 +184,10 @@ type EnvContext struct {
 	// ConfigPath is the directory where configuration files and data might be located.
 	ConfigPath string `json:"config_path,omitempty" yaml:"config_path,omitempty" mapstructure:"config_path,omitempty"`
 
+	// ConfigPaths is the search path of config directories, in order of precedence, when
+	// CONFIG_DIR lists more than one. ConfigPath is its first entry.
+	ConfigPaths []string `json:"config_paths,omitempty" yaml:"config_paths,omitempty" mapstructure:"config_paths,omitempty"`
+
 	// Host holds information about the underlying host.
 	Host HostContext `json:"host,omitempty" yaml:"host,omitempty" mapstructure:"host,omitempty"`
 
@@ -193,6 +202,24 @@ type EnvContext struct {
 
 	// Process holds information about the applications process (pid and ppid).
 	Process ProcessContext `json:"process,omitempty" yaml:"process,omitempty" mapstructure:"process,omitempty"`
+
+	// Resources holds the CPU and memory limits of the process.
+	Resources ResourceContext `json:"resources,omitempty" yaml:"resources,omitempty" mapstructure:"resources,omitempty"`
+
+	// Proxy holds the outbound proxy settings from the environment.
+	Proxy ProxyContext `json:"proxy,omitempty" yaml:"proxy,omitempty" mapstructure:"proxy,omitempty"`
+
+	// Locale holds the system locale. The configured locales are added by cfx.NewLocaleContext.
+	Locale LocaleContext `json:"locale,omitempty" yaml:"locale,omitempty" mapstructure:"locale,omitempty"`
+
+	// Serverless describes the function the process serves on Lambda, Cloud Functions or Azure Functions.
+	Serverless ServerlessContext `json:"serverless,omitempty" yaml:"serverless,omitempty" mapstructure:"serverless,omitempty"`
+
+	// Terminal describes the terminal attached to the standard streams, for CLI tools.
+	Terminal TerminalContext `json:"terminal,omitempty" yaml:"terminal,omitempty" mapstructure:"terminal,omitempty"`
+
+	// Vars holds the values of the environment variables declared with cfx.DefineVar.
+	Vars Vars `json:"-" yaml:"-" mapstructure:"-"`
 }
 
 // HostContext holds information about the underlying host.
@@ -205,6 +232,9 @@ type HostContext struct {
 
 	// Timezone of the underlying operating system.
 	Timezone string `json:"timezone,omitempty" yaml:"timezone,omitempty" mapstructure:"timezone,omitempty"`
+
+	// Security describes the SELinux, AppArmor and seccomp confinement of the process.
+	Security SecurityContext `json:"security,omitempty" yaml:"security,omitempty" mapstructure:"security,omitempty"`
 }
 
 // DeploymentContext holds information about the current deployment environment of the application.
@@ -229,6 +259,12 @@ type DeploymentContext struct {
 
 	// DatacenterID is a generic identifier to help classify an environment's datacenter.
 	DatacenterID string `json:"datacenter_id,omitempty" yaml:"datacenter_id,omitempty" mapstructure:"datacenter_id,omitempty"`
+
+	// SPIFFE is the SPIFFE workload identity of the process, when it runs in a mesh.
+	SPIFFE SPIFFEContext `json:"spiffe,omitempty" yaml:"spiffe,omitempty" mapstructure:"spiffe,omitempty"`
+
+	// Platform is the orchestrator that scheduled the process, such as Nomad or ECS.
+	Platform PlatformContext `json:"platform,omitempty" yaml:"platform,omitempty" mapstructure:"platform,omitempty"`
 }
 
 // GoContext holds information about the Go environment of the running application.
@@ -241,6 +277,9 @@ type GoContext struct {
 
 	// Version is the version of Go that was used to compile the application. (runtime.Version())
 	Version string `json:"version,omitempty" yaml:"version,omitempty" mapstructure:"version,omitempty"`
+
+	// Crypto describes the FIPS mode of the binary and the crypto policy of the operating system.
+	Crypto CryptoContext `json:"crypto,omitempty" yaml:"crypto,omitempty" mapstructure:"crypto,omitempty"`
 }
 
 // UserContext holds information about the user the current process is running as.
@@ -262,6 +301,15 @@ type ProcessContext struct {
 
 	// PPID is the parent process ID of the current application
 	PPID int `json:"ppid,omitempty" yaml:"ppid,omitempty" mapstructure:"ppid,omitempty"`
+
+	// Systemd describes the systemd unit running the application, if any.
+	Systemd SystemdContext `json:"systemd,omitempty" yaml:"systemd,omitempty" mapstructure:"systemd,omitempty"`
+
+	// Windows describes the Windows service running the application, if any.
+	Windows WindowsContext `json:"windows,omitempty" yaml:"windows,omitempty" mapstructure:"windows,omitempty"`
+
+	// Supervisor is the init system or supervisor that started the application.
+	Supervisor Supervisor `json:"supervisor,omitempty" yaml:"supervisor,omitempty" mapstructure:"supervisor,omitempty"`
 }
 
 // EnvResult is used as an Fx container, wrapping the EnvContext output.
@@ -274,6 +322,10 @@ type EnvResult struct {
 // NewEnvContext creates a new, populated EnvContext, optionally returning an error
 // if an error occurs during the population of the data.
 func NewEnvContext(prefix string) (EnvContext, error) {
+	return newEnvContext(prefix, defaultOptions())
+}
+
+func newEnvContext(prefix string, opts *options) (EnvContext, error) {
 	var ctx EnvContext
 	envPrefix, err := ParseEnvKeyPrefix(prefix)
 	if err != nil {
@@ -292,6 +344,7 @@ func NewEnvContext(prefix string) (EnvContext, error) {
 			OS:      runtime.GOOS,
 			Arch:    runtime.GOARCH,
 			Version: runtime.Version(),
+			Crypto:  DetectCrypto(),
 		},
 		Deployment: DeploymentContext{
 			AppID:            KeyAppID.Get(envPrefix),
@@ -301,12 +354,21 @@ func NewEnvContext(prefix string) (EnvContext, error) {
 			AvailabilityZone: KeyAvailabilityZone.Get(envPrefix),
 			NetworkID:        KeyNetworkID.Get(envPrefix),
 			DatacenterID:     KeyDatacenterID.Get(envPrefix),
+			SPIFFE:           DetectSPIFFE(envPrefix),
 		},
 		Process: ProcessContext{
-			PID:  os.Getpid(),
-			PPID: os.Getppid(),
+			PID:        os.Getpid(),
+			PPID:       os.Getppid(),
+			Systemd:    DetectSystemd(),
+			Windows:    DetectWindows(),
+			Supervisor: DetectSupervisor(),
 		},
-		User: UserContext{},
+		User:       UserContext{},
+		Resources:  DetectResources(),
+		Proxy:      DetectProxy(envPrefix),
+		Locale:     LocaleContext{System: DetectSystemLocale()},
+		Serverless: DetectServerless(),
+		Terminal:   DetectTerminal(),
 	}
 
 	hn, err := os.Hostname()
@@ -314,6 +376,7 @@ func NewEnvContext(prefix string) (EnvContext, error) {
 		return ctx, fmt.Errorf("could not determine the systems hostname: %v", err)
 	}
 	ctx.Host.Hostname = hn
+	ctx.Host.Security = DetectSecurity()
 
 	// --- Resolve the System UUID
 	mid, err := machineid.ID()
@@ -334,6 +397,20 @@ func NewEnvContext(prefix string) (EnvContext, error) {
 	ctx.User.UID = u.Uid
 	ctx.User.GID = u.Gid
 
+	if err := airGappedFromEnv(envPrefix); err != nil {
+		return ctx, err
+	}
+
+	// --- Fill the deployment from the orchestrator, after air-gapped mode is known
+	ctx.Deployment.applyPlatform(DetectPlatform())
+
+	// --- Capture the application defined variables
+	vars, err := loadVars(envPrefix, opts)
+	if err != nil {
+		return ctx, err
+	}
+	ctx.Vars = vars
+
 	if val := KeyEnvironment.Get(envPrefix); val != "" {
 		env, err := ParseEnv(val)
 		if err != nil {
@@ -342,6 +419,14 @@ func NewEnvContext(prefix string) (EnvContext, error) {
 		ctx.Environment = env
 	}
 
+	// --- Expand ~ and $VARS in the paths, e.g. CFGFX_CONFIG_DIR=~/app/config
+	if ctx.AppPath, err = ExpandPath(ctx.AppPath); err != nil {
+		return ctx, fmt.Errorf("%s could not be expanded: %v", KeyAppPath, err)
+	}
+	if ctx.ConfigPath, err = expandPathList(ctx.ConfigPath); err != nil {
+		return ctx, fmt.Errorf("%s could not be expanded: %v", KeyConfigPath, err)
+	}
+
 	// --- Resolve the AppPath (CFGFX_APP_DIR)
 	// If it wasn't set by the user, try to get the binaries current working directory.
 	if ctx.AppPath == "" {
@@ -363,6 +448,10 @@ func NewEnvContext(prefix string) (EnvContext, error) {
 		ctx.AppPath = abspath
 	}
 
+	if _, err := createDir(opts, ctx.AppPath); err != nil {
+		return ctx, fmt.Errorf("%s is set to %s - which could not be created: %v", KeyAppPath, ctx.AppPath, err)
+	}
+
 	// check to make sure AppDir it's real and readable
 	stat, err := os.Stat(ctx.AppPath)
 	if err != nil {
@@ -380,6 +469,18 @@ func NewEnvContext(prefix string) (EnvContext, error) {
 	}
 
 	// --- Resolve the AppConfigPath (CFGFX_CONFIG_DIR)
+	// A search path such as ./config:/etc/myapp lists directories in order of precedence. The
+	// first one must exist, the others are skipped while they do not.
+	if list := filepath.SplitList(ctx.ConfigPath); len(list) > 1 {
+		ctx.ConfigPath = list[0]
+		for _, p := range list {
+			if abs, err := filepath.Abs(p); err == nil {
+				p = abs
+			}
+			ctx.ConfigPaths = append(ctx.ConfigPaths, p)
+		}
+	}
+
 	// If it's not set, set it to AppPath's config subdirectory
 	if ctx.ConfigPath == "" {
 		ctx.ConfigPath = filepath.Join(ctx.AppPath, _defaultConfigDir)
@@ -394,6 +495,14 @@ func NewEnvContext(prefix string) (EnvContext, error) {
 		ctx.ConfigPath = abspath
 	}
 
+	if len(ctx.ConfigPaths) > 0 {
+		ctx.ConfigPaths[0] = ctx.ConfigPath
+	}
+
+	if err := createConfigDir(opts, ctx.ConfigPath); err != nil {
+		return ctx, fmt.Errorf("%s is set to %s - which could not be created: %v", KeyConfigPath, ctx.ConfigPath, err)
+	}
+
 	// check to make sure ConfigDir it's real and readable
 	stat, err = os.Stat(ctx.ConfigPath)
 	if err != nil {
@@ -0,0 +1,283 @@
+package cfx
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// EnvVarOrigin describes where an environment variable documented in the manifest comes from.
+type EnvVarOrigin string
+
+const (
+	// OriginCFX is used for the environment variables read by cfx itself.
+	OriginCFX EnvVarOrigin = "cfx"
+
+	// OriginApp is used for environment variables declared by the application.
+	OriginApp EnvVarOrigin = "app"
+
+	// OriginSection is used for environment variables referenced by registered config sections.
+	OriginSection EnvVarOrigin = "section"
+
+	// OriginConfig is used for environment variables referenced by ${VAR} expansions in config files.
+	OriginConfig EnvVarOrigin = "config"
+)
+
+// EnvVarDoc documents a single environment variable the application can read.
+type EnvVarDoc struct {
+	// Name is the full name of the variable, including the prefix when it has one.
+	Name string `json:"name" yaml:"name"`
+
+	// Prefix is the EnvKeyPrefix applied to the variable, if any.
+	Prefix EnvKeyPrefix `json:"prefix,omitempty" yaml:"prefix,omitempty"`
+
+	// Key is the variable name without its prefix.
+	Key string `json:"key" yaml:"key"`
+
+	// Description explains what the variable controls.
+	Description string `json:"description,omitempty" yaml:"description,omitempty"`
+
+	// Default is the value used when the variable is not set.
+	Default string `json:"default,omitempty" yaml:"default,omitempty"`
+
+	// Secret is true when the variable holds sensitive material.
+	Secret bool `json:"secret,omitempty" yaml:"secret,omitempty"`
+
+	// Origin is where the variable was discovered.
+	Origin EnvVarOrigin `json:"origin" yaml:"origin"`
+}
+
+// envVarInfo is the registry entry used to document a prefixed EnvVar.
+type envVarInfo struct {
+	Var         EnvVar
+	Description string
+	Default     string
+	Secret      bool
+	Required    bool
+	Validate    func(string) error
+	Origin      EnvVarOrigin
+}
+
+var (
+	envRegistryMu sync.RWMutex
+	envRegistry   = []envVarInfo{
+		{Var: KeyEnvironment, Origin: OriginCFX, Default: string(_defaultEnv), Description: "Environment the application is running in; selects <environment>.yaml."},
+		{Var: KeyAppPath, Origin: OriginCFX, Default: "current working directory", Description: "Base working directory of the application."},
+		{Var: KeyConfigPath, Origin: OriginCFX, Default: "$APP_DIR/" + _defaultConfigDir, Description: "Directory containing the YAML configuration files."},
+		{Var: KeyAppID, Origin: OriginCFX, Description: "Identifier of the application."},
+		{Var: KeyServiceID, Origin: OriginCFX, Description: "Identifier grouping several related applications together."},
+		{Var: KeyInstanceID, Origin: OriginCFX, Description: "Unique identifier of the running instance."},
+		{Var: KeyRegion, Origin: OriginCFX, Description: "Region the instance is deployed in."},
+		{Var: KeyAvailabilityZone, Origin: OriginCFX, Description: "Availability zone within the region."},
+		{Var: KeyNetworkID, Origin: OriginCFX, Description: "Identifier of the network the instance is attached to."},
+		{Var: KeyDatacenterID, Origin: OriginCFX, Description: "Identifier of the datacenter the instance runs in."},
+	}
+)
+
+// registerEnvVar adds a documented EnvVar to the manifest registry.
+func registerEnvVar(info envVarInfo) {
+	envRegistryMu.Lock()
+	defer envRegistryMu.Unlock()
+	for i, existing := range envRegistry {
+		if existing.Var == info.Var {
+			envRegistry[i] = info
+			return
+		}
+	}
+	envRegistry = append(envRegistry, info)
+}
+
+// EnvManifest returns documentation for every environment variable the application can read:
+// the variables read by cfx, those registered by the application, and the variables referenced
+// through "env:" secret references in the defaults of the provided sections.
+func EnvManifest(prefix EnvKeyPrefix, sections ...SectionSpec) []EnvVarDoc {
+	if prefix == "" {
+		prefix = DefaultEnvKeyPrefix
+	}
+
+	envRegistryMu.RLock()
+	ret := make([]EnvVarDoc, 0, len(envRegistry))
+	for _, info := range envRegistry {
+		ret = append(ret, EnvVarDoc{
+			Name:        info.Var.Key(prefix),
+			Prefix:      prefix,
+			Key:         string(info.Var),
+			Description: info.Description,
+			Default:     info.Default,
+			Secret:      info.Secret,
+			Origin:      info.Origin,
+		})
+	}
+	envRegistryMu.RUnlock()
+
+	for _, spec := range sections {
+		ret = append(ret, sectionEnvRefs(spec)...)
+	}
+
+	return dedupeEnvDocs(ret)
+}
+
+// sectionEnvRefs finds SecretRef and Secret fields using the env scheme in a section's defaults.
+func sectionEnvRefs(spec SectionSpec) []EnvVarDoc {
+	ret := []EnvVarDoc{}
+	if spec.Target == nil {
+		return ret
+	}
+
+	refType := reflect.TypeOf(SecretRef(""))
+	secretType := reflect.TypeOf(Secret(""))
+	var walk func(path string, v reflect.Value)
+	walk = func(path string, v reflect.Value) {
+		switch v.Kind() {
+		case reflect.Ptr, reflect.Interface:
+			if !v.IsNil() {
+				walk(path, v.Elem())
+			}
+		case reflect.Struct:
+			t := v.Type()
+			for i := 0; i < v.NumField(); i++ {
+				if t.Field(i).PkgPath != "" {
+					continue
+				}
+				walk(joinKey(path, yamlFieldName(t.Field(i))), v.Field(i))
+			}
+		case reflect.String:
+			if v.Type() != refType && v.Type() != secretType {
+				return
+			}
+			scheme, name, err := SecretRef(v.String()).Split()
+			if err != nil || scheme != "env" {
+				return
+			}
+			ret = append(ret, EnvVarDoc{
+				Name:        name,
+				Key:         name,
+				Description: fmt.Sprintf("Secret referenced by config key %s.", path),
+				Secret:      true,
+				Origin:      OriginSection,
+			})
+		}
+	}
+	walk(spec.Key, reflect.ValueOf(spec.Target))
+
+	return ret
+}
+
+func yamlFieldName(f reflect.StructField) string {
+	name := strings.Split(f.Tag.Get("yaml"), ",")[0]
+	if name == "" {
+		return strings.ToLower(f.Name)
+	}
+	return name
+}
+
+// ScanEnvReferences documents every ${VAR} expansion found in the YAML files of configDir.
+func ScanEnvReferences(configDir string) ([]EnvVarDoc, error) {
+	files, err := ioutil.ReadDir(configDir)
+	if err != nil {
+		return nil, fmt.Errorf("could not list config directory: %v", err)
+	}
+
+	ret := []EnvVarDoc{}
+	for _, f := range files {
+		if f.IsDir() || !yamlExts[filepath.Ext(f.Name())] {
+			continue
+		}
+
+		data, err := ioutil.ReadFile(filepath.Join(configDir, f.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("could not read config file %s: %v", f.Name(), err)
+		}
+
+		refs, err := scanExpansions(data)
+		if err != nil {
+			return nil, fmt.Errorf("could not scan config file %s: %v", f.Name(), err)
+		}
+
+		for _, ref := range refs {
+			if ref.Name == DataRefPrefix && ref.Op == ":" {
+				// ${data:key} references a data file, not the environment
+				continue
+			}
+			if isCredentialRef(ref) || isKeychainRef(ref) {
+				continue
+			}
+			ret = append(ret, EnvVarDoc{
+				Name:        ref.Name,
+				Key:         ref.Name,
+				Description: fmt.Sprintf("Expanded in %s.", f.Name()),
+				Default:     ref.Default(),
+				Secret:      isSensitiveKey(ref.Name),
+				Origin:      OriginConfig,
+			})
+		}
+	}
+
+	return dedupeEnvDocs(ret), nil
+}
+
+// dedupeEnvDocs merges duplicate variables and sorts the result by name.
+func dedupeEnvDocs(docs []EnvVarDoc) []EnvVarDoc {
+	seen := map[string]int{}
+	ret := []EnvVarDoc{}
+	for _, d := range docs {
+		idx, ok := seen[d.Name]
+		if !ok {
+			seen[d.Name] = len(ret)
+			ret = append(ret, d)
+			continue
+		}
+		if d.Description != "" && !strings.Contains(ret[idx].Description, d.Description) {
+			ret[idx].Description = strings.TrimSpace(ret[idx].Description + " " + d.Description)
+		}
+		ret[idx].Secret = ret[idx].Secret || d.Secret
+		if ret[idx].Default == "" {
+			ret[idx].Default = d.Default
+		}
+	}
+
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Name < ret[j].Name
+	})
+
+	return ret
+}
+
+// WriteEnvManifestJSON writes the manifest as indented JSON.
+func WriteEnvManifestJSON(w io.Writer, docs []EnvVarDoc) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(docs)
+}
+
+// WriteEnvManifestMarkdown writes the manifest as a markdown table.
+func WriteEnvManifestMarkdown(w io.Writer, docs []EnvVarDoc) error {
+	if _, err := fmt.Fprintln(w, "| Variable | Description | Default | Secret | Origin |"); err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintln(w, "| --- | --- | --- | --- | --- |"); err != nil {
+		return err
+	}
+	for _, d := range docs {
+		secret := ""
+		if d.Secret {
+			secret = "yes"
+		}
+		_, err := fmt.Fprintf(w, "| `%s` | %s | %s | %s | %s |\n",
+			d.Name, markdownCell(d.Description), markdownCell(d.Default), secret, d.Origin)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func markdownCell(s string) string {
+	return strings.Replace(strings.Replace(s, "|", `\|`, -1), "\n", " ", -1)
+}
@@ -0,0 +1,23 @@
+package cfx
+
+import "testing"
+
+func TestEnvManifestListsCFXVariables(t *testing.T) {
+	docs := map[string]EnvVarDoc{}
+	for _, d := range EnvManifest("APP") {
+		if _, dup := docs[d.Name]; dup {
+			t.Errorf("%s is listed twice", d.Name)
+		}
+		docs[d.Name] = d
+	}
+	for _, k := range []EnvVar{KeyEnvironment, KeyAirGapped, KeyHTTPProxy, KeyHTTPSProxy, KeyNoProxy, KeySPIFFESVID} {
+		d, ok := docs[k.Key("APP")]
+		if !ok {
+			t.Errorf("%s is missing from the manifest", k.Key("APP"))
+			continue
+		}
+		if d.Origin != OriginCFX || d.Description == "" {
+			t.Errorf("%s is not documented as a cfx variable: %+v", d.Name, d)
+		}
+	}
+}
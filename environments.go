@@ -0,0 +1,105 @@
+package cfx
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// EnvironmentsManifestName is the base name of the optional manifest in ConfigPath that declares
+// how environments inherit from each other, for example environments.yaml:
+//
+//	environments:
+//	  production-like: {}
+//	  staging:
+//	    parent: production-like
+//	  production:
+//	    parent: production-like
+//
+// With this manifest, staging is loaded as base.yaml, then production-like.yaml, then
+// staging.yaml, each overlaying the one before it.
+const EnvironmentsManifestName = "environments"
+
+// EnvironmentsManifest declares the inheritance between environment config files.
+type EnvironmentsManifest struct {
+	Environments map[string]EnvironmentSpec `json:"environments,omitempty" yaml:"environments,omitempty" mapstructure:"environments,omitempty"`
+}
+
+// EnvironmentSpec describes a single environment in the EnvironmentsManifest.
+type EnvironmentSpec struct {
+	// Parent is the name of the config file this environment overlays.
+	Parent string `json:"parent,omitempty" yaml:"parent,omitempty" mapstructure:"parent,omitempty"`
+
+	// Description documents the environment.
+	Description string `json:"description,omitempty" yaml:"description,omitempty" mapstructure:"description,omitempty"`
+}
+
+// ParseEnvironmentsManifest decodes an EnvironmentsManifest.
+func ParseEnvironmentsManifest(data []byte) (*EnvironmentsManifest, error) {
+	m := &EnvironmentsManifest{}
+	if err := yaml.UnmarshalStrict(data, m); err != nil {
+		return nil, fmt.Errorf("could not parse environments manifest: %v", err)
+	}
+	return m, nil
+}
+
+// Chain returns the config names to overlay for env, most general first and env itself last.
+// Environments not listed in the manifest have no parents.
+func (m *EnvironmentsManifest) Chain(env string) ([]string, error) {
+	chain := []string{}
+	seen := map[string]bool{}
+	for name := env; name != ""; {
+		key := strings.ToLower(name)
+		if seen[key] {
+			return nil, fmt.Errorf("environment %s inherits from itself: %s", env, strings.Join(append(reverseStrings(chain), name), " -> "))
+		}
+		seen[key] = true
+		chain = append(chain, name)
+
+		spec, _ := m.lookup(name)
+		name = spec.Parent
+	}
+
+	return reverseStrings(chain), nil
+}
+
+// lookup finds an environment by name, ignoring case like config file resolution does.
+func (m *EnvironmentsManifest) lookup(name string) (EnvironmentSpec, bool) {
+	if m == nil {
+		return EnvironmentSpec{}, false
+	}
+	for k, v := range m.Environments {
+		if strings.EqualFold(k, name) {
+			return v, true
+		}
+	}
+	return EnvironmentSpec{}, false
+}
+
+// readEnvironmentsManifest loads the manifest from configDir, returning nil if there is none.
+func readEnvironmentsManifest(configDir string) (*EnvironmentsManifest, error) {
+	path, err := resolveConfig(configDir, EnvironmentsManifestName)
+	if err == ErrConfigNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read environments manifest %s: %v", path, err)
+	}
+
+	return ParseEnvironmentsManifest(data)
+}
+
+func reverseStrings(s []string) []string {
+	ret := make([]string, len(s))
+	for i, v := range s {
+		ret[len(s)-1-i] = v
+	}
+	return ret
+}
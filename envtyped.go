@@ -0,0 +1,86 @@
+package cfx
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The typed getters leave the value out of their errors, since the variable may hold a secret such
+// as a password or a URL with credentials in it.
+
+// GetBool parses the environment variable as a boolean. Accepted values are those understood by
+// strconv.ParseBool plus "yes", "no", "on" and "off". If the variable is not set, def is returned.
+func (e EnvVar) GetBool(p EnvKeyPrefix, def bool) (bool, error) {
+	val, ok := e.lookupTrimmed(p)
+	if !ok {
+		return def, nil
+	}
+
+	switch strings.ToLower(val) {
+	case "yes", "y", "on":
+		return true, nil
+	case "no", "n", "off":
+		return false, nil
+	}
+
+	b, err := strconv.ParseBool(val)
+	if err != nil {
+		return def, fmt.Errorf("%s is not set to a valid boolean", e.Key(p))
+	}
+	return b, nil
+}
+
+// GetInt parses the environment variable as a base 10 integer. If the variable is not set, def is returned.
+func (e EnvVar) GetInt(p EnvKeyPrefix, def int) (int, error) {
+	val, ok := e.lookupTrimmed(p)
+	if !ok {
+		return def, nil
+	}
+
+	i, err := strconv.Atoi(val)
+	if err != nil {
+		return def, fmt.Errorf("%s is not set to a valid integer", e.Key(p))
+	}
+	return i, nil
+}
+
+// GetDuration parses the environment variable with time.ParseDuration. If the variable is not set, def is returned.
+func (e EnvVar) GetDuration(p EnvKeyPrefix, def time.Duration) (time.Duration, error) {
+	val, ok := e.lookupTrimmed(p)
+	if !ok {
+		return def, nil
+	}
+
+	d, err := time.ParseDuration(val)
+	if err != nil {
+		return def, fmt.Errorf("%s is not set to a valid duration", e.Key(p))
+	}
+	return d, nil
+}
+
+// GetURL parses the environment variable as an absolute URL. If the variable is not set, def is returned.
+func (e EnvVar) GetURL(p EnvKeyPrefix, def *url.URL) (*url.URL, error) {
+	val, ok := e.lookupTrimmed(p)
+	if !ok {
+		return def, nil
+	}
+
+	u, err := url.Parse(val)
+	if err != nil {
+		return def, fmt.Errorf("%s is not set to a valid url", e.Key(p))
+	}
+	if !u.IsAbs() || u.Host == "" {
+		return def, fmt.Errorf("%s is not set to an absolute url", e.Key(p))
+	}
+	return u, nil
+}
+
+// lookupTrimmed treats variables that are set to an empty string as unset.
+func (e EnvVar) lookupTrimmed(p EnvKeyPrefix) (string, bool) {
+	val, ok := e.Lookup(p)
+	val = strings.TrimSpace(val)
+	return val, ok && val != ""
+}
@@ -0,0 +1,36 @@
+package cfx
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestEnvTypedErrorsOmitValue(t *testing.T) {
+	const secret = "hunter2"
+	v := EnvVar("TYPED")
+	p := EnvKeyPrefix("CFXTEST")
+
+	setTestEnv(t, v.Key(p), secret)
+	errs := map[string]error{}
+	_, errs["bool"] = v.GetBool(p, false)
+	_, errs["int"] = v.GetInt(p, 0)
+	_, errs["duration"] = v.GetDuration(p, 0)
+
+	setTestEnv(t, v.Key(p), "postgres://admin:"+secret+"@db:bad-port/app")
+	_, errs["url"] = v.GetURL(p, nil)
+	setTestEnv(t, v.Key(p), "admin:"+secret+"@db")
+	_, errs["relative url"] = v.GetURL(p, nil)
+
+	for name, err := range errs {
+		if err == nil {
+			t.Errorf("%s: parsed an invalid value", name)
+			continue
+		}
+		if strings.Contains(err.Error(), secret) {
+			t.Errorf("%s: error %q contains the value", name, err)
+		}
+		if !strings.Contains(err.Error(), v.Key(p)) {
+			t.Errorf("%s: error %q does not name the variable", name, err)
+		}
+	}
+}
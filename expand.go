@@ -0,0 +1,553 @@
+package cfx
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// NoExpandDirective disables ${VAR} expansion for an entire file when it appears in a
+// comment before the first YAML content, for example:
+//
+//	# cfx:noexpand
+//	dashboards:
+//	  title: "${__field.name}"
+const NoExpandDirective = "cfx:noexpand"
+
+// expansion is a single ${...} reference found in a configuration source.
+type expansion struct {
+	// Name is the referenced environment variable.
+	Name string
+
+	// Op is the operator between the name and the argument, or empty for ${VAR}.
+	Op string
+
+	// Arg is the default value or message following the operator.
+	Arg string
+
+	// Line is the 1 based line the reference starts on.
+	Line int
+}
+
+// UnsetMode controls what a ${VAR} reference to an unset variable without a default expands to.
+type UnsetMode string
+
+const (
+	// UnsetEmpty silently expands unset variables to an empty string. This is the default.
+	UnsetEmpty UnsetMode = "empty"
+
+	// UnsetWarn expands unset variables to an empty string and reports a warning.
+	UnsetWarn UnsetMode = "warn"
+
+	// UnsetError fails the load when a variable is unset.
+	UnsetError UnsetMode = "error"
+
+	// UnsetKeepLiteral leaves the ${VAR} reference in the value unchanged.
+	UnsetKeepLiteral UnsetMode = "keep"
+)
+
+// UnresolvedExpansion describes a ${VAR} reference whose variable was not set.
+type UnresolvedExpansion struct {
+	// Source is the file the reference was found in.
+	Source string `json:"source" yaml:"source"`
+
+	// Line is the line of the source the reference starts on.
+	Line int `json:"line" yaml:"line"`
+
+	// Key is the dotted configuration key the reference belongs to. It is inferred from
+	// the indentation of block style YAML and may be empty for flow style documents.
+	Key string `json:"key,omitempty" yaml:"key,omitempty"`
+
+	// Var is the name of the unset environment variable.
+	Var string `json:"var" yaml:"var"`
+
+	// Mode is the UnsetMode that was applied.
+	Mode UnsetMode `json:"mode" yaml:"mode"`
+}
+
+// String implements the fmt.Stringer interface.
+func (u UnresolvedExpansion) String() string {
+	loc := fmt.Sprintf("%s:%d", u.Source, u.Line)
+	if u.Key != "" {
+		loc = fmt.Sprintf("%s (key %s)", loc, u.Key)
+	}
+	return fmt.Sprintf("%s: environment variable %s is not set", loc, u.Var)
+}
+
+// WithUnsetExpansion sets how references to unset environment variables are handled.
+func WithUnsetExpansion(mode UnsetMode) Option {
+	return func(o *options) {
+		o.unsetMode = mode
+	}
+}
+
+// WithUnsetExpansionFor overrides the UnsetMode for references under the dotted key.
+// The most specific matching key wins.
+func WithUnsetExpansionFor(key string, mode UnsetMode) Option {
+	return func(o *options) {
+		if o.unsetKeyModes == nil {
+			o.unsetKeyModes = map[string]UnsetMode{}
+		}
+		o.unsetKeyModes[key] = mode
+	}
+}
+
+// WithExpansionWarnings sets the function that receives references handled with UnsetWarn.
+// By default they are written with the standard library logger.
+func WithExpansionWarnings(fn func(UnresolvedExpansion)) Option {
+	return func(o *options) {
+		o.expansionWarn = fn
+	}
+}
+
+// WithEnvAllow restricts ${VAR} expansion to the environment variables matching patterns.
+// A pattern is either an exact name or a prefix followed by "*", such as "MYAPP_*". Referencing
+// any other variable fails the load, which stops configuration files, for example those
+// supplied by tenants or plugins, from reading arbitrary values out of the process environment.
+// Calling it several times extends the allowlist.
+func WithEnvAllow(patterns ...string) Option {
+	return func(o *options) {
+		o.envPolicy.allow = append(o.envPolicy.allow, patterns...)
+	}
+}
+
+// WithEnvDeny forbids ${VAR} expansion of the environment variables matching patterns, using
+// the same pattern syntax as WithEnvAllow. Denied variables are rejected even if they are allowed.
+func WithEnvDeny(patterns ...string) Option {
+	return func(o *options) {
+		o.envPolicy.deny = append(o.envPolicy.deny, patterns...)
+	}
+}
+
+// envPolicy decides which environment variables may be expanded.
+type envPolicy struct {
+	allow []string
+	deny  []string
+}
+
+func (p envPolicy) permits(name string) bool {
+	if matchEnvPattern(p.deny, name) {
+		return false
+	}
+	return len(p.allow) == 0 || matchEnvPattern(p.allow, name)
+}
+
+func matchEnvPattern(patterns []string, name string) bool {
+	for _, p := range patterns {
+		if strings.HasSuffix(p, "*") {
+			if strings.HasPrefix(name, strings.TrimSuffix(p, "*")) {
+				return true
+			}
+			continue
+		}
+		if p == name {
+			return true
+		}
+	}
+	return false
+}
+
+// UnresolvedExpansions returns every reference to an unset environment variable that was
+// found while loading the Container's current configuration, whatever mode was applied.
+func UnresolvedExpansions(c Container) []UnresolvedExpansion {
+	y, ok := c.(*yamlContainer)
+	if !ok {
+		return nil
+	}
+	snap := y.currentSnapshot()
+	if snap == nil {
+		return nil
+	}
+	return append([]UnresolvedExpansion{}, snap.unresolved...)
+}
+
+// expander expands the ${VAR} references of configuration sources.
+type expander struct {
+	lookup     func(string) (string, bool)
+	mode       UnsetMode
+	keyModes   map[string]UnsetMode
+	warn       func(UnresolvedExpansion)
+	policy     envPolicy
+	unresolved []UnresolvedExpansion
+
+	// dataFiles are read into data on the first ${data:key} reference.
+	dataFiles  []string
+	limits     Limits
+	data       map[string]interface{}
+	dataErr    error
+	dataLoaded bool
+
+	// prompter asks for variables that would otherwise fail expansion, see WithInteractiveFallback.
+	prompter *prompter
+
+	// credential and keychain resolve ${cred:name} and ${keychain:service/account} references.
+	credential func(expansion) (string, error)
+	keychain   func(expansion) (string, error)
+}
+
+func newExpander(opts *options, lookup func(string) (string, bool)) *expander {
+	warn := opts.expansionWarn
+	if warn == nil {
+		warn = func(u UnresolvedExpansion) {
+			log.Printf("cfx: %s", u)
+		}
+	}
+	return &expander{
+		lookup:    lookup,
+		mode:      opts.unsetMode,
+		keyModes:  opts.unsetKeyModes,
+		warn:      warn,
+		policy:    opts.envPolicy,
+		dataFiles: opts.dataFiles,
+		limits:    opts.limits,
+		prompter:  opts.prompter,
+
+		credential: resolveCredentialRef,
+		keychain:   resolveKeychainRef,
+	}
+}
+
+// modeFor returns the UnsetMode that applies to key.
+func (e *expander) modeFor(key string) UnsetMode {
+	mode, best := e.mode, -1
+	for k, m := range e.keyModes {
+		if (key == k || strings.HasPrefix(key, k+".") || k == "") && len(k) > best {
+			mode, best = m, len(k)
+		}
+	}
+	if mode == "" {
+		return UnsetEmpty
+	}
+	return mode
+}
+
+// expandSource expands ${VAR} references in the source. "$$" is an escape for a literal "$",
+// so "$${VAR}" produces the literal text "${VAR}". Sources carrying the NoExpandDirective are
+// returned unchanged.
+func (e *expander) expandSource(src configSource) ([]byte, error) {
+	if hasNoExpandDirective(src.data) {
+		return src.data, nil
+	}
+
+	var keys []string
+
+	var out bytes.Buffer
+	out.Grow(len(src.data))
+
+	err := walkExpansions(src.data, func(literal []byte) {
+		out.Write(literal)
+	}, func(exp expansion) error {
+		if isCredentialRef(exp) {
+			val, err := e.credential(exp)
+			if err != nil {
+				return fmt.Errorf("line %d: %v", exp.Line, err)
+			}
+			out.WriteString(val)
+			return nil
+		}
+		if isKeychainRef(exp) {
+			val, err := e.keychain(exp)
+			if err != nil {
+				return fmt.Errorf("line %d: %v", exp.Line, err)
+			}
+			out.WriteString(val)
+			return nil
+		}
+		if e.isDataRef(exp) {
+			val, err := e.resolveData(exp)
+			if err != nil {
+				return fmt.Errorf("line %d: %v", exp.Line, err)
+			}
+			out.WriteString(val)
+			return nil
+		}
+
+		val, ok, err := e.resolve(exp)
+		if err != nil {
+			return fmt.Errorf("line %d: %v", exp.Line, err)
+		}
+		if ok {
+			out.WriteString(val)
+			return nil
+		}
+
+		if keys == nil {
+			keys = yamlLineKeys(src.data)
+		}
+		u := UnresolvedExpansion{Source: src.name, Line: exp.Line, Var: exp.Name}
+		if exp.Line < len(keys) {
+			u.Key = keys[exp.Line]
+		}
+		u.Mode = e.modeFor(u.Key)
+		e.unresolved = append(e.unresolved, u)
+
+		switch u.Mode {
+		case UnsetError:
+			if v, ok, err := e.prompter.ask(exp.Name, u.Key, false); err != nil {
+				return fmt.Errorf("line %d: %v", exp.Line, err)
+			} else if ok {
+				e.unresolved = e.unresolved[:len(e.unresolved)-1]
+				out.WriteString(v)
+				return nil
+			}
+			if u.Key != "" {
+				return fmt.Errorf("line %d: environment variable %s referenced by key %s is not set", exp.Line, exp.Name, u.Key)
+			}
+			return fmt.Errorf("line %d: environment variable %s is not set", exp.Line, exp.Name)
+		case UnsetWarn:
+			e.warn(u)
+		case UnsetKeepLiteral:
+			out.WriteString(exp.String())
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", src.name, err)
+	}
+
+	return out.Bytes(), nil
+}
+
+// resolve returns the value a single reference expands to, or false if its variable is
+// unset and it has no default. ${VAR:-default} and ${VAR:?message} follow the shell and also
+// apply when the variable is set but empty; ${VAR-default} and ${VAR?message} apply only when
+// it is unset. ${VAR:default} is accepted as a synonym for ${VAR:-default}.
+func (e *expander) resolve(exp expansion) (string, bool, error) {
+	if !e.policy.permits(exp.Name) {
+		return "", false, fmt.Errorf("expansion of environment variable %s is not permitted", exp.Name)
+	}
+
+	val, ok := e.lookup(exp.Name)
+	if ok && (val != "" || !exp.emptyIsUnset()) {
+		return val, true, nil
+	}
+
+	switch exp.Op {
+	case ":", ":-", "-":
+		return exp.Arg, true, nil
+	case ":?", "?":
+		if v, ok, err := e.prompter.ask(exp.Name, exp.Arg, false); err != nil || ok {
+			return v, ok, err
+		}
+		msg := exp.Arg
+		if msg == "" {
+			msg = "parameter null or not set"
+		}
+		return "", false, fmt.Errorf("%s: %s", exp.Name, msg)
+	}
+
+	return val, ok, nil
+}
+
+// scanExpansions returns every reference in data, honouring escapes and the
+// NoExpandDirective.
+func scanExpansions(data []byte) ([]expansion, error) {
+	if hasNoExpandDirective(data) {
+		return nil, nil
+	}
+
+	ret := []expansion{}
+	err := walkExpansions(data, func([]byte) {}, func(exp expansion) error {
+		ret = append(ret, exp)
+		return nil
+	})
+
+	return ret, err
+}
+
+// walkExpansions splits data into literal runs and references.
+func walkExpansions(data []byte, literal func([]byte), ref func(expansion) error) error {
+	line := 1
+	start := 0
+	for i := 0; i < len(data); i++ {
+		switch data[i] {
+		case '\n':
+			line++
+			continue
+		case '$':
+		default:
+			continue
+		}
+
+		if i+1 >= len(data) {
+			break
+		}
+
+		switch data[i+1] {
+		case '$':
+			// escaped dollar sign
+			literal(data[start : i+1])
+			i++
+			start = i + 1
+		case '{':
+			end := bytes.IndexByte(data[i+2:], '}')
+			if end < 0 {
+				return fmt.Errorf("line %d: unterminated ${ expansion, use $${ for a literal", line)
+			}
+			exp, err := parseExpansion(string(data[i+2 : i+2+end]))
+			if err != nil {
+				return fmt.Errorf("line %d: %v", line, err)
+			}
+			exp.Line = line
+
+			literal(data[start:i])
+			if err := ref(exp); err != nil {
+				return err
+			}
+
+			line += bytes.Count(data[i:i+2+end], []byte{'\n'})
+			i += 2 + end
+			start = i + 1
+		}
+	}
+
+	literal(data[start:])
+	return nil
+}
+
+// String returns the reference in its ${...} form.
+func (e expansion) String() string {
+	return "${" + e.Name + e.Op + e.Arg + "}"
+}
+
+// parseExpansion parses the body of a ${...} reference.
+func parseExpansion(body string) (expansion, error) {
+	n := 0
+	for n < len(body) && isExpansionNameByte(body[n], n == 0) {
+		n++
+	}
+	if n == 0 {
+		return expansion{}, fmt.Errorf("invalid expansion ${%s}: expected an environment variable name", body)
+	}
+
+	exp := expansion{Name: body[:n]}
+	rest := body[n:]
+	if rest == "" {
+		return exp, nil
+	}
+
+	for _, op := range []string{":-", ":?", "-", "?", ":"} {
+		if strings.HasPrefix(rest, op) {
+			exp.Op, exp.Arg = op, rest[len(op):]
+			return exp, nil
+		}
+	}
+
+	return expansion{}, fmt.Errorf("invalid expansion ${%s}: unexpected %q after variable name", body, rest[:1])
+}
+
+// emptyIsUnset reports whether the operator treats an empty variable as unset.
+func (e expansion) emptyIsUnset() bool {
+	return strings.HasPrefix(e.Op, ":")
+}
+
+// Default returns the default value of the reference, if it has one.
+func (e expansion) Default() string {
+	switch e.Op {
+	case ":", ":-", "-":
+		return e.Arg
+	}
+	return ""
+}
+
+func isExpansionNameByte(c byte, first bool) bool {
+	switch {
+	case c == '_', c >= 'A' && c <= 'Z', c >= 'a' && c <= 'z':
+		return true
+	case c >= '0' && c <= '9':
+		return !first
+	}
+	return false
+}
+
+// yamlLineKeys infers the dotted key that each line of a block style YAML document belongs to,
+// indexed by 1 based line number. List items inherit the key of the list.
+func yamlLineKeys(data []byte) []string {
+	type frame struct {
+		indent int
+		key    string
+	}
+
+	lines := strings.Split(string(data), "\n")
+	keys := make([]string, len(lines)+1)
+
+	var stack []frame
+	path := func() string {
+		parts := make([]string, len(stack))
+		for i, f := range stack {
+			parts[i] = f.key
+		}
+		return strings.Join(parts, ".")
+	}
+
+	for i, line := range lines {
+		content := strings.TrimLeft(line, " ")
+		indent := len(line) - len(content)
+		if content == "" || strings.HasPrefix(content, "#") || content == "---" {
+			keys[i+1] = path()
+			continue
+		}
+
+		// unwrap list items so "- key: value" is treated as a mapping entry
+		for strings.HasPrefix(content, "- ") || content == "-" {
+			trimmed := strings.TrimLeft(strings.TrimPrefix(content, "-"), " ")
+			indent += len(content) - len(trimmed)
+			content = trimmed
+		}
+
+		key, ok := yamlLineKey(content)
+		if !ok {
+			keys[i+1] = path()
+			continue
+		}
+
+		for len(stack) > 0 && stack[len(stack)-1].indent >= indent {
+			stack = stack[:len(stack)-1]
+		}
+		stack = append(stack, frame{indent: indent, key: key})
+		keys[i+1] = path()
+	}
+
+	return keys
+}
+
+// yamlLineKey returns the mapping key that starts content, if any.
+func yamlLineKey(content string) (string, bool) {
+	if content[0] == '"' || content[0] == '\'' {
+		end := strings.IndexByte(content[1:], content[0])
+		if end < 0 || !strings.HasPrefix(content[end+2:], ":") {
+			return "", false
+		}
+		return content[1 : end+1], true
+	}
+
+	idx := strings.Index(content, ":")
+	if idx <= 0 || strings.ContainsAny(content[:idx], "{}[]#,") {
+		return "", false
+	}
+	if idx+1 < len(content) && content[idx+1] != ' ' && content[idx+1] != '\t' {
+		return "", false
+	}
+	return strings.TrimSpace(content[:idx]), true
+}
+
+// hasNoExpandDirective reports whether the leading comments of data contain NoExpandDirective.
+func hasNoExpandDirective(data []byte) bool {
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		switch {
+		case line == "", line == "---":
+			continue
+		case strings.HasPrefix(line, "#"):
+			if strings.TrimSpace(strings.TrimPrefix(line, "#")) == NoExpandDirective {
+				return true
+			}
+		default:
+			return false
+		}
+	}
+	return false
+}
@@ -0,0 +1,115 @@
+package cfx
+
+import (
+	"strings"
+	"testing"
+)
+
+func testExpand(opts *options, env map[string]string, data string) (string, *expander, error) {
+	e := newExpander(opts, func(name string) (string, bool) {
+		v, ok := env[name]
+		return v, ok
+	})
+	e.warn = func(UnresolvedExpansion) {}
+	out, err := e.expandSource(configSource{name: "test.yaml", data: []byte(data)})
+	return string(out), e, err
+}
+
+func TestExpandSource(t *testing.T) {
+	env := map[string]string{"HOST": "db.internal", "EMPTY": ""}
+	tests := []struct {
+		in, want string
+	}{
+		{"host: ${HOST}\n", "host: db.internal\n"},
+		{"host: ${MISSING}\n", "host: \n"},
+		{"host: ${MISSING:-fallback}\n", "host: fallback\n"},
+		{"host: ${MISSING:fallback}\n", "host: fallback\n"},
+		{"host: ${EMPTY:-fallback}\n", "host: fallback\n"},
+		{"host: ${EMPTY-fallback}\n", "host: \n"},
+		{"host: ${MISSING-fallback}\n", "host: fallback\n"},
+		{"host: ${HOST:-fallback}\n", "host: db.internal\n"},
+		{"url: http://${HOST}:${PORT:-5432}/db\n", "url: http://db.internal:5432/db\n"},
+		{"price: $$5 and $${HOST}\n", "price: $5 and ${HOST}\n"},
+		{"cost: $5\n", "cost: $5\n"},
+		{"# " + NoExpandDirective + "\ntitle: ${HOST}\n", "# " + NoExpandDirective + "\ntitle: ${HOST}\n"},
+	}
+	for _, tt := range tests {
+		got, _, err := testExpand(newOptions(nil), env, tt.in)
+		if err != nil {
+			t.Errorf("expand %q: %v", tt.in, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("expand %q = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestExpandRequiredVariable(t *testing.T) {
+	_, _, err := testExpand(newOptions(nil), nil, "db:\n  password: ${DB_PASSWORD:?must be set}\n")
+	if err == nil || !strings.Contains(err.Error(), "DB_PASSWORD: must be set") || !strings.Contains(err.Error(), "line 2") {
+		t.Fatalf("error = %v, want the message and line of the reference", err)
+	}
+
+	got, _, err := testExpand(newOptions(nil), map[string]string{"DB_PASSWORD": ""}, "password: ${DB_PASSWORD?}\n")
+	if err != nil || got != "password: \n" {
+		t.Errorf("set but empty variable with ? = %q, %v, want it accepted", got, err)
+	}
+}
+
+func TestExpandUnsetModes(t *testing.T) {
+	in := "db:\n  host: ${DB_HOST}\ncache:\n  host: ${CACHE_HOST}\n"
+
+	got, e, err := testExpand(newOptions([]Option{WithUnsetExpansion(UnsetKeepLiteral)}), nil, in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != in {
+		t.Errorf("keep mode produced %q, want the input unchanged", got)
+	}
+	if len(e.unresolved) != 2 || e.unresolved[0].Key != "db.host" || e.unresolved[1].Line != 4 {
+		t.Errorf("unresolved = %+v, want both references with their keys and lines", e.unresolved)
+	}
+
+	_, _, err = testExpand(newOptions([]Option{WithUnsetExpansionFor("cache", UnsetError)}), nil, in)
+	if err == nil || !strings.Contains(err.Error(), "CACHE_HOST referenced by key cache.host") {
+		t.Errorf("error = %v, want cache.host to fail while db.host expands to empty", err)
+	}
+
+	var warned []string
+	opts := newOptions([]Option{WithUnsetExpansion(UnsetWarn), WithExpansionWarnings(func(u UnresolvedExpansion) {
+		warned = append(warned, u.Var)
+	})})
+	e = newExpander(opts, func(string) (string, bool) { return "", false })
+	if _, err := e.expandSource(configSource{name: "test.yaml", data: []byte(in)}); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(warned, ",") != "DB_HOST,CACHE_HOST" {
+		t.Errorf("warnings = %v, want one per unset variable", warned)
+	}
+}
+
+func TestExpandEnvPolicy(t *testing.T) {
+	env := map[string]string{"APP_HOST": "a", "AWS_SECRET_ACCESS_KEY": "s"}
+	opts := newOptions([]Option{WithEnvAllow("APP_*")})
+
+	if got, _, err := testExpand(opts, env, "host: ${APP_HOST}\n"); err != nil || got != "host: a\n" {
+		t.Errorf("allowed variable = %q, %v", got, err)
+	}
+	if _, _, err := testExpand(opts, env, "key: ${AWS_SECRET_ACCESS_KEY}\n"); err == nil || !strings.Contains(err.Error(), "not permitted") {
+		t.Errorf("variable outside the allow list = %v, want it refused", err)
+	}
+
+	opts = newOptions([]Option{WithEnvDeny("AWS_*")})
+	if _, _, err := testExpand(opts, env, "key: ${AWS_SECRET_ACCESS_KEY:-x}\n"); err == nil {
+		t.Error("denied variable with a default was expanded")
+	}
+}
+
+func TestExpandInvalidReference(t *testing.T) {
+	for _, in := range []string{"a: ${}\n", "a: ${1ABC}\n", "a: ${A B}\n"} {
+		if _, _, err := testExpand(newOptions(nil), nil, in); err == nil {
+			t.Errorf("expand %q succeeded, want an error", in)
+		}
+	}
+}
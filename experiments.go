@@ -0,0 +1,285 @@
+package cfx
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"sync"
+	"time"
+
+	"go.uber.org/fx"
+)
+
+// ExperimentsKey is the config section read by ExperimentsModule.
+const ExperimentsKey = "experiments"
+
+const _experimentBuckets = 10000
+
+// ExperimentsModule provides an *Experiments that follows the "experiments" config section
+// across reloads.
+var ExperimentsModule = fx.Options(
+	ProvideSection(ExperimentsKey, &ExperimentsConfig{}),
+	fx.Provide(NewExperiments),
+)
+
+// ExperimentsConfig is the configuration section for experiments, keyed by experiment name:
+//
+//	experiments:
+//	  checkout_button:
+//	    enabled: true
+//	    traffic: 20
+//	    variants:
+//	      - name: control
+//	        weight: 1
+//	      - name: green
+//	        weight: 1
+//	    overrides:
+//	      qa-user-1: green
+type ExperimentsConfig map[string]ExperimentConfig
+
+// ExperimentConfig configures a single experiment.
+type ExperimentConfig struct {
+	// Enabled turns the experiment on. Disabled experiments assign every unit the first variant
+	// and log no exposures.
+	Enabled bool `json:"enabled,omitempty" yaml:"enabled,omitempty" mapstructure:"enabled,omitempty"`
+
+	// Traffic is the percentage of units that take part in the experiment, 100 if unset. Units
+	// that do not take part get the first variant and log no exposures. Raising it only adds
+	// units; none change variant.
+	Traffic *float64 `json:"traffic,omitempty" yaml:"traffic,omitempty" mapstructure:"traffic,omitempty"`
+
+	// Salt is mixed into the assignment hash, defaulting to the experiment name. Changing it
+	// reshuffles every assignment.
+	Salt string `json:"salt,omitempty" yaml:"salt,omitempty" mapstructure:"salt,omitempty"`
+
+	// Variants are the arms of the experiment. The first one is the control.
+	Variants []ExperimentVariant `json:"variants,omitempty" yaml:"variants,omitempty" mapstructure:"variants,omitempty"`
+
+	// Overrides force the variant of specific units, for example for QA accounts. Overridden
+	// units log exposures like any other.
+	Overrides map[string]string `json:"overrides,omitempty" yaml:"overrides,omitempty" mapstructure:"overrides,omitempty"`
+}
+
+// ExperimentVariant is an arm of an experiment.
+type ExperimentVariant struct {
+	Name string `json:"name" yaml:"name" mapstructure:"name"`
+
+	// Weight is the share of units assigned the variant, relative to the other variants.
+	Weight float64 `json:"weight" yaml:"weight" mapstructure:"weight"`
+}
+
+// Validate implements the cfx.Validator interface.
+func (e ExperimentsConfig) Validate() error {
+	names := make([]string, 0, len(e))
+	for name := range e {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if err := e[name].validate(); err != nil {
+			return fmt.Errorf("experiment %s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+func (e ExperimentConfig) validate() error {
+	if len(e.Variants) == 0 {
+		return errors.New("at least one variant is required")
+	}
+	if e.Traffic != nil && (*e.Traffic < 0 || *e.Traffic > 100) {
+		return fmt.Errorf("traffic must be between 0 and 100, got %v", *e.Traffic)
+	}
+
+	seen := map[string]bool{}
+	total := 0.0
+	for _, v := range e.Variants {
+		if v.Name == "" {
+			return errors.New("variants must have a name")
+		}
+		if seen[v.Name] {
+			return fmt.Errorf("variant %s is listed twice", v.Name)
+		}
+		if v.Weight < 0 {
+			return fmt.Errorf("variant %s has a negative weight", v.Name)
+		}
+		seen[v.Name] = true
+		total += v.Weight
+	}
+	if total <= 0 {
+		return errors.New("variant weights must add up to more than zero")
+	}
+
+	for unit, variant := range e.Overrides {
+		if !seen[variant] {
+			return fmt.Errorf("override for %s names unknown variant %s", unit, variant)
+		}
+	}
+	return nil
+}
+
+// Exposure records that a unit was shown a variant of an experiment.
+type Exposure struct {
+	Experiment string    `json:"experiment" yaml:"experiment"`
+	Variant    string    `json:"variant" yaml:"variant"`
+	Unit       string    `json:"unit" yaml:"unit"`
+	Time       time.Time `json:"time" yaml:"time"`
+}
+
+// ExposureLogger receives every Exposure, typically to forward it to an analytics pipeline.
+// It is called synchronously on the request path, so it should not block.
+type ExposureLogger func(Exposure)
+
+// Experiments assigns units, such as users or instances, to experiment variants. Assignments
+// are deterministic: a unit keeps its variant across processes and restarts for as long as the
+// experiment's salt and variants do not change. It follows the "experiments" section live as
+// the configuration is reloaded, and is safe for concurrent use.
+type Experiments struct {
+	env EnvContext
+
+	mu      sync.RWMutex
+	cfg     ExperimentsConfig
+	loggers []ExposureLogger
+	clock   func() time.Time
+}
+
+// NewExperiments reads the "experiments" section from c and follows its changes on reload. A
+// reload that makes the section invalid is rejected and the previous experiments are kept.
+func NewExperiments(c Container, env EnvContext) (*Experiments, error) {
+	e := &Experiments{env: env, clock: time.Now}
+	if err := e.load(c); err != nil {
+		return nil, err
+	}
+
+	if err := OnSectionChange(c, ExperimentsKey, func(string, []Change) error {
+		return e.load(c)
+	}); err != nil {
+		return nil, err
+	}
+
+	return e, nil
+}
+
+func (e *Experiments) load(c Container) error {
+	cfg := ExperimentsConfig{}
+	if err := c.Populate(ExperimentsKey, &cfg); err != nil {
+		return fmt.Errorf("could not populate experiments config: %v", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		return SectionError{Key: ExperimentsKey, Err: err}
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.cfg = cfg
+	return nil
+}
+
+// OnExposure registers fn to receive every exposure logged by Variant and InstanceVariant.
+func (e *Experiments) OnExposure(fn ExposureLogger) {
+	if fn == nil {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.loggers = append(e.loggers, fn)
+}
+
+// Variant returns the variant of experiment assigned to unit, such as a user ID, and logs the
+// exposure. Unknown experiments return "".
+func (e *Experiments) Variant(experiment, unit string) string {
+	variant, exposed := e.Assignment(experiment, unit)
+	if exposed {
+		e.expose(Exposure{Experiment: experiment, Variant: variant, Unit: unit})
+	}
+	return variant
+}
+
+// InstanceVariant returns the variant of experiment assigned to the running instance, using
+// its Identity as the unit, and logs the exposure.
+func (e *Experiments) InstanceVariant(experiment string) string {
+	return e.Variant(experiment, e.env.Identity())
+}
+
+// Assignment returns the variant of experiment assigned to unit without logging an exposure,
+// and whether the unit takes part in the experiment. Units that do not take part, because the
+// experiment is disabled or outside its traffic, get the first variant.
+func (e *Experiments) Assignment(experiment, unit string) (string, bool) {
+	e.mu.RLock()
+	cfg, ok := e.cfg[experiment]
+	e.mu.RUnlock()
+	if !ok || len(cfg.Variants) == 0 {
+		return "", false
+	}
+
+	control := cfg.Variants[0].Name
+	if !cfg.Enabled {
+		return control, false
+	}
+	if v, ok := cfg.Overrides[unit]; ok {
+		return v, true
+	}
+
+	salt := cfg.Salt
+	if salt == "" {
+		salt = experiment
+	}
+	if cfg.Traffic != nil && float64(experimentBucket(salt, "traffic", unit)) >= *cfg.Traffic*(_experimentBuckets/100) {
+		return control, false
+	}
+
+	total := 0.0
+	for _, v := range cfg.Variants {
+		total += v.Weight
+	}
+	point := float64(experimentBucket(salt, "variant", unit)) / _experimentBuckets * total
+	for _, v := range cfg.Variants {
+		if point < v.Weight {
+			return v.Name, true
+		}
+		point -= v.Weight
+	}
+	// rounding can leave the point past the last variant with weight
+	for i := len(cfg.Variants) - 1; i >= 0; i-- {
+		if cfg.Variants[i].Weight > 0 {
+			return cfg.Variants[i].Name, true
+		}
+	}
+	return control, true
+}
+
+// Config returns the current experiments.
+func (e *Experiments) Config() ExperimentsConfig {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.cfg
+}
+
+func (e *Experiments) expose(ex Exposure) {
+	e.mu.RLock()
+	loggers := e.loggers
+	e.mu.RUnlock()
+
+	if len(loggers) == 0 {
+		return
+	}
+	ex.Time = e.clock()
+	for _, fn := range loggers {
+		fn(ex)
+	}
+}
+
+// experimentBucket hashes unit into one of _experimentBuckets buckets. Traffic and variant
+// assignment use independent hashes so that changing the traffic does not move units between
+// variants.
+func experimentBucket(salt, purpose, unit string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(salt))
+	h.Write([]byte{'/'})
+	h.Write([]byte(purpose))
+	h.Write([]byte{'/'})
+	h.Write([]byte(unit))
+	return h.Sum32() % _experimentBuckets
+}
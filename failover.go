@@ -0,0 +1,338 @@
+package cfx
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LayerSource fetches a single layer of YAML configuration from somewhere other than the config
+// directory, such as a key/value store or an object store.
+type LayerSource interface {
+	// Name identifies the source in reports and errors.
+	Name() string
+
+	// FetchLayer returns the YAML content of the layer.
+	FetchLayer(ctx context.Context) ([]byte, error)
+}
+
+// FileLayer returns a LayerSource reading the file at path, typically a local cache of a
+// remote layer kept as the last resort of a FailoverLayer.
+func FileLayer(path string) LayerSource {
+	return fileLayer(path)
+}
+
+type fileLayer string
+
+// Name implements the LayerSource interface.
+func (f fileLayer) Name() string { return "file:" + string(f) }
+
+// FetchLayer implements the LayerSource interface.
+func (f fileLayer) FetchLayer(context.Context) ([]byte, error) {
+	return ioutil.ReadFile(string(f))
+}
+
+// HTTPLayer returns a LayerSource fetching url with a GET request, which covers most remote
+// stores: a Consul key read with "?raw", an S3 object through a presigned URL or a bucket
+// endpoint, or a plain web server. A nil client uses http.DefaultClient.
+func HTTPLayer(url string, client *http.Client) LayerSource {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &httpLayer{url: url, client: client}
+}
+
+// _maxLayerSize bounds the size of a layer fetched over HTTP.
+const _maxLayerSize = 16 << 20
+
+type httpLayer struct {
+	url    string
+	client *http.Client
+}
+
+// Name implements the LayerSource interface, leaving out credentials and query parameters such
+// as presigned URL signatures.
+func (h *httpLayer) Name() string {
+	name := redactLocation(h.url)
+	if i := strings.Index(name, "?"); i >= 0 {
+		name = name[:i]
+	}
+	return name
+}
+
+// FetchLayer implements the LayerSource interface.
+func (h *httpLayer) FetchLayer(ctx context.Context) ([]byte, error) {
+	if err := checkNetworkAllowed("config layer " + h.Name()); err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodGet, h.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := h.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, _maxLayerSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > _maxLayerSize {
+		return nil, fmt.Errorf("layer exceeds the maximum size of %d bytes", _maxLayerSize)
+	}
+	return data, nil
+}
+
+// FailoverLayer is a configuration layer with several sources, tried in order of preference:
+//
+//	layer := &cfx.FailoverLayer{
+//		Name: "shared",
+//		Sources: []cfx.LayerSource{
+//			cfx.HTTPLayer("http://consul:8500/v1/kv/shared/config.yaml?raw", nil),
+//			cfx.HTTPLayer("https://config.s3.amazonaws.com/shared/config.yaml", nil),
+//			cfx.FileLayer("/var/cache/app/shared.yaml"),
+//		},
+//		CacheFile: "/var/cache/app/shared.yaml",
+//	}
+//	c, err := cfx.NewConfigWithOptions(env, cfx.WithFailoverLayer(layer))
+//
+// A source that fails is marked unhealthy and skipped for RetryAfter, so a dead primary does
+// not slow every load down. Preferred sources are retried once RetryAfter has passed, and the
+// layer fails back to them as soon as they answer again. The layer only fails when every
+// source fails, so the availability of a single store does not gate the availability of the
+// service.
+type FailoverLayer struct {
+	// Name identifies the layer in reports and errors.
+	Name string
+
+	// Sources are tried in order of preference.
+	Sources []LayerSource
+
+	// CacheFile, if set, receives the content of every successful fetch, atomically. Adding a
+	// FileLayer for it as the last source keeps the layer available when every store is down.
+	// Failures to write it are logged, and it is not written in read-only mode.
+	CacheFile string
+
+	// Cache, if set, holds the content of the layer for CacheTTL, so that instances restarting
+	// together fetch it from the sources once. It defaults to the cache given to
+	// WithRemoteCache. Cache errors are logged and the sources are fetched.
+	Cache Cache
+
+	// CacheTTL is how long the content is cached. Defaults to one minute.
+	CacheTTL time.Duration
+
+	// Timeout bounds each fetch. Defaults to five seconds.
+	Timeout time.Duration
+
+	// RetryAfter is how long an unhealthy source is skipped. Defaults to thirty seconds.
+	RetryAfter time.Duration
+
+	mu     sync.Mutex
+	health []LayerSourceStatus
+	active int
+	digest string
+}
+
+// LayerSourceStatus describes the health of a single source of a FailoverLayer.
+type LayerSourceStatus struct {
+	Name string `json:"name" yaml:"name"`
+
+	// Healthy is false after the last fetch from the source failed.
+	Healthy bool `json:"healthy" yaml:"healthy"`
+
+	// Active is set for the source the current layer content came from.
+	Active bool `json:"active" yaml:"active"`
+
+	// Failures counts consecutive failures.
+	Failures int `json:"failures,omitempty" yaml:"failures,omitempty"`
+
+	LastError   string    `json:"last_error,omitempty" yaml:"last_error,omitempty"`
+	LastFailure time.Time `json:"last_failure,omitempty" yaml:"last_failure,omitempty"`
+	LastSuccess time.Time `json:"last_success,omitempty" yaml:"last_success,omitempty"`
+}
+
+// WithFailoverLayer adds a layer fetched from the first available of its sources. Layers are
+// merged after the configuration files, in the order they were added.
+func WithFailoverLayer(layer *FailoverLayer) Option {
+	return func(o *options) {
+		if layer != nil {
+			o.failoverLayers = append(o.failoverLayers, layer)
+		}
+	}
+}
+
+// Status returns the health of every source, in order of preference.
+func (l *FailoverLayer) Status() []LayerSourceStatus {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.init()
+	ret := make([]LayerSourceStatus, len(l.health))
+	copy(ret, l.health)
+	for i := range ret {
+		ret[i].Active = i == l.active
+	}
+	return ret
+}
+
+// HealthCheck returns a function for ProvideHealthCheck that fails while no source of the
+// layer is healthy. A layer running on a fallback source is still healthy.
+func (l *FailoverLayer) HealthCheck() func(context.Context) error {
+	return func(context.Context) error {
+		for _, s := range l.Status() {
+			if s.Healthy {
+				return nil
+			}
+		}
+		return fmt.Errorf("no source of config layer %s is healthy", l.Name)
+	}
+}
+
+// init sets up the health of the sources. l.mu must be held.
+func (l *FailoverLayer) init() {
+	if len(l.health) == len(l.Sources) {
+		return
+	}
+	l.health = make([]LayerSourceStatus, len(l.Sources))
+	for i, s := range l.Sources {
+		l.health[i] = LayerSourceStatus{Name: s.Name(), Healthy: true}
+	}
+	l.active = -1
+}
+
+// fetch returns the content of the layer from the most preferred source that answers.
+func (l *FailoverLayer) fetch(ctx context.Context, opts *options) ([]byte, string, error) {
+	clock := opts.clock
+	if len(l.Sources) == 0 {
+		return nil, "", fmt.Errorf("config layer %s has no sources", l.Name)
+	}
+
+	cache, ttl := l.Cache, l.CacheTTL
+	if cache == nil {
+		cache, ttl = opts.remoteCache, opts.remoteCacheTTL
+	}
+	if ttl <= 0 {
+		ttl = _defaultRemoteCacheTTL
+	}
+	key := "cfx:layer:" + l.Name
+	if cache != nil {
+		data, ok, err := cache.Get(ctx, key)
+		if err != nil {
+			log.Printf("cfx: could not read config layer %s from the cache: %v", l.Name, err)
+		}
+		if ok {
+			sum := sha256.Sum256(data)
+			l.mu.Lock()
+			l.digest = hex.EncodeToString(sum[:])
+			l.mu.Unlock()
+			return data, "cache", nil
+		}
+	}
+
+	timeout := l.Timeout
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+	retryAfter := l.RetryAfter
+	if retryAfter <= 0 {
+		retryAfter = 30 * time.Second
+	}
+
+	// sources in their retry window are skipped, unless every source is
+	l.mu.Lock()
+	l.init()
+	now := clock()
+	var order, parked []int
+	for i, h := range l.health {
+		if !h.Healthy && now.Sub(h.LastFailure) < retryAfter {
+			parked = append(parked, i)
+			continue
+		}
+		order = append(order, i)
+	}
+	l.mu.Unlock()
+	if len(order) == 0 {
+		order = parked
+	}
+
+	var errs []string
+	for _, i := range order {
+		src := l.Sources[i]
+		fctx, cancel := context.WithTimeout(ctx, timeout)
+		data, err := src.FetchLayer(fctx)
+		cancel()
+
+		l.mu.Lock()
+		h := &l.health[i]
+		if err != nil {
+			h.Healthy = false
+			h.Failures++
+			h.LastError = err.Error()
+			h.LastFailure = clock()
+			l.mu.Unlock()
+			errs = append(errs, fmt.Sprintf("%s: %v", src.Name(), err))
+			continue
+		}
+		h.Healthy = true
+		h.Failures = 0
+		h.LastError = ""
+		h.LastSuccess = clock()
+		l.active = i
+		sum := sha256.Sum256(data)
+		l.digest = hex.EncodeToString(sum[:])
+		l.mu.Unlock()
+
+		// the layer is served even when it cannot be cached
+		if l.CacheFile != "" && src.Name() != fileLayer(l.CacheFile).Name() &&
+			checkWriteAllowed(opts, "cache config layer", l.CacheFile) == nil {
+			if err := writeFileLocked(opts.fileLock, l.CacheFile, data, 0600); err != nil {
+				log.Printf("cfx: could not cache config layer %s: %v", l.Name, err)
+			}
+		}
+		if cache != nil {
+			if err := cache.Set(ctx, key, data, ttl); err != nil {
+				log.Printf("cfx: could not cache config layer %s: %v", l.Name, err)
+			}
+		}
+		return data, src.Name(), nil
+	}
+	return nil, "", fmt.Errorf("every source of config layer %s failed: %s", l.Name, strings.Join(errs, "; "))
+}
+
+// signature fetches the layer and summarizes its content for change detection.
+func (l *FailoverLayer) signature(opts *options) string {
+	if _, _, err := l.fetch(context.Background(), opts); err != nil {
+		return "failover:" + l.Name + ":unavailable;"
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return "failover:" + l.Name + ":" + l.digest + ";"
+}
+
+// failoverSources fetches the failover layers of opts.
+func failoverSources(opts *options) ([]configSource, error) {
+	var ret []configSource
+	for _, l := range opts.failoverLayers {
+		if l.Name == "" {
+			return nil, errors.New("config layers need a name")
+		}
+		data, from, err := l.fetch(context.Background(), opts)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, configSource{name: "layer:" + l.Name + "@" + from, data: data})
+	}
+	return ret, nil
+}
@@ -0,0 +1,155 @@
+package cfx
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// scalarKind records which of the typed forms of a scalar are valid.
+type scalarKind uint8
+
+const (
+	scalarString scalarKind = 1 << iota
+	scalarInt
+	scalarFloat
+	scalarBool
+	scalarDuration
+)
+
+// scalar is a leaf of the configuration tree converted to every type it can be read as, so the
+// Fast accessors neither reflect nor allocate.
+type scalar struct {
+	kinds scalarKind
+	s     string
+	i     int64
+	f     float64
+	b     bool
+	d     time.Duration
+}
+
+// flattenScalars returns the scalar leaves of tree keyed by their dotted paths. Lists, maps
+// and values evaluated at read time are left out.
+func flattenScalars(tree map[string]interface{}) map[string]scalar {
+	ret := map[string]scalar{}
+	flattenScalarsInto(ret, "", tree)
+	return ret
+}
+
+func flattenScalarsInto(dst map[string]scalar, prefix string, v interface{}) {
+	if m, ok := v.(map[string]interface{}); ok {
+		if isReadTimeStanza(m) {
+			return
+		}
+		for k, val := range m {
+			key := k
+			if prefix != "" {
+				key = strings.Join([]string{prefix, k}, ".")
+			}
+			flattenScalarsInto(dst, key, val)
+		}
+		return
+	}
+	if prefix == "" {
+		return
+	}
+	if s, ok := newScalar(v); ok {
+		dst[prefix] = s
+	}
+}
+
+func newScalar(v interface{}) (scalar, bool) {
+	var s scalar
+	switch t := v.(type) {
+	case string:
+		s.kinds = scalarString
+		s.s = t
+		if d, err := time.ParseDuration(t); err == nil {
+			s.kinds |= scalarDuration
+			s.d = d
+		}
+	case bool:
+		s.kinds = scalarString | scalarBool
+		s.s = fmt.Sprint(t)
+		s.b = t
+	case int:
+		s = intScalar(int64(t))
+	case int64:
+		s = intScalar(t)
+	case uint64:
+		if t > math.MaxInt64 {
+			s.kinds = scalarString | scalarFloat
+			s.s = fmt.Sprint(t)
+			s.f = float64(t)
+			break
+		}
+		s = intScalar(int64(t))
+	case float64:
+		s.kinds = scalarString | scalarFloat
+		s.s = fmt.Sprint(t)
+		s.f = t
+		if t == math.Trunc(t) && t >= math.MinInt64 && t < math.MaxInt64 {
+			s.kinds |= scalarInt
+			s.i = int64(t)
+		}
+	default:
+		return s, false
+	}
+	return s, true
+}
+
+func intScalar(i int64) scalar {
+	return scalar{
+		kinds: scalarString | scalarInt | scalarFloat | scalarDuration,
+		s:     fmt.Sprint(i),
+		i:     i,
+		f:     float64(i),
+		d:     time.Duration(i),
+	}
+}
+
+// lookupScalar returns the scalar under key if it can be read as kind.
+func (y *yamlContainer) lookupScalar(key string, kind scalarKind) (scalar, bool) {
+	y.RLock()
+	snap := y.snap
+	y.RUnlock()
+	if snap == nil {
+		return scalar{}, false
+	}
+	s, ok := snap.scalars[key]
+	if !ok || s.kinds&kind == 0 {
+		return scalar{}, false
+	}
+	return s, true
+}
+
+// StringFast implements the cfgfx.Container interface.
+func (y *yamlContainer) StringFast(key string) (string, bool) {
+	s, ok := y.lookupScalar(key, scalarString)
+	return s.s, ok
+}
+
+// Int64Fast implements the cfgfx.Container interface.
+func (y *yamlContainer) Int64Fast(key string) (int64, bool) {
+	s, ok := y.lookupScalar(key, scalarInt)
+	return s.i, ok
+}
+
+// Float64Fast implements the cfgfx.Container interface.
+func (y *yamlContainer) Float64Fast(key string) (float64, bool) {
+	s, ok := y.lookupScalar(key, scalarFloat)
+	return s.f, ok
+}
+
+// BoolFast implements the cfgfx.Container interface.
+func (y *yamlContainer) BoolFast(key string) (bool, bool) {
+	s, ok := y.lookupScalar(key, scalarBool)
+	return s.b, ok
+}
+
+// DurationFast implements the cfgfx.Container interface.
+func (y *yamlContainer) DurationFast(key string) (time.Duration, bool) {
+	s, ok := y.lookupScalar(key, scalarDuration)
+	return s.d, ok
+}
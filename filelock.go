@@ -0,0 +1,113 @@
+package cfx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// ErrLockTimeout is returned by LockFile when the lock could not be acquired in time.
+var ErrLockTimeout = errors.New("timed out waiting for file lock")
+
+// FileLockConfig configures the advisory locks taken around files cfx writes, such as the last
+// known good configuration, replay recordings and failover layer caches, so that several
+// instances on one host do not write them at the same time. Locking is on unless Disabled is
+// set. It can be read from configuration, so operators can tune the timeout:
+//
+//	file_lock:
+//	  timeout: 30s
+type FileLockConfig struct {
+	// Disabled turns locking off, for filesystems that do not support it.
+	Disabled bool `json:"disabled,omitempty" yaml:"disabled,omitempty" mapstructure:"disabled,omitempty"`
+
+	// Timeout bounds how long to wait for another process to release the lock, ten seconds
+	// if zero.
+	Timeout time.Duration `json:"timeout,omitempty" yaml:"timeout,omitempty" mapstructure:"timeout,omitempty"`
+
+	// PollInterval is how often the lock is retried while it is held, 50ms if zero.
+	PollInterval time.Duration `json:"poll_interval,omitempty" yaml:"poll_interval,omitempty" mapstructure:"poll_interval,omitempty"`
+}
+
+// WithFileLocking configures the locks taken around the files the Container writes.
+func WithFileLocking(cfg FileLockConfig) Option {
+	return func(o *options) {
+		o.fileLock = cfg
+	}
+}
+
+// FileLock is an exclusive advisory lock held on a lock file. Advisory locks only exclude
+// other processes that take the same lock, and are released when the process exits.
+type FileLock struct {
+	f *os.File
+}
+
+// LockFile takes an exclusive advisory lock on path, creating the file if needed, using
+// flock(2) on Unix and LockFileEx on Windows. It waits for up to cfg.Timeout, or until ctx
+// is done, for another process to release the lock. With cfg.Disabled, it returns a FileLock
+// that locks nothing.
+func LockFile(ctx context.Context, path string, cfg FileLockConfig) (*FileLock, error) {
+	if cfg.Disabled {
+		return &FileLock{}, nil
+	}
+	timeout := cfg.Timeout
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	poll := cfg.PollInterval
+	if poll <= 0 {
+		poll = 50 * time.Millisecond
+	}
+
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("could not open lock file %s: %v", path, err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	for {
+		locked, err := tryLockFile(f)
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("could not lock %s: %v", path, err)
+		}
+		if locked {
+			return &FileLock{f: f}, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			f.Close()
+			if ctx.Err() == context.DeadlineExceeded {
+				return nil, fmt.Errorf("%v %s after %s", ErrLockTimeout, path, timeout)
+			}
+			return nil, ctx.Err()
+		case <-time.After(poll):
+		}
+	}
+}
+
+// Unlock releases the lock.
+func (l *FileLock) Unlock() error {
+	if l == nil || l.f == nil {
+		return nil
+	}
+	err := unlockFile(l.f)
+	if cerr := l.f.Close(); err == nil {
+		err = cerr
+	}
+	l.f = nil
+	return err
+}
+
+// writeFileLocked writes a file with writeFileAtomic while holding the lock on path.lock.
+func writeFileLocked(cfg FileLockConfig, path string, data []byte, perm os.FileMode) error {
+	lock, err := LockFile(context.Background(), path+".lock", cfg)
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+	return writeFileAtomic(path, data, perm)
+}
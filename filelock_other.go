@@ -0,0 +1,15 @@
+//go:build plan9 || js
+// +build plan9 js
+
+package cfx
+
+import "os"
+
+// tryLockFile always succeeds on platforms without advisory locks.
+func tryLockFile(*os.File) (bool, error) {
+	return true, nil
+}
+
+func unlockFile(*os.File) error {
+	return nil
+}
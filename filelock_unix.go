@@ -0,0 +1,21 @@
+//go:build !windows && !plan9 && !js
+// +build !windows,!plan9,!js
+
+package cfx
+
+import (
+	"os"
+	"syscall"
+)
+
+func tryLockFile(f *os.File) (bool, error) {
+	err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if err == syscall.EWOULDBLOCK {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func unlockFile(f *os.File) error {
+	return syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+}
@@ -0,0 +1,43 @@
+//go:build windows
+// +build windows
+
+package cfx
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	_procLockFileEx   = _kernel32.NewProc("LockFileEx")
+	_procUnlockFileEx = _kernel32.NewProc("UnlockFileEx")
+)
+
+const (
+	_lockfileFailImmediately = 0x00000001
+	_lockfileExclusiveLock   = 0x00000002
+	_errorLockViolation      = syscall.Errno(33)
+)
+
+// tryLockFile locks the first byte of f, which is enough for a lock file.
+func tryLockFile(f *os.File) (bool, error) {
+	var ol syscall.Overlapped
+	r, _, err := _procLockFileEx.Call(f.Fd(), _lockfileExclusiveLock|_lockfileFailImmediately, 0, 1, 0, uintptr(unsafe.Pointer(&ol)))
+	if r != 0 {
+		return true, nil
+	}
+	if err == _errorLockViolation {
+		return false, nil
+	}
+	return false, err
+}
+
+func unlockFile(f *os.File) error {
+	var ol syscall.Overlapped
+	r, _, err := _procUnlockFileEx.Call(f.Fd(), 0, 1, 0, uintptr(unsafe.Pointer(&ol)))
+	if r == 0 {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package cfx
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"go.uber.org/config"
+)
+
+// ErrFrozen is returned when a frozen Container is asked to change its configuration.
+var ErrFrozen = errors.New("configuration container is frozen")
+
+// Freeze implements the cfgfx.Container interface.
+func (y *yamlContainer) Freeze() {
+	y.Lock()
+	defer y.Unlock()
+	y.frozen = true
+}
+
+// Frozen implements the cfgfx.Container interface.
+func (y *yamlContainer) Frozen() bool {
+	y.RLock()
+	defer y.RUnlock()
+	return y.frozen
+}
+
+// WipeSecrets implements the cfgfx.Container interface.
+func (y *yamlContainer) WipeSecrets() error {
+	y.Lock()
+	defer y.Unlock()
+	if y.snap == nil {
+		return nil
+	}
+
+	full, err := y.snap.materialized()
+	if err != nil {
+		return err
+	}
+	tree, ok := redactTree("", full, y.opts.redactor).(map[string]interface{})
+	if !ok {
+		tree = map[string]interface{}{}
+	}
+
+	provider, err := config.NewYAML(config.Static(tree))
+	if err != nil {
+		return fmt.Errorf("error constructing redacted yaml configuration: %v", err)
+	}
+
+	// the fingerprint is kept so the container still reports the configuration it loaded
+	wiped := *y.snap
+	wiped.cfg = provider
+	wiped.tree = tree
+	wiped.lazy = nil
+	wiped.scalars = flattenScalars(tree)
+	y.snap = &wiped
+
+	// lazily resolved Secret values are cached process wide
+	_secretCache.wipe()
+
+	return nil
+}
+
+// redactTree returns a copy of v with every leaf passed through redact. Lists are walked too,
+// their elements keyed like "brokers[0].password", so secrets nested in lists are redacted.
+func redactTree(prefix string, v interface{}, redact Redactor) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		if len(t) == 0 && prefix != "" {
+			break
+		}
+		ret := make(map[string]interface{}, len(t))
+		for k, val := range t {
+			ret[k] = redactTree(joinKey(prefix, k), val, redact)
+		}
+		return ret
+	case []interface{}:
+		if len(t) == 0 {
+			break
+		}
+		ret := make([]interface{}, len(t))
+		for i, val := range t {
+			ret[i] = redactTree(prefix+"["+strconv.Itoa(i)+"]", val, redact)
+		}
+		return ret
+	}
+	return redact(prefix, v)
+}
@@ -0,0 +1,215 @@
+package cfx
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"go.uber.org/config"
+	"gopkg.in/yaml.v2"
+)
+
+// Fuzz targets, as accepted by FuzzCorpus.
+const (
+	FuzzTargetParseEnv          = "parse_env"
+	FuzzTargetParseEnvKeyPrefix = "parse_env_key_prefix"
+	FuzzTargetMerge             = "merge"
+	FuzzTargetExpand            = "expand"
+	FuzzTargetPopulate          = "populate"
+)
+
+// _fuzzMergeSeparator separates the base and override documents of a FuzzMerge input.
+const _fuzzMergeSeparator = "\n---\n"
+
+// The Fuzz functions are fuzz targets for the parsers of cfx. They follow the go-fuzz
+// convention of returning 1 for inputs that were accepted and 0 otherwise, and panic when an
+// invariant is violated, so they can be driven by go-fuzz directly or by Go native fuzzing from
+// a test of the calling package:
+//
+//	func FuzzConfig(f *testing.F) {
+//		for _, seed := range cfx.FuzzCorpus(cfx.FuzzTargetPopulate) {
+//			f.Add(seed)
+//		}
+//		f.Fuzz(func(t *testing.T, data []byte) {
+//			cfx.FuzzPopulate(data, &MyConfig{})
+//		})
+//	}
+//
+// Inputs that crashed a target are added to its corpus once fixed, so every run of the corpus
+// replays them.
+
+// FuzzParseEnv fuzzes ParseEnv. Accepted environments must parse to themselves.
+func FuzzParseEnv(data []byte) int {
+	v := string(data)
+	env, err := ParseEnv(v)
+	if err != nil {
+		if env != _nilEnv {
+			panic(fmt.Sprintf("ParseEnv(%q) returned %q with an error", v, env))
+		}
+		return 0
+	}
+	if v != "" && string(env) != v {
+		panic(fmt.Sprintf("ParseEnv(%q) returned %q", v, env))
+	}
+	if again, err := ParseEnv(string(env)); err != nil || again != env {
+		panic(fmt.Sprintf("ParseEnv(%q) is not stable: %q, %v", env, again, err))
+	}
+	return 1
+}
+
+// FuzzParseEnvKeyPrefix fuzzes ParseEnvKeyPrefix. Accepted prefixes must parse to themselves
+// and be usable in environment variable names.
+func FuzzParseEnvKeyPrefix(data []byte) int {
+	v := string(data)
+	prefix, err := ParseEnvKeyPrefix(v)
+	if err != nil {
+		return 0
+	}
+	if v != "" && string(prefix) != v {
+		panic(fmt.Sprintf("ParseEnvKeyPrefix(%q) returned %q", v, prefix))
+	}
+	for _, c := range string(prefix) {
+		if !validEnvKeyPrefixLetter(c) {
+			panic(fmt.Sprintf("ParseEnvKeyPrefix(%q) accepted the invalid character %q", v, c))
+		}
+	}
+	if again, err := ParseEnvKeyPrefix(string(prefix)); err != nil || again != prefix {
+		panic(fmt.Sprintf("ParseEnvKeyPrefix(%q) is not stable: %q, %v", prefix, again, err))
+	}
+	return 1
+}
+
+// FuzzMerge fuzzes the merging of configuration trees. The input holds a base and an override
+// YAML document separated by a "---" line. Every key of the override must be present in the
+// merged tree, merging must not modify its inputs, and merging a tree with itself must leave
+// it unchanged.
+func FuzzMerge(data []byte) int {
+	parts := bytes.SplitN(data, []byte(_fuzzMergeSeparator), 2)
+	if len(parts) != 2 {
+		return 0
+	}
+	var base, override interface{}
+	if yaml.Unmarshal(parts[0], &base) != nil || yaml.Unmarshal(parts[1], &override) != nil {
+		return 0
+	}
+	base, override = normalizeValue(base), normalizeValue(override)
+	baseCopy, overrideCopy := copyTreeValue(base), copyTreeValue(override)
+
+	merged := mergeTrees(base, override)
+	if !reflect.DeepEqual(base, baseCopy) || !reflect.DeepEqual(override, overrideCopy) {
+		panic("merging modified its inputs")
+	}
+	if om, ok := override.(map[string]interface{}); ok {
+		mm, ok := merged.(map[string]interface{})
+		if !ok {
+			panic(fmt.Sprintf("merging a map produced %T", merged))
+		}
+		for k := range om {
+			if _, ok := mm[k]; !ok {
+				panic(fmt.Sprintf("merged tree lost the override key %q", k))
+			}
+		}
+	}
+	if self := mergeTrees(base, base); !reflect.DeepEqual(self, base) {
+		panic("merging a tree with itself changed it")
+	}
+	return 1
+}
+
+// FuzzExpand fuzzes the expansion of ${VAR} references. Every variable, credential and
+// keychain secret expands to "x" without leaving the process, so expansion must succeed
+// exactly when the references can be scanned, and the result must not contain any references.
+func FuzzExpand(data []byte) int {
+	if hasNoExpandDirective(data) {
+		return 0
+	}
+	stub := func(expansion) (string, error) { return "x", nil }
+	e := newExpander(&options{}, func(string) (string, bool) { return "x", true })
+	e.credential, e.keychain = stub, stub
+
+	refs, scanErr := scanExpansions(data)
+	out, err := e.expandSource(configSource{name: "fuzz", data: data})
+	if (scanErr == nil) != (err == nil) {
+		panic(fmt.Sprintf("scanning and expanding disagree: %v, %v", scanErr, err))
+	}
+	if err != nil {
+		return 0
+	}
+	if left, err := scanExpansions(out); err == nil && len(left) > 0 && !bytes.Contains(data, []byte("$$")) {
+		panic(fmt.Sprintf("expanded %d references, but the output still holds %d", len(refs), len(left)))
+	}
+	return 1
+}
+
+// FuzzPopulate fuzzes decoding a YAML document into target, a pointer to a config struct, the
+// way Container.Populate does, including the constraint checks.
+func FuzzPopulate(data []byte, target interface{}) int {
+	rv := reflect.ValueOf(target)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		panic(fmt.Sprintf("FuzzPopulate needs a non nil pointer, got %T", target))
+	}
+	// start from a zero value so every input is decoded independently
+	rv.Elem().Set(reflect.Zero(rv.Elem().Type()))
+
+	provider, err := config.NewYAML(config.Source(bytes.NewReader(data)))
+	if err != nil {
+		return 0
+	}
+	if err := provider.Get(config.Root).Populate(target); err != nil {
+		return 0
+	}
+	if err := CheckConstraints("", target); err != nil {
+		return 0
+	}
+	return 1
+}
+
+// FuzzCorpus returns the seed corpus of a fuzz target.
+func FuzzCorpus(target string) [][]byte {
+	var seeds []string
+	switch target {
+	case FuzzTargetParseEnv:
+		seeds = []string{"", "production", "dev1", "a", "Production", "prod-east", strings.Repeat("a", 65), "dév"}
+	case FuzzTargetParseEnvKeyPrefix:
+		seeds = []string{"", "CFX", "MY_APP", "_APP", "APP_", "A", "app", strings.Repeat("A", 65), "ÉTÉ"}
+	case FuzzTargetMerge:
+		seeds = []string{
+			"a: 1" + _fuzzMergeSeparator + "b: 2",
+			"a: {b: 1, c: [1, 2]}" + _fuzzMergeSeparator + "a: {c: [3], d: null}",
+			"a: 1" + _fuzzMergeSeparator + "a: {b: 1}",
+			"[1, 2]" + _fuzzMergeSeparator + "{a: 1}",
+			"" + _fuzzMergeSeparator + "",
+			"? [1, 2]\n: x" + _fuzzMergeSeparator + "a: 1",
+		}
+	case FuzzTargetExpand:
+		seeds = []string{
+			"a: ${A}",
+			"a: ${A:-default}\nb: ${B:?required}",
+			"a: $${A}",
+			"a: ${A",
+			"a: ${}",
+			"a: ${A-${B}}",
+			"a: ${cred:db}",
+			"# cfx:noexpand\na: ${A}",
+			"a: $",
+		}
+	case FuzzTargetPopulate:
+		seeds = []string{
+			"",
+			"a: 1",
+			"a: [1, 2, 3]",
+			"a: {b: {c: true}}",
+			"a: &x [1]\nb: *x",
+			"a: !!binary aGVsbG8=",
+			"a: 1e400",
+			"a: 2006-01-02T15:04:05Z",
+		}
+	}
+
+	ret := make([][]byte, len(seeds))
+	for i, s := range seeds {
+		ret[i] = []byte(s)
+	}
+	return ret
+}
@@ -0,0 +1,37 @@
+package cfx
+
+import "testing"
+
+// TestFuzzCorpus replays the seed corpus of every fuzz target, including the inputs that
+// crashed them once, on toolchains without native fuzzing.
+func TestFuzzCorpus(t *testing.T) {
+	targets := map[string]func([]byte) int{
+		FuzzTargetParseEnv:          FuzzParseEnv,
+		FuzzTargetParseEnvKeyPrefix: FuzzParseEnvKeyPrefix,
+		FuzzTargetMerge:             FuzzMerge,
+		FuzzTargetExpand:            FuzzExpand,
+		FuzzTargetPopulate: func(data []byte) int {
+			var v map[string]interface{}
+			return FuzzPopulate(data, &v)
+		},
+	}
+	for name, fn := range targets {
+		seeds := FuzzCorpus(name)
+		if len(seeds) == 0 {
+			t.Errorf("%s has no seed corpus", name)
+		}
+		for _, seed := range seeds {
+			fn(seed)
+		}
+	}
+}
+
+// TestFuzzExpandStaysLocal checks that credential and keychain references are expanded by
+// stubs, rather than by the resolvers of the machine running the fuzzer.
+func TestFuzzExpandStaysLocal(t *testing.T) {
+	for _, in := range []string{"a: ${cred:db}", "a: ${keychain:svc/acct}", "a: ${cred:db:-x}\nb: ${A}"} {
+		if FuzzExpand([]byte(in)) != 1 {
+			t.Errorf("FuzzExpand(%q) rejected the input", in)
+		}
+	}
+}
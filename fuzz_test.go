@@ -0,0 +1,53 @@
+//go:build go1.18
+// +build go1.18
+
+package cfx
+
+import "testing"
+
+// The native fuzz targets wrap the exported ones. Their seed corpora, and the crashers kept in
+// testdata/fuzz, run as regular tests.
+
+func addCorpus(f *testing.F, target string) {
+	for _, seed := range FuzzCorpus(target) {
+		f.Add(seed)
+	}
+}
+
+func FuzzParseEnvNative(f *testing.F) {
+	addCorpus(f, FuzzTargetParseEnv)
+	f.Fuzz(func(t *testing.T, data []byte) { FuzzParseEnv(data) })
+}
+
+func FuzzParseEnvKeyPrefixNative(f *testing.F) {
+	addCorpus(f, FuzzTargetParseEnvKeyPrefix)
+	f.Fuzz(func(t *testing.T, data []byte) { FuzzParseEnvKeyPrefix(data) })
+}
+
+func FuzzMergeNative(f *testing.F) {
+	addCorpus(f, FuzzTargetMerge)
+	f.Fuzz(func(t *testing.T, data []byte) { FuzzMerge(data) })
+}
+
+func FuzzExpandNative(f *testing.F) {
+	addCorpus(f, FuzzTargetExpand)
+	f.Fuzz(func(t *testing.T, data []byte) { FuzzExpand(data) })
+}
+
+// fuzzConfig exercises nested structs, slices, maps and constraints in FuzzPopulate.
+type fuzzConfig struct {
+	Name    string            `yaml:"name"`
+	Port    int               `yaml:"port"`
+	Timeout string            `yaml:"timeout"`
+	Brokers []string          `yaml:"brokers" cfx:"maxItems=8,uniqueItems"`
+	Labels  map[string]string `yaml:"labels"`
+	TLS     struct {
+		Enabled bool   `yaml:"enabled"`
+		CAFile  string `yaml:"ca_file"`
+	} `yaml:"tls"`
+}
+
+func FuzzPopulateNative(f *testing.F) {
+	addCorpus(f, FuzzTargetPopulate)
+	f.Fuzz(func(t *testing.T, data []byte) { FuzzPopulate(data, &fuzzConfig{}) })
+}
@@ -0,0 +1,95 @@
+//go:build go1.21
+// +build go1.21
+
+package cfx
+
+import "fmt"
+
+// ValueOption customizes how Value reads a key.
+type ValueOption[T any] func(*valueOptions[T])
+
+type valueOptions[T any] struct {
+	def        T
+	required   bool
+	validators []func(T) error
+}
+
+// DefaultValue is used when the key is not set.
+func DefaultValue[T any](v T) ValueOption[T] {
+	return func(o *valueOptions[T]) {
+		o.def = v
+	}
+}
+
+// RequiredValue makes Value fail when the key is not set.
+func RequiredValue[T any]() ValueOption[T] {
+	return func(o *valueOptions[T]) {
+		o.required = true
+	}
+}
+
+// ValidateValue adds a check that the value must pass.
+func ValidateValue[T any](fn func(T) error) ValueOption[T] {
+	return func(o *valueOptions[T]) {
+		if fn != nil {
+			o.validators = append(o.validators, fn)
+		}
+	}
+}
+
+// Value reads the value under key as a T. Keys that are not set produce the default given with
+// DefaultValue, or the zero value. Values implementing Validator, directly or through a pointer,
+// are validated, followed by every ValidateValue check.
+//
+//	port, err := cfx.Value[int](c, "server.port", cfx.DefaultValue(8080))
+func Value[T any](c Container, key string, opts ...ValueOption[T]) (T, error) {
+	o := &valueOptions[T]{}
+	for _, opt := range opts {
+		if opt != nil {
+			opt(o)
+		}
+	}
+
+	var zero T
+	if o.required {
+		var raw interface{}
+		if err := c.Populate(key, &raw); err != nil {
+			return zero, err
+		}
+		if raw == nil {
+			return zero, fmt.Errorf("config key %s is required but not set", key)
+		}
+	}
+
+	v := o.def
+	if err := c.Populate(key, &v); err != nil {
+		return zero, fmt.Errorf("could not read config key %s: %v", key, err)
+	}
+
+	if val, ok := any(&v).(Validator); ok {
+		if err := val.Validate(); err != nil {
+			return zero, fmt.Errorf("config key %s is invalid: %v", key, err)
+		}
+	} else if val, ok := any(v).(Validator); ok {
+		if err := val.Validate(); err != nil {
+			return zero, fmt.Errorf("config key %s is invalid: %v", key, err)
+		}
+	}
+	for _, fn := range o.validators {
+		if err := fn(v); err != nil {
+			return zero, fmt.Errorf("config key %s is invalid: %v", key, err)
+		}
+	}
+
+	return v, nil
+}
+
+// MustValue is like Value but panics if the value cannot be read. It is intended for
+// package initialization and tests.
+func MustValue[T any](c Container, key string, opts ...ValueOption[T]) T {
+	v, err := Value(c, key, opts...)
+	if err != nil {
+		panic(err)
+	}
+	return v
+}
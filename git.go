@@ -0,0 +1,306 @@
+package cfx
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// _defaultGitTimeout bounds every git command run for a GitSource without a Timeout.
+const _defaultGitTimeout = 2 * time.Minute
+
+// GitSource describes a git repository that configuration is loaded from with WithGitSource.
+type GitSource struct {
+	// Repository is the URL of the repository, or the path of a local checkout.
+	Repository string `json:"repository" yaml:"repository"`
+
+	// Ref is the branch, tag or commit SHA to load, HEAD if empty. Pinning a full commit SHA makes
+	// the configuration immutable; a branch is followed as it moves.
+	Ref string `json:"ref,omitempty" yaml:"ref,omitempty"`
+
+	// Path is the directory within the repository holding the configuration files, the root of
+	// the repository if empty.
+	Path string `json:"path,omitempty" yaml:"path,omitempty"`
+
+	// CacheDir is where remote repositories are cloned, a "cfx/git" directory in the user's
+	// cache directory if empty. Local checkouts are read in place.
+	CacheDir string `json:"cache_dir,omitempty" yaml:"cache_dir,omitempty"`
+
+	// Timeout bounds every git command, two minutes if zero.
+	Timeout time.Duration `json:"timeout,omitempty" yaml:"timeout,omitempty"`
+}
+
+// ReportGit describes the git commit configuration was loaded from in a LoadReport.
+type ReportGit struct {
+	Repository string `json:"repository" yaml:"repository"`
+	Ref        string `json:"ref,omitempty" yaml:"ref,omitempty"`
+	Path       string `json:"path,omitempty" yaml:"path,omitempty"`
+
+	// Commit is the full SHA of the commit the configuration was read from.
+	Commit string `json:"commit" yaml:"commit"`
+}
+
+// WithGitSource loads configuration from a directory of a git repository at a pinned ref
+// instead of ConfigPath, for GitOps style configuration without a separate sync agent:
+//
+//	cfx.WithGitSource(cfx.GitSource{
+//		Repository: "https://github.com/acme/config.git",
+//		Ref:        "v1.4.2",
+//		Path:       "services/billing",
+//	})
+//
+// The directory is laid out like ConfigPath: base.yaml, the environment files and an optional
+// environments manifest. Files are read from git objects rather than a working tree, so a local
+// checkout with uncommitted changes loads its committed state. Remote repositories are cloned
+// into CacheDir on first use and fetched on every load; the commit that was loaded is recorded
+// in the LoadReport. Hot reload polls the ref and reloads when it points to a new commit.
+//
+// cfx runs the git binary found on PATH, so credentials come from the usual git configuration,
+// such as credential helpers and SSH keys. Git is never allowed to prompt for them.
+func WithGitSource(src GitSource) Option {
+	return func(o *options) {
+		o.gitSource = &src
+	}
+}
+
+func (g *GitSource) ref() string {
+	if g.Ref == "" {
+		return "HEAD"
+	}
+	return g.Ref
+}
+
+// pinned reports whether Ref is a full commit SHA, which never needs to be fetched again once
+// it is available locally.
+func (g *GitSource) pinned() bool {
+	if len(g.Ref) != 40 {
+		return false
+	}
+	_, err := hex.DecodeString(g.Ref)
+	return err == nil
+}
+
+// local reports whether Repository is a local checkout or bare repository.
+func (g *GitSource) local() bool {
+	if strings.Contains(g.Repository, "://") {
+		return false
+	}
+	st, err := os.Stat(g.Repository)
+	return err == nil && st.IsDir()
+}
+
+// git runs a git command in dir and returns its standard output.
+func (g *GitSource) git(dir string, args ...string) ([]byte, error) {
+	timeout := g.Timeout
+	if timeout <= 0 {
+		timeout = _defaultGitTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if dir != "" {
+		args = append([]string{"-C", dir}, args...)
+	}
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		command := strings.Replace(strings.Join(args, " "), g.Repository, redactLocation(g.Repository), -1)
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("git %s: %v: %s", command, err, msg)
+		}
+		return nil, fmt.Errorf("git %s: %v", command, err)
+	}
+	return stdout.Bytes(), nil
+}
+
+// repoDir returns the repository to read objects from, cloning remote repositories into the
+// cache directory first.
+func (g *GitSource) repoDir() (string, error) {
+	if g.local() {
+		return g.Repository, nil
+	}
+	if err := checkNetworkAllowed("git repository " + redactLocation(g.Repository)); err != nil {
+		return "", err
+	}
+
+	cache := g.CacheDir
+	if cache == "" {
+		dir, err := os.UserCacheDir()
+		if err != nil {
+			dir = os.TempDir()
+		}
+		cache = filepath.Join(dir, "cfx", "git")
+	}
+	sum := sha256.Sum256([]byte(g.Repository))
+	dir := filepath.Join(cache, hex.EncodeToString(sum[:8])+".git")
+	if _, err := os.Stat(dir); err == nil {
+		return dir, nil
+	}
+
+	if err := os.MkdirAll(cache, 0700); err != nil {
+		return "", fmt.Errorf("could not create git cache directory: %v", err)
+	}
+	// clone next to the final location so that an interrupted clone is never used
+	tmp, err := ioutil.TempDir(cache, ".clone-")
+	if err != nil {
+		return "", fmt.Errorf("could not create git cache directory: %v", err)
+	}
+	defer os.RemoveAll(tmp)
+	if _, err := g.git("", "clone", "--bare", "--quiet", "--", g.Repository, tmp); err != nil {
+		return "", fmt.Errorf("could not clone %s: %v", redactLocation(g.Repository), err)
+	}
+	if err := os.Rename(tmp, dir); err != nil && !os.IsExist(err) {
+		if _, serr := os.Stat(dir); serr != nil {
+			return "", fmt.Errorf("could not populate git cache: %v", err)
+		}
+	}
+	return dir, nil
+}
+
+// resolve returns the commit Ref points to in dir, fetching it from remote repositories unless
+// a pinned commit is already available.
+func (g *GitSource) resolve(dir string) (string, error) {
+	if g.pinned() {
+		if _, err := g.git(dir, "cat-file", "-e", g.Ref+"^{commit}"); err == nil {
+			return strings.ToLower(g.Ref), nil
+		}
+	}
+
+	rev := g.ref()
+	if !g.local() {
+		if _, err := g.git(dir, "fetch", "--quiet", "--force", "--", g.Repository, rev); err != nil {
+			return "", fmt.Errorf("could not fetch %s from %s: %v", rev, redactLocation(g.Repository), err)
+		}
+		rev = "FETCH_HEAD"
+	}
+
+	out, err := g.git(dir, "rev-parse", "--verify", "--quiet", rev+"^{commit}")
+	if err != nil {
+		return "", fmt.Errorf("could not resolve %s in %s: %v", g.ref(), redactLocation(g.Repository), err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// head returns the commit Ref currently points to without fetching it, for change detection.
+func (g *GitSource) head() (string, error) {
+	if g.pinned() {
+		return strings.ToLower(g.Ref), nil
+	}
+	if g.local() {
+		out, err := g.git(g.Repository, "rev-parse", "--verify", "--quiet", g.ref()+"^{commit}")
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimSpace(string(out)), nil
+	}
+	if err := checkNetworkAllowed("git repository " + redactLocation(g.Repository)); err != nil {
+		return "", err
+	}
+	out, err := g.git("", "ls-remote", "--", g.Repository, g.ref())
+	if err != nil {
+		return "", err
+	}
+	fields := strings.Fields(string(out))
+	if len(fields) == 0 {
+		return "", fmt.Errorf("ref %s not found", g.ref())
+	}
+	return fields[0], nil
+}
+
+// load reads the configuration files in Path at the commit Ref resolves to.
+func (g *GitSource) load() (*Bundle, *ReportGit, error) {
+	dir, err := g.repoDir()
+	if err != nil {
+		return nil, nil, err
+	}
+	commit, err := g.resolve(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	treeish := commit
+	if p := strings.Trim(path.Clean("/"+filepath.ToSlash(g.Path)), "/"); p != "" {
+		treeish += ":" + p
+	}
+	out, err := g.git(dir, "ls-tree", "-r", "-z", treeish)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not list %s in %s: %v", treeish, redactLocation(g.Repository), err)
+	}
+
+	b := &Bundle{
+		path:   redactLocation(g.Repository) + "@" + commit[:12],
+		digest: commit,
+		files:  map[string][]byte{},
+	}
+	for _, entry := range bytes.Split(out, []byte{0}) {
+		// <mode> SP <type> SP <object> TAB <file>
+		tab := bytes.IndexByte(entry, '\t')
+		if tab < 0 {
+			continue
+		}
+		meta := strings.Fields(string(entry[:tab]))
+		if len(meta) != 3 || meta[1] != "blob" || meta[0] == "120000" {
+			continue
+		}
+		name := string(entry[tab+1:])
+		if !isConfigFile(name) {
+			continue
+		}
+		data, err := g.git(dir, "cat-file", "blob", meta[2])
+		if err != nil {
+			return nil, nil, fmt.Errorf("could not read %s at %s: %v", name, commit, err)
+		}
+		if err := b.add(name, bytes.NewReader(data)); err != nil {
+			return nil, nil, err
+		}
+	}
+	if len(b.files) == 0 {
+		return nil, nil, fmt.Errorf("%s does not contain any yaml files at %s", redactLocation(g.Repository), treeish)
+	}
+
+	return b, &ReportGit{
+		Repository: redactLocation(g.Repository),
+		Ref:        g.ref(),
+		Path:       g.Path,
+		Commit:     commit,
+	}, nil
+}
+
+// loadGit builds a snapshot from the repository configured with WithGitSource.
+func loadGit(env EnvContext, opts *options) (*snapshot, error) {
+	pre := &stageTimings{}
+	start := opts.clock()
+	b, report, err := opts.gitSource.load()
+	if err != nil {
+		return nil, err
+	}
+	sources, err := b.sources(env, opts)
+	if err != nil {
+		return nil, err
+	}
+	extra, err := pluginSources(env, opts)
+	if err != nil {
+		return nil, err
+	}
+	pre.record(opts, StageRead, start)
+
+	snap, err := buildSnapshot(env, opts, append(sources, extra...))
+	if err != nil {
+		return nil, err
+	}
+	snap.stages.prepend(pre.list())
+	snap.git = report
+	return snap, nil
+}
@@ -7,4 +7,5 @@ require (
 	go.uber.org/config v1.4.0
 	go.uber.org/fx v1.10.0
 	golang.org/x/sys v0.0.0-20200223170610-d5e6a3e2c0ae // indirect
+	gopkg.in/yaml.v2 v2.2.5
 )
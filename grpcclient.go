@@ -0,0 +1,186 @@
+package cfx
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/fx"
+)
+
+// GRPCClientConfig is the canonical configuration section for outbound gRPC clients.
+// cfx does not depend on gRPC itself; the fields map directly onto grpc.DialOption values,
+// which the cfxgrpc module builds, and ServiceConfigJSON can be passed to
+// grpc.WithDefaultServiceConfig.
+type GRPCClientConfig struct {
+	// Target is the dial target, e.g. "dns:///payments.internal:443".
+	Target string `json:"target,omitempty" yaml:"target,omitempty" mapstructure:"target,omitempty"`
+
+	// Authority overrides the :authority header sent to the server.
+	Authority string `json:"authority,omitempty" yaml:"authority,omitempty" mapstructure:"authority,omitempty"`
+
+	// DialTimeout limits how long a blocking dial may take.
+	DialTimeout time.Duration `json:"dial_timeout,omitempty" yaml:"dial_timeout,omitempty" mapstructure:"dial_timeout,omitempty"`
+
+	// CallTimeout is the default deadline applied to each call.
+	CallTimeout time.Duration `json:"call_timeout,omitempty" yaml:"call_timeout,omitempty" mapstructure:"call_timeout,omitempty"`
+
+	// MaxRecvMsgSize is the maximum message size in bytes the client can receive.
+	MaxRecvMsgSize int `json:"max_recv_msg_size,omitempty" yaml:"max_recv_msg_size,omitempty" mapstructure:"max_recv_msg_size,omitempty"`
+
+	// MaxSendMsgSize is the maximum message size in bytes the client can send.
+	MaxSendMsgSize int `json:"max_send_msg_size,omitempty" yaml:"max_send_msg_size,omitempty" mapstructure:"max_send_msg_size,omitempty"`
+
+	// Keepalive configures client side keepalive pings.
+	Keepalive GRPCKeepaliveConfig `json:"keepalive,omitempty" yaml:"keepalive,omitempty" mapstructure:"keepalive,omitempty"`
+
+	// TLS configures transport security. If it is not enabled the connection is insecure.
+	TLS TLSConfig `json:"tls,omitempty" yaml:"tls,omitempty" mapstructure:"tls,omitempty"`
+
+	// Retries configures the retry policy placed in the default service config.
+	Retries GRPCRetryConfig `json:"retries,omitempty" yaml:"retries,omitempty" mapstructure:"retries,omitempty"`
+}
+
+// GRPCKeepaliveConfig mirrors grpc/keepalive.ClientParameters.
+type GRPCKeepaliveConfig struct {
+	// Time is how long the connection may be idle before a ping is sent.
+	Time time.Duration `json:"time,omitempty" yaml:"time,omitempty" mapstructure:"time,omitempty"`
+
+	// Timeout is how long to wait for a ping acknowledgement before closing the connection.
+	Timeout time.Duration `json:"timeout,omitempty" yaml:"timeout,omitempty" mapstructure:"timeout,omitempty"`
+
+	// PermitWithoutStream allows pings to be sent when there are no active streams.
+	PermitWithoutStream bool `json:"permit_without_stream,omitempty" yaml:"permit_without_stream,omitempty" mapstructure:"permit_without_stream,omitempty"`
+}
+
+// GRPCRetryConfig mirrors the retryPolicy block of a gRPC service config.
+type GRPCRetryConfig struct {
+	// MaxAttempts is the total number of attempts, including the first. Values below 2 disable retries.
+	MaxAttempts int `json:"max_attempts,omitempty" yaml:"max_attempts,omitempty" mapstructure:"max_attempts,omitempty"`
+
+	// InitialBackoff is the delay before the first retry.
+	InitialBackoff time.Duration `json:"initial_backoff,omitempty" yaml:"initial_backoff,omitempty" mapstructure:"initial_backoff,omitempty"`
+
+	// MaxBackoff caps the delay between attempts.
+	MaxBackoff time.Duration `json:"max_backoff,omitempty" yaml:"max_backoff,omitempty" mapstructure:"max_backoff,omitempty"`
+
+	// BackoffMultiplier is applied to the backoff after every attempt.
+	BackoffMultiplier float64 `json:"backoff_multiplier,omitempty" yaml:"backoff_multiplier,omitempty" mapstructure:"backoff_multiplier,omitempty"`
+
+	// RetryableStatusCodes lists the gRPC status codes that should be retried, e.g. "UNAVAILABLE".
+	RetryableStatusCodes []string `json:"retryable_status_codes,omitempty" yaml:"retryable_status_codes,omitempty" mapstructure:"retryable_status_codes,omitempty"`
+}
+
+// DefaultGRPCClientConfig returns the defaults that are applied before a section is populated.
+func DefaultGRPCClientConfig() GRPCClientConfig {
+	return GRPCClientConfig{
+		DialTimeout: 10 * time.Second,
+		CallTimeout: 30 * time.Second,
+		Keepalive: GRPCKeepaliveConfig{
+			Time:    30 * time.Second,
+			Timeout: 10 * time.Second,
+		},
+		Retries: GRPCRetryConfig{
+			MaxAttempts:          1,
+			InitialBackoff:       100 * time.Millisecond,
+			MaxBackoff:           2 * time.Second,
+			BackoffMultiplier:    2,
+			RetryableStatusCodes: []string{"UNAVAILABLE"},
+		},
+	}
+}
+
+// Validate checks the client configuration for invalid values.
+func (g GRPCClientConfig) Validate() error {
+	if g.Target == "" {
+		return errors.New("grpc client target must be set")
+	}
+	if g.DialTimeout < 0 || g.CallTimeout < 0 || g.Keepalive.Time < 0 || g.Keepalive.Timeout < 0 {
+		return errors.New("grpc client timeouts must not be negative")
+	}
+	if g.MaxRecvMsgSize < 0 || g.MaxSendMsgSize < 0 {
+		return errors.New("grpc client message sizes must not be negative")
+	}
+	if g.Retries.MaxAttempts > 1 {
+		if g.Retries.InitialBackoff <= 0 || g.Retries.MaxBackoff <= 0 {
+			return errors.New("grpc client retry backoffs must be positive when retries are enabled")
+		}
+		if g.Retries.BackoffMultiplier <= 0 {
+			return errors.New("grpc client retries.backoff_multiplier must be positive")
+		}
+		if len(g.Retries.RetryableStatusCodes) == 0 {
+			return errors.New("grpc client retries.retryable_status_codes must not be empty")
+		}
+	}
+	return g.TLS.Validate()
+}
+
+// ServiceConfigJSON renders a gRPC service config containing the retry policy, suitable for
+// grpc.WithDefaultServiceConfig. It returns an empty string when retries are disabled.
+func (g GRPCClientConfig) ServiceConfigJSON() (string, error) {
+	if g.Retries.MaxAttempts < 2 {
+		return "", nil
+	}
+
+	codes := make([]string, len(g.Retries.RetryableStatusCodes))
+	for i, c := range g.Retries.RetryableStatusCodes {
+		codes[i] = strings.ToUpper(c)
+	}
+
+	sc := map[string]interface{}{
+		"methodConfig": []interface{}{
+			map[string]interface{}{
+				"name": []interface{}{map[string]interface{}{}},
+				"retryPolicy": map[string]interface{}{
+					"maxAttempts":          g.Retries.MaxAttempts,
+					"initialBackoff":       protoDuration(g.Retries.InitialBackoff),
+					"maxBackoff":           protoDuration(g.Retries.MaxBackoff),
+					"backoffMultiplier":    g.Retries.BackoffMultiplier,
+					"retryableStatusCodes": codes,
+				},
+			},
+		},
+	}
+
+	data, err := json.Marshal(sc)
+	if err != nil {
+		return "", fmt.Errorf("could not encode grpc service config: %v", err)
+	}
+
+	return string(data), nil
+}
+
+// protoDuration formats a duration the way protobuf JSON encodes google.protobuf.Duration,
+// which does not accept exponents.
+func protoDuration(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'f', -1, 64) + "s"
+}
+
+// ProvideGRPCClientConfig returns an Fx option that provides a validated *GRPCClientConfig named
+// after the config section at key. Consumers can depend on it with an fx.In field tagged `name:"<key>"`.
+func ProvideGRPCClientConfig(key string) fx.Option {
+	return fx.Provide(fx.Annotated{
+		Name: key,
+		Target: func(c Container) (*GRPCClientConfig, error) {
+			return LoadGRPCClientConfig(c, key)
+		},
+	})
+}
+
+// LoadGRPCClientConfig populates the gRPC client config section at key over the defaults and
+// validates it.
+func LoadGRPCClientConfig(c Container, key string) (*GRPCClientConfig, error) {
+	cfg := DefaultGRPCClientConfig()
+	if err := c.Populate(key, &cfg); err != nil {
+		return nil, fmt.Errorf("could not populate grpc client config %s: %v", key, err)
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid grpc client config %s: %v", key, err)
+	}
+
+	return &cfg, nil
+}
@@ -0,0 +1,39 @@
+package cfx
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProtoDuration(t *testing.T) {
+	tests := []struct {
+		d    time.Duration
+		want string
+	}{
+		{0, "0s"},
+		{100 * time.Millisecond, "0.1s"},
+		{1500 * time.Millisecond, "1.5s"},
+		{2 * time.Second, "2s"},
+		{100 * time.Nanosecond, "0.0000001s"},
+		{1e9 * time.Second, "1000000000s"},
+	}
+	for _, tt := range tests {
+		if got := protoDuration(tt.d); got != tt.want {
+			t.Errorf("protoDuration(%v) = %q, want %q", tt.d, got, tt.want)
+		}
+	}
+}
+
+func TestServiceConfigJSON(t *testing.T) {
+	cfg := DefaultGRPCClientConfig()
+	cfg.Retries.MaxAttempts = 3
+	cfg.Retries.InitialBackoff = time.Microsecond
+	sc, err := cfg.ServiceConfigJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(sc, `"initialBackoff":"0.000001s"`) {
+		t.Errorf("service config %s does not hold the initial backoff in protobuf JSON form", sc)
+	}
+}
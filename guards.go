@@ -0,0 +1,327 @@
+package cfx
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"unicode"
+
+	"gopkg.in/yaml.v2"
+)
+
+// WhenKey is the YAML key that guards a block with a condition on the EnvContext. Blocks whose
+// condition is false are dropped from their file before it is merged; a guard at the top of a
+// file applies to the whole file:
+//
+//	database:
+//	  replicas:
+//	    when: environment == "production" && region == "us-east-1"
+//	    count: 3
+//
+// Conditions compare identifiers with string literals using == and !=, and combine them with
+// &&, || and !, grouped with parentheses. The identifiers are environment, app_id, service_id,
+// instance_id, region, zone, network_id, datacenter_id, hostname, os, arch and var.NAME, the
+// value of a variable declared with cfx.DefineVar.
+// Only string values of a when key are treated as guards.
+const WhenKey = "when"
+
+// applyGuards evaluates the when guards in src and returns the source without the blocks whose
+// guards are false. Sources without guards are returned unchanged.
+func applyGuards(env EnvContext, src configSource) (configSource, error) {
+	if !bytes.Contains(src.data, []byte(WhenKey+":")) {
+		return src, nil
+	}
+
+	var raw interface{}
+	if err := yaml.Unmarshal(src.data, &raw); err != nil {
+		// leave reporting malformed YAML to the config provider
+		return src, nil
+	}
+
+	tree, ok := normalizeValue(raw).(map[string]interface{})
+	if !ok {
+		return src, nil
+	}
+
+	keep, changed, err := guardMap(env, "", tree)
+	if err != nil {
+		return src, fmt.Errorf("could not evaluate guards in %s: %v", src.name, err)
+	}
+	if !changed {
+		return src, nil
+	}
+	if !keep {
+		return configSource{name: src.name}, nil
+	}
+
+	data, err := yaml.Marshal(tree)
+	if err != nil {
+		return src, fmt.Errorf("could not encode %s after evaluating guards: %v", src.name, err)
+	}
+	return configSource{name: src.name, data: data}, nil
+}
+
+// guardMap evaluates the guard of m and of its children, removing children whose guards are
+// false. It reports whether m itself should be kept and whether anything changed.
+func guardMap(env EnvContext, key string, m map[string]interface{}) (bool, bool, error) {
+	changed := false
+	if cond, ok := m[WhenKey].(string); ok {
+		keep, err := EvalCondition(env, cond)
+		if err != nil {
+			return false, false, fmt.Errorf("%s: %v", joinKey(key, WhenKey), err)
+		}
+		if !keep {
+			return false, true, nil
+		}
+		delete(m, WhenKey)
+		changed = true
+	}
+
+	for k, v := range m {
+		val, keep, sub, err := guardValue(env, joinKey(key, k), v)
+		if err != nil {
+			return false, false, err
+		}
+		if !keep {
+			delete(m, k)
+		} else if sub {
+			m[k] = val
+		}
+		changed = changed || sub
+	}
+
+	return true, changed, nil
+}
+
+// guardValue evaluates the guards within v, returning the value to keep in its place.
+func guardValue(env EnvContext, key string, v interface{}) (interface{}, bool, bool, error) {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		keep, changed, err := guardMap(env, key, t)
+		return t, keep, changed, err
+	case []interface{}:
+		changed := false
+		kept := make([]interface{}, 0, len(t))
+		for i, item := range t {
+			val, keep, sub, err := guardValue(env, fmt.Sprintf("%s[%d]", key, i), item)
+			if err != nil {
+				return nil, false, false, err
+			}
+			changed = changed || sub
+			if keep {
+				kept = append(kept, val)
+			}
+		}
+		return kept, true, changed, nil
+	}
+	return v, true, false, nil
+}
+
+// EvalCondition evaluates a when guard condition against env.
+func EvalCondition(env EnvContext, cond string) (bool, error) {
+	toks, err := tokenizeCondition(cond)
+	if err != nil {
+		return false, err
+	}
+	p := &condParser{env: env, tokens: toks}
+
+	ret, err := p.parseOr()
+	if err != nil {
+		return false, err
+	}
+	if p.pos != len(p.tokens) {
+		return false, fmt.Errorf("unexpected %q in condition %q", p.tokens[p.pos].text, cond)
+	}
+	return ret, nil
+}
+
+type condTokenKind int
+
+const (
+	condIdent condTokenKind = iota
+	condString
+	condOp
+)
+
+type condToken struct {
+	kind condTokenKind
+	text string
+}
+
+func tokenizeCondition(s string) ([]condToken, error) {
+	var toks []condToken
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t':
+			i++
+		case c == '"' || c == '\'':
+			j := strings.IndexByte(s[i+1:], c)
+			if j < 0 {
+				return nil, fmt.Errorf("unterminated string in condition %q", s)
+			}
+			toks = append(toks, condToken{kind: condString, text: s[i+1 : i+1+j]})
+			i += j + 2
+		case strings.HasPrefix(s[i:], "==") || strings.HasPrefix(s[i:], "!=") ||
+			strings.HasPrefix(s[i:], "&&") || strings.HasPrefix(s[i:], "||"):
+			toks = append(toks, condToken{kind: condOp, text: s[i : i+2]})
+			i += 2
+		case c == '!' || c == '(' || c == ')':
+			toks = append(toks, condToken{kind: condOp, text: string(c)})
+			i++
+		case c == '_' || c == '.' || unicode.IsLetter(rune(c)) || unicode.IsDigit(rune(c)):
+			j := i
+			for j < len(s) && (s[j] == '_' || s[j] == '.' || s[j] == '-' || unicode.IsLetter(rune(s[j])) || unicode.IsDigit(rune(s[j]))) {
+				j++
+			}
+			toks = append(toks, condToken{kind: condIdent, text: s[i:j]})
+			i = j
+		default:
+			return nil, fmt.Errorf("unexpected character %q in condition %q", c, s)
+		}
+	}
+	return toks, nil
+}
+
+type condParser struct {
+	env    EnvContext
+	tokens []condToken
+	pos    int
+}
+
+func (p *condParser) peek(op string) bool {
+	return p.pos < len(p.tokens) && p.tokens[p.pos].kind == condOp && p.tokens[p.pos].text == op
+}
+
+func (p *condParser) parseOr() (bool, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return false, err
+	}
+	for p.peek("||") {
+		p.pos++
+		right, err := p.parseAnd()
+		if err != nil {
+			return false, err
+		}
+		left = left || right
+	}
+	return left, nil
+}
+
+func (p *condParser) parseAnd() (bool, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return false, err
+	}
+	for p.peek("&&") {
+		p.pos++
+		right, err := p.parseUnary()
+		if err != nil {
+			return false, err
+		}
+		left = left && right
+	}
+	return left, nil
+}
+
+func (p *condParser) parseUnary() (bool, error) {
+	if p.peek("!") {
+		p.pos++
+		v, err := p.parseUnary()
+		return !v, err
+	}
+	if p.peek("(") {
+		p.pos++
+		v, err := p.parseOr()
+		if err != nil {
+			return false, err
+		}
+		if !p.peek(")") {
+			return false, fmt.Errorf("missing closing parenthesis")
+		}
+		p.pos++
+		return v, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *condParser) parseComparison() (bool, error) {
+	start := p.pos
+	left, err := p.parseOperand()
+	if err != nil {
+		return false, err
+	}
+
+	if p.peek("==") || p.peek("!=") {
+		op := p.tokens[p.pos].text
+		p.pos++
+		right, err := p.parseOperand()
+		if err != nil {
+			return false, err
+		}
+		if op == "==" {
+			return left == right, nil
+		}
+		return left != right, nil
+	}
+
+	switch left {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	}
+	return false, fmt.Errorf("expected a comparison after %q", p.tokens[start].text)
+}
+
+func (p *condParser) parseOperand() (string, error) {
+	if p.pos >= len(p.tokens) {
+		return "", fmt.Errorf("unexpected end of condition")
+	}
+	tok := p.tokens[p.pos]
+	p.pos++
+
+	switch tok.kind {
+	case condString:
+		return tok.text, nil
+	case condIdent:
+		if tok.text == "true" || tok.text == "false" {
+			return tok.text, nil
+		}
+		return conditionIdent(p.env, tok.text)
+	}
+	return "", fmt.Errorf("unexpected %q", tok.text)
+}
+
+func conditionIdent(env EnvContext, name string) (string, error) {
+	if strings.HasPrefix(name, "var.") {
+		return env.Vars.Get(EnvVar(strings.TrimPrefix(name, "var."))), nil
+	}
+
+	switch name {
+	case "environment":
+		return env.Environment.String(), nil
+	case "app_id":
+		return env.Deployment.AppID, nil
+	case "service_id":
+		return env.Deployment.ServiceID, nil
+	case "instance_id":
+		return env.Deployment.InstanceID, nil
+	case "region":
+		return env.Deployment.Region, nil
+	case "zone", "availability_zone":
+		return env.Deployment.AvailabilityZone, nil
+	case "network_id":
+		return env.Deployment.NetworkID, nil
+	case "datacenter_id":
+		return env.Deployment.DatacenterID, nil
+	case "hostname":
+		return env.Host.Hostname, nil
+	case "os":
+		return env.Go.OS, nil
+	case "arch":
+		return env.Go.Arch, nil
+	}
+	return "", fmt.Errorf("unknown identifier %q", name)
+}
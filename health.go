@@ -0,0 +1,476 @@
+package cfx
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"go.uber.org/fx"
+)
+
+// HealthKey is the config section read by HealthModule.
+const HealthKey = "health"
+
+// HealthModule provides a *Health that runs the checks registered with ProvideHealthCheck, and
+// with Health.Register, on the intervals set in the "health" config section.
+var HealthModule = fx.Options(
+	ProvideSection(HealthKey, DefaultHealthConfig()),
+	fx.Provide(NewHealth),
+)
+
+// HealthStatus is the status of a single check, or of the service as a whole.
+type HealthStatus string
+
+// Health statuses. Checks are up, down, unknown until they first run, or disabled. The service
+// is up, degraded when only non-critical checks are down, or down.
+const (
+	HealthUp       HealthStatus = "up"
+	HealthDown     HealthStatus = "down"
+	HealthDegraded HealthStatus = "degraded"
+	HealthUnknown  HealthStatus = "unknown"
+	HealthDisabled HealthStatus = "disabled"
+)
+
+// Serving statuses of the grpc.health.v1 protocol, as returned by Health.GRPCServingStatus.
+const (
+	GRPCHealthUnknown        int32 = 0
+	GRPCHealthServing        int32 = 1
+	GRPCHealthNotServing     int32 = 2
+	GRPCHealthServiceUnknown int32 = 3
+)
+
+// HealthConfig is the configuration section for health checks:
+//
+//	health:
+//	  interval: 15s
+//	  timeout: 5s
+//	  checks:
+//	    postgres:
+//	      interval: 5s
+//	    search:
+//	      critical: false
+type HealthConfig struct {
+	// Interval is how often each check runs. Defaults to 15 seconds.
+	Interval time.Duration `json:"interval,omitempty" yaml:"interval,omitempty" mapstructure:"interval,omitempty"`
+
+	// Timeout bounds each run of a check. Defaults to 5 seconds.
+	Timeout time.Duration `json:"timeout,omitempty" yaml:"timeout,omitempty" mapstructure:"timeout,omitempty"`
+
+	// Checks overrides the settings of individual checks, keyed by check name.
+	Checks map[string]HealthCheckConfig `json:"checks,omitempty" yaml:"checks,omitempty" mapstructure:"checks,omitempty"`
+}
+
+// HealthCheckConfig overrides the settings of a single check.
+type HealthCheckConfig struct {
+	// Interval overrides the section's interval.
+	Interval time.Duration `json:"interval,omitempty" yaml:"interval,omitempty" mapstructure:"interval,omitempty"`
+
+	// Timeout overrides the section's timeout.
+	Timeout time.Duration `json:"timeout,omitempty" yaml:"timeout,omitempty" mapstructure:"timeout,omitempty"`
+
+	// Critical overrides whether the check failing takes the whole service down, rather than
+	// only degrading it. Nil keeps the criticality the check was registered with.
+	Critical *bool `json:"critical,omitempty" yaml:"critical,omitempty" mapstructure:"critical,omitempty"`
+
+	// Disabled stops the check from running and leaves it out of the aggregated status.
+	Disabled bool `json:"disabled,omitempty" yaml:"disabled,omitempty" mapstructure:"disabled,omitempty"`
+}
+
+// DefaultHealthConfig returns a HealthConfig with a 15 second interval and a 5 second timeout.
+func DefaultHealthConfig() *HealthConfig {
+	return &HealthConfig{Interval: 15 * time.Second, Timeout: 5 * time.Second}
+}
+
+// Validate implements the cfx.Validator interface.
+func (h HealthConfig) Validate() error {
+	if h.Interval < 0 || h.Timeout < 0 {
+		return errors.New("health interval and timeout must not be negative")
+	}
+	for name, c := range h.Checks {
+		if c.Interval < 0 || c.Timeout < 0 {
+			return fmt.Errorf("health check %s: interval and timeout must not be negative", name)
+		}
+	}
+	return nil
+}
+
+// HealthCheck is a named check run by Health.
+type HealthCheck struct {
+	Name string
+	Fn   func(ctx context.Context) error
+
+	// Critical checks take the service down when they fail; others only degrade it.
+	Critical bool
+}
+
+// HealthCheckResult adds a HealthCheck to the checks run by HealthModule.
+type HealthCheckResult struct {
+	fx.Out
+
+	Check HealthCheck `group:"cfx_health_checks"`
+}
+
+// ProvideHealthCheck registers a critical check with HealthModule.
+func ProvideHealthCheck(name string, fn func(ctx context.Context) error) fx.Option {
+	return fx.Provide(func() HealthCheckResult {
+		return HealthCheckResult{Check: HealthCheck{Name: name, Fn: fn, Critical: true}}
+	})
+}
+
+// HealthResult is the latest outcome of a single check.
+type HealthResult struct {
+	Name     string        `json:"name" yaml:"name"`
+	Status   HealthStatus  `json:"status" yaml:"status"`
+	Critical bool          `json:"critical" yaml:"critical"`
+	Error    string        `json:"error,omitempty" yaml:"error,omitempty"`
+	Time     time.Time     `json:"time,omitempty" yaml:"time,omitempty"`
+	Duration time.Duration `json:"duration,omitempty" yaml:"duration,omitempty"`
+}
+
+// HealthReport aggregates the latest outcome of every check.
+type HealthReport struct {
+	Status HealthStatus   `json:"status" yaml:"status"`
+	Checks []HealthResult `json:"checks" yaml:"checks"`
+}
+
+// Health runs named checks in the background and aggregates their results. Modules register
+// their checks with ProvideHealthCheck or Register; the "health" section controls how often
+// they run and which are critical, and changes to it apply live. It is safe for concurrent use.
+type Health struct {
+	mu      sync.RWMutex
+	cfg     HealthConfig
+	checks  map[string]HealthCheck
+	results map[string]HealthResult
+	changed chan struct{}
+
+	observers []func(HealthReport)
+
+	ctx     context.Context
+	cancel  context.CancelFunc
+	started bool
+	wg      sync.WaitGroup
+}
+
+// HealthParams are the dependencies of NewHealth.
+type HealthParams struct {
+	fx.In
+
+	Lifecycle fx.Lifecycle
+	Config    Container
+	Checks    []HealthCheck `group:"cfx_health_checks"`
+}
+
+// NewHealth reads the "health" section, follows its changes on reload, and runs the checks for
+// the lifetime of the Fx application.
+func NewHealth(p HealthParams) (*Health, error) {
+	h := newHealth()
+	if err := h.load(p.Config); err != nil {
+		return nil, err
+	}
+	for _, c := range p.Checks {
+		if err := h.Register(c); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := OnSectionChange(p.Config, HealthKey, func(string, []Change) error {
+		return h.load(p.Config)
+	}); err != nil {
+		return nil, err
+	}
+
+	p.Lifecycle.Append(fx.Hook{
+		OnStart: func(context.Context) error {
+			h.Start()
+			return nil
+		},
+		OnStop: h.Stop,
+	})
+	return h, nil
+}
+
+func newHealth() *Health {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Health{
+		cfg:     *DefaultHealthConfig(),
+		checks:  map[string]HealthCheck{},
+		results: map[string]HealthResult{},
+		changed: make(chan struct{}),
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+}
+
+func (h *Health) load(c Container) error {
+	cfg := DefaultHealthConfig()
+	if err := c.Populate(HealthKey, cfg); err != nil {
+		return fmt.Errorf("could not populate health config: %v", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		return SectionError{Key: HealthKey, Err: err}
+	}
+
+	h.mu.Lock()
+	h.cfg = *cfg
+	close(h.changed)
+	h.changed = make(chan struct{})
+	h.mu.Unlock()
+	h.notify()
+	return nil
+}
+
+// Register adds a check. Checks registered after the Health has started begin running
+// immediately. Names must be unique.
+func (h *Health) Register(c HealthCheck) error {
+	if c.Name == "" || c.Fn == nil {
+		return errors.New("health checks need a name and a function")
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, exists := h.checks[c.Name]; exists {
+		return fmt.Errorf("health check %s is registered more than once", c.Name)
+	}
+	h.checks[c.Name] = c
+	if h.started && h.ctx.Err() == nil {
+		h.wg.Add(1)
+		go h.run(c)
+	}
+	return nil
+}
+
+// Start runs every registered check in the background until Stop is called. NewHealth binds
+// Start and Stop to the Fx lifecycle.
+func (h *Health) Start() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.started || h.ctx.Err() != nil {
+		return
+	}
+	h.started = true
+	for _, c := range h.checks {
+		h.wg.Add(1)
+		go h.run(c)
+	}
+}
+
+// Stop stops running checks and waits for running checks to return, or for ctx to expire.
+func (h *Health) Stop(ctx context.Context) error {
+	h.cancel()
+
+	done := make(chan struct{})
+	go func() {
+		h.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("health checks did not finish before shutdown: %v", ctx.Err())
+	}
+}
+
+// settings returns the effective settings of check c.
+func (h *Health) settings(c HealthCheck) (time.Duration, time.Duration, bool, bool) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	interval, timeout, critical := h.cfg.Interval, h.cfg.Timeout, c.Critical
+	override := h.cfg.Checks[c.Name]
+	if override.Interval > 0 {
+		interval = override.Interval
+	}
+	if override.Timeout > 0 {
+		timeout = override.Timeout
+	}
+	if override.Critical != nil {
+		critical = *override.Critical
+	}
+	if interval <= 0 {
+		interval = 15 * time.Second
+	}
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+	return interval, timeout, critical, override.Disabled
+}
+
+// run runs check c on its interval until the Health is stopped. Configuration changes take
+// effect at once.
+func (h *Health) run(c HealthCheck) {
+	defer h.wg.Done()
+
+	for {
+		interval, timeout, critical, disabled := h.settings(c)
+		if disabled {
+			h.record(HealthResult{Name: c.Name, Status: HealthDisabled, Critical: critical})
+		} else {
+			h.record(h.check(c, timeout, critical))
+		}
+
+		h.mu.RLock()
+		changed := h.changed
+		h.mu.RUnlock()
+
+		timer := time.NewTimer(interval)
+		select {
+		case <-h.ctx.Done():
+			timer.Stop()
+			return
+		case <-changed:
+			timer.Stop()
+		case <-timer.C:
+		}
+	}
+}
+
+func (h *Health) check(c HealthCheck, timeout time.Duration, critical bool) HealthResult {
+	ctx, cancel := context.WithTimeout(h.ctx, timeout)
+	defer cancel()
+
+	res := HealthResult{Name: c.Name, Status: HealthUp, Critical: critical, Time: time.Now()}
+	if err := c.Fn(ctx); err != nil {
+		res.Status = HealthDown
+		res.Error = err.Error()
+	}
+	res.Duration = time.Since(res.Time)
+	return res
+}
+
+func (h *Health) record(res HealthResult) {
+	h.mu.Lock()
+	prev, ok := h.results[res.Name]
+	h.results[res.Name] = res
+	h.mu.Unlock()
+
+	if !ok || prev.Status != res.Status || prev.Critical != res.Critical {
+		h.notify()
+	}
+}
+
+// OnChange registers fn to receive the report every time the status of a check changes, for
+// example to update a gRPC health server:
+//
+//	h.OnChange(func(r cfx.HealthReport) {
+//		status := healthpb.HealthCheckResponse_ServingStatus(h.GRPCServingStatus(""))
+//		grpcHealth.SetServingStatus("", status)
+//	})
+//
+// fn is called synchronously from the goroutine running the check, so it should not block.
+func (h *Health) OnChange(fn func(HealthReport)) {
+	if fn == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.observers = append(h.observers, fn)
+}
+
+func (h *Health) notify() {
+	h.mu.RLock()
+	observers := h.observers
+	h.mu.RUnlock()
+	if len(observers) == 0 {
+		return
+	}
+	r := h.Report()
+	for _, fn := range observers {
+		fn(r)
+	}
+}
+
+// Report returns the latest outcome of every check, sorted by name, and the aggregated status.
+// A critical check that has not run yet counts as down.
+func (h *Health) Report() HealthReport {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	r := HealthReport{Status: HealthUp, Checks: make([]HealthResult, 0, len(h.checks))}
+	for name, c := range h.checks {
+		res, ok := h.results[name]
+		if !ok {
+			res = HealthResult{Name: name, Status: HealthUnknown, Critical: c.Critical}
+			if o := h.cfg.Checks[name]; o.Critical != nil {
+				res.Critical = *o.Critical
+			}
+		}
+		r.Checks = append(r.Checks, res)
+
+		switch {
+		case res.Status == HealthUp || res.Status == HealthDisabled:
+		case res.Critical:
+			r.Status = HealthDown
+		case r.Status == HealthUp:
+			r.Status = HealthDegraded
+		}
+	}
+	sort.Slice(r.Checks, func(i, j int) bool { return r.Checks[i].Name < r.Checks[j].Name })
+	return r
+}
+
+// Status returns the aggregated status of the service.
+func (h *Health) Status() HealthStatus {
+	return h.Report().Status
+}
+
+// GRPCServingStatus maps the health of service to a grpc.health.v1 serving status, for use
+// with a gRPC health server. The empty service is the aggregated status, which is serving
+// while degraded; any other service is the check of that name.
+func (h *Health) GRPCServingStatus(service string) int32 {
+	r := h.Report()
+	status := r.Status
+	if service != "" {
+		status = ""
+		for _, c := range r.Checks {
+			if c.Name == service {
+				status = c.Status
+			}
+		}
+	}
+
+	switch status {
+	case HealthUp, HealthDegraded, HealthDisabled:
+		return GRPCHealthServing
+	case HealthDown:
+		return GRPCHealthNotServing
+	case HealthUnknown:
+		return GRPCHealthUnknown
+	}
+	return GRPCHealthServiceUnknown
+}
+
+// ServeHTTP serves the report as JSON, so the Health can be mounted on an admin mux. The status
+// is 503 when the service is down. The "check" query parameter serves a single check instead,
+// with a 503 when it is down and a 404 when it does not exist.
+func (h *Health) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	report := h.Report()
+	var body interface{} = report
+	status := http.StatusOK
+	if report.Status == HealthDown {
+		status = http.StatusServiceUnavailable
+	}
+
+	if name := r.URL.Query().Get("check"); name != "" {
+		status = http.StatusNotFound
+		body = map[string]string{"error": fmt.Sprintf("health check %s does not exist", name)}
+		for _, c := range report.Checks {
+			if c.Name != name {
+				continue
+			}
+			body, status = c, http.StatusOK
+			if c.Status == HealthDown || (c.Status == HealthUnknown && c.Critical) {
+				status = http.StatusServiceUnavailable
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
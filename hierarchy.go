@@ -0,0 +1,82 @@
+package cfx
+
+const (
+	// ServiceOverridesKey holds per service overrides, keyed by DeploymentContext.ServiceID.
+	ServiceOverridesKey = "service"
+
+	// AppOverridesKey holds per application overrides, keyed by DeploymentContext.AppID.
+	AppOverridesKey = "app"
+)
+
+// WithKeyHierarchy lets one configuration tree serve several services. Values under
+// app.<app_id> are overlaid onto the root of the tree, followed by values under
+// service.<service_id>, using the DeploymentContext of the environment. With a service ID of
+// "billing", service.billing.db.host overrides app.<app_id>.db.host, which overrides db.host:
+//
+//	db:
+//	  host: db.internal
+//	service:
+//	  billing:
+//	    db:
+//	      host: billing-db.internal
+func WithKeyHierarchy() Option {
+	return func(o *options) {
+		o.keyHierarchy = true
+	}
+}
+
+// overlayHierarchy merges the app and service overrides for env onto the root of tree,
+// reporting whether anything was overlaid.
+func overlayHierarchy(env EnvContext, tree map[string]interface{}) bool {
+	changed := false
+	for _, level := range []struct{ key, id string }{
+		{AppOverridesKey, env.Deployment.AppID},
+		{ServiceOverridesKey, env.Deployment.ServiceID},
+	} {
+		if level.id == "" {
+			continue
+		}
+		overrides, ok := tree[level.key].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if sub, ok := overrides[level.id].(map[string]interface{}); ok {
+			mergeTree(tree, sub)
+			changed = true
+		}
+	}
+	return changed
+}
+
+// mergeTree deep merges src into dst. Maps are merged key by key; any other value replaces
+// the destination value.
+func mergeTree(dst, src map[string]interface{}) {
+	for k, sv := range src {
+		sm, sok := sv.(map[string]interface{})
+		dm, dok := dst[k].(map[string]interface{})
+		if sok && dok {
+			mergeTree(dm, sm)
+			continue
+		}
+		dst[k] = copyTreeValue(sv)
+	}
+}
+
+// copyTreeValue deep copies maps and lists so merged trees don't share structure.
+func copyTreeValue(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(t))
+		for k, val := range t {
+			m[k] = copyTreeValue(val)
+		}
+		return m
+	case []interface{}:
+		l := make([]interface{}, len(t))
+		for i, val := range t {
+			l[i] = copyTreeValue(val)
+		}
+		return l
+	}
+	return v
+}
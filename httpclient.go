@@ -0,0 +1,230 @@
+package cfx
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"go.uber.org/fx"
+)
+
+// HTTPClientConfig is the canonical configuration section for outbound HTTP clients.
+type HTTPClientConfig struct {
+	// Timeout is the overall time limit for a request, including retries.
+	Timeout time.Duration `json:"timeout,omitempty" yaml:"timeout,omitempty" mapstructure:"timeout,omitempty"`
+
+	// DialTimeout limits how long establishing a TCP connection may take.
+	DialTimeout time.Duration `json:"dial_timeout,omitempty" yaml:"dial_timeout,omitempty" mapstructure:"dial_timeout,omitempty"`
+
+	// KeepAlive is the TCP keep-alive period for active connections.
+	KeepAlive time.Duration `json:"keep_alive,omitempty" yaml:"keep_alive,omitempty" mapstructure:"keep_alive,omitempty"`
+
+	// TLSHandshakeTimeout limits how long a TLS handshake may take.
+	TLSHandshakeTimeout time.Duration `json:"tls_handshake_timeout,omitempty" yaml:"tls_handshake_timeout,omitempty" mapstructure:"tls_handshake_timeout,omitempty"`
+
+	// ResponseHeaderTimeout limits how long to wait for response headers after writing a request.
+	ResponseHeaderTimeout time.Duration `json:"response_header_timeout,omitempty" yaml:"response_header_timeout,omitempty" mapstructure:"response_header_timeout,omitempty"`
+
+	// IdleConnTimeout is how long an idle connection stays in the pool.
+	IdleConnTimeout time.Duration `json:"idle_conn_timeout,omitempty" yaml:"idle_conn_timeout,omitempty" mapstructure:"idle_conn_timeout,omitempty"`
+
+	// MaxIdleConns is the maximum number of idle connections across all hosts.
+	MaxIdleConns int `json:"max_idle_conns,omitempty" yaml:"max_idle_conns,omitempty" mapstructure:"max_idle_conns,omitempty"`
+
+	// MaxIdleConnsPerHost is the maximum number of idle connections per host.
+	MaxIdleConnsPerHost int `json:"max_idle_conns_per_host,omitempty" yaml:"max_idle_conns_per_host,omitempty" mapstructure:"max_idle_conns_per_host,omitempty"`
+
+	// MaxConnsPerHost limits the total number of connections per host. Zero means no limit.
+	MaxConnsPerHost int `json:"max_conns_per_host,omitempty" yaml:"max_conns_per_host,omitempty" mapstructure:"max_conns_per_host,omitempty"`
+
+	// DisableKeepAlives disables HTTP keep-alives, using each connection for a single request.
+	DisableKeepAlives bool `json:"disable_keep_alives,omitempty" yaml:"disable_keep_alives,omitempty" mapstructure:"disable_keep_alives,omitempty"`
+
+	// TLS configures the client's TLS settings.
+	TLS TLSConfig `json:"tls,omitempty" yaml:"tls,omitempty" mapstructure:"tls,omitempty"`
+
+	// Retries configures retrying of idempotent requests.
+	Retries HTTPRetryConfig `json:"retries,omitempty" yaml:"retries,omitempty" mapstructure:"retries,omitempty"`
+}
+
+// HTTPRetryConfig configures how idempotent HTTP requests are retried.
+type HTTPRetryConfig struct {
+	// MaxAttempts is the total number of attempts, including the first. Values below 2 disable retries.
+	MaxAttempts int `json:"max_attempts,omitempty" yaml:"max_attempts,omitempty" mapstructure:"max_attempts,omitempty"`
+
+	// Backoff is the delay before the first retry. It doubles on every subsequent attempt.
+	Backoff time.Duration `json:"backoff,omitempty" yaml:"backoff,omitempty" mapstructure:"backoff,omitempty"`
+
+	// MaxBackoff caps the delay between attempts.
+	MaxBackoff time.Duration `json:"max_backoff,omitempty" yaml:"max_backoff,omitempty" mapstructure:"max_backoff,omitempty"`
+}
+
+// DefaultHTTPClientConfig returns the defaults that are applied before a section is populated.
+func DefaultHTTPClientConfig() HTTPClientConfig {
+	return HTTPClientConfig{
+		Timeout:               30 * time.Second,
+		DialTimeout:           10 * time.Second,
+		KeepAlive:             30 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: 15 * time.Second,
+		IdleConnTimeout:       90 * time.Second,
+		MaxIdleConns:          100,
+		MaxIdleConnsPerHost:   10,
+		Retries: HTTPRetryConfig{
+			MaxAttempts: 1,
+			Backoff:     100 * time.Millisecond,
+			MaxBackoff:  2 * time.Second,
+		},
+	}
+}
+
+// Validate checks the client configuration for invalid values.
+func (h HTTPClientConfig) Validate() error {
+	if h.Timeout < 0 || h.DialTimeout < 0 || h.TLSHandshakeTimeout < 0 || h.ResponseHeaderTimeout < 0 || h.IdleConnTimeout < 0 {
+		return errors.New("http client timeouts must not be negative")
+	}
+	if h.MaxIdleConns < 0 || h.MaxIdleConnsPerHost < 0 || h.MaxConnsPerHost < 0 {
+		return errors.New("http client connection limits must not be negative")
+	}
+	if h.Retries.MaxAttempts < 0 {
+		return errors.New("http client retries.max_attempts must not be negative")
+	}
+	if h.Retries.Backoff < 0 || h.Retries.MaxBackoff < 0 {
+		return errors.New("http client retry backoff must not be negative")
+	}
+	return h.TLS.Validate()
+}
+
+// Transport builds an *http.Transport from the configuration.
+func (h HTTPClientConfig) Transport() (*http.Transport, error) {
+	if err := h.Validate(); err != nil {
+		return nil, err
+	}
+
+	tlsCfg, err := h.TLS.ClientConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	dialer := &net.Dialer{
+		Timeout:   h.DialTimeout,
+		KeepAlive: h.KeepAlive,
+	}
+
+	return &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           dialer.DialContext,
+		TLSClientConfig:       tlsCfg,
+		TLSHandshakeTimeout:   h.TLSHandshakeTimeout,
+		ResponseHeaderTimeout: h.ResponseHeaderTimeout,
+		IdleConnTimeout:       h.IdleConnTimeout,
+		MaxIdleConns:          h.MaxIdleConns,
+		MaxIdleConnsPerHost:   h.MaxIdleConnsPerHost,
+		MaxConnsPerHost:       h.MaxConnsPerHost,
+		DisableKeepAlives:     h.DisableKeepAlives,
+	}, nil
+}
+
+// Client builds an *http.Client from the configuration.
+func (h HTTPClientConfig) Client() (*http.Client, error) {
+	transport, err := h.Transport()
+	if err != nil {
+		return nil, err
+	}
+
+	var rt http.RoundTripper = transport
+	if h.Retries.MaxAttempts > 1 {
+		rt = &retryTransport{next: transport, cfg: h.Retries}
+	}
+
+	return &http.Client{
+		Timeout:   h.Timeout,
+		Transport: rt,
+	}, nil
+}
+
+// ProvideHTTPClient returns an Fx option that provides an *http.Client named after the
+// config section at key. Consumers can depend on it with an fx.In field tagged `name:"<key>"`.
+func ProvideHTTPClient(key string) fx.Option {
+	return fx.Provide(fx.Annotated{
+		Name: key,
+		Target: func(c Container) (*http.Client, error) {
+			cfg := DefaultHTTPClientConfig()
+			if err := c.Populate(key, &cfg); err != nil {
+				return nil, fmt.Errorf("could not populate http client config %s: %v", key, err)
+			}
+
+			client, err := cfg.Client()
+			if err != nil {
+				return nil, fmt.Errorf("invalid http client config %s: %v", key, err)
+			}
+
+			return client, nil
+		},
+	})
+}
+
+var _idempotentMethods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodOptions: true,
+	http.MethodPut:     true,
+	http.MethodDelete:  true,
+}
+
+// retryTransport retries idempotent requests that fail with a network error or a 502/503/504.
+type retryTransport struct {
+	next http.RoundTripper
+	cfg  HTTPRetryConfig
+}
+
+// RoundTrip implements the http.RoundTripper interface.
+func (r *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !_idempotentMethods[req.Method] || (req.Body != nil && req.GetBody == nil) {
+		return r.next.RoundTrip(req)
+	}
+
+	backoff := r.cfg.Backoff
+	for attempt := 1; ; attempt++ {
+		resp, err := r.next.RoundTrip(req)
+		if attempt >= r.cfg.MaxAttempts || !retryableResponse(resp, err) {
+			return resp, err
+		}
+		if resp != nil {
+			resp.Body.Close()
+		}
+
+		select {
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+		if r.cfg.MaxBackoff > 0 && backoff > r.cfg.MaxBackoff {
+			backoff = r.cfg.MaxBackoff
+		}
+
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req = req.Clone(req.Context())
+			req.Body = body
+		}
+	}
+}
+
+func retryableResponse(resp *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+	switch resp.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,192 @@
+package cfx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"go.uber.org/fx"
+)
+
+// HTTPServerKey is the config section read by HTTPServerModule.
+const HTTPServerKey = "server"
+
+// HTTPServerModule provides an *http.Server configured from the "server" config section.
+// The server starts listening when the Fx application starts and is gracefully shut down
+// when it stops. The handler is taken from an http.Handler in the Fx graph if one is provided,
+// otherwise http.DefaultServeMux is served.
+var HTTPServerModule = fx.Options(
+	ProvideSection(HTTPServerKey, &HTTPServerConfig{}),
+	fx.Provide(NewHTTPServer),
+	fx.Invoke(func(*http.Server) {}),
+)
+
+// H2CWrapper upgrades a handler to serve HTTP/2 over cleartext, e.g. h2c.NewHandler from
+// golang.org/x/net/http2/h2c. It must be provided to the Fx graph when server.h2c is enabled.
+type H2CWrapper func(http.Handler) http.Handler
+
+// HTTPServerConfig is the canonical configuration section for HTTP servers.
+type HTTPServerConfig struct {
+	// Addr is the address to listen on. Defaults to 127.0.0.1:8080 in development and :8080 elsewhere.
+	Addr string `json:"addr,omitempty" yaml:"addr,omitempty" mapstructure:"addr,omitempty"`
+
+	// ReadTimeout limits how long reading an entire request may take.
+	ReadTimeout time.Duration `json:"read_timeout,omitempty" yaml:"read_timeout,omitempty" mapstructure:"read_timeout,omitempty"`
+
+	// ReadHeaderTimeout limits how long reading request headers may take.
+	ReadHeaderTimeout time.Duration `json:"read_header_timeout,omitempty" yaml:"read_header_timeout,omitempty" mapstructure:"read_header_timeout,omitempty"`
+
+	// WriteTimeout limits how long writing a response may take.
+	WriteTimeout time.Duration `json:"write_timeout,omitempty" yaml:"write_timeout,omitempty" mapstructure:"write_timeout,omitempty"`
+
+	// IdleTimeout is how long keep-alive connections may stay idle.
+	IdleTimeout time.Duration `json:"idle_timeout,omitempty" yaml:"idle_timeout,omitempty" mapstructure:"idle_timeout,omitempty"`
+
+	// MaxHeaderBytes limits the size of request headers.
+	MaxHeaderBytes int `json:"max_header_bytes,omitempty" yaml:"max_header_bytes,omitempty" mapstructure:"max_header_bytes,omitempty"`
+
+	// TLS configures transport security.
+	TLS TLSConfig `json:"tls,omitempty" yaml:"tls,omitempty" mapstructure:"tls,omitempty"`
+
+	// H2C enables HTTP/2 over cleartext. It requires an H2CWrapper in the Fx graph.
+	H2C bool `json:"h2c,omitempty" yaml:"h2c,omitempty" mapstructure:"h2c,omitempty"`
+
+	// ShutdownPeriod is how long in-flight requests are given to finish when the server stops.
+	ShutdownPeriod time.Duration `json:"shutdown_period,omitempty" yaml:"shutdown_period,omitempty" mapstructure:"shutdown_period,omitempty"`
+}
+
+// DefaultHTTPServerConfig returns the defaults that are applied before the section is populated.
+func DefaultHTTPServerConfig(env EnvContext) HTTPServerConfig {
+	addr := ":8080"
+	if env.Environment == _defaultEnv {
+		addr = "127.0.0.1:8080"
+	}
+
+	return HTTPServerConfig{
+		Addr:              addr,
+		ReadTimeout:       30 * time.Second,
+		ReadHeaderTimeout: 10 * time.Second,
+		WriteTimeout:      30 * time.Second,
+		IdleTimeout:       120 * time.Second,
+		MaxHeaderBytes:    1 << 20,
+		ShutdownPeriod:    15 * time.Second,
+	}
+}
+
+// Validate implements the cfx.Validator interface.
+func (h HTTPServerConfig) Validate() error {
+	if h.Addr != "" {
+		if _, _, err := net.SplitHostPort(h.Addr); err != nil {
+			return fmt.Errorf("server addr %q is invalid: %v", h.Addr, err)
+		}
+	}
+	if h.ReadTimeout < 0 || h.ReadHeaderTimeout < 0 || h.WriteTimeout < 0 || h.IdleTimeout < 0 || h.ShutdownPeriod < 0 {
+		return errors.New("server timeouts must not be negative")
+	}
+	if h.MaxHeaderBytes < 0 {
+		return errors.New("server max_header_bytes must not be negative")
+	}
+	if h.H2C && h.TLS.Enabled {
+		return errors.New("server h2c cannot be combined with tls")
+	}
+	return h.TLS.Validate()
+}
+
+// HTTPServerParams are the dependencies of NewHTTPServer.
+type HTTPServerParams struct {
+	fx.In
+
+	Lifecycle fx.Lifecycle
+	Env       EnvContext
+	Config    Container
+	Handler   http.Handler `optional:"true"`
+	H2C       H2CWrapper   `optional:"true"`
+}
+
+// NewHTTPServer builds an *http.Server from the "server" section and binds it to the Fx lifecycle.
+func NewHTTPServer(p HTTPServerParams) (*http.Server, error) {
+	cfg := DefaultHTTPServerConfig(p.Env)
+	if err := p.Config.Populate(HTTPServerKey, &cfg); err != nil {
+		return nil, fmt.Errorf("could not populate server config: %v", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, SectionError{Key: HTTPServerKey, Err: err}
+	}
+
+	tlsCfg, err := cfg.TLS.ServerConfig()
+	if err != nil {
+		return nil, SectionError{Key: HTTPServerKey, Err: err}
+	}
+
+	handler := p.Handler
+	if handler == nil {
+		handler = http.DefaultServeMux
+	}
+	if cfg.H2C {
+		if p.H2C == nil {
+			return nil, errors.New("server.h2c is enabled but no cfx.H2CWrapper was provided")
+		}
+		handler = p.H2C(handler)
+	}
+
+	logger := log.New(os.Stderr, fmt.Sprintf("[%s] ", serverLogTag(p.Env)), log.LstdFlags)
+	srv := &http.Server{
+		Addr:              cfg.Addr,
+		Handler:           handler,
+		TLSConfig:         tlsCfg,
+		ReadTimeout:       cfg.ReadTimeout,
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+		MaxHeaderBytes:    cfg.MaxHeaderBytes,
+		ErrorLog:          logger,
+	}
+
+	p.Lifecycle.Append(fx.Hook{
+		OnStart: func(ctx context.Context) error {
+			ln, err := net.Listen("tcp", srv.Addr)
+			if err != nil {
+				return fmt.Errorf("could not listen on %s: %v", srv.Addr, err)
+			}
+
+			logger.Printf("http server listening on %s (tls=%t)", ln.Addr(), tlsCfg != nil)
+			go func() {
+				var err error
+				if tlsCfg != nil {
+					err = srv.ServeTLS(ln, "", "")
+				} else {
+					err = srv.Serve(ln)
+				}
+				if err != nil && err != http.ErrServerClosed {
+					logger.Printf("http server stopped unexpectedly: %v", err)
+				}
+			}()
+
+			return nil
+		},
+		OnStop: func(ctx context.Context) error {
+			if cfg.ShutdownPeriod > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, cfg.ShutdownPeriod)
+				defer cancel()
+			}
+			logger.Printf("http server shutting down")
+			return srv.Shutdown(ctx)
+		},
+	})
+
+	return srv, nil
+}
+
+func serverLogTag(env EnvContext) string {
+	app := env.Deployment.AppID
+	if app == "" {
+		app = "cfx"
+	}
+	return fmt.Sprintf("%s %s %s", app, env.Environment, env.Host.Hostname)
+}
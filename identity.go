@@ -0,0 +1,115 @@
+package cfx
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"go.uber.org/fx"
+)
+
+// ServiceIdentity is the canonical description of a running instance, used when registering it
+// with service discovery.
+type ServiceIdentity struct {
+	// Name is the name the instance registers under: the AppID, or the ServiceID if there is none.
+	Name string `json:"name" yaml:"name"`
+
+	// ID uniquely identifies the instance, see EnvContext.Identity.
+	ID string `json:"id" yaml:"id"`
+
+	// Service is the ServiceID grouping related apps.
+	Service string `json:"service,omitempty" yaml:"service,omitempty"`
+
+	Environment EnvID  `json:"environment,omitempty" yaml:"environment,omitempty"`
+	Region      string `json:"region,omitempty" yaml:"region,omitempty"`
+	Zone        string `json:"zone,omitempty" yaml:"zone,omitempty"`
+	Datacenter  string `json:"datacenter,omitempty" yaml:"datacenter,omitempty"`
+	Hostname    string `json:"hostname,omitempty" yaml:"hostname,omitempty"`
+
+	// Address and Port are where the instance can be reached. They are not known to the
+	// EnvContext and are set by the application, see RegisterService.
+	Address string `json:"address,omitempty" yaml:"address,omitempty"`
+	Port    int    `json:"port,omitempty" yaml:"port,omitempty"`
+
+	// Tags are "key:value" labels derived from the environment, sorted.
+	Tags []string `json:"tags,omitempty" yaml:"tags,omitempty"`
+
+	// Meta holds the same labels as a map.
+	Meta map[string]string `json:"meta,omitempty" yaml:"meta,omitempty"`
+}
+
+// ServiceIdentity builds the canonical identity record for the running instance.
+func (e EnvContext) ServiceIdentity() ServiceIdentity {
+	id := ServiceIdentity{
+		Name:        e.Deployment.AppID,
+		ID:          e.Identity(),
+		Service:     e.Deployment.ServiceID,
+		Environment: e.Environment,
+		Region:      e.Deployment.Region,
+		Zone:        e.Deployment.AvailabilityZone,
+		Datacenter:  e.Deployment.DatacenterID,
+		Hostname:    e.Host.Hostname,
+		Address:     e.Host.Hostname,
+		Meta:        map[string]string{},
+	}
+	if id.Name == "" {
+		id.Name = e.Deployment.ServiceID
+	}
+
+	for k, v := range map[string]string{
+		"env":        e.Environment.String(),
+		"service":    e.Deployment.ServiceID,
+		"region":     e.Deployment.Region,
+		"zone":       e.Deployment.AvailabilityZone,
+		"datacenter": e.Deployment.DatacenterID,
+		"network":    e.Deployment.NetworkID,
+		"version":    e.Go.Version,
+	} {
+		if v == "" {
+			continue
+		}
+		id.Meta[k] = v
+		id.Tags = append(id.Tags, k+":"+v)
+	}
+	sort.Strings(id.Tags)
+
+	return id
+}
+
+// Registrar registers instances with a service discovery system.
+type Registrar interface {
+	Register(ctx context.Context, id ServiceIdentity) error
+	Deregister(ctx context.Context, id ServiceIdentity) error
+}
+
+// RegisterService registers the instance's ServiceIdentity with r when the Fx application starts
+// and deregisters it when it stops. port is the port the instance serves on; fns can adjust the
+// identity further, for example to set the Address or add tags.
+func RegisterService(r Registrar, port int, fns ...func(*ServiceIdentity)) fx.Option {
+	return fx.Invoke(func(lc fx.Lifecycle, env EnvContext) error {
+		id := env.ServiceIdentity()
+		id.Port = port
+		for _, fn := range fns {
+			fn(&id)
+		}
+		if id.Name == "" {
+			return fmt.Errorf("cannot register service without an app or service id")
+		}
+
+		lc.Append(fx.Hook{
+			OnStart: func(ctx context.Context) error {
+				if err := r.Register(ctx, id); err != nil {
+					return fmt.Errorf("could not register service %s: %v", id.Name, err)
+				}
+				return nil
+			},
+			OnStop: func(ctx context.Context) error {
+				if err := r.Deregister(ctx, id); err != nil {
+					return fmt.Errorf("could not deregister service %s: %v", id.Name, err)
+				}
+				return nil
+			},
+		})
+		return nil
+	})
+}
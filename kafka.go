@@ -0,0 +1,140 @@
+package cfx
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"text/template"
+	"time"
+
+	"go.uber.org/fx"
+)
+
+const _defaultKafkaClientID = `{{ with .Deployment.AppID }}{{ . }}{{ else }}cfx{{ end }}-{{ .Host.Hostname }}`
+
+var _saslMechanisms = map[string]bool{
+	"PLAIN":         true,
+	"SCRAM-SHA-256": true,
+	"SCRAM-SHA-512": true,
+}
+
+// KafkaConfig is the canonical configuration section for Kafka clients.
+type KafkaConfig struct {
+	// Brokers lists the host:port of the bootstrap brokers.
+	Brokers []string `json:"brokers,omitempty" yaml:"brokers,omitempty" mapstructure:"brokers,omitempty"`
+
+	// ClientID is a text/template rendered against the EnvContext, e.g. "{{ .Deployment.AppID }}".
+	ClientID string `json:"client_id,omitempty" yaml:"client_id,omitempty" mapstructure:"client_id,omitempty"`
+
+	// Version is the Kafka protocol version to speak, e.g. "2.4.0".
+	Version string `json:"version,omitempty" yaml:"version,omitempty" mapstructure:"version,omitempty"`
+
+	// DialTimeout limits how long establishing a connection may take.
+	DialTimeout time.Duration `json:"dial_timeout,omitempty" yaml:"dial_timeout,omitempty" mapstructure:"dial_timeout,omitempty"`
+
+	// SASL configures authentication.
+	SASL KafkaSASLConfig `json:"sasl,omitempty" yaml:"sasl,omitempty" mapstructure:"sasl,omitempty"`
+
+	// TLS configures transport security.
+	TLS TLSConfig `json:"tls,omitempty" yaml:"tls,omitempty" mapstructure:"tls,omitempty"`
+}
+
+// KafkaSASLConfig holds the Kafka SASL authentication settings.
+type KafkaSASLConfig struct {
+	// Enabled toggles SASL authentication.
+	Enabled bool `json:"enabled,omitempty" yaml:"enabled,omitempty" mapstructure:"enabled,omitempty"`
+
+	// Mechanism is one of PLAIN, SCRAM-SHA-256 or SCRAM-SHA-512.
+	Mechanism string `json:"mechanism,omitempty" yaml:"mechanism,omitempty" mapstructure:"mechanism,omitempty"`
+
+	// Username is the SASL user.
+	Username string `json:"username,omitempty" yaml:"username,omitempty" mapstructure:"username,omitempty"`
+
+	// Password is a reference to the SASL password, e.g. "env:KAFKA_PASSWORD".
+	Password SecretRef `json:"password,omitempty" yaml:"password,omitempty" mapstructure:"password,omitempty"`
+}
+
+// DefaultKafkaConfig returns the defaults that are applied before a section is populated.
+func DefaultKafkaConfig() KafkaConfig {
+	return KafkaConfig{
+		Brokers:     []string{"127.0.0.1:9092"},
+		ClientID:    _defaultKafkaClientID,
+		DialTimeout: 30 * time.Second,
+		SASL: KafkaSASLConfig{
+			Mechanism: "PLAIN",
+		},
+	}
+}
+
+// Validate implements the cfx.Validator interface.
+func (k KafkaConfig) Validate() error {
+	if len(k.Brokers) == 0 {
+		return errors.New("kafka brokers must not be empty")
+	}
+	for _, addr := range k.Brokers {
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			return fmt.Errorf("kafka broker %q is invalid: %v", addr, err)
+		}
+	}
+	if _, err := template.New("client_id").Parse(k.ClientID); err != nil {
+		return fmt.Errorf("kafka client_id template is invalid: %v", err)
+	}
+	if k.DialTimeout < 0 {
+		return errors.New("kafka dial_timeout must not be negative")
+	}
+	if k.SASL.Enabled {
+		if !_saslMechanisms[strings.ToUpper(k.SASL.Mechanism)] {
+			return fmt.Errorf("kafka sasl mechanism %s is not supported", k.SASL.Mechanism)
+		}
+		if k.SASL.Username == "" || k.SASL.Password.IsZero() {
+			return errors.New("kafka sasl username and password must be set")
+		}
+	}
+	return k.TLS.Validate()
+}
+
+// RenderClientID renders the ClientID template against the EnvContext.
+func (k KafkaConfig) RenderClientID(env EnvContext) (string, error) {
+	tmpl, err := template.New("client_id").Option("missingkey=error").Parse(k.ClientID)
+	if err != nil {
+		return "", fmt.Errorf("kafka client_id template is invalid: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, env); err != nil {
+		return "", fmt.Errorf("could not render kafka client_id: %v", err)
+	}
+
+	return buf.String(), nil
+}
+
+// ProvideKafkaConfig registers the Kafka section at key for startup validation and provides
+// it as a *KafkaConfig named after key, with ClientID already rendered.
+func ProvideKafkaConfig(key string) fx.Option {
+	defaults := DefaultKafkaConfig()
+	spec := Section(key, &defaults)
+
+	return fx.Options(
+		ProvideSection(key, &defaults),
+		fx.Provide(fx.Annotated{
+			Name: key,
+			Target: func(env EnvContext, c Container) (*KafkaConfig, error) {
+				v, err := spec.Populate(c)
+				if err != nil {
+					return nil, SectionError{Key: key, Err: err}
+				}
+
+				cfg := v.(*KafkaConfig)
+				id, err := cfg.RenderClientID(env)
+				if err != nil {
+					return nil, SectionError{Key: key, Err: err}
+				}
+				cfg.ClientID = id
+
+				return cfg, nil
+			},
+		}),
+	)
+}
@@ -0,0 +1,132 @@
+package cfx
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// KeychainRefPrefix is the name of ${keychain:service/account} references, which expand to a
+// secret stored in the OS keychain: the macOS Keychain, the Windows Credential Manager or a
+// Secret Service provider such as GNOME Keyring through libsecret's secret-tool.
+const KeychainRefPrefix = "keychain"
+
+// ErrKeychainNotFound is returned when the keychain holds no secret for a service and account.
+var ErrKeychainNotFound = errors.New("secret not found in keychain")
+
+// ReadKeychain returns the secret stored in the OS keychain for service and account, or
+// ErrKeychainNotFound if there is none.
+func ReadKeychain(ctx context.Context, service, account string) (string, error) {
+	if err := checkKeychainName(service, account); err != nil {
+		return "", err
+	}
+	val, err := keychainGet(ctx, service, account)
+	if err != nil {
+		if err == ErrKeychainNotFound {
+			return "", err
+		}
+		return "", fmt.Errorf("could not read %s/%s from the keychain: %v", service, account, err)
+	}
+	return val, nil
+}
+
+// WriteKeychain stores secret in the OS keychain for service and account, replacing any
+// secret stored before, so CLI tools can keep tokens out of dotfiles.
+func WriteKeychain(ctx context.Context, service, account, secret string) error {
+	if err := checkKeychainName(service, account); err != nil {
+		return err
+	}
+	if err := keychainSet(ctx, service, account, secret); err != nil {
+		return fmt.Errorf("could not write %s/%s to the keychain: %v", service, account, err)
+	}
+	return nil
+}
+
+// DeleteKeychain removes the secret stored in the OS keychain for service and account, or
+// returns ErrKeychainNotFound if there is none.
+func DeleteKeychain(ctx context.Context, service, account string) error {
+	if err := checkKeychainName(service, account); err != nil {
+		return err
+	}
+	if err := keychainDelete(ctx, service, account); err != nil {
+		if err == ErrKeychainNotFound {
+			return err
+		}
+		return fmt.Errorf("could not delete %s/%s from the keychain: %v", service, account, err)
+	}
+	return nil
+}
+
+// SplitKeychainPath splits a "service/account" path at its last slash, so service names may
+// contain slashes, as in "github.com/acme/cli/token".
+func SplitKeychainPath(path string) (string, string, error) {
+	i := strings.LastIndexByte(path, '/')
+	if i <= 0 || i == len(path)-1 {
+		return "", "", fmt.Errorf("keychain path %q must be in the form <service>/<account>", path)
+	}
+	return path[:i], path[i+1:], nil
+}
+
+func checkKeychainName(service, account string) error {
+	if service == "" || account == "" {
+		return errors.New("keychain service and account must not be empty")
+	}
+	if strings.ContainsAny(service+account, "\x00\r\n\"") {
+		return fmt.Errorf("keychain service %q or account %q contains invalid characters", service, account)
+	}
+	return nil
+}
+
+// resolveKeychain is the SecretResolver for keychain:service/account references.
+func resolveKeychain(ctx context.Context, path string) (string, error) {
+	service, account, err := SplitKeychainPath(path)
+	if err != nil {
+		return "", err
+	}
+	return ReadKeychain(ctx, service, account)
+}
+
+// isKeychainRef reports whether a reference is a ${keychain:service/account} reference.
+func isKeychainRef(exp expansion) bool {
+	return exp.Name == KeychainRefPrefix && exp.Op == ":"
+}
+
+// resolveKeychainRef returns the text a ${keychain:service/account} reference expands to.
+// ${keychain:service/account:-default} provides a default for a missing secret.
+func resolveKeychainRef(exp expansion) (string, error) {
+	path, def, hasDefault := exp.Arg, "", false
+	if i := strings.Index(path, ":-"); i >= 0 {
+		path, def, hasDefault = path[:i], path[i+2:], true
+	}
+
+	val, err := resolveKeychain(context.Background(), path)
+	if err != nil && hasDefault {
+		return def, nil
+	}
+	if err == ErrKeychainNotFound {
+		return "", fmt.Errorf("keychain %s: %v", path, err)
+	}
+	return val, err
+}
+
+// decodeSecurityOutput returns the secret printed by the macOS security tool, which prints
+// secrets holding bytes outside printable ASCII as hex. Output is decoded only when it is hex
+// and the decoded bytes could not have been printed as they are.
+func decodeSecurityOutput(out string) string {
+	val := strings.TrimRight(out, "\n")
+	if val == "" || len(val)%2 != 0 {
+		return val
+	}
+	b, err := hex.DecodeString(val)
+	if err != nil {
+		return val
+	}
+	for _, c := range b {
+		if c < 0x20 || c > 0x7e {
+			return string(b)
+		}
+	}
+	return val
+}
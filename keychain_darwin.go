@@ -0,0 +1,65 @@
+package cfx
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// _securityPath is the macOS keychain command line tool.
+const _securityPath = "/usr/bin/security"
+
+// _securityNotFound is the exit status of security when no item matches.
+const _securityNotFound = 44
+
+func keychainGet(ctx context.Context, service, account string) (string, error) {
+	out, err := exec.CommandContext(ctx, _securityPath, "find-generic-password", "-s", service, "-a", account, "-w").Output()
+	if err != nil {
+		return "", securityError(err)
+	}
+	return decodeSecurityOutput(string(out)), nil
+}
+
+func keychainSet(ctx context.Context, service, account, secret string) error {
+	// commands are given on stdin, with the secret hex encoded, so it never appears in argv
+	cmd := exec.CommandContext(ctx, _securityPath, "-i")
+	cmd.Stdin = strings.NewReader(fmt.Sprintf("add-generic-password -U -s %s -a %s -X %s\n",
+		securityQuote(service), securityQuote(account), hex.EncodeToString([]byte(secret))))
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	// security -i exits zero even when a command fails
+	if msg := strings.TrimSpace(stderr.String()); msg != "" {
+		return fmt.Errorf("security: %s", msg)
+	}
+	return nil
+}
+
+func keychainDelete(ctx context.Context, service, account string) error {
+	if err := exec.CommandContext(ctx, _securityPath, "delete-generic-password", "-s", service, "-a", account).Run(); err != nil {
+		return securityError(err)
+	}
+	return nil
+}
+
+func securityError(err error) error {
+	if ee, ok := err.(*exec.ExitError); ok {
+		if ee.ExitCode() == _securityNotFound {
+			return ErrKeychainNotFound
+		}
+		if msg := strings.TrimSpace(string(ee.Stderr)); msg != "" {
+			return fmt.Errorf("%v: %s", err, msg)
+		}
+	}
+	return err
+}
+
+// securityQuote quotes s for the command line read by security -i.
+func securityQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
@@ -0,0 +1,65 @@
+//go:build !darwin && !windows
+// +build !darwin,!windows
+
+package cfx
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// _secretTool is libsecret's command line tool, which talks to the Secret Service provider of
+// the desktop session, such as GNOME Keyring or KWallet.
+const _secretTool = "secret-tool"
+
+func keychainGet(ctx context.Context, service, account string) (string, error) {
+	out, err := secretTool(ctx, "", "lookup", "service", service, "account", account)
+	if err != nil {
+		return "", err
+	}
+	// lookup exits zero with no output when nothing matches on older libsecret
+	if len(out) == 0 {
+		return "", ErrKeychainNotFound
+	}
+	return strings.TrimRight(string(out), "\n"), nil
+}
+
+func keychainSet(ctx context.Context, service, account, secret string) error {
+	// the secret is read from stdin, so it never appears in argv
+	_, err := secretTool(ctx, secret, "store", "--label", service+"/"+account, "service", service, "account", account)
+	return err
+}
+
+func keychainDelete(ctx context.Context, service, account string) error {
+	if _, err := keychainGet(ctx, service, account); err != nil {
+		return err
+	}
+	_, err := secretTool(ctx, "", "clear", "service", service, "account", account)
+	return err
+}
+
+func secretTool(ctx context.Context, stdin string, args ...string) ([]byte, error) {
+	path, err := exec.LookPath(_secretTool)
+	if err != nil {
+		return nil, errors.New("secret-tool was not found, install libsecret-tools (or libsecret) to use the keychain")
+	}
+	cmd := exec.CommandContext(ctx, path, args...)
+	cmd.Stdin = strings.NewReader(stdin)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		msg := strings.TrimSpace(stderr.String())
+		if _, ok := err.(*exec.ExitError); ok && msg == "" && args[0] == "lookup" {
+			return nil, ErrKeychainNotFound
+		}
+		if msg != "" {
+			return nil, fmt.Errorf("%v: %s", err, msg)
+		}
+		return nil, err
+	}
+	return stdout.Bytes(), nil
+}
@@ -0,0 +1,26 @@
+package cfx
+
+import "testing"
+
+func TestDecodeSecurityOutput(t *testing.T) {
+	tests := []struct {
+		out  string
+		want string
+	}{
+		{out: "s3cret\n", want: "s3cret"},
+		{out: "\n", want: ""},
+		// printable secrets that happen to be hex are kept as they are
+		{out: "2a2b\n", want: "2a2b"},
+		{out: "414243\n", want: "414243"},
+		// odd length hex cannot be security's encoding
+		{out: "abc\n", want: "abc"},
+		{out: "000102ff\n", want: "\x00\x01\x02\xff"},
+		{out: "70c3a4737377\n", want: "pässw"},
+		{out: "6c696e65310a6c696e6532\n", want: "line1\nline2"},
+	}
+	for _, tt := range tests {
+		if got := decodeSecurityOutput(tt.out); got != tt.want {
+			t.Errorf("decodeSecurityOutput(%q) = %q, want %q", tt.out, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package cfx
+
+import (
+	"context"
+	"errors"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	_procCredReadW   = _advapi32.NewProc("CredReadW")
+	_procCredWriteW  = _advapi32.NewProc("CredWriteW")
+	_procCredDeleteW = _advapi32.NewProc("CredDeleteW")
+	_procCredFree    = _advapi32.NewProc("CredFree")
+)
+
+const (
+	_credTypeGeneric         = 1
+	_credPersistLocalMachine = 2
+	_credMaxBlobSize         = 5 * 512
+	_errorNotFound           = syscall.Errno(1168)
+)
+
+// credential mirrors CREDENTIALW.
+type credential struct {
+	Flags              uint32
+	Type               uint32
+	TargetName         *uint16
+	Comment            *uint16
+	LastWritten        syscall.Filetime
+	CredentialBlobSize uint32
+	CredentialBlob     *byte
+	Persist            uint32
+	AttributeCount     uint32
+	Attributes         uintptr
+	TargetAlias        *uint16
+	UserName           *uint16
+}
+
+// credentialTarget is the Credential Manager target name of a service and account.
+func credentialTarget(service, account string) (*uint16, error) {
+	return syscall.UTF16PtrFromString(service + ":" + account)
+}
+
+func keychainGet(_ context.Context, service, account string) (string, error) {
+	target, err := credentialTarget(service, account)
+	if err != nil {
+		return "", err
+	}
+	var cred *credential
+	if r, _, err := _procCredReadW.Call(uintptr(unsafe.Pointer(target)), _credTypeGeneric, 0, uintptr(unsafe.Pointer(&cred))); r == 0 {
+		if err == _errorNotFound {
+			return "", ErrKeychainNotFound
+		}
+		return "", err
+	}
+	defer _procCredFree.Call(uintptr(unsafe.Pointer(cred)))
+
+	if cred.CredentialBlobSize == 0 {
+		return "", nil
+	}
+	blob := (*[_credMaxBlobSize]byte)(unsafe.Pointer(cred.CredentialBlob))[:cred.CredentialBlobSize:cred.CredentialBlobSize]
+	return string(blob), nil
+}
+
+func keychainSet(_ context.Context, service, account, secret string) error {
+	if len(secret) > _credMaxBlobSize {
+		return errors.New("secret is larger than the 2560 bytes the Credential Manager can store")
+	}
+	target, err := credentialTarget(service, account)
+	if err != nil {
+		return err
+	}
+	user, err := syscall.UTF16PtrFromString(account)
+	if err != nil {
+		return err
+	}
+	cred := credential{
+		Type:               _credTypeGeneric,
+		TargetName:         target,
+		CredentialBlobSize: uint32(len(secret)),
+		Persist:            _credPersistLocalMachine,
+		UserName:           user,
+	}
+	if len(secret) > 0 {
+		blob := []byte(secret)
+		cred.CredentialBlob = &blob[0]
+	}
+	if r, _, err := _procCredWriteW.Call(uintptr(unsafe.Pointer(&cred)), 0); r == 0 {
+		return err
+	}
+	return nil
+}
+
+func keychainDelete(_ context.Context, service, account string) error {
+	target, err := credentialTarget(service, account)
+	if err != nil {
+		return err
+	}
+	if r, _, err := _procCredDeleteW.Call(uintptr(unsafe.Pointer(target)), _credTypeGeneric, 0); r == 0 {
+		if err == _errorNotFound {
+			return ErrKeychainNotFound
+		}
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,295 @@
+package cfx
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"go.uber.org/config"
+)
+
+// WithLazySections defers parsing the given top level sections until they are first populated,
+// so services with very large sections, such as rule sets, do not pay to parse the ones they
+// never read. Lazy sections are split out of every source textually and merged across sources
+// when read. They are meant for large, static data: they do not take part in rollouts, !expr,
+// read-time values, key hierarchy overlays, transformers or section change notifications, and
+// features that need the whole tree, such as last known good and replay files, parse them.
+// Sources using YAML aliases, flow style or multiple documents are parsed eagerly. A key of "*"
+// makes every section lazy, which shortens cold starts on serverless platforms for functions
+// that use none of those features.
+func WithLazySections(keys ...string) Option {
+	return func(o *options) {
+		if o.lazySections == nil {
+			o.lazySections = map[string]bool{}
+		}
+		for _, k := range keys {
+			o.lazySections[k] = true
+		}
+	}
+}
+
+// _allSections is the lazy section key standing for every top level section.
+const _allSections = "*"
+
+// lazySection is a top level section whose YAML is parsed on first use.
+type lazySection struct {
+	key       string
+	fragments [][]byte
+
+	once sync.Once
+	cfg  *config.YAML
+	tree interface{}
+	err  error
+}
+
+func (l *lazySection) load() (*config.YAML, interface{}, error) {
+	l.once.Do(func() {
+		opts := make([]config.YAMLOption, 0, len(l.fragments))
+		for _, f := range l.fragments {
+			opts = append(opts, config.Source(bytes.NewReader(f)))
+		}
+		l.cfg, l.err = config.NewYAML(opts...)
+		if l.err != nil {
+			l.err = fmt.Errorf("could not parse lazy section %s: %v", l.key, l.err)
+			return
+		}
+
+		var raw interface{}
+		if err := l.cfg.Get(l.key).Populate(&raw); err != nil {
+			l.err = fmt.Errorf("could not parse lazy section %s: %v", l.key, err)
+			return
+		}
+		l.tree = normalizeValue(raw)
+	})
+	return l.cfg, l.tree, l.err
+}
+
+// lazyState holds the lazy sections of a snapshot, and the complete tree once it is needed.
+type lazyState struct {
+	sections map[string]*lazySection
+
+	fullOnce sync.Once
+	full     map[string]interface{}
+	fullErr  error
+
+	cfgOnce sync.Once
+	cfg     *config.YAML
+	cfgErr  error
+}
+
+// splitLazySources moves the lazy sections out of every source. If any source cannot be split
+// safely, the sources are returned unchanged so that merge order is preserved.
+func splitLazySources(keys map[string]bool, sources [][]byte) ([][]byte, *lazyState) {
+	if len(keys) == 0 {
+		return sources, nil
+	}
+
+	rest := make([][]byte, len(sources))
+	lazy := map[string]*lazySection{}
+	for i, data := range sources {
+		r, sections, ok := splitLazySections(data, keys)
+		if !ok {
+			return sources, nil
+		}
+		rest[i] = r
+		for _, s := range sections {
+			l, exists := lazy[s.key]
+			if !exists {
+				l = &lazySection{key: s.key}
+				lazy[s.key] = l
+			}
+			l.fragments = append(l.fragments, s.data)
+		}
+	}
+	if len(lazy) == 0 {
+		return sources, nil
+	}
+	return rest, &lazyState{sections: lazy}
+}
+
+type lazyFragment struct {
+	key  string
+	data []byte
+}
+
+// splitLazySections splits the top level sections named in keys out of a block style YAML
+// document. It reports false if the document cannot be split without parsing it.
+func splitLazySections(data []byte, keys map[string]bool) ([]byte, []lazyFragment, bool) {
+	if bytes.IndexByte(data, '*') >= 0 && _yamlAlias.Match(data) {
+		return nil, nil, false
+	}
+
+	rest := make([]byte, 0, len(data))
+	var sections []lazyFragment
+	cur := -1
+	content := false
+
+	for off := 0; off < len(data); {
+		end := bytes.IndexByte(data[off:], '\n')
+		if end < 0 {
+			end = len(data)
+		} else {
+			end += off + 1
+		}
+		line := data[off:end]
+		off = end
+
+		// indented lines, blank lines and comments belong to the current section
+		l := bytes.TrimRight(line, "\r\n")
+		if len(l) > 0 && l[0] != ' ' && l[0] != '\t' && l[0] != '#' {
+			switch {
+			case bytes.HasPrefix(l, []byte("---")) || bytes.HasPrefix(l, []byte("...")):
+				if content {
+					return nil, nil, false
+				}
+			case bytes.IndexByte([]byte("-{[%?&!|>"), l[0]) >= 0:
+				return nil, nil, false
+			default:
+				key, ok := topLevelKey(string(l))
+				if !ok {
+					return nil, nil, false
+				}
+				content = true
+				cur = -1
+				if keys[key] || keys[_allSections] {
+					cur = len(sections)
+					sections = append(sections, lazyFragment{key: key})
+				}
+			}
+		}
+
+		if cur >= 0 {
+			sections[cur].data = append(sections[cur].data, line...)
+		} else {
+			rest = append(rest, line...)
+		}
+	}
+
+	// make sure every fragment ends with a newline
+	for i := range sections {
+		if d := sections[i].data; len(d) > 0 && d[len(d)-1] != '\n' {
+			sections[i].data = append(d, '\n')
+		}
+	}
+	return rest, sections, true
+}
+
+// topLevelKey returns the key of a top level "key:" line.
+func topLevelKey(line string) (string, bool) {
+	if line[0] == '"' || line[0] == '\'' {
+		end := strings.IndexByte(line[1:], line[0])
+		if end < 0 || !strings.HasPrefix(line[end+2:], ":") {
+			return "", false
+		}
+		return line[1 : end+1], true
+	}
+
+	i := strings.Index(line, ":")
+	if i <= 0 {
+		return "", false
+	}
+	if i+1 < len(line) && line[i+1] != ' ' && line[i+1] != '\t' {
+		return "", false
+	}
+	return strings.TrimSpace(line[:i]), true
+}
+
+// lazySectionFor returns the lazy section holding key, if any.
+func (s *snapshot) lazySectionFor(key string) *lazySection {
+	if s.lazy == nil || key == "" {
+		return nil
+	}
+	if i := strings.IndexByte(key, '.'); i >= 0 {
+		key = key[:i]
+	}
+	return s.lazy.sections[key]
+}
+
+// materialized returns the complete tree, parsing any lazy sections.
+func (s *snapshot) materialized() (map[string]interface{}, error) {
+	if s.lazy == nil {
+		return s.tree, nil
+	}
+
+	l := s.lazy
+	l.fullOnce.Do(func() {
+		tree := make(map[string]interface{}, len(s.tree)+len(l.sections))
+		for k, v := range s.tree {
+			tree[k] = v
+		}
+		for k, sec := range l.sections {
+			_, sub, err := sec.load()
+			if err != nil {
+				l.fullErr = err
+				return
+			}
+			tree[k] = mergeTrees(tree[k], sub)
+		}
+		l.full = tree
+	})
+	return l.full, l.fullErr
+}
+
+// rootProvider returns a provider over the complete tree, for reads of the root.
+func (s *snapshot) rootProvider() (*config.YAML, error) {
+	if s.lazy == nil {
+		return s.cfg, nil
+	}
+
+	tree, err := s.materialized()
+	if err != nil {
+		return nil, err
+	}
+	l := s.lazy
+	l.cfgOnce.Do(func() {
+		l.cfg, l.cfgErr = config.NewYAML(config.Static(tree))
+	})
+	return l.cfg, l.cfgErr
+}
+
+// mergeTrees merges override into base the way YAML sources are merged: maps are merged
+// recursively and any other value replaces the previous one.
+func mergeTrees(base, override interface{}) interface{} {
+	bm, ok := base.(map[string]interface{})
+	om, ok2 := override.(map[string]interface{})
+	if !ok || !ok2 {
+		if override == nil {
+			return base
+		}
+		return override
+	}
+
+	ret := make(map[string]interface{}, len(bm)+len(om))
+	for k, v := range bm {
+		ret[k] = v
+	}
+	for k, v := range om {
+		ret[k] = mergeTrees(ret[k], v)
+	}
+	return ret
+}
+
+// lazyFingerprint folds the content of the lazy sections into the fingerprint of the eager
+// tree, so that changes to them are detected without parsing them.
+func lazyFingerprint(fp string, lazy *lazyState) string {
+	keys := make([]string, 0, len(lazy.sections))
+	for k := range lazy.sections {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	h := sha256.New()
+	h.Write([]byte(fp))
+	for _, k := range keys {
+		fmt.Fprintf(h, "\x00%s", k)
+		for _, f := range lazy.sections[k].fragments {
+			fmt.Fprintf(h, "\x00%d\x00", len(f))
+			h.Write(f)
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,197 @@
+package cfx
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// DefaultSecretTTL is how long a Secret value is cached when its resolver does not report a lease.
+var DefaultSecretTTL = 5 * time.Minute
+
+// Secret is a configuration field that references a secret as "scheme:path", like SecretRef,
+// but is resolved lazily. Nothing is fetched when the configuration is loaded; the first call to
+// Value fetches the secret and caches it until its lease expires, so a service can start while a
+// secret backend it only needs later is unreachable.
+//
+//	type Config struct {
+//		APIKey cfx.Secret `yaml:"api_key"`
+//	}
+type Secret string
+
+// SecretLease is a secret value along with how long it may be cached.
+type SecretLease struct {
+	// Value is the secret value.
+	Value string
+
+	// TTL is how long the value may be cached. Zero means DefaultSecretTTL.
+	TTL time.Duration
+}
+
+// SecretLeaseResolver is optionally implemented by a SecretResolver whose backend issues leases,
+// such as Vault. The lease TTL decides how long a Secret caches the value.
+type SecretLeaseResolver interface {
+	ResolveSecretLease(ctx context.Context, path string) (SecretLease, error)
+}
+
+// Ref returns the reference the secret was configured with.
+func (s Secret) Ref() SecretRef {
+	return SecretRef(s)
+}
+
+// IsZero reports whether the secret is unset.
+func (s Secret) IsZero() bool {
+	return s == ""
+}
+
+// String implements the fmt.Stringer interface. It never includes the secret value.
+func (s Secret) String() string {
+	return string(s)
+}
+
+// Value returns the secret value, fetching it if it is not cached or its lease has expired.
+func (s Secret) Value(ctx context.Context) (string, error) {
+	return _secretCache.get(ctx, s.Ref(), false)
+}
+
+// Refresh fetches the secret value from its backend, bypassing the cache.
+func (s Secret) Refresh(ctx context.Context) (string, error) {
+	return _secretCache.get(ctx, s.Ref(), true)
+}
+
+// resolveLease resolves the reference, using the resolver's lease when it provides one.
+// Unless bypassCache is set, the scheme's Cache is consulted first.
+func (s SecretRef) resolveLease(ctx context.Context, bypassCache bool) (SecretLease, error) {
+	if err := ctx.Err(); err != nil {
+		return SecretLease{}, err
+	}
+
+	scheme, path, err := s.Split()
+	if err != nil {
+		return SecretLease{}, err
+	}
+
+	r, ok := lookupSecretResolver(scheme)
+	if !ok {
+		return SecretLease{}, fmt.Errorf("no secret resolver registered for scheme %q", scheme)
+	}
+	if err := checkSecretScheme(scheme); err != nil {
+		return SecretLease{}, fmt.Errorf("could not resolve secret %s: %v", s, err)
+	}
+
+	cache, cached := lookupSecretCache(scheme)
+	cacheKey := "secret:" + string(s)
+	if cached && !bypassCache {
+		if val, ok, err := cache.Get(ctx, cacheKey); err == nil && ok {
+			return SecretLease{Value: string(val)}, nil
+		}
+	}
+
+	var lease SecretLease
+	if lr, ok := r.(SecretLeaseResolver); ok {
+		lease, err = lr.ResolveSecretLease(ctx, path)
+	} else {
+		lease.Value, err = r.ResolveSecret(ctx, path)
+	}
+	if err != nil {
+		return SecretLease{}, fmt.Errorf("could not resolve secret %s: %v", s, err)
+	}
+
+	if cached {
+		ttl := lease.TTL
+		if ttl <= 0 {
+			ttl = DefaultSecretTTL
+		}
+		// a cache failure only costs another fetch later
+		_ = cache.Set(ctx, cacheKey, []byte(lease.Value), ttl)
+	}
+
+	return lease, nil
+}
+
+type cachedSecret struct {
+	// mu serializes fetches of a single secret so concurrent callers share one request.
+	mu      sync.Mutex
+	value   string
+	ttl     time.Duration
+	expires time.Time
+	valid   bool
+}
+
+// secretCache holds lazily resolved Secret values, keyed by reference.
+type secretCache struct {
+	sync.Mutex
+	entries map[SecretRef]*cachedSecret
+	now     func() time.Time
+
+	// subs and watchers track rotation subscriptions, see OnSecretRotated.
+	subs     map[SecretRef][]*rotationSub
+	watchers map[SecretRef]chan struct{}
+}
+
+var _secretCache = &secretCache{
+	entries:  map[SecretRef]*cachedSecret{},
+	now:      time.Now,
+	subs:     map[SecretRef][]*rotationSub{},
+	watchers: map[SecretRef]chan struct{}{},
+}
+
+func (c *secretCache) entry(ref SecretRef) *cachedSecret {
+	c.Lock()
+	defer c.Unlock()
+	e, ok := c.entries[ref]
+	if !ok {
+		e = &cachedSecret{}
+		c.entries[ref] = e
+	}
+	return e
+}
+
+func (c *secretCache) get(ctx context.Context, ref SecretRef, refresh bool) (string, error) {
+	val, old, rotated, err := c.fetch(ctx, ref, refresh)
+	if err != nil {
+		return "", err
+	}
+
+	// subscribers are notified outside the entry lock so they may read the secret again
+	if rotated {
+		c.notifyRotated(ref, old, val)
+	}
+
+	return val, nil
+}
+
+// fetch returns the cached value of ref, resolving it if needed, along with the previous
+// value when resolving it changed the value.
+func (c *secretCache) fetch(ctx context.Context, ref SecretRef, refresh bool) (string, string, bool, error) {
+	e := c.entry(ref)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if !refresh && e.valid && c.now().Before(e.expires) {
+		return e.value, "", false, nil
+	}
+
+	lease, err := ref.resolveLease(ctx, refresh)
+	if err != nil {
+		return "", "", false, err
+	}
+
+	ttl := lease.TTL
+	if ttl <= 0 {
+		ttl = DefaultSecretTTL
+	}
+
+	old, rotated := e.value, e.valid && e.value != lease.Value
+	e.value, e.ttl, e.expires, e.valid = lease.Value, ttl, c.now().Add(ttl), true
+
+	return e.value, old, rotated, nil
+}
+
+// wipe drops every cached secret value.
+func (c *secretCache) wipe() {
+	c.Lock()
+	defer c.Unlock()
+	c.entries = map[SecretRef]*cachedSecret{}
+}
@@ -0,0 +1,91 @@
+package cfx
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+// LeaderOnlyKey is the YAML key that marks a value as only applying to the cluster leader.
+// Followers read the fallback instead; if no fallback is defined the key is unset on followers.
+//
+//	cleanup_enabled:
+//	  cfx:leader_only:
+//	    value: true
+//	    fallback: false
+//
+// The cfx: prefix keeps ordinary configuration with a "leader_only" key from being taken for a
+// stanza.
+const LeaderOnlyKey = "cfx:leader_only"
+
+// LeaderElector is implemented by the application's leader election mechanism so that
+// leader_only values can be evaluated when they are read.
+type LeaderElector interface {
+	IsLeader() bool
+}
+
+// LeaderElectorFunc is an adapter to allow the use of ordinary functions as a LeaderElector.
+type LeaderElectorFunc func() bool
+
+// IsLeader implements the LeaderElector interface.
+func (f LeaderElectorFunc) IsLeader() bool {
+	return f()
+}
+
+// LeaderHint is a LeaderElector whose state is set by the application, typically from the
+// callbacks of an election library.
+type LeaderHint struct {
+	leader int32
+}
+
+// NewLeaderHint creates a LeaderHint that starts as a follower.
+func NewLeaderHint() *LeaderHint {
+	return &LeaderHint{}
+}
+
+// SetLeader records whether this instance currently holds leadership.
+func (l *LeaderHint) SetLeader(leader bool) {
+	var v int32
+	if leader {
+		v = 1
+	}
+	atomic.StoreInt32(&l.leader, v)
+}
+
+// IsLeader implements the LeaderElector interface.
+func (l *LeaderHint) IsLeader() bool {
+	return atomic.LoadInt32(&l.leader) == 1
+}
+
+// neverLeader is used when no election is configured, so that a missing elector makes every
+// instance read the fallback rather than all of them acting as the leader.
+var neverLeader = LeaderElectorFunc(func() bool { return false })
+
+// WithLeaderElection provides the hint used to evaluate leader_only values.
+// Without it, every instance is considered a follower. Single instance deployments that want
+// the leader values can pass LeaderElectorFunc(func() bool { return true }).
+func WithLeaderElection(l LeaderElector) Option {
+	return func(o *options) {
+		if l != nil {
+			o.leader = l
+		}
+	}
+}
+
+func evalLeaderOnly(rc readContext, key string, stanza map[string]interface{}) (interface{}, bool, error) {
+	val, ok := stanza["value"]
+	if !ok {
+		return nil, false, fmt.Errorf("leader_only value %s must define a value", key)
+	}
+	for k := range stanza {
+		if k != "value" && k != "fallback" {
+			return nil, false, fmt.Errorf("leader_only value %s has unknown field %s", key, k)
+		}
+	}
+
+	if rc.leader {
+		return val, true, nil
+	}
+
+	fallback, ok := stanza["fallback"]
+	return fallback, ok, nil
+}
@@ -0,0 +1,52 @@
+package cfx
+
+import "testing"
+
+func TestLeaderOnlyNeedsMarker(t *testing.T) {
+	dir := testDir(t)
+	writeTestConfig(t, dir, "production.yaml", "jobs:\n  leader_only:\n    name: cleanup\n  cleanup_enabled:\n    cfx:leader_only:\n      value: true\n      fallback: false\n")
+
+	c, err := NewConfigWithOptions(EnvContext{ConfigPath: dir, Environment: "production"}, WithLeaderElection(LeaderElectorFunc(func() bool { return true })))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var jobs struct {
+		LeaderOnly struct {
+			Name string `yaml:"name"`
+		} `yaml:"leader_only"`
+		CleanupEnabled bool `yaml:"cleanup_enabled"`
+	}
+	if err := c.Populate("jobs", &jobs); err != nil {
+		t.Fatal(err)
+	}
+	if jobs.LeaderOnly.Name != "cleanup" {
+		t.Errorf("jobs.leader_only.name = %q, want the plain key kept as data", jobs.LeaderOnly.Name)
+	}
+	if !jobs.CleanupEnabled {
+		t.Error("jobs.cleanup_enabled is false on the leader")
+	}
+}
+
+func TestLeaderOnlyDefaultsToFollower(t *testing.T) {
+	dir := testDir(t)
+	writeTestConfig(t, dir, "production.yaml", "jobs:\n  cleanup_enabled:\n    cfx:leader_only:\n      value: true\n      fallback: false\n  rebalance:\n    cfx:leader_only:\n      value: true\n")
+
+	c, err := NewConfig(EnvContext{ConfigPath: dir, Environment: "production"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var jobs struct {
+		CleanupEnabled *bool `yaml:"cleanup_enabled"`
+		Rebalance      *bool `yaml:"rebalance"`
+	}
+	if err := c.Populate("jobs", &jobs); err != nil {
+		t.Fatal(err)
+	}
+	if jobs.CleanupEnabled == nil || *jobs.CleanupEnabled {
+		t.Errorf("jobs.cleanup_enabled = %v without an elector, want the fallback", jobs.CleanupEnabled)
+	}
+	if jobs.Rebalance != nil {
+		t.Errorf("jobs.rebalance = %v without an elector, want it unset", *jobs.Rebalance)
+	}
+}
@@ -0,0 +1,47 @@
+package cfx
+
+import (
+	"strings"
+)
+
+// LibrariesKey is the top level YAML key reserved for third-party library configuration.
+// Applications should not define their own configuration beneath it.
+const LibrariesKey = "libraries"
+
+// LibrarySection maps a library's import path to its reserved configuration key.
+// The leading host element is dropped and the remainder is lowercased with every
+// non alpha-numeric character replaced by an underscore, so "github.com/foo/bar"
+// maps to "libraries.foo_bar".
+func LibrarySection(importPath string) string {
+	return joinKey(LibrariesKey, libraryName(importPath))
+}
+
+// PopulateLibrary populates target from the reserved configuration section of the library
+// identified by importPath.
+func PopulateLibrary(c Container, importPath string, target interface{}) error {
+	return c.Populate(LibrarySection(importPath), target)
+}
+
+func libraryName(importPath string) string {
+	parts := strings.Split(strings.Trim(importPath, "/"), "/")
+	if len(parts) > 1 && strings.Contains(parts[0], ".") {
+		parts = parts[1:]
+	}
+
+	name := strings.ToLower(strings.Join(parts, "/"))
+	var sb strings.Builder
+	lastUnderscore := false
+	for _, c := range name {
+		if ('a' <= c && c <= 'z') || ('0' <= c && c <= '9') {
+			sb.WriteRune(c)
+			lastUnderscore = false
+			continue
+		}
+		if !lastUnderscore {
+			sb.WriteRune('_')
+			lastUnderscore = true
+		}
+	}
+
+	return strings.Trim(sb.String(), "_")
+}
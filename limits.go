@@ -0,0 +1,112 @@
+package cfx
+
+import (
+	"fmt"
+	"regexp"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Limits protects services that load configuration from semi-trusted sources against
+// oversized or maliciously crafted YAML. A zero value for any field disables that limit.
+type Limits struct {
+	// MaxFileSize is the maximum size in bytes of a single configuration source.
+	MaxFileSize int64
+
+	// MaxDepth is the maximum nesting depth of maps and lists in a single source.
+	MaxDepth int
+
+	// MaxAliases is the maximum number of alias references (*name) in a single source.
+	MaxAliases int
+
+	// MaxKeys is the maximum number of map keys in a single source, counted after aliases are expanded.
+	MaxKeys int
+}
+
+// DefaultLimits returns conservative limits suitable for configuration from semi-trusted sources.
+func DefaultLimits() Limits {
+	return Limits{
+		MaxFileSize: 4 << 20,
+		MaxDepth:    32,
+		MaxAliases:  100,
+		MaxKeys:     50000,
+	}
+}
+
+// WithLimits applies size and complexity limits to every configuration source.
+func WithLimits(l Limits) Option {
+	return func(o *options) {
+		o.limits = l
+	}
+}
+
+func (l Limits) enabled() bool {
+	return l.MaxFileSize > 0 || l.MaxDepth > 0 || l.MaxAliases > 0 || l.MaxKeys > 0
+}
+
+// _yamlAlias matches alias references outside of quoted scalars closely enough to bound
+// alias expansion before the document is decoded.
+var _yamlAlias = regexp.MustCompile(`(?m)(?:^|[\s\[{,:-])\*[^\s,\[\]{}]+`)
+
+// check enforces the limits against a single source.
+func (l Limits) check(src configSource) error {
+	if !l.enabled() {
+		return nil
+	}
+
+	if l.MaxFileSize > 0 && int64(len(src.data)) > l.MaxFileSize {
+		return fmt.Errorf("config source %s exceeds the maximum size of %d bytes", src.name, l.MaxFileSize)
+	}
+
+	if l.MaxAliases > 0 {
+		if n := len(_yamlAlias.FindAllIndex(src.data, -1)); n > l.MaxAliases {
+			return fmt.Errorf("config source %s contains %d alias references, the maximum is %d", src.name, n, l.MaxAliases)
+		}
+	}
+
+	if l.MaxDepth == 0 && l.MaxKeys == 0 {
+		return nil
+	}
+
+	var doc interface{}
+	if err := yaml.Unmarshal(src.data, &doc); err != nil {
+		return fmt.Errorf("could not parse config source %s: %v", src.name, err)
+	}
+
+	keys := 0
+	if err := l.walk(src.name, doc, 1, &keys); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (l Limits) walk(name string, v interface{}, depth int, keys *int) error {
+	var children []interface{}
+	switch t := v.(type) {
+	case map[interface{}]interface{}:
+		*keys += len(t)
+		for _, val := range t {
+			children = append(children, val)
+		}
+	case []interface{}:
+		children = t
+	default:
+		return nil
+	}
+
+	if l.MaxDepth > 0 && depth > l.MaxDepth {
+		return fmt.Errorf("config source %s is nested deeper than the maximum depth of %d", name, l.MaxDepth)
+	}
+	if l.MaxKeys > 0 && *keys > l.MaxKeys {
+		return fmt.Errorf("config source %s contains more than the maximum of %d keys", name, l.MaxKeys)
+	}
+
+	for _, c := range children {
+		if err := l.walk(name, c, depth+1, keys); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,229 @@
+package cfx
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"go.uber.org/config"
+)
+
+// Key naming rules checked by CheckNaming.
+const (
+	LintSnakeCase         = "snake_case"
+	LintMaxDepth          = "max_depth"
+	LintSemanticDuplicate = "semantic_duplicate"
+)
+
+// DefaultUnitSuffixes are the suffixes stripped from sibling keys to find keys that configure
+// the same thing, such as timeout and timeout_seconds.
+var DefaultUnitSuffixes = []string{
+	"_ns", "_us", "_ms", "_millis", "_milliseconds",
+	"_s", "_sec", "_secs", "_seconds",
+	"_min", "_mins", "_minutes",
+	"_hours", "_days", "_duration",
+	"_bytes", "_kb", "_mb", "_gb",
+	"_count", "_num", "_pct", "_percent",
+}
+
+var _snakeCaseKey = regexp.MustCompile(`^[a-z][a-z0-9]*(_[a-z0-9]+)*$`)
+
+// LintConfig configures the key naming rules checked by CheckNaming. It can be shared across
+// services as a section of its own:
+//
+//	lint:
+//	  snake_case: true
+//	  max_depth: 5
+//	  semantic_duplicates: true
+//	  ignore: ["feature_flags.*"]
+type LintConfig struct {
+	// SnakeCase requires every key to be lower case words separated by underscores.
+	SnakeCase bool `json:"snake_case,omitempty" yaml:"snake_case,omitempty" mapstructure:"snake_case,omitempty"`
+
+	// MaxDepth is the deepest a key may be nested, counting the top level as one. Zero means
+	// unlimited.
+	MaxDepth int `json:"max_depth,omitempty" yaml:"max_depth,omitempty" mapstructure:"max_depth,omitempty"`
+
+	// SemanticDuplicates rejects sibling keys that only differ by a unit suffix, such as
+	// timeout and timeout_seconds.
+	SemanticDuplicates bool `json:"semantic_duplicates,omitempty" yaml:"semantic_duplicates,omitempty" mapstructure:"semantic_duplicates,omitempty"`
+
+	// UnitSuffixes overrides DefaultUnitSuffixes for SemanticDuplicates.
+	UnitSuffixes []string `json:"unit_suffixes,omitempty" yaml:"unit_suffixes,omitempty" mapstructure:"unit_suffixes,omitempty"`
+
+	// Ignore lists dotted key patterns exempt from every rule, along with everything below
+	// them. A segment of "*" matches any single key, so "feature_flags.*" exempts the names of
+	// individual flags, which are data rather than configuration keys.
+	Ignore []string `json:"ignore,omitempty" yaml:"ignore,omitempty" mapstructure:"ignore,omitempty"`
+}
+
+// DefaultLintConfig returns a LintConfig enabling every rule, with keys at most six levels
+// deep.
+func DefaultLintConfig() LintConfig {
+	return LintConfig{SnakeCase: true, MaxDepth: 6, SemanticDuplicates: true}
+}
+
+// LintFinding describes a single key breaking a naming rule.
+type LintFinding struct {
+	// Key is the dotted path of the offending key, e.g. "http.readTimeout".
+	Key string `json:"key" yaml:"key"`
+
+	// Rule is the broken rule, e.g. LintSnakeCase.
+	Rule string `json:"rule" yaml:"rule"`
+
+	// Message explains the finding.
+	Message string `json:"message" yaml:"message"`
+}
+
+// Error implements the error interface.
+func (f LintFinding) Error() string {
+	return fmt.Sprintf("%s breaks %s: %s", f.Key, f.Rule, f.Message)
+}
+
+// LintFindings aggregates every finding of CheckNaming, sorted by key.
+type LintFindings []LintFinding
+
+// Error implements the error interface.
+func (l LintFindings) Error() string {
+	msgs := make([]string, len(l))
+	for i, f := range l {
+		msgs[i] = f.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// CheckNaming checks every key of the configuration held by c against the rules of cfg, and
+// returns LintFindings listing every key that breaks one. Run it in CI or at startup to keep
+// config trees consistent across services:
+//
+//	if err := cfx.CheckNaming(c, cfx.DefaultLintConfig()); err != nil {
+//		log.Fatal(err)
+//	}
+func CheckNaming(c Container, cfg LintConfig) error {
+	var raw interface{}
+	if err := c.Populate(config.Root, &raw); err != nil {
+		return fmt.Errorf("could not read configuration: %v", err)
+	}
+	tree, _ := normalizeValue(raw).(map[string]interface{})
+	if findings := LintTree(tree, cfg); len(findings) > 0 {
+		return findings
+	}
+	return nil
+}
+
+// LintTree checks every key of tree against the rules of cfg, and returns the findings sorted
+// by key.
+func LintTree(tree map[string]interface{}, cfg LintConfig) LintFindings {
+	suffixes := cfg.UnitSuffixes
+	if suffixes == nil {
+		suffixes = DefaultUnitSuffixes
+	}
+	// longest first, so that _seconds is stripped rather than _s
+	sorted := append([]string(nil), suffixes...)
+	sort.Slice(sorted, func(i, j int) bool { return len(sorted[i]) > len(sorted[j]) })
+
+	l := &linter{cfg: cfg, suffixes: sorted}
+	l.lint("", 0, tree)
+	sort.SliceStable(l.findings, func(i, j int) bool { return l.findings[i].Key < l.findings[j].Key })
+	return l.findings
+}
+
+type linter struct {
+	cfg      LintConfig
+	suffixes []string
+	findings LintFindings
+}
+
+func (l *linter) add(key, rule, msg string) {
+	l.findings = append(l.findings, LintFinding{Key: key, Rule: rule, Message: msg})
+}
+
+func (l *linter) lint(prefix string, depth int, v interface{}) {
+	switch val := v.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(val))
+		for k := range val {
+			if !l.ignored(joinKey(prefix, k)) {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		if l.cfg.SemanticDuplicates {
+			l.lintDuplicates(prefix, keys)
+		}
+		for _, k := range keys {
+			key := joinKey(prefix, k)
+			if l.cfg.SnakeCase && !_snakeCaseKey.MatchString(k) {
+				l.add(key, LintSnakeCase, fmt.Sprintf("%q is not snake_case", k))
+			}
+			if l.cfg.MaxDepth > 0 && depth+1 > l.cfg.MaxDepth {
+				l.add(key, LintMaxDepth, fmt.Sprintf("nested %d levels deep, at most %d allowed", depth+1, l.cfg.MaxDepth))
+				continue // one finding per subtree is enough
+			}
+			l.lint(key, depth+1, val[k])
+		}
+	case []interface{}:
+		// list elements do not add a level of nesting
+		for i, e := range val {
+			l.lint(joinKey(prefix, strconv.Itoa(i)), depth, e)
+		}
+	}
+}
+
+// lintDuplicates reports sibling keys that share a stem once unit suffixes are stripped.
+func (l *linter) lintDuplicates(prefix string, keys []string) {
+	stems := map[string][]string{}
+	var order []string
+	for _, k := range keys {
+		stem := l.stem(k)
+		if _, ok := stems[stem]; !ok {
+			order = append(order, stem)
+		}
+		stems[stem] = append(stems[stem], k)
+	}
+	for _, stem := range order {
+		group := stems[stem]
+		if len(group) < 2 {
+			continue
+		}
+		for _, k := range group[1:] {
+			l.add(joinKey(prefix, k), LintSemanticDuplicate,
+				fmt.Sprintf("%q and %q both configure %s", group[0], k, stem))
+		}
+	}
+}
+
+func (l *linter) stem(key string) string {
+	lower := strings.ToLower(key)
+	for _, s := range l.suffixes {
+		if strings.HasSuffix(lower, s) && len(lower) > len(s) {
+			return lower[:len(lower)-len(s)]
+		}
+	}
+	return lower
+}
+
+// ignored reports whether key, or one of its parents, matches an Ignore pattern.
+func (l *linter) ignored(key string) bool {
+	segs := strings.Split(key, ".")
+	for _, p := range l.cfg.Ignore {
+		psegs := strings.Split(p, ".")
+		if len(psegs) > len(segs) {
+			continue
+		}
+		match := true
+		for i, ps := range psegs {
+			if ok, err := path.Match(ps, segs[i]); err != nil || !ok {
+				match = false
+				break
+			}
+		}
+		if match {
+			return true
+		}
+	}
+	return false
+}
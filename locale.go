@@ -0,0 +1,204 @@
+package cfx
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"go.uber.org/fx"
+)
+
+// LocaleKey is the config section read by NewLocaleContext.
+const LocaleKey = "locale"
+
+// LocaleModule provides a LocaleContext combining the system locale with the "locale" section.
+var LocaleModule = fx.Options(
+	ProvideSection(LocaleKey, &LocaleConfig{}),
+	fx.Provide(NewLocaleContext),
+)
+
+// LocaleConfig is the configuration section for localization.
+type LocaleConfig struct {
+	// Default is the locale used when none of the requested locales are supported.
+	// Defaults to the system locale, or "en" if it cannot be detected.
+	Default string `json:"default,omitempty" yaml:"default,omitempty" mapstructure:"default,omitempty"`
+
+	// Supported lists the locales the service can produce output in, e.g. ["en-US", "fr"].
+	Supported []string `json:"supported,omitempty" yaml:"supported,omitempty" mapstructure:"supported,omitempty"`
+}
+
+// Validate implements the cfx.Validator interface.
+func (l LocaleConfig) Validate() error {
+	for _, tag := range append([]string{l.Default}, l.Supported...) {
+		if strings.ContainsAny(tag, " \t/") {
+			return fmt.Errorf("locale %q is not a valid language tag", tag)
+		}
+	}
+	if l.Default != "" && len(l.Supported) > 0 && !containsLocale(l.Supported, NormalizeLocale(l.Default)) {
+		return errors.New("locale default must be one of the supported locales")
+	}
+	return nil
+}
+
+// LocaleContext holds the locales available to the application, as BCP 47 style tags.
+type LocaleContext struct {
+	// System is the locale of the process environment, from LC_ALL, LC_MESSAGES or LANG.
+	System string `json:"system,omitempty" yaml:"system,omitempty" mapstructure:"system,omitempty"`
+
+	// Default is the configured default locale.
+	Default string `json:"default,omitempty" yaml:"default,omitempty" mapstructure:"default,omitempty"`
+
+	// Supported are the configured supported locales.
+	Supported []string `json:"supported,omitempty" yaml:"supported,omitempty" mapstructure:"supported,omitempty"`
+}
+
+// NewLocaleContext combines the system locale of env with the "locale" section of c.
+func NewLocaleContext(env EnvContext, c Container) (LocaleContext, error) {
+	cfg := LocaleConfig{}
+	if err := c.Populate(LocaleKey, &cfg); err != nil {
+		return LocaleContext{}, fmt.Errorf("could not populate locale config: %v", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		return LocaleContext{}, SectionError{Key: LocaleKey, Err: err}
+	}
+
+	ret := LocaleContext{System: env.Locale.System}
+	if ret.System == "" {
+		ret.System = DetectSystemLocale()
+	}
+	for _, tag := range cfg.Supported {
+		ret.Supported = append(ret.Supported, NormalizeLocale(tag))
+	}
+
+	ret.Default = NormalizeLocale(cfg.Default)
+	if ret.Default == "" {
+		ret.Default = ret.System
+		if len(ret.Supported) > 0 {
+			ret.Default = ret.Resolve(ret.System)
+			if !containsLocale(ret.Supported, ret.Default) {
+				ret.Default = ret.Supported[0]
+			}
+		}
+	}
+	if ret.Default == "" {
+		ret.Default = "en"
+	}
+
+	return ret, nil
+}
+
+// Resolve returns the best supported locale for the requested locales, in order of preference.
+// An exact match wins, then a supported locale with the same language. If nothing matches, the
+// default locale is returned. When no supported locales are configured, the first requested
+// locale is returned as is.
+func (l LocaleContext) Resolve(requested ...string) string {
+	for _, req := range requested {
+		tag := NormalizeLocale(req)
+		if tag == "" {
+			continue
+		}
+		if len(l.Supported) == 0 {
+			return tag
+		}
+		if containsLocale(l.Supported, tag) {
+			return tag
+		}
+		lang := localeLanguage(tag)
+		for _, s := range l.Supported {
+			if localeLanguage(s) == lang {
+				return s
+			}
+		}
+	}
+	return l.Default
+}
+
+// ResolveAcceptLanguage resolves the locale for the value of an HTTP Accept-Language header,
+// honouring its quality values.
+func (l LocaleContext) ResolveAcceptLanguage(header string) string {
+	type entry struct {
+		tag string
+		q   float64
+	}
+	var entries []entry
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(strings.TrimSpace(part), ";")
+		e := entry{tag: strings.TrimSpace(fields[0]), q: 1}
+		for _, f := range fields[1:] {
+			f = strings.TrimSpace(f)
+			if strings.HasPrefix(f, "q=") {
+				fmt.Sscanf(f[2:], "%g", &e.q)
+			}
+		}
+		if e.tag == "" || e.tag == "*" || e.q <= 0 {
+			continue
+		}
+		// stable insertion by descending quality
+		i := len(entries)
+		for i > 0 && entries[i-1].q < e.q {
+			i--
+		}
+		entries = append(entries, entry{})
+		copy(entries[i+1:], entries[i:])
+		entries[i] = e
+	}
+
+	tags := make([]string, len(entries))
+	for i, e := range entries {
+		tags[i] = e.tag
+	}
+	return l.Resolve(tags...)
+}
+
+// DetectSystemLocale returns the locale of the process environment, read from LC_ALL,
+// LC_MESSAGES and LANG in that order. It returns an empty string for the C and POSIX locales.
+func DetectSystemLocale() string {
+	for _, key := range []string{"LC_ALL", "LC_MESSAGES", "LANG"} {
+		if v := os.Getenv(key); v != "" {
+			return NormalizeLocale(v)
+		}
+	}
+	return ""
+}
+
+// NormalizeLocale converts a POSIX locale such as "en_US.UTF-8" or a language tag such as
+// "en-us" to the canonical form "en-US".
+func NormalizeLocale(v string) string {
+	if i := strings.IndexAny(v, ".@"); i >= 0 {
+		v = v[:i]
+	}
+	if v == "" || v == "C" || v == "POSIX" {
+		return ""
+	}
+
+	parts := strings.Split(strings.Replace(v, "_", "-", -1), "-")
+	parts[0] = strings.ToLower(parts[0])
+	for i := 1; i < len(parts); i++ {
+		switch len(parts[i]) {
+		case 2:
+			parts[i] = strings.ToUpper(parts[i])
+		case 4:
+			parts[i] = strings.ToUpper(parts[i][:1]) + strings.ToLower(parts[i][1:])
+		default:
+			parts[i] = strings.ToLower(parts[i])
+		}
+	}
+	return strings.Join(parts, "-")
+}
+
+func localeLanguage(tag string) string {
+	if i := strings.Index(tag, "-"); i >= 0 {
+		return tag[:i]
+	}
+	return tag
+}
+
+func containsLocale(tags []string, tag string) bool {
+	for _, t := range tags {
+		if NormalizeLocale(t) == tag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,155 @@
+package cfx
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/fx"
+)
+
+// MaintenanceKey is the config section read by MaintenanceModule.
+const MaintenanceKey = "maintenance"
+
+// ErrMaintenance is returned by Maintenance.Check while maintenance mode is on.
+var ErrMaintenance = errors.New("service is in maintenance mode")
+
+// MaintenanceModule provides a *Maintenance that follows the "maintenance" config section
+// across reloads.
+var MaintenanceModule = fx.Options(
+	ProvideSection(MaintenanceKey, &MaintenanceConfig{}),
+	fx.Provide(NewMaintenance),
+)
+
+// MaintenanceConfig is the configuration section for maintenance mode.
+type MaintenanceConfig struct {
+	// Enabled turns maintenance mode on.
+	Enabled bool `json:"enabled,omitempty" yaml:"enabled,omitempty" mapstructure:"enabled,omitempty"`
+
+	// Message is returned to callers that are turned away.
+	Message string `json:"message,omitempty" yaml:"message,omitempty" mapstructure:"message,omitempty"`
+
+	// RetryAfter is advertised to HTTP callers in the Retry-After header.
+	RetryAfter time.Duration `json:"retry_after,omitempty" yaml:"retry_after,omitempty" mapstructure:"retry_after,omitempty"`
+
+	// Allow lists HTTP path prefixes or gRPC full method names, e.g. "/healthz" or
+	// "/grpc.health.v1.Health/", that keep being served during maintenance.
+	Allow []string `json:"allow,omitempty" yaml:"allow,omitempty" mapstructure:"allow,omitempty"`
+}
+
+// Validate implements the cfx.Validator interface.
+func (m MaintenanceConfig) Validate() error {
+	if m.RetryAfter < 0 {
+		return errors.New("maintenance retry_after must not be negative")
+	}
+	for _, a := range m.Allow {
+		if !strings.HasPrefix(a, "/") {
+			return fmt.Errorf("maintenance allow entry %q must start with /", a)
+		}
+	}
+	return nil
+}
+
+// Maintenance is the single source of truth for whether the service is in maintenance mode.
+// Its state follows the "maintenance" section live as the configuration is reloaded. It is
+// safe for concurrent use.
+type Maintenance struct {
+	mu      sync.RWMutex
+	cfg     MaintenanceConfig
+	changed chan struct{}
+}
+
+// NewMaintenance reads the "maintenance" section from c and follows its changes on reload.
+// A reload that makes the section invalid is rejected and the previous state is kept.
+func NewMaintenance(c Container) (*Maintenance, error) {
+	m := &Maintenance{changed: make(chan struct{})}
+	if err := m.load(c); err != nil {
+		return nil, err
+	}
+
+	if err := OnSectionChange(c, MaintenanceKey, func(string, []Change) error {
+		return m.load(c)
+	}); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+func (m *Maintenance) load(c Container) error {
+	cfg := MaintenanceConfig{}
+	if err := c.Populate(MaintenanceKey, &cfg); err != nil {
+		return fmt.Errorf("could not populate maintenance config: %v", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		return SectionError{Key: MaintenanceKey, Err: err}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.cfg = cfg
+	close(m.changed)
+	m.changed = make(chan struct{})
+
+	return nil
+}
+
+// Enabled reports whether maintenance mode is on.
+func (m *Maintenance) Enabled() bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.cfg.Enabled
+}
+
+// Config returns the current maintenance settings.
+func (m *Maintenance) Config() MaintenanceConfig {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.cfg
+}
+
+// Check returns ErrMaintenance, wrapped with the configured message, if a call to path should
+// be turned away. path is an HTTP request path or a gRPC full method name, so Check can back
+// both HTTP middleware and gRPC interceptors.
+func (m *Maintenance) Check(path string) error {
+	cfg := m.Config()
+	if !cfg.Enabled {
+		return nil
+	}
+	for _, a := range cfg.Allow {
+		if strings.HasPrefix(path, a) {
+			return nil
+		}
+	}
+	if cfg.Message != "" {
+		return fmt.Errorf("%v: %s", ErrMaintenance, cfg.Message)
+	}
+	return ErrMaintenance
+}
+
+// Middleware answers requests with 503 Service Unavailable while maintenance mode is on,
+// except for allowed paths.
+func (m *Maintenance) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := m.Check(r.URL.Path); err != nil {
+			if ra := m.Config().RetryAfter; ra > 0 {
+				w.Header().Set("Retry-After", strconv.Itoa(int((ra+time.Second-1)/time.Second)))
+			}
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Changed returns a channel that is closed the next time the settings change. Call Changed
+// again after it fires to wait for the following change.
+func (m *Maintenance) Changed() <-chan struct{} {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.changed
+}
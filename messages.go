@@ -0,0 +1,235 @@
+package cfx
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// MessageID identifies an operator facing error message in a MessageCatalog.
+type MessageID string
+
+// Messages of the errors returned by cfx.
+const (
+	// MsgSectionInvalid is the message of a SectionError, with the arguments {key} and {err}.
+	MsgSectionInvalid MessageID = "section_invalid"
+
+	// MsgConstraintViolated is the message of a ConstraintError, with the arguments {path},
+	// {constraint} and {msg}.
+	MsgConstraintViolated MessageID = "constraint_violated"
+
+	// MsgAirGapped is the message of an AirGappedError, with the argument {resource}.
+	MsgAirGapped MessageID = "air_gapped"
+
+	// MsgReadOnly is the message of a ReadOnlyError, with the arguments {operation} and {path}.
+	MsgReadOnly MessageID = "read_only"
+
+	// MsgReadOnlyHint is the message of a ReadOnlyError with a hint, with the arguments
+	// {operation}, {path} and {hint}.
+	MsgReadOnlyHint MessageID = "read_only_hint"
+
+	// MsgListSeparator joins the messages of errors that aggregate several failures.
+	MsgListSeparator MessageID = "list_separator"
+)
+
+// MessageCatalog holds the message templates of a locale. Templates refer to the arguments of
+// the error by name in braces, e.g. "config section {key} is invalid: {err}", so translations
+// can order them freely.
+type MessageCatalog map[MessageID]string
+
+// LocalizedError is implemented by errors whose message can be rendered in other locales.
+// Arguments that are errors themselves are localized too.
+type LocalizedError interface {
+	error
+
+	// MessageID returns the message of the error.
+	MessageID() MessageID
+
+	// MessageArgs returns the arguments of the message, keyed by name.
+	MessageArgs() map[string]interface{}
+}
+
+// _defaultMessageLocale is the locale of the built in messages, and the final fallback.
+const _defaultMessageLocale = "en"
+
+var (
+	messageCatalogsMu sync.RWMutex
+	messageCatalogs   = map[string]MessageCatalog{
+		_defaultMessageLocale: {
+			MsgSectionInvalid:     "config section {key} is invalid: {err}",
+			MsgConstraintViolated: "{path} violates {constraint}: {msg}",
+			MsgAirGapped:          "{resource} requires network access, which is disabled in air-gapped mode",
+			MsgReadOnly:           "{operation} {path} is not allowed in read-only mode",
+			MsgReadOnlyHint:       "{operation} {path} is not allowed in read-only mode: {hint}",
+			MsgListSeparator:      "; ",
+		},
+	}
+)
+
+// RegisterMessageCatalog adds the messages of cat to the catalog of locale, replacing messages
+// with the same ID. Messages missing from a locale fall back to the locale's language, then to
+// English, so catalogs can be partial:
+//
+//	cfx.RegisterMessageCatalog("fr", cfx.MessageCatalog{
+//		cfx.MsgSectionInvalid: "la section de configuration {key} est invalide : {err}",
+//	})
+func RegisterMessageCatalog(locale string, cat MessageCatalog) {
+	locale = NormalizeLocale(locale)
+	if locale == "" {
+		locale = _defaultMessageLocale
+	}
+
+	messageCatalogsMu.Lock()
+	defer messageCatalogsMu.Unlock()
+	dst, ok := messageCatalogs[locale]
+	if !ok {
+		dst = MessageCatalog{}
+		messageCatalogs[locale] = dst
+	}
+	for id, msg := range cat {
+		dst[id] = msg
+	}
+}
+
+// MessageLocales returns the locales with a registered message catalog, sorted.
+func MessageLocales() []string {
+	messageCatalogsMu.RLock()
+	defer messageCatalogsMu.RUnlock()
+	ret := make([]string, 0, len(messageCatalogs))
+	for locale := range messageCatalogs {
+		ret = append(ret, locale)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// Messages returns a copy of the messages of locale, including the messages it falls back to,
+// for example to hand to translators.
+func Messages(locale string) MessageCatalog {
+	messageCatalogsMu.RLock()
+	defer messageCatalogsMu.RUnlock()
+	ret := MessageCatalog{}
+	for _, l := range messageFallbacks(NormalizeLocale(locale)) {
+		for id, msg := range messageCatalogs[l] {
+			if _, ok := ret[id]; !ok {
+				ret[id] = msg
+			}
+		}
+	}
+	return ret
+}
+
+// Localize renders the message of err in locale, a language tag or POSIX locale such as the
+// result of LocaleContext.Resolve. The messages of errors cfx does not know how to localize
+// are used as they are, and an empty locale renders English.
+func Localize(err error, locale string) string {
+	if err == nil {
+		return ""
+	}
+	return localize(err, messageFallbacks(NormalizeLocale(locale)))
+}
+
+// LocalizeError renders the message of err in the best supported locale for the requested
+// locales, see Resolve.
+func (l LocaleContext) LocalizeError(err error, requested ...string) string {
+	return Localize(err, l.Resolve(requested...))
+}
+
+func localize(err error, locales []string) string {
+	switch e := err.(type) {
+	case ValidationErrors:
+		msgs := make([]string, len(e))
+		for i := range e {
+			msgs[i] = localize(e[i], locales)
+		}
+		return strings.Join(msgs, lookupMessage(locales, MsgListSeparator))
+	case ConstraintErrors:
+		msgs := make([]string, len(e))
+		for i := range e {
+			msgs[i] = localize(e[i], locales)
+		}
+		return strings.Join(msgs, lookupMessage(locales, MsgListSeparator))
+	case LocalizedError:
+		tmpl := lookupMessage(locales, e.MessageID())
+		if tmpl == "" {
+			return err.Error()
+		}
+		args := e.MessageArgs()
+		pairs := make([]string, 0, 2*len(args))
+		for name, v := range args {
+			s := ""
+			switch a := v.(type) {
+			case error:
+				s = localize(a, locales)
+			case string:
+				s = a
+			default:
+				s = fmt.Sprint(a)
+			}
+			pairs = append(pairs, "{"+name+"}", s)
+		}
+		return strings.NewReplacer(pairs...).Replace(tmpl)
+	}
+	return err.Error()
+}
+
+func lookupMessage(locales []string, id MessageID) string {
+	messageCatalogsMu.RLock()
+	defer messageCatalogsMu.RUnlock()
+	for _, l := range locales {
+		if msg, ok := messageCatalogs[l][id]; ok {
+			return msg
+		}
+	}
+	return ""
+}
+
+// messageFallbacks lists the catalogs consulted for locale, most specific first.
+func messageFallbacks(locale string) []string {
+	var ret []string
+	if locale != "" {
+		ret = append(ret, locale)
+		if lang := localeLanguage(locale); lang != locale {
+			ret = append(ret, lang)
+		}
+	}
+	return append(ret, _defaultMessageLocale)
+}
+
+// MessageID implements the cfx.LocalizedError interface.
+func (e SectionError) MessageID() MessageID { return MsgSectionInvalid }
+
+// MessageArgs implements the cfx.LocalizedError interface.
+func (e SectionError) MessageArgs() map[string]interface{} {
+	return map[string]interface{}{"key": e.Key, "err": e.Err}
+}
+
+// MessageID implements the cfx.LocalizedError interface.
+func (e ConstraintError) MessageID() MessageID { return MsgConstraintViolated }
+
+// MessageArgs implements the cfx.LocalizedError interface.
+func (e ConstraintError) MessageArgs() map[string]interface{} {
+	return map[string]interface{}{"path": e.Path, "constraint": e.Constraint, "msg": e.Msg}
+}
+
+// MessageID implements the cfx.LocalizedError interface.
+func (e AirGappedError) MessageID() MessageID { return MsgAirGapped }
+
+// MessageArgs implements the cfx.LocalizedError interface.
+func (e AirGappedError) MessageArgs() map[string]interface{} {
+	return map[string]interface{}{"resource": e.Resource}
+}
+
+// MessageID implements the cfx.LocalizedError interface.
+func (e ReadOnlyError) MessageID() MessageID {
+	if e.Hint != "" {
+		return MsgReadOnlyHint
+	}
+	return MsgReadOnly
+}
+
+// MessageArgs implements the cfx.LocalizedError interface.
+func (e ReadOnlyError) MessageArgs() map[string]interface{} {
+	return map[string]interface{}{"operation": e.Operation, "path": e.Path, "hint": e.Hint}
+}
@@ -0,0 +1,190 @@
+package cfx
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// EnvPlaceholder is replaced by the config name, such as an environment or "base", in the
+// patterns given to PatternNaming.
+const EnvPlaceholder = "<env>"
+
+// NamingStrategy decides which files in ConfigPath hold each named layer of configuration:
+// base, and every environment. The environments manifest is always named environments.yaml.
+type NamingStrategy interface {
+	// Resolve returns the files holding the configuration called name in configDir, in merge
+	// order, or ErrConfigNotFound if there are none. Names are matched ignoring case.
+	Resolve(configDir, name string) ([]string, error)
+
+	// Names lists the names that have configuration in configDir.
+	Names(configDir string) ([]string, error)
+}
+
+// DefaultNaming is the NamingStrategy used unless WithNamingStrategy is given: the
+// configuration called name is the single file <name>.yaml or <name>.yml, optionally
+// compressed.
+var DefaultNaming NamingStrategy = PatternNaming("<env>.yaml")
+
+// PatternNaming returns a NamingStrategy locating configuration with a path pattern relative
+// to ConfigPath, in which EnvPlaceholder appears once:
+//
+//   - "app.<env>.yaml" matches app.production.yaml
+//   - "<env>/config.yaml" matches production/config.yaml
+//   - "config.<env>.d/" matches every YAML file in config.production.d, merged in lexical order
+//
+// Patterns ending in a slash name directories. In file patterns, the extension stands for
+// either YAML extension and any compression suffix, so "<env>.yaml" also matches
+// production.yml.gz. PatternNaming panics if pattern has no EnvPlaceholder, or more than one.
+func PatternNaming(pattern string) NamingStrategy {
+	if strings.Count(pattern, EnvPlaceholder) != 1 {
+		panic(fmt.Sprintf("cfx: naming pattern %q must contain %s exactly once", pattern, EnvPlaceholder))
+	}
+	p := patternNaming{pattern: filepath.ToSlash(pattern)}
+	if strings.HasSuffix(p.pattern, "/") {
+		p.dir = true
+		p.stem = strings.TrimRight(p.pattern, "/")
+	} else {
+		p.stem = strings.TrimSuffix(p.pattern, filepath.Ext(p.pattern))
+	}
+	return p
+}
+
+// WithNamingStrategy overrides how configuration files are located in ConfigPath, to fit an
+// existing repository layout:
+//
+//	c, err := cfx.NewConfigWithOptions(env, cfx.WithNamingStrategy(cfx.PatternNaming("app.<env>.yaml")))
+func WithNamingStrategy(s NamingStrategy) Option {
+	return func(o *options) {
+		if s != nil {
+			o.naming = s
+		}
+	}
+}
+
+type patternNaming struct {
+	pattern string
+
+	// stem is the pattern without the YAML extension of file patterns, or the trailing slash
+	// of directory patterns.
+	stem string
+	dir  bool
+}
+
+// String implements the fmt.Stringer interface.
+func (p patternNaming) String() string {
+	return p.pattern
+}
+
+// Resolve implements the NamingStrategy interface.
+func (p patternNaming) Resolve(configDir, name string) ([]string, error) {
+	target := filepath.Join(configDir, filepath.FromSlash(strings.Replace(p.stem, EnvPlaceholder, name, 1)))
+	if p.dir {
+		return resolveConfigDir(target)
+	}
+
+	dir, base := filepath.Split(target)
+	dir = filepath.Clean(dir)
+	if dir != filepath.Clean(configDir) {
+		// a missing subdirectory is a missing config, not a missing ConfigPath
+		if fi, err := os.Stat(dir); err != nil || !fi.IsDir() {
+			if _, err := os.Stat(configDir); err != nil {
+				return nil, fmt.Errorf("config directory %s could not be located: %v", configDir, err)
+			}
+			return nil, ErrConfigNotFound
+		}
+	}
+	path, err := resolveConfig(dir, base)
+	if err != nil {
+		return nil, err
+	}
+	return []string{path}, nil
+}
+
+// Names implements the NamingStrategy interface.
+func (p patternNaming) Names(configDir string) ([]string, error) {
+	segs := strings.Split(p.stem, "/")
+	k := 0
+	for i, s := range segs {
+		if strings.Contains(s, EnvPlaceholder) {
+			k = i
+		}
+	}
+	dir := filepath.Join(append([]string{configDir}, segs[:k]...)...)
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if k > 0 && os.IsNotExist(err) {
+			return []string{}, nil
+		}
+		return nil, fmt.Errorf("could not list config directory: %v", err)
+	}
+
+	parts := strings.SplitN(strings.ToLower(segs[k]), EnvPlaceholder, 2)
+	last := k == len(segs)-1
+	seen := map[string]bool{}
+	names := []string{}
+	for _, f := range files {
+		entry := f.Name()
+		if last && !p.dir {
+			if f.IsDir() {
+				continue
+			}
+			filename, _ := compressionExt(entry)
+			ext := filepath.Ext(filename)
+			if !yamlExts[ext] {
+				continue
+			}
+			entry = strings.TrimSuffix(filename, ext)
+		} else if !f.IsDir() {
+			continue
+		}
+
+		lower := strings.ToLower(entry)
+		if len(lower) <= len(parts[0])+len(parts[1]) || !strings.HasPrefix(lower, parts[0]) || !strings.HasSuffix(lower, parts[1]) {
+			continue
+		}
+		name := entry[len(parts[0]) : len(entry)-len(parts[1])]
+		if seen[strings.ToLower(name)] {
+			continue
+		}
+		if !last {
+			// the rest of the pattern must exist too
+			if _, err := p.Resolve(configDir, name); err != nil {
+				continue
+			}
+		}
+		seen[strings.ToLower(name)] = true
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// resolveConfigDir returns the YAML files in dir in lexical order.
+func resolveConfigDir(dir string) ([]string, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrConfigNotFound
+		}
+		return nil, fmt.Errorf("could not list config directory %s: %v", dir, err)
+	}
+	var paths []string
+	for _, f := range files {
+		if f.IsDir() {
+			continue
+		}
+		filename, _ := compressionExt(f.Name())
+		if yamlExts[filepath.Ext(filename)] {
+			paths = append(paths, filepath.Join(dir, f.Name()))
+		}
+	}
+	if len(paths) == 0 {
+		return nil, ErrConfigNotFound
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
@@ -0,0 +1,162 @@
+package cfx
+
+import (
+	"os"
+	"time"
+
+	"go.uber.org/fx"
+)
+
+// Option is used to customize the behavior of a Container created with NewConfigWithOptions.
+type Option func(*options)
+
+type options struct {
+	auditSinks []AuditSink
+	redactor   Redactor
+	clock      func() time.Time
+	leader     LeaderElector
+	limits     Limits
+	bundlePath string
+	bundleSig  string
+	bundleKeys []BundleVerifier
+
+	// bundleUnsigned is set by WithBundle to skip signature verification.
+	bundleUnsigned bool
+
+	observers      []ReloadObserver
+	stageObservers []StageObserver
+	reloadInterval time.Duration
+	lastKnownGood  string
+	sections       []SectionSpec
+
+	readinessFile string
+	readyHooks    []ReadyHook
+
+	sectionHandlers []sectionHandler
+
+	unsetMode     UnsetMode
+	unsetKeyModes map[string]UnsetMode
+	expansionWarn func(UnresolvedExpansion)
+	envPolicy     envPolicy
+
+	deprecations map[string]string
+
+	replayRecording string
+	replayPath      string
+
+	socketPath  string
+	socketServe string
+
+	sharedSnapshot string
+
+	snapshotEncoding SnapshotEncoding
+
+	configClient ConfigClient
+
+	serverEntryLimit int
+	serverAllowed    []ConfigRequest
+
+	lazySections map[string]bool
+
+	prompter *prompter
+
+	dataFiles []string
+
+	gitSource *GitSource
+
+	registryKeys []string
+
+	failoverLayers []*FailoverLayer
+
+	remoteCache    Cache
+	remoteCacheTTL time.Duration
+
+	keyHierarchy bool
+
+	pluginDir string
+	plugins   []*pluginClient
+
+	driftInterval  time.Duration
+	driftObservers []DriftObserver
+
+	readOnly bool
+
+	knownEnvironments []string
+	naming            NamingStrategy
+	configPaths       []string
+	createDirs        bool
+	dirPerm           os.FileMode
+	seedFiles         map[string][]byte
+	fileLock          FileLockConfig
+	backups           int
+
+	transformers []TreeTransformer
+	wasmRuntime  WASMRuntime
+	wasmDir      string
+}
+
+func defaultOptions() *options {
+	return &options{
+		redactor: DefaultRedactor,
+		clock:    time.Now,
+		leader:   neverLeader,
+		naming:   DefaultNaming,
+		backups:  _defaultBackups,
+	}
+}
+
+func newOptions(opts []Option) *options {
+	o := defaultOptions()
+	for _, opt := range opts {
+		if opt != nil {
+			opt(o)
+		}
+	}
+	return o
+}
+
+// WithAuditSink registers a sink that will receive an AuditRecord every time the
+// Container is reloaded. Multiple sinks can be registered.
+func WithAuditSink(sink AuditSink) Option {
+	return func(o *options) {
+		if sink != nil {
+			o.auditSinks = append(o.auditSinks, sink)
+		}
+	}
+}
+
+// WithRedactor overrides the function used to redact values before they are
+// written to audit records.
+func WithRedactor(r Redactor) Option {
+	return func(o *options) {
+		if r != nil {
+			o.redactor = r
+		}
+	}
+}
+
+// WithClock overrides the clock used to evaluate scheduled values and timestamp
+// reloads. It is primarily useful in tests.
+func WithClock(now func() time.Time) Option {
+	return func(o *options) {
+		if now != nil {
+			o.clock = now
+		}
+	}
+}
+
+// NewFXConfig is used to create a constructor for the cfx Container that applies
+// the provided options. It can be used in place of cfx.Module.
+func NewFXConfig(opts ...Option) fx.Option {
+	return fx.Options(
+		fx.Provide(func(lc fx.Lifecycle, env EnvContext) (Container, error) {
+			c, err := NewConfigWithOptions(env, opts...)
+			if err != nil {
+				return nil, err
+			}
+			bindLifecycle(lc, c, c.(*yamlContainer).opts)
+			return c, nil
+		}),
+		validateSections,
+	)
+}
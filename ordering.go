@@ -0,0 +1,212 @@
+package cfx
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"go.uber.org/fx"
+)
+
+// ConfigStep is a named configuration step of a module, typically the function that reads the
+// module's section and sets it up, along with the steps it must run before or after.
+type ConfigStep struct {
+	// Name identifies the step in Before and After lists and in the graph.
+	Name string
+
+	// Fn is passed to fx.Invoke, so it can depend on any type in the Fx graph.
+	Fn interface{}
+
+	// Before lists steps that must run after this one.
+	Before []string
+
+	// After lists steps that must run before this one.
+	After []string
+}
+
+// OrderedSteps invokes the steps in an order that satisfies their Before and After
+// relationships. Fx runs invocations in the order they are given, so the ordering is only as
+// strong as the Fx graph allows: a step's own dependencies are still constructed on demand.
+// Steps with no relationship between them keep the order they were passed in.
+//
+//	fx.New(
+//		cfx.Module,
+//		cfx.OrderedSteps(
+//			cfx.ConfigStep{Name: "metrics", Fn: setupMetrics},
+//			cfx.ConfigStep{Name: "database", Fn: setupDatabase, After: []string{"secrets"}},
+//			cfx.ConfigStep{Name: "secrets", Fn: setupSecrets, Before: []string{"metrics"}},
+//		),
+//	)
+//
+// Relationships that name an unknown step, or that form a cycle, fail the application with an
+// error describing them.
+func OrderedSteps(steps ...ConfigStep) fx.Option {
+	order, err := StepOrder(steps...)
+	if err != nil {
+		return fx.Error(err)
+	}
+
+	byName := make(map[string]ConfigStep, len(steps))
+	for _, s := range steps {
+		byName[s.Name] = s
+	}
+	opts := make([]fx.Option, 0, len(order))
+	for _, name := range order {
+		opts = append(opts, fx.Invoke(byName[name].Fn))
+	}
+	return fx.Options(opts...)
+}
+
+// StepOrder returns the names of the steps in the order OrderedSteps invokes them.
+func StepOrder(steps ...ConfigStep) ([]string, error) {
+	g, err := newStepGraph(steps)
+	if err != nil {
+		return nil, err
+	}
+
+	indegree := make([]int, len(steps))
+	for _, deps := range g.edges {
+		for _, to := range deps {
+			indegree[to]++
+		}
+	}
+
+	ret := make([]string, 0, len(steps))
+	done := make([]bool, len(steps))
+	for len(ret) < len(steps) {
+		// the first ready step in the order given keeps the result stable
+		next := -1
+		for i := range steps {
+			if !done[i] && indegree[i] == 0 {
+				next = i
+				break
+			}
+		}
+		if next < 0 {
+			return nil, fmt.Errorf("configuration steps form a cycle: %s", g.cycle(done))
+		}
+		done[next] = true
+		ret = append(ret, steps[next].Name)
+		for _, to := range g.edges[next] {
+			indegree[to]--
+		}
+	}
+	return ret, nil
+}
+
+// WriteStepGraph writes the steps and their relationships as a Graphviz digraph, with an
+// edge from every step to the steps that run after it, for debugging start order issues:
+//
+//	cfx.WriteStepGraph(os.Stdout, steps...)   // | dot -Tsvg > steps.svg
+func WriteStepGraph(w io.Writer, steps ...ConfigStep) error {
+	g, err := newStepGraph(steps)
+	if err != nil {
+		return err
+	}
+
+	var sb strings.Builder
+	sb.WriteString("digraph cfx_steps {\n\trankdir=LR;\n")
+	for _, s := range steps {
+		fmt.Fprintf(&sb, "\t%q;\n", s.Name)
+	}
+	for from, deps := range g.edges {
+		for _, to := range deps {
+			fmt.Fprintf(&sb, "\t%q -> %q;\n", steps[from].Name, steps[to].Name)
+		}
+	}
+	sb.WriteString("}\n")
+
+	_, err = io.WriteString(w, sb.String())
+	return err
+}
+
+// stepGraph holds the "runs before" edges between steps, by index.
+type stepGraph struct {
+	steps []ConfigStep
+	edges [][]int
+}
+
+func newStepGraph(steps []ConfigStep) (*stepGraph, error) {
+	index := make(map[string]int, len(steps))
+	for i, s := range steps {
+		if s.Name == "" {
+			return nil, errors.New("configuration steps need a name")
+		}
+		if _, exists := index[s.Name]; exists {
+			return nil, fmt.Errorf("configuration step %s is defined more than once", s.Name)
+		}
+		index[s.Name] = i
+	}
+
+	g := &stepGraph{steps: steps, edges: make([][]int, len(steps))}
+	seen := map[[2]int]bool{}
+	add := func(from, to int) {
+		if !seen[[2]int{from, to}] {
+			seen[[2]int{from, to}] = true
+			g.edges[from] = append(g.edges[from], to)
+		}
+	}
+	for i, s := range steps {
+		for _, name := range s.Before {
+			j, ok := index[name]
+			if !ok {
+				return nil, fmt.Errorf("configuration step %s must run before unknown step %s", s.Name, name)
+			}
+			add(i, j)
+		}
+		for _, name := range s.After {
+			j, ok := index[name]
+			if !ok {
+				return nil, fmt.Errorf("configuration step %s must run after unknown step %s", s.Name, name)
+			}
+			add(j, i)
+		}
+	}
+	for i := range g.edges {
+		sort.Ints(g.edges[i])
+	}
+	return g, nil
+}
+
+// cycle describes a cycle among the steps that are not done.
+func (g *stepGraph) cycle(done []bool) string {
+	// every remaining step has a remaining predecessor, so walking backwards must revisit one
+	preds := make([]int, len(g.steps))
+	for i := range preds {
+		preds[i] = -1
+	}
+	for from, deps := range g.edges {
+		if done[from] {
+			continue
+		}
+		for _, to := range deps {
+			if !done[to] && preds[to] < 0 {
+				preds[to] = from
+			}
+		}
+	}
+
+	start := 0
+	for done[start] {
+		start++
+	}
+	visited := map[int]int{}
+	path := []int{}
+	for i := start; ; i = preds[i] {
+		if at, ok := visited[i]; ok {
+			path = path[at:]
+			break
+		}
+		visited[i] = len(path)
+		path = append(path, i)
+	}
+
+	names := make([]string, 0, len(path)+1)
+	for k := len(path) - 1; k >= 0; k-- {
+		names = append(names, g.steps[path[k]].Name)
+	}
+	names = append(names, names[0])
+	return strings.Join(names, " -> ")
+}
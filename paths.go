@@ -0,0 +1,57 @@
+package cfx
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+)
+
+// ExpandPath expands $VAR and ${VAR} references to environment variables in p, then a leading
+// ~ to the home directory of the current user, or ~name to the home directory of user name.
+// Unset variables expand to the empty string, like in a shell.
+func ExpandPath(p string) (string, error) {
+	return expandHome(os.ExpandEnv(p))
+}
+
+func expandHome(p string) (string, error) {
+	if !strings.HasPrefix(p, "~") {
+		return p, nil
+	}
+
+	name, rest := p[1:], ""
+	if i := strings.IndexFunc(name, func(r rune) bool { return r == '/' || r == os.PathSeparator }); i >= 0 {
+		name, rest = name[:i], name[i:]
+	}
+
+	var home string
+	if name == "" {
+		h, err := os.UserHomeDir()
+		if err != nil {
+			return "", fmt.Errorf("could not expand ~ in %s: %v", p, err)
+		}
+		home = h
+	} else {
+		u, err := user.Lookup(name)
+		if err != nil {
+			return "", fmt.Errorf("could not expand ~%s in %s: %v", name, p, err)
+		}
+		home = u.HomeDir
+	}
+	return home + rest, nil
+}
+
+// expandPathList expands every entry of a search path like ExpandPath. Variables are expanded
+// before the list is split, so a variable may hold several entries.
+func expandPathList(list string) (string, error) {
+	entries := filepath.SplitList(os.ExpandEnv(list))
+	for i, e := range entries {
+		expanded, err := expandHome(e)
+		if err != nil {
+			return "", err
+		}
+		entries[i] = expanded
+	}
+	return strings.Join(entries, string(filepath.ListSeparator)), nil
+}
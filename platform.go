@@ -0,0 +1,302 @@
+package cfx
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// Platform identifies the orchestrator or platform that scheduled the process.
+type Platform string
+
+// Platforms detected by DetectPlatform.
+const (
+	PlatformNone         Platform = ""
+	PlatformNomad        Platform = "nomad"
+	PlatformECS          Platform = "ecs"
+	PlatformCloudFoundry Platform = "cloudfoundry"
+	PlatformHeroku       Platform = "heroku"
+	PlatformFly          Platform = "fly"
+	PlatformRender       Platform = "render"
+)
+
+// String implements the fmt.Stringer interface.
+func (p Platform) String() string {
+	return string(p)
+}
+
+// _ecsMetadataTimeout bounds the request to the ECS task metadata endpoint, which is served by
+// the local ECS agent and answers in milliseconds when it is there at all.
+var _ecsMetadataTimeout = 2 * time.Second
+
+// PlatformContext describes the placement of the process by the orchestrator that scheduled
+// it, for the orchestrators that are not Kubernetes.
+type PlatformContext struct {
+	// Name is the detected platform.
+	Name Platform `json:"name,omitempty" yaml:"name,omitempty" mapstructure:"name,omitempty"`
+
+	// ID identifies this copy of the workload: the Nomad allocation ID, the ECS task ARN, the
+	// Cloud Foundry instance GUID, the Heroku dyno ID, the Fly Machine ID or the Render instance.
+	ID string `json:"id,omitempty" yaml:"id,omitempty" mapstructure:"id,omitempty"`
+
+	// App is the scheduled workload: the Nomad job, the ECS task definition family, the Cloud
+	// Foundry application, or the Heroku, Fly or Render app.
+	App string `json:"app,omitempty" yaml:"app,omitempty" mapstructure:"app,omitempty"`
+
+	// Task is the Nomad task, the ECS task definition revision, the Heroku process type, the Fly
+	// process group or the Render service type.
+	Task string `json:"task,omitempty" yaml:"task,omitempty" mapstructure:"task,omitempty"`
+
+	// Index is the Nomad allocation index, the Cloud Foundry instance index or the Heroku dyno
+	// number.
+	Index string `json:"index,omitempty" yaml:"index,omitempty" mapstructure:"index,omitempty"`
+
+	// Cluster is the ECS cluster or the Cloud Foundry organization.
+	Cluster string `json:"cluster,omitempty" yaml:"cluster,omitempty" mapstructure:"cluster,omitempty"`
+
+	// Namespace is the Nomad namespace or the Cloud Foundry space.
+	Namespace string `json:"namespace,omitempty" yaml:"namespace,omitempty" mapstructure:"namespace,omitempty"`
+
+	// Service is the Render service ID. It fills DeploymentContext.ServiceID when that was not
+	// set explicitly.
+	Service string `json:"service,omitempty" yaml:"service,omitempty" mapstructure:"service,omitempty"`
+
+	// Region, Zone and Datacenter are the placement reported by the platform. They fill the
+	// DeploymentContext fields of the same name that were not set explicitly.
+	Region     string `json:"region,omitempty" yaml:"region,omitempty" mapstructure:"region,omitempty"`
+	Zone       string `json:"zone,omitempty" yaml:"zone,omitempty" mapstructure:"zone,omitempty"`
+	Datacenter string `json:"datacenter,omitempty" yaml:"datacenter,omitempty" mapstructure:"datacenter,omitempty"`
+}
+
+// DetectPlatform works out which orchestrator scheduled the process from the variables it
+// sets: Nomad from NOMAD_ALLOC_ID, ECS from ECS_CONTAINER_METADATA_URI_V4, Cloud Foundry from
+// VCAP_APPLICATION, Heroku from DYNO, Fly.io from FLY_APP_NAME and Render from
+// RENDER_SERVICE_ID. On ECS the cluster, task and zone are read from the container metadata
+// file; DetectPlatform never makes network calls.
+func DetectPlatform() PlatformContext {
+	switch {
+	case os.Getenv("NOMAD_ALLOC_ID") != "":
+		return detectNomad()
+	case os.Getenv("ECS_CONTAINER_METADATA_URI_V4") != "" || os.Getenv("ECS_CONTAINER_METADATA_URI") != "":
+		return detectECS()
+	case os.Getenv("VCAP_APPLICATION") != "":
+		return detectCloudFoundry()
+	case os.Getenv("DYNO") != "":
+		return detectHeroku()
+	case os.Getenv("FLY_APP_NAME") != "":
+		return detectFly()
+	case os.Getenv("RENDER_SERVICE_ID") != "":
+		return detectRender()
+	}
+	return PlatformContext{}
+}
+
+func detectNomad() PlatformContext {
+	return PlatformContext{
+		Name:       PlatformNomad,
+		ID:         os.Getenv("NOMAD_ALLOC_ID"),
+		App:        os.Getenv("NOMAD_JOB_NAME"),
+		Task:       os.Getenv("NOMAD_TASK_NAME"),
+		Index:      os.Getenv("NOMAD_ALLOC_INDEX"),
+		Namespace:  os.Getenv("NOMAD_NAMESPACE"),
+		Region:     os.Getenv("NOMAD_REGION"),
+		Datacenter: os.Getenv("NOMAD_DC"),
+	}
+}
+
+// ecsTaskMetadata is the subset of the ECS task metadata response used by FetchECSPlatform.
+type ecsTaskMetadata struct {
+	Cluster          string `json:"Cluster"`
+	TaskARN          string `json:"TaskARN"`
+	Family           string `json:"Family"`
+	Revision         string `json:"Revision"`
+	AvailabilityZone string `json:"AvailabilityZone"`
+}
+
+// ecsMetadataFile is the subset of the container metadata file used by detectECS.
+type ecsMetadataFile struct {
+	Cluster                string `json:"Cluster"`
+	TaskARN                string `json:"TaskARN"`
+	TaskDefinitionFamily   string `json:"TaskDefinitionFamily"`
+	TaskDefinitionRevision string `json:"TaskDefinitionRevision"`
+	AvailabilityZone       string `json:"AvailabilityZone"`
+}
+
+// detectECS reads the region from the environment and the task from the container metadata
+// file, which the agent writes when ECS_ENABLE_CONTAINER_METADATA is set. It makes no network
+// calls, since it runs before options such as AirGapped apply.
+func detectECS() PlatformContext {
+	ret := PlatformContext{Name: PlatformECS, Region: firstEnv("AWS_REGION", "AWS_DEFAULT_REGION")}
+
+	path := os.Getenv("ECS_CONTAINER_METADATA_FILE")
+	if path == "" {
+		return ret
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return ret
+	}
+	var md ecsMetadataFile
+	if err := json.Unmarshal(data, &md); err != nil {
+		return ret
+	}
+	ret.applyECS(ecsTaskMetadata{
+		Cluster:          md.Cluster,
+		TaskARN:          md.TaskARN,
+		Family:           md.TaskDefinitionFamily,
+		Revision:         md.TaskDefinitionRevision,
+		AvailabilityZone: md.AvailabilityZone,
+	})
+	return ret
+}
+
+// FetchECSPlatform returns the ECS placement of the process, read from the task metadata
+// endpoint at ECS_CONTAINER_METADATA_URI_V4. DetectPlatform does not query it, so tasks
+// without a container metadata file, such as Fargate tasks, can call FetchECSPlatform and
+// apply the result themselves. It fails in air-gapped mode.
+func FetchECSPlatform(ctx context.Context) (PlatformContext, error) {
+	endpoint := firstEnv("ECS_CONTAINER_METADATA_URI_V4", "ECS_CONTAINER_METADATA_URI")
+	if endpoint == "" {
+		return PlatformContext{}, errors.New("not running on ECS")
+	}
+	if err := checkNetworkAllowed("ECS task metadata"); err != nil {
+		return PlatformContext{}, err
+	}
+
+	ret := detectECS()
+	md, err := fetchECSTaskMetadata(ctx, endpoint)
+	if err != nil {
+		return ret, err
+	}
+	ret.applyECS(md)
+	return ret, nil
+}
+
+// applyECS fills p from the ECS task metadata.
+func (p *PlatformContext) applyECS(md ecsTaskMetadata) {
+	p.ID = md.TaskARN
+	p.App = md.Family
+	p.Task = md.Revision
+	p.Cluster = md.Cluster
+	p.Zone = md.AvailabilityZone
+	if p.Region == "" {
+		// task ARNs are arn:aws:ecs:<region>:<account>:task/...
+		if parts := strings.SplitN(md.TaskARN, ":", 5); len(parts) == 5 {
+			p.Region = parts[3]
+		}
+	}
+}
+
+func fetchECSTaskMetadata(ctx context.Context, endpoint string) (ecsTaskMetadata, error) {
+	var md ecsTaskMetadata
+	req, err := http.NewRequest(http.MethodGet, strings.TrimRight(endpoint, "/")+"/task", nil)
+	if err != nil {
+		return md, fmt.Errorf("could not query ECS task metadata: %v", err)
+	}
+	client := &http.Client{Timeout: _ecsMetadataTimeout}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return md, fmt.Errorf("could not query ECS task metadata: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return md, fmt.Errorf("ECS task metadata endpoint returned %s", resp.Status)
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return md, fmt.Errorf("could not read ECS task metadata: %v", err)
+	}
+	if err := json.Unmarshal(data, &md); err != nil {
+		return md, fmt.Errorf("could not parse ECS task metadata: %v", err)
+	}
+	return md, nil
+}
+
+// vcapApplication is the subset of VCAP_APPLICATION used by detectCloudFoundry.
+type vcapApplication struct {
+	ApplicationName  string `json:"application_name"`
+	InstanceID       string `json:"instance_id"`
+	InstanceIndex    *int   `json:"instance_index"`
+	OrganizationName string `json:"organization_name"`
+	SpaceName        string `json:"space_name"`
+}
+
+func detectCloudFoundry() PlatformContext {
+	ret := PlatformContext{
+		Name:  PlatformCloudFoundry,
+		ID:    os.Getenv("CF_INSTANCE_GUID"),
+		Index: os.Getenv("CF_INSTANCE_INDEX"),
+	}
+	var app vcapApplication
+	if err := json.Unmarshal([]byte(os.Getenv("VCAP_APPLICATION")), &app); err != nil {
+		return ret
+	}
+	ret.App = app.ApplicationName
+	ret.Cluster = app.OrganizationName
+	ret.Namespace = app.SpaceName
+	if ret.ID == "" {
+		ret.ID = app.InstanceID
+	}
+	if ret.Index == "" && app.InstanceIndex != nil {
+		ret.Index = fmt.Sprint(*app.InstanceIndex)
+	}
+	return ret
+}
+
+func detectHeroku() PlatformContext {
+	// DYNO is <process type>.<number>, e.g. web.1, or run.<number> for one-off dynos
+	ret := PlatformContext{
+		Name: PlatformHeroku,
+		ID:   os.Getenv("HEROKU_DYNO_ID"),
+		App:  os.Getenv("HEROKU_APP_NAME"),
+		Task: os.Getenv("DYNO"),
+	}
+	if i := strings.LastIndexByte(ret.Task, '.'); i >= 0 {
+		ret.Task, ret.Index = ret.Task[:i], ret.Task[i+1:]
+	}
+	return ret
+}
+
+func detectFly() PlatformContext {
+	return PlatformContext{
+		Name:   PlatformFly,
+		ID:     firstEnv("FLY_MACHINE_ID", "FLY_ALLOC_ID"),
+		App:    os.Getenv("FLY_APP_NAME"),
+		Task:   os.Getenv("FLY_PROCESS_GROUP"),
+		Region: os.Getenv("FLY_REGION"),
+	}
+}
+
+func detectRender() PlatformContext {
+	return PlatformContext{
+		Name:    PlatformRender,
+		ID:      os.Getenv("RENDER_INSTANCE_ID"),
+		App:     os.Getenv("RENDER_SERVICE_NAME"),
+		Task:    os.Getenv("RENDER_SERVICE_TYPE"),
+		Service: os.Getenv("RENDER_SERVICE_ID"),
+	}
+}
+
+// applyPlatform records p in the deployment and fills the fields that were not set
+// explicitly from it.
+func (d *DeploymentContext) applyPlatform(p PlatformContext) {
+	d.Platform = p
+	fill := func(dst *string, v string) {
+		if *dst == "" {
+			*dst = v
+		}
+	}
+	fill(&d.AppID, p.App)
+	fill(&d.ServiceID, p.Service)
+	fill(&d.InstanceID, p.ID)
+	fill(&d.Region, p.Region)
+	fill(&d.AvailabilityZone, p.Zone)
+	fill(&d.DatacenterID, p.Datacenter)
+}
@@ -0,0 +1,43 @@
+package cfx
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+func TestDetectECSMakesNoNetworkCalls(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("DetectPlatform queried %s", r.URL)
+	}))
+	defer srv.Close()
+
+	dir := testDir(t)
+	writeTestConfig(t, dir, "ecs.json", `{
+  "Cluster": "prod",
+  "TaskARN": "arn:aws:ecs:eu-west-1:123456789012:task/prod/abc",
+  "TaskDefinitionFamily": "api",
+  "TaskDefinitionRevision": "7",
+  "AvailabilityZone": "eu-west-1b"
+}`)
+	for _, k := range []string{"NOMAD_ALLOC_ID", "AWS_REGION", "AWS_DEFAULT_REGION"} {
+		setTestEnv(t, k, "")
+	}
+	setTestEnv(t, "ECS_CONTAINER_METADATA_URI_V4", srv.URL)
+	setTestEnv(t, "ECS_CONTAINER_METADATA_FILE", filepath.Join(dir, "ecs.json"))
+
+	got := DetectPlatform()
+	want := PlatformContext{
+		Name:    PlatformECS,
+		ID:      "arn:aws:ecs:eu-west-1:123456789012:task/prod/abc",
+		App:     "api",
+		Task:    "7",
+		Cluster: "prod",
+		Region:  "eu-west-1",
+		Zone:    "eu-west-1b",
+	}
+	if got != want {
+		t.Errorf("DetectPlatform() = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,419 @@
+package cfx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+const (
+	// PluginProtocolVersion is the version of the protocol spoken between cfx and plugins.
+	PluginProtocolVersion = 1
+
+	// PluginsDirName is the conventional plugins directory within the ConfigPath, loaded with
+	// WithPlugins(cfx.PluginsDirName).
+	PluginsDirName = "plugins"
+
+	// _pluginCookieKey is set in the environment of plugin processes, so that ServePlugin can
+	// tell it was launched by cfx.
+	_pluginCookieKey   = "CFX_PLUGIN_COOKIE"
+	_pluginCookieValue = "5c1f2b7e-cfx-plugin"
+
+	_pluginServiceName      = "Plugin"
+	_pluginHandshakeTimeout = 10 * time.Second
+)
+
+// Plugin describes a cfx plugin: a separate binary, discovered from a plugins directory, that
+// contributes configuration sources, secret resolvers and validators without forking cfx.
+// A plugin's main function builds a Plugin and calls ServePlugin:
+//
+//	func main() {
+//		cfx.ServePlugin(cfx.Plugin{
+//			Name:          "vault",
+//			SecretSchemes: []string{"vault"},
+//			ResolveSecret: resolveFromVault,
+//		})
+//	}
+//
+// cfx starts every plugin when a Container is created with WithPlugins and talks to it using
+// JSON-RPC over the plugin's stdin and stdout, so plugins must not write to stdout themselves.
+type Plugin struct {
+	// Name identifies the plugin in errors.
+	Name string
+
+	// SecretSchemes are the SecretRef schemes served by ResolveSecret.
+	SecretSchemes []string
+
+	// Local declares that ResolveSecret makes no network calls, so the plugin's secret
+	// schemes can be used in air-gapped mode.
+	Local bool
+
+	// Sources returns configuration layers that are merged after the configuration files,
+	// in order.
+	Sources func(env EnvContext) ([]PluginSource, error)
+
+	// ResolveSecret resolves the path of a SecretRef using one of SecretSchemes.
+	ResolveSecret func(ctx context.Context, scheme, path string) (string, error)
+
+	// Validate checks the merged configuration tree before it is used.
+	Validate func(env EnvContext, tree map[string]interface{}) error
+}
+
+// PluginSource is a configuration layer contributed by a plugin.
+type PluginSource struct {
+	// Name identifies the layer in reports and errors.
+	Name string
+
+	// Data is the YAML content of the layer.
+	Data []byte
+}
+
+// PluginInfo is exchanged when a plugin starts. It is part of the plugin protocol.
+type PluginInfo struct {
+	Protocol      int
+	Name          string
+	SecretSchemes []string
+	Local         bool
+	Sources       bool
+	Validates     bool
+}
+
+// PluginSourcesArgs is part of the plugin protocol.
+type PluginSourcesArgs struct {
+	Env EnvContext
+}
+
+// PluginSecretArgs is part of the plugin protocol.
+type PluginSecretArgs struct {
+	Scheme  string
+	Path    string
+	Timeout time.Duration
+}
+
+// PluginValidateArgs is part of the plugin protocol.
+type PluginValidateArgs struct {
+	Env  EnvContext
+	Tree map[string]interface{}
+}
+
+// WithPlugins loads plugins from every executable in dir when the Container is created. A
+// relative dir is resolved against the ConfigPath. Plugin binaries that are writable by
+// other users are refused.
+func WithPlugins(dir string) Option {
+	return func(o *options) {
+		o.pluginDir = dir
+	}
+}
+
+// ServePlugin serves p to the cfx process that launched it, until that process goes away.
+// It returns an error if the binary was not launched by cfx.
+func ServePlugin(p Plugin) error {
+	if os.Getenv(_pluginCookieKey) != _pluginCookieValue {
+		return errors.New("this binary is a cfx plugin and is meant to be launched by cfx, see cfx.WithPlugins")
+	}
+
+	srv := rpc.NewServer()
+	if err := srv.RegisterName(_pluginServiceName, &pluginServer{p: p}); err != nil {
+		return fmt.Errorf("could not register plugin %s: %v", p.Name, err)
+	}
+	srv.ServeCodec(jsonrpc.NewServerCodec(stdioConn{r: os.Stdin, w: os.Stdout}))
+	return nil
+}
+
+// pluginServer exposes a Plugin over RPC.
+type pluginServer struct {
+	p Plugin
+}
+
+func (s *pluginServer) Info(_ int, reply *PluginInfo) error {
+	*reply = PluginInfo{
+		Protocol:      PluginProtocolVersion,
+		Name:          s.p.Name,
+		SecretSchemes: s.p.SecretSchemes,
+		Local:         s.p.Local,
+		Sources:       s.p.Sources != nil,
+		Validates:     s.p.Validate != nil,
+	}
+	return nil
+}
+
+func (s *pluginServer) Sources(args PluginSourcesArgs, reply *[]PluginSource) error {
+	if s.p.Sources == nil {
+		return nil
+	}
+	srcs, err := s.p.Sources(args.Env)
+	if err != nil {
+		return err
+	}
+	*reply = srcs
+	return nil
+}
+
+func (s *pluginServer) ResolveSecret(args PluginSecretArgs, reply *string) error {
+	if s.p.ResolveSecret == nil {
+		return fmt.Errorf("plugin %s does not resolve secrets", s.p.Name)
+	}
+	ctx := context.Background()
+	if args.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, args.Timeout)
+		defer cancel()
+	}
+	val, err := s.p.ResolveSecret(ctx, args.Scheme, args.Path)
+	if err != nil {
+		return err
+	}
+	*reply = val
+	return nil
+}
+
+func (s *pluginServer) Validate(args PluginValidateArgs, reply *bool) error {
+	if s.p.Validate == nil {
+		*reply = true
+		return nil
+	}
+	if err := s.p.Validate(args.Env, args.Tree); err != nil {
+		return err
+	}
+	*reply = true
+	return nil
+}
+
+// stdioConn joins a reader and a writer into an io.ReadWriteCloser.
+type stdioConn struct {
+	r io.ReadCloser
+	w io.WriteCloser
+}
+
+func (c stdioConn) Read(p []byte) (int, error)  { return c.r.Read(p) }
+func (c stdioConn) Write(p []byte) (int, error) { return c.w.Write(p) }
+
+func (c stdioConn) Close() error {
+	werr := c.w.Close()
+	if err := c.r.Close(); err != nil {
+		return err
+	}
+	return werr
+}
+
+// pluginClient is the host side of a running plugin.
+type pluginClient struct {
+	path   string
+	info   PluginInfo
+	cmd    *exec.Cmd
+	client *rpc.Client
+}
+
+// startPlugins launches every plugin in dir.
+func startPlugins(env EnvContext, dir string) ([]*pluginClient, error) {
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(env.ConfigPath, dir)
+	}
+
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("could not list plugins directory %s: %v", dir, err)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+
+	var ret []*pluginClient
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+		fi, err := os.Stat(path)
+		if err != nil {
+			closePlugins(ret)
+			return nil, fmt.Errorf("could not stat plugin %s: %v", path, err)
+		}
+		if fi.IsDir() || fi.Mode()&0111 == 0 {
+			continue
+		}
+		if fi.Mode()&0002 != 0 {
+			closePlugins(ret)
+			return nil, fmt.Errorf("refusing to load plugin %s: it is writable by other users", path)
+		}
+
+		p, err := startPlugin(path)
+		if err != nil {
+			closePlugins(ret)
+			return nil, err
+		}
+		ret = append(ret, p)
+	}
+
+	for _, p := range ret {
+		for _, scheme := range p.info.SecretSchemes {
+			r := p.secretResolver(scheme)
+			if p.info.Local {
+				RegisterLocalSecretResolver(scheme, r)
+			} else {
+				RegisterSecretResolver(scheme, r)
+			}
+		}
+	}
+
+	return ret, nil
+}
+
+func startPlugin(path string) (*pluginClient, error) {
+	cmd := exec.Command(path)
+	cmd.Env = append(os.Environ(), _pluginCookieKey+"="+_pluginCookieValue)
+	cmd.Stderr = os.Stderr
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, fmt.Errorf("could not start plugin %s: %v", path, err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("could not start plugin %s: %v", path, err)
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("could not start plugin %s: %v", path, err)
+	}
+
+	p := &pluginClient{
+		path:   path,
+		cmd:    cmd,
+		client: rpc.NewClientWithCodec(jsonrpc.NewClientCodec(stdioConn{r: stdout, w: stdin})),
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), _pluginHandshakeTimeout)
+	defer cancel()
+	if err := p.call(ctx, "Info", 0, &p.info); err != nil {
+		p.close()
+		return nil, fmt.Errorf("plugin %s did not complete the handshake: %v", path, err)
+	}
+	if p.info.Protocol != PluginProtocolVersion {
+		p.close()
+		return nil, fmt.Errorf("plugin %s speaks protocol version %d, expected %d", path, p.info.Protocol, PluginProtocolVersion)
+	}
+	if p.info.Name == "" {
+		p.info.Name = filepath.Base(path)
+	}
+
+	return p, nil
+}
+
+// call invokes a plugin method, giving up when ctx is done.
+func (p *pluginClient) call(ctx context.Context, method string, args interface{}, reply interface{}) error {
+	c := p.client.Go(_pluginServiceName+"."+method, args, reply, make(chan *rpc.Call, 1))
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-c.Done:
+		return c.Error
+	}
+}
+
+func (p *pluginClient) sources(env EnvContext) ([]configSource, error) {
+	if !p.info.Sources {
+		return nil, nil
+	}
+	var srcs []PluginSource
+	if err := p.call(context.Background(), "Sources", PluginSourcesArgs{Env: env}, &srcs); err != nil {
+		return nil, fmt.Errorf("plugin %s could not provide its sources: %v", p.info.Name, err)
+	}
+
+	ret := make([]configSource, len(srcs))
+	for i, s := range srcs {
+		ret[i] = configSource{name: fmt.Sprintf("plugin:%s/%s", p.info.Name, s.Name), data: s.Data}
+	}
+	return ret, nil
+}
+
+func (p *pluginClient) validate(env EnvContext, tree map[string]interface{}) error {
+	if !p.info.Validates {
+		return nil
+	}
+	var ok bool
+	if err := p.call(context.Background(), "Validate", PluginValidateArgs{Env: env, Tree: tree}, &ok); err != nil {
+		return fmt.Errorf("plugin %s rejected the configuration: %v", p.info.Name, err)
+	}
+	return nil
+}
+
+func (p *pluginClient) secretResolver(scheme string) SecretResolver {
+	return SecretResolverFunc(func(ctx context.Context, path string) (string, error) {
+		args := PluginSecretArgs{Scheme: scheme, Path: path}
+		if dl, ok := ctx.Deadline(); ok {
+			args.Timeout = time.Until(dl)
+		}
+		var val string
+		if err := p.call(ctx, "ResolveSecret", args, &val); err != nil {
+			return "", fmt.Errorf("plugin %s: %v", p.info.Name, err)
+		}
+		return val, nil
+	})
+}
+
+func (p *pluginClient) close() {
+	p.client.Close()
+	done := make(chan struct{})
+	go func() {
+		p.cmd.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		p.cmd.Process.Kill()
+		<-done
+	}
+}
+
+func closePlugins(plugins []*pluginClient) {
+	for _, p := range plugins {
+		p.close()
+	}
+}
+
+// pluginSources collects the configuration layers of every plugin, in load order, followed by
+// the registry keys registered with WithRegistryKey.
+func pluginSources(env EnvContext, opts *options) ([]configSource, error) {
+	var ret []configSource
+	for _, p := range opts.plugins {
+		srcs, err := p.sources(env)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, srcs...)
+	}
+
+	reg, err := registrySources(opts)
+	if err != nil {
+		return nil, err
+	}
+	ret = append(ret, reg...)
+
+	layers, err := failoverSources(opts)
+	if err != nil {
+		return nil, err
+	}
+	return append(ret, layers...), nil
+}
+
+// validatePlugins runs the validators of every plugin against the snapshot.
+func validatePlugins(env EnvContext, opts *options, snap *snapshot) error {
+	if len(opts.plugins) == 0 {
+		return nil
+	}
+	tree, err := snap.materialized()
+	if err != nil {
+		return err
+	}
+	for _, p := range opts.plugins {
+		if err := p.validate(env, tree); err != nil {
+			return err
+		}
+	}
+	return nil
+}
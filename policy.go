@@ -0,0 +1,209 @@
+package cfx
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// BackoffStop is returned by Backoff.NextBackOff when no more retries should be made. It has the
+// same value as backoff.Stop in github.com/cenkalti/backoff.
+const BackoffStop time.Duration = -1
+
+// RetryPolicy is a reusable configuration shape for retrying operations with exponential backoff.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts, including the first. Zero means no limit,
+	// in which case MaxElapsed should be set.
+	MaxAttempts int `json:"max_attempts,omitempty" yaml:"max_attempts,omitempty" mapstructure:"max_attempts,omitempty"`
+
+	// InitialInterval is the delay before the first retry.
+	InitialInterval time.Duration `json:"initial_interval,omitempty" yaml:"initial_interval,omitempty" mapstructure:"initial_interval,omitempty"`
+
+	// MaxInterval caps the delay between attempts.
+	MaxInterval time.Duration `json:"max_interval,omitempty" yaml:"max_interval,omitempty" mapstructure:"max_interval,omitempty"`
+
+	// Multiplier is the factor the delay grows by after every attempt. Defaults to 2.
+	Multiplier float64 `json:"multiplier,omitempty" yaml:"multiplier,omitempty" mapstructure:"multiplier,omitempty"`
+
+	// Jitter randomizes each delay by up to this fraction in either direction, between 0 and 1.
+	Jitter float64 `json:"jitter,omitempty" yaml:"jitter,omitempty" mapstructure:"jitter,omitempty"`
+
+	// MaxElapsed stops retrying once this much time has passed since the first attempt.
+	// Zero means no limit.
+	MaxElapsed time.Duration `json:"max_elapsed,omitempty" yaml:"max_elapsed,omitempty" mapstructure:"max_elapsed,omitempty"`
+}
+
+// DefaultRetryPolicy returns a RetryPolicy making 3 attempts, starting at 100ms and doubling up
+// to 5s, with 20% jitter.
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts:     3,
+		InitialInterval: 100 * time.Millisecond,
+		MaxInterval:     5 * time.Second,
+		Multiplier:      2,
+		Jitter:          0.2,
+	}
+}
+
+// Validate implements the cfx.Validator interface.
+func (r RetryPolicy) Validate() error {
+	if r.MaxAttempts < 0 {
+		return errors.New("retry max_attempts must not be negative")
+	}
+	if r.MaxAttempts == 0 && r.MaxElapsed == 0 {
+		return errors.New("retry policy must set max_attempts or max_elapsed")
+	}
+	if r.InitialInterval < 0 || r.MaxInterval < 0 || r.MaxElapsed < 0 {
+		return errors.New("retry intervals must not be negative")
+	}
+	if r.MaxInterval != 0 && r.MaxInterval < r.InitialInterval {
+		return errors.New("retry max_interval must not be lower than initial_interval")
+	}
+	if r.Multiplier != 0 && r.Multiplier < 1 {
+		return errors.New("retry multiplier must be at least 1")
+	}
+	if r.Jitter < 0 || r.Jitter > 1 {
+		return errors.New("retry jitter must be between 0 and 1")
+	}
+	return nil
+}
+
+// Delay returns the delay before the given retry, starting at 1, without jitter.
+func (r RetryPolicy) Delay(retry int) time.Duration {
+	if retry < 1 {
+		return 0
+	}
+	mult := r.Multiplier
+	if mult == 0 {
+		mult = 2
+	}
+	d := float64(r.InitialInterval) * math.Pow(mult, float64(retry-1))
+	if r.MaxInterval > 0 && d > float64(r.MaxInterval) {
+		return r.MaxInterval
+	}
+	if d > math.MaxInt64 {
+		return time.Duration(math.MaxInt64)
+	}
+	return time.Duration(d)
+}
+
+// Backoff returns a new Backoff following the policy. It satisfies the backoff.BackOff
+// interface of github.com/cenkalti/backoff.
+func (r RetryPolicy) Backoff() *Backoff {
+	b := &Backoff{policy: r}
+	b.Reset()
+	return b
+}
+
+// Do calls fn until it succeeds, the policy is exhausted or ctx is done, and returns the last
+// error.
+func (r RetryPolicy) Do(ctx context.Context, fn func(context.Context) error) error {
+	b := r.Backoff()
+	for {
+		err := fn(ctx)
+		if err == nil {
+			return nil
+		}
+
+		d := b.NextBackOff()
+		if d == BackoffStop {
+			return err
+		}
+
+		t := time.NewTimer(d)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return err
+		case <-t.C:
+		}
+	}
+}
+
+// Backoff produces the delays of a RetryPolicy. It is safe for concurrent use.
+type Backoff struct {
+	mu      sync.Mutex
+	policy  RetryPolicy
+	retries int
+	start   time.Time
+}
+
+// Reset restarts the backoff, as if no attempt had been made.
+func (b *Backoff) Reset() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.retries = 0
+	b.start = time.Now()
+}
+
+// NextBackOff returns the delay before the next attempt, or BackoffStop when the policy is
+// exhausted.
+func (b *Backoff) NextBackOff() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	p := b.policy
+	if p.MaxAttempts > 0 && b.retries+1 >= p.MaxAttempts {
+		return BackoffStop
+	}
+	b.retries++
+
+	d := p.Delay(b.retries)
+	if p.Jitter > 0 {
+		delta := p.Jitter * float64(d)
+		d = time.Duration(float64(d) - delta + rand.Float64()*2*delta)
+	}
+	if p.MaxElapsed > 0 && time.Since(b.start)+d > p.MaxElapsed {
+		return BackoffStop
+	}
+	return d
+}
+
+// TimeoutPolicy is a reusable configuration shape for bounding operations in time.
+type TimeoutPolicy struct {
+	// Timeout bounds the whole operation, including retries. Zero means no limit.
+	Timeout time.Duration `json:"timeout,omitempty" yaml:"timeout,omitempty" mapstructure:"timeout,omitempty"`
+
+	// AttemptTimeout bounds each attempt. Zero means attempts are only bound by Timeout.
+	AttemptTimeout time.Duration `json:"attempt_timeout,omitempty" yaml:"attempt_timeout,omitempty" mapstructure:"attempt_timeout,omitempty"`
+}
+
+// Validate implements the cfx.Validator interface.
+func (t TimeoutPolicy) Validate() error {
+	if t.Timeout < 0 || t.AttemptTimeout < 0 {
+		return errors.New("timeouts must not be negative")
+	}
+	if t.Timeout > 0 && t.AttemptTimeout > t.Timeout {
+		return errors.New("attempt_timeout must not be longer than timeout")
+	}
+	return nil
+}
+
+// Context returns a context bound by Timeout. The returned cancel function must be called.
+func (t TimeoutPolicy) Context(ctx context.Context) (context.Context, context.CancelFunc) {
+	return withOptionalTimeout(ctx, t.Timeout)
+}
+
+// AttemptContext returns a context bound by AttemptTimeout, for a single attempt. The returned
+// cancel function must be called.
+func (t TimeoutPolicy) AttemptContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return withOptionalTimeout(ctx, t.AttemptTimeout)
+}
+
+// Deadline returns the deadline of an operation starting at now, and false if there is none.
+func (t TimeoutPolicy) Deadline(now time.Time) (time.Time, bool) {
+	if t.Timeout <= 0 {
+		return time.Time{}, false
+	}
+	return now.Add(t.Timeout), true
+}
+
+func withOptionalTimeout(ctx context.Context, d time.Duration) (context.Context, context.CancelFunc) {
+	if d <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, d)
+}
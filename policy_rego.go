@@ -0,0 +1,243 @@
+package cfx
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"go.uber.org/fx"
+)
+
+// PoliciesKey is the config section read by PolicyModule.
+const PoliciesKey = "policies"
+
+// PolicyModule evaluates Rego policies against the merged configuration and the EnvContext
+// when the application is constructed, blocking startup on violations. The application
+// provides the PolicyEvaluator, usually an adapter over github.com/open-policy-agent/opa/rego.
+var PolicyModule = fx.Options(
+	ProvideSection(PoliciesKey, DefaultPolicyConfig()),
+	fx.Invoke(EnforcePolicies),
+)
+
+// PolicyConfig is the configuration section for policy checks.
+type PolicyConfig struct {
+	// Dir is the directory holding .rego files. Relative paths are resolved against the
+	// ConfigPath. Defaults to "policies".
+	Dir string `json:"dir,omitempty" yaml:"dir,omitempty" mapstructure:"dir,omitempty"`
+
+	// Bundle is a .tar or .tar.gz policy bundle to read .rego files from instead of Dir.
+	Bundle string `json:"bundle,omitempty" yaml:"bundle,omitempty" mapstructure:"bundle,omitempty"`
+
+	// Query is the Rego query yielding the violation messages. Defaults to "data.cfx.deny".
+	Query string `json:"query,omitempty" yaml:"query,omitempty" mapstructure:"query,omitempty"`
+
+	// Environments limits enforcement to these environments. Violations elsewhere are only
+	// logged. Empty means every environment.
+	Environments []EnvID `json:"environments,omitempty" yaml:"environments,omitempty" mapstructure:"environments,omitempty"`
+
+	// Disabled turns policy checks off.
+	Disabled bool `json:"disabled,omitempty" yaml:"disabled,omitempty" mapstructure:"disabled,omitempty"`
+}
+
+// DefaultPolicyConfig returns a PolicyConfig reading policies from the "policies" directory
+// and querying data.cfx.deny.
+func DefaultPolicyConfig() *PolicyConfig {
+	return &PolicyConfig{Dir: "policies", Query: "data.cfx.deny"}
+}
+
+// Validate implements the cfx.Validator interface.
+func (p PolicyConfig) Validate() error {
+	if p.Disabled {
+		return nil
+	}
+	if p.Dir == "" && p.Bundle == "" {
+		return errors.New("policies require a dir or a bundle")
+	}
+	if p.Query == "" {
+		return errors.New("policies query must not be empty")
+	}
+	return nil
+}
+
+// PolicyEvaluator evaluates Rego modules, keyed by file name, against input and returns the
+// violation messages produced by query.
+type PolicyEvaluator interface {
+	EvalPolicies(ctx context.Context, modules map[string]string, query string, input map[string]interface{}) ([]string, error)
+}
+
+// PolicyViolations is returned when the configuration violates policies.
+type PolicyViolations []string
+
+// Error implements the error interface.
+func (p PolicyViolations) Error() string {
+	return fmt.Sprintf("configuration violates policies: %s", strings.Join(p, "; "))
+}
+
+// PolicyParams are the dependencies of EnforcePolicies.
+type PolicyParams struct {
+	fx.In
+
+	Env       EnvContext
+	Config    Container
+	Evaluator PolicyEvaluator
+}
+
+// EnforcePolicies reads the "policies" section and evaluates the policies it points to. The
+// policy input is {"env": <EnvContext>, "config": <merged configuration>}.
+func EnforcePolicies(p PolicyParams) error {
+	cfg := DefaultPolicyConfig()
+	if err := p.Config.Populate(PoliciesKey, cfg); err != nil {
+		return fmt.Errorf("could not populate policies config: %v", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		return SectionError{Key: PoliciesKey, Err: err}
+	}
+	if cfg.Disabled {
+		return nil
+	}
+
+	modules, err := loadPolicyModules(p.Env, *cfg)
+	if err != nil {
+		return err
+	}
+	if len(modules) == 0 {
+		return nil
+	}
+
+	input, err := policyInput(p.Env, p.Config)
+	if err != nil {
+		return err
+	}
+
+	violations, err := p.Evaluator.EvalPolicies(context.Background(), modules, cfg.Query, input)
+	if err != nil {
+		return fmt.Errorf("could not evaluate policies: %v", err)
+	}
+	if len(violations) == 0 {
+		return nil
+	}
+
+	sort.Strings(violations)
+	if !policyEnforced(p.Env, cfg.Environments) {
+		for _, v := range violations {
+			log.Printf("cfx: policy violation (not enforced in %s): %s", p.Env.Environment, v)
+		}
+		return nil
+	}
+	return PolicyViolations(violations)
+}
+
+func policyEnforced(env EnvContext, envs []EnvID) bool {
+	if len(envs) == 0 {
+		return true
+	}
+	for _, e := range envs {
+		if e == env.Environment {
+			return true
+		}
+	}
+	return false
+}
+
+// policyInput builds the input document policies are evaluated against.
+func policyInput(env EnvContext, c Container) (map[string]interface{}, error) {
+	var tree interface{}
+	if y, ok := c.(*yamlContainer); ok {
+		if snap := y.currentSnapshot(); snap != nil {
+			full, err := snap.materialized()
+			if err != nil {
+				return nil, err
+			}
+			tree = full
+		}
+	} else {
+		var raw interface{}
+		if err := c.Populate("", &raw); err != nil {
+			return nil, fmt.Errorf("could not read configuration for policies: %v", err)
+		}
+		tree = normalizeValue(raw)
+	}
+
+	return map[string]interface{}{
+		"env":    env,
+		"config": tree,
+	}, nil
+}
+
+// loadPolicyModules reads the .rego files from the configured bundle or directory.
+func loadPolicyModules(env EnvContext, cfg PolicyConfig) (map[string]string, error) {
+	resolve := func(p string) string {
+		if filepath.IsAbs(p) {
+			return p
+		}
+		return filepath.Join(env.ConfigPath, p)
+	}
+
+	if cfg.Bundle != "" {
+		return readPolicyBundle(resolve(cfg.Bundle))
+	}
+
+	dir := resolve(cfg.Dir)
+	paths, err := filepath.Glob(filepath.Join(dir, "*.rego"))
+	if err != nil {
+		return nil, fmt.Errorf("could not list policies in %s: %v", dir, err)
+	}
+
+	modules := map[string]string{}
+	for _, p := range paths {
+		data, err := ioutil.ReadFile(p)
+		if err != nil {
+			return nil, fmt.Errorf("could not read policy %s: %v", p, err)
+		}
+		modules[filepath.Base(p)] = string(data)
+	}
+	return modules, nil
+}
+
+// readPolicyBundle reads the .rego files of a .tar or .tar.gz bundle, such as an OPA bundle.
+func readPolicyBundle(path string) (map[string]string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read policy bundle %s: %v", path, err)
+	}
+
+	var r io.Reader = bytes.NewReader(data)
+	if len(data) > 2 && data[0] == 0x1f && data[1] == 0x8b {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("could not decompress policy bundle %s: %v", path, err)
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	modules := map[string]string{}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("could not read policy bundle %s: %v", path, err)
+		}
+		if hdr.Typeflag != tar.TypeReg || filepath.Ext(hdr.Name) != ".rego" {
+			continue
+		}
+		src, err := ioutil.ReadAll(io.LimitReader(tr, 16<<20))
+		if err != nil {
+			return nil, fmt.Errorf("could not read %s from policy bundle %s: %v", hdr.Name, path, err)
+		}
+		modules[strings.TrimPrefix(hdr.Name, "/")] = string(src)
+	}
+	return modules, nil
+}
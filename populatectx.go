@@ -0,0 +1,77 @@
+package cfx
+
+import (
+	"context"
+	"reflect"
+)
+
+// ContextResolver is implemented by configuration values that need to fetch remote data, such
+// as secrets, after they are populated. PopulateContext calls ResolveContext on the target and
+// on every addressable value within it that implements the interface.
+type ContextResolver interface {
+	ResolveContext(ctx context.Context) error
+}
+
+// PopulateContext implements the cfgfx.Container interface.
+func (y *yamlContainer) PopulateContext(ctx context.Context, key string, target interface{}) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := y.Populate(key, target); err != nil {
+		return err
+	}
+
+	if snap := y.currentSnapshot(); snap != nil {
+		start := y.opts.clock()
+		defer snap.stages.record(y.opts, StageSecrets, start)
+	}
+	return resolveContext(ctx, reflect.ValueOf(target))
+}
+
+// resolveContext walks v, calling ResolveContext on every ContextResolver it finds. The walk
+// stops as soon as ctx is done.
+func resolveContext(ctx context.Context, v reflect.Value) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if !v.IsValid() {
+		return nil
+	}
+
+	if v.Kind() != reflect.Ptr && v.CanAddr() {
+		if r, ok := v.Addr().Interface().(ContextResolver); ok {
+			return r.ResolveContext(ctx)
+		}
+	}
+	if v.Kind() == reflect.Ptr && !v.IsNil() {
+		if r, ok := v.Interface().(ContextResolver); ok {
+			return r.ResolveContext(ctx)
+		}
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		return resolveContext(ctx, v.Elem())
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < v.NumField(); i++ {
+			if t.Field(i).PkgPath != "" {
+				continue // unexported
+			}
+			if err := resolveContext(ctx, v.Field(i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if err := resolveContext(ctx, v.Index(i)); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,299 @@
+package cfx
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/fx"
+)
+
+// PreflightKey is the config section read by PreflightModule.
+const PreflightKey = "preflight"
+
+// PreflightModule runs the dependency checks listed in the "preflight" config section when the
+// application starts, failing startup if a required check fails. It provides a *Preflight whose
+// results can be served from an admin endpoint.
+var PreflightModule = fx.Options(
+	ProvideSection(PreflightKey, DefaultPreflightConfig()),
+	fx.Provide(NewPreflight),
+	fx.Invoke(func(*Preflight) {}),
+)
+
+// Preflight check types.
+const (
+	PreflightTCP  = "tcp"
+	PreflightHTTP = "http"
+	PreflightDNS  = "dns"
+)
+
+// PreflightConfig is the configuration section for startup dependency checks.
+type PreflightConfig struct {
+	// Timeout is the default timeout of each check. Defaults to 5 seconds.
+	Timeout time.Duration `json:"timeout,omitempty" yaml:"timeout,omitempty" mapstructure:"timeout,omitempty"`
+
+	// Checks are the checks run at startup, in parallel.
+	Checks []PreflightCheck `json:"checks,omitempty" yaml:"checks,omitempty" mapstructure:"checks,omitempty"`
+}
+
+// PreflightCheck describes a single dependency check.
+type PreflightCheck struct {
+	// Name identifies the check in logs and results. Defaults to the target.
+	Name string `json:"name,omitempty" yaml:"name,omitempty" mapstructure:"name,omitempty"`
+
+	// Type is one of tcp, http or dns.
+	Type string `json:"type,omitempty" yaml:"type,omitempty" mapstructure:"type,omitempty"`
+
+	// Target is a host:port for tcp checks, a URL for http checks and a host name for dns checks.
+	Target string `json:"target,omitempty" yaml:"target,omitempty" mapstructure:"target,omitempty"`
+
+	// Timeout overrides the section's default timeout.
+	Timeout time.Duration `json:"timeout,omitempty" yaml:"timeout,omitempty" mapstructure:"timeout,omitempty"`
+
+	// ExpectStatus is the status code an http check must return. By default any 2xx or 3xx
+	// status passes.
+	ExpectStatus int `json:"expect_status,omitempty" yaml:"expect_status,omitempty" mapstructure:"expect_status,omitempty"`
+
+	// Optional checks are reported but do not fail startup.
+	Optional bool `json:"optional,omitempty" yaml:"optional,omitempty" mapstructure:"optional,omitempty"`
+}
+
+// DefaultPreflightConfig returns a PreflightConfig with a 5 second timeout and no checks.
+func DefaultPreflightConfig() *PreflightConfig {
+	return &PreflightConfig{Timeout: 5 * time.Second}
+}
+
+// Validate implements the cfx.Validator interface.
+func (p PreflightConfig) Validate() error {
+	if p.Timeout < 0 {
+		return errors.New("preflight timeout must not be negative")
+	}
+	for i, c := range p.Checks {
+		if err := c.Validate(); err != nil {
+			return fmt.Errorf("preflight check %d: %v", i, err)
+		}
+	}
+	return nil
+}
+
+// Validate implements the cfx.Validator interface.
+func (c PreflightCheck) Validate() error {
+	if c.Target == "" {
+		return errors.New("target is required")
+	}
+	if c.Timeout < 0 {
+		return errors.New("timeout must not be negative")
+	}
+	switch strings.ToLower(c.Type) {
+	case PreflightTCP:
+		if _, _, err := net.SplitHostPort(c.Target); err != nil {
+			return fmt.Errorf("tcp target %q is invalid: %v", c.Target, err)
+		}
+	case PreflightHTTP:
+		if !strings.HasPrefix(c.Target, "http://") && !strings.HasPrefix(c.Target, "https://") {
+			return fmt.Errorf("http target %q must be an http or https URL", c.Target)
+		}
+	case PreflightDNS:
+	default:
+		return fmt.Errorf("unknown check type %q", c.Type)
+	}
+	return nil
+}
+
+func (c PreflightCheck) name() string {
+	if c.Name != "" {
+		return c.Name
+	}
+	return c.Target
+}
+
+// PreflightResult is the outcome of a single check.
+type PreflightResult struct {
+	Name     string        `json:"name"`
+	Type     string        `json:"type"`
+	Target   string        `json:"target"`
+	Optional bool          `json:"optional,omitempty"`
+	OK       bool          `json:"ok"`
+	Error    string        `json:"error,omitempty"`
+	Duration time.Duration `json:"duration"`
+	Time     time.Time     `json:"time"`
+}
+
+// Preflight runs the configured checks and keeps the results of the last run.
+type Preflight struct {
+	sync.RWMutex
+
+	cfg     PreflightConfig
+	results []PreflightResult
+}
+
+// PreflightParams are the dependencies of NewPreflight.
+type PreflightParams struct {
+	fx.In
+
+	Lifecycle fx.Lifecycle
+	Config    Container
+}
+
+// NewPreflight reads the "preflight" section and runs its checks when the application starts.
+func NewPreflight(p PreflightParams) (*Preflight, error) {
+	cfg := DefaultPreflightConfig()
+	if err := p.Config.Populate(PreflightKey, cfg); err != nil {
+		return nil, fmt.Errorf("could not populate preflight config: %v", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, SectionError{Key: PreflightKey, Err: err}
+	}
+
+	pf := &Preflight{cfg: *cfg}
+	p.Lifecycle.Append(fx.Hook{
+		OnStart: pf.Run,
+	})
+	return pf, nil
+}
+
+// Run executes every check in parallel and logs the outcome of each. It returns an error
+// listing the required checks that failed.
+func (p *Preflight) Run(ctx context.Context) error {
+	results := make([]PreflightResult, len(p.cfg.Checks))
+
+	var wg sync.WaitGroup
+	for i, c := range p.cfg.Checks {
+		wg.Add(1)
+		go func(i int, c PreflightCheck) {
+			defer wg.Done()
+			results[i] = p.check(ctx, c)
+		}(i, c)
+	}
+	wg.Wait()
+
+	p.Lock()
+	p.results = results
+	p.Unlock()
+
+	var failed []string
+	for _, r := range results {
+		switch {
+		case r.OK:
+			log.Printf("cfx: preflight %s ok (%s)", r.Name, r.Duration)
+		case r.Optional:
+			log.Printf("cfx: preflight %s failed, continuing as it is optional: %s", r.Name, r.Error)
+		default:
+			log.Printf("cfx: preflight %s failed: %s", r.Name, r.Error)
+			failed = append(failed, fmt.Sprintf("%s: %s", r.Name, r.Error))
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("preflight checks failed: %s", strings.Join(failed, "; "))
+	}
+	return nil
+}
+
+func (p *Preflight) check(ctx context.Context, c PreflightCheck) PreflightResult {
+	timeout := c.Timeout
+	if timeout == 0 {
+		timeout = p.cfg.Timeout
+	}
+	if timeout == 0 {
+		timeout = 5 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	res := PreflightResult{
+		Name:     c.name(),
+		Type:     strings.ToLower(c.Type),
+		Target:   c.Target,
+		Optional: c.Optional,
+		Time:     time.Now(),
+	}
+
+	var err error
+	switch res.Type {
+	case PreflightTCP:
+		var conn net.Conn
+		conn, err = (&net.Dialer{}).DialContext(ctx, "tcp", c.Target)
+		if err == nil {
+			conn.Close()
+		}
+	case PreflightHTTP:
+		err = checkHTTP(ctx, c)
+	case PreflightDNS:
+		var addrs []string
+		addrs, err = net.DefaultResolver.LookupHost(ctx, c.Target)
+		if err == nil && len(addrs) == 0 {
+			err = fmt.Errorf("no addresses found for %s", c.Target)
+		}
+	}
+
+	res.Duration = time.Since(res.Time)
+	res.OK = err == nil
+	if err != nil {
+		res.Error = err.Error()
+	}
+	return res
+}
+
+func checkHTTP(ctx context.Context, c PreflightCheck) error {
+	req, err := http.NewRequest(http.MethodGet, c.Target, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if c.ExpectStatus != 0 {
+		if resp.StatusCode != c.ExpectStatus {
+			return fmt.Errorf("got status %d, expected %d", resp.StatusCode, c.ExpectStatus)
+		}
+		return nil
+	}
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("got status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// Results returns the results of the last run, in the order the checks are configured.
+func (p *Preflight) Results() []PreflightResult {
+	p.RLock()
+	defer p.RUnlock()
+	return append([]PreflightResult{}, p.results...)
+}
+
+// Passed reports whether every required check passed in the last run.
+func (p *Preflight) Passed() bool {
+	p.RLock()
+	defer p.RUnlock()
+	if p.results == nil && len(p.cfg.Checks) > 0 {
+		return false
+	}
+	for _, r := range p.results {
+		if !r.OK && !r.Optional {
+			return false
+		}
+	}
+	return true
+}
+
+// ServeHTTP serves the results of the last run as JSON, so the Preflight can be mounted on
+// an admin mux. The status is 503 when a required check failed or the checks have not run.
+func (p *Preflight) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	status := http.StatusOK
+	if !p.Passed() {
+		status = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(p.Results())
+}
@@ -0,0 +1,78 @@
+package cfx
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+// WithInteractiveFallback makes a CLI tool ask for missing configuration instead of failing
+// when a user is at the terminal: required variables declared with DefineVar, and ${VAR:?msg}
+// or unset ${VAR} references that would be an error. Answers are set in the process
+// environment, so each value is asked for once and reloads see it. Variables declared
+// WithSecret are read without echo. When standard input or error is not a terminal, as in
+// CI or under a supervisor, missing configuration fails as it would without the option.
+//
+//	env, err := cfx.NewEnvContextWithOptions("MYCLI", cfx.WithInteractiveFallback())
+func WithInteractiveFallback() Option {
+	return func(o *options) {
+		o.prompter = newPrompter(os.Stdin, os.Stderr)
+	}
+}
+
+// prompter asks the user at the terminal for missing values.
+type prompter struct {
+	sync.Mutex
+	in  *os.File
+	out *os.File
+	r   *bufio.Reader
+}
+
+func newPrompter(in *os.File, out *os.File) *prompter {
+	return &prompter{in: in, out: out, r: bufio.NewReader(in)}
+}
+
+// ask prompts for the variable key, and reports false if no one can be asked or the answer
+// is empty.
+func (p *prompter) ask(key, hint string, secret bool) (string, bool, error) {
+	if p == nil || !isTerminal(p.in.Fd()) || !isTerminal(p.out.Fd()) {
+		return "", false, nil
+	}
+
+	p.Lock()
+	defer p.Unlock()
+	// another goroutine may have asked already
+	if v, ok := os.LookupEnv(key); ok && v != "" {
+		return v, true, nil
+	}
+
+	label := key
+	if hint != "" {
+		label = fmt.Sprintf("%s (%s)", key, hint)
+	}
+	fmt.Fprintf(p.out, "%s: ", label)
+
+	var line string
+	var err error
+	if secret {
+		line, err = readHidden(p.in, p.r)
+		fmt.Fprintln(p.out)
+	} else {
+		line, err = p.r.ReadString('\n')
+	}
+	if err != nil && (err != io.EOF || line == "") {
+		return "", false, fmt.Errorf("could not read %s from the terminal: %v", key, err)
+	}
+
+	val := strings.TrimRight(line, "\r\n")
+	if val == "" {
+		return "", false, nil
+	}
+	if err := os.Setenv(key, val); err != nil {
+		return "", false, fmt.Errorf("could not set %s: %v", key, err)
+	}
+	return val, true, nil
+}
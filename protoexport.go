@@ -0,0 +1,658 @@
+package cfx
+
+import (
+	"fmt"
+	"sort"
+)
+
+// The Go types in this file are encoded as the messages defined in proto/envcontext.proto, so
+// that sidecars and services written in other languages can consume the effective
+// configuration with code generated from that schema.
+
+// ToProto encodes the EnvContext as a cfx.v1.EnvContext message. Vars are not included.
+func (e EnvContext) ToProto() []byte {
+	p := &protoEncoder{}
+	encodeEnvContext(p, e)
+	return p.buf
+}
+
+// FromProto replaces the EnvContext with one decoded from a cfx.v1.EnvContext message.
+func (e *EnvContext) FromProto(data []byte) error {
+	*e = EnvContext{}
+	if err := decodeEnvContext(data, e); err != nil {
+		return fmt.Errorf("could not decode environment: %v", err)
+	}
+	return nil
+}
+
+// ToProto encodes the response as a cfx.v1.EffectiveConfig message.
+func (r *ConfigResponse) ToProto() ([]byte, error) {
+	p := &protoEncoder{}
+	p.optInt(1, int64(r.Version))
+	p.message(2, func(p *protoEncoder) error {
+		encodeEnvContext(p, r.Environment)
+		return nil
+	})
+	p.optString(3, r.Fingerprint)
+	for _, s := range r.Sources {
+		s := s
+		p.message(4, func(p *protoEncoder) error {
+			p.optString(1, s.Name)
+			p.optInt(2, int64(s.Size))
+			p.optString(3, s.Digest)
+			return nil
+		})
+	}
+	if err := p.message(5, func(p *protoEncoder) error {
+		return encodeStruct(p, r.Config)
+	}); err != nil {
+		return nil, fmt.Errorf("could not encode configuration: %v", err)
+	}
+	return p.buf, nil
+}
+
+// FromProto replaces the response with one decoded from a cfx.v1.EffectiveConfig message.
+// Numbers in the configuration are decoded as float64, as they are from JSON.
+func (r *ConfigResponse) FromProto(data []byte) error {
+	*r = ConfigResponse{Config: map[string]interface{}{}}
+	err := decodeProto(data, func(f protoField) error {
+		switch f.num {
+		case 1:
+			r.Version = int(f.int())
+		case 2:
+			return decodeEnvContext(f.data, &r.Environment)
+		case 3:
+			r.Fingerprint = f.str()
+		case 4:
+			s := ReportSource{}
+			if err := decodeProto(f.data, func(f protoField) error {
+				switch f.num {
+				case 1:
+					s.Name = f.str()
+				case 2:
+					s.Size = int(f.int())
+				case 3:
+					s.Digest = f.str()
+				}
+				return nil
+			}); err != nil {
+				return err
+			}
+			r.Sources = append(r.Sources, s)
+		case 5:
+			m, err := decodeStruct(f.data)
+			if err != nil {
+				return err
+			}
+			r.Config = m
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("could not decode effective configuration: %v", err)
+	}
+	return nil
+}
+
+// ExportProto encodes the configuration being served by c, and the environment it was loaded
+// in, as a cfx.v1.EffectiveConfig message. Values are expanded and not redacted, so the output
+// must be protected like the configuration files themselves.
+func ExportProto(c Container) ([]byte, error) {
+	y, ok := c.(*yamlContainer)
+	if !ok {
+		return nil, fmt.Errorf("container of type %T does not support exporting", c)
+	}
+	snap := y.currentSnapshot()
+	if snap == nil {
+		return nil, ErrNoConfigsLoaded
+	}
+	tree, err := snap.materialized()
+	if err != nil {
+		return nil, err
+	}
+
+	r := &ConfigResponse{
+		Version:     DistributionProtocolVersion,
+		Environment: y.env,
+		Fingerprint: snap.fingerprint,
+		Sources:     snap.sourceReports,
+		Config:      tree,
+	}
+	return r.ToProto()
+}
+
+func encodeEnvContext(p *protoEncoder, e EnvContext) {
+	p.optString(1, e.Environment.String())
+	p.optString(2, string(e.EnvPrefix))
+	p.optString(3, e.AppPath)
+	p.optString(4, e.ConfigPath)
+	for _, c := range e.ConfigPaths {
+		p.bytes(13, []byte(c))
+	}
+	p.message(5, func(p *protoEncoder) error {
+		p.optString(1, e.Host.Hostname)
+		p.optString(2, e.Host.UUID)
+		p.optString(3, e.Host.Timezone)
+		return p.message(4, func(p *protoEncoder) error {
+			sec := e.Host.Security
+			p.optString(1, sec.SELinux)
+			p.optString(2, sec.SELinuxMode)
+			p.optString(3, sec.AppArmor)
+			p.optString(4, sec.AppArmorMode)
+			p.optString(5, string(sec.Seccomp))
+			p.optBool(6, sec.NoNewPrivs)
+			return nil
+		})
+	})
+	p.message(6, func(p *protoEncoder) error {
+		p.optString(1, e.Go.OS)
+		p.optString(2, e.Go.Arch)
+		p.optString(3, e.Go.Version)
+		return p.message(4, func(p *protoEncoder) error {
+			p.optBool(1, e.Go.Crypto.BoringCrypto)
+			p.optBool(2, e.Go.Crypto.FIPS140)
+			p.optBool(3, e.Go.Crypto.OSFIPS)
+			p.optString(4, e.Go.Crypto.OSPolicy)
+			return nil
+		})
+	})
+	p.message(7, func(p *protoEncoder) error {
+		d := e.Deployment
+		p.optString(1, d.AppID)
+		p.optString(2, d.ServiceID)
+		p.optString(3, d.InstanceID)
+		p.optString(4, d.Region)
+		p.optString(5, d.AvailabilityZone)
+		p.optString(6, d.NetworkID)
+		p.optString(7, d.DatacenterID)
+		p.message(8, func(p *protoEncoder) error {
+			p.optString(1, d.SPIFFE.ID)
+			p.optString(2, d.SPIFFE.TrustDomain)
+			p.optString(3, d.SPIFFE.EndpointSocket)
+			return nil
+		})
+		return p.message(9, func(p *protoEncoder) error {
+			p.optString(1, string(d.Platform.Name))
+			p.optString(2, d.Platform.ID)
+			p.optString(3, d.Platform.App)
+			p.optString(4, d.Platform.Task)
+			p.optString(5, d.Platform.Index)
+			p.optString(6, d.Platform.Cluster)
+			p.optString(7, d.Platform.Namespace)
+			p.optString(8, d.Platform.Region)
+			p.optString(9, d.Platform.Zone)
+			p.optString(10, d.Platform.Datacenter)
+			p.optString(11, d.Platform.Service)
+			return nil
+		})
+	})
+	p.message(8, func(p *protoEncoder) error {
+		p.optString(1, e.User.Username)
+		p.optString(2, e.User.UID)
+		p.optString(3, e.User.GID)
+		return nil
+	})
+	p.message(9, func(p *protoEncoder) error {
+		p.optInt(1, int64(e.Process.PID))
+		p.optInt(2, int64(e.Process.PPID))
+		p.message(3, func(p *protoEncoder) error {
+			p.optString(1, e.Process.Systemd.Unit)
+			p.optString(2, e.Process.Systemd.InvocationID)
+			p.optString(3, e.Process.Systemd.CredentialsDirectory)
+			return nil
+		})
+		p.message(4, func(p *protoEncoder) error {
+			p.optBool(1, e.Process.Windows.Service)
+			p.optString(2, e.Process.Windows.ServiceName)
+			p.optInt(3, int64(e.Process.Windows.SessionID))
+			return nil
+		})
+		p.optString(5, string(e.Process.Supervisor))
+		return nil
+	})
+	p.message(10, func(p *protoEncoder) error {
+		p.optInt(1, int64(e.Resources.NumCPU))
+		p.optDouble(2, e.Resources.CPULimit)
+		p.optInt(3, e.Resources.MemoryLimit)
+		p.optInt(4, e.Resources.OpenFiles)
+		p.optInt(5, e.Resources.OpenFilesMax)
+		p.optInt(6, e.Resources.Processes)
+		p.optInt(7, e.Resources.ProcessesMax)
+		p.optInt(8, e.Resources.MaxThreads)
+		return nil
+	})
+	p.message(11, func(p *protoEncoder) error {
+		proxy := e.Proxy.Redacted()
+		p.optString(1, proxy.HTTPProxy)
+		p.optString(2, proxy.HTTPSProxy)
+		p.optString(3, proxy.NoProxy)
+		return nil
+	})
+	p.message(12, func(p *protoEncoder) error {
+		p.optString(1, e.Locale.System)
+		p.optString(2, e.Locale.Default)
+		for _, s := range e.Locale.Supported {
+			p.bytes(3, []byte(s))
+		}
+		return nil
+	})
+	p.message(14, func(p *protoEncoder) error {
+		p.optString(1, string(e.Serverless.Provider))
+		p.optString(2, e.Serverless.FunctionName)
+		p.optString(3, e.Serverless.Version)
+		p.optInt(4, int64(e.Serverless.MemoryMB))
+		p.optBool(5, e.Serverless.ColdStart)
+		return nil
+	})
+	p.message(15, func(p *protoEncoder) error {
+		p.optBool(1, e.Terminal.Stdin)
+		p.optBool(2, e.Terminal.Stdout)
+		p.optBool(3, e.Terminal.Stderr)
+		p.optInt(4, int64(e.Terminal.Width))
+		p.optInt(5, int64(e.Terminal.Height))
+		p.optString(6, string(e.Terminal.Color))
+		return nil
+	})
+}
+
+func decodeEnvContext(data []byte, e *EnvContext) error {
+	return decodeProto(data, func(f protoField) error {
+		switch f.num {
+		case 1:
+			e.Environment = EnvID(f.str())
+		case 2:
+			e.EnvPrefix = EnvKeyPrefix(f.str())
+		case 3:
+			e.AppPath = f.str()
+		case 4:
+			e.ConfigPath = f.str()
+		case 13:
+			e.ConfigPaths = append(e.ConfigPaths, f.str())
+		case 5:
+			return decodeProto(f.data, func(f protoField) error {
+				switch f.num {
+				case 1:
+					e.Host.Hostname = f.str()
+				case 2:
+					e.Host.UUID = f.str()
+				case 3:
+					e.Host.Timezone = f.str()
+				case 4:
+					sec := &e.Host.Security
+					return decodeProto(f.data, func(f protoField) error {
+						switch f.num {
+						case 1:
+							sec.SELinux = f.str()
+						case 2:
+							sec.SELinuxMode = f.str()
+						case 3:
+							sec.AppArmor = f.str()
+						case 4:
+							sec.AppArmorMode = f.str()
+						case 5:
+							sec.Seccomp = SeccompMode(f.str())
+						case 6:
+							sec.NoNewPrivs = f.bool()
+						}
+						return nil
+					})
+				}
+				return nil
+			})
+		case 6:
+			return decodeProto(f.data, func(f protoField) error {
+				switch f.num {
+				case 1:
+					e.Go.OS = f.str()
+				case 2:
+					e.Go.Arch = f.str()
+				case 3:
+					e.Go.Version = f.str()
+				case 4:
+					return decodeProto(f.data, func(f protoField) error {
+						switch f.num {
+						case 1:
+							e.Go.Crypto.BoringCrypto = f.bool()
+						case 2:
+							e.Go.Crypto.FIPS140 = f.bool()
+						case 3:
+							e.Go.Crypto.OSFIPS = f.bool()
+						case 4:
+							e.Go.Crypto.OSPolicy = f.str()
+						}
+						return nil
+					})
+				}
+				return nil
+			})
+		case 7:
+			d := &e.Deployment
+			return decodeProto(f.data, func(f protoField) error {
+				switch f.num {
+				case 1:
+					d.AppID = f.str()
+				case 2:
+					d.ServiceID = f.str()
+				case 3:
+					d.InstanceID = f.str()
+				case 4:
+					d.Region = f.str()
+				case 5:
+					d.AvailabilityZone = f.str()
+				case 6:
+					d.NetworkID = f.str()
+				case 7:
+					d.DatacenterID = f.str()
+				case 8:
+					return decodeProto(f.data, func(f protoField) error {
+						switch f.num {
+						case 1:
+							d.SPIFFE.ID = f.str()
+						case 2:
+							d.SPIFFE.TrustDomain = f.str()
+						case 3:
+							d.SPIFFE.EndpointSocket = f.str()
+						}
+						return nil
+					})
+				case 9:
+					return decodeProto(f.data, func(f protoField) error {
+						switch f.num {
+						case 1:
+							d.Platform.Name = Platform(f.str())
+						case 2:
+							d.Platform.ID = f.str()
+						case 3:
+							d.Platform.App = f.str()
+						case 4:
+							d.Platform.Task = f.str()
+						case 5:
+							d.Platform.Index = f.str()
+						case 6:
+							d.Platform.Cluster = f.str()
+						case 7:
+							d.Platform.Namespace = f.str()
+						case 8:
+							d.Platform.Region = f.str()
+						case 9:
+							d.Platform.Zone = f.str()
+						case 10:
+							d.Platform.Datacenter = f.str()
+						case 11:
+							d.Platform.Service = f.str()
+						}
+						return nil
+					})
+				}
+				return nil
+			})
+		case 8:
+			return decodeProto(f.data, func(f protoField) error {
+				switch f.num {
+				case 1:
+					e.User.Username = f.str()
+				case 2:
+					e.User.UID = f.str()
+				case 3:
+					e.User.GID = f.str()
+				}
+				return nil
+			})
+		case 9:
+			return decodeProto(f.data, func(f protoField) error {
+				switch f.num {
+				case 1:
+					e.Process.PID = int(f.int())
+				case 2:
+					e.Process.PPID = int(f.int())
+				case 3:
+					sd := &e.Process.Systemd
+					return decodeProto(f.data, func(f protoField) error {
+						switch f.num {
+						case 1:
+							sd.Unit = f.str()
+						case 2:
+							sd.InvocationID = f.str()
+						case 3:
+							sd.CredentialsDirectory = f.str()
+						}
+						return nil
+					})
+				case 4:
+					w := &e.Process.Windows
+					return decodeProto(f.data, func(f protoField) error {
+						switch f.num {
+						case 1:
+							w.Service = f.bool()
+						case 2:
+							w.ServiceName = f.str()
+						case 3:
+							w.SessionID = int(f.int())
+						}
+						return nil
+					})
+				case 5:
+					e.Process.Supervisor = Supervisor(f.str())
+				}
+				return nil
+			})
+		case 10:
+			return decodeProto(f.data, func(f protoField) error {
+				switch f.num {
+				case 1:
+					e.Resources.NumCPU = int(f.int())
+				case 2:
+					e.Resources.CPULimit = f.double()
+				case 3:
+					e.Resources.MemoryLimit = f.int()
+				case 4:
+					e.Resources.OpenFiles = f.int()
+				case 5:
+					e.Resources.OpenFilesMax = f.int()
+				case 6:
+					e.Resources.Processes = f.int()
+				case 7:
+					e.Resources.ProcessesMax = f.int()
+				case 8:
+					e.Resources.MaxThreads = f.int()
+				}
+				return nil
+			})
+		case 11:
+			return decodeProto(f.data, func(f protoField) error {
+				switch f.num {
+				case 1:
+					e.Proxy.HTTPProxy = f.str()
+				case 2:
+					e.Proxy.HTTPSProxy = f.str()
+				case 3:
+					e.Proxy.NoProxy = f.str()
+				}
+				return nil
+			})
+		case 12:
+			return decodeProto(f.data, func(f protoField) error {
+				switch f.num {
+				case 1:
+					e.Locale.System = f.str()
+				case 2:
+					e.Locale.Default = f.str()
+				case 3:
+					e.Locale.Supported = append(e.Locale.Supported, f.str())
+				}
+				return nil
+			})
+		case 14:
+			return decodeProto(f.data, func(f protoField) error {
+				switch f.num {
+				case 1:
+					e.Serverless.Provider = ServerlessProvider(f.str())
+				case 2:
+					e.Serverless.FunctionName = f.str()
+				case 3:
+					e.Serverless.Version = f.str()
+				case 4:
+					e.Serverless.MemoryMB = int(f.int())
+				case 5:
+					e.Serverless.ColdStart = f.bool()
+				}
+				return nil
+			})
+		case 15:
+			return decodeProto(f.data, func(f protoField) error {
+				switch f.num {
+				case 1:
+					e.Terminal.Stdin = f.bool()
+				case 2:
+					e.Terminal.Stdout = f.bool()
+				case 3:
+					e.Terminal.Stderr = f.bool()
+				case 4:
+					e.Terminal.Width = int(f.int())
+				case 5:
+					e.Terminal.Height = int(f.int())
+				case 6:
+					e.Terminal.Color = ColorLevel(f.str())
+				}
+				return nil
+			})
+		}
+		return nil
+	})
+}
+
+// encodeStruct encodes m as a google.protobuf.Struct, with keys sorted so that the output is
+// deterministic.
+func encodeStruct(p *protoEncoder, m map[string]interface{}) error {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		v := m[k]
+		if err := p.message(1, func(p *protoEncoder) error {
+			p.bytes(1, []byte(k))
+			return p.message(2, func(p *protoEncoder) error {
+				return encodeValue(p, v)
+			})
+		}); err != nil {
+			return fmt.Errorf("%s: %v", k, err)
+		}
+	}
+	return nil
+}
+
+// encodeValue encodes v as a google.protobuf.Value.
+func encodeValue(p *protoEncoder, v interface{}) error {
+	switch t := v.(type) {
+	case nil:
+		p.varint(1, 0)
+	case string:
+		p.bytes(3, []byte(t))
+	case bool:
+		if t {
+			p.varint(4, 1)
+		} else {
+			p.varint(4, 0)
+		}
+	case int:
+		p.double(2, float64(t))
+	case int64:
+		p.double(2, float64(t))
+	case uint64:
+		p.double(2, float64(t))
+	case float64:
+		p.double(2, t)
+	case map[string]interface{}:
+		return p.message(5, func(p *protoEncoder) error {
+			return encodeStruct(p, t)
+		})
+	case map[interface{}]interface{}:
+		return encodeValue(p, normalizeValue(t))
+	case []interface{}:
+		return p.message(6, func(p *protoEncoder) error {
+			for i, item := range t {
+				if err := p.message(1, func(p *protoEncoder) error {
+					return encodeValue(p, item)
+				}); err != nil {
+					return fmt.Errorf("[%d]: %v", i, err)
+				}
+			}
+			return nil
+		})
+	default:
+		return fmt.Errorf("cannot encode %T as a protobuf value", v)
+	}
+	return nil
+}
+
+func decodeStruct(data []byte) (map[string]interface{}, error) {
+	ret := map[string]interface{}{}
+	err := decodeProto(data, func(f protoField) error {
+		if f.num != 1 {
+			return nil
+		}
+		var key string
+		var val interface{}
+		if err := decodeProto(f.data, func(f protoField) error {
+			switch f.num {
+			case 1:
+				key = f.str()
+			case 2:
+				v, err := decodeValue(f.data)
+				if err != nil {
+					return err
+				}
+				val = v
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+		ret[key] = val
+		return nil
+	})
+	return ret, err
+}
+
+func decodeValue(data []byte) (interface{}, error) {
+	var ret interface{}
+	err := decodeProto(data, func(f protoField) error {
+		switch f.num {
+		case 1:
+			ret = nil
+		case 2:
+			ret = f.double()
+		case 3:
+			ret = f.str()
+		case 4:
+			ret = f.bool()
+		case 5:
+			m, err := decodeStruct(f.data)
+			if err != nil {
+				return err
+			}
+			ret = m
+		case 6:
+			l := []interface{}{}
+			if err := decodeProto(f.data, func(f protoField) error {
+				if f.num != 1 {
+					return nil
+				}
+				v, err := decodeValue(f.data)
+				if err != nil {
+					return err
+				}
+				l = append(l, v)
+				return nil
+			}); err != nil {
+				return err
+			}
+			ret = l
+		}
+		return nil
+	})
+	return ret, err
+}
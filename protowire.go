@@ -0,0 +1,149 @@
+package cfx
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// Protocol buffer wire types.
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+// protoEncoder appends fields in the protocol buffer wire format. cfx does not depend on a
+// protobuf runtime; the messages it writes are small enough to encode by hand.
+type protoEncoder struct {
+	buf []byte
+}
+
+func (p *protoEncoder) uvarint(v uint64) {
+	var b [binary.MaxVarintLen64]byte
+	p.buf = append(p.buf, b[:binary.PutUvarint(b[:], v)]...)
+}
+
+func (p *protoEncoder) tag(field, wire int) {
+	p.uvarint(uint64(field)<<3 | uint64(wire))
+}
+
+func (p *protoEncoder) varint(field int, v uint64) {
+	p.tag(field, wireVarint)
+	p.uvarint(v)
+}
+
+func (p *protoEncoder) bytes(field int, v []byte) {
+	p.tag(field, wireBytes)
+	p.uvarint(uint64(len(v)))
+	p.buf = append(p.buf, v...)
+}
+
+func (p *protoEncoder) double(field int, v float64) {
+	p.tag(field, wireFixed64)
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], math.Float64bits(v))
+	p.buf = append(p.buf, b[:]...)
+}
+
+// message encodes a nested message built by fn.
+func (p *protoEncoder) message(field int, fn func(*protoEncoder) error) error {
+	sub := &protoEncoder{}
+	if err := fn(sub); err != nil {
+		return err
+	}
+	p.bytes(field, sub.buf)
+	return nil
+}
+
+// The opt methods follow proto3 semantics and leave out fields holding their zero value.
+
+func (p *protoEncoder) optString(field int, v string) {
+	if v != "" {
+		p.bytes(field, []byte(v))
+	}
+}
+
+func (p *protoEncoder) optInt(field int, v int64) {
+	if v != 0 {
+		p.varint(field, uint64(v))
+	}
+}
+
+func (p *protoEncoder) optBool(field int, v bool) {
+	if v {
+		p.varint(field, 1)
+	}
+}
+
+func (p *protoEncoder) optDouble(field int, v float64) {
+	if v != 0 {
+		p.double(field, v)
+	}
+}
+
+var errProtoTruncated = errors.New("truncated protobuf message")
+
+// protoField is a single field read by protoDecoder.
+type protoField struct {
+	num  int
+	wire int
+
+	// n holds varint and fixed width values, data holds length delimited ones.
+	n    uint64
+	data []byte
+}
+
+func (f protoField) str() string     { return string(f.data) }
+func (f protoField) int() int64      { return int64(f.n) }
+func (f protoField) bool() bool      { return f.n != 0 }
+func (f protoField) double() float64 { return math.Float64frombits(f.n) }
+
+// decodeProto calls fn for every field of a message in the protocol buffer wire format.
+func decodeProto(data []byte, fn func(protoField) error) error {
+	for len(data) > 0 {
+		key, n := binary.Uvarint(data)
+		if n <= 0 {
+			return errProtoTruncated
+		}
+		data = data[n:]
+
+		f := protoField{num: int(key >> 3), wire: int(key & 7)}
+		switch f.wire {
+		case wireVarint:
+			f.n, n = binary.Uvarint(data)
+			if n <= 0 {
+				return errProtoTruncated
+			}
+			data = data[n:]
+		case wireFixed64:
+			if len(data) < 8 {
+				return errProtoTruncated
+			}
+			f.n = binary.LittleEndian.Uint64(data)
+			data = data[8:]
+		case wireFixed32:
+			if len(data) < 4 {
+				return errProtoTruncated
+			}
+			f.n = uint64(binary.LittleEndian.Uint32(data))
+			data = data[4:]
+		case wireBytes:
+			l, n := binary.Uvarint(data)
+			if n <= 0 || uint64(len(data)-n) < l {
+				return errProtoTruncated
+			}
+			f.data = data[n : n+int(l)]
+			data = data[n+int(l):]
+		default:
+			return fmt.Errorf("unsupported protobuf wire type %d", f.wire)
+		}
+
+		if err := fn(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
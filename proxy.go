@@ -0,0 +1,220 @@
+package cfx
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// Environment variables that configure outbound proxies. Each is also read with the
+// application's EnvKeyPrefix, e.g. CFX_HTTP_PROXY, which takes precedence over the
+// conventional variable.
+const (
+	// KeyHTTPProxy is the proxy used for http:// requests.
+	KeyHTTPProxy EnvVar = EnvVar("HTTP_PROXY")
+
+	// KeyHTTPSProxy is the proxy used for https:// requests.
+	KeyHTTPSProxy EnvVar = EnvVar("HTTPS_PROXY")
+
+	// KeyNoProxy lists the hosts that are reached directly.
+	KeyNoProxy EnvVar = EnvVar("NO_PROXY")
+)
+
+func init() {
+	registerEnvVar(envVarInfo{Var: KeyHTTPProxy, Origin: OriginCFX, Secret: true, Description: "Proxy for http:// requests; overrides HTTP_PROXY."})
+	registerEnvVar(envVarInfo{Var: KeyHTTPSProxy, Origin: OriginCFX, Secret: true, Description: "Proxy for https:// requests; overrides HTTPS_PROXY."})
+	registerEnvVar(envVarInfo{Var: KeyNoProxy, Origin: OriginCFX, Description: "Hosts reached without a proxy; overrides NO_PROXY."})
+}
+
+// ProxyContext holds the outbound proxy settings of the process. Credentials in the proxy URLs
+// are kept so ProxyFunc can authenticate, but are masked whenever the context is encoded as
+// JSON, YAML or protobuf, so they do not end up in reports, replay files or server responses.
+type ProxyContext struct {
+	// HTTPProxy is the proxy URL for http:// requests.
+	HTTPProxy string `json:"http_proxy,omitempty" yaml:"http_proxy,omitempty" mapstructure:"http_proxy,omitempty"`
+
+	// HTTPSProxy is the proxy URL for https:// requests.
+	HTTPSProxy string `json:"https_proxy,omitempty" yaml:"https_proxy,omitempty" mapstructure:"https_proxy,omitempty"`
+
+	// NoProxy is a comma separated list of hosts, domains, IPs and CIDRs reached directly.
+	NoProxy string `json:"no_proxy,omitempty" yaml:"no_proxy,omitempty" mapstructure:"no_proxy,omitempty"`
+}
+
+// DetectProxy reads the proxy settings from the environment. For each setting the prefixed
+// variable (e.g. CFX_HTTPS_PROXY) wins over the upper case variable (HTTPS_PROXY), which wins
+// over the lower case one (https_proxy).
+func DetectProxy(prefix EnvKeyPrefix) ProxyContext {
+	lookup := func(key EnvVar) string {
+		for _, name := range []string{key.Key(prefix), string(key), strings.ToLower(string(key))} {
+			if v, ok := os.LookupEnv(name); ok {
+				return strings.TrimSpace(v)
+			}
+		}
+		return ""
+	}
+
+	return ProxyContext{
+		HTTPProxy:  lookup(KeyHTTPProxy),
+		HTTPSProxy: lookup(KeyHTTPSProxy),
+		NoProxy:    lookup(KeyNoProxy),
+	}
+}
+
+// Enabled reports whether any proxy is configured.
+func (p ProxyContext) Enabled() bool {
+	return p.HTTPProxy != "" || p.HTTPSProxy != ""
+}
+
+// _redactedProxyUser replaces credentials in redacted proxy URLs.
+const _redactedProxyUser = "xxxxx"
+
+// Redacted returns a copy with any credentials in the proxy URLs masked, suitable for logging.
+// The password is masked, or the user name when there is no password, as it is then usually a
+// token.
+func (p ProxyContext) Redacted() ProxyContext {
+	redact := func(raw string) string {
+		if raw == "" {
+			return raw
+		}
+		u, err := parseProxyURL(raw)
+		if err != nil {
+			// unparseable values may still carry credentials
+			if i := strings.LastIndex(raw, "@"); i >= 0 {
+				return _redactedProxyUser + raw[i:]
+			}
+			return raw
+		}
+		if u.User == nil {
+			return raw
+		}
+		if _, ok := u.User.Password(); ok {
+			u.User = url.UserPassword(u.User.Username(), _redactedProxyUser)
+		} else {
+			u.User = url.User(_redactedProxyUser)
+		}
+		return u.String()
+	}
+	p.HTTPProxy = redact(p.HTTPProxy)
+	p.HTTPSProxy = redact(p.HTTPSProxy)
+	return p
+}
+
+// MarshalJSON implements the json.Marshaler interface, encoding the Redacted settings.
+func (p ProxyContext) MarshalJSON() ([]byte, error) {
+	type plain ProxyContext
+	return json.Marshal(plain(p.Redacted()))
+}
+
+// MarshalYAML implements the yaml.Marshaler interface, encoding the Redacted settings.
+func (p ProxyContext) MarshalYAML() (interface{}, error) {
+	type plain ProxyContext
+	return plain(p.Redacted()), nil
+}
+
+// Validate checks that the proxy URLs can be parsed.
+func (p ProxyContext) Validate() error {
+	for _, raw := range []string{p.HTTPProxy, p.HTTPSProxy} {
+		if raw == "" {
+			continue
+		}
+		if _, err := parseProxyURL(raw); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ProxyFunc returns a function suitable for http.Transport.Proxy that applies these settings.
+// As with http.ProxyFromEnvironment, requests to localhost and loopback addresses are never
+// proxied.
+func (p ProxyContext) ProxyFunc() func(*http.Request) (*url.URL, error) {
+	return func(req *http.Request) (*url.URL, error) {
+		raw := p.HTTPProxy
+		if req.URL.Scheme == "https" {
+			raw = p.HTTPSProxy
+		}
+		if raw == "" || !p.useProxy(req.URL) {
+			return nil, nil
+		}
+		return parseProxyURL(raw)
+	}
+}
+
+// Transport returns a clone of http.DefaultTransport that uses these proxy settings.
+func (p ProxyContext) Transport() (*http.Transport, error) {
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.Proxy = p.ProxyFunc()
+	return t, nil
+}
+
+func (p ProxyContext) useProxy(u *url.URL) bool {
+	host, port := u.Hostname(), u.Port()
+	if host == "localhost" {
+		return false
+	}
+	ip := net.ParseIP(host)
+	if ip != nil && ip.IsLoopback() {
+		return false
+	}
+
+	host = strings.ToLower(host)
+	for _, entry := range strings.Split(p.NoProxy, ",") {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		if entry == "" {
+			continue
+		}
+		if entry == "*" {
+			return false
+		}
+		if _, cidr, err := net.ParseCIDR(entry); err == nil {
+			if ip != nil && cidr.Contains(ip) {
+				return false
+			}
+			continue
+		}
+
+		entryHost, entryPort := entry, ""
+		if h, p, err := net.SplitHostPort(entry); err == nil {
+			entryHost, entryPort = h, p
+		}
+		if entryPort != "" && entryPort != port {
+			continue
+		}
+		if entryIP := net.ParseIP(entryHost); entryIP != nil {
+			if ip != nil && entryIP.Equal(ip) {
+				return false
+			}
+			continue
+		}
+
+		// "example.com" and ".example.com" both match example.com and its subdomains
+		domain := strings.TrimPrefix(entryHost, "*")
+		domain = strings.TrimPrefix(domain, ".")
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return false
+		}
+	}
+	return true
+}
+
+func parseProxyURL(raw string) (*url.URL, error) {
+	u, err := url.Parse(raw)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		// a bare host:port is treated as an http proxy
+		if u2, err2 := url.Parse("http://" + raw); err2 == nil && u2.Host != "" {
+			return u2, nil
+		}
+		if err == nil {
+			err = fmt.Errorf("missing scheme or host")
+		}
+		return nil, fmt.Errorf("invalid proxy address %q: %v", raw, err)
+	}
+	return u, nil
+}
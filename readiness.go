@@ -0,0 +1,98 @@
+package cfx
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"go.uber.org/fx"
+)
+
+// ReadyHook is called once every registered section has been populated and validated and the
+// application has started. It can be used to notify an orchestrator that the instance may
+// receive traffic.
+type ReadyHook func(ctx context.Context, c Container) error
+
+// WithReadinessFile writes a sentinel file at path once the configuration has been validated and
+// the Fx application has started, and removes it when the application stops. Orchestrators can
+// gate traffic on the file existing, for example with an exec readiness probe running `test -f`.
+func WithReadinessFile(path string) Option {
+	return func(o *options) {
+		o.readinessFile = path
+	}
+}
+
+// WithReadyHook registers a function to call once the configuration has been validated and the
+// Fx application has started. If a hook returns an error the application fails to start.
+func WithReadyHook(fn ReadyHook) Option {
+	return func(o *options) {
+		if fn != nil {
+			o.readyHooks = append(o.readyHooks, fn)
+		}
+	}
+}
+
+// MarkReady validates the Container against its registered sections, then writes the
+// readiness file and runs the ready hooks. It is called automatically by cfx.Module and
+// NewFXConfig; applications that do not use Fx can call it after populating their configuration.
+func MarkReady(ctx context.Context, c Container) error {
+	y, ok := c.(*yamlContainer)
+	if !ok {
+		return fmt.Errorf("container of type %T does not support readiness", c)
+	}
+
+	if err := y.validateSnapshot(y.currentSnapshot()); err != nil {
+		return err
+	}
+
+	return y.markReady(ctx)
+}
+
+func (y *yamlContainer) markReady(ctx context.Context) error {
+	for _, fn := range y.opts.readyHooks {
+		if err := fn(ctx, y); err != nil {
+			return fmt.Errorf("ready hook failed: %v", err)
+		}
+	}
+
+	if y.opts.readinessFile == "" {
+		return nil
+	}
+
+	data := fmt.Sprintf("%s %s\n", y.opts.clock().UTC().Format(time.RFC3339), y.Fingerprint())
+	return writeFileAtomic(y.opts.readinessFile, []byte(data), 0644)
+}
+
+// clearReady removes the readiness file so that a stopping instance stops receiving traffic.
+func (y *yamlContainer) clearReady() error {
+	if y.opts.readinessFile == "" {
+		return nil
+	}
+
+	if err := os.Remove(y.opts.readinessFile); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("could not remove readiness file %s: %v", y.opts.readinessFile, err)
+	}
+
+	return nil
+}
+
+// bindReadiness signals readiness when the Fx application starts and withdraws it when it stops.
+func bindReadiness(lc fx.Lifecycle, y *yamlContainer) {
+	if y.opts.readinessFile == "" && len(y.opts.readyHooks) == 0 {
+		return
+	}
+
+	// a stale sentinel from a previous run must not mark this instance ready early
+	if err := y.clearReady(); err != nil {
+		lc.Append(fx.Hook{OnStart: func(context.Context) error { return err }})
+		return
+	}
+
+	lc.Append(fx.Hook{
+		OnStart: y.markReady,
+		OnStop: func(context.Context) error {
+			return y.clearReady()
+		},
+	})
+}
@@ -0,0 +1,95 @@
+package cfx
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// ReadOnlyError is returned when an operation that writes configuration is attempted in
+// read-only mode, and when read-only mode finds the configuration writable at startup.
+type ReadOnlyError struct {
+	// Operation describes what would have written, or what was found writable.
+	Operation string
+
+	// Path is the file or directory involved.
+	Path string
+
+	// Hint explains how to fix the problem, if there is a fix.
+	Hint string
+}
+
+// Error implements the error interface.
+func (e ReadOnlyError) Error() string {
+	msg := fmt.Sprintf("%s %s is not allowed in read-only mode", e.Operation, e.Path)
+	if e.Hint != "" {
+		msg += ": " + e.Hint
+	}
+	return msg
+}
+
+// ReadOnly turns on read-only mode for the Container, for regulated environments that must
+// prove their configuration cannot change underneath a running process. At startup the Container
+// checks with the operating system that the process cannot write to the config directory or to
+// any of the configuration files it loads, and fails with a ReadOnlyError with guidance if it
+// can. Every API that writes configuration back to disk, such as WithLastKnownGood and
+// WithReplayRecording, fails with a ReadOnlyError instead of writing.
+func ReadOnly() Option {
+	return func(o *options) {
+		o.readOnly = true
+	}
+}
+
+// IsReadOnly reports whether c was created in read-only mode.
+func IsReadOnly(c Container) bool {
+	y, ok := c.(*yamlContainer)
+	return ok && y.opts.readOnly
+}
+
+// checkWriteAllowed returns a ReadOnlyError for an operation writing to path in read-only mode.
+// Every API that writes configuration calls it first.
+func checkWriteAllowed(opts *options, operation, path string) error {
+	if opts.readOnly {
+		return ReadOnlyError{Operation: operation, Path: path}
+	}
+	return nil
+}
+
+// verifyReadOnly checks that the process cannot write to any config directory of env or to the
+// files the snapshot was loaded from.
+func verifyReadOnly(env EnvContext, opts *options, snap *snapshot) error {
+	if !opts.readOnly {
+		return nil
+	}
+
+	var paths []string
+	for _, root := range env.configRoots() {
+		if root == "" {
+			continue
+		}
+		if dir, err := filepath.Abs(root); err == nil {
+			paths = append(paths, dir)
+		}
+	}
+	for _, src := range snap.sources {
+		if _, err := os.Stat(src); err == nil {
+			paths = append(paths, src)
+		}
+	}
+	if opts.bundlePath != "" {
+		paths = append(paths, opts.bundlePath)
+	}
+
+	for _, p := range paths {
+		if !pathWritable(p) {
+			continue
+		}
+		return ReadOnlyError{
+			Operation: "writable configuration",
+			Path:      p,
+			Hint: "mount it read-only (mount -o remount,ro, or a read-only volume), or remove write " +
+				"access (chmod -R a-w) and run the process as a user that does not own it",
+		}
+	}
+	return nil
+}
@@ -0,0 +1,34 @@
+//go:build windows || plan9 || js
+// +build windows plan9 js
+
+package cfx
+
+import (
+	"io/ioutil"
+	"os"
+)
+
+// pathWritable checks whether the process may write to path by opening files for writing
+// without truncating them, and by creating and removing a probe file in directories.
+func pathWritable(path string) bool {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+	if !fi.IsDir() {
+		f, err := os.OpenFile(path, os.O_WRONLY, 0)
+		if err != nil {
+			return false
+		}
+		f.Close()
+		return true
+	}
+
+	f, err := ioutil.TempFile(path, ".cfx-readonly-")
+	if err != nil {
+		return false
+	}
+	f.Close()
+	os.Remove(f.Name())
+	return true
+}
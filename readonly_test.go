@@ -0,0 +1,20 @@
+package cfx
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestVerifyReadOnlyChecksEveryConfigRoot(t *testing.T) {
+	dir := testDir(t)
+	env := EnvContext{ConfigPaths: []string{filepath.Join(dir, "missing"), dir}}
+
+	err := verifyReadOnly(env, newOptions([]Option{ReadOnly()}), &snapshot{})
+	roErr, ok := err.(ReadOnlyError)
+	if !ok {
+		t.Fatalf("got %v, want a ReadOnlyError for the writable search path entry", err)
+	}
+	if roErr.Path != dir {
+		t.Errorf("error names %s, want %s", roErr.Path, dir)
+	}
+}
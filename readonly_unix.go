@@ -0,0 +1,15 @@
+//go:build !windows && !plan9 && !js
+// +build !windows,!plan9,!js
+
+package cfx
+
+import "syscall"
+
+// _accessWrite is the W_OK mode of access(2).
+const _accessWrite = 0x2
+
+// pathWritable asks the kernel whether the process may write to path, which accounts for
+// permissions, ACLs and read-only mounts without modifying anything.
+func pathWritable(path string) bool {
+	return syscall.Access(path, _accessWrite) == nil
+}
@@ -0,0 +1,143 @@
+package cfx
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"go.uber.org/config"
+)
+
+// readContext holds the state that read-time stanzas are evaluated against.
+type readContext struct {
+	now    time.Time
+	leader bool
+}
+
+func (y *yamlContainer) newReadContext() readContext {
+	return readContext{
+		now:    y.opts.clock(),
+		leader: y.opts.leader.IsLeader(),
+	}
+}
+
+// populateDynamic evaluates read-time stanzas beneath key before populating target.
+func populateDynamic(rc readContext, tree map[string]interface{}, key string, target interface{}) error {
+	sub, _ := lookupTree(tree, key)
+	val, present, err := evalReadTime(rc, key, sub)
+	if err != nil {
+		return err
+	}
+	if !present {
+		val = nil
+	}
+
+	provider, err := config.NewYAML(config.Static(val))
+	if err != nil {
+		return fmt.Errorf("could not evaluate configuration for %s: %v", key, err)
+	}
+
+	return provider.Get(config.Root).Populate(target)
+}
+
+// lookupTree finds the value at the dotted key path within the tree.
+func lookupTree(tree map[string]interface{}, key string) (interface{}, bool) {
+	if key == config.Root {
+		return tree, true
+	}
+
+	var cur interface{} = tree
+	for _, part := range strings.Split(key, ".") {
+		m, ok := cur.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		cur, ok = m[part]
+		if !ok {
+			return nil, false
+		}
+	}
+
+	return cur, true
+}
+
+// hasReadTimeStanzas reports whether any value in the tree must be evaluated at read time.
+func hasReadTimeStanzas(v interface{}) bool {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		if isReadTimeStanza(t) {
+			return true
+		}
+		for _, val := range t {
+			if hasReadTimeStanzas(val) {
+				return true
+			}
+		}
+	case []interface{}:
+		for _, val := range t {
+			if hasReadTimeStanzas(val) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func isReadTimeStanza(m map[string]interface{}) bool {
+	if len(m) != 1 {
+		return false
+	}
+	if _, ok := m[ScheduledKey].([]interface{}); ok {
+		return true
+	}
+	_, ok := m[LeaderOnlyKey].(map[string]interface{})
+	return ok
+}
+
+// evalReadTime returns a copy of v with every read-time stanza replaced by its current value.
+// The boolean result is false when the value should be treated as unset.
+func evalReadTime(rc readContext, key string, v interface{}) (interface{}, bool, error) {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		if entries, ok := t[ScheduledKey].([]interface{}); ok && len(t) == 1 {
+			val, present, err := evalSchedule(rc, key, entries)
+			if err != nil || !present {
+				return nil, false, err
+			}
+			return evalReadTime(rc, key, val)
+		}
+		if stanza, ok := t[LeaderOnlyKey].(map[string]interface{}); ok && len(t) == 1 {
+			val, present, err := evalLeaderOnly(rc, key, stanza)
+			if err != nil || !present {
+				return nil, false, err
+			}
+			return evalReadTime(rc, key, val)
+		}
+
+		m := make(map[string]interface{}, len(t))
+		for k, val := range t {
+			ev, present, err := evalReadTime(rc, joinKey(key, k), val)
+			if err != nil {
+				return nil, false, err
+			}
+			if present {
+				m[k] = ev
+			}
+		}
+		return m, true, nil
+	case []interface{}:
+		l := make([]interface{}, 0, len(t))
+		for i, val := range t {
+			ev, present, err := evalReadTime(rc, fmt.Sprintf("%s[%d]", key, i), val)
+			if err != nil {
+				return nil, false, err
+			}
+			if present {
+				l = append(l, ev)
+			}
+		}
+		return l, true, nil
+	default:
+		return v, true, nil
+	}
+}
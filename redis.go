@@ -0,0 +1,119 @@
+package cfx
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"go.uber.org/fx"
+)
+
+// RedisConfig is the canonical configuration section for Redis clients.
+type RedisConfig struct {
+	// Addresses lists the host:port of every node. A single address is a standalone server.
+	Addresses []string `json:"addresses,omitempty" yaml:"addresses,omitempty" mapstructure:"addresses,omitempty"`
+
+	// MasterName enables sentinel mode, naming the monitored master.
+	MasterName string `json:"master_name,omitempty" yaml:"master_name,omitempty" mapstructure:"master_name,omitempty"`
+
+	// Username is used for Redis 6 ACL authentication.
+	Username string `json:"username,omitempty" yaml:"username,omitempty" mapstructure:"username,omitempty"`
+
+	// Password is a reference to the password, e.g. "env:REDIS_PASSWORD".
+	Password SecretRef `json:"password,omitempty" yaml:"password,omitempty" mapstructure:"password,omitempty"`
+
+	// DB is the database index to select.
+	DB int `json:"db,omitempty" yaml:"db,omitempty" mapstructure:"db,omitempty"`
+
+	// DialTimeout limits how long establishing a connection may take.
+	DialTimeout time.Duration `json:"dial_timeout,omitempty" yaml:"dial_timeout,omitempty" mapstructure:"dial_timeout,omitempty"`
+
+	// ReadTimeout limits how long a socket read may take.
+	ReadTimeout time.Duration `json:"read_timeout,omitempty" yaml:"read_timeout,omitempty" mapstructure:"read_timeout,omitempty"`
+
+	// WriteTimeout limits how long a socket write may take.
+	WriteTimeout time.Duration `json:"write_timeout,omitempty" yaml:"write_timeout,omitempty" mapstructure:"write_timeout,omitempty"`
+
+	// Pool configures the connection pool.
+	Pool RedisPoolConfig `json:"pool,omitempty" yaml:"pool,omitempty" mapstructure:"pool,omitempty"`
+
+	// TLS configures transport security.
+	TLS TLSConfig `json:"tls,omitempty" yaml:"tls,omitempty" mapstructure:"tls,omitempty"`
+}
+
+// RedisPoolConfig holds the Redis connection pool settings.
+type RedisPoolConfig struct {
+	// Size is the maximum number of connections per node.
+	Size int `json:"size,omitempty" yaml:"size,omitempty" mapstructure:"size,omitempty"`
+
+	// MinIdle is the number of idle connections kept open.
+	MinIdle int `json:"min_idle,omitempty" yaml:"min_idle,omitempty" mapstructure:"min_idle,omitempty"`
+
+	// Timeout is how long to wait for a free connection when the pool is exhausted.
+	Timeout time.Duration `json:"timeout,omitempty" yaml:"timeout,omitempty" mapstructure:"timeout,omitempty"`
+
+	// IdleTimeout is how long an idle connection is kept before it is closed.
+	IdleTimeout time.Duration `json:"idle_timeout,omitempty" yaml:"idle_timeout,omitempty" mapstructure:"idle_timeout,omitempty"`
+}
+
+// DefaultRedisConfig returns the defaults that are applied before a section is populated.
+func DefaultRedisConfig() RedisConfig {
+	return RedisConfig{
+		Addresses:    []string{"127.0.0.1:6379"},
+		DialTimeout:  5 * time.Second,
+		ReadTimeout:  3 * time.Second,
+		WriteTimeout: 3 * time.Second,
+		Pool: RedisPoolConfig{
+			Size:        10,
+			Timeout:     4 * time.Second,
+			IdleTimeout: 5 * time.Minute,
+		},
+	}
+}
+
+// Validate implements the cfx.Validator interface.
+func (r RedisConfig) Validate() error {
+	if len(r.Addresses) == 0 {
+		return errors.New("redis addresses must not be empty")
+	}
+	for _, addr := range r.Addresses {
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			return fmt.Errorf("redis address %q is invalid: %v", addr, err)
+		}
+	}
+	if r.DB < 0 {
+		return errors.New("redis db must not be negative")
+	}
+	if r.DialTimeout < 0 || r.ReadTimeout < 0 || r.WriteTimeout < 0 {
+		return errors.New("redis timeouts must not be negative")
+	}
+	if r.Pool.Size < 1 {
+		return errors.New("redis pool.size must be at least 1")
+	}
+	if r.Pool.MinIdle < 0 || r.Pool.MinIdle > r.Pool.Size {
+		return errors.New("redis pool.min_idle must be between 0 and pool.size")
+	}
+	return r.TLS.Validate()
+}
+
+// ProvideRedisConfig registers the Redis section at key for startup validation and provides
+// it as a *RedisConfig named after key.
+func ProvideRedisConfig(key string) fx.Option {
+	defaults := DefaultRedisConfig()
+	spec := Section(key, &defaults)
+
+	return fx.Options(
+		ProvideSection(key, &defaults),
+		fx.Provide(fx.Annotated{
+			Name: key,
+			Target: func(c Container) (*RedisConfig, error) {
+				cfg, err := spec.Populate(c)
+				if err != nil {
+					return nil, SectionError{Key: key, Err: err}
+				}
+				return cfg.(*RedisConfig), nil
+			},
+		}),
+	)
+}
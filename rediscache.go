@@ -0,0 +1,310 @@
+package cfx
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// _maxRedisReply bounds the size of a single reply read from Redis.
+const _maxRedisReply = 64 << 20
+
+// RedisCacheConfig configures a RedisCache.
+type RedisCacheConfig struct {
+	// Addr is the host:port of the server. Defaults to "localhost:6379".
+	Addr string `json:"addr,omitempty" yaml:"addr,omitempty" mapstructure:"addr,omitempty"`
+
+	// Username is sent with AUTH when set, for servers using ACLs.
+	Username string `json:"username,omitempty" yaml:"username,omitempty" mapstructure:"username,omitempty"`
+
+	// Password is a reference to the password sent with AUTH, e.g. "env:REDIS_PASSWORD".
+	Password SecretRef `json:"password,omitempty" yaml:"password,omitempty" mapstructure:"password,omitempty"`
+
+	// DB is the database selected on every connection.
+	DB int `json:"db,omitempty" yaml:"db,omitempty" mapstructure:"db,omitempty"`
+
+	// Prefix is prepended to every key, so that several applications can share a server.
+	Prefix string `json:"prefix,omitempty" yaml:"prefix,omitempty" mapstructure:"prefix,omitempty"`
+
+	// Timeout bounds dialing and every command whose context has no deadline. Defaults to five
+	// seconds.
+	Timeout time.Duration `json:"timeout,omitempty" yaml:"timeout,omitempty" mapstructure:"timeout,omitempty"`
+
+	// MaxIdle is how many idle connections are kept for reuse. Defaults to four.
+	MaxIdle int `json:"max_idle,omitempty" yaml:"max_idle,omitempty" mapstructure:"max_idle,omitempty"`
+
+	// TLS configures TLS to the server.
+	TLS TLSConfig `json:"tls,omitempty" yaml:"tls,omitempty" mapstructure:"tls,omitempty"`
+}
+
+// RedisCache is a Cache stored in Redis, so that every instance of a service shares it. It
+// speaks the Redis protocol directly over a small pool of connections, using GET, SET with PX
+// and DEL, and works with any server compatible with them. Expiry is left to the server, so
+// Stats only reports hits, misses and sets. Connections count as network access in
+// air-gapped mode.
+type RedisCache struct {
+	cfg      RedisCacheConfig
+	idle     chan *redisConn
+	counters cacheCounters
+}
+
+// NewRedisCache creates a RedisCache. Connections are made when the cache is first used.
+func NewRedisCache(cfg RedisCacheConfig) *RedisCache {
+	if cfg.Addr == "" {
+		cfg.Addr = "localhost:6379"
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 5 * time.Second
+	}
+	if cfg.MaxIdle <= 0 {
+		cfg.MaxIdle = 4
+	}
+	return &RedisCache{cfg: cfg, idle: make(chan *redisConn, cfg.MaxIdle)}
+}
+
+// Get implements the Cache interface.
+func (r *RedisCache) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	reply, err := r.do(ctx, "GET", r.cfg.Prefix+key)
+	if err != nil {
+		return nil, false, err
+	}
+	if reply == nil {
+		atomic.AddUint64(&r.counters.misses, 1)
+		return nil, false, nil
+	}
+	data, ok := reply.([]byte)
+	if !ok {
+		return nil, false, fmt.Errorf("unexpected reply to redis GET: %v", reply)
+	}
+	atomic.AddUint64(&r.counters.hits, 1)
+	return data, true, nil
+}
+
+// Set implements the Cache interface.
+func (r *RedisCache) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	atomic.AddUint64(&r.counters.sets, 1)
+
+	args := []string{"SET", r.cfg.Prefix + key, string(value)}
+	if ttl > 0 {
+		ms := int64(ttl / time.Millisecond)
+		if ms == 0 {
+			ms = 1
+		}
+		args = append(args, "PX", strconv.FormatInt(ms, 10))
+	}
+	_, err := r.do(ctx, args...)
+	return err
+}
+
+// Delete implements the Cache interface.
+func (r *RedisCache) Delete(ctx context.Context, key string) error {
+	_, err := r.do(ctx, "DEL", r.cfg.Prefix+key)
+	return err
+}
+
+// Stats returns the cache's counters. Entries is not known and always zero.
+func (r *RedisCache) Stats() CacheStats {
+	return r.counters.stats(0)
+}
+
+// Close closes the idle connections.
+func (r *RedisCache) Close() error {
+	for {
+		select {
+		case c := <-r.idle:
+			c.conn.Close()
+		default:
+			return nil
+		}
+	}
+}
+
+// do runs a command on a pooled connection. Connections are dropped after any error other
+// than an error reply, since their state is unknown.
+func (r *RedisCache) do(ctx context.Context, args ...string) (interface{}, error) {
+	if err := checkNetworkAllowed("redis cache " + r.cfg.Addr); err != nil {
+		return nil, err
+	}
+
+	c, err := r.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	reply, err := c.do(ctx, r.cfg.Timeout, args...)
+	if _, isReply := err.(redisError); err != nil && !isReply {
+		c.conn.Close()
+	} else {
+		r.put(c)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("redis %s failed: %v", args[0], err)
+	}
+	return reply, nil
+}
+
+func (r *RedisCache) get(ctx context.Context) (*redisConn, error) {
+	select {
+	case c := <-r.idle:
+		return c, nil
+	default:
+	}
+	return r.dial(ctx)
+}
+
+func (r *RedisCache) put(c *redisConn) {
+	select {
+	case r.idle <- c:
+	default:
+		c.conn.Close()
+	}
+}
+
+// dial connects to the server, authenticates and selects the database.
+func (r *RedisCache) dial(ctx context.Context) (*redisConn, error) {
+	d := net.Dialer{Timeout: r.cfg.Timeout}
+	conn, err := d.DialContext(ctx, "tcp", r.cfg.Addr)
+	if err != nil {
+		return nil, fmt.Errorf("could not connect to redis at %s: %v", r.cfg.Addr, err)
+	}
+
+	if r.cfg.TLS.Enabled {
+		tc, err := r.cfg.TLS.ClientConfig()
+		if err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("could not configure TLS for redis: %v", err)
+		}
+		if tc.ServerName == "" {
+			host, _, _ := net.SplitHostPort(r.cfg.Addr)
+			tc.ServerName = host
+		}
+		conn = tls.Client(conn, tc)
+	}
+
+	c := &redisConn{conn: conn, r: bufio.NewReader(conn)}
+	if !r.cfg.Password.IsZero() {
+		pw, err := r.cfg.Password.Resolve(ctx)
+		if err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("could not resolve redis password: %v", err)
+		}
+		args := []string{"AUTH", pw}
+		if r.cfg.Username != "" {
+			args = []string{"AUTH", r.cfg.Username, pw}
+		}
+		if _, err := c.do(ctx, r.cfg.Timeout, args...); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("could not authenticate to redis: %v", err)
+		}
+	}
+	if r.cfg.DB != 0 {
+		if _, err := c.do(ctx, r.cfg.Timeout, "SELECT", strconv.Itoa(r.cfg.DB)); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("could not select redis database %d: %v", r.cfg.DB, err)
+		}
+	}
+	return c, nil
+}
+
+// redisError is an error reply from the server.
+type redisError string
+
+func (e redisError) Error() string {
+	return string(e)
+}
+
+// redisConn is a connection speaking RESP, the Redis serialization protocol.
+type redisConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// do sends a command and reads its reply. Bulk strings are returned as []byte, a null reply
+// as nil, integers as int64, simple strings as string and arrays as []interface{}.
+func (c *redisConn) do(ctx context.Context, timeout time.Duration, args ...string) (interface{}, error) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(timeout)
+	}
+	if err := c.conn.SetDeadline(deadline); err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, 0, 64)
+	buf = append(buf, '*')
+	buf = strconv.AppendInt(buf, int64(len(args)), 10)
+	buf = append(buf, '\r', '\n')
+	for _, a := range args {
+		buf = append(buf, '$')
+		buf = strconv.AppendInt(buf, int64(len(a)), 10)
+		buf = append(buf, '\r', '\n')
+		buf = append(buf, a...)
+		buf = append(buf, '\r', '\n')
+	}
+	if _, err := c.conn.Write(buf); err != nil {
+		return nil, err
+	}
+	return c.readReply()
+}
+
+func (c *redisConn) readReply() (interface{}, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, errors.New("malformed redis reply")
+	}
+	kind, line := line[0], line[1:len(line)-2]
+
+	switch kind {
+	case '+':
+		return line, nil
+	case '-':
+		return nil, redisError(line)
+	case ':':
+		n, err := strconv.ParseInt(line, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("malformed redis integer reply: %v", err)
+		}
+		return n, nil
+	case '$':
+		n, err := strconv.Atoi(line)
+		if err != nil || n < -1 || n > _maxRedisReply {
+			return nil, fmt.Errorf("malformed redis bulk reply length %q", line)
+		}
+		if n == -1 {
+			return nil, nil
+		}
+		data := make([]byte, n+2)
+		if _, err := io.ReadFull(c.r, data); err != nil {
+			return nil, err
+		}
+		if data[n] != '\r' || data[n+1] != '\n' {
+			return nil, errors.New("malformed redis bulk reply")
+		}
+		return data[:n], nil
+	case '*':
+		n, err := strconv.Atoi(line)
+		if err != nil || n < -1 || n > _maxRedisReply {
+			return nil, fmt.Errorf("malformed redis array reply length %q", line)
+		}
+		if n == -1 {
+			return nil, nil
+		}
+		ret := make([]interface{}, n)
+		for i := range ret {
+			if ret[i], err = c.readReply(); err != nil {
+				return nil, err
+			}
+		}
+		return ret, nil
+	}
+	return nil, fmt.Errorf("unknown redis reply type %q", kind)
+}
@@ -0,0 +1,417 @@
+package cfx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"go.uber.org/fx"
+	"gopkg.in/yaml.v2"
+)
+
+// ReloadEvent describes the outcome of loading or reloading a Container.
+type ReloadEvent struct {
+	// Time is when the event occurred.
+	Time time.Time
+
+	// Trigger is what caused the load.
+	Trigger ReloadTrigger
+
+	// Err is set when the new configuration could not be loaded or failed validation.
+	Err error
+
+	// Fingerprint is the fingerprint of the configuration being served after the event.
+	Fingerprint string
+
+	// FellBack is set when the Container is serving the last known good configuration
+	// from disk because the current configuration could not be used.
+	FellBack bool
+}
+
+// ReloadObserver is notified after every load attempt. It is the hook for emitting
+// metrics and alerts about failed reloads.
+type ReloadObserver func(ReloadEvent)
+
+// WithReloadObserver registers a function that is called after every load attempt.
+func WithReloadObserver(fn ReloadObserver) Option {
+	return func(o *options) {
+		if fn != nil {
+			o.observers = append(o.observers, fn)
+		}
+	}
+}
+
+// WithHotReload polls the configuration sources at the given interval and reloads the Container
+// when they change. The watcher runs for the lifetime of the Fx application when the Container is
+// created with NewFXConfig; otherwise use cfx.Watch.
+func WithHotReload(interval time.Duration) Option {
+	return func(o *options) {
+		o.reloadInterval = interval
+	}
+}
+
+// WithLastKnownGood persists the merged configuration to path every time a configuration
+// is loaded and validated. If the configuration cannot be loaded at startup, the Container
+// comes up with the persisted copy instead. The file is written with 0600 permissions as it
+// may contain expanded secrets.
+func WithLastKnownGood(path string) Option {
+	return func(o *options) {
+		o.lastKnownGood = path
+	}
+}
+
+// WithSections registers sections that every reloaded configuration must satisfy before it
+// replaces the current one. Sections registered with cfx.ProvideSection are added automatically.
+func WithSections(specs ...SectionSpec) Option {
+	return func(o *options) {
+		o.sections = append(o.sections, specs...)
+	}
+}
+
+func (y *yamlContainer) notify(ev ReloadEvent) {
+	if ev.Time.IsZero() {
+		ev.Time = y.opts.clock()
+	}
+	for _, fn := range y.opts.observers {
+		fn(ev)
+	}
+}
+
+// registerSections adds sections discovered in the Fx graph to the reload validation set.
+func (y *yamlContainer) registerSections(specs []SectionSpec) {
+	y.Lock()
+	defer y.Unlock()
+	y.sections = append(y.sections, specs...)
+}
+
+func (y *yamlContainer) validateSnapshot(snap *snapshot) error {
+	start := y.opts.clock()
+	defer snap.stages.record(y.opts, StageValidate, start)
+
+	y.RLock()
+	specs := append(append([]SectionSpec{}, y.opts.sections...), y.sections...)
+	y.RUnlock()
+
+	if len(specs) > 0 {
+		candidate := &yamlContainer{env: y.env, opts: y.opts, snap: snap}
+		if err := ValidateSections(candidate, specs...); err != nil {
+			return err
+		}
+	}
+
+	return validatePlugins(y.env, y.opts, snap)
+}
+
+// Reload implements the cfgfx.Container interface.
+// If the new configuration cannot be loaded or fails validation, the previous configuration
+// is kept and the error is returned. Change handlers are only called for sections that
+// changed. The new configuration remains in place even if a change handler or an AuditSink
+// fails, in which case the first such error is returned.
+func (y *yamlContainer) Reload(trigger ReloadTrigger) error {
+	return y.reload(trigger, func() (*snapshot, error) {
+		return loadSnapshot(y.env, y.opts)
+	})
+}
+
+// reload replaces the current configuration with the snapshot returned by load.
+func (y *yamlContainer) reload(trigger ReloadTrigger, load func() (*snapshot, error)) error {
+	y.reloadMu.Lock()
+	defer y.reloadMu.Unlock()
+
+	if y.Frozen() {
+		return ErrFrozen
+	}
+
+	snap, err := load()
+	if err == nil {
+		err = y.validateSnapshot(snap)
+	}
+	if err != nil {
+		y.notify(ReloadEvent{Trigger: trigger, Err: err, Fingerprint: y.Fingerprint()})
+		return fmt.Errorf("could not reload configuration, keeping the previous configuration: %v", err)
+	}
+
+	if err := persistLastKnownGood(y.opts, snap); err != nil {
+		y.notify(ReloadEvent{Trigger: trigger, Err: err, Fingerprint: y.Fingerprint()})
+		return err
+	}
+
+	if err := recordReplay(y.env, y.opts, snap); err != nil {
+		y.notify(ReloadEvent{Trigger: trigger, Err: err, Fingerprint: y.Fingerprint()})
+		return err
+	}
+
+	if err := publishSharedSnapshot(y.opts, snap); err != nil {
+		y.notify(ReloadEvent{Trigger: trigger, Err: err, Fingerprint: y.Fingerprint()})
+		return err
+	}
+
+	y.Lock()
+	if y.frozen {
+		y.Unlock()
+		return ErrFrozen
+	}
+	old := y.snap
+	y.snap = snap
+	y.Unlock()
+
+	y.notify(ReloadEvent{Trigger: trigger, Fingerprint: snap.fingerprint})
+
+	if old == nil {
+		return nil
+	}
+
+	changes := diffTrees(old.tree, snap.tree)
+	y.notifyWatchables(changes)
+	handlerErr := y.dispatchSectionChanges(changes)
+
+	var auditErr error
+	if len(y.opts.auditSinks) > 0 {
+		rec := newAuditRecord(y.env, trigger, old, snap, y.opts.redactor)
+		for _, sink := range y.opts.auditSinks {
+			if err := sink.WriteAudit(rec); err != nil && auditErr == nil {
+				auditErr = fmt.Errorf("configuration reloaded but audit record could not be written: %v", err)
+			}
+		}
+	}
+
+	if handlerErr != nil {
+		return handlerErr
+	}
+	return auditErr
+}
+
+// fallBack replaces the current configuration with the last known good copy after the
+// current one failed validation at startup.
+func (y *yamlContainer) fallBack(reason error) error {
+	lkg, err := loadLastKnownGood(y.env, y.opts)
+	if err != nil {
+		return reason
+	}
+	if err := y.validateSnapshot(lkg); err != nil {
+		return reason
+	}
+
+	lkg.fallbackReason = reason
+
+	y.Lock()
+	y.snap = lkg
+	y.Unlock()
+
+	y.notify(ReloadEvent{Trigger: TriggerStartup, Err: reason, Fingerprint: lkg.fingerprint, FellBack: true})
+	return nil
+}
+
+func persistLastKnownGood(opts *options, snap *snapshot) error {
+	if opts.lastKnownGood == "" {
+		return nil
+	}
+	if err := checkWriteAllowed(opts, "persisting the last known good configuration to", opts.lastKnownGood); err != nil {
+		return err
+	}
+
+	tree, err := snap.materialized()
+	if err != nil {
+		return err
+	}
+	data, err := yaml.Marshal(tree)
+	if err != nil {
+		return fmt.Errorf("could not encode last known good configuration: %v", err)
+	}
+
+	// values were already expanded when the configuration was loaded
+	data = append([]byte("# "+NoExpandDirective+"\n"), data...)
+
+	return writeFileLocked(opts.fileLock, opts.lastKnownGood, data, 0600)
+}
+
+func loadLastKnownGood(env EnvContext, opts *options) (*snapshot, error) {
+	if opts.lastKnownGood == "" {
+		return nil, errors.New("no last known good configuration configured")
+	}
+
+	src, err := readConfigSource(opts.lastKnownGood, opts.limits)
+	if err != nil {
+		return nil, err
+	}
+
+	return buildSnapshot(env, opts, []configSource{src})
+}
+
+// writeFileAtomic writes data to a temporary file in the same directory, syncs it and
+// renames it over path so readers never observe a partial file.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	dir := filepath.Dir(path)
+	tmp, err := ioutil.TempFile(dir, "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("could not create temporary file in %s: %v", dir, err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("could not write %s: %v", path, err)
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return fmt.Errorf("could not set permissions on %s: %v", path, err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("could not sync %s: %v", path, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("could not close %s: %v", path, err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("could not replace %s: %v", path, err)
+	}
+
+	return nil
+}
+
+// watchPaths returns the files whose changes should trigger a reload.
+func (y *yamlContainer) watchPaths() []string {
+	if y.opts.replayPath != "" {
+		return []string{y.opts.replayPath}
+	}
+	if y.opts.bundlePath != "" {
+		return []string{y.opts.bundlePath}
+	}
+	paths, err := discoverConfigFiles(y.env, y.opts.naming)
+	if err != nil {
+		return nil
+	}
+	return append(paths, y.opts.dataFiles...)
+}
+
+// sourceSignature summarizes the state of the Container's configuration sources, changing
+// when they need to be reloaded. Containers attached to a socket use the parent's fingerprint.
+func (y *yamlContainer) sourceSignature() string {
+	if y.opts.socketPath != "" {
+		s, err := ReadSocket(y.opts.socketPath)
+		if err != nil {
+			return "socket:unavailable"
+		}
+		return "socket:" + s.Fingerprint
+	}
+	if y.opts.gitSource != nil {
+		commit, err := y.opts.gitSource.head()
+		if err != nil {
+			return "git:unavailable"
+		}
+		return "git:" + commit + ";" + sourceSignature(y.opts.dataFiles) + y.layerSignature()
+	}
+	return sourceSignature(y.watchPaths()) + y.layerSignature()
+}
+
+// layerSignature summarizes the content of the failover layers.
+func (y *yamlContainer) layerSignature() string {
+	var sb strings.Builder
+	for _, l := range y.opts.failoverLayers {
+		sb.WriteString(l.signature(y.opts))
+	}
+	return sb.String()
+}
+
+// sourceSignature summarizes the identity, size and modification time of every watched file.
+func sourceSignature(paths []string) string {
+	var sb strings.Builder
+	for _, p := range paths {
+		st, err := os.Stat(p)
+		if err != nil {
+			fmt.Fprintf(&sb, "%s:missing;", p)
+			continue
+		}
+		fmt.Fprintf(&sb, "%s:%d:%d;", p, st.Size(), st.ModTime().UnixNano())
+	}
+	return sb.String()
+}
+
+// Watch polls the Container's configuration sources and reloads it when they change, until ctx
+// is cancelled. The interval set with WithHotReload is used, or five seconds if none was set.
+// Reload failures are reported through ReloadObservers. Watch returns ErrFrozen once the
+// Container has been frozen.
+func Watch(ctx context.Context, c Container) error {
+	y, ok := c.(*yamlContainer)
+	if !ok {
+		return fmt.Errorf("container of type %T does not support watching", c)
+	}
+
+	interval := y.opts.reloadInterval
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+
+	last := y.sourceSignature()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+
+		if y.Frozen() {
+			return ErrFrozen
+		}
+
+		sig := y.sourceSignature()
+		if sig == last {
+			continue
+		}
+		last = sig
+
+		// failures are reported to observers, and the previous config keeps being served
+		_ = y.Reload(TriggerWatch)
+	}
+}
+
+// bindLifecycle starts the hot reload watcher, the drift checker, the socket server and the
+// config server watcher with the Fx application when they are enabled, and stops any plugins
+// when the application stops.
+func bindLifecycle(lc fx.Lifecycle, c Container, opts *options) {
+	bindDriftDetection(lc, c, opts)
+	bindSocketServer(lc, c, opts)
+	bindServerWatch(lc, c, opts)
+
+	if len(opts.plugins) > 0 {
+		lc.Append(fx.Hook{
+			OnStop: func(context.Context) error {
+				closePlugins(opts.plugins)
+				return nil
+			},
+		})
+	}
+
+	if opts.reloadInterval <= 0 {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	lc.Append(fx.Hook{
+		OnStart: func(context.Context) error {
+			go func() {
+				defer close(done)
+				Watch(ctx, c)
+			}()
+			return nil
+		},
+		OnStop: func(stopCtx context.Context) error {
+			cancel()
+			select {
+			case <-done:
+			case <-stopCtx.Done():
+			}
+			return nil
+		},
+	})
+}
@@ -0,0 +1,118 @@
+package cfx
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"time"
+)
+
+// ReplayVersion is the version of the replay file format written by WithReplayRecording.
+const ReplayVersion = 1
+
+// Replay is the effective configuration of a Container as recorded by WithReplayRecording.
+type Replay struct {
+	// Version is the replay file format version.
+	Version int `json:"version" yaml:"version"`
+
+	// RecordedAt is when the configuration was recorded.
+	RecordedAt time.Time `json:"recorded_at" yaml:"recorded_at"`
+
+	// Environment is the environment the configuration was loaded in.
+	Environment EnvContext `json:"environment" yaml:"environment"`
+
+	// Fingerprint is the fingerprint of the configuration before redaction.
+	Fingerprint string `json:"fingerprint" yaml:"fingerprint"`
+
+	// Sources lists the sources the configuration was merged from.
+	Sources []ReportSource `json:"sources,omitempty" yaml:"sources,omitempty"`
+
+	// Config is the merged configuration after expansion, with sensitive values redacted.
+	Config map[string]interface{} `json:"config" yaml:"config"`
+}
+
+// WithReplayRecording writes the effective configuration to path as a Replay every time it
+// is loaded. Values are expanded and passed through the Container's Redactor, so the file can
+// be attached to a support ticket and loaded with NewContainerFromReplay to reproduce an issue.
+func WithReplayRecording(path string) Option {
+	return func(o *options) {
+		o.replayRecording = path
+	}
+}
+
+// NewContainerFromReplay creates a Container serving the configuration recorded in a replay
+// file, along with the environment it was recorded in. Reloading the Container re-reads the file.
+func NewContainerFromReplay(path string, opts ...Option) (Container, EnvContext, error) {
+	r, err := ReadReplay(path)
+	if err != nil {
+		return nil, EnvContext{}, err
+	}
+
+	opts = append(opts, func(o *options) {
+		o.replayPath = path
+	})
+	c, err := NewConfigWithOptions(r.Environment, opts...)
+	if err != nil {
+		return nil, EnvContext{}, err
+	}
+	return c, r.Environment, nil
+}
+
+// ReadReplay reads a replay file written by WithReplayRecording.
+func ReadReplay(path string) (*Replay, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read replay file %s: %v", path, err)
+	}
+
+	r := &Replay{}
+	if err := json.Unmarshal(data, r); err != nil {
+		return nil, fmt.Errorf("could not decode replay file %s: %v", path, err)
+	}
+	if r.Version != ReplayVersion {
+		return nil, fmt.Errorf("replay file %s has unsupported version %d", path, r.Version)
+	}
+
+	return r, nil
+}
+
+// recordReplay writes the snapshot to the replay file, if recording is enabled.
+func recordReplay(env EnvContext, opts *options, snap *snapshot) error {
+	if opts.replayRecording == "" {
+		return nil
+	}
+	if err := checkWriteAllowed(opts, "recording a replay to", opts.replayRecording); err != nil {
+		return err
+	}
+
+	full, err := snap.materialized()
+	if err != nil {
+		return err
+	}
+	tree, _ := redactTree("", full, opts.redactor).(map[string]interface{})
+	r := Replay{
+		Version:     ReplayVersion,
+		RecordedAt:  opts.clock().UTC(),
+		Environment: env,
+		Fingerprint: snap.fingerprint,
+		Sources:     snap.sourceReports,
+		Config:      tree,
+	}
+
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return fmt.Errorf("could not encode replay: %v", err)
+	}
+
+	return writeFileLocked(opts.fileLock, opts.replayRecording, data, 0600)
+}
+
+// loadReplay builds a snapshot from the configuration recorded in a replay file.
+func loadReplay(env EnvContext, opts *options) (*snapshot, error) {
+	r, err := ReadReplay(opts.replayPath)
+	if err != nil {
+		return nil, err
+	}
+
+	return buildExpandedSnapshot(env, opts, opts.replayPath, r.Config)
+}
@@ -0,0 +1,241 @@
+package cfx
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LoadReport is a machine readable summary of how the Container's current configuration was
+// loaded. It is intended to be attached to startup spans or structured startup logs.
+type LoadReport struct {
+	// Environment summarizes the environment the configuration was loaded for.
+	Environment ReportEnvironment `json:"environment" yaml:"environment"`
+
+	// Sources lists the configuration sources in merge order.
+	Sources []ReportSource `json:"sources" yaml:"sources"`
+
+	// LoadedAt is when the configuration was loaded.
+	LoadedAt time.Time `json:"loaded_at" yaml:"loaded_at"`
+
+	// Duration is how long reading, expanding and merging the sources took.
+	Duration time.Duration `json:"duration" yaml:"duration"`
+
+	// Stages breaks the load down into the stages of the pipeline, in the order they first
+	// ran. Validation and secret resolution happen after loading and are not in Duration.
+	Stages []StageTiming `json:"stages,omitempty" yaml:"stages,omitempty"`
+
+	// Git is set when the configuration was loaded with WithGitSource, and records the commit
+	// it was read from.
+	Git *ReportGit `json:"git,omitempty" yaml:"git,omitempty"`
+
+	// Fingerprint is the fingerprint of the merged configuration.
+	Fingerprint string `json:"fingerprint" yaml:"fingerprint"`
+
+	// Warnings are non fatal problems found while loading.
+	Warnings []string `json:"warnings,omitempty" yaml:"warnings,omitempty"`
+
+	// Deprecations lists deprecated keys that are still set in the configuration.
+	Deprecations []string `json:"deprecations,omitempty" yaml:"deprecations,omitempty"`
+
+	// Unresolved lists references to unset environment variables.
+	Unresolved []UnresolvedExpansion `json:"unresolved,omitempty" yaml:"unresolved,omitempty"`
+
+	// UnusedFiles lists the YAML files in ConfigPath that no known environment merges, such as
+	// orphaned overlays or typo'd filenames. Each is also a warning.
+	UnusedFiles []UnusedFile `json:"unused_files,omitempty" yaml:"unused_files,omitempty"`
+
+	// FellBack is set when the last known good configuration is being served.
+	FellBack bool `json:"fell_back,omitempty" yaml:"fell_back,omitempty"`
+}
+
+// ReportEnvironment is the subset of the EnvContext included in a LoadReport.
+type ReportEnvironment struct {
+	Environment EnvID  `json:"environment,omitempty" yaml:"environment,omitempty"`
+	AppID       string `json:"app_id,omitempty" yaml:"app_id,omitempty"`
+	ServiceID   string `json:"service_id,omitempty" yaml:"service_id,omitempty"`
+	InstanceID  string `json:"instance_id,omitempty" yaml:"instance_id,omitempty"`
+	Region      string `json:"region,omitempty" yaml:"region,omitempty"`
+	Hostname    string `json:"hostname,omitempty" yaml:"hostname,omitempty"`
+	ConfigPath  string `json:"config_path,omitempty" yaml:"config_path,omitempty"`
+
+	// ConfigPaths is the search path of config directories, when there is more than one.
+	ConfigPaths []string `json:"config_paths,omitempty" yaml:"config_paths,omitempty"`
+
+	// Security is the confinement of the process, so it can be confirmed from telemetry.
+	Security SecurityContext `json:"security,omitempty" yaml:"security,omitempty"`
+}
+
+// ReportSource describes a single configuration source in a LoadReport.
+type ReportSource struct {
+	// Name identifies the source, usually its path.
+	Name string `json:"name" yaml:"name"`
+
+	// Size is the size of the source in bytes, before expansion.
+	Size int `json:"size" yaml:"size"`
+
+	// Digest is the sha256 hex digest of the source, before expansion.
+	Digest string `json:"digest" yaml:"digest"`
+}
+
+// WithDeprecatedKey marks a configuration key as deprecated. Loading a configuration that still
+// sets the key, or anything beneath it, records message in the LoadReport's deprecations.
+func WithDeprecatedKey(key, message string) Option {
+	return func(o *options) {
+		if o.deprecations == nil {
+			o.deprecations = map[string]string{}
+		}
+		o.deprecations[key] = message
+	}
+}
+
+// Report implements the cfgfx.Container interface.
+func (y *yamlContainer) Report() LoadReport {
+	y.RLock()
+	snap := y.snap
+	y.RUnlock()
+
+	r := LoadReport{
+		Environment: ReportEnvironment{
+			Environment: y.env.Environment,
+			AppID:       y.env.Deployment.AppID,
+			ServiceID:   y.env.Deployment.ServiceID,
+			InstanceID:  y.env.Deployment.InstanceID,
+			Region:      y.env.Deployment.Region,
+			Hostname:    y.env.Host.Hostname,
+			ConfigPath:  y.env.ConfigPath,
+			ConfigPaths: append([]string(nil), y.env.ConfigPaths...),
+			Security:    y.env.Host.Security,
+		},
+	}
+	if snap == nil {
+		return r
+	}
+
+	r.Sources = append([]ReportSource{}, snap.sourceReports...)
+	for i := range r.Sources {
+		r.Sources[i].Name = redactLocation(r.Sources[i].Name)
+	}
+	r.LoadedAt = snap.loadedAt
+	r.Duration = snap.loadDuration
+	r.Stages = snap.stages.list()
+	if snap.git != nil {
+		git := *snap.git
+		r.Git = &git
+	}
+	r.Fingerprint = snap.fingerprint
+	r.Deprecations = deprecatedKeys(y.opts.deprecations, snap.tree)
+	r.Unresolved = append([]UnresolvedExpansion{}, snap.unresolved...)
+	for _, u := range snap.unresolved {
+		if u.Mode == UnsetWarn {
+			r.Warnings = append(r.Warnings, u.String())
+		}
+	}
+	r.UnusedFiles = append([]UnusedFile{}, snap.unusedFiles...)
+	for _, u := range snap.unusedFiles {
+		r.Warnings = append(r.Warnings, u.String())
+	}
+	if snap.fallbackReason != nil {
+		r.FellBack = true
+		r.Warnings = append(r.Warnings, fmt.Sprintf("serving last known good configuration: %v", snap.fallbackReason))
+	}
+
+	return r
+}
+
+// Attributes flattens the report into string attributes prefixed with "cfx.", suitable for
+// OpenTelemetry span attributes or log fields.
+func (r LoadReport) Attributes() map[string]string {
+	names := make([]string, len(r.Sources))
+	for i, s := range r.Sources {
+		names[i] = s.Name
+	}
+
+	attrs := map[string]string{
+		"cfx.environment":  r.Environment.Environment.String(),
+		"cfx.app_id":       r.Environment.AppID,
+		"cfx.service_id":   r.Environment.ServiceID,
+		"cfx.instance_id":  r.Environment.InstanceID,
+		"cfx.region":       r.Environment.Region,
+		"cfx.hostname":     r.Environment.Hostname,
+		"cfx.sources":      strings.Join(names, ","),
+		"cfx.fingerprint":  r.Fingerprint,
+		"cfx.loaded_at":    r.LoadedAt.UTC().Format(time.RFC3339Nano),
+		"cfx.duration_ms":  strconv.FormatFloat(float64(r.Duration)/float64(time.Millisecond), 'f', 3, 64),
+		"cfx.warnings":     strconv.Itoa(len(r.Warnings)),
+		"cfx.deprecations": strconv.Itoa(len(r.Deprecations)),
+		"cfx.unresolved":   strconv.Itoa(len(r.Unresolved)),
+		"cfx.unused_files": strconv.Itoa(len(r.UnusedFiles)),
+		"cfx.fell_back":    strconv.FormatBool(r.FellBack),
+	}
+	if sec := r.Environment.Security; sec != (SecurityContext{}) {
+		attrs["cfx.security.selinux"] = sec.SELinux
+		attrs["cfx.security.selinux_mode"] = sec.SELinuxMode
+		attrs["cfx.security.apparmor"] = sec.AppArmor
+		attrs["cfx.security.apparmor_mode"] = sec.AppArmorMode
+		attrs["cfx.security.seccomp"] = string(sec.Seccomp)
+		attrs["cfx.security.no_new_privs"] = strconv.FormatBool(sec.NoNewPrivs)
+		attrs["cfx.security.confined"] = strconv.FormatBool(sec.Confined())
+	}
+	if r.Git != nil {
+		attrs["cfx.git.repository"] = r.Git.Repository
+		attrs["cfx.git.ref"] = r.Git.Ref
+		attrs["cfx.git.commit"] = r.Git.Commit
+	}
+	for _, s := range r.Stages {
+		attrs["cfx.stage."+string(s.Stage)+"_ms"] = strconv.FormatFloat(float64(s.Duration)/float64(time.Millisecond), 'f', 3, 64)
+	}
+	for k, v := range attrs {
+		if v == "" {
+			delete(attrs, k)
+		}
+	}
+	return attrs
+}
+
+// KeyValues returns the Attributes as alternating keys and values, sorted by key, for
+// structured loggers such as zap's SugaredLogger or logr.
+func (r LoadReport) KeyValues() []interface{} {
+	attrs := r.Attributes()
+	keys := make([]string, 0, len(attrs))
+	for k := range attrs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	ret := make([]interface{}, 0, len(keys)*2)
+	for _, k := range keys {
+		ret = append(ret, k, attrs[k])
+	}
+	return ret
+}
+
+func newReportSource(src configSource) ReportSource {
+	sum := sha256.Sum256(src.data)
+	return ReportSource{
+		Name:   src.name,
+		Size:   len(src.data),
+		Digest: hex.EncodeToString(sum[:]),
+	}
+}
+
+// deprecatedKeys returns the messages for deprecated keys set in tree, sorted by key.
+func deprecatedKeys(deprecations map[string]string, tree map[string]interface{}) []string {
+	ret := []string{}
+	for key, msg := range deprecations {
+		if _, ok := lookupTree(tree, key); !ok {
+			continue
+		}
+		if msg == "" {
+			ret = append(ret, fmt.Sprintf("%s is deprecated", key))
+			continue
+		}
+		ret = append(ret, fmt.Sprintf("%s is deprecated: %s", key, msg))
+	}
+	sort.Strings(ret)
+	return ret
+}
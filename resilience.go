@@ -0,0 +1,222 @@
+package cfx
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.uber.org/fx"
+)
+
+const (
+	// RateLimitsKey is the config section holding named rate limits.
+	RateLimitsKey = "ratelimits"
+
+	// CircuitBreakersKey is the config section holding named circuit breaker settings.
+	CircuitBreakersKey = "circuitbreakers"
+
+	// DefaultPolicyName is the entry used for names that have no entry of their own.
+	DefaultPolicyName = "default"
+)
+
+// ResilienceModule validates the "ratelimits" and "circuitbreakers" sections and provides a
+// *ResilienceRegistry that tracks them across reloads.
+var ResilienceModule = fx.Options(
+	ProvideSection(RateLimitsKey, &RateLimitsConfig{}),
+	ProvideSection(CircuitBreakersKey, &CircuitBreakersConfig{}),
+	fx.Provide(NewResilienceRegistry),
+)
+
+// RateLimitConfig configures a token bucket rate limit.
+type RateLimitConfig struct {
+	// Rate is the number of events allowed per second.
+	Rate float64 `json:"rate,omitempty" yaml:"rate,omitempty" mapstructure:"rate,omitempty"`
+
+	// Burst is the number of events allowed at once. The ResilienceRegistry sets it to 1 when
+	// it is left out.
+	Burst int `json:"burst,omitempty" yaml:"burst,omitempty" mapstructure:"burst,omitempty"`
+
+	// Disabled turns the limit off.
+	Disabled bool `json:"disabled,omitempty" yaml:"disabled,omitempty" mapstructure:"disabled,omitempty"`
+}
+
+// Validate implements the cfx.Validator interface.
+func (r RateLimitConfig) Validate() error {
+	if r.Disabled {
+		return nil
+	}
+	if r.Rate <= 0 {
+		return errors.New("rate must be positive")
+	}
+	if r.Burst < 0 {
+		return errors.New("burst must not be negative")
+	}
+	return nil
+}
+
+// RateLimitsConfig is the "ratelimits" config section, keyed by limit name.
+type RateLimitsConfig map[string]RateLimitConfig
+
+// Validate implements the cfx.Validator interface.
+func (r RateLimitsConfig) Validate() error {
+	for name, rl := range r {
+		if err := rl.Validate(); err != nil {
+			return fmt.Errorf("rate limit %s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// CircuitBreakerConfig configures a circuit breaker.
+type CircuitBreakerConfig struct {
+	// FailureThreshold is the number of consecutive failures that opens the breaker.
+	FailureThreshold int `json:"failure_threshold,omitempty" yaml:"failure_threshold,omitempty" mapstructure:"failure_threshold,omitempty"`
+
+	// FailureRatio opens the breaker when this share of requests in Interval fail, once at
+	// least MinRequests were made. Zero disables ratio based tripping.
+	FailureRatio float64 `json:"failure_ratio,omitempty" yaml:"failure_ratio,omitempty" mapstructure:"failure_ratio,omitempty"`
+
+	// MinRequests is the number of requests needed in Interval before FailureRatio applies.
+	MinRequests int `json:"min_requests,omitempty" yaml:"min_requests,omitempty" mapstructure:"min_requests,omitempty"`
+
+	// Interval is the window over which failures are counted while the breaker is closed.
+	Interval time.Duration `json:"interval,omitempty" yaml:"interval,omitempty" mapstructure:"interval,omitempty"`
+
+	// OpenTimeout is how long the breaker stays open before letting trial requests through.
+	OpenTimeout time.Duration `json:"open_timeout,omitempty" yaml:"open_timeout,omitempty" mapstructure:"open_timeout,omitempty"`
+
+	// HalfOpenRequests is the number of trial requests allowed while half open.
+	HalfOpenRequests int `json:"half_open_requests,omitempty" yaml:"half_open_requests,omitempty" mapstructure:"half_open_requests,omitempty"`
+
+	// Disabled turns the breaker off.
+	Disabled bool `json:"disabled,omitempty" yaml:"disabled,omitempty" mapstructure:"disabled,omitempty"`
+}
+
+// Validate implements the cfx.Validator interface.
+func (c CircuitBreakerConfig) Validate() error {
+	if c.Disabled {
+		return nil
+	}
+	if c.FailureThreshold <= 0 && c.FailureRatio <= 0 {
+		return errors.New("failure_threshold or failure_ratio must be set")
+	}
+	if c.FailureRatio < 0 || c.FailureRatio > 1 {
+		return errors.New("failure_ratio must be between 0 and 1")
+	}
+	if c.MinRequests < 0 || c.HalfOpenRequests < 0 {
+		return errors.New("request counts must not be negative")
+	}
+	if c.Interval < 0 {
+		return errors.New("interval must not be negative")
+	}
+	if c.OpenTimeout <= 0 {
+		return errors.New("open_timeout must be positive")
+	}
+	return nil
+}
+
+// CircuitBreakersConfig is the "circuitbreakers" config section, keyed by breaker name.
+type CircuitBreakersConfig map[string]CircuitBreakerConfig
+
+// Validate implements the cfx.Validator interface.
+func (c CircuitBreakersConfig) Validate() error {
+	for name, cb := range c {
+		if err := cb.Validate(); err != nil {
+			return fmt.Errorf("circuit breaker %s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// ResilienceRegistry holds the current rate limits and circuit breaker settings and keeps them
+// up to date as the configuration is reloaded. It is safe for concurrent use.
+type ResilienceRegistry struct {
+	mu       sync.RWMutex
+	limits   RateLimitsConfig
+	breakers CircuitBreakersConfig
+	changed  chan struct{}
+}
+
+// NewResilienceRegistry reads the "ratelimits" and "circuitbreakers" sections from c and
+// follows their changes on reload. A reload that makes either section invalid is rejected
+// and the previous settings are kept.
+func NewResilienceRegistry(c Container) (*ResilienceRegistry, error) {
+	r := &ResilienceRegistry{changed: make(chan struct{})}
+	if err := r.load(c); err != nil {
+		return nil, err
+	}
+
+	for _, key := range []string{RateLimitsKey, CircuitBreakersKey} {
+		if err := OnSectionChange(c, key, func(string, []Change) error {
+			return r.load(c)
+		}); err != nil {
+			return nil, err
+		}
+	}
+
+	return r, nil
+}
+
+func (r *ResilienceRegistry) load(c Container) error {
+	limits := RateLimitsConfig{}
+	if err := c.Populate(RateLimitsKey, &limits); err != nil {
+		return fmt.Errorf("could not populate rate limits: %v", err)
+	}
+	if err := limits.Validate(); err != nil {
+		return SectionError{Key: RateLimitsKey, Err: err}
+	}
+	for name, rl := range limits {
+		if rl.Burst == 0 {
+			rl.Burst = 1
+			limits[name] = rl
+		}
+	}
+
+	breakers := CircuitBreakersConfig{}
+	if err := c.Populate(CircuitBreakersKey, &breakers); err != nil {
+		return fmt.Errorf("could not populate circuit breakers: %v", err)
+	}
+	if err := breakers.Validate(); err != nil {
+		return SectionError{Key: CircuitBreakersKey, Err: err}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.limits, r.breakers = limits, breakers
+	close(r.changed)
+	r.changed = make(chan struct{})
+
+	return nil
+}
+
+// RateLimit returns the rate limit called name, or the "default" entry if there is none.
+func (r *ResilienceRegistry) RateLimit(name string) (RateLimitConfig, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if rl, ok := r.limits[name]; ok {
+		return rl, true
+	}
+	rl, ok := r.limits[DefaultPolicyName]
+	return rl, ok
+}
+
+// CircuitBreaker returns the circuit breaker settings called name, or the "default" entry if
+// there is none.
+func (r *ResilienceRegistry) CircuitBreaker(name string) (CircuitBreakerConfig, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if cb, ok := r.breakers[name]; ok {
+		return cb, true
+	}
+	cb, ok := r.breakers[DefaultPolicyName]
+	return cb, ok
+}
+
+// Changed returns a channel that is closed the next time the settings change. Call Changed
+// again after it fires to wait for the following change.
+func (r *ResilienceRegistry) Changed() <-chan struct{} {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.changed
+}
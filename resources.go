@@ -0,0 +1,101 @@
+package cfx
+
+import (
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// _cgroupRoot is where the cgroup filesystem is mounted.
+var _cgroupRoot = "/sys/fs/cgroup"
+
+// ResourceContext holds the compute resources available to the process.
+type ResourceContext struct {
+	// NumCPU is the number of logical CPUs on the machine. (runtime.NumCPU())
+	NumCPU int `json:"num_cpu,omitempty" yaml:"num_cpu,omitempty" mapstructure:"num_cpu,omitempty"`
+
+	// CPULimit is the CPU quota imposed by the cgroup, in cores. Zero means unlimited.
+	CPULimit float64 `json:"cpu_limit,omitempty" yaml:"cpu_limit,omitempty" mapstructure:"cpu_limit,omitempty"`
+
+	// MemoryLimit is the memory limit imposed by the cgroup, in bytes. Zero means unlimited.
+	MemoryLimit int64 `json:"memory_limit,omitempty" yaml:"memory_limit,omitempty" mapstructure:"memory_limit,omitempty"`
+
+	// OpenFiles and OpenFilesMax are the soft and hard limits on open file descriptors
+	// (RLIMIT_NOFILE). Zero means unlimited or unknown.
+	OpenFiles    int64 `json:"open_files,omitempty" yaml:"open_files,omitempty" mapstructure:"open_files,omitempty"`
+	OpenFilesMax int64 `json:"open_files_max,omitempty" yaml:"open_files_max,omitempty" mapstructure:"open_files_max,omitempty"`
+
+	// Processes and ProcessesMax are the soft and hard limits on the processes and threads of
+	// the user (RLIMIT_NPROC). Zero means unlimited or unknown.
+	Processes    int64 `json:"processes,omitempty" yaml:"processes,omitempty" mapstructure:"processes,omitempty"`
+	ProcessesMax int64 `json:"processes_max,omitempty" yaml:"processes_max,omitempty" mapstructure:"processes_max,omitempty"`
+
+	// MaxThreads is the system wide limit on threads. Zero means unknown.
+	MaxThreads int64 `json:"max_threads,omitempty" yaml:"max_threads,omitempty" mapstructure:"max_threads,omitempty"`
+}
+
+// CPUs returns the number of cores the process can actually use: the cgroup quota when there
+// is one, otherwise the number of logical CPUs.
+func (r ResourceContext) CPUs() float64 {
+	if r.CPULimit > 0 && (r.NumCPU == 0 || r.CPULimit < float64(r.NumCPU)) {
+		return r.CPULimit
+	}
+	return float64(r.NumCPU)
+}
+
+// DetectResources reads the CPU and memory limits of the process's cgroup (v1 or v2) and its
+// resource limits. Limits that cannot be determined, including on systems without cgroups, are
+// left at zero.
+func DetectResources() ResourceContext {
+	r := ResourceContext{NumCPU: runtime.NumCPU(), MaxThreads: readThreadsMax()}
+	r.OpenFiles, r.OpenFilesMax = getRLimit(rlimitOpenFiles)
+	r.Processes, r.ProcessesMax = getRLimit(rlimitProcesses)
+
+	// cgroup v2
+	if data, err := ioutil.ReadFile(filepath.Join(_cgroupRoot, "cpu.max")); err == nil {
+		fields := strings.Fields(string(data))
+		if len(fields) == 2 && fields[0] != "max" {
+			r.CPULimit = cpuQuota(fields[0], fields[1])
+		}
+		r.MemoryLimit = readCgroupInt(filepath.Join(_cgroupRoot, "memory.max"))
+		return r
+	}
+
+	// cgroup v1
+	if quota, err := ioutil.ReadFile(filepath.Join(_cgroupRoot, "cpu", "cpu.cfs_quota_us")); err == nil {
+		if period, err := ioutil.ReadFile(filepath.Join(_cgroupRoot, "cpu", "cpu.cfs_period_us")); err == nil {
+			r.CPULimit = cpuQuota(strings.TrimSpace(string(quota)), strings.TrimSpace(string(period)))
+		}
+	}
+	r.MemoryLimit = readCgroupInt(filepath.Join(_cgroupRoot, "memory", "memory.limit_in_bytes"))
+
+	return r
+}
+
+func cpuQuota(quota, period string) float64 {
+	q, err := strconv.ParseFloat(quota, 64)
+	if err != nil || q <= 0 {
+		return 0
+	}
+	p, err := strconv.ParseFloat(period, 64)
+	if err != nil || p <= 0 {
+		return 0
+	}
+	return q / p
+}
+
+// readCgroupInt reads a byte limit, treating "max" and the v1 "unlimited" sentinel as zero.
+func readCgroupInt(path string) int64 {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0
+	}
+	v, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil || v <= 0 || v >= math.MaxInt64/2 {
+		return 0
+	}
+	return v
+}
@@ -0,0 +1,124 @@
+package cfx
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"runtime/debug"
+	"strconv"
+	"strings"
+
+	"go.uber.org/fx"
+)
+
+// RLimitsKey is the config section read by RLimitsModule.
+const RLimitsKey = "limits"
+
+// _threadsMaxPath holds the system wide limit on the number of threads.
+var _threadsMaxPath = "/proc/sys/kernel/threads-max"
+
+// RLimitsModule raises the soft resource limits of the process to the targets in the "limits"
+// config section when the application is constructed.
+var RLimitsModule = fx.Options(
+	ProvideSection(RLimitsKey, &RLimitsConfig{}),
+	fx.Invoke(ApplyRLimits),
+)
+
+// RLimitsConfig is the configuration section for the resource limits of the process:
+//
+//	limits:
+//	  open_files: max
+//	  processes: 4096
+//	  max_threads: 20000
+type RLimitsConfig struct {
+	// OpenFiles is the target soft limit on open file descriptors (RLIMIT_NOFILE), either a
+	// number or "max" for the hard limit. Empty leaves it unchanged.
+	OpenFiles string `json:"open_files,omitempty" yaml:"open_files,omitempty" mapstructure:"open_files,omitempty"`
+
+	// Processes is the target soft limit on processes and threads of the user (RLIMIT_NPROC),
+	// either a number or "max" for the hard limit. Empty leaves it unchanged.
+	Processes string `json:"processes,omitempty" yaml:"processes,omitempty" mapstructure:"processes,omitempty"`
+
+	// MaxThreads is passed to debug.SetMaxThreads. Zero leaves the Go default of 10000.
+	MaxThreads int `json:"max_threads,omitempty" yaml:"max_threads,omitempty" mapstructure:"max_threads,omitempty"`
+}
+
+// Validate implements the cfx.Validator interface.
+func (r RLimitsConfig) Validate() error {
+	if _, _, err := parseRLimitTarget(r.OpenFiles); err != nil {
+		return fmt.Errorf("limits open_files is invalid: %v", err)
+	}
+	if _, _, err := parseRLimitTarget(r.Processes); err != nil {
+		return fmt.Errorf("limits processes is invalid: %v", err)
+	}
+	if r.MaxThreads < 0 {
+		return errors.New("limits max_threads must not be negative")
+	}
+	return nil
+}
+
+// Apply raises the soft limits of the current process to their targets. Limits are never
+// lowered, and targets above the hard limit are capped at it, so that the same configuration
+// can be used on machines with different hard limits. Unprivileged processes cannot raise hard
+// limits. On platforms without rlimits only MaxThreads is applied.
+func (r RLimitsConfig) Apply() error {
+	if err := r.Validate(); err != nil {
+		return err
+	}
+
+	if target, max, _ := parseRLimitTarget(r.OpenFiles); target > 0 || max {
+		if err := raiseRLimit(rlimitOpenFiles, target, max); err != nil {
+			return fmt.Errorf("could not raise the open files limit: %v", err)
+		}
+	}
+	if target, max, _ := parseRLimitTarget(r.Processes); target > 0 || max {
+		if err := raiseRLimit(rlimitProcesses, target, max); err != nil {
+			return fmt.Errorf("could not raise the processes limit: %v", err)
+		}
+	}
+	if r.MaxThreads > 0 {
+		debug.SetMaxThreads(r.MaxThreads)
+	}
+	return nil
+}
+
+// ApplyRLimits reads the "limits" section and applies it.
+func ApplyRLimits(c Container) error {
+	cfg := RLimitsConfig{}
+	if err := c.Populate(RLimitsKey, &cfg); err != nil {
+		return fmt.Errorf("could not populate limits config: %v", err)
+	}
+	if err := cfg.Apply(); err != nil {
+		return SectionError{Key: RLimitsKey, Err: err}
+	}
+	return nil
+}
+
+// parseRLimitTarget parses a limit target, which is empty, "max" or a positive number.
+func parseRLimitTarget(s string) (uint64, bool, error) {
+	s = strings.TrimSpace(s)
+	switch {
+	case s == "":
+		return 0, false, nil
+	case strings.EqualFold(s, "max"):
+		return 0, true, nil
+	}
+	v, err := strconv.ParseUint(s, 10, 64)
+	if err != nil || v == 0 {
+		return 0, false, fmt.Errorf("%q must be a positive number or \"max\"", s)
+	}
+	return v, false, nil
+}
+
+// readThreadsMax reads the system wide thread limit, or zero when it is unknown.
+func readThreadsMax() int64 {
+	data, err := ioutil.ReadFile(_threadsMaxPath)
+	if err != nil {
+		return 0
+	}
+	v, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil || v < 0 {
+		return 0
+	}
+	return v
+}
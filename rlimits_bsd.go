@@ -0,0 +1,12 @@
+//go:build netbsd || openbsd
+// +build netbsd openbsd
+
+package cfx
+
+// rlimitProcesses is RLIMIT_NPROC.
+const rlimitProcesses = 7
+
+// maxRLimit returns the highest soft limit that can be set for resource.
+func maxRLimit(_ int, hard uint64) uint64 {
+	return hard
+}
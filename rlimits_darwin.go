@@ -0,0 +1,25 @@
+package cfx
+
+import "syscall"
+
+// rlimitProcesses is RLIMIT_NPROC.
+const rlimitProcesses = 7
+
+// _openMax is OPEN_MAX, the most files a process may have open whatever its hard limit.
+const _openMax = 10240
+
+// maxRLimit returns the highest soft limit that can be set for resource. macOS rejects soft
+// limits on open files above OPEN_MAX or kern.maxfilesperproc, even when the hard limit is
+// unlimited.
+func maxRLimit(resource int, hard uint64) uint64 {
+	if resource != rlimitOpenFiles {
+		return hard
+	}
+	if hard > _openMax {
+		hard = _openMax
+	}
+	if n, err := syscall.SysctlUint32("kern.maxfilesperproc"); err == nil && n > 0 && uint64(n) < hard {
+		hard = uint64(n)
+	}
+	return hard
+}
@@ -0,0 +1,6 @@
+package cfx
+
+// maxRLimit returns the highest soft limit that can be set for resource.
+func maxRLimit(_ int, hard uint64) uint64 {
+	return hard
+}
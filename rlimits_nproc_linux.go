@@ -0,0 +1,7 @@
+//go:build !mips && !mipsle && !mips64 && !mips64le
+// +build !mips,!mipsle,!mips64,!mips64le
+
+package cfx
+
+// rlimitProcesses is RLIMIT_NPROC.
+const rlimitProcesses = 6
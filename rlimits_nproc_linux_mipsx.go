@@ -0,0 +1,9 @@
+//go:build linux && (mips || mipsle || mips64 || mips64le)
+// +build linux
+// +build mips mipsle mips64 mips64le
+
+package cfx
+
+// rlimitProcesses is RLIMIT_NPROC, which MIPS numbers differently from other Linux
+// architectures.
+const rlimitProcesses = 8
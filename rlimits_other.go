@@ -0,0 +1,17 @@
+//go:build !linux && !darwin && !netbsd && !openbsd
+// +build !linux,!darwin,!netbsd,!openbsd
+
+package cfx
+
+const (
+	rlimitOpenFiles = 0
+	rlimitProcesses = 0
+)
+
+func getRLimit(int) (int64, int64) {
+	return 0, 0
+}
+
+func raiseRLimit(int, uint64, bool) error {
+	return nil
+}
@@ -0,0 +1,44 @@
+//go:build linux || darwin || netbsd || openbsd
+// +build linux darwin netbsd openbsd
+
+package cfx
+
+import "syscall"
+
+// rlimitOpenFiles is the RLIMIT_NOFILE resource. rlimitProcesses, RLIMIT_NPROC, is defined per
+// platform since the syscall package does not define it and its number differs between them.
+const rlimitOpenFiles = syscall.RLIMIT_NOFILE
+
+// getRLimit returns the soft and hard limits of resource, with zero for unlimited.
+func getRLimit(resource int) (int64, int64) {
+	var r syscall.Rlimit
+	if err := syscall.Getrlimit(resource, &r); err != nil {
+		return 0, 0
+	}
+	return rlimitValue(uint64(r.Cur)), rlimitValue(uint64(r.Max))
+}
+
+func rlimitValue(v uint64) int64 {
+	if v >= 1<<63 {
+		// RLIM_INFINITY
+		return 0
+	}
+	return int64(v)
+}
+
+// raiseRLimit raises the soft limit of resource to target, or to the hard limit if max is set.
+func raiseRLimit(resource int, target uint64, max bool) error {
+	var r syscall.Rlimit
+	if err := syscall.Getrlimit(resource, &r); err != nil {
+		return err
+	}
+	hard := maxRLimit(resource, uint64(r.Max))
+	if max || target > hard {
+		target = hard
+	}
+	if target <= uint64(r.Cur) {
+		return nil
+	}
+	r.Cur = target
+	return syscall.Setrlimit(resource, &r)
+}
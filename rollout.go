@@ -0,0 +1,133 @@
+package cfx
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"strings"
+)
+
+const (
+	// RolloutKey is the YAML key that marks a value as being gradually rolled out.
+	// The stanza must contain `old`, `new` and `percent` keys:
+	//
+	//   timeout:
+	//     cfx:rollout:
+	//       old: 5s
+	//       new: 10s
+	//       percent: 25
+	//
+	// The cfx: prefix keeps ordinary configuration with a "rollout" key, such as
+	// deploy: {rollout: {strategy: canary}}, from being taken for a stanza.
+	RolloutKey = "cfx:rollout"
+
+	_rolloutBuckets = 10000
+)
+
+// Identity returns the most stable identifier available for the running instance.
+// It prefers the configured InstanceID, falling back to the machine UUID and finally the hostname.
+func (e EnvContext) Identity() string {
+	if e.Deployment.InstanceID != "" {
+		return e.Deployment.InstanceID
+	}
+	if e.Host.UUID != "" {
+		return e.Host.UUID
+	}
+	return e.Host.Hostname
+}
+
+// RolloutSelected reports whether the instance described by env falls within the
+// given percentage of the fleet for the provided config key. The result is deterministic
+// for a given instance identity and key, so increasing the percentage only ever adds instances.
+func RolloutSelected(env EnvContext, key string, percent float64) bool {
+	if percent <= 0 {
+		return false
+	}
+	if percent >= 100 {
+		return true
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(env.Identity()))
+	h.Write([]byte{'/'})
+	h.Write([]byte(key))
+
+	return float64(h.Sum32()%_rolloutBuckets) < percent*(_rolloutBuckets/100)
+}
+
+// resolveRollouts replaces every rollout stanza in the tree with its effective value.
+// It returns true if any stanza was found.
+func resolveRollouts(env EnvContext, tree map[string]interface{}) (bool, error) {
+	return resolveRolloutsIn(env, "", tree)
+}
+
+func resolveRolloutsIn(env EnvContext, prefix string, m map[string]interface{}) (bool, error) {
+	changed := false
+	for k, v := range m {
+		child, ok := v.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		key := joinKey(prefix, k)
+		stanza, isRollout := rolloutStanza(child)
+		if !isRollout {
+			sub, err := resolveRolloutsIn(env, key, child)
+			if err != nil {
+				return changed, err
+			}
+			changed = changed || sub
+			continue
+		}
+
+		val, err := evalRollout(env, key, stanza)
+		if err != nil {
+			return changed, err
+		}
+		m[k] = val
+		changed = true
+	}
+
+	return changed, nil
+}
+
+func rolloutStanza(m map[string]interface{}) (map[string]interface{}, bool) {
+	if len(m) != 1 {
+		return nil, false
+	}
+	stanza, ok := m[RolloutKey].(map[string]interface{})
+	return stanza, ok
+}
+
+func evalRollout(env EnvContext, key string, stanza map[string]interface{}) (interface{}, error) {
+	oldVal, hasOld := stanza["old"]
+	newVal, hasNew := stanza["new"]
+	if !hasOld || !hasNew {
+		return nil, fmt.Errorf("rollout for %s must define both old and new values", key)
+	}
+
+	rawPct, ok := stanza["percent"]
+	if !ok {
+		return nil, fmt.Errorf("rollout for %s must define a percent", key)
+	}
+
+	pct, err := strconv.ParseFloat(strings.TrimSuffix(fmt.Sprint(rawPct), "%"), 64)
+	if err != nil {
+		return nil, fmt.Errorf("rollout for %s has an invalid percent %v: %v", key, rawPct, err)
+	}
+	if pct < 0 || pct > 100 {
+		return nil, fmt.Errorf("rollout for %s has percent %v, must be between 0 and 100", key, pct)
+	}
+
+	if RolloutSelected(env, key, pct) {
+		return newVal, nil
+	}
+	return oldVal, nil
+}
+
+func joinKey(prefix, key string) string {
+	if prefix == "" {
+		return key
+	}
+	return prefix + "." + key
+}
@@ -0,0 +1,114 @@
+package cfx
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestRolloutNeedsMarker(t *testing.T) {
+	dir := testDir(t)
+	writeTestConfig(t, dir, "production.yaml", "deploy:\n  rollout:\n    strategy: canary\nhttp:\n  timeout:\n    cfx:rollout:\n      old: 5s\n      new: 10s\n      percent: 100\n")
+
+	c, err := NewConfigWithOptions(EnvContext{ConfigPath: dir, Environment: "production"})
+	if err != nil {
+		t.Fatalf("config with a plain rollout key failed to load: %v", err)
+	}
+
+	var deploy struct {
+		Rollout struct {
+			Strategy string `yaml:"strategy"`
+		} `yaml:"rollout"`
+	}
+	if err := c.Populate("deploy", &deploy); err != nil {
+		t.Fatal(err)
+	}
+	if deploy.Rollout.Strategy != "canary" {
+		t.Errorf("deploy.rollout.strategy = %q, want the plain key kept as data", deploy.Rollout.Strategy)
+	}
+
+	var timeout string
+	if err := c.Populate("http.timeout", &timeout); err != nil {
+		t.Fatal(err)
+	}
+	if timeout != "10s" {
+		t.Errorf("http.timeout = %q, want the rolled out value", timeout)
+	}
+}
+
+func rolloutEnv(i int) EnvContext {
+	env := EnvContext{}
+	env.Deployment.InstanceID = fmt.Sprintf("instance-%d", i)
+	return env
+}
+
+func TestRolloutSelected(t *testing.T) {
+	const fleet = 2000
+	for i := 0; i < fleet; i++ {
+		env := rolloutEnv(i)
+		if RolloutSelected(env, "http.timeout", 0) {
+			t.Fatalf("%s selected at 0 percent", env.Identity())
+		}
+		if !RolloutSelected(env, "http.timeout", 100) {
+			t.Fatalf("%s not selected at 100 percent", env.Identity())
+		}
+		if RolloutSelected(env, "http.timeout", 40) != RolloutSelected(env, "http.timeout", 40) {
+			t.Fatalf("%s selection is not deterministic", env.Identity())
+		}
+	}
+
+	// raising the percentage only adds instances, and roughly the requested share is selected
+	prev := map[int]bool{}
+	for _, pct := range []float64{1, 10, 25, 50, 90} {
+		selected := map[int]bool{}
+		for i := 0; i < fleet; i++ {
+			if RolloutSelected(rolloutEnv(i), "http.timeout", pct) {
+				selected[i] = true
+			}
+		}
+		for i := range prev {
+			if !selected[i] {
+				t.Errorf("instance-%d dropped out when the rollout went to %v percent", i, pct)
+			}
+		}
+		if got, want := float64(len(selected))*100/fleet, pct; got < want-3 || got > want+3 {
+			t.Errorf("%v percent rollout selected %.1f percent of the fleet", pct, got)
+		}
+		prev = selected
+	}
+}
+
+func TestRolloutSelectedPerKey(t *testing.T) {
+	// buckets depend on the key, so the same instances are not always the first to get changes
+	same := 0
+	for i := 0; i < 1000; i++ {
+		if RolloutSelected(rolloutEnv(i), "a", 50) == RolloutSelected(rolloutEnv(i), "b", 50) {
+			same++
+		}
+	}
+	if same > 600 || same < 400 {
+		t.Errorf("%d of 1000 instances made the same choice for two keys, want about half", same)
+	}
+}
+
+func TestEvalRolloutErrors(t *testing.T) {
+	tests := []struct {
+		stanza map[string]interface{}
+		want   string
+	}{
+		{map[string]interface{}{"new": 1, "percent": 5}, "both old and new"},
+		{map[string]interface{}{"old": 1, "new": 2}, "must define a percent"},
+		{map[string]interface{}{"old": 1, "new": 2, "percent": "lots"}, "invalid percent"},
+		{map[string]interface{}{"old": 1, "new": 2, "percent": 101}, "between 0 and 100"},
+	}
+	for _, tt := range tests {
+		_, err := evalRollout(rolloutEnv(0), "k", tt.stanza)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("evalRollout(%v) = %v, want an error containing %q", tt.stanza, err, tt.want)
+		}
+	}
+
+	if v, err := evalRollout(rolloutEnv(0), "k", map[string]interface{}{"old": 1, "new": 2, "percent": "100%"}); err != nil || v != 2 {
+		t.Errorf("percent with a %% suffix = %v, %v, want the new value", v, err)
+	}
+}
@@ -0,0 +1,244 @@
+package cfx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"runtime/pprof"
+	"runtime/trace"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/fx"
+)
+
+// RuntimeKey is the config section read by RuntimeModule.
+const RuntimeKey = "runtime"
+
+// RuntimeModule applies the "runtime" config section when the application is constructed,
+// and runs the pprof endpoint for the lifetime of the application when it is enabled.
+var RuntimeModule = fx.Options(
+	ProvideSection(RuntimeKey, &RuntimeConfig{}),
+	fx.Invoke(ApplyRuntime),
+)
+
+// RuntimeConfig is the configuration section for tuning the Go runtime.
+type RuntimeConfig struct {
+	// GOMAXPROCS overrides the number of OS threads executing Go code. Zero leaves it unchanged.
+	GOMAXPROCS int `json:"gomaxprocs,omitempty" yaml:"gomaxprocs,omitempty" mapstructure:"gomaxprocs,omitempty"`
+
+	// GCPercent sets the garbage collection target percentage. Nil leaves it unchanged, -1 disables the GC.
+	GCPercent *int `json:"gc_percent,omitempty" yaml:"gc_percent,omitempty" mapstructure:"gc_percent,omitempty"`
+
+	// MemoryLimit sets the soft memory limit, e.g. "512MiB". Requires Go 1.19 or newer.
+	MemoryLimit string `json:"memory_limit,omitempty" yaml:"memory_limit,omitempty" mapstructure:"memory_limit,omitempty"`
+
+	// BlockProfileRate is passed to runtime.SetBlockProfileRate. Nil leaves it unchanged, zero
+	// disables block profiling.
+	BlockProfileRate *int `json:"block_profile_rate,omitempty" yaml:"block_profile_rate,omitempty" mapstructure:"block_profile_rate,omitempty"`
+
+	// MutexProfileFraction is passed to runtime.SetMutexProfileFraction. Nil leaves it
+	// unchanged, zero disables mutex profiling.
+	MutexProfileFraction *int `json:"mutex_profile_fraction,omitempty" yaml:"mutex_profile_fraction,omitempty" mapstructure:"mutex_profile_fraction,omitempty"`
+
+	// Pprof configures the profiling endpoint.
+	Pprof PprofConfig `json:"pprof,omitempty" yaml:"pprof,omitempty" mapstructure:"pprof,omitempty"`
+}
+
+// PprofConfig configures the profiling endpoint. It always runs on its own listener so that
+// profiles are never exposed on the application's public server.
+type PprofConfig struct {
+	// Enabled toggles the profiling endpoint.
+	Enabled bool `json:"enabled,omitempty" yaml:"enabled,omitempty" mapstructure:"enabled,omitempty"`
+
+	// Addr is the address the endpoint listens on. Defaults to 127.0.0.1:6060.
+	Addr string `json:"addr,omitempty" yaml:"addr,omitempty" mapstructure:"addr,omitempty"`
+}
+
+// Validate implements the cfx.Validator interface.
+func (r RuntimeConfig) Validate() error {
+	if r.GOMAXPROCS < 0 {
+		return errors.New("runtime gomaxprocs must not be negative")
+	}
+	if r.GCPercent != nil && *r.GCPercent < -1 {
+		return errors.New("runtime gc_percent must be -1 or greater")
+	}
+	if r.MemoryLimit != "" {
+		if _, err := ParseByteSize(r.MemoryLimit); err != nil {
+			return fmt.Errorf("runtime memory_limit is invalid: %v", err)
+		}
+	}
+	if r.BlockProfileRate != nil && *r.BlockProfileRate < 0 || r.MutexProfileFraction != nil && *r.MutexProfileFraction < 0 {
+		return errors.New("runtime profile rates must not be negative")
+	}
+	if r.Pprof.Addr != "" {
+		if _, _, err := net.SplitHostPort(r.Pprof.Addr); err != nil {
+			return fmt.Errorf("runtime pprof addr %q is invalid: %v", r.Pprof.Addr, err)
+		}
+	}
+	return nil
+}
+
+// Apply applies the runtime settings to the current process.
+func (r RuntimeConfig) Apply() error {
+	if err := r.Validate(); err != nil {
+		return err
+	}
+
+	if r.GOMAXPROCS > 0 {
+		runtime.GOMAXPROCS(r.GOMAXPROCS)
+	}
+	if r.GCPercent != nil {
+		debug.SetGCPercent(*r.GCPercent)
+	}
+	if r.MemoryLimit != "" {
+		limit, _ := ParseByteSize(r.MemoryLimit)
+		if err := setMemoryLimit(limit); err != nil {
+			return err
+		}
+	}
+	if r.BlockProfileRate != nil {
+		runtime.SetBlockProfileRate(*r.BlockProfileRate)
+	}
+	if r.MutexProfileFraction != nil {
+		runtime.SetMutexProfileFraction(*r.MutexProfileFraction)
+	}
+
+	return nil
+}
+
+// RuntimeParams are the dependencies of ApplyRuntime.
+type RuntimeParams struct {
+	fx.In
+
+	Lifecycle fx.Lifecycle
+	Config    Container
+}
+
+// ApplyRuntime reads the "runtime" section, applies it and, when enabled, binds the pprof
+// endpoint to the Fx lifecycle.
+func ApplyRuntime(p RuntimeParams) error {
+	cfg := RuntimeConfig{}
+	if err := p.Config.Populate(RuntimeKey, &cfg); err != nil {
+		return fmt.Errorf("could not populate runtime config: %v", err)
+	}
+	if err := cfg.Apply(); err != nil {
+		return SectionError{Key: RuntimeKey, Err: err}
+	}
+	if !cfg.Pprof.Enabled {
+		return nil
+	}
+
+	addr := cfg.Pprof.Addr
+	if addr == "" {
+		addr = "127.0.0.1:6060"
+	}
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           PprofHandler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	p.Lifecycle.Append(fx.Hook{
+		OnStart: func(ctx context.Context) error {
+			ln, err := net.Listen("tcp", addr)
+			if err != nil {
+				return fmt.Errorf("could not listen on %s for pprof: %v", addr, err)
+			}
+			go func() {
+				if err := srv.Serve(ln); err != nil && err != http.ErrServerClosed {
+					log.Printf("cfx pprof endpoint stopped unexpectedly: %v", err)
+				}
+			}()
+			return nil
+		},
+		OnStop: func(ctx context.Context) error {
+			return srv.Shutdown(ctx)
+		},
+	})
+
+	return nil
+}
+
+// PprofHandler returns a handler serving runtime profiles under /debug/pprof/ without
+// registering anything on http.DefaultServeMux.
+func PprofHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", servePprofIndex)
+	mux.HandleFunc("/debug/pprof/profile", servePprofCPU)
+	mux.HandleFunc("/debug/pprof/trace", servePprofTrace)
+	return mux
+}
+
+func servePprofIndex(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/debug/pprof/")
+	if name == "" {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		for _, p := range pprof.Profiles() {
+			fmt.Fprintf(w, "%s\t%d\n", p.Name(), p.Count())
+		}
+		fmt.Fprintln(w, "profile\t(cpu, ?seconds=30)")
+		fmt.Fprintln(w, "trace\t(execution trace, ?seconds=1)")
+		return
+	}
+
+	p := pprof.Lookup(name)
+	if p == nil {
+		http.Error(w, "unknown profile", http.StatusNotFound)
+		return
+	}
+
+	dbg, _ := strconv.Atoi(r.FormValue("debug"))
+	if dbg > 0 {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	} else {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, name))
+	}
+	if name == "heap" && r.FormValue("gc") != "" {
+		runtime.GC()
+	}
+	p.WriteTo(w, dbg)
+}
+
+func profileSeconds(r *http.Request, def int) time.Duration {
+	sec, err := strconv.Atoi(r.FormValue("seconds"))
+	if err != nil || sec <= 0 {
+		sec = def
+	}
+	return time.Duration(sec) * time.Second
+}
+
+func servePprofCPU(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", `attachment; filename="profile"`)
+	if err := pprof.StartCPUProfile(w); err != nil {
+		http.Error(w, fmt.Sprintf("could not enable cpu profiling: %v", err), http.StatusInternalServerError)
+		return
+	}
+	select {
+	case <-time.After(profileSeconds(r, 30)):
+	case <-r.Context().Done():
+	}
+	pprof.StopCPUProfile()
+}
+
+func servePprofTrace(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", `attachment; filename="trace"`)
+	if err := trace.Start(w); err != nil {
+		http.Error(w, fmt.Sprintf("could not enable tracing: %v", err), http.StatusInternalServerError)
+		return
+	}
+	select {
+	case <-time.After(profileSeconds(r, 1)):
+	case <-r.Context().Done():
+	}
+	trace.Stop()
+}
@@ -0,0 +1,12 @@
+//go:build !go1.19
+// +build !go1.19
+
+package cfx
+
+import (
+	"errors"
+)
+
+func setMemoryLimit(limit int64) error {
+	return errors.New("runtime memory_limit requires the application to be built with Go 1.19 or newer")
+}
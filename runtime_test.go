@@ -0,0 +1,26 @@
+package cfx
+
+import (
+	"runtime"
+	"testing"
+)
+
+func TestRuntimeApplyLeavesUnsetRates(t *testing.T) {
+	prev := runtime.SetMutexProfileFraction(3)
+	defer runtime.SetMutexProfileFraction(prev)
+
+	if err := (RuntimeConfig{}).Apply(); err != nil {
+		t.Fatal(err)
+	}
+	if got := runtime.SetMutexProfileFraction(-1); got != 3 {
+		t.Errorf("mutex profile fraction = %d after applying an empty config, want 3", got)
+	}
+
+	zero := 0
+	if err := (RuntimeConfig{MutexProfileFraction: &zero}).Apply(); err != nil {
+		t.Fatal(err)
+	}
+	if got := runtime.SetMutexProfileFraction(-1); got != 0 {
+		t.Errorf("mutex profile fraction = %d, want 0", got)
+	}
+}
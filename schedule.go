@@ -0,0 +1,91 @@
+package cfx
+
+import (
+	"fmt"
+	"time"
+)
+
+// ScheduledKey is the YAML key that marks a value as having time-boxed variants.
+// The stanza is a list of candidate values; the first entry whose activation window
+// contains the current time is used. An entry without a window always matches, so
+// it can be used as a trailing fallback. If no entry matches the key is treated as unset.
+//
+//	enabled:
+//	  cfx:scheduled:
+//	    - value: true
+//	      effective_from: 2020-06-01T02:00:00Z
+//	      effective_until: 2020-06-01T04:00:00Z
+//	    - value: false
+//
+// The cfx: prefix keeps ordinary configuration with a "scheduled" key from being taken for a
+// stanza.
+const ScheduledKey = "cfx:scheduled"
+
+var _scheduleTimeFormats = []string{
+	time.RFC3339,
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+}
+
+func evalSchedule(rc readContext, key string, entries []interface{}) (interface{}, bool, error) {
+	for i, e := range entries {
+		entry, ok := e.(map[string]interface{})
+		if !ok {
+			return nil, false, fmt.Errorf("scheduled value %s[%d] must be a map", key, i)
+		}
+
+		val, ok := entry["value"]
+		if !ok {
+			return nil, false, fmt.Errorf("scheduled value %s[%d] must define a value", key, i)
+		}
+
+		active, err := windowActive(rc.now, entry["effective_from"], entry["effective_until"])
+		if err != nil {
+			return nil, false, fmt.Errorf("scheduled value %s[%d] has an invalid window: %v", key, i, err)
+		}
+		if active {
+			return val, true, nil
+		}
+	}
+
+	return nil, false, nil
+}
+
+func windowActive(now time.Time, from, until interface{}) (bool, error) {
+	if from != nil {
+		t, err := parseScheduleTime(from)
+		if err != nil {
+			return false, err
+		}
+		if now.Before(t) {
+			return false, nil
+		}
+	}
+
+	if until != nil {
+		t, err := parseScheduleTime(until)
+		if err != nil {
+			return false, err
+		}
+		if !now.Before(t) {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+func parseScheduleTime(v interface{}) (time.Time, error) {
+	if t, ok := v.(time.Time); ok {
+		return t, nil
+	}
+
+	s := fmt.Sprint(v)
+	for _, layout := range _scheduleTimeFormats {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("could not parse %q as a timestamp", s)
+}
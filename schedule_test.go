@@ -0,0 +1,120 @@
+package cfx
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestScheduledNeedsMarker(t *testing.T) {
+	dir := testDir(t)
+	writeTestConfig(t, dir, "production.yaml", "backups:\n  scheduled:\n    - nightly\n    - weekly\nmaintenance:\n  enabled:\n    cfx:scheduled:\n      - value: true\n")
+
+	c, err := NewConfigWithOptions(EnvContext{ConfigPath: dir, Environment: "production"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var backups struct {
+		Scheduled []string `yaml:"scheduled"`
+	}
+	if err := c.Populate("backups", &backups); err != nil {
+		t.Fatal(err)
+	}
+	if len(backups.Scheduled) != 2 || backups.Scheduled[0] != "nightly" {
+		t.Errorf("backups.scheduled = %v, want the plain key kept as data", backups.Scheduled)
+	}
+
+	var enabled bool
+	if err := c.Populate("maintenance.enabled", &enabled); err != nil {
+		t.Fatal(err)
+	}
+	if !enabled {
+		t.Error("maintenance.enabled is false, want the scheduled value")
+	}
+}
+
+func TestScheduledWindows(t *testing.T) {
+	dir := testDir(t)
+	writeTestConfig(t, dir, "production.yaml", `maintenance:
+  mode:
+    cfx:scheduled:
+      - value: window
+        effective_from: 2020-06-01T02:00:00Z
+        effective_until: 2020-06-01T04:00:00Z
+      - value: after
+        effective_from: "2020-06-02"
+      - value: fallback
+  banner:
+    cfx:scheduled:
+      - value: sale
+        effective_from: 2020-06-01 02:00:00
+        effective_until: 2020-06-01 04:00:00
+`)
+
+	var mu sync.Mutex
+	now := time.Date(2020, 6, 1, 3, 0, 0, 0, time.UTC)
+	clock := WithClock(func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		return now
+	})
+	c, err := NewConfigWithOptions(EnvContext{ConfigPath: dir, Environment: "production"}, clock)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		at     time.Time
+		mode   string
+		banner *string
+	}{
+		{time.Date(2020, 6, 1, 3, 0, 0, 0, time.UTC), "window", strPtr("sale")},
+		{time.Date(2020, 6, 1, 2, 0, 0, 0, time.UTC), "window", strPtr("sale")},
+		{time.Date(2020, 6, 1, 4, 0, 0, 0, time.UTC), "fallback", nil},
+		{time.Date(2020, 6, 1, 1, 59, 0, 0, time.UTC), "fallback", nil},
+		{time.Date(2020, 6, 2, 0, 0, 0, 0, time.UTC), "after", nil},
+	}
+	for _, tt := range tests {
+		mu.Lock()
+		now = tt.at
+		mu.Unlock()
+
+		var m struct {
+			Mode   string  `yaml:"mode"`
+			Banner *string `yaml:"banner"`
+		}
+		if err := c.Populate("maintenance", &m); err != nil {
+			t.Fatal(err)
+		}
+		if m.Mode != tt.mode {
+			t.Errorf("at %s mode = %q, want %q", tt.at, m.Mode, tt.mode)
+		}
+		if (m.Banner == nil) != (tt.banner == nil) || (m.Banner != nil && *m.Banner != *tt.banner) {
+			t.Errorf("at %s banner = %v, want %v", tt.at, m.Banner, tt.banner)
+		}
+	}
+}
+
+func strPtr(s string) *string {
+	return &s
+}
+
+func TestEvalScheduleErrors(t *testing.T) {
+	rc := readContext{now: time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)}
+	tests := []struct {
+		entries []interface{}
+		want    string
+	}{
+		{[]interface{}{"on"}, "must be a map"},
+		{[]interface{}{map[string]interface{}{"effective_from": "2020-01-01"}}, "must define a value"},
+		{[]interface{}{map[string]interface{}{"value": 1, "effective_until": "soon"}}, "invalid window"},
+	}
+	for _, tt := range tests {
+		_, _, err := evalSchedule(rc, "k", tt.entries)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("evalSchedule(%v) = %v, want an error containing %q", tt.entries, err, tt.want)
+		}
+	}
+}
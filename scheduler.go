@@ -0,0 +1,257 @@
+package cfx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"go.uber.org/fx"
+)
+
+// JobsKey is the config section read by SchedulerModule.
+const JobsKey = "jobs"
+
+// SchedulerModule runs the jobs registered with ProvideJob on the schedules configured in the
+// "jobs" config section. Changes to the section apply live when the Container is reloaded.
+var SchedulerModule = fx.Options(
+	fx.Invoke(StartScheduler),
+)
+
+// JobConfig configures a single scheduled job.
+type JobConfig struct {
+	// Schedule is a cron expression, see ParseCron.
+	Schedule string `json:"schedule,omitempty" yaml:"schedule,omitempty" mapstructure:"schedule,omitempty"`
+
+	// Disabled stops the job from being scheduled.
+	Disabled bool `json:"disabled,omitempty" yaml:"disabled,omitempty" mapstructure:"disabled,omitempty"`
+
+	// Timeout bounds a single run of the job. Zero means no timeout.
+	Timeout time.Duration `json:"timeout,omitempty" yaml:"timeout,omitempty" mapstructure:"timeout,omitempty"`
+
+	// Timezone is the IANA time zone the schedule is evaluated in. Defaults to the local time zone.
+	Timezone string `json:"timezone,omitempty" yaml:"timezone,omitempty" mapstructure:"timezone,omitempty"`
+}
+
+// Validate implements the cfx.Validator interface.
+func (j JobConfig) Validate() error {
+	if j.Schedule == "" {
+		return errors.New("job schedule is required")
+	}
+	sched, err := ParseCron(j.Schedule)
+	if err != nil {
+		return err
+	}
+	if sched.Next(time.Now()).IsZero() {
+		return fmt.Errorf("job schedule %q never runs", j.Schedule)
+	}
+	if j.Timeout < 0 {
+		return errors.New("job timeout must not be negative")
+	}
+	if _, err := time.LoadLocation(j.Timezone); err != nil {
+		return fmt.Errorf("job timezone %q is invalid: %v", j.Timezone, err)
+	}
+	return nil
+}
+
+// JobsConfig is the "jobs" config section, keyed by job name.
+type JobsConfig map[string]JobConfig
+
+// Validate implements the cfx.Validator interface.
+func (j JobsConfig) Validate() error {
+	for name, job := range j {
+		if err := job.Validate(); err != nil {
+			return fmt.Errorf("job %s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// Job is a named handler run by SchedulerModule.
+type Job struct {
+	Name string
+	Fn   func(ctx context.Context) error
+}
+
+// JobResult adds a Job to the jobs run by SchedulerModule.
+type JobResult struct {
+	fx.Out
+
+	Job Job `group:"cfx_jobs"`
+}
+
+// ProvideJob registers a job handler with SchedulerModule. The job runs on the schedule set
+// under jobs.<name> in the configuration and is not run while it has none.
+func ProvideJob(name string, fn func(ctx context.Context) error) fx.Option {
+	return fx.Provide(func() JobResult {
+		return JobResult{Job: Job{Name: name, Fn: fn}}
+	})
+}
+
+// SchedulerParams are the dependencies of StartScheduler.
+type SchedulerParams struct {
+	fx.In
+
+	Lifecycle fx.Lifecycle
+	Config    Container
+	Jobs      []Job `group:"cfx_jobs"`
+}
+
+// StartScheduler reads the "jobs" section and runs the registered jobs on their schedules for
+// the lifetime of the Fx application. The section is validated before the application starts;
+// configured jobs without a registered handler are ignored.
+func StartScheduler(p SchedulerParams) error {
+	s := &scheduler{jobs: map[string]Job{}}
+	for _, j := range p.Jobs {
+		if _, exists := s.jobs[j.Name]; exists {
+			return fmt.Errorf("job %s is registered more than once", j.Name)
+		}
+		s.jobs[j.Name] = j
+	}
+
+	cfg, err := readJobsConfig(p.Config)
+	if err != nil {
+		return err
+	}
+
+	p.Lifecycle.Append(fx.Hook{
+		OnStart: func(context.Context) error {
+			s.apply(cfg)
+			return OnSectionChange(p.Config, JobsKey, func(string, []Change) error {
+				cfg, err := readJobsConfig(p.Config)
+				if err != nil {
+					return err
+				}
+				s.apply(cfg)
+				return nil
+			})
+		},
+		OnStop: func(ctx context.Context) error {
+			return s.stop(ctx)
+		},
+	})
+
+	return nil
+}
+
+func readJobsConfig(c Container) (JobsConfig, error) {
+	cfg := JobsConfig{}
+	if err := c.Populate(JobsKey, &cfg); err != nil {
+		return nil, fmt.Errorf("could not populate jobs config: %v", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, SectionError{Key: JobsKey, Err: err}
+	}
+	return cfg, nil
+}
+
+// scheduler runs one goroutine per scheduled job.
+type scheduler struct {
+	mu      sync.Mutex
+	jobs    map[string]Job
+	running map[string]*scheduledJob
+	stopped bool
+	wg      sync.WaitGroup
+}
+
+type scheduledJob struct {
+	cfg    JobConfig
+	cancel context.CancelFunc
+}
+
+// apply reconciles the running jobs with cfg, restarting only the jobs whose settings changed.
+func (s *scheduler) apply(cfg JobsConfig) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stopped {
+		return
+	}
+	if s.running == nil {
+		s.running = map[string]*scheduledJob{}
+	}
+
+	names := make([]string, 0, len(s.jobs))
+	for name := range s.jobs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		jc, configured := cfg[name]
+		active := configured && !jc.Disabled
+		cur, running := s.running[name]
+
+		if running && (!active || cur.cfg != jc) {
+			cur.cancel()
+			delete(s.running, name)
+			running = false
+		}
+		if active && !running {
+			ctx, cancel := context.WithCancel(context.Background())
+			s.running[name] = &scheduledJob{cfg: jc, cancel: cancel}
+			s.wg.Add(1)
+			go s.run(ctx, s.jobs[name], jc)
+		}
+	}
+}
+
+// run invokes job on its schedule until ctx is cancelled. Runs never overlap: an activation
+// that arrives while the previous run is still going is skipped.
+func (s *scheduler) run(ctx context.Context, job Job, cfg JobConfig) {
+	defer s.wg.Done()
+
+	sched, _ := ParseCron(cfg.Schedule)
+	loc, _ := time.LoadLocation(cfg.Timezone)
+
+	for {
+		next := sched.Next(time.Now().In(loc))
+		if next.IsZero() {
+			log.Printf("cfx: job %s will never run with schedule %q", job.Name, cfg.Schedule)
+			return
+		}
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		runCtx, cancel := ctx, context.CancelFunc(func() {})
+		if cfg.Timeout > 0 {
+			runCtx, cancel = context.WithTimeout(ctx, cfg.Timeout)
+		}
+		if err := job.Fn(runCtx); err != nil {
+			log.Printf("cfx: job %s failed: %v", job.Name, err)
+		}
+		cancel()
+	}
+}
+
+// stop cancels every job and waits for running jobs to return, or for ctx to expire.
+func (s *scheduler) stop(ctx context.Context) error {
+	s.mu.Lock()
+	s.stopped = true
+	for name, j := range s.running {
+		j.cancel()
+		delete(s.running, name)
+	}
+	s.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("jobs did not finish before shutdown: %v", ctx.Err())
+	}
+}
@@ -0,0 +1,86 @@
+package cfx
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// WithConfigPaths sets the directories searched for configuration files, in order of
+// precedence, overriding the ConfigPath of the EnvContext. The first directory is the primary
+// one: relative paths in the configuration, such as plugin directories, are resolved against
+// it, and it is the ConfigPath reported by the Container.
+//
+//	c, err := cfx.NewConfigWithOptions(env, cfx.WithConfigPaths("./config", "~/.config/myapp", "/etc/myapp"))
+//
+// Paths are expanded with ExpandPath. Directories that do not exist are skipped, like missing
+// entries of $PATH.
+func WithConfigPaths(paths ...string) Option {
+	return func(o *options) {
+		o.configPaths = append([]string{}, paths...)
+	}
+}
+
+// configRoots returns the config directories of e, in order of precedence.
+func (e EnvContext) configRoots() []string {
+	if len(e.ConfigPaths) > 0 {
+		return e.ConfigPaths
+	}
+	return []string{e.ConfigPath}
+}
+
+// applyConfigPaths sets the search path of env from WithConfigPaths.
+func applyConfigPaths(env EnvContext, opts *options) EnvContext {
+	if len(opts.configPaths) == 0 {
+		return env
+	}
+	env.ConfigPaths = make([]string, len(opts.configPaths))
+	for i, p := range opts.configPaths {
+		if expanded, err := ExpandPath(p); err == nil {
+			p = expanded
+		}
+		if abs, err := filepath.Abs(p); err == nil {
+			p = abs
+		}
+		env.ConfigPaths[i] = p
+	}
+	env.ConfigPath = env.ConfigPaths[0]
+	return env
+}
+
+// resolveAcross returns the files holding the configuration called name in every root, lowest
+// precedence first so that they merge in search path order.
+func resolveAcross(roots []string, naming NamingStrategy, name string) ([]string, error) {
+	var ret []string
+	for i := len(roots) - 1; i >= 0; i-- {
+		paths, err := naming.Resolve(roots[i], name)
+		if err == ErrConfigNotFound {
+			continue
+		}
+		if err != nil {
+			if _, statErr := os.Stat(roots[i]); len(roots) > 1 && os.IsNotExist(statErr) {
+				continue
+			}
+			return nil, err
+		}
+		ret = append(ret, paths...)
+	}
+	if len(ret) == 0 {
+		return nil, ErrConfigNotFound
+	}
+	return ret, nil
+}
+
+// readSearchPathManifest returns the environments manifest of the root with the highest
+// precedence that has one, or nil if none does.
+func readSearchPathManifest(roots []string) (*EnvironmentsManifest, error) {
+	for _, root := range roots {
+		if _, err := os.Stat(root); len(roots) > 1 && os.IsNotExist(err) {
+			continue
+		}
+		m, err := readEnvironmentsManifest(root)
+		if err != nil || m != nil {
+			return m, err
+		}
+	}
+	return nil, nil
+}
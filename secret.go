@@ -0,0 +1,121 @@
+package cfx
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+)
+
+// SecretRef is a reference to a secret value held outside of the configuration files.
+// References take the form "<scheme>:<path>", for example "env:DB_PASSWORD" or
+// "file:/run/secrets/db_password", "cred:db_password" for a systemd credential, or
+// "keychain:myapp/token" for the OS keychain. Schemes are served by registered SecretResolvers.
+type SecretRef string
+
+// SecretResolver resolves the path portion of a SecretRef into its value.
+type SecretResolver interface {
+	ResolveSecret(ctx context.Context, path string) (string, error)
+}
+
+// SecretResolverFunc is an adapter to allow the use of ordinary functions as a SecretResolver.
+type SecretResolverFunc func(ctx context.Context, path string) (string, error)
+
+// ResolveSecret implements the SecretResolver interface.
+func (f SecretResolverFunc) ResolveSecret(ctx context.Context, path string) (string, error) {
+	return f(ctx, path)
+}
+
+var (
+	secretResolversMu sync.RWMutex
+	secretResolvers   = map[string]SecretResolver{
+		"env":      SecretResolverFunc(resolveEnvSecret),
+		"file":     SecretResolverFunc(resolveFileSecret),
+		"cred":     SecretResolverFunc(resolveCredential),
+		"keychain": SecretResolverFunc(resolveKeychain),
+	}
+	secretCaches = map[string]Cache{}
+)
+
+// RegisterSecretResolver makes a SecretResolver available for the given scheme.
+// Registering a scheme twice replaces the previous resolver.
+func RegisterSecretResolver(scheme string, r SecretResolver) {
+	secretResolversMu.Lock()
+	defer secretResolversMu.Unlock()
+	secretResolvers[scheme] = r
+}
+
+// SetSecretCache caches the values resolved for scheme in c, so that they are shared between
+// Containers and, with a shared Cache such as a DiskCache, between processes. Passing a nil
+// Cache disables caching for the scheme. Values are cached for their lease TTL, or for
+// DefaultSecretTTL.
+func SetSecretCache(scheme string, c Cache) {
+	secretResolversMu.Lock()
+	defer secretResolversMu.Unlock()
+	if c == nil {
+		delete(secretCaches, scheme)
+		return
+	}
+	secretCaches[scheme] = c
+}
+
+func lookupSecretCache(scheme string) (Cache, bool) {
+	secretResolversMu.RLock()
+	defer secretResolversMu.RUnlock()
+	c, ok := secretCaches[scheme]
+	return c, ok
+}
+
+func lookupSecretResolver(scheme string) (SecretResolver, bool) {
+	secretResolversMu.RLock()
+	defer secretResolversMu.RUnlock()
+	r, ok := secretResolvers[scheme]
+	return r, ok
+}
+
+// Split returns the scheme and path of the reference.
+func (s SecretRef) Split() (string, string, error) {
+	idx := strings.Index(string(s), ":")
+	if idx < 1 {
+		return "", "", fmt.Errorf("secret reference %q must be in the form <scheme>:<path>", s.String())
+	}
+	return string(s)[:idx], string(s)[idx+1:], nil
+}
+
+// IsZero reports whether the reference is empty.
+func (s SecretRef) IsZero() bool {
+	return s == ""
+}
+
+// String implements the fmt.Stringer interface. It never includes the secret value.
+func (s SecretRef) String() string {
+	return string(s)
+}
+
+// Resolve fetches the secret value using the resolver registered for the reference's scheme.
+// If a Cache was set for the scheme with SetSecretCache, it is consulted first.
+func (s SecretRef) Resolve(ctx context.Context) (string, error) {
+	lease, err := s.resolveLease(ctx, false)
+	if err != nil {
+		return "", err
+	}
+	return lease.Value, nil
+}
+
+func resolveEnvSecret(_ context.Context, name string) (string, error) {
+	val, ok := os.LookupEnv(name)
+	if !ok {
+		return "", fmt.Errorf("environment variable %s is not set", name)
+	}
+	return val, nil
+}
+
+func resolveFileSecret(_ context.Context, path string) (string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
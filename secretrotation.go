@@ -0,0 +1,113 @@
+package cfx
+
+import (
+	"context"
+	"time"
+)
+
+// secretRefreshTimeout bounds a single background refresh of a rotating secret.
+const secretRefreshTimeout = 30 * time.Second
+
+// SecretRotatedFunc is called with the previous and current value of a rotated secret.
+type SecretRotatedFunc func(old, new string)
+
+type rotationSub struct {
+	fn SecretRotatedFunc
+}
+
+// OnSecretRotated subscribes fn to changes of the secret at ref. While at least one subscription
+// exists the secret is refreshed in the background before its lease expires, so database pools
+// and TLS clients can swap credentials before the old ones stop working. Changes noticed by
+// Secret.Value and Secret.Refresh are reported too. The returned function cancels the
+// subscription.
+func OnSecretRotated(ref SecretRef, fn SecretRotatedFunc) (cancel func()) {
+	sub := &rotationSub{fn: fn}
+	_secretCache.subscribe(ref, sub)
+	return func() {
+		_secretCache.unsubscribe(ref, sub)
+	}
+}
+
+func (c *secretCache) subscribe(ref SecretRef, sub *rotationSub) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.subs[ref] = append(c.subs[ref], sub)
+	if _, ok := c.watchers[ref]; !ok {
+		stop := make(chan struct{})
+		c.watchers[ref] = stop
+		go c.watch(ref, stop)
+	}
+}
+
+func (c *secretCache) unsubscribe(ref SecretRef, sub *rotationSub) {
+	c.Lock()
+	defer c.Unlock()
+
+	subs := c.subs[ref]
+	for i, s := range subs {
+		if s == sub {
+			subs = append(subs[:i:i], subs[i+1:]...)
+			break
+		}
+	}
+	if len(subs) > 0 {
+		c.subs[ref] = subs
+		return
+	}
+
+	delete(c.subs, ref)
+	if stop, ok := c.watchers[ref]; ok {
+		close(stop)
+		delete(c.watchers, ref)
+	}
+}
+
+func (c *secretCache) notifyRotated(ref SecretRef, old, new string) {
+	c.Lock()
+	subs := append([]*rotationSub{}, c.subs[ref]...)
+	c.Unlock()
+
+	for _, s := range subs {
+		s.fn(old, new)
+	}
+}
+
+// watch refreshes ref shortly before its lease expires, until stop is closed.
+// Failed refreshes are retried with exponential backoff.
+func (c *secretCache) watch(ref SecretRef, stop chan struct{}) {
+	refresh := false
+	retry := time.Second
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), secretRefreshTimeout)
+		_, err := c.get(ctx, ref, refresh)
+		cancel()
+
+		wait := retry
+		if err == nil {
+			refresh, retry = true, time.Second
+			wait = c.refreshIn(ref)
+		} else if retry < time.Minute {
+			retry *= 2
+		}
+
+		select {
+		case <-stop:
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// refreshIn returns how long to wait before refreshing ref, leaving a fifth of its lease.
+func (c *secretCache) refreshIn(ref SecretRef) time.Duration {
+	e := c.entry(ref)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	wait := e.expires.Sub(c.now()) - e.ttl/5
+	if wait < time.Second {
+		wait = time.Second
+	}
+	return wait
+}
@@ -0,0 +1,155 @@
+package cfx
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"go.uber.org/fx"
+)
+
+// Validator is implemented by configuration sections that can check their own values.
+type Validator interface {
+	Validate() error
+}
+
+// SectionSpec describes a configuration section that is populated and validated at startup.
+type SectionSpec struct {
+	// Key is the dotted config key the section lives under.
+	Key string
+
+	// Target is a pointer to a value holding the section's defaults. Every validation
+	// populates a fresh copy of it, so the value itself is never modified.
+	Target interface{}
+}
+
+// Section creates a SectionSpec for the config key, using target as the defaults.
+func Section(key string, target interface{}) SectionSpec {
+	return SectionSpec{Key: key, Target: target}
+}
+
+// New returns a pointer to a fresh copy of the section's defaults.
+func (s SectionSpec) New() (interface{}, error) {
+	v := reflect.ValueOf(s.Target)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		return nil, fmt.Errorf("section %s target must be a non-nil pointer, got %T", s.Key, s.Target)
+	}
+
+	ret := reflect.New(v.Elem().Type())
+	ret.Elem().Set(v.Elem())
+	return ret.Interface(), nil
+}
+
+// Populate populates a fresh copy of the section from the container and validates it.
+func (s SectionSpec) Populate(c Container) (interface{}, error) {
+	return s.populate(c.Populate)
+}
+
+// PopulateContext is like Populate, but resolves ContextResolver values within the section
+// using ctx before validating it.
+func (s SectionSpec) PopulateContext(ctx context.Context, c Container) (interface{}, error) {
+	return s.populate(func(key string, target interface{}) error {
+		return c.PopulateContext(ctx, key, target)
+	})
+}
+
+func (s SectionSpec) populate(fn func(key string, target interface{}) error) (interface{}, error) {
+	target, err := s.New()
+	if err != nil {
+		return nil, err
+	}
+	if err := fn(s.Key, target); err != nil {
+		return nil, err
+	}
+	if v, ok := target.(Validator); ok {
+		if err := v.Validate(); err != nil {
+			return nil, err
+		}
+	}
+	return target, nil
+}
+
+// SectionError is returned when a single section fails to populate or validate.
+type SectionError struct {
+	Key string
+	Err error
+}
+
+// Error implements the error interface.
+func (e SectionError) Error() string {
+	return fmt.Sprintf("config section %s is invalid: %v", e.Key, e.Err)
+}
+
+// ValidationErrors aggregates the failures of several sections.
+type ValidationErrors []SectionError
+
+// Error implements the error interface.
+func (v ValidationErrors) Error() string {
+	msgs := make([]string, len(v))
+	for i, e := range v {
+		msgs[i] = e.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// ValidateSections populates and validates every section, returning ValidationErrors
+// describing all of the sections that failed.
+func ValidateSections(c Container, specs ...SectionSpec) error {
+	var errs ValidationErrors
+	for _, spec := range specs {
+		if _, err := spec.Populate(c); err != nil {
+			errs = append(errs, SectionError{Key: spec.Key, Err: err})
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// SectionResult is used as an Fx container to add a SectionSpec to the set of sections
+// validated at startup.
+type SectionResult struct {
+	fx.Out
+
+	Section SectionSpec `group:"cfx_sections"`
+}
+
+// ProvideSection registers the section at key to be validated when the application starts.
+func ProvideSection(key string, target interface{}) fx.Option {
+	return fx.Provide(func() SectionResult {
+		return SectionResult{Section: Section(key, target)}
+	})
+}
+
+type sectionParams struct {
+	fx.In
+
+	Lifecycle fx.Lifecycle
+	Config    Container
+	Sections  []SectionSpec `group:"cfx_sections"`
+}
+
+// validateSections is invoked by cfx.Module to validate every registered section. The sections
+// are also registered with the Container so that reloaded configurations are held to them, and
+// a Container configured with WithLastKnownGood falls back to its persisted copy if they fail.
+// Once validation passes, readiness is signalled when the application starts.
+var validateSections = fx.Invoke(func(p sectionParams) error {
+	y, ok := p.Config.(*yamlContainer)
+	if !ok {
+		return ValidateSections(p.Config, p.Sections...)
+	}
+
+	y.registerSections(p.Sections)
+	if err := y.validateSnapshot(y.currentSnapshot()); err != nil {
+		if err := y.fallBack(err); err != nil {
+			return err
+		}
+	} else if err := persistLastKnownGood(y.opts, y.currentSnapshot()); err != nil {
+		return err
+	}
+
+	bindReadiness(p.Lifecycle, y)
+	return nil
+})
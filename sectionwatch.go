@@ -0,0 +1,83 @@
+package cfx
+
+import (
+	"fmt"
+	"strings"
+)
+
+// SectionChangeFunc is called after a reload that changed one or more keys under a section.
+// changes holds only the changed leaf keys within that section, with unredacted values.
+type SectionChangeFunc func(key string, changes []Change) error
+
+type sectionHandler struct {
+	key string
+	fn  SectionChangeFunc
+}
+
+// WithSectionChangeHandler registers fn to be called when a reload changes anything under key.
+// Reloads that leave the section untouched do not call fn, so only the components whose
+// configuration actually changed need to reconfigure.
+func WithSectionChangeHandler(key string, fn SectionChangeFunc) Option {
+	return func(o *options) {
+		if fn != nil {
+			o.sectionHandlers = append(o.sectionHandlers, sectionHandler{key: key, fn: fn})
+		}
+	}
+}
+
+// OnSectionChange registers fn on an existing Container to be called when a reload changes
+// anything under key. An empty key matches every change.
+func OnSectionChange(c Container, key string, fn SectionChangeFunc) error {
+	y, ok := c.(*yamlContainer)
+	if !ok {
+		return fmt.Errorf("container of type %T does not support change notifications", c)
+	}
+	if fn == nil {
+		return nil
+	}
+
+	y.Lock()
+	defer y.Unlock()
+	y.handlers = append(y.handlers, sectionHandler{key: key, fn: fn})
+	return nil
+}
+
+// sectionChanges returns the changes that fall under key.
+func sectionChanges(key string, changes []Change) []Change {
+	if key == "" {
+		return changes
+	}
+
+	var ret []Change
+	for _, c := range changes {
+		if c.Key == key || strings.HasPrefix(c.Key, key+".") {
+			ret = append(ret, c)
+		}
+	}
+	return ret
+}
+
+// dispatchSectionChanges calls every handler whose section was touched by the change set.
+// Every handler is called even if an earlier one fails; the first error is returned.
+func (y *yamlContainer) dispatchSectionChanges(changes []Change) error {
+	if len(changes) == 0 {
+		return nil
+	}
+
+	y.RLock()
+	handlers := append(append([]sectionHandler{}, y.opts.sectionHandlers...), y.handlers...)
+	y.RUnlock()
+
+	var first error
+	for _, h := range handlers {
+		sc := sectionChanges(h.key, changes)
+		if len(sc) == 0 {
+			continue
+		}
+		if err := h.fn(h.key, sc); err != nil && first == nil {
+			first = fmt.Errorf("change handler for section %q failed: %v", h.key, err)
+		}
+	}
+
+	return first
+}
@@ -0,0 +1,132 @@
+package cfx
+
+import (
+	"bufio"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// SeccompMode is the seccomp mode of the process.
+type SeccompMode string
+
+// Seccomp modes, as reported by the Seccomp line of /proc/self/status.
+const (
+	SeccompDisabled SeccompMode = "disabled"
+	SeccompStrict   SeccompMode = "strict"
+	SeccompFilter   SeccompMode = "filter"
+)
+
+// Paths read by DetectSecurity.
+var (
+	_selinuxFSPath       = "/sys/fs/selinux"
+	_apparmorEnabledPath = "/sys/module/apparmor/parameters/enabled"
+	_procSelfAttr        = "/proc/self/attr"
+	_procSelfStatus      = "/proc/self/status"
+)
+
+// SecurityContext describes the mandatory access control and syscall confinement of the
+// process, so that confinement can be confirmed from application telemetry. Fields are empty
+// when the mechanism is not available, for example on platforms other than Linux.
+type SecurityContext struct {
+	// SELinux is the SELinux context of the process, e.g.
+	// "system_u:system_r:container_t:s0:c123,c456".
+	SELinux string `json:"selinux,omitempty" yaml:"selinux,omitempty" mapstructure:"selinux,omitempty"`
+
+	// SELinuxMode is "enforcing" or "permissive" when SELinux is enabled.
+	SELinuxMode string `json:"selinux_mode,omitempty" yaml:"selinux_mode,omitempty" mapstructure:"selinux_mode,omitempty"`
+
+	// AppArmor is the AppArmor profile confining the process, or "unconfined".
+	AppArmor string `json:"apparmor,omitempty" yaml:"apparmor,omitempty" mapstructure:"apparmor,omitempty"`
+
+	// AppArmorMode is the mode of the AppArmor profile, "enforce" or "complain".
+	AppArmorMode string `json:"apparmor_mode,omitempty" yaml:"apparmor_mode,omitempty" mapstructure:"apparmor_mode,omitempty"`
+
+	// Seccomp is the seccomp mode of the process.
+	Seccomp SeccompMode `json:"seccomp,omitempty" yaml:"seccomp,omitempty" mapstructure:"seccomp,omitempty"`
+
+	// NoNewPrivs is set when the process cannot gain privileges, e.g. through setuid binaries.
+	NoNewPrivs bool `json:"no_new_privs,omitempty" yaml:"no_new_privs,omitempty" mapstructure:"no_new_privs,omitempty"`
+}
+
+// Confined reports whether an SELinux or AppArmor policy is enforced on the process, or a
+// seccomp filter restricts its syscalls.
+func (s SecurityContext) Confined() bool {
+	return s.SELinuxMode == "enforcing" ||
+		(s.AppArmor != "" && s.AppArmor != "unconfined" && s.AppArmorMode != "complain") ||
+		s.Seccomp == SeccompStrict || s.Seccomp == SeccompFilter
+}
+
+// DetectSecurity reports the SELinux context, AppArmor profile and seccomp mode of the current
+// process.
+func DetectSecurity() SecurityContext {
+	var ret SecurityContext
+
+	if enforce, err := ioutil.ReadFile(_selinuxFSPath + "/enforce"); err == nil {
+		ret.SELinuxMode = "permissive"
+		if strings.TrimSpace(string(enforce)) == "1" {
+			ret.SELinuxMode = "enforcing"
+		}
+		ret.SELinux = readLSMAttr("selinux")
+	}
+
+	if enabled, err := ioutil.ReadFile(_apparmorEnabledPath); err == nil && strings.HasPrefix(string(enabled), "Y") {
+		ret.AppArmor, ret.AppArmorMode = parseAppArmorLabel(readLSMAttr("apparmor"))
+	}
+
+	ret.Seccomp, ret.NoNewPrivs = readSeccompStatus()
+	return ret
+}
+
+// readLSMAttr reads the label of the process for a security module, from its own attr
+// directory on kernels that stack modules, or from the shared file otherwise.
+func readLSMAttr(module string) string {
+	for _, p := range []string{_procSelfAttr + "/" + module + "/current", _procSelfAttr + "/current"} {
+		if data, err := ioutil.ReadFile(p); err == nil {
+			return strings.TrimRight(string(data), "\x00\n")
+		}
+	}
+	return ""
+}
+
+// parseAppArmorLabel splits a label such as "docker-default (enforce)" into the profile and
+// its mode.
+func parseAppArmorLabel(label string) (string, string) {
+	label = strings.TrimSpace(label)
+	if i := strings.LastIndex(label, " ("); i >= 0 && strings.HasSuffix(label, ")") {
+		return label[:i], label[i+2 : len(label)-1]
+	}
+	return label, ""
+}
+
+func readSeccompStatus() (SeccompMode, bool) {
+	f, err := os.Open(_procSelfStatus)
+	if err != nil {
+		return "", false
+	}
+	defer f.Close()
+
+	var mode SeccompMode
+	var nnp bool
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) != 2 {
+			continue
+		}
+		switch fields[0] {
+		case "Seccomp:":
+			switch fields[1] {
+			case "0":
+				mode = SeccompDisabled
+			case "1":
+				mode = SeccompStrict
+			case "2":
+				mode = SeccompFilter
+			}
+		case "NoNewPrivs:":
+			nnp = fields[1] == "1"
+		}
+	}
+	return mode, nnp
+}
@@ -0,0 +1,90 @@
+package cfx
+
+import (
+	"os"
+	"strconv"
+	"sync/atomic"
+)
+
+// ServerlessProvider identifies the functions platform running the process.
+type ServerlessProvider string
+
+// Providers detected by DetectServerless.
+const (
+	ServerlessNone           ServerlessProvider = ""
+	ServerlessLambda         ServerlessProvider = "lambda"
+	ServerlessCloudFunctions ServerlessProvider = "cloud-functions"
+	ServerlessAzureFunctions ServerlessProvider = "azure-functions"
+)
+
+// String implements the fmt.Stringer interface.
+func (s ServerlessProvider) String() string {
+	return string(s)
+}
+
+// _warm is set once the first EnvContext of the process has been created.
+var _warm int32
+
+// ServerlessContext describes the function the process serves on a serverless platform.
+type ServerlessContext struct {
+	// Provider is the detected platform, empty when the process is not a function.
+	Provider ServerlessProvider `json:"provider,omitempty" yaml:"provider,omitempty" mapstructure:"provider,omitempty"`
+
+	// FunctionName is the name of the deployed function.
+	FunctionName string `json:"function_name,omitempty" yaml:"function_name,omitempty" mapstructure:"function_name,omitempty"`
+
+	// Version is the published version or revision of the function.
+	Version string `json:"version,omitempty" yaml:"version,omitempty" mapstructure:"version,omitempty"`
+
+	// MemoryMB is the memory allotted to each instance, in megabytes.
+	MemoryMB int `json:"memory_mb,omitempty" yaml:"memory_mb,omitempty" mapstructure:"memory_mb,omitempty"`
+
+	// ColdStart is true for the first EnvContext created by the process, which is built while
+	// the platform waits on the first invocation.
+	ColdStart bool `json:"cold_start,omitempty" yaml:"cold_start,omitempty" mapstructure:"cold_start,omitempty"`
+}
+
+// Serverless reports whether the process runs on a serverless platform.
+func (s ServerlessContext) Serverless() bool {
+	return s.Provider != ServerlessNone
+}
+
+// DetectServerless works out whether the process is a function on AWS Lambda, Google Cloud
+// Functions or Azure Functions from the variables those platforms set.
+func DetectServerless() ServerlessContext {
+	var ret ServerlessContext
+	switch {
+	case os.Getenv("AWS_LAMBDA_FUNCTION_NAME") != "":
+		ret.Provider = ServerlessLambda
+		ret.FunctionName = os.Getenv("AWS_LAMBDA_FUNCTION_NAME")
+		ret.Version = os.Getenv("AWS_LAMBDA_FUNCTION_VERSION")
+		ret.MemoryMB, _ = strconv.Atoi(os.Getenv("AWS_LAMBDA_FUNCTION_MEMORY_SIZE"))
+	case os.Getenv("FUNCTION_TARGET") != "" && os.Getenv("K_SERVICE") != "",
+		os.Getenv("FUNCTION_NAME") != "" && os.Getenv("FUNCTION_REGION") != "":
+		// 2nd gen functions run on Cloud Run and set K_SERVICE, 1st gen set FUNCTION_NAME and
+		// FUNCTION_REGION; either name alone is too generic to go by
+		ret.Provider = ServerlessCloudFunctions
+		ret.FunctionName = firstEnv("K_SERVICE", "FUNCTION_NAME")
+		ret.Version = firstEnv("K_REVISION", "X_GOOGLE_FUNCTION_VERSION")
+		ret.MemoryMB, _ = strconv.Atoi(os.Getenv("FUNCTION_MEMORY_MB"))
+	case os.Getenv("FUNCTIONS_WORKER_RUNTIME") != "" || os.Getenv("FUNCTIONS_CUSTOMHANDLER_PORT") != "":
+		ret.Provider = ServerlessAzureFunctions
+		ret.FunctionName = os.Getenv("WEBSITE_SITE_NAME")
+		ret.Version = os.Getenv("FUNCTIONS_EXTENSION_VERSION")
+		ret.MemoryMB, _ = strconv.Atoi(os.Getenv("WEBSITE_MEMORY_LIMIT_MB"))
+	default:
+		return ret
+	}
+	ret.ColdStart = atomic.CompareAndSwapInt32(&_warm, 0, 1)
+	return ret
+}
+
+// firstEnv returns the value of the first of keys that is set.
+func firstEnv(keys ...string) string {
+	for _, k := range keys {
+		if v := os.Getenv(k); v != "" {
+			return v
+		}
+	}
+	return ""
+}
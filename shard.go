@@ -0,0 +1,60 @@
+package cfx
+
+import (
+	"hash/fnv"
+)
+
+// ShardKey returns a stable 64 bit hash of the instance's Identity. Instances keep their key
+// across restarts as long as their InstanceID, machine UUID or hostname does not change.
+func (e EnvContext) ShardKey() uint64 {
+	return hashKey(e.Identity())
+}
+
+// Shard returns the shard in [0, of) the instance belongs to, or -1 if of is not positive.
+// Shards are assigned with jump consistent hashing, so growing the number of shards from of to
+// of+1 only moves about 1/(of+1) of the instances, all of them into the new shard.
+func (e EnvContext) Shard(of int) int {
+	return jumpHash(e.ShardKey(), of)
+}
+
+// InShard reports whether the instance belongs to shard n of of. Instances agree on the
+// assignment without coordinating, which makes it suitable for config driven splits of
+// singleton work:
+//
+//	if env.InShard(0, cfg.Workers) {
+//		runMigrations()
+//	}
+//
+// Nothing guarantees that every shard has an instance, or that only one instance is in a
+// shard; use a LeaderElector where exactly one instance must act.
+func (e EnvContext) InShard(n, of int) bool {
+	return n >= 0 && e.Shard(of) == n
+}
+
+// ShardOf returns the shard in [0, of) a key belongs to, using the same assignment as
+// EnvContext.Shard, so work items can be partitioned across shards consistently. It returns -1
+// if of is not positive.
+func ShardOf(key string, of int) int {
+	return jumpHash(hashKey(key), of)
+}
+
+func hashKey(key string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	return h.Sum64()
+}
+
+// jumpHash implements "A Fast, Minimal Memory, Consistent Hash Algorithm" by Lamping and Veach.
+func jumpHash(key uint64, buckets int) int {
+	if buckets <= 0 {
+		return -1
+	}
+
+	var b, j int64 = -1, 0
+	for j < int64(buckets) {
+		b = j
+		key = key*2862933555777941757 + 1
+		j = int64(float64(b+1) * (float64(int64(1)<<31) / float64((key>>33)+1)))
+	}
+	return int(b)
+}
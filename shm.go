@@ -0,0 +1,278 @@
+package cfx
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// Shared snapshot file layout. The file starts with a fixed size header, in little endian
+// byte order, followed by the configuration as JSON or CBOR:
+//
+//	offset  size  field
+//	0       8     magic, "CFXSNAP\x00"
+//	8       4     format version, SharedSnapshotVersion
+//	12      4     payload encoding, 0 for JSON and 1 for CBOR
+//	16      8     generation, incremented on every publish
+//	24      8     payload length in bytes
+//	32      64    fingerprint of the configuration, hex encoded
+//	96      ...   payload
+//
+// A new snapshot is published by atomically replacing the file, so a mapping never changes
+// under a reader. Readers detect a newer snapshot by checking whether the path still refers to
+// the file they mapped.
+const (
+	SharedSnapshotVersion = 1
+
+	_sharedSnapshotMagic      = "CFXSNAP\x00"
+	_sharedSnapshotHeaderSize = 96
+
+	_sharedSnapshotJSON = 0
+	_sharedSnapshotCBOR = 1
+)
+
+// WithSharedSnapshot publishes the configuration to a memory-mapped file at path every time it
+// is loaded, so that sidecar processes, such as log shippers and agents, can read the effective
+// configuration with OpenSharedSnapshot without any round trip to the application. Values are
+// passed through the Container's Redactor. The file is written with 0640 permissions.
+func WithSharedSnapshot(path string) Option {
+	return func(o *options) {
+		o.sharedSnapshot = path
+	}
+}
+
+// publishSharedSnapshot writes the snapshot to the shared snapshot file, if enabled.
+func publishSharedSnapshot(opts *options, snap *snapshot) error {
+	if opts.sharedSnapshot == "" {
+		return nil
+	}
+
+	tree, err := snap.materialized()
+	if err != nil {
+		return err
+	}
+	redacted := redactTree("", tree, opts.redactor)
+	var payload []byte
+	var encoding uint32
+	if opts.snapshotEncoding == EncodingCBOR {
+		payload, err = EncodeCBOR(redacted)
+		encoding = _sharedSnapshotCBOR
+	} else {
+		payload, err = json.Marshal(redacted)
+	}
+	if err != nil {
+		return fmt.Errorf("could not encode shared snapshot: %v", err)
+	}
+
+	var gen uint64 = 1
+	if prev, err := ReadSharedSnapshotHeader(opts.sharedSnapshot); err == nil {
+		gen = prev.Generation + 1
+	}
+
+	buf := make([]byte, _sharedSnapshotHeaderSize, _sharedSnapshotHeaderSize+len(payload))
+	copy(buf[0:8], _sharedSnapshotMagic)
+	binary.LittleEndian.PutUint32(buf[8:12], SharedSnapshotVersion)
+	binary.LittleEndian.PutUint32(buf[12:16], encoding)
+	binary.LittleEndian.PutUint64(buf[16:24], gen)
+	binary.LittleEndian.PutUint64(buf[24:32], uint64(len(payload)))
+	copy(buf[32:96], snap.fingerprint)
+	buf = append(buf, payload...)
+
+	return writeFileLocked(opts.fileLock, opts.sharedSnapshot, buf, 0640)
+}
+
+// SharedSnapshotHeader is the header of a shared snapshot file.
+type SharedSnapshotHeader struct {
+	// Version is the file format version.
+	Version uint32
+
+	// Encoding is the encoding of the payload.
+	Encoding SnapshotEncoding
+
+	// Generation is incremented every time a snapshot is published.
+	Generation uint64
+
+	// Length is the size of the payload in bytes.
+	Length uint64
+
+	// Fingerprint is the fingerprint of the configuration.
+	Fingerprint string
+}
+
+// parseSharedSnapshotHeader parses the header at the start of data, checking the payload
+// length against the size of the file.
+func parseSharedSnapshotHeader(data []byte, size int64) (SharedSnapshotHeader, error) {
+	if len(data) < _sharedSnapshotHeaderSize || string(data[0:8]) != _sharedSnapshotMagic {
+		return SharedSnapshotHeader{}, fmt.Errorf("not a cfx shared snapshot")
+	}
+
+	h := SharedSnapshotHeader{
+		Version:     binary.LittleEndian.Uint32(data[8:12]),
+		Generation:  binary.LittleEndian.Uint64(data[16:24]),
+		Length:      binary.LittleEndian.Uint64(data[24:32]),
+		Fingerprint: string(bytes.TrimRight(data[32:96], "\x00")),
+	}
+	if h.Version != SharedSnapshotVersion {
+		return SharedSnapshotHeader{}, fmt.Errorf("unsupported shared snapshot version %d", h.Version)
+	}
+	switch enc := binary.LittleEndian.Uint32(data[12:16]); enc {
+	case _sharedSnapshotJSON:
+		h.Encoding = EncodingJSON
+	case _sharedSnapshotCBOR:
+		h.Encoding = EncodingCBOR
+	default:
+		return SharedSnapshotHeader{}, fmt.Errorf("unsupported shared snapshot encoding %d", enc)
+	}
+	if h.Length > uint64(size-_sharedSnapshotHeaderSize) {
+		return SharedSnapshotHeader{}, fmt.Errorf("shared snapshot is truncated")
+	}
+	return h, nil
+}
+
+// ReadSharedSnapshotHeader reads only the header of the shared snapshot file at path.
+func ReadSharedSnapshotHeader(path string) (SharedSnapshotHeader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return SharedSnapshotHeader{}, fmt.Errorf("could not open shared snapshot %s: %v", path, err)
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return SharedSnapshotHeader{}, fmt.Errorf("could not stat shared snapshot %s: %v", path, err)
+	}
+
+	buf := make([]byte, _sharedSnapshotHeaderSize)
+	if _, err := f.ReadAt(buf, 0); err != nil {
+		return SharedSnapshotHeader{}, fmt.Errorf("could not read shared snapshot %s: %v", path, err)
+	}
+
+	h, err := parseSharedSnapshotHeader(buf, fi.Size())
+	if err != nil {
+		return SharedSnapshotHeader{}, fmt.Errorf("could not read shared snapshot %s: %v", path, err)
+	}
+	return h, nil
+}
+
+// SharedSnapshot is a shared snapshot file mapped into memory.
+type SharedSnapshot struct {
+	SharedSnapshotHeader
+
+	path  string
+	info  os.FileInfo
+	data  []byte
+	unmap func() error
+}
+
+// OpenSharedSnapshot maps the shared snapshot file at path into memory. Close must be called to
+// release the mapping.
+func OpenSharedSnapshot(path string) (*SharedSnapshot, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not open shared snapshot %s: %v", path, err)
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("could not stat shared snapshot %s: %v", path, err)
+	}
+	if fi.Size() < _sharedSnapshotHeaderSize {
+		return nil, fmt.Errorf("could not read shared snapshot %s: not a cfx shared snapshot", path)
+	}
+
+	data, unmap, err := mapFile(f, int(fi.Size()))
+	if err != nil {
+		return nil, fmt.Errorf("could not map shared snapshot %s: %v", path, err)
+	}
+
+	h, err := parseSharedSnapshotHeader(data, fi.Size())
+	if err != nil {
+		unmap()
+		return nil, fmt.Errorf("could not read shared snapshot %s: %v", path, err)
+	}
+
+	return &SharedSnapshot{SharedSnapshotHeader: h, path: path, info: fi, data: data, unmap: unmap}, nil
+}
+
+// Bytes returns the encoded configuration, see Encoding. The slice refers to the mapped memory
+// and must not be modified or used after Close.
+func (s *SharedSnapshot) Bytes() []byte {
+	return s.data[_sharedSnapshotHeaderSize : _sharedSnapshotHeaderSize+s.Length]
+}
+
+// Decode decodes the configuration into target. CBOR payloads are decoded directly into
+// *map[string]interface{} and *interface{} targets, and through JSON into anything else.
+func (s *SharedSnapshot) Decode(target interface{}) error {
+	if s.Encoding != EncodingCBOR {
+		if err := json.Unmarshal(s.Bytes(), target); err != nil {
+			return fmt.Errorf("could not decode shared snapshot %s: %v", s.path, err)
+		}
+		return nil
+	}
+
+	tree, err := s.Tree()
+	if err != nil {
+		return err
+	}
+	switch t := target.(type) {
+	case *map[string]interface{}:
+		*t = tree
+		return nil
+	case *interface{}:
+		*t = tree
+		return nil
+	}
+	data, err := json.Marshal(tree)
+	if err == nil {
+		err = json.Unmarshal(data, target)
+	}
+	if err != nil {
+		return fmt.Errorf("could not decode shared snapshot %s: %v", s.path, err)
+	}
+	return nil
+}
+
+// Tree decodes the configuration as a generic tree.
+func (s *SharedSnapshot) Tree() (map[string]interface{}, error) {
+	if s.Encoding != EncodingCBOR {
+		tree := map[string]interface{}{}
+		if err := json.Unmarshal(s.Bytes(), &tree); err != nil {
+			return nil, fmt.Errorf("could not decode shared snapshot %s: %v", s.path, err)
+		}
+		return tree, nil
+	}
+
+	v, err := DecodeCBOR(s.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("could not decode shared snapshot %s: %v", s.path, err)
+	}
+	tree, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("could not decode shared snapshot %s: payload is a %T, not a map", s.path, v)
+	}
+	return tree, nil
+}
+
+// Stale reports whether a newer snapshot has been published since s was opened, in which case
+// it should be closed and opened again.
+func (s *SharedSnapshot) Stale() bool {
+	fi, err := os.Stat(s.path)
+	if err != nil {
+		return true
+	}
+	return !os.SameFile(fi, s.info)
+}
+
+// Close releases the mapping.
+func (s *SharedSnapshot) Close() error {
+	if s.unmap == nil {
+		return nil
+	}
+	err := s.unmap()
+	s.unmap = nil
+	s.data = nil
+	return err
+}
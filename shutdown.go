@@ -0,0 +1,223 @@
+package cfx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"go.uber.org/fx"
+)
+
+// ShutdownKey is the config section read by ShutdownModule.
+const ShutdownKey = "shutdown"
+
+// ShutdownModule installs signal handlers and runs the registered shutdown hooks in the order
+// configured by the "shutdown" config section before the Fx application stops.
+var ShutdownModule = fx.Options(
+	ProvideSection(ShutdownKey, DefaultShutdownConfig()),
+	fx.Invoke(InstallShutdown),
+)
+
+var _shutdownSignals = map[string]os.Signal{
+	"SIGINT":  syscall.SIGINT,
+	"SIGTERM": syscall.SIGTERM,
+	"SIGHUP":  syscall.SIGHUP,
+	"SIGQUIT": syscall.SIGQUIT,
+}
+
+// ShutdownConfig is the configuration section for graceful shutdown.
+type ShutdownConfig struct {
+	// GracePeriod bounds how long the shutdown hooks may run in total.
+	GracePeriod time.Duration `json:"grace_period,omitempty" yaml:"grace_period,omitempty" mapstructure:"grace_period,omitempty"`
+
+	// PreStopDelay is waited after a signal arrives, before any hook runs, so load balancers
+	// can stop routing to the instance first.
+	PreStopDelay time.Duration `json:"pre_stop_delay,omitempty" yaml:"pre_stop_delay,omitempty" mapstructure:"pre_stop_delay,omitempty"`
+
+	// Signals lists the signals that trigger a shutdown: SIGINT, SIGTERM, SIGHUP or SIGQUIT.
+	Signals []string `json:"signals,omitempty" yaml:"signals,omitempty" mapstructure:"signals,omitempty"`
+
+	// Order lists hook names in the order they run. Hooks that are not listed run afterwards,
+	// in the order they were registered.
+	Order []string `json:"order,omitempty" yaml:"order,omitempty" mapstructure:"order,omitempty"`
+}
+
+// DefaultShutdownConfig returns a ShutdownConfig with a 30 second grace period that handles
+// SIGINT and SIGTERM.
+func DefaultShutdownConfig() *ShutdownConfig {
+	return &ShutdownConfig{
+		GracePeriod: 30 * time.Second,
+		Signals:     []string{"SIGINT", "SIGTERM"},
+	}
+}
+
+// Validate implements the cfx.Validator interface.
+func (s ShutdownConfig) Validate() error {
+	if s.GracePeriod <= 0 {
+		return errors.New("shutdown grace_period must be positive")
+	}
+	if s.PreStopDelay < 0 {
+		return errors.New("shutdown pre_stop_delay must not be negative")
+	}
+	if s.PreStopDelay >= s.GracePeriod {
+		return errors.New("shutdown pre_stop_delay must be shorter than grace_period")
+	}
+	if _, err := s.signals(); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (s ShutdownConfig) signals() ([]os.Signal, error) {
+	ret := make([]os.Signal, 0, len(s.Signals))
+	for _, name := range s.Signals {
+		key := strings.ToUpper(name)
+		if !strings.HasPrefix(key, "SIG") {
+			key = "SIG" + key
+		}
+		sig, ok := _shutdownSignals[key]
+		if !ok {
+			return nil, fmt.Errorf("shutdown signal %q is not supported", name)
+		}
+		ret = append(ret, sig)
+	}
+	return ret, nil
+}
+
+// ShutdownHook is a named callback run during graceful shutdown, such as draining a queue
+// consumer or deregistering from a load balancer.
+type ShutdownHook struct {
+	Name string
+	Fn   func(ctx context.Context) error
+}
+
+// ShutdownHookResult adds a ShutdownHook to the hooks run by ShutdownModule.
+type ShutdownHookResult struct {
+	fx.Out
+
+	Hook ShutdownHook `group:"cfx_shutdown_hooks"`
+}
+
+// ProvideShutdownHook registers a named shutdown hook with ShutdownModule.
+func ProvideShutdownHook(name string, fn func(ctx context.Context) error) fx.Option {
+	return fx.Provide(func() ShutdownHookResult {
+		return ShutdownHookResult{Hook: ShutdownHook{Name: name, Fn: fn}}
+	})
+}
+
+// ShutdownParams are the dependencies of InstallShutdown.
+type ShutdownParams struct {
+	fx.In
+
+	Lifecycle  fx.Lifecycle
+	Shutdowner fx.Shutdowner
+	Config     Container
+	Hooks      []ShutdownHook `group:"cfx_shutdown_hooks"`
+}
+
+// InstallShutdown reads the "shutdown" section and binds signal handling and the ordered
+// shutdown hooks to the Fx lifecycle. When a configured signal arrives, it waits for the
+// pre-stop delay, runs the hooks within the grace period and then stops the application.
+// If the application stops for another reason, the hooks run as it stops.
+func InstallShutdown(p ShutdownParams) error {
+	cfg := DefaultShutdownConfig()
+	if err := p.Config.Populate(ShutdownKey, cfg); err != nil {
+		return fmt.Errorf("could not populate shutdown config: %v", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		return SectionError{Key: ShutdownKey, Err: err}
+	}
+	sigs, _ := cfg.signals()
+	hooks := orderShutdownHooks(p.Hooks, cfg.Order)
+
+	var once sync.Once
+	var hookErr error
+	runHooks := func(ctx context.Context, delay time.Duration) error {
+		once.Do(func() {
+			ctx, cancel := context.WithTimeout(ctx, cfg.GracePeriod)
+			defer cancel()
+			select {
+			case <-time.After(delay):
+			case <-ctx.Done():
+			}
+			hookErr = runShutdownHooks(ctx, hooks)
+		})
+		return hookErr
+	}
+
+	ch := make(chan os.Signal, 1)
+	done := make(chan struct{})
+	p.Lifecycle.Append(fx.Hook{
+		OnStart: func(context.Context) error {
+			signal.Notify(ch, sigs...)
+			go func() {
+				select {
+				case sig := <-ch:
+					log.Printf("cfx: received %s, shutting down", sig)
+					if err := runHooks(context.Background(), cfg.PreStopDelay); err != nil {
+						log.Printf("cfx: %v", err)
+					}
+					if err := p.Shutdowner.Shutdown(); err != nil {
+						log.Printf("cfx: could not stop application: %v", err)
+					}
+				case <-done:
+				}
+			}()
+			return nil
+		},
+		OnStop: func(ctx context.Context) error {
+			signal.Stop(ch)
+			close(done)
+			return runHooks(ctx, 0)
+		},
+	})
+
+	return nil
+}
+
+// orderShutdownHooks sorts hooks by the configured order, keeping unlisted hooks last in
+// registration order.
+func orderShutdownHooks(hooks []ShutdownHook, order []string) []ShutdownHook {
+	ret := make([]ShutdownHook, 0, len(hooks))
+	used := make([]bool, len(hooks))
+	for _, name := range order {
+		for i, h := range hooks {
+			if !used[i] && h.Name == name {
+				ret = append(ret, h)
+				used[i] = true
+			}
+		}
+	}
+	for i, h := range hooks {
+		if !used[i] {
+			ret = append(ret, h)
+		}
+	}
+	return ret
+}
+
+// runShutdownHooks runs every hook in order, stopping early if ctx expires, and reports
+// the hooks that failed.
+func runShutdownHooks(ctx context.Context, hooks []ShutdownHook) error {
+	failed := []string{}
+	for _, h := range hooks {
+		if err := ctx.Err(); err != nil {
+			failed = append(failed, fmt.Sprintf("%s: not run, grace period exceeded", h.Name))
+			continue
+		}
+		if err := h.Fn(ctx); err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", h.Name, err))
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("shutdown hooks failed: %s", strings.Join(failed, "; "))
+	}
+	return nil
+}
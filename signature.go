@@ -0,0 +1,205 @@
+package cfx
+
+import (
+	"bufio"
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"strings"
+)
+
+// ErrSignatureInvalid is returned when a bundle's signature does not match any trusted key.
+var ErrSignatureInvalid = errors.New("config bundle signature could not be verified with any trusted key")
+
+// BundleVerifier verifies a detached signature over the raw bytes of a config bundle.
+type BundleVerifier interface {
+	Verify(data []byte, sig []byte) error
+}
+
+// ParsePublicKey parses a trusted public key. Both PEM encoded PKIX keys (ECDSA, Ed25519 and RSA,
+// as produced by cosign) and minisign public keys are accepted.
+func ParsePublicKey(data []byte) (BundleVerifier, error) {
+	if block, _ := pem.Decode(data); block != nil {
+		pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse public key: %v", err)
+		}
+		switch k := pub.(type) {
+		case *ecdsa.PublicKey, *rsa.PublicKey, ed25519.PublicKey:
+			return pkixVerifier{key: k}, nil
+		default:
+			return nil, fmt.Errorf("public key type %T is not supported", pub)
+		}
+	}
+
+	return parseMinisignPublicKey(data)
+}
+
+// LoadPublicKeyFile reads and parses a trusted public key from disk.
+func LoadPublicKeyFile(path string) (BundleVerifier, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read public key %s: %v", path, err)
+	}
+	return ParsePublicKey(data)
+}
+
+// verifyAny checks the signature against each trusted key in turn.
+func verifyAny(keys []BundleVerifier, data, sig []byte) error {
+	if len(keys) == 0 {
+		return errors.New("no trusted keys were configured to verify the config bundle")
+	}
+	for _, k := range keys {
+		if k.Verify(data, sig) == nil {
+			return nil
+		}
+	}
+	return ErrSignatureInvalid
+}
+
+// pkixVerifier verifies cosign style signatures: the base64 encoded signature of the blob,
+// ASN.1 encoded for ECDSA and PKCS#1 v1.5 for RSA, both over a SHA-256 digest.
+type pkixVerifier struct {
+	key crypto.PublicKey
+}
+
+// Verify implements the BundleVerifier interface.
+func (p pkixVerifier) Verify(data []byte, sig []byte) error {
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(sig)))
+	if err != nil {
+		return fmt.Errorf("signature is not valid base64: %v", err)
+	}
+
+	digest := sha256.Sum256(data)
+	switch k := p.key.(type) {
+	case *ecdsa.PublicKey:
+		var es struct {
+			R, S *big.Int
+		}
+		if rest, err := asn1.Unmarshal(raw, &es); err != nil || len(rest) != 0 {
+			return ErrSignatureInvalid
+		}
+		if !ecdsa.Verify(k, digest[:], es.R, es.S) {
+			return ErrSignatureInvalid
+		}
+	case *rsa.PublicKey:
+		if err := rsa.VerifyPKCS1v15(k, crypto.SHA256, digest[:], raw); err != nil {
+			return ErrSignatureInvalid
+		}
+	case ed25519.PublicKey:
+		if !ed25519.Verify(k, data, raw) {
+			return ErrSignatureInvalid
+		}
+	}
+	return nil
+}
+
+// minisignVerifier verifies minisign signatures, both pre-hashed (the default since minisign
+// 0.8) and legacy ones made with minisign -l, along with the global signature over the
+// trusted comment.
+type minisignVerifier struct {
+	keyID [8]byte
+	key   ed25519.PublicKey
+}
+
+func parseMinisignPublicKey(data []byte) (BundleVerifier, error) {
+	line := lastNonCommentLine(data)
+	raw, err := base64.StdEncoding.DecodeString(line)
+	if err != nil || len(raw) != 2+8+ed25519.PublicKeySize || string(raw[:2]) != "Ed" {
+		return nil, errors.New("public key is neither a PEM encoded key nor a minisign public key")
+	}
+
+	v := minisignVerifier{key: ed25519.PublicKey(raw[10:])}
+	copy(v.keyID[:], raw[2:10])
+	return v, nil
+}
+
+// Verify implements the BundleVerifier interface.
+func (m minisignVerifier) Verify(data []byte, sig []byte) error {
+	var sigLine, comment, globalLine string
+	sc := bufio.NewScanner(bytes.NewReader(sig))
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		switch {
+		case line == "" || strings.HasPrefix(line, "untrusted comment:"):
+		case strings.HasPrefix(line, "trusted comment:"):
+			// the comment is signed as it is, so only the line ending is removed
+			comment = strings.TrimPrefix(strings.TrimRight(sc.Text(), "\r"), "trusted comment: ")
+		case sigLine == "":
+			sigLine = line
+		case globalLine == "":
+			globalLine = line
+		}
+	}
+	if sigLine == "" {
+		return errors.New("minisign signature is empty")
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(sigLine)
+	if err != nil || len(raw) != 2+8+ed25519.SignatureSize {
+		return errors.New("minisign signature is malformed")
+	}
+
+	msg := data
+	switch string(raw[:2]) {
+	case "Ed":
+	case "ED":
+		digest := blake2b512(data)
+		msg = digest[:]
+	default:
+		return errors.New("minisign signature algorithm is not supported")
+	}
+
+	if !bytes.Equal(raw[2:10], m.keyID[:]) {
+		return ErrSignatureInvalid
+	}
+	if !ed25519.Verify(m.key, msg, raw[10:]) {
+		return ErrSignatureInvalid
+	}
+
+	// the global signature covers the signature and the trusted comment
+	if globalLine == "" {
+		return errors.New("minisign signature has no trusted comment")
+	}
+	global, err := base64.StdEncoding.DecodeString(globalLine)
+	if err != nil || len(global) != ed25519.SignatureSize {
+		return errors.New("minisign global signature is malformed")
+	}
+	if !ed25519.Verify(m.key, append(append([]byte{}, raw[10:]...), comment...), global) {
+		return ErrSignatureInvalid
+	}
+	return nil
+}
+
+// nonCommentLines returns the base64 lines of a minisign file, skipping the comment lines.
+func nonCommentLines(data []byte) []string {
+	ret := []string{}
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "untrusted comment:") || strings.HasPrefix(line, "trusted comment:") {
+			continue
+		}
+		ret = append(ret, line)
+	}
+	return ret
+}
+
+func lastNonCommentLine(data []byte) string {
+	lines := nonCommentLines(data)
+	if len(lines) == 0 {
+		return ""
+	}
+	return lines[len(lines)-1]
+}
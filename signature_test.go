@@ -0,0 +1,116 @@
+package cfx
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestBlake2b512(t *testing.T) {
+	seq := func(n, mod int) []byte {
+		b := make([]byte, n)
+		for i := range b {
+			b[i] = byte(i % mod)
+		}
+		return b
+	}
+	// digests computed with Python's hashlib.blake2b
+	tests := []struct {
+		data []byte
+		want string
+	}{
+		{nil, "786a02f742015903c6c6fd852552d272912f4740e15847618a86e217f71f5419d25e1031afee585313896444934eb04b903a685b1448b755d56f701afe9be2ce"},
+		{[]byte("abc"), "ba80a53f981c4d0d6a2797b69f12f6e94c212f14685ac4b74b12bb6fdbffa2d17d87c5392aab792dc252d5de4533cc9518d38aa8dbf1925ab92386edd4009923"},
+		{seq(128, 256), "2319e3789c47e2daa5fe807f61bec2a1a6537fa03f19ff32e87eecbfd64b7e0e8ccff439ac333b040f19b0c4ddd11a61e24ac1fe0f10a039806c5dcc0da3d115"},
+		{seq(200, 251), "fb3c1f0f56a56f8e316fdf5d853c8c872c39635d083634c3904fc3ac07d1b578e85ff0e480e92d44ade33b62e893ee32343e79ddf6ef292e89b582d312502314"},
+		{seq(256, 251), "93463ac058b6163eb43be3f5bb32b28541498f4e3366f1effe253ad44e1e076e41c3616046027c82a7124f8f4746668ad10b12e8e25a95ac8f3151df01cd5a93"},
+	}
+	for _, tt := range tests {
+		got := blake2b512(tt.data)
+		if hex.EncodeToString(got[:]) != tt.want {
+			t.Errorf("blake2b512 of %d bytes = %x, want %s", len(tt.data), got, tt.want)
+		}
+	}
+}
+
+// minisignKey is a minisign key pair for tests.
+type minisignKey struct {
+	id   [8]byte
+	pub  ed25519.PublicKey
+	priv ed25519.PrivateKey
+}
+
+func newMinisignKey(t *testing.T) minisignKey {
+	t.Helper()
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k := minisignKey{pub: pub, priv: priv}
+	copy(k.id[:], "cfxtest!")
+	return k
+}
+
+func (k minisignKey) publicKey() []byte {
+	raw := append(append([]byte("Ed"), k.id[:]...), k.pub...)
+	return []byte("untrusted comment: minisign public key\n" + base64.StdEncoding.EncodeToString(raw) + "\n")
+}
+
+// sign signs data the way minisign does, pre-hashing it with BLAKE2b-512 unless legacy is set.
+func (k minisignKey) sign(data []byte, legacy bool, comment string) []byte {
+	alg, msg := "ED", data
+	if legacy {
+		alg = "Ed"
+	} else {
+		d := blake2b512(data)
+		msg = d[:]
+	}
+	sig := ed25519.Sign(k.priv, msg)
+	global := ed25519.Sign(k.priv, append(append([]byte{}, sig...), comment...))
+	raw := append(append([]byte(alg), k.id[:]...), sig...)
+	return []byte(fmt.Sprintf("untrusted comment: signature\n%s\ntrusted comment: %s\n%s\n",
+		base64.StdEncoding.EncodeToString(raw), comment, base64.StdEncoding.EncodeToString(global)))
+}
+
+func TestMinisignVerify(t *testing.T) {
+	k := newMinisignKey(t)
+	v, err := ParsePublicKey(k.publicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := []byte("bundle contents")
+	comment := "timestamp:1700000000\tfile:config.tgz"
+
+	for _, legacy := range []bool{false, true} {
+		sig := k.sign(data, legacy, comment)
+		if err := v.Verify(data, sig); err != nil {
+			t.Errorf("legacy=%v: valid signature rejected: %v", legacy, err)
+		}
+		if err := v.Verify([]byte("tampered"), sig); err == nil {
+			t.Errorf("legacy=%v: signature over other data accepted", legacy)
+		}
+
+		// swapping the trusted comment must break the global signature
+		forged := k.sign(data, legacy, comment)
+		other := k.sign(data, legacy, "trusted: no")
+		lines := strings.Split(string(forged), "\n")
+		lines[2] = strings.Split(string(other), "\n")[2]
+		if err := v.Verify(data, []byte(strings.Join(lines, "\n"))); err == nil {
+			t.Errorf("legacy=%v: altered trusted comment accepted", legacy)
+		}
+
+		// the global signature is required
+		if err := v.Verify(data, []byte(strings.Join(strings.Split(string(sig), "\n")[:2], "\n"))); err == nil {
+			t.Errorf("legacy=%v: signature without a trusted comment accepted", legacy)
+		}
+	}
+
+	other := newMinisignKey(t)
+	if err := v.Verify(data, other.sign(data, false, comment)); err == nil {
+		t.Error("signature by another key with the same key ID accepted")
+	}
+}
@@ -0,0 +1,216 @@
+package cfx
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"go.uber.org/config"
+)
+
+// snapshot is an immutable view of a fully merged configuration.
+type snapshot struct {
+	cfg         *config.YAML
+	sources     []string
+	tree        map[string]interface{}
+	fingerprint string
+	loadedAt    time.Time
+
+	// dynamic is set when the tree contains stanzas that must be evaluated at read time.
+	dynamic bool
+
+	// unresolved lists the ${VAR} references to unset variables found while loading.
+	unresolved []UnresolvedExpansion
+
+	// sourceReports and loadDuration describe how the snapshot was loaded.
+	sourceReports []ReportSource
+	loadDuration  time.Duration
+
+	// fallbackReason is set when the snapshot is the last known good configuration.
+	fallbackReason error
+
+	// lazy holds the sections configured with WithLazySections, which are not in tree.
+	lazy *lazyState
+
+	// scalars holds the scalar leaves of tree for the Fast accessors.
+	scalars map[string]scalar
+
+	// stages records how long each stage of loading the snapshot took.
+	stages *stageTimings
+
+	// git is set when the snapshot was loaded from a GitSource.
+	git *ReportGit
+
+	// unusedFiles lists the files in ConfigPath no known environment merges.
+	unusedFiles []UnusedFile
+}
+
+func newSnapshot(env EnvContext, opts *options, provider *config.YAML, sources []string) (*snapshot, error) {
+	var raw interface{}
+	if err := provider.Get(config.Root).Populate(&raw); err != nil {
+		return nil, fmt.Errorf("could not extract merged configuration: %v", err)
+	}
+
+	tree, ok := normalizeValue(raw).(map[string]interface{})
+	if !ok {
+		tree = map[string]interface{}{}
+	}
+
+	// apply app and service specific overrides
+	overlaid := opts.keyHierarchy && overlayHierarchy(env, tree)
+
+	// resolve any values that are being gradually rolled out
+	rolled, err := resolveRollouts(env, tree)
+	if err != nil {
+		return nil, err
+	}
+
+	// compute the values of !expr scalars
+	derived, err := resolveExprs(env, tree)
+	if err != nil {
+		return nil, err
+	}
+
+	tree, transformed, err := applyTransformers(env, opts, tree)
+	if err != nil {
+		return nil, err
+	}
+	if rolled || overlaid || derived || transformed {
+		provider, err = config.NewYAML(config.Static(tree))
+		if err != nil {
+			return nil, fmt.Errorf("error constructing resolved yaml configuration: %v", err)
+		}
+	}
+
+	fp, err := fingerprintTree(tree)
+	if err != nil {
+		return nil, err
+	}
+
+	return &snapshot{
+		cfg:         provider,
+		sources:     sources,
+		tree:        tree,
+		fingerprint: fp,
+		loadedAt:    opts.clock(),
+		dynamic:     hasReadTimeStanzas(tree),
+		scalars:     flattenScalars(tree),
+		stages:      &stageTimings{},
+	}, nil
+}
+
+// normalizeValue converts the map[interface{}]interface{} values produced by the YAML
+// decoder into map[string]interface{} so that the tree can be JSON encoded.
+func normalizeValue(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(t))
+		for k, val := range t {
+			m[fmt.Sprint(k)] = normalizeValue(val)
+		}
+		return m
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(t))
+		for k, val := range t {
+			m[k] = normalizeValue(val)
+		}
+		return m
+	case []interface{}:
+		l := make([]interface{}, len(t))
+		for i, val := range t {
+			l[i] = normalizeValue(val)
+		}
+		return l
+	default:
+		return v
+	}
+}
+
+// fingerprintTree returns a stable sha256 hex digest of a configuration tree.
+func fingerprintTree(tree interface{}) (string, error) {
+	data, err := json.Marshal(tree)
+	if err != nil {
+		return "", fmt.Errorf("could not fingerprint configuration: %v", err)
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// flattenTree walks the tree, returning a map of dotted key paths to leaf values.
+// Lists are treated as leaf values.
+func flattenTree(tree map[string]interface{}) map[string]interface{} {
+	ret := map[string]interface{}{}
+	flattenInto(ret, "", tree)
+	return ret
+}
+
+func flattenInto(dst map[string]interface{}, prefix string, v interface{}) {
+	m, ok := v.(map[string]interface{})
+	if !ok || (len(m) == 0 && prefix != "") {
+		dst[prefix] = v
+		return
+	}
+	for k, val := range m {
+		key := k
+		if prefix != "" {
+			key = strings.Join([]string{prefix, k}, ".")
+		}
+		flattenInto(dst, key, val)
+	}
+}
+
+// ChangeKind describes how a configuration key changed between two snapshots.
+type ChangeKind string
+
+const (
+	// ChangeAdded is used when a key exists only in the new configuration.
+	ChangeAdded ChangeKind = "added"
+
+	// ChangeRemoved is used when a key exists only in the old configuration.
+	ChangeRemoved ChangeKind = "removed"
+
+	// ChangeModified is used when a key exists in both configurations with different values.
+	ChangeModified ChangeKind = "modified"
+)
+
+// Change describes a single leaf key that differs between two configurations.
+type Change struct {
+	Key  string      `json:"key" yaml:"key"`
+	Kind ChangeKind  `json:"kind" yaml:"kind"`
+	Old  interface{} `json:"old,omitempty" yaml:"old,omitempty"`
+	New  interface{} `json:"new,omitempty" yaml:"new,omitempty"`
+}
+
+// diffTrees returns the sorted list of leaf keys that differ between two trees.
+func diffTrees(oldTree, newTree map[string]interface{}) []Change {
+	oldFlat := flattenTree(oldTree)
+	newFlat := flattenTree(newTree)
+
+	changes := []Change{}
+	for k, ov := range oldFlat {
+		nv, exists := newFlat[k]
+		if !exists {
+			changes = append(changes, Change{Key: k, Kind: ChangeRemoved, Old: ov})
+			continue
+		}
+		if !reflect.DeepEqual(ov, nv) {
+			changes = append(changes, Change{Key: k, Kind: ChangeModified, Old: ov, New: nv})
+		}
+	}
+	for k, nv := range newFlat {
+		if _, exists := oldFlat[k]; !exists {
+			changes = append(changes, Change{Key: k, Kind: ChangeAdded, New: nv})
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Key < changes[j].Key
+	})
+
+	return changes
+}
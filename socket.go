@@ -0,0 +1,238 @@
+package cfx
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"time"
+
+	"go.uber.org/fx"
+)
+
+// SocketProtocolVersion is the version of the snapshot served by ServeSocket.
+const SocketProtocolVersion = 1
+
+// _socketTimeout bounds how long a worker waits for a snapshot, and how long the parent spends
+// writing one.
+const _socketTimeout = 10 * time.Second
+
+// SocketSnapshot is the resolved configuration a parent process serves to its workers.
+type SocketSnapshot struct {
+	// Version is the protocol version.
+	Version int `json:"version"`
+
+	// Environment is the environment the parent loaded the configuration in.
+	Environment EnvContext `json:"environment"`
+
+	// Fingerprint is the fingerprint of the configuration.
+	Fingerprint string `json:"fingerprint"`
+
+	// Sources lists the sources the parent merged the configuration from.
+	Sources []ReportSource `json:"sources,omitempty"`
+
+	// Config is the merged and expanded configuration.
+	Config map[string]interface{} `json:"config"`
+}
+
+// WithSocketServer serves the configuration on a unix domain socket at path, see ServeSocket.
+// The server runs for the lifetime of the Fx application when the Container is created with
+// NewFXConfig; otherwise use cfx.ServeSocket.
+func WithSocketServer(path string) Option {
+	return func(o *options) {
+		o.socketServe = path
+	}
+}
+
+// ServeSocket serves the configuration of c on a unix domain socket at path until ctx is
+// cancelled, so that worker processes can attach with NewContainerFromSocket instead of each
+// locating, parsing and expanding the configuration themselves. Every connection receives the
+// configuration being served at the time, so workers see the parent's reloads the next time
+// they reload. The socket is created with 0600 permissions as the configuration is not
+// redacted; a stale socket left at path by a previous process is replaced.
+func ServeSocket(ctx context.Context, c Container, path string) error {
+	y, ok := c.(*yamlContainer)
+	if !ok {
+		return fmt.Errorf("container of type %T cannot be served on a socket", c)
+	}
+
+	if err := removeStaleSocket(path); err != nil {
+		return err
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return fmt.Errorf("could not listen on %s: %v", path, err)
+	}
+	defer os.Remove(path)
+	if err := os.Chmod(path, 0600); err != nil {
+		l.Close()
+		return fmt.Errorf("could not set permissions on %s: %v", path, err)
+	}
+
+	go func() {
+		<-ctx.Done()
+		l.Close()
+	}()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return fmt.Errorf("could not accept on %s: %v", path, err)
+		}
+		go func() {
+			if err := y.writeSocketSnapshot(conn); err != nil {
+				log.Printf("cfx: could not serve configuration on %s: %v", path, err)
+			}
+		}()
+	}
+}
+
+// bindSocketServer starts the socket server with the Fx application when it is enabled.
+func bindSocketServer(lc fx.Lifecycle, c Container, opts *options) {
+	if opts.socketServe == "" {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	lc.Append(fx.Hook{
+		OnStart: func(context.Context) error {
+			go func() {
+				defer close(done)
+				if err := ServeSocket(ctx, c, opts.socketServe); err != nil && ctx.Err() == nil {
+					log.Printf("cfx: config socket server stopped: %v", err)
+				}
+			}()
+			return nil
+		},
+		OnStop: func(stopCtx context.Context) error {
+			cancel()
+			select {
+			case <-done:
+			case <-stopCtx.Done():
+			}
+			return nil
+		},
+	})
+}
+
+// removeStaleSocket removes a socket at path that nothing is listening on.
+func removeStaleSocket(path string) error {
+	fi, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("could not stat %s: %v", path, err)
+	}
+	if fi.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("refusing to replace %s: it is not a socket", path)
+	}
+	if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+		conn.Close()
+		return fmt.Errorf("%s is already being served by another process", path)
+	}
+	if err := os.Remove(path); err != nil {
+		return fmt.Errorf("could not remove stale socket %s: %v", path, err)
+	}
+	return nil
+}
+
+func (y *yamlContainer) writeSocketSnapshot(conn net.Conn) error {
+	defer conn.Close()
+	conn.SetWriteDeadline(time.Now().Add(_socketTimeout))
+
+	snap := y.currentSnapshot()
+	if snap == nil {
+		return fmt.Errorf("no configuration has been loaded")
+	}
+	tree, err := snap.materialized()
+	if err != nil {
+		return err
+	}
+	if y.opts.snapshotEncoding == EncodingCBOR {
+		data, err := encodeSnapshotCBOR(SocketProtocolVersion, y.env, snap.fingerprint, snap.sourceReports, tree)
+		if err != nil {
+			return fmt.Errorf("could not encode configuration: %v", err)
+		}
+		_, err = conn.Write(data)
+		return err
+	}
+	return json.NewEncoder(conn).Encode(SocketSnapshot{
+		Version:     SocketProtocolVersion,
+		Environment: y.env,
+		Fingerprint: snap.fingerprint,
+		Sources:     snap.sourceReports,
+		Config:      tree,
+	})
+}
+
+// NewContainerFromSocket creates a Container serving the configuration of the parent process
+// listening on the unix domain socket at path, along with the environment the parent loaded it
+// in. Reloading the Container fetches the parent's current configuration, and hot reload polls
+// the parent for a new fingerprint. SecretRefs in the configuration are still resolved by the
+// worker when they are populated.
+func NewContainerFromSocket(path string, opts ...Option) (Container, EnvContext, error) {
+	s, err := ReadSocket(path)
+	if err != nil {
+		return nil, EnvContext{}, err
+	}
+
+	opts = append(opts, func(o *options) {
+		o.socketPath = path
+	})
+	c, err := NewConfigWithOptions(s.Environment, opts...)
+	if err != nil {
+		return nil, EnvContext{}, err
+	}
+	return c, s.Environment, nil
+}
+
+// ReadSocket fetches the configuration served by ServeSocket at path.
+func ReadSocket(path string) (*SocketSnapshot, error) {
+	conn, err := net.DialTimeout("unix", path, _socketTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("could not connect to config socket %s: %v", path, err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(_socketTimeout))
+
+	data, err := ioutil.ReadAll(conn)
+	if err != nil {
+		return nil, fmt.Errorf("could not read configuration from socket %s: %v", path, err)
+	}
+	if len(data) == 0 {
+		return nil, fmt.Errorf("config socket %s closed without sending a configuration", path)
+	}
+
+	s := &SocketSnapshot{}
+	if isCBOR(data) {
+		s.Version, s.Environment, s.Fingerprint, s.Sources, s.Config, err = decodeSnapshotCBOR(data)
+	} else {
+		err = json.Unmarshal(data, s)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not decode configuration from socket %s: %v", path, err)
+	}
+	if s.Version != SocketProtocolVersion {
+		return nil, fmt.Errorf("config socket %s speaks protocol version %d, expected %d", path, s.Version, SocketProtocolVersion)
+	}
+
+	return s, nil
+}
+
+// loadSocket builds a snapshot from the configuration served on the socket.
+func loadSocket(env EnvContext, opts *options) (*snapshot, error) {
+	s, err := ReadSocket(opts.socketPath)
+	if err != nil {
+		return nil, err
+	}
+
+	return buildExpandedSnapshot(env, opts, "socket:"+opts.socketPath, s.Config)
+}
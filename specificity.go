@@ -0,0 +1,45 @@
+package cfx
+
+// specificNames returns the names of the config files that refine the one called name for the
+// deployment of env, least specific first:
+//
+//	production.us-east-1          Region
+//	production.payments           ServiceID
+//	production.us-east-1.payments Region and ServiceID
+//
+// A service is more specific than a region, so a file for the service wins over one for the
+// region, and a file for both wins over either.
+func specificNames(env EnvContext, name string) []string {
+	region, service := env.Deployment.Region, env.Deployment.ServiceID
+	var ret []string
+	if region != "" {
+		ret = append(ret, name+"."+region)
+	}
+	if service != "" {
+		ret = append(ret, name+"."+service)
+	}
+	if region != "" && service != "" {
+		ret = append(ret, name+"."+region+"."+service)
+	}
+	return ret
+}
+
+// resolveSpecific returns the files holding the configuration called name and its refinements
+// for the deployment of env, in merge order. Refinements are optional.
+func resolveSpecific(env EnvContext, roots []string, naming NamingStrategy, name string) ([]string, error) {
+	paths, err := resolveAcross(roots, naming, name)
+	if err != nil {
+		return nil, err
+	}
+	for _, n := range specificNames(env, name) {
+		more, err := resolveAcross(roots, naming, n)
+		if err == ErrConfigNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		paths = append(paths, more...)
+	}
+	return paths, nil
+}
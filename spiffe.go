@@ -0,0 +1,251 @@
+package cfx
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	// KeySPIFFEEndpointSocket is the standard variable holding the address of the SPIFFE
+	// Workload API, e.g. "unix:///run/spire/sockets/agent.sock". It is read without a prefix.
+	KeySPIFFEEndpointSocket = "SPIFFE_ENDPOINT_SOCKET"
+
+	// KeySPIFFESVID is the ENV_VAR holding the path of an X.509 SVID written to disk by a
+	// SPIFFE helper, from which the workload's SPIFFE ID is read.
+	KeySPIFFESVID EnvVar = EnvVar("SPIFFE_SVID")
+)
+
+func init() {
+	registerEnvVar(envVarInfo{Var: KeySPIFFESVID, Origin: OriginCFX, Description: "Path of an X.509 SVID from which the workload's SPIFFE ID is read."})
+}
+
+// _spiffeSockets are the well-known locations of the SPIRE agent's Workload API socket.
+var _spiffeSockets = []string{
+	"/run/spire/sockets/agent.sock",
+	"/run/spire/agent-sockets/spire-agent.sock",
+	"/tmp/spire-agent/public/api.sock",
+}
+
+// SPIFFEContext describes the SPIFFE workload identity of the process.
+type SPIFFEContext struct {
+	// ID is the SPIFFE ID of the workload, e.g. "spiffe://example.org/ns/prod/sa/payments".
+	ID string `json:"id,omitempty" yaml:"id,omitempty" mapstructure:"id,omitempty"`
+
+	// TrustDomain is the trust domain of ID, e.g. "example.org".
+	TrustDomain string `json:"trust_domain,omitempty" yaml:"trust_domain,omitempty" mapstructure:"trust_domain,omitempty"`
+
+	// EndpointSocket is the address of the Workload API serving the workload's SVIDs.
+	EndpointSocket string `json:"endpoint_socket,omitempty" yaml:"endpoint_socket,omitempty" mapstructure:"endpoint_socket,omitempty"`
+}
+
+// DetectSPIFFE locates the SPIFFE Workload API socket, from SPIFFE_ENDPOINT_SOCKET or the
+// well-known SPIRE locations, and reads the workload's SPIFFE ID from the SVID named by the
+// SPIFFE_SVID variable. Fetching SVIDs over the Workload API itself requires a gRPC client,
+// which cfx does not carry: use an SVIDSource backed by go-spiffe for that.
+func DetectSPIFFE(prefix EnvKeyPrefix) SPIFFEContext {
+	var ret SPIFFEContext
+	if v := os.Getenv(KeySPIFFEEndpointSocket); v != "" {
+		ret.EndpointSocket = v
+	} else {
+		for _, p := range _spiffeSockets {
+			if fi, err := os.Stat(p); err == nil && fi.Mode()&os.ModeSocket != 0 {
+				ret.EndpointSocket = "unix://" + p
+				break
+			}
+		}
+	}
+
+	if path := KeySPIFFESVID.Get(prefix); path != "" {
+		if certs, err := readCertificates(path); err == nil && len(certs) > 0 {
+			if id, err := SPIFFEID(certs[0]); err == nil {
+				ret.ID = id.String()
+				ret.TrustDomain = id.Host
+			}
+		}
+	}
+	return ret
+}
+
+// SPIFFEID returns the SPIFFE ID in the URI SAN of an X.509 SVID.
+func SPIFFEID(cert *x509.Certificate) (*url.URL, error) {
+	var ids []*url.URL
+	for _, u := range cert.URIs {
+		if u.Scheme == "spiffe" {
+			ids = append(ids, u)
+		}
+	}
+	if len(ids) != 1 {
+		return nil, fmt.Errorf("certificate has %d SPIFFE IDs, an SVID has exactly one", len(ids))
+	}
+	if ids[0].Host == "" {
+		return nil, errors.New("SPIFFE ID has no trust domain")
+	}
+	return ids[0], nil
+}
+
+// SVID is an X.509 SVID with the bundle of its trust domain.
+type SVID struct {
+	// Certificate is the SVID and its private key.
+	Certificate tls.Certificate
+
+	// Bundle holds the certificate authorities of the trust domain, used to verify peers.
+	Bundle *x509.CertPool
+}
+
+// SVIDSource provides the current X.509 SVID of the workload. SVIDs are short lived, so
+// sources are asked again for every TLS handshake. A source backed by the go-spiffe Workload
+// API client adapts in a few lines:
+//
+//	type workloadSource struct{ src *workloadapi.X509Source }
+//
+//	func (w workloadSource) FetchSVID(context.Context) (*cfx.SVID, error) {
+//		svid, err := w.src.GetX509SVID()
+//		...
+//	}
+type SVIDSource interface {
+	FetchSVID(ctx context.Context) (*SVID, error)
+}
+
+// FileSVIDSource returns an SVIDSource reading PEM files kept up to date by a SPIFFE helper,
+// re-read on every fetch so rotated SVIDs are picked up.
+func FileSVIDSource(certFile, keyFile, bundleFile string) SVIDSource {
+	return fileSVIDSource{cert: certFile, key: keyFile, bundle: bundleFile}
+}
+
+type fileSVIDSource struct {
+	cert, key, bundle string
+}
+
+// FetchSVID implements the SVIDSource interface.
+func (f fileSVIDSource) FetchSVID(context.Context) (*SVID, error) {
+	cert, err := tls.LoadX509KeyPair(f.cert, f.key)
+	if err != nil {
+		return nil, fmt.Errorf("could not load SVID: %v", err)
+	}
+	pool, err := loadCertPool(f.bundle)
+	if err != nil {
+		return nil, err
+	}
+	return &SVID{Certificate: cert, Bundle: pool}, nil
+}
+
+// SPIFFEClientTLS returns a *tls.Config that presents the workload's SVID and authenticates
+// the server by its SPIFFE ID rather than its hostname, so config and secret backends can be
+// reached with mesh identity instead of static tokens. serverID is the SPIFFE ID the server
+// must present; when empty, any server in the workload's trust domain is accepted.
+func SPIFFEClientTLS(src SVIDSource, serverID string) *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			svid, err := src.FetchSVID(context.Background())
+			if err != nil {
+				return nil, err
+			}
+			return &svid.Certificate, nil
+		},
+		// hostnames are meaningless for SPIFFE peers, VerifyPeerCertificate checks the chain
+		InsecureSkipVerify: true,
+		VerifyPeerCertificate: func(raw [][]byte, _ [][]*x509.Certificate) error {
+			svid, err := src.FetchSVID(context.Background())
+			if err != nil {
+				return err
+			}
+			return verifySPIFFEPeer(raw, svid, serverID)
+		},
+	}
+}
+
+// SPIFFEHTTPClient returns an *http.Client using SPIFFEClientTLS, for HTTPLayer,
+// NewHTTPConfigClient and the other HTTP backed sources.
+func SPIFFEHTTPClient(src SVIDSource, serverID string) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = SPIFFEClientTLS(src, serverID)
+	return &http.Client{Transport: transport, Timeout: 30 * time.Second}
+}
+
+func verifySPIFFEPeer(raw [][]byte, svid *SVID, serverID string) error {
+	if len(raw) == 0 {
+		return errors.New("server presented no certificate")
+	}
+	certs := make([]*x509.Certificate, len(raw))
+	for i, der := range raw {
+		c, err := x509.ParseCertificate(der)
+		if err != nil {
+			return fmt.Errorf("could not parse server certificate: %v", err)
+		}
+		certs[i] = c
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, c := range certs[1:] {
+		intermediates.AddCert(c)
+	}
+	if _, err := certs[0].Verify(x509.VerifyOptions{
+		Roots:         svid.Bundle,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	}); err != nil {
+		return fmt.Errorf("could not verify server SVID: %v", err)
+	}
+
+	id, err := SPIFFEID(certs[0])
+	if err != nil {
+		return err
+	}
+	if serverID != "" {
+		if id.String() != serverID {
+			return fmt.Errorf("server is %s, expected %s", id, serverID)
+		}
+		return nil
+	}
+	if len(svid.Certificate.Certificate) == 0 {
+		return errors.New("SVID has no certificate")
+	}
+	own, err := x509.ParseCertificate(svid.Certificate.Certificate[0])
+	if err != nil {
+		return fmt.Errorf("could not parse SVID: %v", err)
+	}
+	ownID, err := SPIFFEID(own)
+	if err != nil {
+		return err
+	}
+	if !strings.EqualFold(id.Host, ownID.Host) {
+		return fmt.Errorf("server %s is not in trust domain %s", id, ownID.Host)
+	}
+	return nil
+}
+
+// readCertificates parses every PEM certificate in the file at path.
+func readCertificates(path string) ([]*x509.Certificate, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var ret []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		c, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, c)
+	}
+	return ret, nil
+}
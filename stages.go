@@ -0,0 +1,99 @@
+package cfx
+
+import (
+	"sync"
+	"time"
+)
+
+// LoadStage is a stage of the configuration loading pipeline.
+type LoadStage string
+
+const (
+	// StageDiscovery locates the configuration files for the environment.
+	StageDiscovery LoadStage = "discovery"
+
+	// StageRead reads and decompresses the configuration files.
+	StageRead LoadStage = "read"
+
+	// StageExpand expands environment variables and applies guards to every source.
+	StageExpand LoadStage = "expand"
+
+	// StageParse parses the YAML sources. The underlying provider merges the sources as it
+	// decodes them, so merging is included.
+	StageParse LoadStage = "parse"
+
+	// StageResolve applies key hierarchy overlays, rollouts, !expr values and transformers to
+	// the merged tree, and fingerprints it.
+	StageResolve LoadStage = "resolve"
+
+	// StageValidate validates registered sections and plugin constraints.
+	StageValidate LoadStage = "validate"
+
+	// StageSecrets resolves ContextResolver values, such as secrets, in PopulateContext.
+	StageSecrets LoadStage = "secrets"
+)
+
+// StageTiming is how long a stage of loading the configuration took. Stages that run more than
+// once for the same configuration, such as validation and secret resolution, accumulate.
+type StageTiming struct {
+	Stage    LoadStage     `json:"stage" yaml:"stage"`
+	Start    time.Time     `json:"start" yaml:"start"`
+	Duration time.Duration `json:"duration" yaml:"duration"`
+}
+
+// StageObserver is called every time a stage of loading the configuration completes. Pass the
+// timing's Start and Start+Duration as explicit timestamps to create OpenTelemetry spans:
+//
+//	cfx.WithStageObserver(func(t cfx.StageTiming) {
+//		_, span := tracer.Start(ctx, "cfx."+string(t.Stage), trace.WithTimestamp(t.Start))
+//		span.End(trace.WithTimestamp(t.Start.Add(t.Duration)))
+//	})
+type StageObserver func(StageTiming)
+
+// WithStageObserver registers a function that is called as each stage of loading the
+// configuration completes.
+func WithStageObserver(fn StageObserver) Option {
+	return func(o *options) {
+		if fn != nil {
+			o.stageObservers = append(o.stageObservers, fn)
+		}
+	}
+}
+
+// stageTimings records the stage timings of a snapshot. Validation and secret resolution are
+// recorded after the snapshot is in use, so access is synchronized.
+type stageTimings struct {
+	mu     sync.Mutex
+	stages []StageTiming
+}
+
+// record adds the time elapsed since start to stage and notifies the observers.
+func (s *stageTimings) record(opts *options, stage LoadStage, start time.Time) {
+	t := StageTiming{Stage: stage, Start: start, Duration: opts.clock().Sub(start)}
+	for _, fn := range opts.stageObservers {
+		fn(t)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.stages {
+		if s.stages[i].Stage == stage {
+			s.stages[i].Duration += t.Duration
+			return
+		}
+	}
+	s.stages = append(s.stages, t)
+}
+
+// prepend adds stages that ran before the snapshot was built.
+func (s *stageTimings) prepend(stages []StageTiming) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stages = append(append([]StageTiming{}, stages...), s.stages...)
+}
+
+func (s *stageTimings) list() []StageTiming {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]StageTiming{}, s.stages...)
+}
@@ -0,0 +1,117 @@
+package cfx
+
+import (
+	"io/ioutil"
+	"os"
+	"runtime"
+	"strings"
+)
+
+// Supervisor identifies the init system or supervisor that started the process.
+type Supervisor string
+
+// Supervisors detected by DetectSupervisor.
+const (
+	SupervisorNone           Supervisor = "none"
+	SupervisorSystemd        Supervisor = "systemd"
+	SupervisorLaunchd        Supervisor = "launchd"
+	SupervisorOpenRC         Supervisor = "openrc"
+	SupervisorSysV           Supervisor = "sysv"
+	SupervisorSupervisord    Supervisor = "supervisord"
+	SupervisorDocker         Supervisor = "docker"
+	SupervisorKubernetes     Supervisor = "kubernetes"
+	SupervisorWindowsService Supervisor = "windows-service"
+)
+
+// Paths read by DetectSupervisor.
+var (
+	_dockerEnvPath    = "/.dockerenv"
+	_containerEnvPath = "/run/.containerenv"
+	_systemdRunPath   = "/run/systemd/system"
+	_openrcRunPath    = "/run/openrc"
+	_procOneCgroup    = "/proc/1/cgroup"
+)
+
+// Supervised reports whether the process runs under a supervisor that restarts it, so it
+// should stay in the foreground rather than daemonize.
+func (s Supervisor) Supervised() bool {
+	return s != "" && s != SupervisorNone
+}
+
+// CapturesOutput reports whether the supervisor collects the process's standard output and
+// error, so logs can be written there without timestamps or rotation.
+func (s Supervisor) CapturesOutput() bool {
+	switch s {
+	case SupervisorSystemd, SupervisorSupervisord, SupervisorDocker, SupervisorKubernetes:
+		return true
+	}
+	return false
+}
+
+// Container reports whether the process runs in a container.
+func (s Supervisor) Container() bool {
+	return s == SupervisorDocker || s == SupervisorKubernetes
+}
+
+// String implements the fmt.Stringer interface.
+func (s Supervisor) String() string {
+	return string(s)
+}
+
+// DetectSupervisor works out which init system or supervisor started the process from its
+// environment, its parent and its cgroup. The closest supervisor wins: a process run by
+// supervisord inside a container reports supervisord.
+func DetectSupervisor() Supervisor {
+	switch {
+	case os.Getenv("SUPERVISOR_ENABLED") != "":
+		return SupervisorSupervisord
+	case os.Getenv("INVOCATION_ID") != "":
+		return SupervisorSystemd
+	case os.Getenv("RC_SVCNAME") != "":
+		return SupervisorOpenRC
+	case os.Getenv("KUBERNETES_SERVICE_HOST") != "":
+		return SupervisorKubernetes
+	case inContainer():
+		return SupervisorDocker
+	case runtime.GOOS == "windows":
+		if detectWindows().Service {
+			return SupervisorWindowsService
+		}
+		return SupervisorNone
+	}
+
+	// daemons are re-parented to, or started by, pid 1
+	if os.Getppid() != 1 {
+		return SupervisorNone
+	}
+	switch {
+	case runtime.GOOS == "darwin":
+		return SupervisorLaunchd
+	case runtime.GOOS != "linux":
+		return SupervisorNone
+	case fileExists(_systemdRunPath):
+		return SupervisorSystemd
+	case fileExists(_openrcRunPath):
+		return SupervisorOpenRC
+	}
+	return SupervisorSysV
+}
+
+// inContainer reports whether the process runs in a Docker, Podman or containerd container.
+func inContainer() bool {
+	if fileExists(_dockerEnvPath) || fileExists(_containerEnvPath) {
+		return true
+	}
+	data, err := ioutil.ReadFile(_procOneCgroup)
+	if err != nil {
+		return false
+	}
+	s := string(data)
+	return strings.Contains(s, "/docker") || strings.Contains(s, "/containerd") ||
+		strings.Contains(s, "/kubepods") || strings.Contains(s, "/libpod")
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
@@ -0,0 +1,106 @@
+package cfx
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// CredentialRefPrefix is the name of ${cred:name} references, which expand to systemd
+// credentials passed with LoadCredential= or SetCredential=.
+const CredentialRefPrefix = "cred"
+
+// _procSelfCgroup lists the cgroups of the running process.
+var _procSelfCgroup = "/proc/self/cgroup"
+
+// SystemdContext describes the systemd unit the process runs in. It is empty when the process
+// was not started by systemd.
+type SystemdContext struct {
+	// Unit is the name of the unit, such as "billing.service".
+	Unit string `json:"unit,omitempty" yaml:"unit,omitempty" mapstructure:"unit,omitempty"`
+
+	// InvocationID identifies this run of the unit. ($INVOCATION_ID)
+	InvocationID string `json:"invocation_id,omitempty" yaml:"invocation_id,omitempty" mapstructure:"invocation_id,omitempty"`
+
+	// CredentialsDirectory is where systemd places the unit's credentials. ($CREDENTIALS_DIRECTORY)
+	CredentialsDirectory string `json:"credentials_directory,omitempty" yaml:"credentials_directory,omitempty" mapstructure:"credentials_directory,omitempty"`
+}
+
+// DetectSystemd reads the systemd unit metadata of the process from its environment and
+// cgroup.
+func DetectSystemd() SystemdContext {
+	s := SystemdContext{
+		InvocationID:         os.Getenv("INVOCATION_ID"),
+		CredentialsDirectory: os.Getenv("CREDENTIALS_DIRECTORY"),
+	}
+	if s.InvocationID != "" {
+		s.Unit = cgroupUnit(_procSelfCgroup)
+	}
+	return s
+}
+
+// cgroupUnit returns the innermost service or scope unit in the process's cgroup path.
+func cgroupUnit(path string) string {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		// hierarchy-ID:controller-list:cgroup-path, with the unified hierarchy first on v2
+		parts := strings.SplitN(line, ":", 3)
+		if len(parts) != 3 || (parts[1] != "" && parts[1] != "name=systemd") {
+			continue
+		}
+		elems := strings.Split(parts[2], "/")
+		for i := len(elems) - 1; i >= 0; i-- {
+			if strings.HasSuffix(elems[i], ".service") || strings.HasSuffix(elems[i], ".scope") {
+				return elems[i]
+			}
+		}
+	}
+	return ""
+}
+
+// resolveCredential reads the systemd credential name from $CREDENTIALS_DIRECTORY. Trailing
+// newlines are removed, as for file secrets.
+func resolveCredential(_ context.Context, name string) (string, error) {
+	dir := os.Getenv("CREDENTIALS_DIRECTORY")
+	if dir == "" {
+		return "", fmt.Errorf("credential %s: CREDENTIALS_DIRECTORY is not set, is the process running under systemd with LoadCredential=?", name)
+	}
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		return "", fmt.Errorf("invalid credential name %q", name)
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", fmt.Errorf("credential %s is not set in %s", name, dir)
+		}
+		return "", fmt.Errorf("could not read credential %s: %v", name, err)
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// isCredentialRef reports whether a reference is a ${cred:name} reference.
+func isCredentialRef(exp expansion) bool {
+	return exp.Name == CredentialRefPrefix && exp.Op == ":"
+}
+
+// resolveCredentialRef returns the text a ${cred:name} reference expands to.
+// ${cred:name:-default} provides a default for a missing credential.
+func resolveCredentialRef(exp expansion) (string, error) {
+	name, def, hasDefault := exp.Arg, "", false
+	if i := strings.Index(name, ":-"); i >= 0 {
+		name, def, hasDefault = name[:i], name[i+2:], true
+	}
+
+	val, err := resolveCredential(context.Background(), name)
+	if err != nil && hasDefault {
+		return def, nil
+	}
+	return val, err
+}
@@ -0,0 +1,123 @@
+package cfx
+
+import (
+	"bufio"
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ColorLevel is how many colors the terminal on standard output can show.
+type ColorLevel string
+
+// Color levels detected by DetectTerminal.
+const (
+	ColorNone      ColorLevel = "none"
+	ColorBasic     ColorLevel = "basic"
+	Color256       ColorLevel = "256"
+	ColorTrueColor ColorLevel = "truecolor"
+)
+
+// String implements the fmt.Stringer interface.
+func (c ColorLevel) String() string {
+	return string(c)
+}
+
+// Enabled reports whether color output should be written at all.
+func (c ColorLevel) Enabled() bool {
+	return c != "" && c != ColorNone
+}
+
+// TerminalContext describes the terminal the process is attached to, if any, so CLI tools can
+// decide whether to prompt, how wide to wrap output and whether to color it.
+type TerminalContext struct {
+	// Stdin, Stdout and Stderr report whether each standard stream is a terminal.
+	Stdin  bool `json:"stdin,omitempty" yaml:"stdin,omitempty" mapstructure:"stdin,omitempty"`
+	Stdout bool `json:"stdout,omitempty" yaml:"stdout,omitempty" mapstructure:"stdout,omitempty"`
+	Stderr bool `json:"stderr,omitempty" yaml:"stderr,omitempty" mapstructure:"stderr,omitempty"`
+
+	// Width and Height are the size of the terminal in characters, zero when unknown. COLUMNS
+	// and LINES take precedence.
+	Width  int `json:"width,omitempty" yaml:"width,omitempty" mapstructure:"width,omitempty"`
+	Height int `json:"height,omitempty" yaml:"height,omitempty" mapstructure:"height,omitempty"`
+
+	// Color is the color support of standard output.
+	Color ColorLevel `json:"color,omitempty" yaml:"color,omitempty" mapstructure:"color,omitempty"`
+}
+
+// Interactive reports whether a user can be prompted: standard input and error are both
+// terminals.
+func (t TerminalContext) Interactive() bool {
+	return t.Stdin && t.Stderr
+}
+
+// DetectTerminal inspects the standard streams. Color follows the NO_COLOR, FORCE_COLOR and
+// CLICOLOR_FORCE conventions, then TERM and COLORTERM, and is off when standard output is not
+// a terminal.
+func DetectTerminal() TerminalContext {
+	ret := TerminalContext{
+		Stdin:  isTerminal(os.Stdin.Fd()),
+		Stdout: isTerminal(os.Stdout.Fd()),
+		Stderr: isTerminal(os.Stderr.Fd()),
+	}
+
+	for _, f := range []*os.File{os.Stdout, os.Stderr, os.Stdin} {
+		if w, h, ok := terminalSize(f.Fd()); ok {
+			ret.Width, ret.Height = w, h
+			break
+		}
+	}
+	if n, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && n > 0 {
+		ret.Width = n
+	}
+	if n, err := strconv.Atoi(os.Getenv("LINES")); err == nil && n > 0 {
+		ret.Height = n
+	}
+
+	ret.Color = detectColor(ret.Stdout)
+	return ret
+}
+
+// ReadPassword reads a line from the terminal f without echoing it, for CLI tools asking for
+// a secret.
+func ReadPassword(f *os.File) (string, error) {
+	if !isTerminal(f.Fd()) {
+		return "", errors.New("not a terminal")
+	}
+	line, err := readHidden(f, bufio.NewReader(f))
+	if err != nil && line == "" {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+func detectColor(tty bool) ColorLevel {
+	if _, ok := os.LookupEnv("NO_COLOR"); ok {
+		return ColorNone
+	}
+	forced := false
+	if v := firstEnv("FORCE_COLOR", "CLICOLOR_FORCE"); v != "" {
+		if v == "0" || strings.EqualFold(v, "false") {
+			return ColorNone
+		}
+		forced = true
+	}
+	if !tty && !forced {
+		return ColorNone
+	}
+
+	term := strings.ToLower(os.Getenv("TERM"))
+	switch colorterm := strings.ToLower(os.Getenv("COLORTERM")); {
+	case colorterm == "truecolor" || colorterm == "24bit":
+		return ColorTrueColor
+	case term == "dumb" && !forced:
+		return ColorNone
+	case strings.Contains(term, "256color"):
+		return Color256
+	case os.Getenv("WT_SESSION") != "":
+		// Windows Terminal
+		return ColorTrueColor
+	}
+	return ColorBasic
+}
@@ -0,0 +1,11 @@
+//go:build darwin || freebsd || netbsd || openbsd || dragonfly
+// +build darwin freebsd netbsd openbsd dragonfly
+
+package cfx
+
+import "syscall"
+
+const (
+	_ioctlGetTermios = syscall.TIOCGETA
+	_ioctlSetTermios = syscall.TIOCSETA
+)
@@ -0,0 +1,8 @@
+package cfx
+
+import "syscall"
+
+const (
+	_ioctlGetTermios = syscall.TCGETS
+	_ioctlSetTermios = syscall.TCSETS
+)
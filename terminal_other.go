@@ -0,0 +1,25 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd && !dragonfly && !windows
+// +build !linux,!darwin,!freebsd,!netbsd,!openbsd,!dragonfly,!windows
+
+package cfx
+
+import (
+	"bufio"
+	"errors"
+	"os"
+)
+
+// isTerminal reports whether fd is a terminal. It is never known on this platform.
+func isTerminal(fd uintptr) bool {
+	return false
+}
+
+// terminalSize is not supported on this platform.
+func terminalSize(fd uintptr) (int, int, bool) {
+	return 0, 0, false
+}
+
+// readHidden is not supported on this platform.
+func readHidden(f *os.File, r *bufio.Reader) (string, error) {
+	return "", errors.New("hidden input is not supported on this platform")
+}
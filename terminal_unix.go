@@ -0,0 +1,62 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly
+// +build linux darwin freebsd netbsd openbsd dragonfly
+
+package cfx
+
+import (
+	"bufio"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// winsize mirrors struct winsize.
+type winsize struct {
+	Row, Col, Xpixel, Ypixel uint16
+}
+
+func getTermios(fd uintptr) (*syscall.Termios, error) {
+	var t syscall.Termios
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, _ioctlGetTermios, uintptr(unsafe.Pointer(&t))); errno != 0 {
+		return nil, errno
+	}
+	return &t, nil
+}
+
+func setTermios(fd uintptr, t *syscall.Termios) error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, _ioctlSetTermios, uintptr(unsafe.Pointer(t))); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// isTerminal reports whether fd is a terminal.
+func isTerminal(fd uintptr) bool {
+	_, err := getTermios(fd)
+	return err == nil
+}
+
+// terminalSize returns the width and height of the terminal at fd, in characters.
+func terminalSize(fd uintptr) (int, int, bool) {
+	var ws winsize
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, syscall.TIOCGWINSZ, uintptr(unsafe.Pointer(&ws))); errno != 0 {
+		return 0, 0, false
+	}
+	return int(ws.Col), int(ws.Row), ws.Col > 0
+}
+
+// readHidden reads a line from the terminal f without echoing it.
+func readHidden(f *os.File, r *bufio.Reader) (string, error) {
+	old, err := getTermios(f.Fd())
+	if err != nil {
+		return "", err
+	}
+	t := *old
+	t.Lflag &^= syscall.ECHO
+	t.Lflag |= syscall.ICANON | syscall.ISIG
+	if err := setTermios(f.Fd(), &t); err != nil {
+		return "", err
+	}
+	defer setTermios(f.Fd(), old)
+	return r.ReadString('\n')
+}
@@ -0,0 +1,55 @@
+//go:build windows
+// +build windows
+
+package cfx
+
+import (
+	"bufio"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	_procSetConsoleMode             = _kernel32.NewProc("SetConsoleMode")
+	_procGetConsoleScreenBufferInfo = _kernel32.NewProc("GetConsoleScreenBufferInfo")
+)
+
+// _enableEchoInput is the ENABLE_ECHO_INPUT console mode.
+const _enableEchoInput = 0x0004
+
+// consoleScreenBufferInfo mirrors CONSOLE_SCREEN_BUFFER_INFO.
+type consoleScreenBufferInfo struct {
+	Size, CursorPosition     [2]int16
+	Attributes               uint16
+	Left, Top, Right, Bottom int16
+	MaximumWindowSize        [2]int16
+}
+
+// isTerminal reports whether fd is a console.
+func isTerminal(fd uintptr) bool {
+	var mode uint32
+	return syscall.GetConsoleMode(syscall.Handle(fd), &mode) == nil
+}
+
+// terminalSize returns the width and height of the console window at fd, in characters.
+func terminalSize(fd uintptr) (int, int, bool) {
+	var info consoleScreenBufferInfo
+	if r, _, _ := _procGetConsoleScreenBufferInfo.Call(fd, uintptr(unsafe.Pointer(&info))); r == 0 {
+		return 0, 0, false
+	}
+	return int(info.Right-info.Left) + 1, int(info.Bottom-info.Top) + 1, true
+}
+
+// readHidden reads a line from the console f without echoing it.
+func readHidden(f *os.File, r *bufio.Reader) (string, error) {
+	var mode uint32
+	if err := syscall.GetConsoleMode(syscall.Handle(f.Fd()), &mode); err != nil {
+		return "", err
+	}
+	if r, _, err := _procSetConsoleMode.Call(f.Fd(), uintptr(mode&^_enableEchoInput)); r == 0 {
+		return "", err
+	}
+	defer _procSetConsoleMode.Call(f.Fd(), uintptr(mode))
+	return r.ReadString('\n')
+}
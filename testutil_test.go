@@ -0,0 +1,42 @@
+package cfx
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testDir returns a temporary directory removed when the test ends.
+func testDir(t *testing.T) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "cfx-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
+func writeTestConfig(t *testing.T, dir, name, data string) {
+	t.Helper()
+	if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// setTestEnv sets the environment variable key to val until the test ends.
+func setTestEnv(t *testing.T, key, val string) {
+	t.Helper()
+	old, ok := os.LookupEnv(key)
+	if err := os.Setenv(key, val); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if ok {
+			os.Setenv(key, old)
+		} else {
+			os.Unsetenv(key)
+		}
+	})
+}
@@ -0,0 +1,339 @@
+package cfx
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"go.uber.org/fx"
+)
+
+var _tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+var _tlsClientAuth = map[string]tls.ClientAuthType{
+	"":                   tls.NoClientCert,
+	"none":               tls.NoClientCert,
+	"request":            tls.RequestClientCert,
+	"require":            tls.RequireAnyClientCert,
+	"verify_if_given":    tls.VerifyClientCertIfGiven,
+	"require_and_verify": tls.RequireAndVerifyClientCert,
+}
+
+// TLSConfig is the canonical configuration section for TLS material.
+// Certificates and keys can be provided as file paths, inline PEM, or (for keys) a SecretRef.
+type TLSConfig struct {
+	// Enabled toggles the use of TLS.
+	Enabled bool `json:"enabled,omitempty" yaml:"enabled,omitempty" mapstructure:"enabled,omitempty"`
+
+	// CAFile is a path to a PEM encoded bundle of certificate authorities to trust.
+	// If neither CAFile nor CA are set, the system roots are used.
+	CAFile string `json:"ca_file,omitempty" yaml:"ca_file,omitempty" mapstructure:"ca_file,omitempty"`
+
+	// CA is an inline PEM encoded bundle of certificate authorities to trust.
+	CA string `json:"ca,omitempty" yaml:"ca,omitempty" mapstructure:"ca,omitempty"`
+
+	// CertFile is a path to a PEM encoded certificate.
+	CertFile string `json:"cert_file,omitempty" yaml:"cert_file,omitempty" mapstructure:"cert_file,omitempty"`
+
+	// KeyFile is a path to the PEM encoded private key for CertFile.
+	KeyFile string `json:"key_file,omitempty" yaml:"key_file,omitempty" mapstructure:"key_file,omitempty"`
+
+	// Cert is an inline PEM encoded certificate.
+	Cert string `json:"cert,omitempty" yaml:"cert,omitempty" mapstructure:"cert,omitempty"`
+
+	// Key is a reference to the PEM encoded private key for Cert, e.g. "env:TLS_KEY".
+	Key SecretRef `json:"key,omitempty" yaml:"key,omitempty" mapstructure:"key,omitempty"`
+
+	// ServerName overrides the name used to verify the peer's certificate.
+	ServerName string `json:"server_name,omitempty" yaml:"server_name,omitempty" mapstructure:"server_name,omitempty"`
+
+	// InsecureSkipVerify disables verification of the peer's certificate chain.
+	InsecureSkipVerify bool `json:"insecure_skip_verify,omitempty" yaml:"insecure_skip_verify,omitempty" mapstructure:"insecure_skip_verify,omitempty"`
+
+	// MinVersion is the minimum TLS version to negotiate ("1.0" through "1.3"). Defaults to "1.2".
+	MinVersion string `json:"min_version,omitempty" yaml:"min_version,omitempty" mapstructure:"min_version,omitempty"`
+
+	// ClientAuth is the server's policy for client certificates: "none", "request", "require",
+	// "verify_if_given" or "require_and_verify".
+	ClientAuth string `json:"client_auth,omitempty" yaml:"client_auth,omitempty" mapstructure:"client_auth,omitempty"`
+
+	// ReloadInterval is how often CertFile and KeyFile are checked for changes. When set,
+	// rotated certificates are picked up for new handshakes without a restart. Zero disables rotation.
+	ReloadInterval time.Duration `json:"reload_interval,omitempty" yaml:"reload_interval,omitempty" mapstructure:"reload_interval,omitempty"`
+}
+
+// Validate checks the TLS configuration for obvious mistakes.
+func (t TLSConfig) Validate() error {
+	if !t.Enabled {
+		return nil
+	}
+	if (t.CertFile == "") != (t.KeyFile == "") {
+		return errors.New("tls cert_file and key_file must be set together")
+	}
+	if (t.Cert == "") != t.Key.IsZero() {
+		return errors.New("tls cert and key must be set together")
+	}
+	if t.CertFile != "" && t.Cert != "" {
+		return errors.New("tls cert_file and cert are mutually exclusive")
+	}
+	if t.CAFile != "" && t.CA != "" {
+		return errors.New("tls ca_file and ca are mutually exclusive")
+	}
+	if t.MinVersion != "" {
+		if _, ok := _tlsVersions[t.MinVersion]; !ok {
+			return fmt.Errorf("tls min_version %s is not supported", t.MinVersion)
+		}
+	}
+	if _, ok := _tlsClientAuth[t.ClientAuth]; !ok {
+		return fmt.Errorf("tls client_auth %s is not supported", t.ClientAuth)
+	}
+	if t.ReloadInterval < 0 {
+		return errors.New("tls reload_interval must not be negative")
+	}
+	return nil
+}
+
+func (t TLSConfig) hasKeyPair() bool {
+	return t.CertFile != "" || t.Cert != ""
+}
+
+// ClientConfig builds a *tls.Config suitable for dialing servers.
+// It returns nil if TLS is not enabled.
+func (t TLSConfig) ClientConfig() (*tls.Config, error) {
+	ret, err := t.baseConfig()
+	if ret == nil || err != nil {
+		return nil, err
+	}
+
+	if t.CAFile != "" || t.CA != "" {
+		pool, err := t.certPool()
+		if err != nil {
+			return nil, err
+		}
+		ret.RootCAs = pool
+	}
+
+	if t.hasKeyPair() {
+		kp, err := t.keyPairSource()
+		if err != nil {
+			return nil, err
+		}
+		ret.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return kp.get()
+		}
+	}
+
+	return ret, nil
+}
+
+// ServerConfig builds a *tls.Config suitable for serving connections. A certificate is required.
+// It returns nil if TLS is not enabled.
+func (t TLSConfig) ServerConfig() (*tls.Config, error) {
+	ret, err := t.baseConfig()
+	if ret == nil || err != nil {
+		return nil, err
+	}
+
+	if !t.hasKeyPair() {
+		return nil, errors.New("tls server configuration requires a certificate and key")
+	}
+
+	kp, err := t.keyPairSource()
+	if err != nil {
+		return nil, err
+	}
+	ret.GetCertificate = func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+		return kp.get()
+	}
+
+	ret.ClientAuth = _tlsClientAuth[t.ClientAuth]
+	if t.CAFile != "" || t.CA != "" {
+		pool, err := t.certPool()
+		if err != nil {
+			return nil, err
+		}
+		ret.ClientCAs = pool
+	}
+
+	return ret, nil
+}
+
+func (t TLSConfig) baseConfig() (*tls.Config, error) {
+	if !t.Enabled {
+		return nil, nil
+	}
+	if err := t.Validate(); err != nil {
+		return nil, err
+	}
+
+	ret := &tls.Config{
+		ServerName:         t.ServerName,
+		InsecureSkipVerify: t.InsecureSkipVerify,
+		MinVersion:         tls.VersionTLS12,
+	}
+	if t.MinVersion != "" {
+		ret.MinVersion = _tlsVersions[t.MinVersion]
+	}
+
+	return ret, nil
+}
+
+func (t TLSConfig) certPool() (*x509.CertPool, error) {
+	if t.CA != "" {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM([]byte(t.CA)) {
+			return nil, errors.New("tls ca did not contain any PEM certificates")
+		}
+		return pool, nil
+	}
+	return loadCertPool(t.CAFile)
+}
+
+// keyPairSource loads the configured key pair, wrapping file based material in a
+// reloader when rotation is enabled.
+func (t TLSConfig) keyPairSource() (*certReloader, error) {
+	if t.Cert != "" {
+		key, err := t.Key.Resolve(context.Background())
+		if err != nil {
+			return nil, err
+		}
+		cert, err := tls.X509KeyPair([]byte(t.Cert), []byte(key))
+		if err != nil {
+			return nil, fmt.Errorf("could not parse inline tls key pair: %v", err)
+		}
+		return &certReloader{cert: &cert}, nil
+	}
+
+	r := &certReloader{
+		certFile: t.CertFile,
+		keyFile:  t.KeyFile,
+		interval: t.ReloadInterval,
+	}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func loadCertPool(path string) (*x509.CertPool, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read tls ca file %s: %v", path, err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("tls ca file %s did not contain any PEM certificates", path)
+	}
+
+	return pool, nil
+}
+
+// certReloader serves a key pair, re-reading it from disk when the files change.
+type certReloader struct {
+	sync.Mutex
+
+	certFile  string
+	keyFile   string
+	interval  time.Duration
+	cert      *tls.Certificate
+	certMod   time.Time
+	keyMod    time.Time
+	lastCheck time.Time
+}
+
+func (r *certReloader) get() (*tls.Certificate, error) {
+	r.Lock()
+	defer r.Unlock()
+
+	if r.interval <= 0 || r.certFile == "" || time.Since(r.lastCheck) < r.interval {
+		return r.cert, nil
+	}
+	r.lastCheck = time.Now()
+
+	certMod, keyMod, err := r.modTimes()
+	if err != nil || (certMod.Equal(r.certMod) && keyMod.Equal(r.keyMod)) {
+		// keep serving the current certificate if the files are unreadable or unchanged
+		return r.cert, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		// the pair may be mid-rotation; try again on the next interval
+		return r.cert, nil
+	}
+	r.cert = &cert
+	r.certMod = certMod
+	r.keyMod = keyMod
+
+	return r.cert, nil
+}
+
+func (r *certReloader) reload() error {
+	certMod, keyMod, err := r.modTimes()
+	if err != nil {
+		return err
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("could not load tls key pair %s: %v", r.certFile, err)
+	}
+
+	r.cert = &cert
+	r.certMod = certMod
+	r.keyMod = keyMod
+	r.lastCheck = time.Now()
+
+	return nil
+}
+
+func (r *certReloader) modTimes() (time.Time, time.Time, error) {
+	cs, err := os.Stat(r.certFile)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("could not stat tls cert file %s: %v", r.certFile, err)
+	}
+	ks, err := os.Stat(r.keyFile)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("could not stat tls key file %s: %v", r.keyFile, err)
+	}
+	return cs.ModTime(), ks.ModTime(), nil
+}
+
+// ProvideTLSConfig registers the TLS section at key for startup validation and provides
+// a server side *tls.Config named after key. The provided value is nil when TLS is disabled.
+func ProvideTLSConfig(key string) fx.Option {
+	defaults := TLSConfig{}
+	spec := Section(key, &defaults)
+
+	return fx.Options(
+		ProvideSection(key, &defaults),
+		fx.Provide(fx.Annotated{
+			Name: key,
+			Target: func(c Container) (*tls.Config, error) {
+				v, err := spec.Populate(c)
+				if err != nil {
+					return nil, SectionError{Key: key, Err: err}
+				}
+
+				cfg, err := v.(*TLSConfig).ServerConfig()
+				if err != nil {
+					return nil, SectionError{Key: key, Err: err}
+				}
+
+				return cfg, nil
+			},
+		}),
+	)
+}
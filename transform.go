@@ -0,0 +1,208 @@
+package cfx
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// TreeTransformer receives the merged configuration tree on every load and returns the tree to
+// use, or an error to reject the configuration. Transformers run in the order they were
+// registered, after rollouts and expressions are resolved.
+type TreeTransformer interface {
+	Transform(ctx context.Context, env EnvContext, tree map[string]interface{}) (map[string]interface{}, error)
+}
+
+// TreeTransformerFunc is an adapter to allow the use of ordinary functions as a TreeTransformer.
+type TreeTransformerFunc func(ctx context.Context, env EnvContext, tree map[string]interface{}) (map[string]interface{}, error)
+
+// Transform implements the TreeTransformer interface.
+func (f TreeTransformerFunc) Transform(ctx context.Context, env EnvContext, tree map[string]interface{}) (map[string]interface{}, error) {
+	return f(ctx, env, tree)
+}
+
+// WithTransformer registers a TreeTransformer that every loaded configuration passes through.
+func WithTransformer(t TreeTransformer) Option {
+	return func(o *options) {
+		if t != nil {
+			o.transformers = append(o.transformers, t)
+		}
+	}
+}
+
+// applyTransformers runs the registered transformers over the tree. It returns the resulting
+// tree and whether any transformer changed it.
+func applyTransformers(env EnvContext, opts *options, tree map[string]interface{}) (map[string]interface{}, bool, error) {
+	if len(opts.transformers) == 0 {
+		return tree, false, nil
+	}
+
+	before, err := fingerprintTree(tree)
+	if err != nil {
+		return nil, false, err
+	}
+
+	cur := tree
+	for _, t := range opts.transformers {
+		next, err := t.Transform(context.Background(), env, cur)
+		if err != nil {
+			return nil, false, fmt.Errorf("configuration was rejected by a transformer: %v", err)
+		}
+		if next != nil {
+			cur = next
+		}
+	}
+
+	after, err := fingerprintTree(cur)
+	if err != nil {
+		return nil, false, err
+	}
+	return cur, before != after, nil
+}
+
+// WASMRuntime instantiates WebAssembly modules. cfx does not embed a WebAssembly runtime;
+// applications adapt the runtime of their choice, such as wazero, to this interface.
+type WASMRuntime interface {
+	Instantiate(ctx context.Context, name string, code []byte) (WASMInstance, error)
+}
+
+// WASMInstance is an instantiated WebAssembly module.
+type WASMInstance interface {
+	// Call invokes an exported function.
+	Call(ctx context.Context, fn string, params ...uint64) ([]uint64, error)
+
+	// ReadMemory returns length bytes of linear memory at offset.
+	ReadMemory(offset, length uint32) ([]byte, bool)
+
+	// WriteMemory writes data to linear memory at offset.
+	WriteMemory(offset uint32, data []byte) bool
+
+	// Close releases the instance.
+	Close(ctx context.Context) error
+}
+
+// WASM transformer ABI. A module exports:
+//
+//	cfx_alloc(size i32) i32                   allocates size bytes and returns their offset
+//	cfx_transform(ptr i32, len i32) i64       transforms the request at ptr
+//
+// The request is the JSON document {"env": <EnvContext>, "config": <tree>}. cfx_transform
+// returns the offset of its JSON response in the upper 32 bits and its length in the lower 32
+// bits. The response is {"config": <tree>} to replace the tree, {} to keep it unchanged, or
+// {"error": "...", "violations": ["..."]} to reject it. Policy engines compiled to WebAssembly,
+// such as OPA, are wrapped in a module implementing this ABI.
+const (
+	WASMAllocFunc     = "cfx_alloc"
+	WASMTransformFunc = "cfx_transform"
+)
+
+type wasmRequest struct {
+	Env    EnvContext             `json:"env"`
+	Config map[string]interface{} `json:"config"`
+}
+
+type wasmResponse struct {
+	Config     map[string]interface{} `json:"config,omitempty"`
+	Error      string                 `json:"error,omitempty"`
+	Violations []string               `json:"violations,omitempty"`
+}
+
+// wasmTransformer runs a WebAssembly module implementing the transformer ABI.
+type wasmTransformer struct {
+	rt   WASMRuntime
+	name string
+	code []byte
+}
+
+// NewWASMTransformer creates a TreeTransformer running the WebAssembly module code with rt.
+// A fresh instance is used for every load so modules cannot keep state between loads.
+func NewWASMTransformer(rt WASMRuntime, name string, code []byte) TreeTransformer {
+	return &wasmTransformer{rt: rt, name: name, code: code}
+}
+
+// Transform implements the TreeTransformer interface.
+func (w *wasmTransformer) Transform(ctx context.Context, env EnvContext, tree map[string]interface{}) (map[string]interface{}, error) {
+	req, err := json.Marshal(wasmRequest{Env: env, Config: tree})
+	if err != nil {
+		return nil, fmt.Errorf("wasm module %s: could not encode request: %v", w.name, err)
+	}
+
+	inst, err := w.rt.Instantiate(ctx, w.name, w.code)
+	if err != nil {
+		return nil, fmt.Errorf("wasm module %s: could not instantiate: %v", w.name, err)
+	}
+	defer inst.Close(ctx)
+
+	res, err := inst.Call(ctx, WASMAllocFunc, uint64(len(req)))
+	if err != nil || len(res) != 1 {
+		return nil, fmt.Errorf("wasm module %s: %s failed: %v", w.name, WASMAllocFunc, err)
+	}
+	ptr := uint32(res[0])
+	if !inst.WriteMemory(ptr, req) {
+		return nil, fmt.Errorf("wasm module %s: request does not fit in memory", w.name)
+	}
+
+	res, err = inst.Call(ctx, WASMTransformFunc, uint64(ptr), uint64(len(req)))
+	if err != nil || len(res) != 1 {
+		return nil, fmt.Errorf("wasm module %s: %s failed: %v", w.name, WASMTransformFunc, err)
+	}
+	out, ok := inst.ReadMemory(uint32(res[0]>>32), uint32(res[0]))
+	if !ok {
+		return nil, fmt.Errorf("wasm module %s: response is out of bounds", w.name)
+	}
+
+	var resp wasmResponse
+	if err := json.Unmarshal(out, &resp); err != nil {
+		return nil, fmt.Errorf("wasm module %s: could not decode response: %v", w.name, err)
+	}
+	if resp.Error != "" || len(resp.Violations) > 0 {
+		msgs := resp.Violations
+		if resp.Error != "" {
+			msgs = append([]string{resp.Error}, msgs...)
+		}
+		return nil, fmt.Errorf("wasm module %s: %s", w.name, strings.Join(msgs, "; "))
+	}
+	if resp.Config == nil {
+		return tree, nil
+	}
+	return resp.Config, nil
+}
+
+// WithWASMTransformers loads every .wasm file in dir, in name order, as a TreeTransformer run
+// with rt. A relative dir is resolved against the ConfigPath.
+func WithWASMTransformers(rt WASMRuntime, dir string) Option {
+	return func(o *options) {
+		o.wasmRuntime = rt
+		o.wasmDir = dir
+	}
+}
+
+// loadWASMTransformers reads the modules configured with WithWASMTransformers.
+func loadWASMTransformers(env EnvContext, opts *options) error {
+	if opts.wasmRuntime == nil || opts.wasmDir == "" {
+		return nil
+	}
+
+	dir := opts.wasmDir
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(env.ConfigPath, dir)
+	}
+	paths, err := filepath.Glob(filepath.Join(dir, "*.wasm"))
+	if err != nil {
+		return fmt.Errorf("could not list wasm modules in %s: %v", dir, err)
+	}
+	sort.Strings(paths)
+
+	for _, p := range paths {
+		code, err := ioutil.ReadFile(p)
+		if err != nil {
+			return fmt.Errorf("could not read wasm module %s: %v", p, err)
+		}
+		opts.transformers = append(opts.transformers, NewWASMTransformer(opts.wasmRuntime, filepath.Base(p), code))
+	}
+	return nil
+}
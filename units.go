@@ -0,0 +1,55 @@
+package cfx
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var _byteUnits = []struct {
+	suffix string
+	mult   int64
+}{
+	{"kib", 1 << 10},
+	{"mib", 1 << 20},
+	{"gib", 1 << 30},
+	{"tib", 1 << 40},
+	{"kb", 1000},
+	{"mb", 1000 * 1000},
+	{"gb", 1000 * 1000 * 1000},
+	{"tb", 1000 * 1000 * 1000 * 1000},
+	{"k", 1 << 10},
+	{"m", 1 << 20},
+	{"g", 1 << 30},
+	{"t", 1 << 40},
+	{"b", 1},
+}
+
+// ParseByteSize parses a human readable size such as "512MiB", "1GB" or "4096" into bytes.
+// Binary (KiB, MiB, ...) and decimal (KB, MB, ...) suffixes are supported; single letter
+// suffixes (K, M, G, T) are treated as binary.
+func ParseByteSize(v string) (int64, error) {
+	s := strings.ToLower(strings.TrimSpace(v))
+	if s == "" {
+		return 0, fmt.Errorf("size must not be empty")
+	}
+
+	mult := int64(1)
+	for _, u := range _byteUnits {
+		if strings.HasSuffix(s, u.suffix) {
+			mult = u.mult
+			s = strings.TrimSpace(strings.TrimSuffix(s, u.suffix))
+			break
+		}
+	}
+
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, fmt.Errorf("size %q is invalid: %v", v, err)
+	}
+	if n < 0 {
+		return 0, fmt.Errorf("size %q must not be negative", v)
+	}
+
+	return int64(n * float64(mult)), nil
+}
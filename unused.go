@@ -0,0 +1,162 @@
+package cfx
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// _conventionalEnvironments are always treated as environments by FindUnusedConfigFiles, so
+// that a directory without an environments manifest is not flagged wholesale.
+var _conventionalEnvironments = []string{"development", "test", "staging", "production"}
+
+// UnusedFile is a YAML file in ConfigPath that no known environment merges.
+type UnusedFile struct {
+	Path string `json:"path" yaml:"path"`
+
+	// Suggestion is the config file of a known environment with a similar name, set when the
+	// file looks like a typo of it, e.g. production.yaml for produciton.yaml.
+	Suggestion string `json:"suggestion,omitempty" yaml:"suggestion,omitempty"`
+}
+
+// String implements the fmt.Stringer interface.
+func (u UnusedFile) String() string {
+	if u.Suggestion != "" {
+		return fmt.Sprintf("config file %s is not used by any environment, did you mean %s?", u.Path, u.Suggestion)
+	}
+	return fmt.Sprintf("config file %s is not used by any environment", u.Path)
+}
+
+// WithKnownEnvironments declares the environments an application is deployed to, in addition
+// to those in the environments manifest. Config files of other environments are reported as
+// unused in the LoadReport.
+func WithKnownEnvironments(names ...string) Option {
+	return func(o *options) {
+		o.knownEnvironments = append(o.knownEnvironments, names...)
+	}
+}
+
+// FindUnusedConfigFiles returns the YAML files in configDir that are not merged by any known
+// environment: base.yaml, the environments manifest, and the config files of development,
+// test, staging, production, every environment in the manifest, their parents, and the
+// environments given are in use. Anything else is an orphaned overlay or a typo'd filename
+// that is silently ignored at runtime.
+func FindUnusedConfigFiles(configDir string, environments ...string) ([]UnusedFile, error) {
+	manifest, err := readEnvironmentsManifest(configDir)
+	if err != nil {
+		return nil, err
+	}
+	return findUnusedConfigFiles(configDir, DefaultNaming, environments, manifest)
+}
+
+func findUnusedConfigFiles(configDir string, naming NamingStrategy, environments []string, manifest *EnvironmentsManifest) ([]UnusedFile, error) {
+	found, err := naming.Names(configDir)
+	if err != nil {
+		return nil, err
+	}
+
+	known := map[string]bool{}
+	names := append(append([]string{}, _conventionalEnvironments...), environments...)
+	if manifest != nil {
+		for name := range manifest.Environments {
+			names = append(names, name)
+		}
+	}
+	for _, name := range names {
+		chain, err := manifest.Chain(name)
+		if err != nil {
+			return nil, err
+		}
+		for _, n := range chain {
+			known[strings.ToLower(n)] = true
+		}
+	}
+
+	var ret []UnusedFile
+	for _, name := range found {
+		key := strings.ToLower(name)
+		if key == _defaultConfigName || key == EnvironmentsManifestName || known[key] {
+			continue
+		}
+		if i := strings.Index(key, "."); i > 0 && known[key[:i]] {
+			// a refinement such as production.us-east-1, merged by deployments in that region
+			continue
+		}
+		paths, err := naming.Resolve(configDir, name)
+		if err != nil {
+			continue
+		}
+		match := closestName(key, known)
+		for _, path := range paths {
+			u := UnusedFile{Path: path}
+			if i := strings.LastIndex(path, name); match != "" && i >= len(configDir) {
+				u.Suggestion = path[:i] + match + path[i+len(name):]
+			}
+			ret = append(ret, u)
+		}
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Path < ret[j].Path })
+	return ret, nil
+}
+
+// unusedConfigFiles finds the unused files for the report of a container loading env, treating
+// env itself as known. Failures only cost the report its unused files, so they are ignored.
+func unusedConfigFiles(env EnvContext, opts *options) []UnusedFile {
+	names := append([]string{env.Environment.String()}, opts.knownEnvironments...)
+	roots := env.configRoots()
+	manifest, err := readSearchPathManifest(roots)
+	if err != nil {
+		return nil
+	}
+	var ret []UnusedFile
+	for _, root := range roots {
+		unused, _ := findUnusedConfigFiles(root, opts.naming, names, manifest)
+		ret = append(ret, unused...)
+	}
+	return ret
+}
+
+// closestName returns the name in candidates within two edits of name, preferring the closest.
+func closestName(name string, candidates map[string]bool) string {
+	best, bestDist := "", 3
+	for c := range candidates {
+		if d := editDistance(name, c); d < bestDist || (d == bestDist && c < best) {
+			best, bestDist = c, d
+		}
+	}
+	return best
+}
+
+// editDistance is the Damerau-Levenshtein distance between a and b, counting a transposition
+// of adjacent letters as one edit.
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	d := make([][]int, len(ra)+1)
+	for i := range d {
+		d[i] = make([]int, len(rb)+1)
+		d[i][0] = i
+	}
+	for j := range d[0] {
+		d[0][j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			d[i][j] = minInt(d[i-1][j]+1, minInt(d[i][j-1]+1, d[i-1][j-1]+cost))
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] {
+				d[i][j] = minInt(d[i][j], d[i-2][j-2]+1)
+			}
+		}
+	}
+	return d[len(ra)][len(rb)]
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,157 @@
+package cfx
+
+import (
+	"fmt"
+	"strings"
+)
+
+// EnvironmentValidation is the outcome of validating a single environment with ValidateAll.
+type EnvironmentValidation struct {
+	Environment EnvID `json:"environment" yaml:"environment"`
+
+	// Sources are the files merged for the environment, in order.
+	Sources []string `json:"sources,omitempty" yaml:"sources,omitempty"`
+
+	// Err is set when the environment failed to load or validate. A ValidationErrors lists
+	// every invalid section.
+	Err error `json:"-" yaml:"-"`
+
+	// Error is the message of Err, for JSON and YAML output.
+	Error string `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+// ValidateAllReport holds the outcome of ValidateAll for every environment, sorted by name.
+type ValidateAllReport struct {
+	ConfigDir    string                  `json:"config_dir" yaml:"config_dir"`
+	Environments []EnvironmentValidation `json:"environments" yaml:"environments"`
+}
+
+// OK reports whether every environment loaded and validated.
+func (r ValidateAllReport) OK() bool {
+	for _, e := range r.Environments {
+		if e.Err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// Failed returns the environments that failed to load or validate.
+func (r ValidateAllReport) Failed() []EnvironmentValidation {
+	var ret []EnvironmentValidation
+	for _, e := range r.Environments {
+		if e.Err != nil {
+			ret = append(ret, e)
+		}
+	}
+	return ret
+}
+
+// String implements the fmt.Stringer interface, listing one environment per line.
+func (r ValidateAllReport) String() string {
+	var sb strings.Builder
+	for _, e := range r.Environments {
+		if e.Err != nil {
+			fmt.Fprintf(&sb, "FAIL  %s: %v\n", e.Environment, e.Err)
+			continue
+		}
+		fmt.Fprintf(&sb, "ok    %s\n", e.Environment)
+	}
+	return sb.String()
+}
+
+// ValidateAll loads every environment that has a config file in configDir, merged the way it
+// would be at runtime, and validates the given sections against each. Every YAML file other
+// than base.yaml and the environments manifest is an environment, except files that are only
+// parents in the manifest and not valid environment names themselves. It is the building block
+// for a pre-merge CI gate:
+//
+//	func TestConfig(t *testing.T) {
+//		report, err := cfx.ValidateAll("config", cfx.Section("db", &DBConfig{}))
+//		if err != nil {
+//			t.Fatal(err)
+//		}
+//		for _, e := range report.Failed() {
+//			t.Errorf("%s: %v", e.Environment, e.Err)
+//		}
+//	}
+//
+// ${VAR} references are expanded from the environment of the calling process. An error is only
+// returned when configDir cannot be read; failures of individual environments are in the report.
+func ValidateAll(configDir string, sections ...SectionSpec) (*ValidateAllReport, error) {
+	return ValidateAllWithOptions(configDir, sections)
+}
+
+// ValidateAllWithOptions is like ValidateAll, loading every environment with opts applied, for
+// example a NamingStrategy given with WithNamingStrategy.
+func ValidateAllWithOptions(configDir string, sections []SectionSpec, opts ...Option) (*ValidateAllReport, error) {
+	naming := newOptions(opts).naming
+	names, err := environmentFiles(configDir, naming)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &ValidateAllReport{ConfigDir: configDir}
+	for _, name := range names {
+		res := EnvironmentValidation{Environment: EnvID(name)}
+		res.Sources, res.Err = validateEnvironment(configDir, name, sections, naming, opts)
+		if res.Err != nil {
+			res.Error = res.Err.Error()
+		}
+		report.Environments = append(report.Environments, res)
+	}
+	return report, nil
+}
+
+func validateEnvironment(configDir, name string, sections []SectionSpec, naming NamingStrategy, opts []Option) ([]string, error) {
+	env, err := ParseEnv(name)
+	if err != nil {
+		return nil, err
+	}
+	ctx := EnvContext{Environment: env, ConfigPath: configDir}
+	paths, err := discoverConfigFiles(ctx, naming)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := NewConfigWithOptions(ctx, opts...)
+	if err != nil {
+		return paths, err
+	}
+	return paths, ValidateSections(c, sections...)
+}
+
+// environmentFiles returns the names of the environments with configuration in configDir.
+func environmentFiles(configDir string, naming NamingStrategy) ([]string, error) {
+	found, err := naming.Names(configDir)
+	if err != nil {
+		return nil, err
+	}
+	manifest, err := readEnvironmentsManifest(configDir)
+	if err != nil {
+		return nil, err
+	}
+	parents := map[string]bool{}
+	if manifest != nil {
+		for _, spec := range manifest.Environments {
+			if spec.Parent != "" {
+				parents[strings.ToLower(spec.Parent)] = true
+			}
+		}
+	}
+
+	names := []string{}
+	for _, name := range found {
+		key := strings.ToLower(name)
+		if key == _defaultConfigName || key == EnvironmentsManifestName || strings.Contains(key, ".") {
+			// refinements such as production.us-east-1 are not environments
+			continue
+		}
+		if _, err := ParseEnv(name); err != nil && parents[key] {
+			// an abstract parent such as production-like.yaml
+			continue
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
@@ -0,0 +1,156 @@
+package cfx
+
+import (
+	"fmt"
+	"sort"
+)
+
+// VarOption customizes an environment variable declared with DefineVar.
+type VarOption func(*envVarInfo)
+
+// WithDefault sets the value used when the variable is not set.
+func WithDefault(v string) VarOption {
+	return func(i *envVarInfo) {
+		i.Default = v
+	}
+}
+
+// WithDescription sets the description shown in the environment variable manifest.
+func WithDescription(d string) VarOption {
+	return func(i *envVarInfo) {
+		i.Description = d
+	}
+}
+
+// WithSecret marks the variable as holding sensitive material.
+func WithSecret() VarOption {
+	return func(i *envVarInfo) {
+		i.Secret = true
+	}
+}
+
+// WithRequired causes NewEnvContext to fail if the variable is not set and has no default.
+func WithRequired() VarOption {
+	return func(i *envVarInfo) {
+		i.Required = true
+	}
+}
+
+// WithValidator sets a function used to validate the variable's value when the EnvContext is created.
+func WithValidator(fn func(string) error) VarOption {
+	return func(i *envVarInfo) {
+		i.Validate = fn
+	}
+}
+
+// DefineVar declares an application specific environment variable. The name follows the same
+// rules as an EnvKeyPrefix and is read with the application's prefix, so DefineVar("FEATURE_X")
+// is read from FOO_FEATURE_X when the prefix is FOO. Declared variables are listed in the
+// EnvManifest and their values are available through EnvContext.Vars.
+func DefineVar(name string, opts ...VarOption) (EnvVar, error) {
+	if _, err := ParseEnvKeyPrefix(name); err != nil || name == "" {
+		return "", fmt.Errorf("env var name %q is invalid: must be UPPERCASE alpha numeric and may contain '_' characters, but not start or end with one", name)
+	}
+
+	info := envVarInfo{
+		Var:    EnvVar(name),
+		Origin: OriginApp,
+	}
+	for _, opt := range opts {
+		if opt != nil {
+			opt(&info)
+		}
+	}
+
+	if info.Default != "" && info.Validate != nil {
+		if err := info.Validate(info.Default); err != nil {
+			return "", fmt.Errorf("default value of env var %s is invalid: %v", name, err)
+		}
+	}
+
+	registerEnvVar(info)
+	return info.Var, nil
+}
+
+// MustDefineVar is like DefineVar but panics if the declaration is invalid.
+// It is intended for package level variable declarations.
+func MustDefineVar(name string, opts ...VarOption) EnvVar {
+	v, err := DefineVar(name, opts...)
+	if err != nil {
+		panic(err)
+	}
+	return v
+}
+
+// Vars holds the values of the application defined environment variables, captured when
+// the EnvContext was created. Values are never serialized, as they may be secret.
+type Vars struct {
+	values map[EnvVar]string
+	set    map[EnvVar]bool
+}
+
+// Get returns the value of the variable, or its default if it was not set.
+func (v Vars) Get(key EnvVar) string {
+	return v.values[key]
+}
+
+// Lookup returns the value of the variable and whether it was explicitly set in the environment.
+func (v Vars) Lookup(key EnvVar) (string, bool) {
+	val, ok := v.values[key]
+	return val, ok && v.set[key]
+}
+
+// Keys returns the names of every captured variable, sorted.
+func (v Vars) Keys() []EnvVar {
+	ret := make([]EnvVar, 0, len(v.values))
+	for k := range v.values {
+		ret = append(ret, k)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i] < ret[j]
+	})
+	return ret
+}
+
+// loadVars captures and validates every application defined variable.
+func loadVars(prefix EnvKeyPrefix, opts *options) (Vars, error) {
+	ret := Vars{
+		values: map[EnvVar]string{},
+		set:    map[EnvVar]bool{},
+	}
+
+	envRegistryMu.RLock()
+	defer envRegistryMu.RUnlock()
+
+	for _, info := range envRegistry {
+		if info.Origin != OriginApp {
+			continue
+		}
+
+		val, set := info.Var.Lookup(prefix)
+		if !set && info.Required && info.Default == "" {
+			v, ok, err := opts.prompter.ask(info.Var.Key(prefix), info.Description, info.Secret)
+			if err != nil {
+				return ret, err
+			}
+			val, set = v, ok
+		}
+		if !set {
+			if info.Required && info.Default == "" {
+				return ret, fmt.Errorf("%s must be set", info.Var.Key(prefix))
+			}
+			val = info.Default
+		}
+
+		if set && info.Validate != nil {
+			if err := info.Validate(val); err != nil {
+				return ret, fmt.Errorf("%s is invalid: %v", info.Var.Key(prefix), err)
+			}
+		}
+
+		ret.values[info.Var] = val
+		ret.set[info.Var] = set
+	}
+
+	return ret, nil
+}
@@ -2,5 +2,5 @@ package cfx
 
 const (
 	// Version defines the current version of the cfx package.
-	Version = "0.0.4"
+	Version = "0.1.0"
 )
@@ -0,0 +1,82 @@
+package cfx
+
+import "sync"
+
+// Watchable is a goroutine safe handle to the value under a configuration key. Long lived
+// components keep a Watchable and read it on use, or wait on Changed to react to reloads.
+type Watchable struct {
+	c   *yamlContainer
+	key string
+
+	mu      sync.Mutex
+	changed chan struct{}
+}
+
+// Value implements the cfgfx.Container interface.
+func (y *yamlContainer) Value(key string) *Watchable {
+	y.Lock()
+	defer y.Unlock()
+
+	if w, ok := y.watchables[key]; ok {
+		return w
+	}
+	if y.watchables == nil {
+		y.watchables = map[string]*Watchable{}
+	}
+
+	w := &Watchable{c: y, key: key, changed: make(chan struct{})}
+	y.watchables[key] = w
+	return w
+}
+
+// Key returns the configuration key the handle watches.
+func (w *Watchable) Key() string {
+	return w.key
+}
+
+// Get returns the current value under the key as decoded from YAML: a map, list or scalar.
+// It returns nil if the key is not set.
+func (w *Watchable) Get() interface{} {
+	var v interface{}
+	if err := w.Populate(&v); err != nil {
+		return nil
+	}
+	return v
+}
+
+// Populate populates target with the current value under the key.
+func (w *Watchable) Populate(target interface{}) error {
+	return w.c.Populate(w.key, target)
+}
+
+// Changed returns a channel that is closed the next time a reload changes anything under the
+// key. Call Changed again after it fires to wait for the following change.
+func (w *Watchable) Changed() <-chan struct{} {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.changed
+}
+
+// notify wakes everyone waiting on the current Changed channel.
+func (w *Watchable) notify() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	close(w.changed)
+	w.changed = make(chan struct{})
+}
+
+// notifyWatchables signals every Watchable whose key was touched by the change set.
+func (y *yamlContainer) notifyWatchables(changes []Change) {
+	y.RLock()
+	watchables := make([]*Watchable, 0, len(y.watchables))
+	for _, w := range y.watchables {
+		watchables = append(watchables, w)
+	}
+	y.RUnlock()
+
+	for _, w := range watchables {
+		if len(sectionChanges(w.key, changes)) > 0 {
+			w.notify()
+		}
+	}
+}
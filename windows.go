@@ -0,0 +1,102 @@
+package cfx
+
+import (
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// WindowsContext describes how the process runs on Windows. It is empty on other platforms.
+type WindowsContext struct {
+	// Service is set when the process was started by the service control manager.
+	Service bool `json:"service,omitempty" yaml:"service,omitempty" mapstructure:"service,omitempty"`
+
+	// ServiceName is the name of the service running the process.
+	ServiceName string `json:"service_name,omitempty" yaml:"service_name,omitempty" mapstructure:"service_name,omitempty"`
+
+	// SessionID is the Remote Desktop Services session of the process. Services run in session 0.
+	SessionID int `json:"session_id,omitempty" yaml:"session_id,omitempty" mapstructure:"session_id,omitempty"`
+}
+
+// DetectWindows reports whether the process runs as a Windows service, and in which session.
+// It returns an empty WindowsContext on other platforms.
+func DetectWindows() WindowsContext {
+	return detectWindows()
+}
+
+// WithRegistryKey layers the values of a Windows registry key over the configuration files,
+// for fleets that manage settings with Group Policy or other registry tooling:
+//
+//	cfx.WithRegistryKey(`HKLM\SOFTWARE\Acme\Billing`)
+//
+// The key may start with HKLM, HKCU, HKEY_LOCAL_MACHINE or HKEY_CURRENT_USER. Value names are
+// configuration keys, so a value named "database.host" overrides database.host, and subkeys
+// nest like maps. String values are taken as is and are not expanded, DWORD and QWORD values
+// become integers, and multi-string values become lists. Registry keys are layered in the order
+// they are registered, after the configuration files and plugins. A missing key is ignored.
+//
+// Registry keys are read again on every reload, but changes to them do not trigger hot reload.
+// On other platforms the option has no effect, so the same options can be used fleet wide.
+func WithRegistryKey(key string) Option {
+	return func(o *options) {
+		o.registryKeys = append(o.registryKeys, key)
+	}
+}
+
+// registrySources reads the registry keys registered with WithRegistryKey as configuration
+// layers.
+func registrySources(opts *options) ([]configSource, error) {
+	var ret []configSource
+	for _, key := range opts.registryKeys {
+		tree, err := readRegistryTree(key)
+		if err != nil {
+			return nil, fmt.Errorf("could not read registry key %s: %v", key, err)
+		}
+		if len(tree) == 0 {
+			continue
+		}
+
+		data, err := yaml.Marshal(tree)
+		if err != nil {
+			return nil, fmt.Errorf("could not encode registry key %s: %v", key, err)
+		}
+		data = append([]byte("# "+NoExpandDirective+"\n"), data...)
+		ret = append(ret, configSource{name: "registry:" + key, data: data})
+	}
+	return ret, nil
+}
+
+// splitRegistryKey splits a registry path into its root key name and subkey.
+func splitRegistryKey(key string) (string, string, error) {
+	parts := strings.SplitN(strings.Trim(key, `\`), `\`, 2)
+	root := strings.ToUpper(parts[0])
+	switch root {
+	case "HKLM":
+		root = "HKEY_LOCAL_MACHINE"
+	case "HKCU":
+		root = "HKEY_CURRENT_USER"
+	case "HKEY_LOCAL_MACHINE", "HKEY_CURRENT_USER":
+	default:
+		return "", "", fmt.Errorf("unsupported registry root %s", parts[0])
+	}
+	if len(parts) == 1 || parts[1] == "" {
+		return "", "", fmt.Errorf("registry key %s has no subkey", key)
+	}
+	return root, parts[1], nil
+}
+
+// insertTree sets the value at the dotted key path, creating the maps along it.
+func insertTree(tree map[string]interface{}, key string, val interface{}) {
+	parts := strings.Split(key, ".")
+	m := tree
+	for _, part := range parts[:len(parts)-1] {
+		next, ok := m[part].(map[string]interface{})
+		if !ok {
+			next = map[string]interface{}{}
+			m[part] = next
+		}
+		m = next
+	}
+	m[parts[len(parts)-1]] = val
+}
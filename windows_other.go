@@ -0,0 +1,13 @@
+//go:build !windows
+// +build !windows
+
+package cfx
+
+func detectWindows() WindowsContext {
+	return WindowsContext{}
+}
+
+// readRegistryTree returns nothing on platforms without a registry.
+func readRegistryTree(string) (map[string]interface{}, error) {
+	return nil, nil
+}
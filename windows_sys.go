@@ -0,0 +1,242 @@
+//go:build windows
+// +build windows
+
+package cfx
+
+import (
+	"encoding/binary"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	_kernel32 = syscall.NewLazyDLL("kernel32.dll")
+	_advapi32 = syscall.NewLazyDLL("advapi32.dll")
+
+	_procProcessIdToSessionId = _kernel32.NewProc("ProcessIdToSessionId")
+	_procOpenSCManagerW       = _advapi32.NewProc("OpenSCManagerW")
+	_procEnumServicesStatusEx = _advapi32.NewProc("EnumServicesStatusExW")
+	_procCloseServiceHandle   = _advapi32.NewProc("CloseServiceHandle")
+	_procRegEnumValueW        = _advapi32.NewProc("RegEnumValueW")
+)
+
+const (
+	_scManagerEnumerateService = 0x0004
+	_scEnumProcessInfo         = 0
+	_serviceWin32              = 0x00000030
+	_serviceActive             = 0x00000001
+	_keyWow6464Key             = 0x0100
+	_errorMoreData             = syscall.Errno(234)
+	_errorNoMoreItems          = syscall.Errno(259)
+)
+
+// enumServiceStatusProcess mirrors ENUM_SERVICE_STATUS_PROCESSW.
+type enumServiceStatusProcess struct {
+	ServiceName *uint16
+	DisplayName *uint16
+
+	ServiceType             uint32
+	CurrentState            uint32
+	ControlsAccepted        uint32
+	Win32ExitCode           uint32
+	ServiceSpecificExitCode uint32
+	CheckPoint              uint32
+	WaitHint                uint32
+	ProcessID               uint32
+	ServiceFlags            uint32
+}
+
+func detectWindows() WindowsContext {
+	var w WindowsContext
+
+	var session uint32
+	if r, _, _ := _procProcessIdToSessionId.Call(uintptr(os.Getpid()), uintptr(unsafe.Pointer(&session))); r != 0 {
+		w.SessionID = int(session)
+	}
+
+	// services are only ever started in session 0
+	if session == 0 {
+		w.ServiceName = serviceForPID(uint32(os.Getpid()))
+		w.Service = w.ServiceName != ""
+	}
+	return w
+}
+
+// serviceForPID returns the name of the running service hosted by process pid, if any.
+func serviceForPID(pid uint32) string {
+	if _procOpenSCManagerW.Find() != nil || _procEnumServicesStatusEx.Find() != nil {
+		return ""
+	}
+	scm, _, _ := _procOpenSCManagerW.Call(0, 0, _scManagerEnumerateService)
+	if scm == 0 {
+		return ""
+	}
+	defer _procCloseServiceHandle.Call(scm)
+
+	var needed, count, resume uint32
+	buf := make([]byte, 64<<10)
+	for {
+		r, _, err := _procEnumServicesStatusEx.Call(scm, _scEnumProcessInfo, _serviceWin32, _serviceActive,
+			uintptr(unsafe.Pointer(&buf[0])), uintptr(len(buf)),
+			uintptr(unsafe.Pointer(&needed)), uintptr(unsafe.Pointer(&count)),
+			uintptr(unsafe.Pointer(&resume)), 0)
+		if r == 0 && err != _errorMoreData {
+			return ""
+		}
+
+		size := unsafe.Sizeof(enumServiceStatusProcess{})
+		for i := uintptr(0); i < uintptr(count); i++ {
+			s := (*enumServiceStatusProcess)(unsafe.Pointer(&buf[i*size]))
+			if s.ProcessID == pid {
+				return utf16PtrToString(s.ServiceName)
+			}
+		}
+
+		if r != 0 {
+			return ""
+		}
+		if int(needed) > len(buf) {
+			buf = make([]byte, needed)
+		}
+	}
+}
+
+// utf16PtrToString converts a NUL terminated UTF-16 string to a string.
+func utf16PtrToString(p *uint16) string {
+	if p == nil {
+		return ""
+	}
+	var s []uint16
+	for ptr := unsafe.Pointer(p); ; ptr = unsafe.Pointer(uintptr(ptr) + 2) {
+		c := *(*uint16)(ptr)
+		if c == 0 {
+			break
+		}
+		s = append(s, c)
+	}
+	return syscall.UTF16ToString(s)
+}
+
+func readRegistryTree(key string) (map[string]interface{}, error) {
+	root, sub, err := splitRegistryKey(key)
+	if err != nil {
+		return nil, err
+	}
+	hive := syscall.Handle(syscall.HKEY_LOCAL_MACHINE)
+	if root == "HKEY_CURRENT_USER" {
+		hive = syscall.Handle(syscall.HKEY_CURRENT_USER)
+	}
+
+	tree := map[string]interface{}{}
+	if err := readRegistryKey(hive, sub, tree); err != nil {
+		if err == syscall.ERROR_FILE_NOT_FOUND {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return tree, nil
+}
+
+// readRegistryKey adds the values of a key, and of its subkeys as nested maps, to tree.
+func readRegistryKey(parent syscall.Handle, sub string, tree map[string]interface{}) error {
+	name, err := syscall.UTF16PtrFromString(sub)
+	if err != nil {
+		return err
+	}
+	var k syscall.Handle
+	if err := syscall.RegOpenKeyEx(parent, name, 0, syscall.KEY_READ|_keyWow6464Key, &k); err != nil {
+		return err
+	}
+	defer syscall.RegCloseKey(k)
+
+	var subkeys, maxSubkeyLen, values, maxValueNameLen, maxValueLen uint32
+	if err := syscall.RegQueryInfoKey(k, nil, nil, nil, &subkeys, &maxSubkeyLen, nil, &values, &maxValueNameLen, &maxValueLen, nil, nil); err != nil {
+		return err
+	}
+
+	nameBuf := make([]uint16, maxValueNameLen+1)
+	data := make([]byte, maxValueLen+2)
+	for i := uint32(0); i < values; i++ {
+		nameLen := uint32(len(nameBuf))
+		dataLen := uint32(len(data))
+		var typ uint32
+		r, _, _ := _procRegEnumValueW.Call(uintptr(k), uintptr(i),
+			uintptr(unsafe.Pointer(&nameBuf[0])), uintptr(unsafe.Pointer(&nameLen)), 0,
+			uintptr(unsafe.Pointer(&typ)), uintptr(unsafe.Pointer(&data[0])), uintptr(unsafe.Pointer(&dataLen)))
+		if syscall.Errno(r) == _errorNoMoreItems {
+			break
+		}
+		if r != 0 {
+			return syscall.Errno(r)
+		}
+		valueName := syscall.UTF16ToString(nameBuf[:nameLen])
+		if valueName == "" {
+			// the default value of a key has no name
+			continue
+		}
+		if v, ok := registryValue(typ, data[:dataLen]); ok {
+			insertTree(tree, valueName, v)
+		}
+	}
+
+	keyBuf := make([]uint16, maxSubkeyLen+1)
+	for i := uint32(0); i < subkeys; i++ {
+		keyLen := uint32(len(keyBuf))
+		if err := syscall.RegEnumKeyEx(k, i, &keyBuf[0], &keyLen, nil, nil, nil, nil); err != nil {
+			if err == _errorNoMoreItems {
+				break
+			}
+			return err
+		}
+		child := map[string]interface{}{}
+		if err := readRegistryKey(k, syscall.UTF16ToString(keyBuf[:keyLen]), child); err != nil {
+			return err
+		}
+		insertTree(tree, syscall.UTF16ToString(keyBuf[:keyLen]), child)
+	}
+	return nil
+}
+
+// registryValue converts registry data to a configuration value. Binary and other values are
+// skipped.
+func registryValue(typ uint32, data []byte) (interface{}, bool) {
+	switch typ {
+	case syscall.REG_SZ, syscall.REG_EXPAND_SZ:
+		return syscall.UTF16ToString(bytesToUTF16(data)), true
+	case syscall.REG_MULTI_SZ:
+		ret := []interface{}{}
+		u := bytesToUTF16(data)
+		for len(u) > 0 && u[0] != 0 {
+			n := 0
+			for n < len(u) && u[n] != 0 {
+				n++
+			}
+			ret = append(ret, syscall.UTF16ToString(u[:n]))
+			if n == len(u) {
+				break
+			}
+			u = u[n+1:]
+		}
+		return ret, true
+	case syscall.REG_DWORD:
+		if len(data) < 4 {
+			return nil, false
+		}
+		return int(binary.LittleEndian.Uint32(data)), true
+	case syscall.REG_QWORD:
+		if len(data) < 8 {
+			return nil, false
+		}
+		return int64(binary.LittleEndian.Uint64(data)), true
+	}
+	return nil, false
+}
+
+func bytesToUTF16(b []byte) []uint16 {
+	u := make([]uint16, len(b)/2)
+	for i := range u {
+		u[i] = binary.LittleEndian.Uint16(b[2*i:])
+	}
+	return u
+}
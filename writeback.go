@@ -0,0 +1,218 @@
+package cfx
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// _backupTimeFormat is the timestamp in backup file names, sortable and free of characters
+// that are invalid in Windows file names.
+const _backupTimeFormat = "20060102T150405.000000000Z"
+
+// _defaultBackups is the number of backups kept unless WithBackups is given.
+const _defaultBackups = 5
+
+// ConfigBackup is a copy of a config file taken before it was overwritten.
+type ConfigBackup struct {
+	// Path is the path of the backup, <file>.<timestamp>.bak next to the original.
+	Path string `json:"path" yaml:"path"`
+
+	// Original is the path of the file the backup was taken of.
+	Original string `json:"original" yaml:"original"`
+
+	// Time is when the backup was taken.
+	Time time.Time `json:"time" yaml:"time"`
+
+	Size int64 `json:"size" yaml:"size"`
+}
+
+// WithBackups sets how many backups WriteBack keeps of each file it overwrites, five if not
+// given. Zero keeps none.
+func WithBackups(n int) Option {
+	return func(o *options) {
+		if n < 0 {
+			n = 0
+		}
+		o.backups = n
+	}
+}
+
+// WriteBack sets key to value in the config file of c's environment, the last file merged,
+// leaving the rest of the file as it was, so that tooling can change configuration on behalf
+// of operators. The file is replaced atomically after a timestamped backup of the previous
+// version is taken; see WriteConfigFile. The running configuration only changes once c is
+// reloaded, by hot reload or a call to Reload. Comments in the file are not preserved, and files
+// using local tags such as !expr, or YAML aliases, are refused, as rewriting them would turn
+// expressions into plain strings and inline the aliased values.
+func WriteBack(c Container, key string, value interface{}) error {
+	y, ok := c.(*yamlContainer)
+	if !ok {
+		return errors.New("write back needs a container loaded from config files")
+	}
+	if y.opts.replayPath != "" || y.opts.socketPath != "" || y.opts.configClient != nil || y.opts.gitSource != nil || y.opts.bundlePath != "" {
+		return errors.New("write back needs a container loaded from config files")
+	}
+	if key == "" {
+		return errors.New("write back needs a key")
+	}
+
+	paths, err := discoverConfigFiles(y.env, y.opts.naming)
+	if err != nil {
+		return err
+	}
+	if len(paths) == 0 {
+		return ErrNoConfigsLoaded
+	}
+	path := paths[len(paths)-1]
+	if _, fn := compressionExt(path); fn != nil {
+		return fmt.Errorf("cannot write back to compressed config file %s", path)
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("could not read config file %s: %v", path, err)
+	}
+	if feature := unrewritableYAML(data); feature != "" {
+		return fmt.Errorf("cannot write back to config file %s: it uses %s, which would be lost by rewriting it", path, feature)
+	}
+	var raw interface{}
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return fmt.Errorf("could not parse config file %s: %v", path, err)
+	}
+	tree, _ := normalizeValue(raw).(map[string]interface{})
+	if tree == nil {
+		tree = map[string]interface{}{}
+	}
+	insertTree(tree, key, value)
+
+	out, err := yaml.Marshal(tree)
+	if err != nil {
+		return fmt.Errorf("could not encode config file %s: %v", path, err)
+	}
+	return writeBackFile(y.opts, path, out)
+}
+
+// _yamlLocalTag matches local tags such as !expr outside of quoted scalars, closely enough to
+// refuse rewriting files that use them. yaml.v2 drops local tags when decoding.
+var _yamlLocalTag = regexp.MustCompile(`(?m)(?:^|[\s\[{,:-])![^!\s,\[\]{}][^\s,\[\]{}]*`)
+
+// unrewritableYAML returns the YAML feature of data that decoding and encoding it again would
+// lose, or "" if there is none.
+func unrewritableYAML(data []byte) string {
+	if bytes.IndexByte(data, '!') >= 0 && _yamlLocalTag.Match(data) {
+		return "local tags such as " + ExprTag
+	}
+	if bytes.IndexByte(data, '*') >= 0 && _yamlAlias.Match(data) {
+		return "aliases"
+	}
+	return ""
+}
+
+// WriteConfigFile replaces the file at path with data without ever leaving a partial file
+// behind: data is written to a temporary file, synced and renamed over path. The previous
+// version is first copied to a timestamped backup next to it, and only the newest keep
+// backups are kept. Writers on the same host are serialized with a lock on path.lock.
+func WriteConfigFile(path string, data []byte, keep int) error {
+	opts := defaultOptions()
+	opts.backups = 0
+	if keep > 0 {
+		opts.backups = keep
+	}
+	return writeBackFile(opts, path, data)
+}
+
+// ListBackups returns the backups of the file at path, newest first.
+func ListBackups(path string) ([]ConfigBackup, error) {
+	dir, base := filepath.Split(path)
+	if dir == "" {
+		dir = "."
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("could not list backups of %s: %v", path, err)
+	}
+
+	prefix := base + "."
+	var ret []ConfigBackup
+	for _, f := range files {
+		name := f.Name()
+		if f.IsDir() || !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, ".bak") {
+			continue
+		}
+		t, err := time.Parse(_backupTimeFormat, strings.TrimSuffix(strings.TrimPrefix(name, prefix), ".bak"))
+		if err != nil {
+			continue
+		}
+		ret = append(ret, ConfigBackup{Path: filepath.Join(dir, name), Original: path, Time: t, Size: f.Size()})
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Time.After(ret[j].Time) })
+	return ret, nil
+}
+
+// RestoreBackup replaces the file the backup was taken of with the backup, the same way
+// WriteConfigFile does, so the version being replaced is itself backed up first.
+func RestoreBackup(b ConfigBackup, keep int) error {
+	data, err := ioutil.ReadFile(b.Path)
+	if err != nil {
+		return fmt.Errorf("could not read backup %s: %v", b.Path, err)
+	}
+	return WriteConfigFile(b.Original, data, keep)
+}
+
+// writeBackFile backs up the file at path and atomically replaces it with data.
+func writeBackFile(opts *options, path string, data []byte) error {
+	if err := checkWriteAllowed(opts, "writing back configuration to", path); err != nil {
+		return err
+	}
+
+	lock, err := LockFile(context.Background(), path+".lock", opts.fileLock)
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
+	perm := os.FileMode(0644)
+	if prev, err := ioutil.ReadFile(path); err == nil {
+		if fi, err := os.Stat(path); err == nil {
+			perm = fi.Mode().Perm()
+		}
+		if opts.backups > 0 {
+			backup := path + "." + opts.clock().UTC().Format(_backupTimeFormat) + ".bak"
+			if err := writeFileAtomic(backup, prev, perm); err != nil {
+				return fmt.Errorf("could not back up %s: %v", path, err)
+			}
+		}
+	} else if !os.IsNotExist(err) {
+		return fmt.Errorf("could not read %s: %v", path, err)
+	}
+
+	if err := writeFileAtomic(path, data, perm); err != nil {
+		return err
+	}
+	return pruneBackups(path, opts.backups)
+}
+
+// pruneBackups removes all but the newest keep backups of path.
+func pruneBackups(path string, keep int) error {
+	backups, err := ListBackups(path)
+	if err != nil {
+		return err
+	}
+	for i := keep; i < len(backups); i++ {
+		if err := os.Remove(backups[i].Path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("could not remove old backup %s: %v", backups[i].Path, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package cfx
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWriteBack(t *testing.T) {
+	dir := testDir(t)
+	writeTestConfig(t, dir, "production.yaml", "server:\n  port: 8080\n")
+
+	c, err := NewConfigWithOptions(EnvContext{ConfigPath: dir, Environment: "production"}, WithBackups(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteBack(c, "server.host", "0.0.0.0"); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, "production.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "host: 0.0.0.0") || !strings.Contains(string(data), "port: 8080") {
+		t.Errorf("written file:\n%s", data)
+	}
+
+	backups, err := ListBackups(filepath.Join(dir, "production.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 1 {
+		t.Fatalf("%d backups, want 1", len(backups))
+	}
+	prev, _ := ioutil.ReadFile(backups[0].Path)
+	if string(prev) != "server:\n  port: 8080\n" {
+		t.Errorf("backup holds:\n%s", prev)
+	}
+}
+
+func TestWriteBackRefusesLossyRewrites(t *testing.T) {
+	tests := map[string]string{
+		"expr":  "workers: !expr \"cpus * 4\"\n",
+		"alias": "defaults: &defaults\n  timeout: 5s\nclient:\n  <<: *defaults\n",
+	}
+	for name, content := range tests {
+		t.Run(name, func(t *testing.T) {
+			dir := testDir(t)
+			writeTestConfig(t, dir, "production.yaml", content)
+
+			c, err := NewConfigWithOptions(EnvContext{ConfigPath: dir, Environment: "production"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := WriteBack(c, "other", 1); err == nil {
+				t.Fatal("write back succeeded")
+			}
+			data, _ := ioutil.ReadFile(filepath.Join(dir, "production.yaml"))
+			if string(data) != content {
+				t.Errorf("file was changed to:\n%s", data)
+			}
+		})
+	}
+}
+
+func TestUnrewritableYAML(t *testing.T) {
+	tests := map[string]bool{
+		"a: 1\n":                     false,
+		"a: \"hello!\"\n":            false,
+		"a: !!str 1\n":               false,
+		"a: !expr 1 + 1\n":           true,
+		"a: [1, *x]\n":               true,
+		"a: 2 * 3\n":                 false,
+		"list:\n  - !custom value\n": true,
+	}
+	for in, want := range tests {
+		if got := unrewritableYAML([]byte(in)) != ""; got != want {
+			t.Errorf("unrewritableYAML(%q) = %v, want %v", in, got, want)
+		}
+	}
+}